@@ -0,0 +1,106 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The scaffold command generates a new plugin in the extensions directory:
+//
+//	go run ./cmd/scaffold -name my-plugin -phases request-headers,response-headers
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/scaffold"
+)
+
+const wasmGoModule = "github.com/alibaba/higress/plugins/wasm-go"
+
+func main() {
+	name := flag.String("name", "", "the name of the plugin in kebab case, e.g. my-plugin")
+	dir := flag.String("dir", "", "the directory of the plugin, extensions/{name} of the wasm-go module by default")
+	phases := flag.String("phases", strings.Join(scaffold.DefaultPhases, ","),
+		"the phases processed by the plugin, separated by commas: "+strings.Join(scaffold.Phases, ", "))
+	force := flag.Bool("force", false, "overwrite the existing files")
+	flag.Parse()
+
+	root, err := findWasmGoRoot()
+	if err != nil {
+		fail(err)
+	}
+	if *dir == "" {
+		*dir = filepath.Join(root, "extensions", *name)
+	}
+	var phaseList []string
+	for _, phase := range strings.Split(*phases, ",") {
+		if phase = strings.TrimSpace(phase); phase != "" {
+			phaseList = append(phaseList, phase)
+		}
+	}
+	files, err := scaffold.Generate(scaffold.Options{
+		Name:       *name,
+		Dir:        *dir,
+		WasmGoRoot: root,
+		Phases:     phaseList,
+		Force:      *force,
+	})
+	if err != nil {
+		fail(err)
+	}
+	for _, file := range files {
+		fmt.Println("created", file)
+	}
+	fmt.Printf("\nnext steps:\n  cd %s\n  go mod tidy\n  make test\n  make build\n", *dir)
+}
+
+// findWasmGoRoot looks for the go.mod of the wasm-go module from the working directory upwards.
+func findWasmGoRoot() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		if isWasmGoRoot(dir) {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("%s is not found, run the command in the wasm-go module", wasmGoModule)
+		}
+		dir = parent
+	}
+}
+
+func isWasmGoRoot(dir string) bool {
+	file, err := os.Open(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) == 2 && fields[0] == "module" {
+			return fields[1] == wasmGoModule
+		}
+	}
+	return false
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	os.Exit(1)
+}
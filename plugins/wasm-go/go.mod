@@ -3,6 +3,7 @@ module github.com/alibaba/higress/plugins/wasm-go
 go 1.19
 
 require (
+	github.com/emmansun/gmsm v0.15.5
 	github.com/google/uuid v1.3.0
 	github.com/higress-group/proxy-wasm-go-sdk v1.0.0
 	github.com/stretchr/testify v1.8.4
@@ -17,5 +18,7 @@ require (
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.0 // indirect
+	golang.org/x/crypto v0.4.0 // indirect
+	golang.org/x/sys v0.3.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,215 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cryptoutil
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// The vectors come from the appendix of GB/T 32905-2016.
+func TestSM3(t *testing.T) {
+	sum := SM3Sum([]byte("abc"))
+	assert.Equal(t, "66c7f0f462eeedd9d1f2d46bdc10e4e24167c4875cf2f7a2297da02b8f4ba8e0", hex.EncodeToString(sum[:]))
+	sum = SM3Sum([]byte(strings.Repeat("abcd", 16)))
+	assert.Equal(t, "debe9ff92275b8a138604889c18e5a4d6fdb70e5387e5765293dcba39c0c5732", hex.EncodeToString(sum[:]))
+
+	h := NewSM3()
+	h.Write([]byte("ab"))
+	h.Write([]byte("c"))
+	assert.Equal(t, "66c7f0f462eeedd9d1f2d46bdc10e4e24167c4875cf2f7a2297da02b8f4ba8e0", hex.EncodeToString(h.Sum(nil)))
+}
+
+// the S-box table of the standard
+var sm4Sbox = [256]byte{
+	0xd6, 0x90, 0xe9, 0xfe, 0xcc, 0xe1, 0x3d, 0xb7, 0x16, 0xb6, 0x14, 0xc2, 0x28, 0xfb, 0x2c, 0x05,
+	0x2b, 0x67, 0x9a, 0x76, 0x2a, 0xbe, 0x04, 0xc3, 0xaa, 0x44, 0x13, 0x26, 0x49, 0x86, 0x06, 0x99,
+	0x9c, 0x42, 0x50, 0xf4, 0x91, 0xef, 0x98, 0x7a, 0x33, 0x54, 0x0b, 0x43, 0xed, 0xcf, 0xac, 0x62,
+	0xe4, 0xb3, 0x1c, 0xa9, 0xc9, 0x08, 0xe8, 0x95, 0x80, 0xdf, 0x94, 0xfa, 0x75, 0x8f, 0x3f, 0xa6,
+	0x47, 0x07, 0xa7, 0xfc, 0xf3, 0x73, 0x17, 0xba, 0x83, 0x59, 0x3c, 0x19, 0xe6, 0x85, 0x4f, 0xa8,
+	0x68, 0x6b, 0x81, 0xb2, 0x71, 0x64, 0xda, 0x8b, 0xf8, 0xeb, 0x0f, 0x4b, 0x70, 0x56, 0x9d, 0x35,
+	0x1e, 0x24, 0x0e, 0x5e, 0x63, 0x58, 0xd1, 0xa2, 0x25, 0x22, 0x7c, 0x3b, 0x01, 0x21, 0x78, 0x87,
+	0xd4, 0x00, 0x46, 0x57, 0x9f, 0xd3, 0x27, 0x52, 0x4c, 0x36, 0x02, 0xe7, 0xa0, 0xc4, 0xc8, 0x9e,
+	0xea, 0xbf, 0x8a, 0xd2, 0x40, 0xc7, 0x38, 0xb5, 0xa3, 0xf7, 0xf2, 0xce, 0xf9, 0x61, 0x15, 0xa1,
+	0xe0, 0xae, 0x5d, 0xa4, 0x9b, 0x34, 0x1a, 0x55, 0xad, 0x93, 0x32, 0x30, 0xf5, 0x8c, 0xb1, 0xe3,
+	0x1d, 0xf6, 0xe2, 0x2e, 0x82, 0x66, 0xca, 0x60, 0xc0, 0x29, 0x23, 0xab, 0x0d, 0x53, 0x4e, 0x6f,
+	0xd5, 0xdb, 0x37, 0x45, 0xde, 0xfd, 0x8e, 0x2f, 0x03, 0xff, 0x6a, 0x72, 0x6d, 0x6c, 0x5b, 0x51,
+	0x8d, 0x1b, 0xaf, 0x92, 0xbb, 0xdd, 0xbc, 0x7f, 0x11, 0xd9, 0x5c, 0x41, 0x1f, 0x10, 0x5a, 0xd8,
+	0x0a, 0xc1, 0x31, 0x88, 0xa5, 0xcd, 0x7b, 0xbd, 0x2d, 0x74, 0xd0, 0x12, 0xb8, 0xe5, 0xb4, 0xb0,
+	0x89, 0x69, 0x97, 0x4a, 0x0c, 0x96, 0x77, 0x7e, 0x65, 0xb9, 0xf1, 0x09, 0xc5, 0x6e, 0xc6, 0x84,
+	0x18, 0xf0, 0x7d, 0xec, 0x3a, 0xdc, 0x4d, 0x20, 0x79, 0xee, 0x5f, 0x3e, 0xd7, 0xcb, 0x39, 0x48,
+}
+
+func TestSM4Sbox(t *testing.T) {
+	for x := 0; x < 256; x++ {
+		a := uint32(x)<<24 | uint32(255-x)<<16 | uint32(x^0x5a)<<8 | uint32(x*7&0xff)
+		expected := uint32(sm4Sbox[x])<<24 | uint32(sm4Sbox[255-x])<<16 | uint32(sm4Sbox[x^0x5a])<<8 | uint32(sm4Sbox[x*7&0xff])
+		assert.Equal(t, expected, sm4Tau(a))
+	}
+}
+
+// The vector comes from the appendix of GB/T 32907-2016.
+func TestSM4(t *testing.T) {
+	key := mustHex("0123456789abcdeffedcba9876543210")
+	block, err := NewSM4(key)
+	require.NoError(t, err)
+	out := make([]byte, 16)
+	block.Encrypt(out, key)
+	assert.Equal(t, "681edf34d206965e86b3e94f536e4246", hex.EncodeToString(out))
+	block.Decrypt(out, out)
+	assert.Equal(t, key, out)
+
+	ciphertext, err := SM4CBCEncrypt(key, nil, []byte("hello sm4"))
+	require.NoError(t, err)
+	plaintext, err := SM4CBCDecrypt(key, ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, "hello sm4", string(plaintext))
+
+	ciphertext, err = SM4GCMEncrypt(key, []byte("hello sm4"), []byte("aad"))
+	require.NoError(t, err)
+	plaintext, err = SM4GCMDecrypt(key, ciphertext, []byte("aad"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello sm4", string(plaintext))
+	_, err = SM4GCMDecrypt(key, ciphertext, []byte("other"))
+	assert.Error(t, err)
+
+	_, err = NewSM4(key[:8])
+	assert.Error(t, err)
+}
+
+func TestAESGCM(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	ciphertext, err := AESGCMEncrypt(key, []byte("secret"), nil)
+	require.NoError(t, err)
+	plaintext, err := AESGCMDecrypt(key, ciphertext, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(plaintext))
+
+	ciphertext[len(ciphertext)-1] ^= 1
+	_, err = AESGCMDecrypt(key, ciphertext, nil)
+	assert.Error(t, err)
+	_, err = AESGCMDecrypt(key, ciphertext[:10], nil)
+	assert.ErrorIs(t, err, ErrCiphertextTooShort)
+}
+
+func TestPKCS7(t *testing.T) {
+	padded := PKCS7Pad([]byte("0123456789abcdef"), 16)
+	assert.Len(t, padded, 32)
+	data, err := PKCS7Unpad(padded, 16)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789abcdef", string(data))
+	_, err = PKCS7Unpad([]byte("0123456789abcde\x00"), 16)
+	assert.ErrorIs(t, err, ErrInvalidPadding)
+}
+
+func TestSM2(t *testing.T) {
+	curve := SM2Curve()
+	assert.True(t, curve.IsOnCurve(curve.Params().Gx, curve.Params().Gy))
+
+	priv, err := GenerateSM2Key(nil)
+	require.NoError(t, err)
+	pub, err := ParseSM2PublicKey(priv.Bytes())
+	require.NoError(t, err)
+
+	msg := []byte("message digest")
+	signature, err := priv.Sign(nil, nil, msg)
+	require.NoError(t, err)
+	assert.True(t, pub.Verify(nil, msg, signature))
+	assert.False(t, pub.Verify(nil, []byte("other"), signature))
+	assert.False(t, pub.Verify([]byte("other uid"), msg, signature))
+
+	ciphertext, err := pub.Encrypt(nil, []byte("encryption standard"))
+	require.NoError(t, err)
+	assert.Len(t, ciphertext, 65+32+len("encryption standard"))
+	plaintext, err := priv.Decrypt(ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, "encryption standard", string(plaintext))
+
+	ciphertext[len(ciphertext)-1] ^= 1
+	_, err = priv.Decrypt(ciphertext)
+	assert.ErrorIs(t, err, ErrSM2InvalidCiphertext)
+
+	_, err = ParseSM2PublicKey(make([]byte, 64))
+	assert.ErrorIs(t, err, ErrSM2InvalidPublicKey)
+}
+
+// The sample of the recommended curve in GM/T 0003.5-2012, which GB/T 32918-2016 and 32918.5-2017 are based on
+func TestSM2KnownAnswer(t *testing.T) {
+	priv, err := ParseSM2PrivateKeyHex("3945208F7B2144B13F36E38AC6D39F95889393692860B51A42FB81EF4DF7C5B8")
+	require.NoError(t, err)
+	assert.Equal(t, "04"+
+		"09f9df311e5421a150dd7d161e4bc5c672179fad1833fc076bb08ff356f35020"+
+		"ccea490ce26775a52dc6ea718cc1aa600aed05fbf35e084a6632f6072da9ad13", hex.EncodeToString(priv.Bytes()))
+
+	msg := []byte("message digest")
+	assert.Equal(t, "b2e14c5c79c6df5b85f4fe7ed8db7a262b9da7e07ccb0ea9f4747b8ccda8a4f3", hex.EncodeToString(priv.ZA(SM2DefaultUID)))
+	assert.Equal(t, "f0b43e94ba45accaace692ed534382eb17e6ab5a19ce7b31f4486fdfc0d28640", hex.EncodeToString(priv.digest(nil, msg)))
+	r := new(big.Int).SetBytes(mustHex("F5A03B0648D2C4630EEAC513E1BB81A15944DA3827D5B74143AC7EACEEE720B3"))
+	s := new(big.Int).SetBytes(mustHex("B1B6AA29DF212FD8763182BC0D421CA1BB9038FD1F7F42D4840B69C485BBC1AA"))
+	assert.True(t, priv.VerifyRS(nil, msg, r, s))
+	assert.False(t, priv.VerifyRS(nil, msg, s, r))
+
+	ciphertext := mustHex("04" +
+		"04ebfc718e8d1798620432268e77feb6415e2ede0e073c0f4f640ecd2e149a73" +
+		"e858f9d81e5430a57b36daab8f950a3c64e6ee6a63094d99283aff767e124df0" +
+		"59983c18f809e262923c53aec295d30383b54e39d609d160afcb1908d0bd8766" +
+		"21886ca989ca9c7d58087307ca93092d651efa")
+	plaintext, err := priv.Decrypt(ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, "encryption standard", string(plaintext))
+}
+
+func TestRSA(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	privPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	pubDER, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	require.NoError(t, err)
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+
+	parsedPriv, err := ParseRSAPrivateKeyPEM(privPEM)
+	require.NoError(t, err)
+	parsedPub, err := ParseRSAPublicKeyPEM(pubPEM)
+	require.NoError(t, err)
+
+	ciphertext, err := RSAEncryptOAEP(parsedPub, []byte("secret"), nil)
+	require.NoError(t, err)
+	plaintext, err := RSADecryptOAEP(parsedPriv, ciphertext, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(plaintext))
+
+	signature, err := RSASignPKCS1v15(parsedPriv, []byte("msg"))
+	require.NoError(t, err)
+	assert.NoError(t, RSAVerifyPKCS1v15(parsedPub, []byte("msg"), signature))
+	assert.Error(t, RSAVerifyPKCS1v15(parsedPub, []byte("other"), signature))
+}
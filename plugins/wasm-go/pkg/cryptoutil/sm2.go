@@ -0,0 +1,212 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// SM2 public key cryptographic algorithm based on elliptic curves: GB/T 32918-2016
+//
+// The operations on the private key and the ephemeral scalars run on the constant-time curve of
+// github.com/emmansun/gmsm, the math/big arithmetic of elliptic.CurveParams would leak them by timing.
+
+package cryptoutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/big"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+// SM2DefaultUID is the default user id used to compute Z_A when signing.
+var SM2DefaultUID = []byte("1234567812345678")
+
+var (
+	ErrSM2InvalidSignature  = errors.New("invalid sm2 signature")
+	ErrSM2InvalidCiphertext = errors.New("invalid sm2 ciphertext")
+	ErrSM2InvalidPublicKey  = errors.New("invalid sm2 public key")
+)
+
+// SM2Curve returns the recommended SM2 curve, its arithmetic is constant-time.
+func SM2Curve() elliptic.Curve {
+	return sm2.P256()
+}
+
+type SM2PublicKey struct {
+	X, Y *big.Int
+}
+
+type SM2PrivateKey struct {
+	SM2PublicKey
+	D *big.Int
+}
+
+// GenerateSM2Key generates a key pair, the private key d is in [1, n-2] as required by the standard.
+func GenerateSM2Key(random io.Reader) (*SM2PrivateKey, error) {
+	if random == nil {
+		random = rand.Reader
+	}
+	key, err := sm2.GenerateKey(random)
+	if err != nil {
+		return nil, err
+	}
+	return &SM2PrivateKey{SM2PublicKey: SM2PublicKey{X: key.X, Y: key.Y}, D: key.D}, nil
+}
+
+// NewSM2PrivateKey creates the key from the big-endian private scalar.
+func NewSM2PrivateKey(d []byte) (*SM2PrivateKey, error) {
+	curve := SM2Curve()
+	k := new(big.Int).SetBytes(d)
+	if k.Sign() <= 0 || k.Cmp(new(big.Int).Sub(curve.Params().N, big.NewInt(1))) >= 0 {
+		return nil, errors.New("invalid sm2 private key")
+	}
+	var scalar [32]byte
+	x, y := curve.ScalarBaseMult(k.FillBytes(scalar[:]))
+	return &SM2PrivateKey{SM2PublicKey: SM2PublicKey{X: x, Y: y}, D: k}, nil
+}
+
+// ParseSM2PrivateKeyHex parses the hex encoded private scalar.
+func ParseSM2PrivateKeyHex(s string) (*SM2PrivateKey, error) {
+	d, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return NewSM2PrivateKey(d)
+}
+
+// ParseSM2PublicKey parses the uncompressed point 04 || x || y, the 04 prefix is optional.
+func ParseSM2PublicKey(data []byte) (*SM2PublicKey, error) {
+	if len(data) == 65 && data[0] == 4 {
+		data = data[1:]
+	}
+	if len(data) != 64 {
+		return nil, ErrSM2InvalidPublicKey
+	}
+	pub := &SM2PublicKey{X: new(big.Int).SetBytes(data[:32]), Y: new(big.Int).SetBytes(data[32:])}
+	if !SM2Curve().IsOnCurve(pub.X, pub.Y) {
+		return nil, ErrSM2InvalidPublicKey
+	}
+	return pub, nil
+}
+
+// Bytes returns the uncompressed point 04 || x || y.
+func (pub *SM2PublicKey) Bytes() []byte {
+	out := make([]byte, 65)
+	out[0] = 4
+	pub.X.FillBytes(out[1:33])
+	pub.Y.FillBytes(out[33:])
+	return out
+}
+
+func (pub *SM2PublicKey) ecdsa() *ecdsa.PublicKey {
+	return &ecdsa.PublicKey{Curve: SM2Curve(), X: pub.X, Y: pub.Y}
+}
+
+func (priv *SM2PrivateKey) gmsm() *sm2.PrivateKey {
+	return &sm2.PrivateKey{PrivateKey: ecdsa.PrivateKey{PublicKey: *priv.ecdsa(), D: priv.D}}
+}
+
+// ZA computes SM3(ENTL || ID || a || b || xG || yG || xA || yA).
+func (pub *SM2PublicKey) ZA(uid []byte) []byte {
+	params := SM2Curve().Params()
+	a := new(big.Int).Sub(params.P, big.NewInt(3))
+	h := NewSM3()
+	var entl [2]byte
+	binary.BigEndian.PutUint16(entl[:], uint16(len(uid)*8))
+	h.Write(entl[:])
+	h.Write(uid)
+	for _, n := range []*big.Int{a, params.B, params.Gx, params.Gy, pub.X, pub.Y} {
+		var buf [32]byte
+		n.FillBytes(buf[:])
+		h.Write(buf[:])
+	}
+	return h.Sum(nil)
+}
+
+// digest returns e = SM3(Z_A || msg).
+func (pub *SM2PublicKey) digest(uid, msg []byte) []byte {
+	if uid == nil {
+		uid = SM2DefaultUID
+	}
+	h := NewSM3()
+	h.Write(pub.ZA(uid))
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+type sm2Signature struct {
+	R, S *big.Int
+}
+
+// Sign signs SM3(Z_A || msg) and returns the ASN.1 DER encoded signature. The default uid is used if uid is nil.
+func (priv *SM2PrivateKey) Sign(random io.Reader, uid, msg []byte) ([]byte, error) {
+	if random == nil {
+		random = rand.Reader
+	}
+	return sm2.SignASN1(random, priv.gmsm(), priv.digest(uid, msg), nil)
+}
+
+// SignRS returns the raw r and s of the signature.
+func (priv *SM2PrivateKey) SignRS(random io.Reader, uid, msg []byte) (*big.Int, *big.Int, error) {
+	signature, err := priv.Sign(random, uid, msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sig sm2Signature
+	if _, err = asn1.Unmarshal(signature, &sig); err != nil {
+		return nil, nil, err
+	}
+	return sig.R, sig.S, nil
+}
+
+// Verify checks the ASN.1 DER encoded signature of msg.
+func (pub *SM2PublicKey) Verify(uid, msg, signature []byte) bool {
+	var sig sm2Signature
+	rest, err := asn1.Unmarshal(signature, &sig)
+	if err != nil || len(rest) > 0 {
+		return false
+	}
+	return pub.VerifyRS(uid, msg, sig.R, sig.S)
+}
+
+func (pub *SM2PublicKey) VerifyRS(uid, msg []byte, r, s *big.Int) bool {
+	if r == nil || s == nil {
+		return false
+	}
+	return sm2.Verify(pub.ecdsa(), pub.digest(uid, msg), r, s)
+}
+
+// Encrypt encrypts msg and returns C1 || C3 || C2, with C1 the uncompressed point.
+func (pub *SM2PublicKey) Encrypt(random io.Reader, msg []byte) ([]byte, error) {
+	if random == nil {
+		random = rand.Reader
+	}
+	return sm2.Encrypt(random, pub.ecdsa(), msg, sm2.NewPlainEncrypterOpts(sm2.MarshalUncompressed, sm2.C1C3C2))
+}
+
+// Decrypt decrypts C1 || C3 || C2.
+func (priv *SM2PrivateKey) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 1 || ciphertext[0] != 4 {
+		return nil, ErrSM2InvalidCiphertext
+	}
+	msg, err := sm2.Decrypt(priv.gmsm(), ciphertext)
+	if err != nil {
+		return nil, ErrSM2InvalidCiphertext
+	}
+	return msg, nil
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// SM3 cryptographic hash algorithm: GB/T 32905-2016, provided by gmsm
+
+package cryptoutil
+
+import (
+	"hash"
+
+	"github.com/emmansun/gmsm/sm3"
+)
+
+const (
+	SM3Size      = sm3.Size
+	SM3BlockSize = sm3.BlockSize
+)
+
+// NewSM3 returns a hash.Hash computing the SM3 checksum.
+func NewSM3() hash.Hash {
+	return sm3.New()
+}
+
+// SM3Sum returns the SM3 checksum of the data.
+func SM3Sum(data []byte) [SM3Size]byte {
+	return sm3.Sum(data)
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// SM4 block cipher algorithm: GB/T 32907-2016
+//
+// The S-box is computed instead of being looked up, the lookups indexed by the key and the data would leak them
+// through the cache timing. The SM4 of gmsm is not used for the same reason, its generic code for wasm uses tables.
+
+package cryptoutil
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+const SM4BlockSize = 16
+
+var sm4FK = [4]uint32{0xa3b1bac6, 0x56aa3350, 0x677d9197, 0xb27022dc}
+
+type sm4Cipher struct {
+	enc [32]uint32
+	dec [32]uint32
+}
+
+// NewSM4 creates a cipher.Block with the 16 bytes key, use it with cipher.NewGCM or CBCEncrypt.
+func NewSM4(key []byte) (cipher.Block, error) {
+	if len(key) != SM4BlockSize {
+		return nil, fmt.Errorf("invalid sm4 key size %d", len(key))
+	}
+	c := &sm4Cipher{}
+	var k [36]uint32
+	for i := 0; i < 4; i++ {
+		k[i] = binary.BigEndian.Uint32(key[i*4:]) ^ sm4FK[i]
+	}
+	for i := 0; i < 32; i++ {
+		// CK[i] consists of the bytes (4i+j)*7 mod 256
+		var ck uint32
+		for j := 0; j < 4; j++ {
+			ck = ck<<8 | uint32(byte((4*i+j)*7))
+		}
+		b := sm4Tau(k[i+1] ^ k[i+2] ^ k[i+3] ^ ck)
+		k[i+4] = k[i] ^ b ^ bits.RotateLeft32(b, 13) ^ bits.RotateLeft32(b, 23)
+		c.enc[i] = k[i+4]
+		c.dec[31-i] = k[i+4]
+	}
+	return c, nil
+}
+
+// sm4Tau applies the S-box to each byte of a. The S-box is A(inv(A(x))) in GF(2^8) modulo
+// x^8+x^7+x^6+x^5+x^4+x^2+1, where A is the affine transform of sm4Affine, and the 4 bytes are computed at once.
+func sm4Tau(a uint32) uint32 {
+	return sm4Affine(sm4Inv(sm4Affine(a)))
+}
+
+const sm4Lanes = 0x01010101
+
+// sm4Affine computes 0xd3 ^ x ^ (x <<< 1) ^ (x <<< 3) ^ (x <<< 6) ^ (x <<< 7) for each byte x of a.
+func sm4Affine(a uint32) uint32 {
+	return 0xd3d3d3d3 ^ a ^ sm4Rotl(a, 1) ^ sm4Rotl(a, 3) ^ sm4Rotl(a, 6) ^ sm4Rotl(a, 7)
+}
+
+// sm4Rotl rotates each byte of a left by k bits.
+func sm4Rotl(a uint32, k uint) uint32 {
+	low := (0xff >> k) * uint32(sm4Lanes)
+	return (a&low)<<k | (a&^low)>>(8-k)
+}
+
+// sm4Mul multiplies each byte of a by the byte of b at the same position, without branches on them.
+func sm4Mul(a, b uint32) uint32 {
+	var r uint32
+	for i := 0; i < 8; i++ {
+		r ^= a & (b >> i & sm4Lanes * 0xff)
+		a = (a&0x7f7f7f7f)<<1 ^ (a>>7&sm4Lanes)*0xf5
+	}
+	return r
+}
+
+// sm4Inv computes x^254 for each byte x of a, which is the inverse of x, or 0 for 0.
+func sm4Inv(a uint32) uint32 {
+	// x^(2^k-1) up to x^127
+	r := a
+	for i := 0; i < 6; i++ {
+		r = sm4Mul(sm4Mul(r, r), a)
+	}
+	return sm4Mul(r, r)
+}
+
+func sm4T(a uint32) uint32 {
+	b := sm4Tau(a)
+	return b ^ bits.RotateLeft32(b, 2) ^ bits.RotateLeft32(b, 10) ^ bits.RotateLeft32(b, 18) ^ bits.RotateLeft32(b, 24)
+}
+
+func (c *sm4Cipher) BlockSize() int { return SM4BlockSize }
+
+func (c *sm4Cipher) Encrypt(dst, src []byte) { sm4Crypt(&c.enc, dst, src) }
+
+func (c *sm4Cipher) Decrypt(dst, src []byte) { sm4Crypt(&c.dec, dst, src) }
+
+func sm4Crypt(rk *[32]uint32, dst, src []byte) {
+	if len(src) < SM4BlockSize || len(dst) < SM4BlockSize {
+		panic("cryptoutil: sm4 input not full block")
+	}
+	x0 := binary.BigEndian.Uint32(src[0:])
+	x1 := binary.BigEndian.Uint32(src[4:])
+	x2 := binary.BigEndian.Uint32(src[8:])
+	x3 := binary.BigEndian.Uint32(src[12:])
+	for i := 0; i < 32; i++ {
+		x0, x1, x2, x3 = x1, x2, x3, x0^sm4T(x1^x2^x3^rk[i])
+	}
+	binary.BigEndian.PutUint32(dst[0:], x3)
+	binary.BigEndian.PutUint32(dst[4:], x2)
+	binary.BigEndian.PutUint32(dst[8:], x1)
+	binary.BigEndian.PutUint32(dst[12:], x0)
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cryptoutil
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+var (
+	ErrCiphertextTooShort = errors.New("ciphertext is too short")
+	ErrInvalidPadding     = errors.New("invalid padding")
+)
+
+// GCMEncrypt seals the plaintext with a random nonce, the output is nonce || ciphertext || tag.
+func GCMEncrypt(block cipher.Block, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// GCMDecrypt opens the output of GCMEncrypt.
+func GCMDecrypt(block cipher.Block, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrCiphertextTooShort
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, additionalData)
+}
+
+func PKCS7Pad(data []byte, blockSize int) []byte {
+	n := blockSize - len(data)%blockSize
+	return append(append([]byte{}, data...), bytes.Repeat([]byte{byte(n)}, n)...)
+}
+
+func PKCS7Unpad(data []byte, blockSize int) ([]byte, error) {
+	if len(data) == 0 || len(data)%blockSize != 0 {
+		return nil, ErrInvalidPadding
+	}
+	n := int(data[len(data)-1])
+	if n == 0 || n > blockSize {
+		return nil, ErrInvalidPadding
+	}
+	for _, b := range data[len(data)-n:] {
+		if int(b) != n {
+			return nil, ErrInvalidPadding
+		}
+	}
+	return data[:len(data)-n], nil
+}
+
+// CBCEncrypt encrypts with PKCS#7 padding, the output is iv || ciphertext. A random iv is used if iv is nil.
+// Prefer GCM unless the peer requires CBC, since CBC is not authenticated.
+func CBCEncrypt(block cipher.Block, iv, plaintext []byte) ([]byte, error) {
+	if iv == nil {
+		iv = make([]byte, block.BlockSize())
+		if _, err := rand.Read(iv); err != nil {
+			return nil, err
+		}
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, errors.New("iv length must equal block size")
+	}
+	padded := PKCS7Pad(plaintext, block.BlockSize())
+	out := make([]byte, len(iv)+len(padded))
+	copy(out, iv)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out[len(iv):], padded)
+	return out, nil
+}
+
+// CBCDecrypt decrypts the output of CBCEncrypt.
+func CBCDecrypt(block cipher.Block, ciphertext []byte) ([]byte, error) {
+	size := block.BlockSize()
+	if len(ciphertext) < 2*size || len(ciphertext)%size != 0 {
+		return nil, ErrCiphertextTooShort
+	}
+	out := make([]byte, len(ciphertext)-size)
+	cipher.NewCBCDecrypter(block, ciphertext[:size]).CryptBlocks(out, ciphertext[size:])
+	return PKCS7Unpad(out, size)
+}
+
+// AESGCMEncrypt encrypts with AES-128/192/256-GCM depending on the key size.
+func AESGCMEncrypt(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return GCMEncrypt(block, plaintext, additionalData)
+}
+
+func AESGCMDecrypt(key, ciphertext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return GCMDecrypt(block, ciphertext, additionalData)
+}
+
+func SM4GCMEncrypt(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := NewSM4(key)
+	if err != nil {
+		return nil, err
+	}
+	return GCMEncrypt(block, plaintext, additionalData)
+}
+
+func SM4GCMDecrypt(key, ciphertext, additionalData []byte) ([]byte, error) {
+	block, err := NewSM4(key)
+	if err != nil {
+		return nil, err
+	}
+	return GCMDecrypt(block, ciphertext, additionalData)
+}
+
+func SM4CBCEncrypt(key, iv, plaintext []byte) ([]byte, error) {
+	block, err := NewSM4(key)
+	if err != nil {
+		return nil, err
+	}
+	return CBCEncrypt(block, iv, plaintext)
+}
+
+func SM4CBCDecrypt(key, ciphertext []byte) ([]byte, error) {
+	block, err := NewSM4(key)
+	if err != nil {
+		return nil, err
+	}
+	return CBCDecrypt(block, ciphertext)
+}
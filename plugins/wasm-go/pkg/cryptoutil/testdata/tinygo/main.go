@@ -0,0 +1,49 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The program is built by make test-tinygo, so the package and gmsm are known to build with TinyGo.
+package main
+
+import (
+	"bytes"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/cryptoutil"
+)
+
+func main() {
+	msg := []byte("hello tinygo")
+	key, err := cryptoutil.GenerateSM2Key(nil)
+	if err != nil {
+		panic(err)
+	}
+	signature, err := key.Sign(nil, nil, msg)
+	if err != nil || !key.Verify(nil, msg, signature) {
+		panic("sm2 signature is not verified")
+	}
+	ciphertext, err := key.Encrypt(nil, msg)
+	if err != nil {
+		panic(err)
+	}
+	if plaintext, err := key.Decrypt(ciphertext); err != nil || !bytes.Equal(plaintext, msg) {
+		panic("sm2 ciphertext is not decrypted")
+	}
+	sum := cryptoutil.SM3Sum(msg)
+	ciphertext, err = cryptoutil.SM4GCMEncrypt(sum[:16], msg, nil)
+	if err != nil {
+		panic(err)
+	}
+	if plaintext, err := cryptoutil.SM4GCMDecrypt(sum[:16], ciphertext, nil); err != nil || !bytes.Equal(plaintext, msg) {
+		panic("sm4 ciphertext is not decrypted")
+	}
+}
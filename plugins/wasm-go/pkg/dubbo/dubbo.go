@@ -0,0 +1,524 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dubbo decodes and encodes the frames of the dubbo protocol with the hessian2 serialization, so the plugins
+// can inspect and mutate the invocations, e.g. the streaming body of a tunneled dubbo connection. The HSF services are
+// supported when they're exposed by the dubbo protocol, the HSF protocol isn't supported.
+//
+// Protocol: https://cn.dubbo.apache.org/en/overview/reference/protocols/tcp/
+package dubbo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	HeaderLength = 16
+	Magic        = 0xdabb
+
+	// The id of the hessian2 serialization, the others are not supported
+	SerializationHessian2 = 2
+
+	// The default max size of the body of a frame, as the payload limit of dubbo
+	DefaultMaxBodySize = 8 << 20
+)
+
+const (
+	flagRequest       = 0x80
+	flagTwoWay        = 0x40
+	flagEvent         = 0x20
+	serializationMask = 0x1f
+)
+
+// The status of the responses
+const (
+	StatusOK                        byte = 20
+	StatusClientTimeout             byte = 30
+	StatusServerTimeout             byte = 31
+	StatusBadRequest                byte = 40
+	StatusBadResponse               byte = 50
+	StatusServiceNotFound           byte = 60
+	StatusServiceError              byte = 70
+	StatusServerError               byte = 80
+	StatusClientError               byte = 90
+	StatusServerThreadpoolExhausted byte = 100
+)
+
+// The type of the result in the body of the responses
+const (
+	ResponseWithException int32 = iota
+	ResponseValue
+	ResponseNullValue
+	ResponseWithExceptionWithAttachments
+	ResponseValueWithAttachments
+	ResponseNullValueWithAttachments
+)
+
+// The well-known attachments
+const (
+	AttachmentPath      = "path"
+	AttachmentInterface = "interface"
+	AttachmentVersion   = "version"
+	AttachmentGroup     = "group"
+	AttachmentTimeout   = "timeout"
+)
+
+var (
+	ErrBadMagic                 = errors.New("dubbo: bad magic number")
+	ErrFrameTruncated           = errors.New("dubbo: frame is truncated")
+	ErrFrameTooLarge            = errors.New("dubbo: frame is too large")
+	ErrEventFrame               = errors.New("dubbo: frame is an event")
+	ErrUnsupportedSerialization = errors.New("dubbo: unsupported serialization")
+)
+
+// Header is the fixed 16 bytes header of the frames.
+type Header struct {
+	Request       bool
+	TwoWay        bool
+	Event         bool
+	Serialization byte
+	// Only for the responses
+	Status     byte
+	ID         int64
+	BodyLength int
+}
+
+// DecodeHeader decodes the header at the start of data.
+func DecodeHeader(data []byte) (Header, error) {
+	if len(data) < HeaderLength {
+		return Header{}, ErrFrameTruncated
+	}
+	if binary.BigEndian.Uint16(data) != Magic {
+		return Header{}, ErrBadMagic
+	}
+	flag := data[2]
+	length := binary.BigEndian.Uint32(data[12:])
+	if length > 1<<31-1 {
+		return Header{}, ErrFrameTooLarge
+	}
+	return Header{
+		Request:       flag&flagRequest != 0,
+		TwoWay:        flag&flagTwoWay != 0,
+		Event:         flag&flagEvent != 0,
+		Serialization: flag & serializationMask,
+		Status:        data[3],
+		ID:            int64(binary.BigEndian.Uint64(data[4:])),
+		BodyLength:    int(length),
+	}, nil
+}
+
+// Bytes encodes the header.
+func (h Header) Bytes() []byte {
+	flag := h.Serialization & serializationMask
+	if h.Request {
+		flag |= flagRequest
+	}
+	if h.TwoWay {
+		flag |= flagTwoWay
+	}
+	if h.Event {
+		flag |= flagEvent
+	}
+	data := make([]byte, 4, HeaderLength)
+	binary.BigEndian.PutUint16(data, Magic)
+	data[2] = flag
+	data[3] = h.Status
+	data = binary.BigEndian.AppendUint64(data, uint64(h.ID))
+	return binary.BigEndian.AppendUint32(data, uint32(h.BodyLength))
+}
+
+type Frame struct {
+	Header
+	Body []byte
+}
+
+// IsHeartbeat reports whether the frame is a heartbeat, whose body is null.
+func (f *Frame) IsHeartbeat() bool {
+	return f.Event && len(f.Body) == 1 && f.Body[0] == 'N'
+}
+
+// Bytes encodes the frame, the length of the body in the header is updated.
+func (f *Frame) Bytes() []byte {
+	f.BodyLength = len(f.Body)
+	return append(f.Header.Bytes(), f.Body...)
+}
+
+// Parser reassembles the frames from the chunks of a stream.
+type Parser struct {
+	maxBodySize int
+	pending     []byte
+}
+
+// NewParser returns a parser, the frames larger than maxBodySize are refused, DefaultMaxBodySize is used if it's not
+// positive.
+func NewParser(maxBodySize int) *Parser {
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+	return &Parser{maxBodySize: maxBodySize}
+}
+
+// Feed returns the frames completed by the chunk, the incomplete bytes are kept for the next chunk. The frames parsed
+// before an error are returned as well, the bytes from the bad frame are left in Pending.
+func (p *Parser) Feed(chunk []byte) ([]*Frame, error) {
+	p.pending = append(p.pending, chunk...)
+	var frames []*Frame
+	for len(p.pending) >= HeaderLength {
+		header, err := DecodeHeader(p.pending)
+		if err != nil {
+			return frames, err
+		}
+		if header.BodyLength > p.maxBodySize {
+			return frames, ErrFrameTooLarge
+		}
+		if len(p.pending) < HeaderLength+header.BodyLength {
+			break
+		}
+		body := make([]byte, header.BodyLength)
+		copy(body, p.pending[HeaderLength:])
+		frames = append(frames, &Frame{Header: header, Body: body})
+		p.pending = p.pending[HeaderLength+header.BodyLength:]
+	}
+	return frames, nil
+}
+
+// Pending returns the bytes not parsed into frames yet.
+func (p *Parser) Pending() []byte {
+	return p.pending
+}
+
+// Request is an invocation, the arguments are kept as they're received unless SetArguments is called.
+type Request struct {
+	ID           int64
+	TwoWay       bool
+	DubboVersion string
+	// The path of the service, which is the interface name unless it's exported with another path
+	Path    string
+	Version string
+	Method  string
+	// The descriptor of the parameter types, e.g. Ljava/lang/String;I
+	ParameterTypes string
+	Arguments      []interface{}
+	// Dubbo copies path, interface, version, group and timeout to the attachments as well
+	Attachments map[string]interface{}
+
+	rawArguments []byte
+	// the number of the classes defined in the raw arguments
+	argumentClasses int
+}
+
+// DecodeRequest decodes the invocation in the frame, ErrEventFrame is returned for the heartbeats and other events.
+func DecodeRequest(frame *Frame) (*Request, error) {
+	if !frame.Request {
+		return nil, errors.New("dubbo: frame is not a request")
+	}
+	if frame.Event {
+		return nil, ErrEventFrame
+	}
+	if frame.Serialization != SerializationHessian2 {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedSerialization, frame.Serialization)
+	}
+	d := NewDecoder(frame.Body)
+	r := &Request{ID: frame.ID, TwoWay: frame.TwoWay}
+	for _, field := range []*string{&r.DubboVersion, &r.Path, &r.Version, &r.Method, &r.ParameterTypes} {
+		value, err := d.DecodeString()
+		if err != nil {
+			return nil, err
+		}
+		*field = value
+	}
+	types, err := ParseParameterTypes(r.ParameterTypes)
+	if err != nil {
+		return nil, err
+	}
+	start := d.Offset()
+	for range types {
+		arg, err := d.Decode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode the arguments: %w", err)
+		}
+		r.Arguments = append(r.Arguments, arg)
+	}
+	r.rawArguments = frame.Body[start:d.Offset()]
+	r.argumentClasses = len(d.classes)
+	// the attachments are absent in the requests of the old versions
+	if r.Attachments, err = decodeAttachments(d); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func decodeAttachments(d *Decoder) (map[string]interface{}, error) {
+	attachments := map[string]interface{}{}
+	if !d.More() {
+		return attachments, nil
+	}
+	v, err := d.Decode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the attachments: %w", err)
+	}
+	if v == nil {
+		return attachments, nil
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: expect attachments, got %T", ErrHessianInvalid, v)
+	}
+	for key, value := range m {
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: attachment key %v is not a string", ErrHessianInvalid, key)
+		}
+		attachments[name] = value
+	}
+	return attachments, nil
+}
+
+// Interface returns the interface of the service, which is the interface attachment or the path.
+func (r *Request) Interface() string {
+	if name, ok := r.Attachments[AttachmentInterface].(string); ok && name != "" {
+		return name
+	}
+	return r.Path
+}
+
+// Group returns the group attachment.
+func (r *Request) Group() string {
+	return r.Attachment(AttachmentGroup)
+}
+
+// GenericMethod returns the target method of a generic invocation, or Method for the other invocations.
+func (r *Request) GenericMethod() string {
+	if r.Method == "$invoke" || r.Method == "$invokeAsync" {
+		if len(r.Arguments) > 0 {
+			if method, ok := r.Arguments[0].(string); ok {
+				return method
+			}
+		}
+	}
+	return r.Method
+}
+
+// Attachment returns the attachment as a string, it's empty if the attachment doesn't exist or isn't a string.
+func (r *Request) Attachment(key string) string {
+	value, _ := r.Attachments[key].(string)
+	return value
+}
+
+func (r *Request) SetAttachment(key string, value interface{}) {
+	if r.Attachments == nil {
+		r.Attachments = map[string]interface{}{}
+	}
+	r.Attachments[key] = value
+}
+
+func (r *Request) RemoveAttachment(key string) {
+	delete(r.Attachments, key)
+}
+
+// SetArguments replaces the arguments, they're encoded when the frame is encoded.
+func (r *Request) SetArguments(parameterTypes string, args ...interface{}) error {
+	types, err := ParseParameterTypes(parameterTypes)
+	if err != nil {
+		return err
+	}
+	if len(types) != len(args) {
+		return fmt.Errorf("dubbo: %d arguments for %d parameters", len(args), len(types))
+	}
+	r.ParameterTypes = parameterTypes
+	r.Arguments = args
+	r.rawArguments = nil
+	r.argumentClasses = 0
+	return nil
+}
+
+// Frame encodes the request.
+func (r *Request) Frame() (*Frame, error) {
+	e := NewEncoder()
+	for _, field := range []string{r.DubboVersion, r.Path, r.Version, r.Method, r.ParameterTypes} {
+		e.writeString(field)
+	}
+	if r.rawArguments != nil {
+		e.writeRaw(r.rawArguments, r.argumentClasses)
+	} else {
+		for _, arg := range r.Arguments {
+			if err := e.Encode(arg); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := e.Encode(r.Attachments); err != nil {
+		return nil, err
+	}
+	return &Frame{
+		Header: Header{
+			Request:       true,
+			TwoWay:        r.TwoWay,
+			Serialization: SerializationHessian2,
+			ID:            r.ID,
+		},
+		Body: e.Bytes(),
+	}, nil
+}
+
+// Response is the result of an invocation.
+type Response struct {
+	ID     int64
+	Status byte
+	// The error message if the status isn't OK
+	ErrorMessage string
+	Value        interface{}
+	// The exception thrown by the service, the status is OK in this case
+	Exception interface{}
+	// Nil if the response doesn't have the attachments
+	Attachments map[string]interface{}
+}
+
+// DecodeResponse decodes the result in the frame, ErrEventFrame is returned for the heartbeats and other events.
+func DecodeResponse(frame *Frame) (*Response, error) {
+	if frame.Request {
+		return nil, errors.New("dubbo: frame is not a response")
+	}
+	if frame.Event {
+		return nil, ErrEventFrame
+	}
+	if frame.Serialization != SerializationHessian2 {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedSerialization, frame.Serialization)
+	}
+	d := NewDecoder(frame.Body)
+	r := &Response{ID: frame.ID, Status: frame.Status}
+	if r.Status != StatusOK {
+		message, err := d.DecodeString()
+		if err != nil {
+			return nil, err
+		}
+		r.ErrorMessage = message
+		return r, nil
+	}
+	kind, err := d.readInt()
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case ResponseWithException, ResponseWithExceptionWithAttachments:
+		r.Exception, err = d.Decode()
+	case ResponseValue, ResponseValueWithAttachments:
+		r.Value, err = d.Decode()
+	case ResponseNullValue, ResponseNullValueWithAttachments:
+	default:
+		return nil, fmt.Errorf("%w: unknown response type %d", ErrHessianInvalid, kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if kind >= ResponseWithExceptionWithAttachments {
+		if r.Attachments, err = decodeAttachments(d); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Frame encodes the response.
+func (r *Response) Frame() (*Frame, error) {
+	e := NewEncoder()
+	if r.Status != StatusOK {
+		e.writeString(r.ErrorMessage)
+	} else {
+		var kind int32
+		var result interface{}
+		switch {
+		case r.Exception != nil:
+			kind, result = ResponseWithException, r.Exception
+		case r.Value != nil:
+			kind, result = ResponseValue, r.Value
+		default:
+			kind = ResponseNullValue
+		}
+		if r.Attachments != nil {
+			kind += ResponseWithExceptionWithAttachments
+		}
+		e.writeInt(kind)
+		if kind != ResponseNullValue && kind != ResponseNullValueWithAttachments {
+			if err := e.Encode(result); err != nil {
+				return nil, err
+			}
+		}
+		if r.Attachments != nil {
+			if err := e.Encode(r.Attachments); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &Frame{
+		Header: Header{Serialization: SerializationHessian2, Status: r.Status, ID: r.ID},
+		Body:   e.Bytes(),
+	}, nil
+}
+
+// NewErrorResponse returns the response of the request with the error status, e.g. for the plugins refusing the
+// invocations.
+func NewErrorResponse(r *Request, status byte, message string) *Response {
+	return &Response{ID: r.ID, Status: status, ErrorMessage: message}
+}
+
+// ParseParameterTypes parses the descriptor of the parameter types into the java type names, e.g.
+// Ljava/lang/String;[I is parsed into java.lang.String and int[].
+func ParseParameterTypes(desc string) ([]string, error) {
+	var types []string
+	for i := 0; i < len(desc); {
+		dims := 0
+		for i < len(desc) && desc[i] == '[' {
+			dims++
+			i++
+		}
+		if i == len(desc) {
+			return nil, fmt.Errorf("dubbo: invalid parameter types: %s", desc)
+		}
+		var name string
+		switch desc[i] {
+		case 'Z':
+			name = "boolean"
+		case 'B':
+			name = "byte"
+		case 'C':
+			name = "char"
+		case 'D':
+			name = "double"
+		case 'F':
+			name = "float"
+		case 'I':
+			name = "int"
+		case 'J':
+			name = "long"
+		case 'S':
+			name = "short"
+		case 'L':
+			end := strings.IndexByte(desc[i:], ';')
+			if end < 2 {
+				return nil, fmt.Errorf("dubbo: invalid parameter types: %s", desc)
+			}
+			name = strings.ReplaceAll(desc[i+1:i+end], "/", ".")
+			i += end
+		default:
+			return nil, fmt.Errorf("dubbo: invalid parameter types: %s", desc)
+		}
+		i++
+		types = append(types, name+strings.Repeat("[]", dims))
+	}
+	return types, nil
+}
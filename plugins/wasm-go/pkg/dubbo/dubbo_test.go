@@ -0,0 +1,288 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dubbo
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHessianEncoding(t *testing.T) {
+	// the examples in the spec of the hessian2 serialization
+	cases := []struct {
+		value   interface{}
+		encoded string
+	}{
+		{nil, "N"},
+		{true, "T"},
+		{int32(0), "\x90"},
+		{int32(-16), "\x80"},
+		{int32(47), "\xbf"},
+		{int32(48), "\xc8\x30"},
+		{int32(-2048), "\xc0\x00"},
+		{int32(2047), "\xcf\xff"},
+		{int32(-262144), "\xd0\x00\x00"},
+		{int32(262144), "I\x00\x04\x00\x00"},
+		{int64(0), "\xe0"},
+		{int64(-8), "\xd8"},
+		{int64(16), "\xf8\x10"},
+		{int64(262143), "\x3f\xff\xff"},
+		{int64(1 << 20), "\x59\x00\x10\x00\x00"},
+		{int64(1 << 40), "L\x00\x00\x01\x00\x00\x00\x00\x00"},
+		{float64(0), "\x5b"},
+		{float64(1), "\x5c"},
+		{float64(-128), "\x5d\x80"},
+		{float64(32767), "\x5e\x7f\xff"},
+		{12.25, "D\x40\x28\x80\x00\x00\x00\x00\x00"},
+		{"", "\x00"},
+		{"hello", "\x05hello"},
+		{"Ã", "\x01\xc3\x83"},
+		{[]byte{1, 2, 3}, "\x23\x01\x02\x03"},
+		{[]interface{}{int32(0), "foo"}, "\x7a\x90\x03foo"},
+		{map[interface{}]interface{}{int32(1): "fee"}, "H\x91\x03feeZ"},
+	}
+	for _, c := range cases {
+		e := NewEncoder()
+		require.NoError(t, e.Encode(c.value))
+		assert.Equal(t, c.encoded, string(e.Bytes()), "%#v", c.value)
+		v, err := NewDecoder([]byte(c.encoded)).Decode()
+		require.NoError(t, err)
+		assert.Equal(t, c.value, v)
+	}
+}
+
+func TestHessianDecoding(t *testing.T) {
+	// the object example in the spec, the second object uses the compact form
+	d := NewDecoder([]byte("C\x0bexample.Car\x92\x05color\x05modelO\x90\x03red\x08corvette\x60\x05green\x05civic"))
+	v, err := d.Decode()
+	require.NoError(t, err)
+	assert.Equal(t, &Object{Class: "example.Car", Fields: []string{"color", "model"}, Values: map[string]interface{}{"color": "red", "model": "corvette"}}, v)
+	v, err = d.Decode()
+	require.NoError(t, err)
+	assert.Equal(t, "civic", v.(*Object).Get("model"))
+	assert.False(t, d.More())
+
+	// typed list, typed map and references
+	d = NewDecoder([]byte("V\x04[int\x92\x90\x91" + "M\x13java.util.Hashtable\x01a\x01bZ" + "\x71\x90\x91" + "Q\x91" + "\x4b\x00\xe3\x83\x8f" + "\x5f\x00\x00\x00\x01"))
+	for _, expected := range []interface{}{
+		[]interface{}{int32(0), int32(1)},
+		map[interface{}]interface{}{"a": "b"},
+		[]interface{}{int32(1)},
+		map[interface{}]interface{}{"a": "b"},
+		time.Unix(894621060, 0),
+		0.001,
+	} {
+		v, err := d.Decode()
+		require.NoError(t, err)
+		assert.Equal(t, expected, v)
+	}
+
+	// java writes the supplementary chars as the surrogate pairs
+	v, err = NewDecoder([]byte("\x03a\xed\xa0\xbd\xed\xb8\x80")).Decode()
+	require.NoError(t, err)
+	assert.Equal(t, "a\U0001f600", v)
+
+	for _, data := range []string{"\x05hel", "I\x00", "\x59", "Q\x90", "\x60", "\x40", "H\x90", "X\xbf"} {
+		_, err := NewDecoder([]byte(data)).Decode()
+		assert.Error(t, err, "%q", data)
+	}
+	_, err = NewDecoder([]byte(strings.Repeat("\x79", 200) + "N")).Decode()
+	assert.True(t, errors.Is(err, ErrHessianTooDeep))
+
+	// a fixed-length list with a negative length
+	for _, data := range []string{"X\x8e", "X\x8f", "V\x04List\x8e"} {
+		_, err = NewDecoder([]byte(data)).Decode()
+		assert.True(t, errors.Is(err, ErrHessianInvalid), "%q", data)
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	e := NewEncoder()
+	require.NoError(f, e.Encode([]interface{}{"a", int32(1), map[string]string{"k": "v"}, NewObject("example.Car")}))
+	f.Add(e.Bytes())
+	f.Add([]byte("X\x8e"))
+	f.Add([]byte("W\x91Z"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := NewDecoder(data)
+		for d.More() {
+			if _, err := d.Decode(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func TestHessianRoundTrip(t *testing.T) {
+	car := NewObject("example.Car")
+	car.Set("color", "red")
+	car.Set("price", int64(100000))
+	long := strings.Repeat("好", hessianChunkSize+10) + "\U0001f600"
+	e := NewEncoder()
+	for _, v := range []interface{}{long, []byte(long), car, car, []interface{}{car, map[string]string{"k": "v"}}} {
+		require.NoError(t, e.Encode(v))
+	}
+	// the class is defined once
+	assert.Equal(t, 1, strings.Count(string(e.Bytes()), "example.Car"))
+
+	d := NewDecoder(e.Bytes())
+	for _, expected := range []interface{}{
+		long,
+		[]byte(long),
+		car,
+		car,
+		[]interface{}{car, map[interface{}]interface{}{"k": "v"}},
+	} {
+		v, err := d.Decode()
+		require.NoError(t, err)
+		assert.Equal(t, expected, v)
+	}
+	assert.False(t, d.More())
+}
+
+func newTestRequest(t testing.TB) []byte {
+	e := NewEncoder()
+	for _, s := range []string{"2.0.2", "com.example.DemoService", "1.0.0", "sayHello", "Ljava/lang/String;Lcom/example/User;I"} {
+		e.writeString(s)
+	}
+	user := NewObject("com.example.User")
+	user.Set("name", "john")
+	require.NoError(t, e.Encode("hello"))
+	require.NoError(t, e.Encode(user))
+	require.NoError(t, e.Encode(int32(18)))
+	require.NoError(t, e.Encode(map[string]interface{}{
+		"path":      "com.example.DemoService",
+		"interface": "com.example.DemoService",
+		"group":     "gray",
+		"owner":     user,
+	}))
+	frame := &Frame{Header: Header{Request: true, TwoWay: true, Serialization: SerializationHessian2, ID: 7}, Body: e.Bytes()}
+	return frame.Bytes()
+}
+
+func TestRequest(t *testing.T) {
+	data := newTestRequest(t)
+	heartbeat := (&Frame{Header: Header{Request: true, TwoWay: true, Event: true, Serialization: SerializationHessian2, ID: 8}, Body: []byte("N")}).Bytes()
+	data = append(data, heartbeat...)
+
+	parser := NewParser(0)
+	var frames []*Frame
+	for i := 0; i < len(data); i += 10 {
+		end := i + 10
+		if end > len(data) {
+			end = len(data)
+		}
+		parsed, err := parser.Feed(data[i:end])
+		require.NoError(t, err)
+		frames = append(frames, parsed...)
+	}
+	require.Len(t, frames, 2)
+	assert.Empty(t, parser.Pending())
+	assert.True(t, frames[1].IsHeartbeat())
+	_, err := DecodeRequest(frames[1])
+	assert.True(t, errors.Is(err, ErrEventFrame))
+
+	req, err := DecodeRequest(frames[0])
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), req.ID)
+	assert.True(t, req.TwoWay)
+	assert.Equal(t, "com.example.DemoService", req.Interface())
+	assert.Equal(t, "sayHello", req.GenericMethod())
+	assert.Equal(t, "gray", req.Group())
+	assert.Equal(t, "1.0.0", req.Version)
+	require.Len(t, req.Arguments, 3)
+	assert.Equal(t, "john", req.Arguments[1].(*Object).Get("name"))
+	assert.Equal(t, int32(18), req.Arguments[2])
+
+	// the arguments are kept, the classes defined by them are counted for the classes of the attachments
+	req.SetAttachment("x-tag", "canary")
+	req.RemoveAttachment(AttachmentGroup)
+	frame, err := req.Frame()
+	require.NoError(t, err)
+	mutated, err := DecodeRequest(frame)
+	require.NoError(t, err)
+	assert.Equal(t, req.Arguments, mutated.Arguments)
+	assert.Equal(t, "canary", mutated.Attachment("x-tag"))
+	assert.Equal(t, "", mutated.Group())
+	assert.Equal(t, "john", mutated.Attachments["owner"].(*Object).Get("name"))
+
+	require.NoError(t, req.SetArguments("Ljava/lang/String;", "bye"))
+	assert.Error(t, req.SetArguments("I", "bye", "again"))
+	frame, err = req.Frame()
+	require.NoError(t, err)
+	mutated, err = DecodeRequest(frame)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"bye"}, mutated.Arguments)
+	assert.Equal(t, "john", mutated.Attachments["owner"].(*Object).Get("name"))
+}
+
+func FuzzDecodeRequest(f *testing.F) {
+	data := newTestRequest(f)
+	f.Add(data[HeaderLength:])
+	f.Add([]byte("\x052.0.2\x03svc\x051.0.0\x03foo\x01IX\x8e"))
+	f.Fuzz(func(t *testing.T, body []byte) {
+		frame := &Frame{Header: Header{Request: true, TwoWay: true, Serialization: SerializationHessian2}, Body: body}
+		_, _ = DecodeRequest(frame)
+	})
+}
+
+func TestParserErrors(t *testing.T) {
+	data := newTestRequest(t)
+	frames, err := NewParser(10).Feed(data)
+	assert.True(t, errors.Is(err, ErrFrameTooLarge))
+	assert.Empty(t, frames)
+
+	parser := NewParser(0)
+	frames, err = parser.Feed(append(data, strings.Repeat("x", HeaderLength)...))
+	assert.True(t, errors.Is(err, ErrBadMagic))
+	assert.Len(t, frames, 1)
+	assert.Len(t, parser.Pending(), HeaderLength)
+}
+
+func TestResponse(t *testing.T) {
+	for _, resp := range []*Response{
+		{ID: 1, Status: StatusOK, Value: "hello"},
+		{ID: 2, Status: StatusOK, Value: int32(1), Attachments: map[string]interface{}{"k": "v"}},
+		{ID: 3, Status: StatusOK},
+		{ID: 4, Status: StatusOK, Exception: NewObject("java.lang.IllegalStateException")},
+		NewErrorResponse(&Request{ID: 5}, StatusServiceNotFound, "service not found"),
+	} {
+		frame, err := resp.Frame()
+		require.NoError(t, err)
+		parsed, err := NewParser(0).Feed(frame.Bytes())
+		require.NoError(t, err)
+		require.Len(t, parsed, 1)
+		decoded, err := DecodeResponse(parsed[0])
+		require.NoError(t, err)
+		assert.Equal(t, resp, decoded)
+	}
+}
+
+func TestParseParameterTypes(t *testing.T) {
+	types, err := ParseParameterTypes("Ljava/lang/String;[IJ[[Lcom/example/User;Z")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"java.lang.String", "int[]", "long", "com.example.User[][]", "boolean"}, types)
+	types, err = ParseParameterTypes("")
+	require.NoError(t, err)
+	assert.Empty(t, types)
+	for _, desc := range []string{"Ljava/lang/String", "[", "X", "L;"} {
+		_, err := ParseParameterTypes(desc)
+		assert.Error(t, err, desc)
+	}
+}
@@ -0,0 +1,844 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dubbo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Hessian2 serialization: http://hessian.caucho.com/doc/hessian-serialization.html
+//
+// The values are decoded as:
+//
+//	null                  nil
+//	boolean               bool
+//	int                   int32
+//	long                  int64
+//	double                float64
+//	date                  time.Time
+//	string                string
+//	binary                []byte
+//	list                  []interface{}, the type of the typed lists is dropped
+//	map                   map[interface{}]interface{}, the type of the typed maps is dropped
+//	object                *Object
+
+var (
+	ErrHessianTruncated = errors.New("hessian: unexpected end of data")
+	ErrHessianTooDeep   = errors.New("hessian: value is nested too deeply")
+	ErrHessianInvalid   = errors.New("hessian: invalid data")
+)
+
+const (
+	hessianMaxDepth  = 128
+	hessianChunkSize = 0x8000
+)
+
+// Object is a Java object, the fields are kept in the order of the class definition.
+type Object struct {
+	Class  string
+	Fields []string
+	Values map[string]interface{}
+}
+
+func NewObject(class string) *Object {
+	return &Object{Class: class, Values: map[string]interface{}{}}
+}
+
+func (o *Object) Get(field string) interface{} {
+	return o.Values[field]
+}
+
+// Set sets the field, it's appended to the fields if it doesn't exist.
+func (o *Object) Set(field string, value interface{}) {
+	if o.Values == nil {
+		o.Values = map[string]interface{}{}
+	}
+	if _, ok := o.Values[field]; !ok {
+		o.Fields = append(o.Fields, field)
+	}
+	o.Values[field] = value
+}
+
+type hessianClass struct {
+	name   string
+	fields []string
+}
+
+// Decoder reads the values of a hessian2 stream, the class definitions, types and references are shared by the values.
+type Decoder struct {
+	data    []byte
+	pos     int
+	depth   int
+	refs    []interface{}
+	classes []hessianClass
+	types   []string
+}
+
+func NewDecoder(data []byte) *Decoder {
+	return &Decoder{data: data}
+}
+
+// Offset returns the number of bytes read.
+func (d *Decoder) Offset() int {
+	return d.pos
+}
+
+// More reports whether there are bytes left.
+func (d *Decoder) More() bool {
+	return d.pos < len(d.data)
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, ErrHessianTruncated
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *Decoder) readBytes(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, ErrHessianTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *Decoder) peek() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, ErrHessianTruncated
+	}
+	return d.data[d.pos], nil
+}
+
+// Decode reads the next value.
+func (d *Decoder) Decode() (interface{}, error) {
+	if d.depth >= hessianMaxDepth {
+		return nil, ErrHessianTooDeep
+	}
+	d.depth++
+	defer func() { d.depth-- }()
+	tag, err := d.readByte()
+	// the class definitions are followed by the object
+	for err == nil && tag == 'C' {
+		if err = d.readClass(); err == nil {
+			tag, err = d.readByte()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case tag == 'N':
+		return nil, nil
+	case tag == 'T':
+		return true, nil
+	case tag == 'F':
+		return false, nil
+	case tag >= 0x80 && tag <= 0xbf:
+		return int32(tag) - 0x90, nil
+	case tag >= 0xc0 && tag <= 0xcf:
+		b, err := d.readBytes(1)
+		if err != nil {
+			return nil, err
+		}
+		return (int32(tag)-0xc8)<<8 | int32(b[0]), nil
+	case tag >= 0xd0 && tag <= 0xd7:
+		b, err := d.readBytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return (int32(tag)-0xd4)<<16 | int32(b[0])<<8 | int32(b[1]), nil
+	case tag == 'I':
+		b, err := d.readBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return int32(binary.BigEndian.Uint32(b)), nil
+	case tag >= 0xd8 && tag <= 0xef:
+		return int64(tag) - 0xe0, nil
+	case tag >= 0xf0:
+		b, err := d.readBytes(1)
+		if err != nil {
+			return nil, err
+		}
+		return (int64(tag)-0xf8)<<8 | int64(b[0]), nil
+	case tag >= 0x38 && tag <= 0x3f:
+		b, err := d.readBytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return (int64(tag)-0x3c)<<16 | int64(b[0])<<8 | int64(b[1]), nil
+	case tag == 0x59:
+		b, err := d.readBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+	case tag == 'L':
+		b, err := d.readBytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case tag == 0x5b:
+		return float64(0), nil
+	case tag == 0x5c:
+		return float64(1), nil
+	case tag == 0x5d:
+		b, err := d.readBytes(1)
+		if err != nil {
+			return nil, err
+		}
+		return float64(int8(b[0])), nil
+	case tag == 0x5e:
+		b, err := d.readBytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return float64(int16(binary.BigEndian.Uint16(b))), nil
+	case tag == 0x5f:
+		// the double is encoded as the milliunits in an int, as the java implementation does
+		b, err := d.readBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return 0.001 * float64(int32(binary.BigEndian.Uint32(b))), nil
+	case tag == 'D':
+		b, err := d.readBytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case tag == 0x4a:
+		b, err := d.readBytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return time.UnixMilli(int64(binary.BigEndian.Uint64(b))), nil
+	case tag == 0x4b:
+		b, err := d.readBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return time.Unix(int64(int32(binary.BigEndian.Uint32(b)))*60, 0), nil
+	case tag <= 0x1f, tag >= 0x30 && tag <= 0x33, tag == 'S', tag == 'R':
+		return d.readString(tag)
+	case tag >= 0x20 && tag <= 0x2f, tag >= 0x34 && tag <= 0x37, tag == 'B', tag == 'A':
+		return d.readBinary(tag)
+	case tag == 0x55, tag == 0x57:
+		if tag == 0x55 {
+			if _, err := d.readType(); err != nil {
+				return nil, err
+			}
+		}
+		return d.readList(-1)
+	case tag == 0x56, tag == 0x58:
+		if tag == 0x56 {
+			if _, err := d.readType(); err != nil {
+				return nil, err
+			}
+		}
+		n, err := d.readInt()
+		if err != nil {
+			return nil, err
+		}
+		// only 'U' and 'W' are variable-length
+		if n < 0 {
+			return nil, fmt.Errorf("%w: negative list length %d", ErrHessianInvalid, n)
+		}
+		return d.readList(int(n))
+	case tag >= 0x70 && tag <= 0x77:
+		if _, err := d.readType(); err != nil {
+			return nil, err
+		}
+		return d.readList(int(tag - 0x70))
+	case tag >= 0x78 && tag <= 0x7f:
+		return d.readList(int(tag - 0x78))
+	case tag == 'M', tag == 'H':
+		if tag == 'M' {
+			if _, err := d.readType(); err != nil {
+				return nil, err
+			}
+		}
+		return d.readMap()
+	case tag == 'O':
+		index, err := d.readInt()
+		if err != nil {
+			return nil, err
+		}
+		return d.readObject(int(index))
+	case tag >= 0x60 && tag <= 0x6f:
+		return d.readObject(int(tag - 0x60))
+	case tag == 'Q':
+		index, err := d.readInt()
+		if err != nil {
+			return nil, err
+		}
+		if index < 0 || int(index) >= len(d.refs) {
+			return nil, fmt.Errorf("%w: unknown reference %d", ErrHessianInvalid, index)
+		}
+		return d.refs[index], nil
+	}
+	return nil, fmt.Errorf("%w: unknown tag 0x%02x", ErrHessianInvalid, tag)
+}
+
+// DecodeString reads the next value, which must be a string or null.
+func (d *Decoder) DecodeString() (string, error) {
+	v, err := d.Decode()
+	if err != nil {
+		return "", err
+	}
+	switch s := v.(type) {
+	case string:
+		return s, nil
+	case nil:
+		return "", nil
+	}
+	return "", fmt.Errorf("%w: expect string, got %T", ErrHessianInvalid, v)
+}
+
+func (d *Decoder) readInt() (int32, error) {
+	v, err := d.Decode()
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int32)
+	if !ok {
+		return 0, fmt.Errorf("%w: expect int, got %T", ErrHessianInvalid, v)
+	}
+	return n, nil
+}
+
+func (d *Decoder) readString(tag byte) (string, error) {
+	var sb strings.Builder
+	for {
+		var n int
+		last := true
+		switch {
+		case tag <= 0x1f:
+			n = int(tag)
+		case tag >= 0x30 && tag <= 0x33:
+			b, err := d.readBytes(1)
+			if err != nil {
+				return "", err
+			}
+			n = int(tag-0x30)<<8 | int(b[0])
+		case tag == 'S', tag == 'R':
+			b, err := d.readBytes(2)
+			if err != nil {
+				return "", err
+			}
+			n = int(binary.BigEndian.Uint16(b))
+			last = tag == 'S'
+		default:
+			return "", fmt.Errorf("%w: unexpected string chunk 0x%02x", ErrHessianInvalid, tag)
+		}
+		if err := d.readChars(&sb, n); err != nil {
+			return "", err
+		}
+		if last {
+			return sb.String(), nil
+		}
+		var err error
+		if tag, err = d.readByte(); err != nil {
+			return "", err
+		}
+	}
+}
+
+// readChars reads n UTF-16 chars encoded in UTF-8, java writes the surrogate pairs as two 3-byte sequences.
+func (d *Decoder) readChars(sb *strings.Builder, n int) error {
+	for i := 0; i < n; i++ {
+		b, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case b < 0x80:
+			sb.WriteByte(b)
+		case b&0xe0 == 0xc0:
+			c, err := d.readBytes(1)
+			if err != nil {
+				return err
+			}
+			sb.WriteRune(rune(b&0x1f)<<6 | rune(c[0]&0x3f))
+		case b&0xf0 == 0xe0:
+			c, err := d.readBytes(2)
+			if err != nil {
+				return err
+			}
+			r := rune(b&0x0f)<<12 | rune(c[0]&0x3f)<<6 | rune(c[1]&0x3f)
+			if utf16.IsSurrogate(r) && i+1 < n && len(d.data)-d.pos >= 3 && d.data[d.pos]&0xf0 == 0xe0 {
+				next := d.data[d.pos:]
+				low := rune(next[0]&0x0f)<<12 | rune(next[1]&0x3f)<<6 | rune(next[2]&0x3f)
+				if pair := utf16.DecodeRune(r, low); pair != 0xfffd {
+					d.pos += 3
+					i++
+					r = pair
+				}
+			}
+			sb.WriteRune(r)
+		case b&0xf8 == 0xf0:
+			c, err := d.readBytes(3)
+			if err != nil {
+				return err
+			}
+			sb.WriteRune(rune(b&0x07)<<18 | rune(c[0]&0x3f)<<12 | rune(c[1]&0x3f)<<6 | rune(c[2]&0x3f))
+			// a supplementary char counts as two chars
+			i++
+		default:
+			return fmt.Errorf("%w: bad utf-8 encoding", ErrHessianInvalid)
+		}
+	}
+	return nil
+}
+
+func (d *Decoder) readBinary(tag byte) ([]byte, error) {
+	var data []byte
+	for {
+		var n int
+		last := true
+		switch {
+		case tag >= 0x20 && tag <= 0x2f:
+			n = int(tag - 0x20)
+		case tag >= 0x34 && tag <= 0x37:
+			b, err := d.readBytes(1)
+			if err != nil {
+				return nil, err
+			}
+			n = int(tag-0x34)<<8 | int(b[0])
+		case tag == 'B', tag == 'A':
+			b, err := d.readBytes(2)
+			if err != nil {
+				return nil, err
+			}
+			n = int(binary.BigEndian.Uint16(b))
+			last = tag == 'B'
+		default:
+			return nil, fmt.Errorf("%w: unexpected binary chunk 0x%02x", ErrHessianInvalid, tag)
+		}
+		chunk, err := d.readBytes(n)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, chunk...)
+		if last {
+			return data, nil
+		}
+		if tag, err = d.readByte(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readType reads the type of a typed list or map, it's either the type name or the index of a type read before.
+func (d *Decoder) readType() (string, error) {
+	v, err := d.Decode()
+	if err != nil {
+		return "", err
+	}
+	switch t := v.(type) {
+	case string:
+		d.types = append(d.types, t)
+		return t, nil
+	case int32:
+		if t < 0 || int(t) >= len(d.types) {
+			return "", fmt.Errorf("%w: unknown type reference %d", ErrHessianInvalid, t)
+		}
+		return d.types[t], nil
+	}
+	return "", fmt.Errorf("%w: expect type, got %T", ErrHessianInvalid, v)
+}
+
+// readList reads n items, or the items until 'Z' if n is negative.
+func (d *Decoder) readList(n int) ([]interface{}, error) {
+	// every item takes one byte at least
+	if n > len(d.data)-d.pos {
+		return nil, ErrHessianTruncated
+	}
+	ref := len(d.refs)
+	d.refs = append(d.refs, nil)
+	list := make([]interface{}, 0, n+1)
+	for i := 0; n < 0 || i < n; i++ {
+		if n < 0 {
+			tag, err := d.peek()
+			if err != nil {
+				return nil, err
+			}
+			if tag == 'Z' {
+				d.pos++
+				break
+			}
+		}
+		item, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+	d.refs[ref] = list
+	return list, nil
+}
+
+func (d *Decoder) readMap() (map[interface{}]interface{}, error) {
+	m := map[interface{}]interface{}{}
+	d.refs = append(d.refs, m)
+	for {
+		tag, err := d.peek()
+		if err != nil {
+			return nil, err
+		}
+		if tag == 'Z' {
+			d.pos++
+			return m, nil
+		}
+		key, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		if key != nil && !reflect.TypeOf(key).Comparable() {
+			return nil, fmt.Errorf("%w: unsupported map key %T", ErrHessianInvalid, key)
+		}
+		value, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+}
+
+func (d *Decoder) readClass() error {
+	name, err := d.DecodeString()
+	if err != nil {
+		return err
+	}
+	n, err := d.readInt()
+	if err != nil {
+		return err
+	}
+	if n < 0 || int(n) > len(d.data)-d.pos {
+		return ErrHessianTruncated
+	}
+	class := hessianClass{name: name, fields: make([]string, 0, n)}
+	for i := 0; i < int(n); i++ {
+		field, err := d.DecodeString()
+		if err != nil {
+			return err
+		}
+		class.fields = append(class.fields, field)
+	}
+	d.classes = append(d.classes, class)
+	return nil
+}
+
+func (d *Decoder) readObject(index int) (*Object, error) {
+	if index < 0 || index >= len(d.classes) {
+		return nil, fmt.Errorf("%w: unknown class %d", ErrHessianInvalid, index)
+	}
+	class := d.classes[index]
+	object := &Object{
+		Class:  class.name,
+		Fields: append([]string(nil), class.fields...),
+		Values: make(map[string]interface{}, len(class.fields)),
+	}
+	d.refs = append(d.refs, object)
+	for _, field := range class.fields {
+		value, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		object.Values[field] = value
+	}
+	return object, nil
+}
+
+// Encoder writes hessian2 values, the references are not used, so the cyclic values can't be encoded.
+type Encoder struct {
+	buf   []byte
+	depth int
+	// the classes defined by the encoder, the index of the first one is classOffset
+	classes     map[string]int
+	classOffset int
+}
+
+func NewEncoder() *Encoder {
+	return &Encoder{classes: map[string]int{}}
+}
+
+// Bytes returns the encoded values.
+func (e *Encoder) Bytes() []byte {
+	return e.buf
+}
+
+// writeRaw appends the encoded values, which define the given number of classes.
+func (e *Encoder) writeRaw(data []byte, classes int) {
+	e.buf = append(e.buf, data...)
+	e.classOffset += classes
+}
+
+// Encode writes the value, it supports the decoded types, the other sized ints, float32, []string, map[string]string
+// and map[string]interface{}.
+func (e *Encoder) Encode(v interface{}) error {
+	if e.depth >= hessianMaxDepth {
+		return ErrHessianTooDeep
+	}
+	e.depth++
+	defer func() { e.depth-- }()
+	switch v := v.(type) {
+	case nil:
+		e.buf = append(e.buf, 'N')
+	case bool:
+		if v {
+			e.buf = append(e.buf, 'T')
+		} else {
+			e.buf = append(e.buf, 'F')
+		}
+	case int8:
+		e.writeInt(int32(v))
+	case int16:
+		e.writeInt(int32(v))
+	case int32:
+		e.writeInt(v)
+	case uint8:
+		e.writeInt(int32(v))
+	case uint16:
+		e.writeInt(int32(v))
+	case int:
+		if v >= math.MinInt32 && v <= math.MaxInt32 {
+			e.writeInt(int32(v))
+		} else {
+			e.writeLong(int64(v))
+		}
+	case uint32:
+		e.writeLong(int64(v))
+	case int64:
+		e.writeLong(v)
+	case float32:
+		e.writeDouble(float64(v))
+	case float64:
+		e.writeDouble(v)
+	case time.Time:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0x4a), uint64(v.UnixMilli()))
+	case string:
+		e.writeString(v)
+	case []byte:
+		e.writeBinary(v)
+	case []string:
+		e.writeListLength(len(v))
+		for _, item := range v {
+			e.writeString(item)
+		}
+	case []interface{}:
+		e.writeListLength(len(v))
+		for _, item := range v {
+			if err := e.Encode(item); err != nil {
+				return err
+			}
+		}
+	case map[string]string:
+		e.buf = append(e.buf, 'H')
+		for _, key := range sortedKeys(v) {
+			e.writeString(key)
+			e.writeString(v[key])
+		}
+		e.buf = append(e.buf, 'Z')
+	case map[string]interface{}:
+		e.buf = append(e.buf, 'H')
+		for _, key := range sortedKeys(v) {
+			e.writeString(key)
+			if err := e.Encode(v[key]); err != nil {
+				return err
+			}
+		}
+		e.buf = append(e.buf, 'Z')
+	case map[interface{}]interface{}:
+		keys := make([]interface{}, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		e.buf = append(e.buf, 'H')
+		for _, key := range keys {
+			if err := e.Encode(key); err != nil {
+				return err
+			}
+			if err := e.Encode(v[key]); err != nil {
+				return err
+			}
+		}
+		e.buf = append(e.buf, 'Z')
+	case *Object:
+		return e.writeObject(v)
+	default:
+		return fmt.Errorf("hessian: unsupported type %T", v)
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (e *Encoder) writeInt(v int32) {
+	switch {
+	case v >= -16 && v <= 47:
+		e.buf = append(e.buf, byte(0x90+v))
+	case v >= -2048 && v <= 2047:
+		e.buf = append(e.buf, byte(0xc8+v>>8), byte(v))
+	case v >= -262144 && v <= 262143:
+		e.buf = append(e.buf, byte(0xd4+v>>16), byte(v>>8), byte(v))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 'I'), uint32(v))
+	}
+}
+
+func (e *Encoder) writeLong(v int64) {
+	switch {
+	case v >= -8 && v <= 15:
+		e.buf = append(e.buf, byte(0xe0+v))
+	case v >= -2048 && v <= 2047:
+		e.buf = append(e.buf, byte(0xf8+v>>8), byte(v))
+	case v >= -262144 && v <= 262143:
+		e.buf = append(e.buf, byte(0x3c+v>>16), byte(v>>8), byte(v))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0x59), uint32(v))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 'L'), uint64(v))
+	}
+}
+
+func (e *Encoder) writeDouble(v float64) {
+	// -0.0 is kept as is
+	if v == math.Trunc(v) && !(v == 0 && math.Signbit(v)) {
+		switch {
+		case v == 0:
+			e.buf = append(e.buf, 0x5b)
+			return
+		case v == 1:
+			e.buf = append(e.buf, 0x5c)
+			return
+		case v >= math.MinInt8 && v <= math.MaxInt8:
+			e.buf = append(e.buf, 0x5d, byte(int8(v)))
+			return
+		case v >= math.MinInt16 && v <= math.MaxInt16:
+			e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0x5e), uint16(int16(v)))
+			return
+		}
+	}
+	e.buf = binary.BigEndian.AppendUint64(append(e.buf, 'D'), math.Float64bits(v))
+}
+
+func (e *Encoder) writeString(s string) {
+	chars := utf16.Encode([]rune(s))
+	for len(chars) > hessianChunkSize {
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 'R'), hessianChunkSize)
+		e.writeChars(chars[:hessianChunkSize])
+		chars = chars[hessianChunkSize:]
+	}
+	n := len(chars)
+	switch {
+	case n <= 0x1f:
+		e.buf = append(e.buf, byte(n))
+	case n <= 0x3ff:
+		e.buf = append(e.buf, byte(0x30+n>>8), byte(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 'S'), uint16(n))
+	}
+	e.writeChars(chars)
+}
+
+// writeChars writes the UTF-16 chars in UTF-8 as java does, a surrogate pair is written as two 3-byte sequences.
+func (e *Encoder) writeChars(chars []uint16) {
+	for _, c := range chars {
+		switch {
+		case c < 0x80:
+			e.buf = append(e.buf, byte(c))
+		case c < 0x800:
+			e.buf = append(e.buf, byte(0xc0|c>>6), byte(0x80|c&0x3f))
+		default:
+			e.buf = append(e.buf, byte(0xe0|c>>12), byte(0x80|c>>6&0x3f), byte(0x80|c&0x3f))
+		}
+	}
+}
+
+func (e *Encoder) writeBinary(data []byte) {
+	for len(data) > hessianChunkSize {
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 'A'), hessianChunkSize)
+		e.buf = append(e.buf, data[:hessianChunkSize]...)
+		data = data[hessianChunkSize:]
+	}
+	n := len(data)
+	switch {
+	case n <= 0x0f:
+		e.buf = append(e.buf, byte(0x20+n))
+	case n <= 0x3ff:
+		e.buf = append(e.buf, byte(0x34+n>>8), byte(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 'B'), uint16(n))
+	}
+	e.buf = append(e.buf, data...)
+}
+
+func (e *Encoder) writeListLength(n int) {
+	if n <= 7 {
+		e.buf = append(e.buf, byte(0x78+n))
+		return
+	}
+	e.buf = append(e.buf, 0x58)
+	e.writeInt(int32(n))
+}
+
+func (e *Encoder) writeObject(o *Object) error {
+	key := o.Class + "\x00" + strings.Join(o.Fields, "\x00")
+	index, ok := e.classes[key]
+	if !ok {
+		index = e.classOffset + len(e.classes)
+		e.classes[key] = index
+		e.buf = append(e.buf, 'C')
+		e.writeString(o.Class)
+		e.writeInt(int32(len(o.Fields)))
+		for _, field := range o.Fields {
+			e.writeString(field)
+		}
+	}
+	if index <= 0x0f {
+		e.buf = append(e.buf, byte(0x60+index))
+	} else {
+		e.buf = append(e.buf, 'O')
+		e.writeInt(int32(index))
+	}
+	for _, field := range o.Fields {
+		if err := e.Encode(o.Values[field]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
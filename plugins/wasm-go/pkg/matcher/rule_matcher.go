@@ -47,6 +47,17 @@ const (
 	MATCH_DOMAIN_KEY       = "_match_domain_"
 	MATCH_SERVICE_KEY      = "_match_service_"
 	MATCH_ROUTE_PREFIX_KEY = "_match_route_prefix_"
+	// The debug dump config read by the wrapper, it's not a part of the plugin config
+	DEBUG_KEY = "_debug_"
+	// The header transformation executed by the wrapper, in the global config and the rules
+	HEADERS_KEY = "_headers_"
+	// The body buffer limits applied by the wrapper on match, in the global config and the rules
+	REQUEST_BODY_BUFFER_LIMIT_KEY  = "_requestBodyBufferLimit_"
+	RESPONSE_BODY_BUFFER_LIMIT_KEY = "_responseBodyBufferLimit_"
+	// The policy of the errors failing the request, read by the wrapper from the global config
+	ERRORS_KEY = "_errors_"
+	// The feature flags polled by the wrapper for wrapper.FlagEnabled, read from the global config
+	FLAGS_KEY = "_flags_"
 )
 
 type HostMatcher struct {
@@ -69,49 +80,59 @@ type RuleMatcher[PluginConfig any] struct {
 	hasGlobalConfig bool
 }
 
+// GlobalRuleIndex is the rule index of the global config returned by GetMatchRule.
+const GlobalRuleIndex = -1
+
 func (m RuleMatcher[PluginConfig]) GetMatchConfig() (*PluginConfig, error) {
+	config, _, err := m.GetMatchRule()
+	return config, err
+}
+
+// GetMatchRule returns the config matched by the request, and the index of the matched rule in _rules_, which is
+// GlobalRuleIndex for the global config. The config is nil if no rule is matched and there is no global config.
+func (m RuleMatcher[PluginConfig]) GetMatchRule() (*PluginConfig, int, error) {
 	host, err := proxywasm.GetHttpRequestHeader(":authority")
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	routeName, err := proxywasm.GetProperty([]string{"route_name"})
 	if err != nil && err != types.ErrorStatusNotFound {
-		return nil, err
+		return nil, 0, err
 	}
 	serviceName, err := proxywasm.GetProperty([]string{"cluster_name"})
 	if err != nil && err != types.ErrorStatusNotFound {
-		return nil, err
+		return nil, 0, err
 	}
-	for _, rule := range m.ruleConfig {
+	for i, rule := range m.ruleConfig {
 		// category == Host
 		if rule.category == Host {
 			if m.hostMatch(rule, host) {
-				return &rule.config, nil
+				return &rule.config, i, nil
 			}
 		}
 		// category == Route
 		if rule.category == Route {
 			if _, ok := rule.routes[string(routeName)]; ok {
-				return &rule.config, nil
+				return &rule.config, i, nil
 			}
 		}
 		// category == RoutePrefix
 		if rule.category == RoutePrefix {
 			for routePrefix := range rule.routePrefixs {
 				if strings.HasPrefix(string(routeName), routePrefix) {
-					return &rule.config, nil
+					return &rule.config, i, nil
 				}
 			}
 		}
 		// category == Cluster
 		if m.serviceMatch(rule, string(serviceName)) {
-			return &rule.config, nil
+			return &rule.config, i, nil
 		}
 	}
 	if m.hasGlobalConfig {
-		return &m.globalConfig, nil
+		return &m.globalConfig, GlobalRuleIndex, nil
 	}
-	return nil, nil
+	return nil, GlobalRuleIndex, nil
 }
 
 func (m *RuleMatcher[PluginConfig]) ParseRuleConfig(config gjson.Result,
@@ -125,6 +146,17 @@ func (m *RuleMatcher[PluginConfig]) ParseRuleConfig(config gjson.Result,
 		m.hasGlobalConfig = true
 		return parsePluginConfig(config, &m.globalConfig)
 	}
+	reserved := false
+	for _, key := range []string{DEBUG_KEY, HEADERS_KEY, REQUEST_BODY_BUFFER_LIMIT_KEY, RESPONSE_BODY_BUFFER_LIMIT_KEY, ERRORS_KEY, FLAGS_KEY} {
+		if _, ok := obj[key]; ok {
+			keyCount--
+			reserved = true
+		}
+	}
+	if reserved && keyCount == 0 {
+		m.hasGlobalConfig = true
+		return parsePluginConfig(config, &m.globalConfig)
+	}
 	if rulesJson, ok := obj[RULES_KEY]; ok {
 		rules = rulesJson.Array()
 		keyCount--
@@ -227,6 +227,56 @@ func TestParseRuleConfig(t *testing.T) {
 				hasGlobalConfig: true,
 			},
 		},
+		{
+			name:   "debug config only",
+			config: `{"_debug_":{"secret":"s3cret"}}`,
+			expected: RuleMatcher[customConfig]{
+				hasGlobalConfig: true,
+			},
+		},
+		{
+			name:   "headers config only",
+			config: `{"_debug_":{"secret":"s3cret"},"_headers_":{"request":[{"op":"remove","name":"x-debug"}]}}`,
+			expected: RuleMatcher[customConfig]{
+				hasGlobalConfig: true,
+			},
+		},
+		{
+			name:   "errors config only",
+			config: `{"_errors_":{"format":"json"}}`,
+			expected: RuleMatcher[customConfig]{
+				hasGlobalConfig: true,
+			},
+		},
+		{
+			name:   "flags config only",
+			config: `{"_flags_":{"path":"/api/client/features","serviceName":"unleash.dns"}}`,
+			expected: RuleMatcher[customConfig]{
+				hasGlobalConfig: true,
+			},
+		},
+		{
+			name:   "buffer limit only",
+			config: `{"_requestBodyBufferLimit_":1048576}`,
+			expected: RuleMatcher[customConfig]{
+				hasGlobalConfig: true,
+			},
+		},
+		{
+			name:   "debug config with rules",
+			config: `{"_debug_":{"secret":"s3cret"},"_rules_":[{"_match_route_":["test1"],"name":"ann"}]}`,
+			expected: RuleMatcher[customConfig]{
+				ruleConfig: []RuleConfig[customConfig]{
+					{
+						category:     Route,
+						routes:       map[string]struct{}{"test1": {}},
+						services:     map[string]struct{}{},
+						routePrefixs: map[string]struct{}{},
+						config:       customConfig{name: "ann"},
+					},
+				},
+			},
+		},
 		{
 			name:   "rules config",
 			config: `{"_rules_":[{"_match_domain_":["*.example.com","www.*","*","www.abc.com"],"name":"john", "age":18},{"_match_route_":["test1","test2"],"name":"ann", "age":16},{"_match_service_":["test1.dns","test2.static:8080"],"name":"ann", "age":16},{"_match_route_prefix_":["api1","api2"],"name":"ann", "age":16}]}`,
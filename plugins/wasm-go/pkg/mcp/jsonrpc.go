@@ -0,0 +1,139 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+const JSONRPCVersion = "2.0"
+
+// The error codes of JSON-RPC 2.0, and the ones defined by MCP.
+const (
+	ErrorCodeParse            = -32700
+	ErrorCodeInvalidRequest   = -32600
+	ErrorCodeMethodNotFound   = -32601
+	ErrorCodeInvalidParams    = -32602
+	ErrorCodeInternal         = -32603
+	ErrorCodeResourceNotFound = -32002
+)
+
+var nullID = json.RawMessage("null")
+
+// Request is a JSON-RPC request, or a notification if it has no id.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+func (r *Request) IsNotification() bool {
+	return len(r.ID) == 0
+}
+
+// Response is a JSON-RPC response, it has either the result or the error.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %s", e.Code, e.Message)
+}
+
+func NewError(code int, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func newResult(id json.RawMessage, result interface{}) *Response {
+	if result == nil {
+		result = struct{}{}
+	}
+	return &Response{JSONRPC: JSONRPCVersion, ID: id, Result: result}
+}
+
+func newErrorResponse(id json.RawMessage, err *Error) *Response {
+	if len(id) == 0 {
+		id = nullID
+	}
+	return &Response{JSONRPC: JSONRPCVersion, ID: id, Error: err}
+}
+
+// decodeMessages decodes a message or a batch of messages. The messages without a method are responses to the
+// requests of the server, which are never sent, so they are dropped.
+func decodeMessages(body []byte) (requests []*Request, batch bool, err *Error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, false, NewError(ErrorCodeParse, "empty body")
+	}
+	var raws []json.RawMessage
+	if body[0] == '[' {
+		batch = true
+		if err := json.Unmarshal(body, &raws); err != nil {
+			return nil, true, NewError(ErrorCodeParse, "invalid json: %v", err)
+		}
+		if len(raws) == 0 {
+			return nil, true, NewError(ErrorCodeInvalidRequest, "empty batch")
+		}
+	} else {
+		raws = []json.RawMessage{body}
+	}
+	for _, raw := range raws {
+		var message struct {
+			Request
+			Result json.RawMessage `json:"result"`
+			Error  json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal(raw, &message); err != nil {
+			if !batch {
+				return nil, false, NewError(ErrorCodeParse, "invalid json: %v", err)
+			}
+			// replied as an invalid request
+			message.Request = Request{}
+		}
+		if message.Method == "" && (len(message.Result) > 0 || len(message.Error) > 0) {
+			// a response of the client
+			continue
+		}
+		request := message.Request
+		requests = append(requests, &request)
+	}
+	return requests, batch, nil
+}
+
+// validate checks the request is well formed, the error is replied to the request.
+func (r *Request) validate() *Error {
+	if r.JSONRPC != JSONRPCVersion {
+		return NewError(ErrorCodeInvalidRequest, "jsonrpc must be %q", JSONRPCVersion)
+	}
+	if r.Method == "" {
+		return NewError(ErrorCodeInvalidRequest, "method is empty")
+	}
+	if len(r.ID) > 0 && (r.ID[0] != '"' && (r.ID[0] < '0' || r.ID[0] > '9') && r.ID[0] != '-') {
+		return NewError(ErrorCodeInvalidRequest, "id must be a string or a number")
+	}
+	return nil
+}
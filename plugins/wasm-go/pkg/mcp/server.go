@@ -0,0 +1,367 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mcp implements the server side of the Model Context Protocol over the streamable HTTP transport, so a
+// plugin can serve the tools and resources of an MCP server on the gateway:
+//
+//	server, _ := mcp.NewServer(mcp.ServerConfig{Info: mcp.Implementation{Name: "weather", Version: "1.0.0"}})
+//	mcp.AddTypedTool(server, mcp.Tool{Name: "forecast", InputSchema: schema}, func(call *mcp.ToolCall, args ForecastArgs) {
+//		call.Reply(mcp.TextResult("sunny in " + args.City))
+//	})
+//
+// and in the request phases of the plugin:
+//
+//	func onHttpRequestHeaders(ctx wrapper.HttpContext, config Config, log wrapper.Log) types.Action {
+//		return config.server.OnRequestHeaders(ctx)
+//	}
+//
+//	func onHttpRequestBody(ctx wrapper.HttpContext, config Config, body []byte, log wrapper.Log) types.Action {
+//		return config.server.OnRequestBody(ctx, body)
+//	}
+//
+// The tools may reply asynchronously, e.g. in the callback of an HTTP call.
+//
+// SSE is supported as the streamable HTTP transport uses it: with ServerConfig.SSE, the response to a POST is a
+// text/event-stream carrying the single message of the reply. The server never sends requests or notifications of its
+// own, so a GET for the stream of the server messages is answered with 405, as the specification allows. The
+// deprecated HTTP+SSE transport of the 2024-11-05 version is not supported: it sends the responses to the POSTs on
+// another long-lived GET stream, and a plugin can't write to a stream other than the one it's serving.
+package mcp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+// The protocol versions supported, the latest first.
+var SupportedProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+type Implementation struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type ServerConfig struct {
+	Info         Implementation
+	Instructions string
+	// The path of the MCP endpoint, the query string is ignored. Default is /mcp
+	Path string
+	// No session is created on initialize, so the requests can go to any gateway without the session id
+	Stateless bool
+	// The sessions expire after being idle for the time, default is 1h
+	SessionTTL time.Duration
+	// The maximum number of the live sessions, initialize is rejected with 503 once they are all taken, default is 10000.
+	// A session takes no slot until the first request after initialize
+	MaxSessions int
+	// Reply in a text/event-stream with a single event if the client accepts it, instead of application/json
+	SSE bool
+}
+
+type Tool struct {
+	Name        string `json:"name"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	// The JSON schema of the arguments, default is {"type": "object"}
+	InputSchema  json.RawMessage `json:"inputSchema"`
+	OutputSchema json.RawMessage `json:"outputSchema,omitempty"`
+}
+
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+// ResourceContents is the content of a resource, either the text or the base64 encoded blob.
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"`
+}
+
+// Content is a content of the tool result, the type is one of text, image, audio or resource.
+type Content struct {
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+	// The base64 encoded data of the image or the audio
+	Data     string            `json:"data,omitempty"`
+	MimeType string            `json:"mimeType,omitempty"`
+	Resource *ResourceContents `json:"resource,omitempty"`
+}
+
+type ToolResult struct {
+	Content           []Content   `json:"content"`
+	StructuredContent interface{} `json:"structuredContent,omitempty"`
+	// The tool failed, the content tells the model why
+	IsError bool `json:"isError,omitempty"`
+}
+
+func TextResult(text string) ToolResult {
+	return ToolResult{Content: []Content{{Type: "text", Text: text}}}
+}
+
+func ErrorResult(err error) ToolResult {
+	return ToolResult{Content: []Content{{Type: "text", Text: err.Error()}}, IsError: true}
+}
+
+// ToolCall is a call of the tool, it must be replied once, either in the handler or later.
+type ToolCall struct {
+	Context   wrapper.HttpContext
+	SessionID string
+	Name      string
+	Arguments gjson.Result
+	reply     func(result interface{}, err *Error)
+}
+
+func (c *ToolCall) Reply(result ToolResult) {
+	if result.Content == nil {
+		result.Content = []Content{}
+	}
+	c.reply(result, nil)
+}
+
+// ReplyError replies the error of the tool to the model, as a result with isError set.
+func (c *ToolCall) ReplyError(err error) {
+	c.Reply(ErrorResult(err))
+}
+
+// DecodeArguments decodes the arguments with encoding/json.
+func (c *ToolCall) DecodeArguments(args interface{}) error {
+	raw := c.Arguments.Raw
+	if raw == "" {
+		raw = "{}"
+	}
+	return json.Unmarshal([]byte(raw), args)
+}
+
+type ToolHandler func(call *ToolCall)
+
+// ResourceRead is a read of the resource, it must be replied once, either in the handler or later.
+type ResourceRead struct {
+	Context   wrapper.HttpContext
+	SessionID string
+	URI       string
+	reply     func(result interface{}, err *Error)
+}
+
+func (r *ResourceRead) Reply(contents ...ResourceContents) {
+	if contents == nil {
+		contents = []ResourceContents{}
+	}
+	r.reply(map[string]interface{}{"contents": contents}, nil)
+}
+
+func (r *ResourceRead) ReplyError(err error) {
+	r.reply(nil, NewError(ErrorCodeInternal, "%v", err))
+}
+
+type ResourceHandler func(read *ResourceRead)
+
+type registeredTool struct {
+	tool    Tool
+	handler ToolHandler
+}
+
+type registeredResource struct {
+	resource Resource
+	handler  ResourceHandler
+}
+
+type Server struct {
+	config    ServerConfig
+	tools     []registeredTool
+	resources []registeredResource
+}
+
+func NewServer(config ServerConfig) (*Server, error) {
+	if config.Info.Name == "" {
+		return nil, errors.New("mcp server name is empty")
+	}
+	if config.Path == "" {
+		config.Path = "/mcp"
+	}
+	if config.SessionTTL <= 0 {
+		config.SessionTTL = time.Hour
+	}
+	if config.MaxSessions <= 0 {
+		config.MaxSessions = 10000
+	}
+	return &Server{config: config}, nil
+}
+
+func (s *Server) AddTool(tool Tool, handler ToolHandler) error {
+	if tool.Name == "" {
+		return errors.New("tool name is empty")
+	}
+	if handler == nil {
+		return fmt.Errorf("handler of tool %s is nil", tool.Name)
+	}
+	if s.tool(tool.Name) != nil {
+		return fmt.Errorf("tool %s is already added", tool.Name)
+	}
+	if len(tool.InputSchema) == 0 {
+		tool.InputSchema = json.RawMessage(`{"type":"object"}`)
+	} else if !json.Valid(tool.InputSchema) {
+		return fmt.Errorf("input schema of tool %s is invalid json", tool.Name)
+	}
+	s.tools = append(s.tools, registeredTool{tool: tool, handler: handler})
+	return nil
+}
+
+// AddTypedTool adds the tool with the arguments decoded into Args, the call fails with invalid params if they
+// can't be decoded.
+func AddTypedTool[Args any](s *Server, tool Tool, handler func(call *ToolCall, args Args)) error {
+	return s.AddTool(tool, func(call *ToolCall) {
+		var args Args
+		if err := call.DecodeArguments(&args); err != nil {
+			call.reply(nil, NewError(ErrorCodeInvalidParams, "invalid arguments of tool %s: %v", call.Name, err))
+			return
+		}
+		handler(call, args)
+	})
+}
+
+func (s *Server) AddResource(resource Resource, handler ResourceHandler) error {
+	if resource.URI == "" || resource.Name == "" {
+		return errors.New("resource uri or name is empty")
+	}
+	if handler == nil {
+		return fmt.Errorf("handler of resource %s is nil", resource.URI)
+	}
+	if s.resource(resource.URI) != nil {
+		return fmt.Errorf("resource %s is already added", resource.URI)
+	}
+	s.resources = append(s.resources, registeredResource{resource: resource, handler: handler})
+	return nil
+}
+
+func (s *Server) tool(name string) *registeredTool {
+	for i := range s.tools {
+		if s.tools[i].tool.Name == name {
+			return &s.tools[i]
+		}
+	}
+	return nil
+}
+
+func (s *Server) resource(uri string) *registeredResource {
+	for i := range s.resources {
+		if s.resources[i].resource.URI == uri {
+			return &s.resources[i]
+		}
+	}
+	return nil
+}
+
+// negotiateVersion returns the version requested by the client if it's supported, or the latest one.
+func negotiateVersion(requested string) string {
+	for _, version := range SupportedProtocolVersions {
+		if version == requested {
+			return version
+		}
+	}
+	return SupportedProtocolVersions[0]
+}
+
+// handle handles the request, the reply is called once with the response, or with nil for the notifications.
+func (s *Server) handle(ctx wrapper.HttpContext, sessionID string, request *Request, reply func(*Response)) {
+	if err := request.validate(); err != nil {
+		reply(newErrorResponse(request.ID, err))
+		return
+	}
+	if request.IsNotification() {
+		// notifications/initialized, notifications/cancelled and so on need no action
+		reply(nil)
+		return
+	}
+	replied := false
+	respond := func(result interface{}, err *Error) {
+		if replied {
+			return
+		}
+		replied = true
+		if err != nil {
+			reply(newErrorResponse(request.ID, err))
+		} else {
+			reply(newResult(request.ID, result))
+		}
+	}
+	params := gjson.ParseBytes(request.Params)
+	switch request.Method {
+	case "initialize":
+		respond(s.initializeResult(params.Get("protocolVersion").String()), nil)
+	case "ping":
+		respond(nil, nil)
+	case "tools/list":
+		tools := make([]Tool, 0, len(s.tools))
+		for _, t := range s.tools {
+			tools = append(tools, t.tool)
+		}
+		respond(map[string]interface{}{"tools": tools}, nil)
+	case "tools/call":
+		name := params.Get("name").String()
+		t := s.tool(name)
+		if t == nil {
+			respond(nil, NewError(ErrorCodeInvalidParams, "unknown tool: %s", name))
+			return
+		}
+		t.handler(&ToolCall{Context: ctx, SessionID: sessionID, Name: name, Arguments: params.Get("arguments"), reply: respond})
+	case "resources/list":
+		resources := make([]Resource, 0, len(s.resources))
+		for _, r := range s.resources {
+			resources = append(resources, r.resource)
+		}
+		respond(map[string]interface{}{"resources": resources}, nil)
+	case "resources/templates/list":
+		respond(map[string]interface{}{"resourceTemplates": []interface{}{}}, nil)
+	case "resources/read":
+		uri := params.Get("uri").String()
+		r := s.resource(uri)
+		if r == nil {
+			respond(nil, &Error{Code: ErrorCodeResourceNotFound, Message: "resource not found", Data: map[string]string{"uri": uri}})
+			return
+		}
+		r.handler(&ResourceRead{Context: ctx, SessionID: sessionID, URI: uri, reply: respond})
+	default:
+		respond(nil, NewError(ErrorCodeMethodNotFound, "method not found: %s", request.Method))
+	}
+}
+
+func (s *Server) initializeResult(requestedVersion string) map[string]interface{} {
+	capabilities := map[string]interface{}{}
+	if len(s.tools) > 0 {
+		capabilities["tools"] = map[string]bool{"listChanged": false}
+	}
+	if len(s.resources) > 0 {
+		capabilities["resources"] = map[string]bool{"subscribe": false, "listChanged": false}
+	}
+	result := map[string]interface{}{
+		"protocolVersion": negotiateVersion(requestedVersion),
+		"capabilities":    capabilities,
+		"serverInfo":      s.config.Info,
+	}
+	if s.config.Instructions != "" {
+		result["instructions"] = s.config.Instructions
+	}
+	return result
+}
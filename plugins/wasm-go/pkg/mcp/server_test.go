@@ -0,0 +1,261 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mcp
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper/test"
+)
+
+type forecastArgs struct {
+	City string `json:"city"`
+}
+
+type testConfig struct {
+	server *Server
+}
+
+func newTestServer(t *testing.T, config ServerConfig, pending *[]*ToolCall) *Server {
+	server, err := NewServer(config)
+	require.NoError(t, err)
+	require.NoError(t, AddTypedTool(server, Tool{Name: "forecast", Description: "weather forecast"}, func(call *ToolCall, args forecastArgs) {
+		if args.City == "" {
+			call.ReplyError(errors.New("city is required"))
+			return
+		}
+		call.Reply(TextResult("sunny in " + args.City))
+	}))
+	require.NoError(t, server.AddTool(Tool{Name: "slow"}, func(call *ToolCall) {
+		*pending = append(*pending, call)
+	}))
+	require.NoError(t, server.AddResource(Resource{URI: "file:///readme", Name: "readme", MimeType: "text/plain"}, func(read *ResourceRead) {
+		read.Reply(ResourceContents{URI: read.URI, MimeType: "text/plain", Text: "hello"})
+	}))
+	assert.Error(t, server.AddTool(Tool{Name: "slow"}, func(call *ToolCall) {}))
+	assert.Error(t, server.AddTool(Tool{Name: "bad", InputSchema: []byte("{")}, func(call *ToolCall) {}))
+	return server
+}
+
+func newMcpHost(t *testing.T, config ServerConfig, pending *[]*ToolCall) (*test.Host, func()) {
+	server := newTestServer(t, config, pending)
+	host, reset := test.NewHost(wrapper.NewCommonVmCtx("mcp-server",
+		wrapper.ParseConfigBy(func(json gjson.Result, config *testConfig, log wrapper.Log) error {
+			config.server = server
+			return nil
+		}),
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config testConfig, log wrapper.Log) types.Action {
+			return config.server.OnRequestHeaders(ctx)
+		}),
+		wrapper.ProcessRequestBodyBy(func(ctx wrapper.HttpContext, config testConfig, body []byte, log wrapper.Log) types.Action {
+			return config.server.OnRequestBody(ctx, body)
+		}),
+	))
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{}`)))
+	return host, reset
+}
+
+func post(host *test.Host, sessionID string, body string, headers ...[2]string) *test.HttpStream {
+	stream := host.NewHttpStream()
+	headers = append(headers, [2]string{":authority", "example.com"}, [2]string{":path", "/mcp"}, [2]string{":method", "POST"},
+		[2]string{"accept", "application/json, text/event-stream"}, [2]string{"content-type", "application/json"})
+	if sessionID != "" {
+		headers = append(headers, [2]string{SessionIDHeader, sessionID})
+	}
+	if stream.CallOnRequestHeaders(headers, false) == types.ActionPause && stream.LocalResponse() == nil {
+		stream.CallOnRequestBody([]byte(body), true)
+	}
+	return stream
+}
+
+func header(response *test.LocalResponse, key string) string {
+	for _, h := range response.Headers {
+		if h[0] == key {
+			return h[1]
+		}
+	}
+	return ""
+}
+
+func TestServer(t *testing.T) {
+	var pending []*ToolCall
+	host, reset := newMcpHost(t, ServerConfig{Info: Implementation{Name: "weather", Version: "1.0.0"}}, &pending)
+	defer reset()
+
+	stream := post(host, "", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"client","version":"1"}}}`)
+	response := stream.LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, uint32(http.StatusOK), response.StatusCode)
+	result := gjson.GetBytes(response.Body, "result")
+	assert.Equal(t, "2025-03-26", result.Get("protocolVersion").String())
+	assert.Equal(t, "weather", result.Get("serverInfo.name").String())
+	assert.True(t, result.Get("capabilities.tools").Exists())
+	assert.True(t, result.Get("capabilities.resources").Exists())
+	sessionID := header(response, SessionIDHeader)
+	require.NotEmpty(t, sessionID)
+
+	response = post(host, sessionID, `{"jsonrpc":"2.0","method":"notifications/initialized"}`).LocalResponse()
+	assert.Equal(t, uint32(http.StatusAccepted), response.StatusCode)
+	assert.Empty(t, response.Body)
+
+	response = post(host, "", `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`).LocalResponse()
+	assert.Equal(t, uint32(http.StatusBadRequest), response.StatusCode)
+	response = post(host, "unknown", `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`).LocalResponse()
+	assert.Equal(t, uint32(http.StatusNotFound), response.StatusCode)
+
+	response = post(host, sessionID, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`).LocalResponse()
+	tools := gjson.GetBytes(response.Body, "result.tools").Array()
+	require.Len(t, tools, 2)
+	assert.Equal(t, "forecast", tools[0].Get("name").String())
+	assert.Equal(t, "object", tools[0].Get("inputSchema.type").String())
+
+	response = post(host, sessionID, `[
+		{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"forecast","arguments":{"city":"Hangzhou"}}},
+		{"jsonrpc":"2.0","id":"4","method":"tools/call","params":{"name":"forecast","arguments":{}}},
+		{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"missing"}},
+		{"jsonrpc":"2.0","id":6,"method":"tools/call","params":{"name":"forecast","arguments":{"city":1}}},
+		{"jsonrpc":"2.0","id":7,"method":"resources/read","params":{"uri":"file:///readme"}},
+		{"jsonrpc":"2.0","id":8,"method":"unknown"},
+		{"jsonrpc":"2.0","method":"notifications/cancelled"}
+	]`).LocalResponse()
+	responses := gjson.ParseBytes(response.Body).Array()
+	require.Len(t, responses, 6)
+	assert.Equal(t, "sunny in Hangzhou", responses[0].Get("result.content.0.text").String())
+	assert.Equal(t, `"4"`, responses[1].Get("id").Raw)
+	assert.True(t, responses[1].Get("result.isError").Bool())
+	assert.Equal(t, int64(ErrorCodeInvalidParams), responses[2].Get("error.code").Int())
+	assert.Equal(t, int64(ErrorCodeInvalidParams), responses[3].Get("error.code").Int())
+	assert.Equal(t, "hello", responses[4].Get("result.contents.0.text").String())
+	assert.Equal(t, int64(ErrorCodeMethodNotFound), responses[5].Get("error.code").Int())
+
+	stream = post(host, sessionID, `{"jsonrpc":"2.0","id":9,"method":"tools/call","params":{"name":"slow"}}`)
+	assert.Nil(t, stream.LocalResponse())
+	require.Len(t, pending, 1)
+	pending[0].Reply(TextResult("done"))
+	pending[0].Reply(TextResult("twice"))
+	assert.Equal(t, "done", gjson.GetBytes(stream.LocalResponse().Body, "result.content.0.text").String())
+
+	response = post(host, sessionID, `{"jsonrpc":"2.0","id":10,"method":"ping"}`, [2]string{ProtocolVersionHeader, "1999-01-01"}).LocalResponse()
+	assert.Equal(t, uint32(http.StatusBadRequest), response.StatusCode)
+	response = post(host, sessionID, `{"jsonrpc":"2.0","id":10`).LocalResponse()
+	assert.Equal(t, int64(ErrorCodeParse), gjson.GetBytes(response.Body, "error.code").Int())
+
+	stream = host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/mcp"}, {":method", "DELETE"}, {SessionIDHeader, sessionID}}, true)
+	assert.Equal(t, uint32(http.StatusOK), stream.LocalResponse().StatusCode)
+	response = post(host, sessionID, `{"jsonrpc":"2.0","id":11,"method":"ping"}`).LocalResponse()
+	assert.Equal(t, uint32(http.StatusNotFound), response.StatusCode)
+
+	stream = host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/mcp"}, {":method", "GET"}}, true)
+	assert.Equal(t, uint32(http.StatusMethodNotAllowed), stream.LocalResponse().StatusCode)
+	stream = host.NewHttpStream()
+	assert.Equal(t, types.ActionContinue, stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/other"}, {":method", "GET"}}, true))
+}
+
+func TestServerMaxSessions(t *testing.T) {
+	var pending []*ToolCall
+	host, reset := newMcpHost(t, ServerConfig{Info: Implementation{Name: "weather"}, MaxSessions: 2}, &pending)
+	defer reset()
+	initialize := func() *test.LocalResponse {
+		return post(host, "", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26"}}`).LocalResponse()
+	}
+	ping := func(sessionID string) uint32 {
+		return post(host, sessionID, `{"jsonrpc":"2.0","id":2,"method":"ping"}`).LocalResponse().StatusCode
+	}
+	// the initialize requests take no slot until the sessions are used
+	var unused []string
+	for i := 0; i < 4; i++ {
+		response := initialize()
+		require.Equal(t, uint32(http.StatusOK), response.StatusCode)
+		unused = append(unused, header(response, SessionIDHeader))
+	}
+	first := header(initialize(), SessionIDHeader)
+	require.NotEmpty(t, first)
+	assert.Equal(t, uint32(http.StatusOK), ping(first))
+	second := header(initialize(), SessionIDHeader)
+	require.NotEmpty(t, second)
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, uint32(http.StatusOK), ping(second))
+	assert.Equal(t, uint32(http.StatusServiceUnavailable), initialize().StatusCode)
+	// the slots of the unused sessions are taken by others
+	for _, sessionID := range unused {
+		assert.Equal(t, uint32(http.StatusNotFound), ping(sessionID))
+	}
+
+	// the session ids can't be forged from the slots
+	parts := strings.SplitN(first, ".", 2)
+	otherSlot := map[string]string{"0": "1", "1": "0"}[parts[0]]
+	for _, sessionID := range []string{parts[0] + ".forged", otherSlot + "." + parts[1], first[:len(first)-1] + "x", "junk"} {
+		assert.Equal(t, uint32(http.StatusNotFound), ping(sessionID), sessionID)
+	}
+
+	// the slot of the deleted session is reused, and the deleted session can't take it again
+	stream := host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/mcp"}, {":method", "DELETE"}, {SessionIDHeader, first}}, true)
+	assert.Equal(t, uint32(http.StatusOK), stream.LocalResponse().StatusCode)
+	assert.Equal(t, uint32(http.StatusNotFound), ping(first))
+	fourth := header(initialize(), SessionIDHeader)
+	require.NotEmpty(t, fourth)
+	assert.Equal(t, uint32(http.StatusOK), ping(fourth))
+	assert.Equal(t, uint32(http.StatusOK), ping(second))
+	assert.Equal(t, uint32(http.StatusNotFound), ping(first))
+}
+
+func TestServerStatelessSSE(t *testing.T) {
+	var pending []*ToolCall
+	host, reset := newMcpHost(t, ServerConfig{Info: Implementation{Name: "weather"}, Stateless: true, SSE: true, Path: "/weather/mcp"}, &pending)
+	defer reset()
+	stream := host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/weather/mcp?x=1"}, {":method", "POST"}, {"accept", "application/json, text/event-stream"}}, false)
+	stream.CallOnRequestBody([]byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2099-01-01"}}`), true)
+	response := stream.LocalResponse()
+	require.NotNil(t, response)
+	assert.Empty(t, header(response, SessionIDHeader))
+	assert.Equal(t, "text/event-stream", header(response, "content-type"))
+	body := string(response.Body)
+	require.Contains(t, body, "event: message\ndata: ")
+	data := body[len("event: message\ndata: "):]
+	assert.Equal(t, SupportedProtocolVersions[0], gjson.Get(data, "result.protocolVersion").String())
+
+	stream = host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/weather/mcp"}, {":method", "POST"}}, false)
+	stream.CallOnRequestBody([]byte(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"forecast","arguments":{"city":"Beijing"}}}`), true)
+	assert.Equal(t, "application/json", header(stream.LocalResponse(), "content-type"))
+	assert.Equal(t, "sunny in Beijing", gjson.GetBytes(stream.LocalResponse().Body, "result.content.0.text").String())
+}
+
+func TestDecodeMessages(t *testing.T) {
+	requests, batch, err := decodeMessages([]byte(`[{"jsonrpc":"2.0","id":1,"result":{}},{"jsonrpc":"2.0","id":2,"method":"ping"},1]`))
+	require.Nil(t, err)
+	assert.True(t, batch)
+	require.Len(t, requests, 2)
+	assert.Equal(t, "ping", requests[0].Method)
+	assert.NotNil(t, requests[1].validate())
+	_, _, err = decodeMessages([]byte(`[]`))
+	assert.Equal(t, ErrorCodeInvalidRequest, err.Code)
+	_, _, err = decodeMessages([]byte(`{`))
+	assert.Equal(t, ErrorCodeParse, err.Code)
+	assert.NotNil(t, (&Request{JSONRPC: "2.0", ID: []byte(`{}`), Method: "ping"}).validate())
+}
@@ -0,0 +1,373 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mcp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+const (
+	SessionIDHeader       = "mcp-session-id"
+	ProtocolVersionHeader = "mcp-protocol-version"
+
+	exchangeContextKey = "__mcp_exchange__"
+	sessionKeyPrefix   = "mcp-session:"
+	secretKeyPrefix    = "mcp-session-secret:"
+	sessionProbes      = 8
+	// the first requests of a session may race to take its slot
+	sessionClaimRetries = 3
+)
+
+var errTooManySessions = NewError(ErrorCodeInternal, "too many sessions")
+
+// exchange is the MCP request being served in the stream.
+type exchange struct {
+	sse bool
+}
+
+// OnRequestHeaders should be called in the request headers phase. The requests to the MCP endpoint are served by
+// the server, it returns types.ActionPause for them, the other requests are left to the plugin.
+func (s *Server) OnRequestHeaders(ctx wrapper.HttpContext) types.Action {
+	path := ctx.Path()
+	if i := strings.IndexByte(path, '?'); i != -1 {
+		path = path[:i]
+	}
+	if path != s.config.Path {
+		return types.ActionContinue
+	}
+	switch ctx.Method() {
+	case http.MethodPost:
+		if length, _ := proxywasm.GetHttpRequestHeader("content-length"); length == "0" {
+			sendError(newErrorResponse(nil, NewError(ErrorCodeParse, "empty body")), http.StatusBadRequest)
+			return types.ActionPause
+		}
+		if version, _ := proxywasm.GetHttpRequestHeader(ProtocolVersionHeader); version != "" && negotiateVersion(version) != version {
+			sendError(newErrorResponse(nil, NewError(ErrorCodeInvalidRequest, "unsupported protocol version: %s", version)), http.StatusBadRequest)
+			return types.ActionPause
+		}
+		accept, _ := proxywasm.GetHttpRequestHeader("accept")
+		ctx.SetContext(exchangeContextKey, &exchange{sse: s.config.SSE && strings.Contains(accept, "text/event-stream")})
+		ctx.BufferRequestBody()
+		return types.ActionPause
+	case http.MethodDelete:
+		if s.config.Stateless {
+			sendStatus(http.StatusMethodNotAllowed, "mcp.method_not_allowed")
+			return types.ActionPause
+		}
+		sessionID, _ := proxywasm.GetHttpRequestHeader(SessionIDHeader)
+		if sessionID == "" {
+			sendStatus(http.StatusBadRequest, "mcp.missing_session")
+			return types.ActionPause
+		}
+		s.deleteSession(sessionID)
+		sendStatus(http.StatusOK, "mcp.session_deleted")
+		return types.ActionPause
+	default:
+		// the server never sends requests or notifications on its own, so there is no stream to GET
+		_ = proxywasm.SendHttpResponseWithDetail(http.StatusMethodNotAllowed, "mcp.method_not_allowed",
+			[][2]string{{"allow", "POST, DELETE"}}, nil, -1)
+		return types.ActionPause
+	}
+}
+
+// OnRequestBody should be called in the request body phase with the whole body, which requires the body to be
+// buffered, i.e. the plugin is set with ProcessRequestBodyBy.
+func (s *Server) OnRequestBody(ctx wrapper.HttpContext, body []byte) types.Action {
+	e, ok := ctx.GetContext(exchangeContextKey).(*exchange)
+	if !ok {
+		return types.ActionContinue
+	}
+	requests, batch, err := decodeMessages(body)
+	if err != nil {
+		sendError(newErrorResponse(nil, err), http.StatusBadRequest)
+		return types.ActionPause
+	}
+	var headers [][2]string
+	sessionID, _ := proxywasm.GetHttpRequestHeader(SessionIDHeader)
+	if initialize := findInitialize(requests); initialize != nil {
+		if len(requests) > 1 {
+			sendError(newErrorResponse(initialize.ID, NewError(ErrorCodeInvalidRequest, "initialize must not be batched")), http.StatusBadRequest)
+			return types.ActionPause
+		}
+		sessionID = ""
+		if !s.config.Stateless {
+			sessionID, err = s.createSession()
+			if err != nil {
+				status := uint32(http.StatusInternalServerError)
+				if err == errTooManySessions {
+					status = http.StatusServiceUnavailable
+				}
+				sendError(newErrorResponse(initialize.ID, err), status)
+				return types.ActionPause
+			}
+			headers = append(headers, [2]string{SessionIDHeader, sessionID})
+		}
+	} else if !s.config.Stateless {
+		if sessionID == "" {
+			sendError(newErrorResponse(nil, NewError(ErrorCodeInvalidRequest, "missing %s header", SessionIDHeader)), http.StatusBadRequest)
+			return types.ActionPause
+		}
+		if !s.touchSession(sessionID) {
+			sendError(newErrorResponse(nil, NewError(ErrorCodeInvalidRequest, "session not found")), http.StatusNotFound)
+			return types.ActionPause
+		}
+	}
+	var responses []*Response
+	pending := len(requests)
+	done := func() {
+		if len(responses) == 0 {
+			sendStatus(http.StatusAccepted, "mcp.accepted", headers...)
+			return
+		}
+		var payload interface{} = responses
+		if !batch {
+			payload = responses[0]
+		}
+		sendPayload(e, payload, headers)
+	}
+	for _, request := range requests {
+		s.handle(ctx, sessionID, request, func(response *Response) {
+			if response != nil {
+				responses = append(responses, response)
+			}
+			pending--
+			if pending == 0 {
+				done()
+			}
+		})
+	}
+	if len(requests) == 0 {
+		done()
+	}
+	return types.ActionPause
+}
+
+func findInitialize(requests []*Request) *Request {
+	for _, request := range requests {
+		if request.Method == "initialize" {
+			return request
+		}
+	}
+	return nil
+}
+
+func sendPayload(e *exchange, payload interface{}, headers [][2]string) {
+	data, _ := json.Marshal(payload)
+	if e.sse {
+		var buf bytes.Buffer
+		buf.WriteString("event: message\ndata: ")
+		buf.Write(data)
+		buf.WriteString("\n\n")
+		headers = append(headers, [2]string{"content-type", "text/event-stream"}, [2]string{"cache-control", "no-cache"})
+		data = buf.Bytes()
+	} else {
+		headers = append(headers, [2]string{"content-type", "application/json"})
+	}
+	_ = proxywasm.SendHttpResponseWithDetail(http.StatusOK, "mcp.response", headers, data, -1)
+}
+
+func sendError(response *Response, status uint32) {
+	data, _ := json.Marshal(response)
+	_ = proxywasm.SendHttpResponseWithDetail(status, "mcp.error", [][2]string{{"content-type", "application/json"}}, data, -1)
+}
+
+func sendStatus(status uint32, detail string, headers ...[2]string) {
+	_ = proxywasm.SendHttpResponseWithDetail(status, detail, headers, nil, -1)
+}
+
+// mcpSession is kept in the shared data, so the requests of a session can be served by any worker. The sessions
+// take the slots of a table of MaxSessions keys, the id of a session starts with its slot, and a slot is reused once
+// its session expires or is deleted, so the clients can't grow the shared data without bound.
+//
+// The slot is not taken on initialize but by the first request of the session, usually notifications/initialized, so
+// the initialize requests never followed take no slot. The id is signed, so only the ones issued by the server can
+// take a slot, and it must be used within SessionTTL after initialize. If another session takes the slot first, the
+// request gets 404 and the client starts a new session, as it does for an expired one.
+type mcpSession struct {
+	ID        string `json:"id"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+func (s *Server) slotKey(slot uint32) string {
+	return sessionKeyPrefix + s.config.Info.Name + ":" + strconv.FormatUint(uint64(slot), 10)
+}
+
+// sessionSecret returns the secret signing the session ids, it's kept in the shared data, so the ids are valid on
+// all the workers.
+func (s *Server) sessionSecret() ([]byte, error) {
+	key := secretKeyPrefix + s.config.Info.Name
+	secret, cas, err := proxywasm.GetSharedData(key)
+	if err == nil && len(secret) > 0 {
+		return secret, nil
+	}
+	if err != nil && !errors.Is(err, types.ErrorStatusNotFound) {
+		return nil, err
+	}
+	if secret, err = wrapper.RandomBytes(32); err != nil {
+		return nil, err
+	}
+	// another worker may have set it at the same time, the one stored wins
+	if err = proxywasm.SetSharedData(key, secret, cas); err != nil && !errors.Is(err, types.ErrorStatusCasMismatch) {
+		return nil, err
+	}
+	secret, _, err = proxywasm.GetSharedData(key)
+	return secret, err
+}
+
+func sessionMac(secret []byte, id string) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16])
+}
+
+// parseSessionID returns the key of the slot of the session and the time it was issued, ok is false if the id is not
+// issued by the server.
+func (s *Server) parseSessionID(sessionID string) (key string, issuedAt int64, ok bool) {
+	i := strings.LastIndexByte(sessionID, '.')
+	if i == -1 {
+		return "", 0, false
+	}
+	secret, err := s.sessionSecret()
+	if err != nil {
+		proxywasm.LogWarnf("failed to get mcp session secret: %v", err)
+		return "", 0, false
+	}
+	if !hmac.Equal([]byte(sessionID[i+1:]), []byte(sessionMac(secret, sessionID[:i]))) {
+		return "", 0, false
+	}
+	parts := strings.SplitN(sessionID[:i], ".", 3)
+	if len(parts) != 3 {
+		return "", 0, false
+	}
+	slot, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil || slot >= uint64(s.config.MaxSessions) {
+		return "", 0, false
+	}
+	issuedAt, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return s.slotKey(uint32(slot)), issuedAt, true
+}
+
+// createSession returns the id of a session in a free slot among the few ones following a random slot, the slot is
+// taken by the first request of the session.
+func (s *Server) createSession() (string, *Error) {
+	random, err := wrapper.RandomBytes(4)
+	if err != nil {
+		return "", NewError(ErrorCodeInternal, "failed to create session: %v", err)
+	}
+	secret, err := s.sessionSecret()
+	if err != nil {
+		return "", NewError(ErrorCodeInternal, "failed to create session: %v", err)
+	}
+	start := binary.BigEndian.Uint32(random)
+	now := time.Now().UnixMilli()
+	for i := uint32(0); i < sessionProbes; i++ {
+		slot := (start + i) % uint32(s.config.MaxSessions)
+		var session mcpSession
+		if _, err := wrapper.GetSharedDataJson(s.slotKey(slot), &session); err != nil {
+			return "", NewError(ErrorCodeInternal, "failed to create session: %v", err)
+		}
+		if session.ExpiresAt > now {
+			continue
+		}
+		id := fmt.Sprintf("%d.%d.%s", slot, now, wrapper.NewUUIDv4())
+		return id + "." + sessionMac(secret, id), nil
+	}
+	proxywasm.LogWarnf("mcp server %s has too many sessions", s.config.Info.Name)
+	return "", errTooManySessions
+}
+
+// touchSession reports whether the session is alive, the first request of the session takes its slot, and its
+// expiration is renewed once half of the ttl has passed, to save the writes of the shared data.
+func (s *Server) touchSession(sessionID string) bool {
+	key, issuedAt, ok := s.parseSessionID(sessionID)
+	if !ok {
+		return false
+	}
+	for attempt := 0; attempt < sessionClaimRetries; attempt++ {
+		var session mcpSession
+		cas, err := wrapper.GetSharedDataJson(key, &session)
+		if err != nil {
+			proxywasm.LogWarnf("failed to get mcp session %s: %v", sessionID, err)
+			return false
+		}
+		now := time.Now()
+		ttl := s.config.SessionTTL.Milliseconds()
+		if session.ID == sessionID {
+			if session.ExpiresAt <= now.UnixMilli() {
+				return false
+			}
+			if session.ExpiresAt-now.UnixMilli() < ttl/2 {
+				session.ExpiresAt = now.Add(s.config.SessionTTL).UnixMilli()
+				// another worker may have renewed it at the same time
+				_ = wrapper.SetSharedDataJson(key, session, cas)
+			}
+			return true
+		}
+		// the slot is taken by another session, or the session is not used in time
+		if session.ExpiresAt > now.UnixMilli() || issuedAt+ttl <= now.UnixMilli() {
+			return false
+		}
+		err = wrapper.SetSharedDataJson(key, mcpSession{ID: sessionID, ExpiresAt: now.Add(s.config.SessionTTL).UnixMilli()}, cas)
+		if err == nil {
+			return true
+		}
+		// another request took the slot at the same time, maybe of the same session
+		if !errors.Is(err, types.ErrorStatusCasMismatch) {
+			proxywasm.LogWarnf("failed to take the slot of mcp session %s: %v", sessionID, err)
+			return false
+		}
+	}
+	return false
+}
+
+// deleteSession frees the slot of the session, the shared data can't be deleted. The id is kept in the freed slot,
+// so the session can't take it again.
+func (s *Server) deleteSession(sessionID string) {
+	key, _, ok := s.parseSessionID(sessionID)
+	if !ok {
+		return
+	}
+	var session mcpSession
+	cas, err := wrapper.GetSharedDataJson(key, &session)
+	if err != nil {
+		proxywasm.LogWarnf("failed to delete mcp session %s: %v", sessionID, err)
+		return
+	}
+	if session.ID != sessionID && session.ExpiresAt > time.Now().UnixMilli() {
+		return
+	}
+	if err := wrapper.SetSharedDataJson(key, mcpSession{ID: sessionID}, cas); err != nil {
+		proxywasm.LogWarnf("failed to delete mcp session %s: %v", sessionID, err)
+	}
+}
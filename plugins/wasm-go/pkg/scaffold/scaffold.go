@@ -0,0 +1,173 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scaffold generates the skeleton of a new plugin: the config parsed with the rule matcher, the phases
+// wired by wrapper.SetCtx, the tests running on the fake host, and a Makefile building the wasm.
+package scaffold
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	PhaseRequestHeaders        = "request-headers"
+	PhaseRequestBody           = "request-body"
+	PhaseResponseHeaders       = "response-headers"
+	PhaseResponseBody          = "response-body"
+	PhaseStreamingResponseBody = "streaming-response-body"
+)
+
+var (
+	Phases        = []string{PhaseRequestHeaders, PhaseRequestBody, PhaseResponseHeaders, PhaseResponseBody, PhaseStreamingResponseBody}
+	DefaultPhases = []string{PhaseRequestHeaders}
+
+	pluginNamePattern = regexp.MustCompile(`^[a-z][a-z0-9]*(-[a-z0-9]+)*$`)
+
+	//go:embed templates/*.tmpl
+	templateFS embed.FS
+	templates  = template.Must(template.ParseFS(templateFS, "templates/*.tmpl"))
+)
+
+type Options struct {
+	// The name of the plugin in kebab case, e.g. my-plugin
+	Name string
+	// The directory the plugin is generated in, e.g. extensions/my-plugin
+	Dir string
+	// The root of the wasm-go module, which the go.mod of the plugin is replaced with
+	WasmGoRoot string
+	// The phases the plugin processes, DefaultPhases if empty
+	Phases []string
+	// Overwrite the existing files
+	Force bool
+}
+
+type templateData struct {
+	Name       string
+	TypeName   string
+	WasmGoPath string
+	Year       int
+	phases     map[string]bool
+}
+
+func (d templateData) Has(phase string) bool {
+	return d.phases[phase]
+}
+
+// Generate writes the files of the plugin, and returns the paths of them.
+func Generate(options Options) ([]string, error) {
+	if !pluginNamePattern.MatchString(options.Name) {
+		return nil, fmt.Errorf("invalid plugin name %q, it should be in kebab case, e.g. my-plugin", options.Name)
+	}
+	if options.Dir == "" {
+		return nil, errors.New("missing plugin dir")
+	}
+	if options.WasmGoRoot == "" {
+		return nil, errors.New("missing wasm-go root")
+	}
+	data := templateData{
+		Name:     options.Name,
+		TypeName: typeName(options.Name),
+		Year:     time.Now().Year(),
+		phases:   map[string]bool{},
+	}
+	wasmGoPath, err := filepath.Rel(options.Dir, options.WasmGoRoot)
+	if err != nil {
+		wasmGoPath, err = filepath.Abs(options.WasmGoRoot)
+		if err != nil {
+			return nil, err
+		}
+	}
+	data.WasmGoPath = filepath.ToSlash(wasmGoPath)
+	phases := options.Phases
+	if len(phases) == 0 {
+		phases = DefaultPhases
+	}
+	for _, phase := range phases {
+		if !containsPhase(phase) {
+			return nil, fmt.Errorf("unknown phase %q, the phases are %s", phase, strings.Join(Phases, ", "))
+		}
+		data.phases[phase] = true
+	}
+	if data.phases[PhaseResponseBody] && data.phases[PhaseStreamingResponseBody] {
+		return nil, errors.New("the response body can't be processed both buffered and streaming")
+	}
+
+	files := map[string][]byte{}
+	for _, tmpl := range templates.Templates() {
+		file := strings.TrimSuffix(tmpl.Name(), ".tmpl")
+		var content strings.Builder
+		if err := tmpl.Execute(&content, data); err != nil {
+			return nil, fmt.Errorf("render %s failed: %v", file, err)
+		}
+		files[file] = []byte(content.String())
+		if strings.HasSuffix(file, ".go") {
+			formatted, err := format.Source(files[file])
+			if err != nil {
+				return nil, fmt.Errorf("format %s failed: %v", file, err)
+			}
+			files[file] = formatted
+		}
+	}
+	if !options.Force {
+		for file := range files {
+			path := filepath.Join(options.Dir, file)
+			if _, err := os.Stat(path); err == nil {
+				return nil, fmt.Errorf("%s already exists", path)
+			}
+		}
+	}
+	if err := os.MkdirAll(options.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	var paths []string
+	for file := range files {
+		paths = append(paths, filepath.Join(options.Dir, file))
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		file := filepath.Base(path)
+		if err := os.WriteFile(path, files[file], 0o644); err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// typeName converts the plugin name to the prefix of the Go types, e.g. my-plugin to MyPlugin.
+func typeName(name string) string {
+	var builder strings.Builder
+	for _, word := range strings.Split(name, "-") {
+		builder.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return builder.String()
+}
+
+func containsPhase(phase string) bool {
+	for _, p := range Phases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scaffold
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "extensions", "my-plugin")
+	files, err := Generate(Options{Name: "my-plugin", Dir: dir, WasmGoRoot: root,
+		Phases: []string{PhaseRequestHeaders, PhaseStreamingResponseBody}})
+	require.NoError(t, err)
+	var names []string
+	for _, file := range files {
+		names = append(names, filepath.Base(file))
+	}
+	assert.Equal(t, []string{"Makefile", "README.md", "VERSION", "go.mod", "main.go", "main_test.go"}, names)
+
+	mainGo, err := os.ReadFile(filepath.Join(dir, "main.go"))
+	require.NoError(t, err)
+	assert.Contains(t, string(mainGo), "type MyPluginConfig struct")
+	assert.Contains(t, string(mainGo), `wrapper.ProcessRequestHeadersBy(onHttpRequestHeaders)`)
+	assert.Contains(t, string(mainGo), `wrapper.ProcessStreamingResponseBodyBy(onStreamingResponseBody)`)
+	assert.NotContains(t, string(mainGo), "onHttpResponseHeaders")
+	goMod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	require.NoError(t, err)
+	assert.Contains(t, string(goMod), "module github.com/alibaba/higress/plugins/wasm-go/extensions/my-plugin")
+	assert.Contains(t, string(goMod), "replace github.com/alibaba/higress/plugins/wasm-go => ../..")
+
+	_, err = Generate(Options{Name: "my-plugin", Dir: dir, WasmGoRoot: root})
+	assert.ErrorContains(t, err, "already exists")
+	_, err = Generate(Options{Name: "my-plugin", Dir: dir, WasmGoRoot: root, Force: true})
+	require.NoError(t, err)
+	mainGo, err = os.ReadFile(filepath.Join(dir, "main.go"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(mainGo), "onStreamingResponseBody")
+}
+
+func TestGenerateStreamingOnly(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "my-plugin")
+	_, err := Generate(Options{Name: "my-plugin", Dir: dir, WasmGoRoot: root, Phases: []string{PhaseStreamingResponseBody}})
+	require.NoError(t, err)
+	mainGo, err := os.ReadFile(filepath.Join(dir, "main.go"))
+	require.NoError(t, err)
+	// the unused imports would break the build
+	assert.NotContains(t, string(mainGo), `"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"`)
+	assert.NotContains(t, string(mainGo), `"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"`)
+}
+
+func TestGenerateInvalidOptions(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "plugin")
+	for _, options := range []Options{
+		{Name: "MyPlugin", Dir: dir, WasmGoRoot: root},
+		{Name: "my_plugin", Dir: dir, WasmGoRoot: root},
+		{Name: "my-plugin", WasmGoRoot: root},
+		{Name: "my-plugin", Dir: dir},
+		{Name: "my-plugin", Dir: dir, WasmGoRoot: root, Phases: []string{"request-trailers"}},
+		{Name: "my-plugin", Dir: dir, WasmGoRoot: root, Phases: []string{PhaseResponseBody, PhaseStreamingResponseBody}},
+	} {
+		_, err := Generate(options)
+		assert.Error(t, err, "%+v", options)
+	}
+	_, err := os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestTypeName(t *testing.T) {
+	assert.Equal(t, "MyPlugin", typeName("my-plugin"))
+	assert.Equal(t, "Ai2Proxy", typeName("ai2-proxy"))
+	assert.Equal(t, "Cors", typeName("cors"))
+}
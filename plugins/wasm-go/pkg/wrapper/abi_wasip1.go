@@ -0,0 +1,357 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build wasip1 && !tinygo
+
+package wrapper
+
+import (
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+// The sdk only calls the host ABI directly when built by TinyGo, the other builds go through the host registered
+// by internal.RegisterMockWasmHost. So for the standard Go toolchain targeting wasip1, a host forwarding the calls
+// to the imports of the proxy is registered, and the callbacks of the proxy are exported by go:wasmexport, which
+// needs Go 1.24 or later. The types mirror the ones of the internal package of the sdk, the method set of
+// wasip1Host must stay identical to internal.ProxyWasmHost.
+
+type (
+	abiStatus     uint32
+	abiLogLevel   uint32
+	abiMapType    uint32
+	abiBufferType uint32
+	abiStreamType uint32
+	abiMetricType uint32
+)
+
+type abiHost interface {
+	ProxyLog(logLevel abiLogLevel, messageData *byte, messageSize int) abiStatus
+	ProxySetProperty(pathData *byte, pathSize int, valueData *byte, valueSize int) abiStatus
+	ProxyGetProperty(pathData *byte, pathSize int, returnValueData **byte, returnValueSize *int) abiStatus
+	ProxySendLocalResponse(statusCode uint32, statusCodeDetailData *byte, statusCodeDetailsSize int, bodyData *byte, bodySize int, headersData *byte, headersSize int, grpcStatus int32) abiStatus
+	ProxyGetSharedData(keyData *byte, keySize int, returnValueData **byte, returnValueSize *int, returnCas *uint32) abiStatus
+	ProxySetSharedData(keyData *byte, keySize int, valueData *byte, valueSize int, cas uint32) abiStatus
+	ProxyRegisterSharedQueue(nameData *byte, nameSize int, returnID *uint32) abiStatus
+	ProxyResolveSharedQueue(vmIDData *byte, vmIDSize int, nameData *byte, nameSize int, returnID *uint32) abiStatus
+	ProxyDequeueSharedQueue(queueID uint32, returnValueData **byte, returnValueSize *int) abiStatus
+	ProxyEnqueueSharedQueue(queueID uint32, valueData *byte, valueSize int) abiStatus
+	ProxyGetHeaderMapValue(mapType abiMapType, keyData *byte, keySize int, returnValueData **byte, returnValueSize *int) abiStatus
+	ProxyAddHeaderMapValue(mapType abiMapType, keyData *byte, keySize int, valueData *byte, valueSize int) abiStatus
+	ProxyReplaceHeaderMapValue(mapType abiMapType, keyData *byte, keySize int, valueData *byte, valueSize int) abiStatus
+	ProxyContinueStream(streamType abiStreamType) abiStatus
+	ProxyCloseStream(streamType abiStreamType) abiStatus
+	ProxyRemoveHeaderMapValue(mapType abiMapType, keyData *byte, keySize int) abiStatus
+	ProxyGetHeaderMapPairs(mapType abiMapType, returnValueData **byte, returnValueSize *int) abiStatus
+	ProxySetHeaderMapPairs(mapType abiMapType, mapData *byte, mapSize int) abiStatus
+	ProxyGetBufferBytes(bufferType abiBufferType, start int, maxSize int, returnBufferData **byte, returnBufferSize *int) abiStatus
+	ProxySetBufferBytes(bufferType abiBufferType, start int, maxSize int, bufferData *byte, bufferSize int) abiStatus
+	ProxyHttpCall(upstreamData *byte, upstreamSize int, headerData *byte, headerSize int, bodyData *byte, bodySize int, trailersData *byte, trailersSize int, timeout uint32, calloutIDPtr *uint32) abiStatus
+	ProxyRedisInit(upstreamData *byte, upstreamSize int, usernameData *byte, usernameSize int, passwordData *byte, passwordSize int, timeout uint32) abiStatus
+	ProxyRedisCall(upstreamData *byte, upstreamSize int, queryData *byte, querySize int, calloutIDPtr *uint32) abiStatus
+	ProxyCallForeignFunction(funcNamePtr *byte, funcNameSize int, paramPtr *byte, paramSize int, returnData **byte, returnSize *int) abiStatus
+	ProxySetTickPeriodMilliseconds(period uint32) abiStatus
+	ProxySetEffectiveContext(contextID uint32) abiStatus
+	ProxyDone() abiStatus
+	ProxyDefineMetric(metricType abiMetricType, metricNameData *byte, metricNameSize int, returnMetricIDPtr *uint32) abiStatus
+	ProxyIncrementMetric(metricID uint32, offset int64) abiStatus
+	ProxyRecordMetric(metricID uint32, value uint64) abiStatus
+	ProxyGetMetric(metricID uint32, returnMetricValue *uint64) abiStatus
+}
+
+//go:linkname registerWasmHost github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.RegisterMockWasmHost
+func registerWasmHost(host abiHost) (release func())
+
+func init() {
+	var host abiHost = wasip1Host{}
+	// look up the methods by reflection to keep the linker from pruning them, since the sdk calls them through
+	// its own interface which the linker doesn't relate to the methods
+	keepMethods(host)
+	// the host is never released, as nothing else runs in the VM
+	registerWasmHost(host)
+}
+
+func keepMethods(host abiHost) {
+	hostType := reflect.TypeOf(host)
+	for i := 0; i < hostType.NumMethod(); i++ {
+		_ = hostType.Method(i)
+	}
+}
+
+//go:linkname mainMain main.main
+func mainMain()
+
+var runMainOnce sync.Once
+
+// runMain runs the main function of the plugin, which calls SetCtx, before the first callback. Unlike the TinyGo
+// builds, the main function isn't run by the Go runtime with -buildmode=c-shared.
+func runMain() {
+	runMainOnce.Do(mainMain)
+}
+
+func ptr(data *byte) unsafe.Pointer {
+	return unsafe.Pointer(data)
+}
+
+// setReturnData converts the 32-bit address and size written by the host.
+func setReturnData(data uint32, size uint32, returnData **byte, returnSize *int) {
+	*returnData = (*byte)(unsafe.Add(unsafe.Pointer(nil), uintptr(data)))
+	*returnSize = int(size)
+}
+
+// The imports of the proxy, the pointers are 32-bit addresses in the linear memory.
+
+//go:wasmimport env proxy_log
+func hostLog(logLevel uint32, messageData unsafe.Pointer, messageSize uint32) uint32
+
+//go:wasmimport env proxy_set_property
+func hostSetProperty(pathData unsafe.Pointer, pathSize uint32, valueData unsafe.Pointer, valueSize uint32) uint32
+
+//go:wasmimport env proxy_get_property
+func hostGetProperty(pathData unsafe.Pointer, pathSize uint32, returnValueData unsafe.Pointer, returnValueSize unsafe.Pointer) uint32
+
+//go:wasmimport env proxy_send_local_response
+func hostSendLocalResponse(statusCode uint32, statusCodeDetailData unsafe.Pointer, statusCodeDetailsSize uint32, bodyData unsafe.Pointer, bodySize uint32, headersData unsafe.Pointer, headersSize uint32, grpcStatus int32) uint32
+
+//go:wasmimport env proxy_get_shared_data
+func hostGetSharedData(keyData unsafe.Pointer, keySize uint32, returnValueData unsafe.Pointer, returnValueSize unsafe.Pointer, returnCas unsafe.Pointer) uint32
+
+//go:wasmimport env proxy_set_shared_data
+func hostSetSharedData(keyData unsafe.Pointer, keySize uint32, valueData unsafe.Pointer, valueSize uint32, cas uint32) uint32
+
+//go:wasmimport env proxy_register_shared_queue
+func hostRegisterSharedQueue(nameData unsafe.Pointer, nameSize uint32, returnID unsafe.Pointer) uint32
+
+//go:wasmimport env proxy_resolve_shared_queue
+func hostResolveSharedQueue(vmIDData unsafe.Pointer, vmIDSize uint32, nameData unsafe.Pointer, nameSize uint32, returnID unsafe.Pointer) uint32
+
+//go:wasmimport env proxy_dequeue_shared_queue
+func hostDequeueSharedQueue(queueID uint32, returnValueData unsafe.Pointer, returnValueSize unsafe.Pointer) uint32
+
+//go:wasmimport env proxy_enqueue_shared_queue
+func hostEnqueueSharedQueue(queueID uint32, valueData unsafe.Pointer, valueSize uint32) uint32
+
+//go:wasmimport env proxy_get_header_map_value
+func hostGetHeaderMapValue(mapType uint32, keyData unsafe.Pointer, keySize uint32, returnValueData unsafe.Pointer, returnValueSize unsafe.Pointer) uint32
+
+//go:wasmimport env proxy_add_header_map_value
+func hostAddHeaderMapValue(mapType uint32, keyData unsafe.Pointer, keySize uint32, valueData unsafe.Pointer, valueSize uint32) uint32
+
+//go:wasmimport env proxy_replace_header_map_value
+func hostReplaceHeaderMapValue(mapType uint32, keyData unsafe.Pointer, keySize uint32, valueData unsafe.Pointer, valueSize uint32) uint32
+
+//go:wasmimport env proxy_remove_header_map_value
+func hostRemoveHeaderMapValue(mapType uint32, keyData unsafe.Pointer, keySize uint32) uint32
+
+//go:wasmimport env proxy_get_header_map_pairs
+func hostGetHeaderMapPairs(mapType uint32, returnValueData unsafe.Pointer, returnValueSize unsafe.Pointer) uint32
+
+//go:wasmimport env proxy_set_header_map_pairs
+func hostSetHeaderMapPairs(mapType uint32, mapData unsafe.Pointer, mapSize uint32) uint32
+
+//go:wasmimport env proxy_get_buffer_bytes
+func hostGetBufferBytes(bufferType uint32, start uint32, maxSize uint32, returnBufferData unsafe.Pointer, returnBufferSize unsafe.Pointer) uint32
+
+//go:wasmimport env proxy_set_buffer_bytes
+func hostSetBufferBytes(bufferType uint32, start uint32, maxSize uint32, bufferData unsafe.Pointer, bufferSize uint32) uint32
+
+//go:wasmimport env proxy_continue_stream
+func hostContinueStream(streamType uint32) uint32
+
+//go:wasmimport env proxy_close_stream
+func hostCloseStream(streamType uint32) uint32
+
+//go:wasmimport env proxy_http_call
+func hostHttpCall(upstreamData unsafe.Pointer, upstreamSize uint32, headerData unsafe.Pointer, headerSize uint32, bodyData unsafe.Pointer, bodySize uint32, trailersData unsafe.Pointer, trailersSize uint32, timeout uint32, calloutIDPtr unsafe.Pointer) uint32
+
+//go:wasmimport env proxy_redis_init
+func hostRedisInit(upstreamData unsafe.Pointer, upstreamSize uint32, usernameData unsafe.Pointer, usernameSize uint32, passwordData unsafe.Pointer, passwordSize uint32, timeout uint32) uint32
+
+//go:wasmimport env proxy_redis_call
+func hostRedisCall(upstreamData unsafe.Pointer, upstreamSize uint32, queryData unsafe.Pointer, querySize uint32, calloutIDPtr unsafe.Pointer) uint32
+
+//go:wasmimport env proxy_call_foreign_function
+func hostCallForeignFunction(funcNamePtr unsafe.Pointer, funcNameSize uint32, paramPtr unsafe.Pointer, paramSize uint32, returnData unsafe.Pointer, returnSize unsafe.Pointer) uint32
+
+//go:wasmimport env proxy_set_tick_period_milliseconds
+func hostSetTickPeriodMilliseconds(period uint32) uint32
+
+//go:wasmimport env proxy_set_effective_context
+func hostSetEffectiveContext(contextID uint32) uint32
+
+//go:wasmimport env proxy_done
+func hostDone() uint32
+
+//go:wasmimport env proxy_define_metric
+func hostDefineMetric(metricType uint32, metricNameData unsafe.Pointer, metricNameSize uint32, returnMetricIDPtr unsafe.Pointer) uint32
+
+//go:wasmimport env proxy_increment_metric
+func hostIncrementMetric(metricID uint32, offset int64) uint32
+
+//go:wasmimport env proxy_record_metric
+func hostRecordMetric(metricID uint32, value uint64) uint32
+
+//go:wasmimport env proxy_get_metric
+func hostGetMetric(metricID uint32, returnMetricValue unsafe.Pointer) uint32
+
+type wasip1Host struct{}
+
+func (wasip1Host) ProxyLog(logLevel abiLogLevel, messageData *byte, messageSize int) abiStatus {
+	return abiStatus(hostLog(uint32(logLevel), ptr(messageData), uint32(messageSize)))
+}
+
+func (wasip1Host) ProxySetProperty(pathData *byte, pathSize int, valueData *byte, valueSize int) abiStatus {
+	return abiStatus(hostSetProperty(ptr(pathData), uint32(pathSize), ptr(valueData), uint32(valueSize)))
+}
+
+func (wasip1Host) ProxyGetProperty(pathData *byte, pathSize int, returnValueData **byte, returnValueSize *int) abiStatus {
+	var data, size uint32
+	status := hostGetProperty(ptr(pathData), uint32(pathSize), unsafe.Pointer(&data), unsafe.Pointer(&size))
+	setReturnData(data, size, returnValueData, returnValueSize)
+	return abiStatus(status)
+}
+
+func (wasip1Host) ProxySendLocalResponse(statusCode uint32, statusCodeDetailData *byte, statusCodeDetailsSize int, bodyData *byte, bodySize int, headersData *byte, headersSize int, grpcStatus int32) abiStatus {
+	return abiStatus(hostSendLocalResponse(statusCode, ptr(statusCodeDetailData), uint32(statusCodeDetailsSize),
+		ptr(bodyData), uint32(bodySize), ptr(headersData), uint32(headersSize), grpcStatus))
+}
+
+func (wasip1Host) ProxyGetSharedData(keyData *byte, keySize int, returnValueData **byte, returnValueSize *int, returnCas *uint32) abiStatus {
+	var data, size uint32
+	status := hostGetSharedData(ptr(keyData), uint32(keySize), unsafe.Pointer(&data), unsafe.Pointer(&size), unsafe.Pointer(returnCas))
+	setReturnData(data, size, returnValueData, returnValueSize)
+	return abiStatus(status)
+}
+
+func (wasip1Host) ProxySetSharedData(keyData *byte, keySize int, valueData *byte, valueSize int, cas uint32) abiStatus {
+	return abiStatus(hostSetSharedData(ptr(keyData), uint32(keySize), ptr(valueData), uint32(valueSize), cas))
+}
+
+func (wasip1Host) ProxyRegisterSharedQueue(nameData *byte, nameSize int, returnID *uint32) abiStatus {
+	return abiStatus(hostRegisterSharedQueue(ptr(nameData), uint32(nameSize), unsafe.Pointer(returnID)))
+}
+
+func (wasip1Host) ProxyResolveSharedQueue(vmIDData *byte, vmIDSize int, nameData *byte, nameSize int, returnID *uint32) abiStatus {
+	return abiStatus(hostResolveSharedQueue(ptr(vmIDData), uint32(vmIDSize), ptr(nameData), uint32(nameSize), unsafe.Pointer(returnID)))
+}
+
+func (wasip1Host) ProxyDequeueSharedQueue(queueID uint32, returnValueData **byte, returnValueSize *int) abiStatus {
+	var data, size uint32
+	status := hostDequeueSharedQueue(queueID, unsafe.Pointer(&data), unsafe.Pointer(&size))
+	setReturnData(data, size, returnValueData, returnValueSize)
+	return abiStatus(status)
+}
+
+func (wasip1Host) ProxyEnqueueSharedQueue(queueID uint32, valueData *byte, valueSize int) abiStatus {
+	return abiStatus(hostEnqueueSharedQueue(queueID, ptr(valueData), uint32(valueSize)))
+}
+
+func (wasip1Host) ProxyGetHeaderMapValue(mapType abiMapType, keyData *byte, keySize int, returnValueData **byte, returnValueSize *int) abiStatus {
+	var data, size uint32
+	status := hostGetHeaderMapValue(uint32(mapType), ptr(keyData), uint32(keySize), unsafe.Pointer(&data), unsafe.Pointer(&size))
+	setReturnData(data, size, returnValueData, returnValueSize)
+	return abiStatus(status)
+}
+
+func (wasip1Host) ProxyAddHeaderMapValue(mapType abiMapType, keyData *byte, keySize int, valueData *byte, valueSize int) abiStatus {
+	return abiStatus(hostAddHeaderMapValue(uint32(mapType), ptr(keyData), uint32(keySize), ptr(valueData), uint32(valueSize)))
+}
+
+func (wasip1Host) ProxyReplaceHeaderMapValue(mapType abiMapType, keyData *byte, keySize int, valueData *byte, valueSize int) abiStatus {
+	return abiStatus(hostReplaceHeaderMapValue(uint32(mapType), ptr(keyData), uint32(keySize), ptr(valueData), uint32(valueSize)))
+}
+
+func (wasip1Host) ProxyContinueStream(streamType abiStreamType) abiStatus {
+	return abiStatus(hostContinueStream(uint32(streamType)))
+}
+
+func (wasip1Host) ProxyCloseStream(streamType abiStreamType) abiStatus {
+	return abiStatus(hostCloseStream(uint32(streamType)))
+}
+
+func (wasip1Host) ProxyRemoveHeaderMapValue(mapType abiMapType, keyData *byte, keySize int) abiStatus {
+	return abiStatus(hostRemoveHeaderMapValue(uint32(mapType), ptr(keyData), uint32(keySize)))
+}
+
+func (wasip1Host) ProxyGetHeaderMapPairs(mapType abiMapType, returnValueData **byte, returnValueSize *int) abiStatus {
+	var data, size uint32
+	status := hostGetHeaderMapPairs(uint32(mapType), unsafe.Pointer(&data), unsafe.Pointer(&size))
+	setReturnData(data, size, returnValueData, returnValueSize)
+	return abiStatus(status)
+}
+
+func (wasip1Host) ProxySetHeaderMapPairs(mapType abiMapType, mapData *byte, mapSize int) abiStatus {
+	return abiStatus(hostSetHeaderMapPairs(uint32(mapType), ptr(mapData), uint32(mapSize)))
+}
+
+func (wasip1Host) ProxyGetBufferBytes(bufferType abiBufferType, start int, maxSize int, returnBufferData **byte, returnBufferSize *int) abiStatus {
+	var data, size uint32
+	status := hostGetBufferBytes(uint32(bufferType), uint32(start), uint32(maxSize), unsafe.Pointer(&data), unsafe.Pointer(&size))
+	setReturnData(data, size, returnBufferData, returnBufferSize)
+	return abiStatus(status)
+}
+
+func (wasip1Host) ProxySetBufferBytes(bufferType abiBufferType, start int, maxSize int, bufferData *byte, bufferSize int) abiStatus {
+	return abiStatus(hostSetBufferBytes(uint32(bufferType), uint32(start), uint32(maxSize), ptr(bufferData), uint32(bufferSize)))
+}
+
+func (wasip1Host) ProxyHttpCall(upstreamData *byte, upstreamSize int, headerData *byte, headerSize int, bodyData *byte, bodySize int, trailersData *byte, trailersSize int, timeout uint32, calloutIDPtr *uint32) abiStatus {
+	return abiStatus(hostHttpCall(ptr(upstreamData), uint32(upstreamSize), ptr(headerData), uint32(headerSize),
+		ptr(bodyData), uint32(bodySize), ptr(trailersData), uint32(trailersSize), timeout, unsafe.Pointer(calloutIDPtr)))
+}
+
+func (wasip1Host) ProxyRedisInit(upstreamData *byte, upstreamSize int, usernameData *byte, usernameSize int, passwordData *byte, passwordSize int, timeout uint32) abiStatus {
+	return abiStatus(hostRedisInit(ptr(upstreamData), uint32(upstreamSize), ptr(usernameData), uint32(usernameSize),
+		ptr(passwordData), uint32(passwordSize), timeout))
+}
+
+func (wasip1Host) ProxyRedisCall(upstreamData *byte, upstreamSize int, queryData *byte, querySize int, calloutIDPtr *uint32) abiStatus {
+	return abiStatus(hostRedisCall(ptr(upstreamData), uint32(upstreamSize), ptr(queryData), uint32(querySize), unsafe.Pointer(calloutIDPtr)))
+}
+
+func (wasip1Host) ProxyCallForeignFunction(funcNamePtr *byte, funcNameSize int, paramPtr *byte, paramSize int, returnData **byte, returnSize *int) abiStatus {
+	var data, size uint32
+	status := hostCallForeignFunction(ptr(funcNamePtr), uint32(funcNameSize), ptr(paramPtr), uint32(paramSize), unsafe.Pointer(&data), unsafe.Pointer(&size))
+	setReturnData(data, size, returnData, returnSize)
+	return abiStatus(status)
+}
+
+func (wasip1Host) ProxySetTickPeriodMilliseconds(period uint32) abiStatus {
+	return abiStatus(hostSetTickPeriodMilliseconds(period))
+}
+
+func (wasip1Host) ProxySetEffectiveContext(contextID uint32) abiStatus {
+	return abiStatus(hostSetEffectiveContext(contextID))
+}
+
+func (wasip1Host) ProxyDone() abiStatus {
+	return abiStatus(hostDone())
+}
+
+func (wasip1Host) ProxyDefineMetric(metricType abiMetricType, metricNameData *byte, metricNameSize int, returnMetricIDPtr *uint32) abiStatus {
+	return abiStatus(hostDefineMetric(uint32(metricType), ptr(metricNameData), uint32(metricNameSize), unsafe.Pointer(returnMetricIDPtr)))
+}
+
+func (wasip1Host) ProxyIncrementMetric(metricID uint32, offset int64) abiStatus {
+	return abiStatus(hostIncrementMetric(metricID, offset))
+}
+
+func (wasip1Host) ProxyRecordMetric(metricID uint32, value uint64) abiStatus {
+	return abiStatus(hostRecordMetric(metricID, value))
+}
+
+func (wasip1Host) ProxyGetMetric(metricID uint32, returnMetricValue *uint64) abiStatus {
+	return abiStatus(hostGetMetric(metricID, unsafe.Pointer(returnMetricValue)))
+}
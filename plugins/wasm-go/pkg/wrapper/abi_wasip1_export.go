@@ -0,0 +1,215 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build wasip1 && !tinygo
+
+package wrapper
+
+import (
+	_ "unsafe"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+// The callbacks of the proxy, exported for the standard Go toolchain targeting wasip1 and forwarded to the sdk.
+
+//go:linkname sdkOnVMStart github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnVMStart
+func sdkOnVMStart(pluginContextID uint32, vmConfigurationSize int) types.OnVMStartStatus
+
+//go:linkname sdkOnConfigure github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnConfigure
+func sdkOnConfigure(pluginContextID uint32, pluginConfigurationSize int) types.OnPluginStartStatus
+
+//go:linkname sdkOnContextCreate github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnContextCreate
+func sdkOnContextCreate(contextID uint32, pluginContextID uint32)
+
+//go:linkname sdkOnNewConnection github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnNewConnection
+func sdkOnNewConnection(contextID uint32) types.Action
+
+//go:linkname sdkOnDownstreamData github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnDownstreamData
+func sdkOnDownstreamData(contextID uint32, dataSize int, endOfStream bool) types.Action
+
+//go:linkname sdkOnDownstreamConnectionClose github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnDownstreamConnectionClose
+func sdkOnDownstreamConnectionClose(contextID uint32, peerType types.PeerType)
+
+//go:linkname sdkOnUpstreamData github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnUpstreamData
+func sdkOnUpstreamData(contextID uint32, dataSize int, endOfStream bool) types.Action
+
+//go:linkname sdkOnUpstreamConnectionClose github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnUpstreamConnectionClose
+func sdkOnUpstreamConnectionClose(contextID uint32, peerType types.PeerType)
+
+//go:linkname sdkOnRequestHeaders github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnRequestHeaders
+func sdkOnRequestHeaders(contextID uint32, numHeaders int, endOfStream bool) types.Action
+
+//go:linkname sdkOnRequestBody github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnRequestBody
+func sdkOnRequestBody(contextID uint32, bodySize int, endOfStream bool) types.Action
+
+//go:linkname sdkOnRequestTrailers github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnRequestTrailers
+func sdkOnRequestTrailers(contextID uint32, numTrailers int) types.Action
+
+//go:linkname sdkOnResponseHeaders github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnResponseHeaders
+func sdkOnResponseHeaders(contextID uint32, numHeaders int, endOfStream bool) types.Action
+
+//go:linkname sdkOnResponseBody github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnResponseBody
+func sdkOnResponseBody(contextID uint32, bodySize int, endOfStream bool) types.Action
+
+//go:linkname sdkOnResponseTrailers github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnResponseTrailers
+func sdkOnResponseTrailers(contextID uint32, numTrailers int) types.Action
+
+//go:linkname sdkOnHttpCallResponse github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnHttpCallResponse
+func sdkOnHttpCallResponse(pluginContextID, calloutID uint32, numHeaders, bodySize, numTrailers int)
+
+//go:linkname sdkOnRedisCallResponse github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.proxyOnRedisCallResponse
+func sdkOnRedisCallResponse(pluginContextID, calloutID uint32, status, responseSize int)
+
+//go:linkname sdkOnQueueReady github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnQueueReady
+func sdkOnQueueReady(contextID, queueID uint32)
+
+//go:linkname sdkOnTick github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnTick
+func sdkOnTick(pluginContextID uint32)
+
+//go:linkname sdkOnLog github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnLog
+func sdkOnLog(contextID uint32)
+
+//go:linkname sdkOnDone github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnDone
+func sdkOnDone(contextID uint32) bool
+
+//go:linkname sdkOnDelete github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnDelete
+func sdkOnDelete(contextID uint32)
+
+//go:wasmexport proxy_abi_version_0_2_0
+func proxyABIVersion() {}
+
+//go:wasmexport proxy_on_memory_allocate
+func proxyOnMemoryAllocate(size uint32) *byte {
+	if size == 0 {
+		return nil
+	}
+	buf := make([]byte, size)
+	return &buf[0]
+}
+
+//go:wasmexport proxy_on_vm_start
+func proxyOnVMStart(pluginContextID uint32, vmConfigurationSize uint32) uint32 {
+	runMain()
+	return boolToUint32(bool(sdkOnVMStart(pluginContextID, int(vmConfigurationSize))))
+}
+
+//go:wasmexport proxy_on_configure
+func proxyOnConfigure(pluginContextID uint32, pluginConfigurationSize uint32) uint32 {
+	runMain()
+	return boolToUint32(bool(sdkOnConfigure(pluginContextID, int(pluginConfigurationSize))))
+}
+
+//go:wasmexport proxy_on_context_create
+func proxyOnContextCreate(contextID uint32, pluginContextID uint32) {
+	runMain()
+	sdkOnContextCreate(contextID, pluginContextID)
+}
+
+//go:wasmexport proxy_on_new_connection
+func proxyOnNewConnection(contextID uint32) uint32 {
+	return uint32(sdkOnNewConnection(contextID))
+}
+
+//go:wasmexport proxy_on_downstream_data
+func proxyOnDownstreamData(contextID uint32, dataSize uint32, endOfStream uint32) uint32 {
+	return uint32(sdkOnDownstreamData(contextID, int(dataSize), endOfStream != 0))
+}
+
+//go:wasmexport proxy_on_downstream_connection_close
+func proxyOnDownstreamConnectionClose(contextID uint32, peerType uint32) {
+	sdkOnDownstreamConnectionClose(contextID, types.PeerType(peerType))
+}
+
+//go:wasmexport proxy_on_upstream_data
+func proxyOnUpstreamData(contextID uint32, dataSize uint32, endOfStream uint32) uint32 {
+	return uint32(sdkOnUpstreamData(contextID, int(dataSize), endOfStream != 0))
+}
+
+//go:wasmexport proxy_on_upstream_connection_close
+func proxyOnUpstreamConnectionClose(contextID uint32, peerType uint32) {
+	sdkOnUpstreamConnectionClose(contextID, types.PeerType(peerType))
+}
+
+//go:wasmexport proxy_on_request_headers
+func proxyOnRequestHeaders(contextID uint32, numHeaders uint32, endOfStream uint32) uint32 {
+	return uint32(sdkOnRequestHeaders(contextID, int(numHeaders), endOfStream != 0))
+}
+
+//go:wasmexport proxy_on_request_body
+func proxyOnRequestBody(contextID uint32, bodySize uint32, endOfStream uint32) uint32 {
+	return uint32(sdkOnRequestBody(contextID, int(bodySize), endOfStream != 0))
+}
+
+//go:wasmexport proxy_on_request_trailers
+func proxyOnRequestTrailers(contextID uint32, numTrailers uint32) uint32 {
+	return uint32(sdkOnRequestTrailers(contextID, int(numTrailers)))
+}
+
+//go:wasmexport proxy_on_response_headers
+func proxyOnResponseHeaders(contextID uint32, numHeaders uint32, endOfStream uint32) uint32 {
+	return uint32(sdkOnResponseHeaders(contextID, int(numHeaders), endOfStream != 0))
+}
+
+//go:wasmexport proxy_on_response_body
+func proxyOnResponseBody(contextID uint32, bodySize uint32, endOfStream uint32) uint32 {
+	return uint32(sdkOnResponseBody(contextID, int(bodySize), endOfStream != 0))
+}
+
+//go:wasmexport proxy_on_response_trailers
+func proxyOnResponseTrailers(contextID uint32, numTrailers uint32) uint32 {
+	return uint32(sdkOnResponseTrailers(contextID, int(numTrailers)))
+}
+
+//go:wasmexport proxy_on_http_call_response
+func proxyOnHttpCallResponse(pluginContextID, calloutID uint32, numHeaders, bodySize, numTrailers uint32) {
+	sdkOnHttpCallResponse(pluginContextID, calloutID, int(numHeaders), int(bodySize), int(numTrailers))
+}
+
+//go:wasmexport proxy_on_redis_call_response
+func proxyOnRedisCallResponse(pluginContextID, calloutID uint32, status, responseSize uint32) {
+	sdkOnRedisCallResponse(pluginContextID, calloutID, int(int32(status)), int(responseSize))
+}
+
+//go:wasmexport proxy_on_queue_ready
+func proxyOnQueueReady(contextID, queueID uint32) {
+	sdkOnQueueReady(contextID, queueID)
+}
+
+//go:wasmexport proxy_on_tick
+func proxyOnTick(pluginContextID uint32) {
+	sdkOnTick(pluginContextID)
+}
+
+//go:wasmexport proxy_on_log
+func proxyOnLog(contextID uint32) {
+	sdkOnLog(contextID)
+}
+
+//go:wasmexport proxy_on_done
+func proxyOnDone(contextID uint32) uint32 {
+	return boolToUint32(sdkOnDone(contextID))
+}
+
+func boolToUint32(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+//go:wasmexport proxy_on_delete
+func proxyOnDelete(contextID uint32) {
+	sdkOnDelete(contextID)
+}
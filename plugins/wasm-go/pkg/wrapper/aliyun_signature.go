@@ -0,0 +1,309 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+)
+
+const (
+	aliyunAcs3Algorithm  = "ACS3-HMAC-SHA256"
+	aliyunAcs3DateFormat = "2006-01-02T15:04:05Z"
+)
+
+type AliyunCredentials struct {
+	AccessKeyID     string
+	AccessKeySecret string
+	SecurityToken   string
+}
+
+type AliyunCredentialsProvider interface {
+	Credentials() (AliyunCredentials, error)
+}
+
+type StaticAliyunCredentials AliyunCredentials
+
+func (c StaticAliyunCredentials) Credentials() (AliyunCredentials, error) {
+	if c.AccessKeyID == "" || c.AccessKeySecret == "" {
+		return AliyunCredentials{}, errors.New("aliyun credentials are empty")
+	}
+	return AliyunCredentials(c), nil
+}
+
+type AliyunSigner struct {
+	Credentials AliyunCredentialsProvider
+}
+
+type AliyunRequest struct {
+	Method string
+	// The path including the query string
+	Path    string
+	Host    string
+	Headers [][2]string
+	Body    []byte
+	// The API action and version, e.g. "TextModeration" and "2022-03-02"
+	Action  string
+	Version string
+}
+
+func aliyunCanonicalHeaders(headers [][2]string, include func(name string) bool) (string, string) {
+	values := map[string]string{}
+	for _, h := range headers {
+		name := strings.ToLower(h[0])
+		if include(name) {
+			values[name] = strings.TrimSpace(h[1])
+		}
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(name + ":" + values[name] + "\n")
+	}
+	return sb.String(), strings.Join(names, ";")
+}
+
+func acs3CanonicalRequest(method, path string, query url.Values, headers [][2]string, payloadHash string) (string, string) {
+	canonicalHeaders, signedHeaders := aliyunCanonicalHeaders(headers, func(name string) bool {
+		return name == "host" || name == "content-type" || strings.HasPrefix(name, "x-acs-")
+	})
+	if path == "" {
+		path = "/"
+	}
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		awsURIEncode(path, false),
+		awsCanonicalQuery(query),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n"), signedHeaders
+}
+
+// SignAcs3 signs the request with the ACS3-HMAC-SHA256 algorithm used by the RPC and ROA style
+// APIs of the V3 SDKs, and returns the headers with the signature headers added.
+func (s *AliyunSigner) SignAcs3(req AliyunRequest, now time.Time) ([][2]string, error) {
+	credentials, err := s.Credentials.Credentials()
+	if err != nil {
+		return nil, err
+	}
+	parsedURL, err := url.Parse(req.Path)
+	if err != nil {
+		return nil, err
+	}
+	payloadHash := sha256Hex(req.Body)
+	headers := make([][2]string, 0, len(req.Headers)+8)
+	for _, h := range req.Headers {
+		if !strings.EqualFold(h[0], "authorization") {
+			headers = append(headers, h)
+		}
+	}
+	headers = append(headers,
+		[2]string{"host", req.Host},
+		[2]string{"x-acs-action", req.Action},
+		[2]string{"x-acs-version", req.Version},
+		[2]string{"x-acs-date", now.UTC().Format(aliyunAcs3DateFormat)},
+		[2]string{"x-acs-signature-nonce", uuid.New().String()},
+		[2]string{"x-acs-content-sha256", payloadHash},
+	)
+	if credentials.SecurityToken != "" {
+		headers = append(headers, [2]string{"x-acs-security-token", credentials.SecurityToken})
+	}
+	canonicalRequest, signedHeaders := acs3CanonicalRequest(req.Method, parsedURL.Path, parsedURL.Query(), headers, payloadHash)
+	stringToSign := aliyunAcs3Algorithm + "\n" + sha256Hex([]byte(canonicalRequest))
+	signature := hex.EncodeToString(hmacSha256([]byte(credentials.AccessKeySecret), stringToSign))
+	authorization := fmt.Sprintf("%s Credential=%s,SignedHeaders=%s,Signature=%s",
+		aliyunAcs3Algorithm, credentials.AccessKeyID, signedHeaders, signature)
+	// host is set from the cluster by HttpCall
+	result := make([][2]string, 0, len(headers))
+	for _, h := range headers {
+		if h[0] != "host" {
+			result = append(result, h)
+		}
+	}
+	return append(result, [2]string{"authorization", authorization}), nil
+}
+
+func roaStringToSign(method, path string, headers [][2]string) string {
+	header := func(name string) string {
+		for _, h := range headers {
+			if strings.EqualFold(h[0], name) {
+				return h[1]
+			}
+		}
+		return ""
+	}
+	canonicalHeaders, _ := aliyunCanonicalHeaders(headers, func(name string) bool {
+		return strings.HasPrefix(name, "x-acs-")
+	})
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		header("accept"),
+		header("content-md5"),
+		header("content-type"),
+		header("date"),
+	}, "\n") + "\n" + canonicalHeaders + canonicalPathAndQuery(path)
+}
+
+// SignRoa signs the request with the HMAC-SHA1 signature of the legacy ROA style APIs.
+func (s *AliyunSigner) SignRoa(req AliyunRequest, now time.Time) ([][2]string, error) {
+	credentials, err := s.Credentials.Credentials()
+	if err != nil {
+		return nil, err
+	}
+	headers := make([][2]string, 0, len(req.Headers)+8)
+	hasAccept := false
+	for _, h := range req.Headers {
+		if strings.EqualFold(h[0], "authorization") {
+			continue
+		}
+		if strings.EqualFold(h[0], "accept") {
+			hasAccept = true
+		}
+		headers = append(headers, h)
+	}
+	if !hasAccept {
+		headers = append(headers, [2]string{"accept", "application/json"})
+	}
+	if len(req.Body) > 0 {
+		headers = append(headers, [2]string{"content-md5", ContentMD5(req.Body)})
+	}
+	headers = append(headers,
+		[2]string{"date", now.UTC().Format(http.TimeFormat)},
+		[2]string{"x-acs-signature-method", "HMAC-SHA1"},
+		[2]string{"x-acs-signature-version", "1.0"},
+		[2]string{"x-acs-signature-nonce", uuid.New().String()},
+		[2]string{"x-acs-version", req.Version},
+	)
+	if credentials.SecurityToken != "" {
+		headers = append(headers, [2]string{"x-acs-security-token", credentials.SecurityToken})
+	}
+	mac := hmac.New(sha1.New, []byte(credentials.AccessKeySecret))
+	mac.Write([]byte(roaStringToSign(req.Method, req.Path, headers)))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return append(headers, [2]string{"authorization", "acs " + credentials.AccessKeyID + ":" + signature}), nil
+}
+
+// AliyunStsCredentials assumes a RAM role with the base credentials and refreshes the temporary
+// credentials on tick before they expire.
+type AliyunStsCredentials struct {
+	client          HttpClient
+	signer          *AliyunSigner
+	host            string
+	roleArn         string
+	sessionName     string
+	durationSeconds int
+	credentials     AliyunCredentials
+	expiration      time.Time
+}
+
+// NewAliyunStsCredentials should be called in parseConfig phase since it registers the refresh tick function.
+// The cluster should point to the sts endpoint, e.g. sts.cn-hangzhou.aliyuncs.com.
+func NewAliyunStsCredentials(cluster Cluster, base AliyunCredentialsProvider, roleArn, sessionName string, durationSeconds int) *AliyunStsCredentials {
+	if sessionName == "" {
+		sessionName = "higress"
+	}
+	if durationSeconds <= 0 {
+		durationSeconds = 3600
+	}
+	p := &AliyunStsCredentials{
+		client:          NewClusterClient(cluster),
+		signer:          &AliyunSigner{Credentials: base},
+		host:            cluster.HostName(),
+		roleArn:         roleArn,
+		sessionName:     sessionName,
+		durationSeconds: durationSeconds,
+	}
+	RegisteTickFunc(10000, func() {
+		// refresh five minutes before the credentials expire
+		if time.Until(p.expiration) < 5*time.Minute {
+			p.refresh()
+		}
+	})
+	return p
+}
+
+func (p *AliyunStsCredentials) Credentials() (AliyunCredentials, error) {
+	if p.credentials.AccessKeyID == "" || time.Now().After(p.expiration) {
+		return AliyunCredentials{}, errors.New("aliyun sts credentials are not available yet")
+	}
+	return p.credentials, nil
+}
+
+func (p *AliyunStsCredentials) refresh() {
+	query := url.Values{
+		"RoleArn":         {p.roleArn},
+		"RoleSessionName": {p.sessionName},
+		"DurationSeconds": {fmt.Sprint(p.durationSeconds)},
+	}
+	path := "/?" + query.Encode()
+	headers, err := p.signer.SignAcs3(AliyunRequest{
+		Method:  http.MethodPost,
+		Path:    path,
+		Host:    p.host,
+		Headers: [][2]string{{"accept", "application/json"}},
+		Action:  "AssumeRole",
+		Version: "2015-04-01",
+	}, time.Now())
+	if err != nil {
+		proxywasm.LogErrorf("failed to sign aliyun sts request: %v", err)
+		return
+	}
+	err = p.client.Post(path, headers, nil, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if statusCode != http.StatusOK {
+			proxywasm.LogErrorf("failed to assume aliyun role %s, status: %d, body: %s", p.roleArn, statusCode, responseBody)
+			return
+		}
+		var result struct {
+			Credentials struct {
+				AccessKeyId     string
+				AccessKeySecret string
+				SecurityToken   string
+				Expiration      time.Time
+			}
+		}
+		if err := json.Unmarshal(responseBody, &result); err != nil {
+			proxywasm.LogErrorf("failed to parse aliyun sts response: %v", err)
+			return
+		}
+		p.credentials = AliyunCredentials{
+			AccessKeyID:     result.Credentials.AccessKeyId,
+			AccessKeySecret: result.Credentials.AccessKeySecret,
+			SecurityToken:   result.Credentials.SecurityToken,
+		}
+		p.expiration = result.Credentials.Expiration
+		proxywasm.LogInfof("aliyun sts credentials refreshed, expiration: %s", p.expiration)
+	})
+	if err != nil {
+		proxywasm.LogErrorf("failed to assume aliyun role %s: %v", p.roleArn, err)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcs3CanonicalRequest(t *testing.T) {
+	headers := [][2]string{
+		{"Host", "green.cn-shanghai.aliyuncs.com"},
+		{"x-acs-action", "TextModeration"},
+		{"x-acs-version", "2022-03-02"},
+		{"accept", "application/json"},
+	}
+	query := url.Values{"Service": {"chat_detection"}, "ServiceParameters": {`{"content":"a b"}`}}
+	canonical, signed := acs3CanonicalRequest("post", "/", query, headers, "hash")
+	assert.Equal(t, "host;x-acs-action;x-acs-version", signed)
+	assert.Equal(t, "POST\n/\n"+
+		"Service=chat_detection&ServiceParameters=%7B%22content%22%3A%22a%20b%22%7D\n"+
+		"host:green.cn-shanghai.aliyuncs.com\nx-acs-action:TextModeration\nx-acs-version:2022-03-02\n\n"+
+		"host;x-acs-action;x-acs-version\nhash", canonical)
+}
+
+func TestAliyunSign(t *testing.T) {
+	signer := &AliyunSigner{Credentials: StaticAliyunCredentials{AccessKeyID: "ak", AccessKeySecret: "sk", SecurityToken: "token"}}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	req := AliyunRequest{Method: "POST", Path: "/?a=1", Host: "example.aliyuncs.com", Action: "Foo", Version: "2024-01-01"}
+
+	headers, err := signer.SignAcs3(req, now)
+	require.NoError(t, err)
+	values := map[string]string{}
+	for _, h := range headers {
+		values[h[0]] = h[1]
+	}
+	assert.Equal(t, "2024-01-01T00:00:00Z", values["x-acs-date"])
+	assert.Equal(t, "token", values["x-acs-security-token"])
+	assert.True(t, strings.HasPrefix(values["authorization"], "ACS3-HMAC-SHA256 Credential=ak,SignedHeaders="+
+		"host;x-acs-action;x-acs-content-sha256;x-acs-date;x-acs-security-token;x-acs-signature-nonce;x-acs-version,Signature="))
+	_, hasHost := values["host"]
+	assert.False(t, hasHost)
+
+	headers, err = signer.SignRoa(req, now)
+	require.NoError(t, err)
+	assert.Equal(t, "authorization", headers[len(headers)-1][0])
+	assert.True(t, strings.HasPrefix(headers[len(headers)-1][1], "acs ak:"))
+}
+
+func TestRoaStringToSign(t *testing.T) {
+	headers := [][2]string{
+		{"accept", "application/json"},
+		{"content-type", "application/json"},
+		{"date", "Mon, 01 Jan 2024 00:00:00 GMT"},
+		{"x-acs-version", "2019-01-01"},
+		{"x-acs-signature-method", "HMAC-SHA1"},
+	}
+	assert.Equal(t, "GET\napplication/json\n\napplication/json\nMon, 01 Jan 2024 00:00:00 GMT\n"+
+		"x-acs-signature-method:HMAC-SHA1\nx-acs-version:2019-01-01\n/apps?a=1&b=2",
+		roaStringToSign("GET", "/apps?b=2&a=1", headers))
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+const (
+	DefaultAssetCacheControl = "public, max-age=3600"
+	// The default limit of the total size of the assets, they're kept in the memory of every VM
+	DefaultAssetMaxSize = 1 << 20
+	// The assets smaller than it are not compressed
+	assetGzipMinSize = 1024
+)
+
+var ErrAssetsTooLarge = errors.New("assets exceed the max size")
+
+// assetContentTypes are the types of the common web assets, so they don't depend on the mime types of the system.
+var assetContentTypes = map[string]string{
+	".html":  "text/html; charset=utf-8",
+	".htm":   "text/html; charset=utf-8",
+	".css":   "text/css; charset=utf-8",
+	".js":    "text/javascript; charset=utf-8",
+	".mjs":   "text/javascript; charset=utf-8",
+	".json":  "application/json",
+	".map":   "application/json",
+	".txt":   "text/plain; charset=utf-8",
+	".xml":   "application/xml",
+	".svg":   "image/svg+xml",
+	".png":   "image/png",
+	".jpg":   "image/jpeg",
+	".jpeg":  "image/jpeg",
+	".gif":   "image/gif",
+	".webp":  "image/webp",
+	".ico":   "image/x-icon",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".wasm":  "application/wasm",
+}
+
+// AssetServerConfig configures NewAssetServer.
+type AssetServerConfig struct {
+	// The directory of the assets in the file system, default is the root
+	Root string
+	// The path prefix of the requests served by Match, e.g. /.well-known/higress/
+	Prefix string
+	// The cache-control header of the assets, default is DefaultAssetCacheControl
+	CacheControl string
+	// The limit of the total size of the assets, default is DefaultAssetMaxSize
+	MaxSize int
+}
+
+// Asset is a file loaded by the AssetServer.
+type Asset struct {
+	Name        string
+	ContentType string
+	Body        []byte
+	ETag        string
+	// The gzip encoded body, nil if the asset isn't worth compressing
+	gzipped []byte
+}
+
+// AssetServer serves the small static files embedded in the plugin, e.g. the scripts of a challenge page, a favicon
+// or the callback page of OIDC:
+//
+//	//go:embed assets
+//	var assetFiles embed.FS
+//
+//	assets, err := wrapper.NewAssetServer(assetFiles, wrapper.AssetServerConfig{Root: "assets", Prefix: "/.challenge/"})
+//	...
+//	if name, ok := assets.Match(ctx.Method(), ctx.Path()); ok {
+//		return assets.ServeAsset(name)
+//	}
+//
+// The files are loaded once, with the content type, the etag and the gzip encoded body computed, so serving one is
+// only a local reply. The conditional requests get 304 by If-None-Match.
+type AssetServer struct {
+	config AssetServerConfig
+	assets map[string]*Asset
+}
+
+func NewAssetServer(fsys fs.FS, config AssetServerConfig) (*AssetServer, error) {
+	if config.Root == "" {
+		config.Root = "."
+	}
+	if config.CacheControl == "" {
+		config.CacheControl = DefaultAssetCacheControl
+	}
+	if config.MaxSize <= 0 {
+		config.MaxSize = DefaultAssetMaxSize
+	}
+	if config.Prefix != "" && !strings.HasSuffix(config.Prefix, "/") {
+		config.Prefix += "/"
+	}
+	root, err := fs.Sub(fsys, config.Root)
+	if err != nil {
+		return nil, err
+	}
+	s := &AssetServer{config: config, assets: map[string]*Asset{}}
+	size := 0
+	err = fs.WalkDir(root, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		body, err := fs.ReadFile(root, name)
+		if err != nil {
+			return err
+		}
+		if size += len(body); size > config.MaxSize {
+			return fmt.Errorf("%w: %d bytes", ErrAssetsTooLarge, config.MaxSize)
+		}
+		s.assets[name] = newAsset(name, body)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func newAsset(name string, body []byte) *Asset {
+	sum := sha256.Sum256(body)
+	asset := &Asset{
+		Name:        name,
+		ContentType: assetContentTypes[strings.ToLower(path.Ext(name))],
+		Body:        body,
+		ETag:        `"` + hex.EncodeToString(sum[:16]) + `"`,
+	}
+	if asset.ContentType == "" {
+		asset.ContentType = mime.TypeByExtension(path.Ext(name))
+	}
+	if asset.ContentType == "" {
+		asset.ContentType = http.DetectContentType(body)
+	}
+	if len(body) >= assetGzipMinSize && compressible(asset.ContentType) {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(body); err == nil && w.Close() == nil && buf.Len() < len(body) {
+			asset.gzipped = buf.Bytes()
+		}
+	}
+	return asset
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return strings.HasPrefix(mediaType, "text/") || mediaType == "application/javascript" ||
+		mediaType == "application/json" || mediaType == "image/svg+xml" || strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml")
+}
+
+// Asset returns the loaded asset by its name in the root, e.g. js/challenge.js.
+func (s *AssetServer) Asset(name string) (*Asset, bool) {
+	asset, ok := s.assets[name]
+	return asset, ok
+}
+
+// Match returns the name of the asset of the request path under the prefix, the query is ignored. Only GET and HEAD
+// requests are matched.
+func (s *AssetServer) Match(method, requestPath string) (string, bool) {
+	if method != http.MethodGet && method != http.MethodHead {
+		return "", false
+	}
+	if i := strings.IndexAny(requestPath, "?#"); i >= 0 {
+		requestPath = requestPath[:i]
+	}
+	if !strings.HasPrefix(requestPath, s.config.Prefix) {
+		return "", false
+	}
+	name := strings.TrimPrefix(requestPath[len(s.config.Prefix):], "/")
+	if _, ok := s.assets[name]; !ok {
+		return "", false
+	}
+	return name, true
+}
+
+// ServeAsset sends the asset as the local reply of the current request, 404 is sent if it doesn't exist. The
+// returned action should be returned by the phase.
+func (s *AssetServer) ServeAsset(name string) types.Action {
+	asset, ok := s.assets[name]
+	if !ok {
+		_ = proxywasm.SendHttpResponseWithDetail(http.StatusNotFound, "asset_not_found", nil, []byte("Not Found"), -1)
+		return types.ActionPause
+	}
+	headers := [][2]string{
+		{"content-type", asset.ContentType},
+		{"cache-control", s.config.CacheControl},
+		{"etag", asset.ETag},
+		{"x-content-type-options", "nosniff"},
+	}
+	if asset.gzipped != nil {
+		headers = append(headers, [2]string{"vary", "accept-encoding"})
+	}
+	if ifNoneMatch, _ := proxywasm.GetHttpRequestHeader("if-none-match"); etagMatch(ifNoneMatch, asset.ETag) {
+		_ = proxywasm.SendHttpResponseWithDetail(http.StatusNotModified, "asset_not_modified", headers, nil, -1)
+		return types.ActionPause
+	}
+	body := asset.Body
+	if asset.gzipped != nil {
+		if acceptEncoding, _ := proxywasm.GetHttpRequestHeader("accept-encoding"); acceptsGzip(acceptEncoding) {
+			body = asset.gzipped
+			headers = append(headers, [2]string{"content-encoding", "gzip"})
+		}
+	}
+	if method, _ := proxywasm.GetHttpRequestHeader(":method"); method == http.MethodHead {
+		// the headers are the same as GET, without the body
+		body = nil
+	}
+	_ = proxywasm.SendHttpResponseWithDetail(http.StatusOK, "asset", headers, body, -1)
+	return types.ActionPause
+}
+
+// etagMatch compares the If-None-Match header with the etag weakly, as RFC 9110 requires.
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, item := range strings.Split(ifNoneMatch, ",") {
+		item = strings.TrimSpace(item)
+		if item == "*" || strings.TrimPrefix(item, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func acceptsGzip(acceptEncoding string) bool {
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(item, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		params = strings.ReplaceAll(params, " ", "")
+		return params != "q=0" && params != "q=0.0" && params != "q=0.00" && params != "q=0.000"
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+var ErrAuthRejected = errors.New("credentials are rejected by the auth backend")
+
+// AuthResult is the outcome of validating the credentials, a rejection is not an error.
+type AuthResult struct {
+	Authenticated bool     `json:"authenticated"`
+	Username      string   `json:"username,omitempty"`
+	Groups        []string `json:"groups,omitempty"`
+}
+
+type AuthCallback func(result AuthResult, err error)
+
+// AuthBackend validates a username and password, e.g. against a directory. The callback may be called synchronously,
+// and is not called if an error is returned.
+type AuthBackend interface {
+	Authenticate(username, password string, callback AuthCallback) error
+}
+
+const (
+	ExternalAuthModeBasic = "basic"
+	ExternalAuthModeForm  = "form"
+	ExternalAuthModeJson  = "json"
+)
+
+type ExternalAuthConfig struct {
+	Client HttpClient
+	// The url of the auth endpoint, e.g. a LDAP-to-HTTP bridge
+	URL string
+	// How the credentials are sent: basic uses the authorization header with a GET request, form and json post
+	// the credentials in the body. Default is basic.
+	Mode string
+	// The field names of the credentials in form and json mode, default are username and password
+	UsernameField string
+	PasswordField string
+	// The gjson path of a boolean in the response telling if the credentials are valid, only the status is checked
+	// if it's empty: 2xx is authenticated, 401 and 403 are rejected, and the others are errors.
+	AuthenticatedPath string
+	// The gjson paths of the canonical username and the groups of the user in the response, optional
+	UsernamePath string
+	GroupsPath   string
+	// The timeout of the auth request in milliseconds, default is 2000ms
+	Timeout uint32
+}
+
+// ExternalAuthBackend validates the credentials by calling an external auth endpoint.
+type ExternalAuthBackend struct {
+	config ExternalAuthConfig
+}
+
+func NewExternalAuthBackend(config ExternalAuthConfig) (*ExternalAuthBackend, error) {
+	if config.Client == nil || config.URL == "" {
+		return nil, errors.New("external auth client or url is empty")
+	}
+	switch config.Mode {
+	case "":
+		config.Mode = ExternalAuthModeBasic
+	case ExternalAuthModeBasic, ExternalAuthModeForm, ExternalAuthModeJson:
+	default:
+		return nil, fmt.Errorf("unknown external auth mode: %s", config.Mode)
+	}
+	if config.UsernameField == "" {
+		config.UsernameField = "username"
+	}
+	if config.PasswordField == "" {
+		config.PasswordField = "password"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 2000
+	}
+	return &ExternalAuthBackend{config: config}, nil
+}
+
+func (b *ExternalAuthBackend) buildRequest(username, password string) (string, [][2]string, []byte, error) {
+	headers := [][2]string{{"accept", "application/json"}}
+	switch b.config.Mode {
+	case ExternalAuthModeForm:
+		headers = append(headers, [2]string{"content-type", "application/x-www-form-urlencoded"})
+		body := url.Values{b.config.UsernameField: {username}, b.config.PasswordField: {password}}.Encode()
+		return http.MethodPost, headers, []byte(body), nil
+	case ExternalAuthModeJson:
+		headers = append(headers, [2]string{"content-type", "application/json"})
+		body, err := json.Marshal(map[string]string{b.config.UsernameField: username, b.config.PasswordField: password})
+		return http.MethodPost, headers, body, err
+	default:
+		headers = append(headers, [2]string{"authorization", BasicAuthorization(username, password)})
+		return http.MethodGet, headers, nil, nil
+	}
+}
+
+func (b *ExternalAuthBackend) parseResponse(username string, statusCode int, body []byte) (AuthResult, error) {
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return AuthResult{Username: username}, nil
+	case statusCode < 200 || statusCode >= 300:
+		return AuthResult{}, fmt.Errorf("external auth failed, status: %d, body: %s", statusCode, body)
+	}
+	result := AuthResult{Authenticated: true, Username: username}
+	if b.config.AuthenticatedPath == "" && b.config.UsernamePath == "" && b.config.GroupsPath == "" {
+		return result, nil
+	}
+	if !gjson.ValidBytes(body) {
+		return AuthResult{}, fmt.Errorf("invalid external auth response: %s", body)
+	}
+	if b.config.AuthenticatedPath != "" {
+		result.Authenticated = gjson.GetBytes(body, b.config.AuthenticatedPath).Bool()
+	}
+	if b.config.UsernamePath != "" {
+		if name := gjson.GetBytes(body, b.config.UsernamePath).String(); name != "" {
+			result.Username = name
+		}
+	}
+	if b.config.GroupsPath != "" {
+		for _, group := range gjson.GetBytes(body, b.config.GroupsPath).Array() {
+			result.Groups = append(result.Groups, group.String())
+		}
+	}
+	return result, nil
+}
+
+func (b *ExternalAuthBackend) Authenticate(username, password string, callback AuthCallback) error {
+	method, headers, body, err := b.buildRequest(username, password)
+	if err != nil {
+		return err
+	}
+	return b.config.Client.Call(method, b.config.URL, headers, body, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		callback(b.parseResponse(username, statusCode, responseBody))
+	}, b.config.Timeout)
+}
+
+// CachedAuthBackend caches the results of the backend in the memory of the VM, the errors are not cached.
+// The cache is not kept in shared data, where the plugins sharing the VM could read it.
+type CachedAuthBackend struct {
+	Backend AuthBackend
+	// How long an authenticated result is cached in milliseconds, default is 60000ms
+	CacheTTL int64
+	// How long a rejected result is cached in milliseconds, default is 10000ms. It spares the backend the repeated
+	// attempts of the same wrong credentials, but it doesn't slow down guessing since every guess is a new entry.
+	NegativeCacheTTL int64
+	// The maximum numbers of the authenticated and the rejected results cached, the least recently used one is
+	// evicted, default is 10000 and 1000. The rejected results are kept apart, so the wrong guesses can't evict the
+	// valid credentials.
+	MaxEntries         int
+	MaxNegativeEntries int
+	authenticated      *LRUCache[string, AuthResult]
+	rejected           *LRUCache[string, AuthResult]
+	secret             []byte
+}
+
+// The password is never stored, the cache is keyed by the HMAC of the credentials with a random secret of the VM,
+// so the keys can't be brute-forced offline.
+func (b *CachedAuthBackend) cacheKey(username, password string) string {
+	h := hmac.New(sha256.New, b.secret)
+	h.Write([]byte(username))
+	h.Write([]byte{0})
+	h.Write([]byte(password))
+	return string(h.Sum(nil))
+}
+
+func (b *CachedAuthBackend) init() error {
+	if b.authenticated != nil {
+		return nil
+	}
+	secret, err := RandomBytes(32)
+	if err != nil {
+		return err
+	}
+	size, negativeSize := b.MaxEntries, b.MaxNegativeEntries
+	if size <= 0 {
+		size = 10000
+	}
+	if negativeSize <= 0 {
+		negativeSize = 1000
+	}
+	authenticated, err := NewLRUCache[string, AuthResult](size, 0)
+	if err != nil {
+		return err
+	}
+	rejected, err := NewLRUCache[string, AuthResult](negativeSize, 0)
+	if err != nil {
+		return err
+	}
+	b.secret, b.authenticated, b.rejected = secret, authenticated, rejected
+	return nil
+}
+
+func (b *CachedAuthBackend) ttl(result AuthResult) int64 {
+	if result.Authenticated {
+		if b.CacheTTL > 0 {
+			return b.CacheTTL
+		}
+		return 60000
+	}
+	if b.NegativeCacheTTL > 0 {
+		return b.NegativeCacheTTL
+	}
+	return 10000
+}
+
+func (b *CachedAuthBackend) Authenticate(username, password string, callback AuthCallback) error {
+	if err := b.init(); err != nil {
+		proxywasm.LogWarnf("failed to init auth cache: %v", err)
+		return b.Backend.Authenticate(username, password, callback)
+	}
+	key := b.cacheKey(username, password)
+	if result, ok := b.authenticated.Get(key); ok {
+		callback(result, nil)
+		return nil
+	}
+	if result, ok := b.rejected.Get(key); ok {
+		callback(result, nil)
+		return nil
+	}
+	return b.Backend.Authenticate(username, password, func(result AuthResult, err error) {
+		if err == nil {
+			b.store(key, result)
+		}
+		callback(result, err)
+	})
+}
+
+func (b *CachedAuthBackend) store(key string, result AuthResult) {
+	ttl := time.Duration(b.ttl(result)) * time.Millisecond
+	if result.Authenticated {
+		b.authenticated.SetWithTTL(key, result, ttl)
+		return
+	}
+	b.rejected.SetWithTTL(key, result, ttl)
+}
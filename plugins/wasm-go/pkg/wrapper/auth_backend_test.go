@@ -0,0 +1,155 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAuthBackend map[string]string
+
+func (b fakeAuthBackend) Authenticate(username, password string, callback AuthCallback) error {
+	expected, ok := b[username]
+	callback(AuthResult{Authenticated: ok && expected == password, Username: username}, nil)
+	return nil
+}
+
+func TestExternalAuthBackendRequest(t *testing.T) {
+	client := NewClusterClient(FQDNCluster{FQDN: "ldap-bridge.default.svc.cluster.local", Port: 8888})
+	_, err := NewExternalAuthBackend(ExternalAuthConfig{Client: client, URL: "/auth", Mode: "ldap"})
+	assert.Error(t, err)
+
+	backend, err := NewExternalAuthBackend(ExternalAuthConfig{Client: client, URL: "/auth"})
+	require.NoError(t, err)
+	method, headers, body, err := backend.buildRequest("alice", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodGet, method)
+	assert.Contains(t, headers, [2]string{"authorization", BasicAuthorization("alice", "secret")})
+	assert.Nil(t, body)
+
+	backend, err = NewExternalAuthBackend(ExternalAuthConfig{Client: client, URL: "/auth", Mode: ExternalAuthModeJson, UsernameField: "user"})
+	require.NoError(t, err)
+	method, _, body, err = backend.buildRequest("alice", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodPost, method)
+	assert.JSONEq(t, `{"user": "alice", "password": "secret"}`, string(body))
+
+	backend, err = NewExternalAuthBackend(ExternalAuthConfig{Client: client, URL: "/auth", Mode: ExternalAuthModeForm})
+	require.NoError(t, err)
+	_, _, body, err = backend.buildRequest("alice", "p&ss")
+	require.NoError(t, err)
+	assert.Equal(t, "password=p%26ss&username=alice", string(body))
+}
+
+func TestExternalAuthBackendResponse(t *testing.T) {
+	client := NewClusterClient(FQDNCluster{FQDN: "ldap-bridge.default.svc.cluster.local", Port: 8888})
+	backend, err := NewExternalAuthBackend(ExternalAuthConfig{Client: client, URL: "/auth"})
+	require.NoError(t, err)
+
+	result, err := backend.parseResponse("alice", http.StatusNoContent, nil)
+	require.NoError(t, err)
+	assert.Equal(t, AuthResult{Authenticated: true, Username: "alice"}, result)
+	result, err = backend.parseResponse("alice", http.StatusUnauthorized, nil)
+	require.NoError(t, err)
+	assert.False(t, result.Authenticated)
+	_, err = backend.parseResponse("alice", http.StatusBadGateway, nil)
+	assert.Error(t, err)
+
+	backend, err = NewExternalAuthBackend(ExternalAuthConfig{
+		Client:            client,
+		URL:               "/auth",
+		AuthenticatedPath: "valid",
+		UsernamePath:      "user.uid",
+		GroupsPath:        "user.memberOf",
+	})
+	require.NoError(t, err)
+	result, err = backend.parseResponse("Alice", http.StatusOK, []byte(`{"valid": true, "user": {"uid": "alice", "memberOf": ["admins", "dev"]}}`))
+	require.NoError(t, err)
+	assert.Equal(t, AuthResult{Authenticated: true, Username: "alice", Groups: []string{"admins", "dev"}}, result)
+	result, err = backend.parseResponse("alice", http.StatusOK, []byte(`{"valid": false}`))
+	require.NoError(t, err)
+	assert.False(t, result.Authenticated)
+	_, err = backend.parseResponse("alice", http.StatusOK, []byte(`<html>`))
+	assert.Error(t, err)
+}
+
+type countingAuthBackend struct {
+	fakeAuthBackend
+	calls int
+}
+
+func (b *countingAuthBackend) Authenticate(username, password string, callback AuthCallback) error {
+	b.calls++
+	return b.fakeAuthBackend.Authenticate(username, password, callback)
+}
+
+func TestCachedAuthBackend(t *testing.T) {
+	backend := &countingAuthBackend{fakeAuthBackend: fakeAuthBackend{"alice": "secret"}}
+	b := &CachedAuthBackend{Backend: backend, NegativeCacheTTL: 3000, MaxEntries: 2, MaxNegativeEntries: 2}
+	require.NoError(t, b.init())
+	assert.NotEqual(t, b.cacheKey("alice", "secret"), b.cacheKey("alice", "other"))
+	assert.NotEqual(t, b.cacheKey("ab", "c"), b.cacheKey("a", "bc"))
+	assert.NotContains(t, b.cacheKey("alice", "secret"), "secret")
+	// the key depends on the secret of the VM
+	other := &CachedAuthBackend{}
+	require.NoError(t, other.init())
+	assert.NotEqual(t, b.cacheKey("alice", "secret"), other.cacheKey("alice", "secret"))
+	assert.Equal(t, int64(60000), b.ttl(AuthResult{Authenticated: true}))
+	assert.Equal(t, int64(3000), b.ttl(AuthResult{}))
+
+	var result AuthResult
+	callback := func(r AuthResult, err error) {
+		require.NoError(t, err)
+		result = r
+	}
+	require.NoError(t, b.Authenticate("alice", "secret", callback))
+	require.NoError(t, b.Authenticate("alice", "secret", callback))
+	assert.True(t, result.Authenticated)
+	assert.Equal(t, 1, backend.calls)
+
+	// the caches are bounded, the guesses evict each other but not the authenticated result
+	for _, password := range []string{"a", "b", "c"} {
+		require.NoError(t, b.Authenticate("alice", password, callback))
+		assert.False(t, result.Authenticated)
+	}
+	assert.Equal(t, 2, b.rejected.Len())
+	assert.Equal(t, 1, b.authenticated.Len())
+	require.NoError(t, b.Authenticate("alice", "secret", callback))
+	assert.True(t, result.Authenticated)
+	assert.Equal(t, 4, backend.calls)
+	require.NoError(t, b.Authenticate("alice", "c", callback))
+	assert.False(t, result.Authenticated)
+	assert.Equal(t, 4, backend.calls)
+	require.NoError(t, b.Authenticate("alice", "a", callback))
+	assert.Equal(t, 5, backend.calls)
+}
+
+func TestBasicAuthenticatorBackend(t *testing.T) {
+	authenticator := &BasicAuthenticator{Backend: fakeAuthBackend{"alice": "secret"}}
+	var username string
+	var authErr error
+	callback := func(u string, err error) { username, authErr = u, err }
+
+	require.NoError(t, authenticator.Authenticate(BasicAuthorization("alice", "secret"), callback))
+	assert.NoError(t, authErr)
+	assert.Equal(t, "alice", username)
+
+	require.NoError(t, authenticator.Authenticate(BasicAuthorization("alice", "wrong"), callback))
+	assert.ErrorIs(t, authErr, ErrAuthRejected)
+}
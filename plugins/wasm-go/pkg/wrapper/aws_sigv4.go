@@ -0,0 +1,355 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+)
+
+const (
+	awsSigV4Algorithm      = "AWS4-HMAC-SHA256"
+	awsAmzDateFormat       = "20060102T150405Z"
+	awsUnsignedPayload     = "UNSIGNED-PAYLOAD"
+	awsMetadataTokenHeader = "x-aws-ec2-metadata-token"
+)
+
+// Headers which are likely to be changed by proxies are not signed.
+var awsSigV4IgnoredHeaders = map[string]struct{}{
+	"authorization":     {},
+	"user-agent":        {},
+	"x-amzn-trace-id":   {},
+	"expect":            {},
+	"transfer-encoding": {},
+	"content-length":    {},
+	"x-request-id":      {},
+}
+
+type AwsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+type AwsCredentialsProvider interface {
+	Credentials() (AwsCredentials, error)
+}
+
+type StaticAwsCredentials AwsCredentials
+
+func (c StaticAwsCredentials) Credentials() (AwsCredentials, error) {
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return AwsCredentials{}, errors.New("aws credentials are empty")
+	}
+	return AwsCredentials(c), nil
+}
+
+type AwsSigV4Signer struct {
+	Service     string
+	Region      string
+	Credentials AwsCredentialsProvider
+	// Sign the payload as UNSIGNED-PAYLOAD instead of its hash
+	UnsignedPayload bool
+}
+
+// awsURIEncode encodes s as defined by the sigv4 specification, "/" is kept if encodeSlash is false.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+func (s *AwsSigV4Signer) canonicalURI(path string) string {
+	if path == "" {
+		return "/"
+	}
+	encoded := awsURIEncode(path, false)
+	// every service except s3 expects the path to be encoded twice
+	if s.Service != "s3" {
+		encoded = awsURIEncode(encoded, false)
+	}
+	return encoded
+}
+
+func awsCanonicalQuery(query url.Values) string {
+	params := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, awsURIEncode(key, true)+"="+awsURIEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign returns the headers with the sigv4 authorization headers added. The host is used
+// when rawURL does not contain one.
+func (s *AwsSigV4Signer) Sign(method, rawURL, host string, headers [][2]string, body []byte, now time.Time) ([][2]string, error) {
+	credentials, err := s.Credentials.Credentials()
+	if err != nil {
+		return nil, err
+	}
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if parsedURL.Host != "" {
+		host = parsedURL.Host
+	}
+	amzDate := now.UTC().Format(awsAmzDateFormat)
+	date := amzDate[:8]
+	payloadHash := awsUnsignedPayload
+	if !s.UnsignedPayload {
+		payloadHash = sha256Hex(body)
+	}
+	signed := make([][2]string, 0, len(headers)+4)
+	for _, h := range headers {
+		if !strings.EqualFold(h[0], "authorization") {
+			signed = append(signed, h)
+		}
+	}
+	signed = append(signed, [2]string{"host", host}, [2]string{"x-amz-date", amzDate})
+	if credentials.SessionToken != "" {
+		signed = append(signed, [2]string{"x-amz-security-token", credentials.SessionToken})
+	}
+	if s.Service == "s3" {
+		signed = append(signed, [2]string{"x-amz-content-sha256", payloadHash})
+	}
+
+	canonicalHeaders := map[string][]string{}
+	for _, h := range signed {
+		name := strings.ToLower(h[0])
+		if strings.HasPrefix(name, ":") {
+			continue
+		}
+		if _, ignored := awsSigV4IgnoredHeaders[name]; ignored {
+			continue
+		}
+		canonicalHeaders[name] = append(canonicalHeaders[name], strings.Join(strings.Fields(h[1]), " "))
+	}
+	names := make([]string, 0, len(canonicalHeaders))
+	for name := range canonicalHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var headerLines strings.Builder
+	for _, name := range names {
+		headerLines.WriteString(name + ":" + strings.Join(canonicalHeaders[name], ",") + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		strings.ToUpper(method),
+		s.canonicalURI(parsedURL.Path),
+		awsCanonicalQuery(parsedURL.Query()),
+		headerLines.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := strings.Join([]string{date, s.Region, s.Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{awsSigV4Algorithm, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+	signingKey := hmacSha256([]byte("AWS4"+credentials.SecretAccessKey), date)
+	signingKey = hmacSha256(signingKey, s.Region)
+	signingKey = hmacSha256(signingKey, s.Service)
+	signingKey = hmacSha256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(signingKey, stringToSign))
+
+	authorization := fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		awsSigV4Algorithm, credentials.AccessKeyID, scope, signedHeaders, signature)
+	// host is set from the cluster by HttpCall
+	result := make([][2]string, 0, len(signed)+1)
+	for _, h := range signed {
+		if h[0] != "host" {
+			result = append(result, h)
+		}
+	}
+	return append(result, [2]string{"authorization", authorization}), nil
+}
+
+// AwsSigV4Client signs every call made to the cluster, e.g. for calling Bedrock or Lambda directly.
+type AwsSigV4Client[C Cluster] struct {
+	cluster C
+	signer  *AwsSigV4Signer
+}
+
+func NewAwsSigV4Client[C Cluster](cluster C, signer *AwsSigV4Signer) *AwsSigV4Client[C] {
+	return &AwsSigV4Client[C]{cluster: cluster, signer: signer}
+}
+
+func (c AwsSigV4Client[C]) Get(rawURL string, headers [][2]string, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.Call(http.MethodGet, rawURL, headers, nil, cb, timeoutMillisecond...)
+}
+func (c AwsSigV4Client[C]) Head(rawURL string, headers [][2]string, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.Call(http.MethodHead, rawURL, headers, nil, cb, timeoutMillisecond...)
+}
+func (c AwsSigV4Client[C]) Options(rawURL string, headers [][2]string, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.Call(http.MethodOptions, rawURL, headers, nil, cb, timeoutMillisecond...)
+}
+func (c AwsSigV4Client[C]) Post(rawURL string, headers [][2]string, body []byte, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.Call(http.MethodPost, rawURL, headers, body, cb, timeoutMillisecond...)
+}
+func (c AwsSigV4Client[C]) Put(rawURL string, headers [][2]string, body []byte, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.Call(http.MethodPut, rawURL, headers, body, cb, timeoutMillisecond...)
+}
+func (c AwsSigV4Client[C]) Patch(rawURL string, headers [][2]string, body []byte, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.Call(http.MethodPatch, rawURL, headers, body, cb, timeoutMillisecond...)
+}
+func (c AwsSigV4Client[C]) Delete(rawURL string, headers [][2]string, body []byte, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.Call(http.MethodDelete, rawURL, headers, body, cb, timeoutMillisecond...)
+}
+func (c AwsSigV4Client[C]) Connect(rawURL string, headers [][2]string, body []byte, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.Call(http.MethodConnect, rawURL, headers, body, cb, timeoutMillisecond...)
+}
+func (c AwsSigV4Client[C]) Trace(rawURL string, headers [][2]string, body []byte, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.Call(http.MethodTrace, rawURL, headers, body, cb, timeoutMillisecond...)
+}
+
+func (c AwsSigV4Client[C]) Call(method, rawURL string, headers [][2]string, body []byte, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	signedHeaders, err := c.signer.Sign(method, rawURL, c.cluster.HostName(), headers, body, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to sign aws request: %v", err)
+	}
+	return HttpCall(c.cluster, method, rawURL, signedHeaders, body, cb, timeoutMillisecond...)
+}
+
+// AwsMetadataCredentials reads temporary credentials from the instance metadata service (IMDSv2),
+// or from the ECS container credentials endpoint if RelativeURI is set, refreshing them on tick.
+type AwsMetadataCredentials struct {
+	client HttpClient
+	// The relative uri of the ECS container credentials endpoint
+	relativeURI string
+	// The IAM role attached to the EC2 instance, discovered automatically if empty
+	role        string
+	credentials AwsCredentials
+	expiration  time.Time
+}
+
+// NewAwsMetadataCredentials should be called in parseConfig phase since it registers the refresh tick function.
+// The cluster should point to 169.254.169.254 for EC2 or 169.254.170.2 for ECS.
+func NewAwsMetadataCredentials(cluster Cluster, relativeURI, role string) *AwsMetadataCredentials {
+	p := &AwsMetadataCredentials{
+		client:      NewClusterClient(cluster),
+		relativeURI: relativeURI,
+		role:        role,
+	}
+	RegisteTickFunc(10000, func() {
+		// refresh five minutes before the credentials expire
+		if time.Until(p.expiration) < 5*time.Minute {
+			p.refresh()
+		}
+	})
+	return p
+}
+
+func (p *AwsMetadataCredentials) Credentials() (AwsCredentials, error) {
+	if p.credentials.AccessKeyID == "" || time.Now().After(p.expiration) {
+		return AwsCredentials{}, errors.New("aws metadata credentials are not available yet")
+	}
+	return p.credentials, nil
+}
+
+func (p *AwsMetadataCredentials) refresh() {
+	if p.relativeURI != "" {
+		p.fetchCredentials(p.relativeURI, nil)
+		return
+	}
+	err := p.client.Put("/latest/api/token", [][2]string{{"x-aws-ec2-metadata-token-ttl-seconds", "21600"}}, nil,
+		func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+			if statusCode != http.StatusOK {
+				proxywasm.LogErrorf("failed to get aws metadata token, status: %d", statusCode)
+				return
+			}
+			tokenHeaders := [][2]string{{awsMetadataTokenHeader, string(responseBody)}}
+			if p.role != "" {
+				p.fetchCredentials("/latest/meta-data/iam/security-credentials/"+p.role, tokenHeaders)
+				return
+			}
+			err := p.client.Get("/latest/meta-data/iam/security-credentials/", tokenHeaders,
+				func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+					role := strings.TrimSpace(strings.SplitN(string(responseBody), "\n", 2)[0])
+					if statusCode != http.StatusOK || role == "" {
+						proxywasm.LogErrorf("failed to get aws instance role, status: %d", statusCode)
+						return
+					}
+					p.role = role
+					p.fetchCredentials("/latest/meta-data/iam/security-credentials/"+role, tokenHeaders)
+				})
+			if err != nil {
+				proxywasm.LogErrorf("failed to get aws instance role: %v", err)
+			}
+		})
+	if err != nil {
+		proxywasm.LogErrorf("failed to get aws metadata token: %v", err)
+	}
+}
+
+func (p *AwsMetadataCredentials) fetchCredentials(path string, headers [][2]string) {
+	err := p.client.Get(path, headers, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if statusCode != http.StatusOK {
+			proxywasm.LogErrorf("failed to get aws credentials, status: %d", statusCode)
+			return
+		}
+		var result struct {
+			AccessKeyId     string
+			SecretAccessKey string
+			Token           string
+			Expiration      time.Time
+		}
+		if err := json.Unmarshal(responseBody, &result); err != nil {
+			proxywasm.LogErrorf("failed to parse aws credentials: %v", err)
+			return
+		}
+		p.credentials = AwsCredentials{
+			AccessKeyID:     result.AccessKeyId,
+			SecretAccessKey: result.SecretAccessKey,
+			SessionToken:    result.Token,
+		}
+		p.expiration = result.Expiration
+		proxywasm.LogInfof("aws credentials refreshed, expiration: %s", result.Expiration)
+	})
+	if err != nil {
+		proxywasm.LogErrorf("failed to get aws credentials: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The cases come from the AWS Signature Version 4 test suite.
+func TestAwsSigV4Sign(t *testing.T) {
+	signer := &AwsSigV4Signer{
+		Service: "service",
+		Region:  "us-east-1",
+		Credentials: StaticAwsCredentials{
+			AccessKeyID:     "AKIDEXAMPLE",
+			SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		},
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	cases := []struct {
+		name      string
+		rawURL    string
+		signature string
+	}{
+		{
+			name:      "get-vanilla",
+			rawURL:    "/",
+			signature: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:      "get-vanilla-query-order-key-case",
+			rawURL:    "/?Param2=value2&Param1=value1",
+			signature: "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			headers, err := signer.Sign("GET", c.rawURL, "example.amazonaws.com", nil, nil, now)
+			require.NoError(t, err)
+			expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=host;x-amz-date, Signature=" + c.signature
+			assert.Equal(t, [][2]string{{"x-amz-date", "20150830T123600Z"}, {"authorization", expected}}, headers)
+		})
+	}
+}
+
+func TestAwsURIEncode(t *testing.T) {
+	assert.Equal(t, "/a%20b/c~d", awsURIEncode("/a b/c~d", false))
+	assert.Equal(t, "%2Fa%2Bb", awsURIEncode("/a+b", true))
+}
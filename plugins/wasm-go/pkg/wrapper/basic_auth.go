@@ -0,0 +1,182 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The 'Basic' HTTP Authentication Scheme: https://datatracker.ietf.org/doc/html/rfc7617
+
+package wrapper
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+var (
+	ErrBasicAuthMissing       = errors.New("basic auth credentials are missing")
+	ErrBasicAuthMalformed     = errors.New("basic auth credentials are malformed")
+	ErrBasicAuthUserNotFound  = errors.New("basic auth user is not found")
+	ErrBasicAuthWrongPassword = errors.New("basic auth password is wrong")
+)
+
+// ParseBasicAuth parses the value of the authorization header.
+func ParseBasicAuth(authorization string) (string, string, error) {
+	if authorization == "" {
+		return "", "", ErrBasicAuthMissing
+	}
+	scheme, encoded, found := strings.Cut(strings.TrimSpace(authorization), " ")
+	if !found || !strings.EqualFold(scheme, "basic") {
+		return "", "", ErrBasicAuthMissing
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", ErrBasicAuthMalformed
+	}
+	username, password, found := strings.Cut(string(decoded), ":")
+	if !found || username == "" {
+		return "", "", ErrBasicAuthMalformed
+	}
+	return username, password, nil
+}
+
+// BasicAuthorization builds the value of the authorization header, for calling upstreams protected by basic auth.
+func BasicAuthorization(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+// BasicAuthPasswordEqual compares the passwords in constant time. Both sides are hashed first so that the
+// time does not depend on the length of the expected password either.
+func BasicAuthPasswordEqual(expected, actual string) bool {
+	e := sha256.Sum256([]byte(expected))
+	a := sha256.Sum256([]byte(actual))
+	return subtle.ConstantTimeCompare(e[:], a[:]) == 1
+}
+
+// BasicAuthCredentialStore looks up the password of a user. The callback may be called synchronously.
+type BasicAuthCredentialStore interface {
+	Lookup(username string, callback func(password string, found bool, err error)) error
+}
+
+// StaticBasicAuthCredentials maps usernames to passwords.
+type StaticBasicAuthCredentials map[string]string
+
+// ParseBasicAuthCredentials parses an array of "username:password" strings.
+func ParseBasicAuthCredentials(json gjson.Result) (StaticBasicAuthCredentials, error) {
+	credentials := StaticBasicAuthCredentials{}
+	for _, item := range json.Array() {
+		username, password, found := strings.Cut(item.String(), ":")
+		if !found || username == "" {
+			return nil, fmt.Errorf("invalid basic auth credential: %s", item.String())
+		}
+		if _, exists := credentials[username]; exists {
+			return nil, fmt.Errorf("duplicate basic auth user: %s", username)
+		}
+		credentials[username] = password
+	}
+	return credentials, nil
+}
+
+func (c StaticBasicAuthCredentials) Lookup(username string, callback func(string, bool, error)) error {
+	password, found := c[username]
+	callback(password, found, nil)
+	return nil
+}
+
+// RedisBasicAuthCredentials reads the password of a user from the redis key keyPrefix+username.
+type RedisBasicAuthCredentials struct {
+	client    RedisClient
+	keyPrefix string
+}
+
+func NewRedisBasicAuthCredentials(client RedisClient, keyPrefix string) *RedisBasicAuthCredentials {
+	return &RedisBasicAuthCredentials{client: client, keyPrefix: keyPrefix}
+}
+
+func (c *RedisBasicAuthCredentials) Lookup(username string, callback func(string, bool, error)) error {
+	return c.client.Get(c.keyPrefix+username, func(response resp.Value) {
+		if response.Error() != nil {
+			callback("", false, response.Error())
+			return
+		}
+		if response.IsNull() {
+			callback("", false, nil)
+			return
+		}
+		callback(response.String(), true, nil)
+	})
+}
+
+type BasicAuthenticator struct {
+	Realm string
+	Store BasicAuthCredentialStore
+	// Backend validates the credentials instead of the store if it's set, e.g. an ExternalAuthBackend
+	Backend AuthBackend
+}
+
+// Authenticate verifies the authorization header against the store, the username is passed to the callback on success.
+// The callback is not called if an error is returned.
+func (a *BasicAuthenticator) Authenticate(authorization string, callback func(username string, err error)) error {
+	username, password, err := ParseBasicAuth(authorization)
+	if err != nil {
+		callback("", err)
+		return nil
+	}
+	if a.Backend != nil {
+		return a.Backend.Authenticate(username, password, func(result AuthResult, err error) {
+			switch {
+			case err != nil:
+				callback(username, err)
+			case !result.Authenticated:
+				callback(username, ErrAuthRejected)
+			case result.Username != "":
+				callback(result.Username, nil)
+			default:
+				callback(username, nil)
+			}
+		})
+	}
+	return a.Store.Lookup(username, func(expected string, found bool, err error) {
+		switch {
+		case err != nil:
+			callback(username, err)
+		case !found:
+			callback(username, ErrBasicAuthUserNotFound)
+		case !BasicAuthPasswordEqual(expected, password):
+			callback(username, ErrBasicAuthWrongPassword)
+		default:
+			callback(username, nil)
+		}
+	})
+}
+
+// BasicAuthChallenge returns the value of the www-authenticate header.
+func BasicAuthChallenge(realm string) string {
+	if realm == "" {
+		realm = "MSE Gateway"
+	}
+	return fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, strings.ReplaceAll(realm, `"`, `\"`))
+}
+
+// SendBasicAuthChallenge replies 401 with the www-authenticate header, details is used as the response code details.
+func SendBasicAuthChallenge(realm, details string) error {
+	headers := [][2]string{{"www-authenticate", BasicAuthChallenge(realm)}}
+	return proxywasm.SendHttpResponseWithDetail(http.StatusUnauthorized, details, headers, []byte("Request denied by Basic Auth check. Unauthorized consumer."), -1)
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestParseBasicAuth(t *testing.T) {
+	username, password, err := ParseBasicAuth(BasicAuthorization("admin", "a:b"))
+	require.NoError(t, err)
+	assert.Equal(t, "admin", username)
+	assert.Equal(t, "a:b", password)
+
+	_, _, err = ParseBasicAuth("")
+	assert.ErrorIs(t, err, ErrBasicAuthMissing)
+	_, _, err = ParseBasicAuth("Bearer abc")
+	assert.ErrorIs(t, err, ErrBasicAuthMissing)
+	_, _, err = ParseBasicAuth("Basic !!!")
+	assert.ErrorIs(t, err, ErrBasicAuthMalformed)
+	_, _, err = ParseBasicAuth("Basic YWRtaW4=")
+	assert.ErrorIs(t, err, ErrBasicAuthMalformed)
+}
+
+func TestBasicAuthenticator(t *testing.T) {
+	store, err := ParseBasicAuthCredentials(gjson.Parse(`["admin:123456", "guest:abc"]`))
+	require.NoError(t, err)
+	authenticator := &BasicAuthenticator{Store: store}
+	cases := []struct {
+		authorization string
+		err           error
+	}{
+		{BasicAuthorization("admin", "123456"), nil},
+		{BasicAuthorization("admin", "12345"), ErrBasicAuthWrongPassword},
+		{BasicAuthorization("root", "123456"), ErrBasicAuthUserNotFound},
+		{"", ErrBasicAuthMissing},
+	}
+	for _, c := range cases {
+		called := false
+		require.NoError(t, authenticator.Authenticate(c.authorization, func(username string, err error) {
+			called = true
+			assert.Equal(t, c.err, err)
+		}))
+		assert.True(t, called)
+	}
+
+	_, err = ParseBasicAuthCredentials(gjson.Parse(`["admin:1", "admin:2"]`))
+	assert.Error(t, err)
+	_, err = ParseBasicAuthCredentials(gjson.Parse(`["admin"]`))
+	assert.Error(t, err)
+}
+
+func TestBasicAuthChallenge(t *testing.T) {
+	assert.Equal(t, `Basic realm="MSE Gateway", charset="UTF-8"`, BasicAuthChallenge(""))
+	assert.Equal(t, `Basic realm="a\"b", charset="UTF-8"`, BasicAuthChallenge(`a"b`))
+}
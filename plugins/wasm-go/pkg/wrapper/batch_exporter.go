@@ -0,0 +1,131 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+)
+
+type BatchExporterConfig struct {
+	Client  HttpClient
+	Path    string
+	Headers [][2]string
+	// Encode joins the records into the request body, default is a json array
+	Encode func(records []json.RawMessage) ([]byte, error)
+	// The max records in a request, default is 100
+	BatchSize int
+	// The interval of flushing the partial batch in milliseconds, default is 5000ms
+	FlushInterval int64
+	// The timeout of the calls in milliseconds, default is 5000ms
+	Timeout uint32
+}
+
+// BatchExporter buffers the records in the VM and posts them in batches, once a batch is full or on tick.
+// The records are lost if the call fails, the exporter is meant for the telemetry which tolerates gaps.
+type BatchExporter struct {
+	config  BatchExporterConfig
+	pending []json.RawMessage
+	dropped int
+}
+
+func newBatchExporter(config BatchExporterConfig) (*BatchExporter, error) {
+	if config.Client == nil {
+		return nil, errors.New("batch exporter client is empty")
+	}
+	if config.Path == "" {
+		config.Path = "/"
+	}
+	if config.Encode == nil {
+		config.Encode = encodeJSONArray
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 5000
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 5000
+	}
+	return &BatchExporter{config: config}, nil
+}
+
+// NewBatchExporter should be called in parseConfig phase since it registers the flush tick function.
+func NewBatchExporter(config BatchExporterConfig) (*BatchExporter, error) {
+	e, err := newBatchExporter(config)
+	if err != nil {
+		return nil, err
+	}
+	RegisteTickFunc(e.config.FlushInterval, e.Flush)
+	return e, nil
+}
+
+func encodeJSONArray(records []json.RawMessage) ([]byte, error) {
+	return json.Marshal(records)
+}
+
+// Add marshals the record into the buffer, a full batch is posted right away.
+func (e *BatchExporter) Add(record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	e.pending = append(e.pending, data)
+	if len(e.pending) >= e.config.BatchSize {
+		e.Flush()
+	}
+	return nil
+}
+
+// Flush posts the buffered records in batches, it's called on tick automatically.
+func (e *BatchExporter) Flush() {
+	for len(e.pending) > 0 {
+		size := e.config.BatchSize
+		if size > len(e.pending) {
+			size = len(e.pending)
+		}
+		batch := e.pending[:size]
+		e.pending = e.pending[size:]
+		body, err := e.config.Encode(batch)
+		if err != nil {
+			e.dropped += len(batch)
+			proxywasm.LogWarnf("failed to encode %d records: %v", len(batch), err)
+			continue
+		}
+		count := len(batch)
+		err = e.config.Client.Post(e.config.Path, e.config.Headers, body, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+			if statusCode < 200 || statusCode >= 300 {
+				e.dropped += count
+				proxywasm.LogWarnf("failed to export %d records, status %d: %s", count, statusCode, bytes.TrimSpace(responseBody))
+			}
+		}, e.config.Timeout)
+		if err != nil {
+			e.dropped += count
+			proxywasm.LogWarnf("failed to export %d records: %v", count, err)
+		}
+	}
+	// release the backing array grown by the bursts
+	e.pending = nil
+}
+
+// Dropped returns the records lost since the exporter was created.
+func (e *BatchExporter) Dropped() int {
+	return e.dropped
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeHttpClient records the posted bodies and responds with the status
+type fakeHttpClient struct {
+	HttpClient
+	status int
+	bodies []string
+}
+
+func (c *fakeHttpClient) Post(rawURL string, headers [][2]string, body []byte, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	c.bodies = append(c.bodies, string(body))
+	cb(c.status, nil, nil)
+	return nil
+}
+
+func TestBatchExporter(t *testing.T) {
+	client := &fakeHttpClient{status: 200}
+	exporter, err := newBatchExporter(BatchExporterConfig{Client: client, BatchSize: 2})
+	require.NoError(t, err)
+	require.NoError(t, exporter.Add(map[string]int{"n": 1}))
+	assert.Empty(t, client.bodies)
+	require.NoError(t, exporter.Add(map[string]int{"n": 2}))
+	assert.Equal(t, []string{`[{"n":1},{"n":2}]`}, client.bodies)
+
+	require.NoError(t, exporter.Add(map[string]int{"n": 3}))
+	exporter.Flush()
+	assert.Equal(t, `[{"n":3}]`, client.bodies[1])
+	assert.Equal(t, 0, exporter.Dropped())
+
+	_, err = newBatchExporter(BatchExporterConfig{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/matcher"
+)
+
+// bodyBufferLimit is the _requestBodyBufferLimit_ and _responseBodyBufferLimit_ of a rule in bytes, 0 keeps the
+// limit of the proxy.
+type bodyBufferLimit struct {
+	request  uint32
+	response uint32
+}
+
+// bodyBufferLimits are the limits of the global config and the rules, the ones absent in a rule are inherited from
+// the global config.
+type bodyBufferLimits struct {
+	global bodyBufferLimit
+	rules  []bodyBufferLimit
+}
+
+func parseBodyBufferLimit(json gjson.Result, inherited bodyBufferLimit) (bodyBufferLimit, error) {
+	limit := inherited
+	for _, field := range []struct {
+		key   string
+		value *uint32
+	}{
+		{matcher.REQUEST_BODY_BUFFER_LIMIT_KEY, &limit.request},
+		{matcher.RESPONSE_BODY_BUFFER_LIMIT_KEY, &limit.response},
+	} {
+		key, value := field.key, field.value
+		item := json.Get(key)
+		if !item.Exists() {
+			continue
+		}
+		if item.Type != gjson.Number || item.Int() <= 0 || item.Int() > int64(^uint32(0)) || float64(item.Int()) != item.Num {
+			return limit, fmt.Errorf("%s should be a positive integer of bytes, got %s", key, item.Raw)
+		}
+		*value = uint32(item.Int())
+	}
+	return limit, nil
+}
+
+func parseBodyBufferLimits(config gjson.Result) (*bodyBufferLimits, error) {
+	global, err := parseBodyBufferLimit(config, bodyBufferLimit{})
+	if err != nil {
+		return nil, err
+	}
+	limits := &bodyBufferLimits{global: global}
+	configured := global != bodyBufferLimit{}
+	for i, rule := range config.Get(matcher.RULES_KEY).Array() {
+		limit, err := parseBodyBufferLimit(rule, global)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i, err)
+		}
+		configured = configured || limit != bodyBufferLimit{}
+		limits.rules = append(limits.rules, limit)
+	}
+	if !configured {
+		return nil, nil
+	}
+	return limits, nil
+}
+
+func (l *bodyBufferLimits) rule(index int) bodyBufferLimit {
+	if index >= 0 && index < len(l.rules) {
+		return l.rules[index]
+	}
+	return l.global
+}
+
+// The wrapper enforces the limit set by SetRequestBodyBufferLimit or SetResponseBodyBufferLimit itself if the host
+// doesn't support the buffer limit properties, e.g. a vanilla envoy. The bodies are rejected with the status envoy
+// uses when its buffer overflows, by the content-length in the header phase, or by the bytes received so far in the
+// body phase, see OnHttpRequestBody. The limit of the host still applies, so it can't be raised this way.
+
+// contentLengthExceeded reports whether the content-length header is larger than the limit.
+func contentLengthExceeded(getHeader func(key string) (string, error), limit uint32) bool {
+	if limit == 0 {
+		return false
+	}
+	value, err := getHeader("content-length")
+	if err != nil {
+		return false
+	}
+	length, err := strconv.ParseUint(value, 10, 64)
+	return err == nil && length > uint64(limit)
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) checkRequestBodyLimit(action types.Action) types.Action {
+	if action != types.ActionContinue || !contentLengthExceeded(proxywasm.GetHttpRequestHeader, ctx.requestBodyLimit) {
+		return action
+	}
+	return ctx.rejectRequestBody()
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) rejectRequestBody() types.Action {
+	DebugDecision(ctx, "request body is larger than %d bytes", ctx.requestBodyLimit)
+	_ = proxywasm.SendHttpResponseWithDetail(http.StatusRequestEntityTooLarge, "request_payload_too_large", nil,
+		[]byte("Payload Too Large"), -1)
+	return types.ActionPause
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) checkResponseBodyLimit(action types.Action) types.Action {
+	if action != types.ActionContinue || !contentLengthExceeded(proxywasm.GetHttpResponseHeader, ctx.responseBodyLimit) {
+		return action
+	}
+	return ctx.rejectResponseBody()
+}
+
+// rejectResponseBody replies 500, envoy resets the stream instead if the response headers are sent already.
+func (ctx *CommonHttpCtx[PluginConfig]) rejectResponseBody() types.Action {
+	DebugDecision(ctx, "response body is larger than %d bytes", ctx.responseBodyLimit)
+	_ = proxywasm.SendHttpResponseWithDetail(http.StatusInternalServerError, "response_payload_too_large", nil,
+		[]byte("Internal Server Error"), -1)
+	return types.ActionPause
+}
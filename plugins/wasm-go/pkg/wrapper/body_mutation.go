@@ -0,0 +1,131 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"strconv"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+// The headers describing the body as it's received, they're stale once the body is replaced
+var bodyDigestHeaders = []string{"content-md5", "digest", "content-digest", "repr-digest"}
+
+// hasBodyLengthHeaders returns true if the headers would be stale after the body is replaced.
+func hasBodyLengthHeaders(headers headerAccessor) bool {
+	all, err := headers.get()
+	if err != nil {
+		return false
+	}
+	for _, header := range all {
+		if header[0] == "content-length" {
+			return true
+		}
+		for _, digest := range bodyDigestHeaders {
+			if header[0] == digest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// holdRequestHeaders keeps the request headers until the body is processed if the plugin opts in with
+// HoldHeadersForBodyReplacement, handles the buffered body and the headers carry the content-length or a digest, so
+// ReplaceRequestBody can fix them before they're sent.
+func (ctx *CommonHttpCtx[PluginConfig]) holdRequestHeaders(action types.Action, endOfStream bool) types.Action {
+	if !ctx.plugin.vm.holdBodyHeaders || action != types.ActionContinue || endOfStream || !ctx.needRequestBody || ctx.plugin.vm.onHttpRequestBody == nil ||
+		(ctx.plugin.vm.onHttpStreamingRequestBody != nil && ctx.streamingRequestBody) {
+		return action
+	}
+	if !hasBodyLengthHeaders(requestHeaderAccessor) {
+		return action
+	}
+	ctx.requestHeadersHeld = true
+	return types.ActionPause
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) holdResponseHeaders(action types.Action, endOfStream bool) types.Action {
+	if !ctx.plugin.vm.holdBodyHeaders || action != types.ActionContinue || endOfStream || !ctx.needResponseBody || ctx.plugin.vm.onHttpResponseBody == nil ||
+		(ctx.plugin.vm.onHttpStreamingResponseBody != nil && ctx.streamingResponseBody) {
+		return action
+	}
+	if !hasBodyLengthHeaders(responseHeaderAccessor) {
+		return action
+	}
+	ctx.responseHeadersHeld = true
+	return types.ActionPause
+}
+
+// ReplaceRequestBody replaces the buffered request body, the content-length is set to the size of the body and the
+// digests like content-md5 are removed. The headers can only be fixed if they're held until the body is processed,
+// which the wrapper does for the plugins created with the HoldHeadersForBodyReplacement option.
+func ReplaceRequestBody(ctx HttpContext, body []byte) error {
+	if c, ok := ctx.(httpContextExtension); ok {
+		return c.replaceRequestBody(body)
+	}
+	return proxywasm.ReplaceHttpRequestBody(body)
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) replaceRequestBody(body []byte) error {
+	if err := proxywasm.ReplaceHttpRequestBody(body); err != nil {
+		return err
+	}
+	ctx.fixBodyHeaders("request", requestHeaderAccessor, len(body), ctx.requestHeadersHeld)
+	return nil
+}
+
+// ReplaceResponseBody replaces the buffered response body, fixing the response headers like ReplaceRequestBody.
+func ReplaceResponseBody(ctx HttpContext, body []byte) error {
+	if c, ok := ctx.(httpContextExtension); ok {
+		return c.replaceResponseBody(body)
+	}
+	return proxywasm.ReplaceHttpResponseBody(body)
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) replaceResponseBody(body []byte) error {
+	if err := proxywasm.ReplaceHttpResponseBody(body); err != nil {
+		return err
+	}
+	ctx.fixBodyHeaders("response", responseHeaderAccessor, len(body), ctx.responseHeadersHeld)
+	return nil
+}
+
+// fixBodyHeaders sets the content-length to the size of the new body and removes the digests. The body is sent
+// chunked if there is no content-length, which is kept as it is.
+func (ctx *CommonHttpCtx[PluginConfig]) fixBodyHeaders(kind string, headers headerAccessor, size int, held bool) {
+	if !held {
+		if hasBodyLengthHeaders(headers) {
+			ctx.plugin.vm.log.Warnf("the %s headers are sent before the body is replaced, the content-length or the digest may be stale", kind)
+		}
+		return
+	}
+	for _, digest := range bodyDigestHeaders {
+		_ = headers.remove(digest)
+	}
+	all, err := headers.get()
+	if err != nil {
+		return
+	}
+	for _, header := range all {
+		if header[0] == "content-length" {
+			if err = headers.replace("content-length", strconv.Itoa(size)); err != nil {
+				ctx.plugin.vm.log.Warnf("fix %s content-length failed: %v", kind, err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/gjson"
+)
+
+const TemplateSourceChallenge = "challenge"
+
+var (
+	ErrChallengeMalformed        = errors.New("challenge token is malformed")
+	ErrChallengeSignatureInvalid = errors.New("challenge token signature is invalid")
+	ErrChallengeExpired          = errors.New("challenge token is expired")
+	ErrChallengeClientMismatch   = errors.New("challenge token is issued to another client")
+	ErrChallengeUnsolved         = errors.New("challenge nonce does not solve the proof of work")
+	ErrChallengeReplayed         = errors.New("challenge token has been used")
+)
+
+const (
+	challengeKindChallenge byte = 'c'
+	challengeKindClearance byte = 'k'
+	// kind, expiry, difficulty and client digest, followed by the random bytes of a challenge
+	challengeHeaderSize = 1 + 8 + 1 + 8
+	challengeRandomSize = 12
+	maxChallengeNonce   = 64
+	maxChallengeBits    = 32
+)
+
+// The default challenge page solves the proof of work with WebCrypto, which is only available to the pages served
+// over https, stores the solution in a cookie and reloads the page.
+const defaultChallengePage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="robots" content="noindex"><title>Checking your browser</title></head>
+<body><p>Checking your browser before accessing the site...</p><noscript>Please enable JavaScript to continue.</noscript>
+<script>
+(async function () {
+  var token = "{{challenge.token}}", difficulty = {{challenge.difficulty}}, encoder = new TextEncoder();
+  function zeros(digest) {
+    var n = 0;
+    for (var i = 0; i < digest.length; i++) {
+      if (digest[i] !== 0) return n + Math.clz32(digest[i]) - 24;
+      n += 8;
+    }
+    return n;
+  }
+  var nonce = 0;
+  while (zeros(new Uint8Array(await crypto.subtle.digest("SHA-256", encoder.encode(token + nonce)))) < difficulty) nonce++;
+  document.cookie = "{{challenge.cookie}}=" + token + ":" + nonce + "; path=/; max-age={{challenge.ttl}}; samesite=lax";
+  location.reload();
+})();
+</script></body></html>
+`
+
+type ChallengeConfig struct {
+	// The key spec of the HMAC signing the tokens, resolved by ResolveSecretKey, e.g. env:CHALLENGE_SECRET.
+	// All the gateways of a site should share it, so a clearance issued by one is accepted by the others.
+	Secret string
+	// The clearance cookie, the solution is sent by the challenge page in the cookie with the _solution suffix,
+	// default is "higress_challenge"
+	CookieName   string
+	CookieDomain string
+	SecureCookie bool
+	// The seconds the clearance is valid, default is 3600
+	TTL int
+	// The seconds the challenge can be solved in, default is 300
+	ChallengeTTL int
+	// The leading zero bits of sha256(token + nonce) required by the proof of work, at most 32. Every bit doubles
+	// the work of the client, 0 only requires the page script to run. It's 16 if absent in the json config.
+	Difficulty int
+	// The header of the client ip the tokens are bound to, the downstream address is used if it's empty
+	ClientIPHeader string
+	// The page sending the solution, the variables of the challenge source are token, difficulty, cookie (the
+	// name of the solution cookie) and ttl. The default page is used if it's nil.
+	Page *ResponseTemplate
+}
+
+func ParseChallengeConfig(json gjson.Result) (ChallengeConfig, error) {
+	config := ChallengeConfig{
+		Secret:         json.Get("secret").String(),
+		CookieName:     json.Get("cookieName").String(),
+		CookieDomain:   json.Get("cookieDomain").String(),
+		SecureCookie:   json.Get("secureCookie").Bool(),
+		TTL:            int(json.Get("ttl").Int()),
+		ChallengeTTL:   int(json.Get("challengeTTL").Int()),
+		Difficulty:     16,
+		ClientIPHeader: json.Get("clientIPHeader").String(),
+	}
+	if difficulty := json.Get("difficulty"); difficulty.Exists() {
+		config.Difficulty = int(difficulty.Int())
+	}
+	if page := json.Get("page"); page.Exists() {
+		var err error
+		if config.Page, err = ParseResponseTemplate(page); err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
+func (c *ChallengeConfig) setDefaults() {
+	if c.CookieName == "" {
+		c.CookieName = "higress_challenge"
+	}
+	if c.TTL <= 0 {
+		c.TTL = 3600
+	}
+	if c.ChallengeTTL <= 0 {
+		c.ChallengeTTL = 300
+	}
+}
+
+// Challenge is issued to a client which has no valid clearance.
+type Challenge struct {
+	Token      string
+	Difficulty int
+	Expires    time.Time
+}
+
+// Challenger challenges the suspicious clients with a proof of work solved by the page script, and clears the
+// clients solving it with a signed cookie. The tokens are stateless HMAC signed values bound to the client, so
+// they are valid across the worker threads and the gateways sharing the secret.
+type Challenger struct {
+	config ChallengeConfig
+	key    []byte
+	nonces NonceCache
+	page   *ResponseTemplate
+	now    func() time.Time
+}
+
+// NewChallenger creates a challenger, a solved challenge can be replayed until it expires if nonces is nil.
+func NewChallenger(config ChallengeConfig, nonces NonceCache) (*Challenger, error) {
+	config.setDefaults()
+	if config.Difficulty < 0 || config.Difficulty > maxChallengeBits {
+		return nil, fmt.Errorf("challenge difficulty should be between 0 and %d", maxChallengeBits)
+	}
+	key, err := ResolveSecretKey(config.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid challenge secret: %v", err)
+	}
+	page := config.Page
+	if page == nil {
+		body, err := ParsePromptTemplate(defaultChallengePage, PromptTemplateOptions{Escape: TemplateEscapeHTML})
+		if err != nil {
+			return nil, err
+		}
+		noStore, _ := ParsePromptTemplate("no-store", PromptTemplateOptions{})
+		page = &ResponseTemplate{
+			StatusCode:  http.StatusForbidden,
+			ContentType: "text/html; charset=utf-8",
+			headers:     []responseTemplateHeader{{name: "cache-control", value: noStore}},
+			body:        body,
+		}
+	}
+	return &Challenger{config: config, key: key, nonces: nonces, page: page, now: time.Now}, nil
+}
+
+// ChallengeClientKey identifies the client the tokens are bound to by the client ip and the user agent of the
+// current request, so a clearance can't be shared by a farm of bots.
+func ChallengeClientKey(ipHeader string) string {
+	userAgent, _ := proxywasm.GetHttpRequestHeader("user-agent")
+	return GetClientIP(ipHeader) + "|" + userAgent
+}
+
+func (c *Challenger) SolutionCookieName() string {
+	return c.config.CookieName + "_solution"
+}
+
+func (c *Challenger) sign(payload []byte) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func challengeClientDigest(client string) []byte {
+	digest := sha256.Sum256([]byte(client))
+	return digest[:8]
+}
+
+func (c *Challenger) issue(kind byte, client string, ttl int, difficulty int, random []byte) (string, time.Time) {
+	expires := c.now().Add(time.Duration(ttl) * time.Second)
+	payload := make([]byte, 0, challengeHeaderSize+len(random))
+	payload = append(payload, kind)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(expires.Unix()))
+	payload = append(payload, byte(difficulty))
+	payload = append(payload, challengeClientDigest(client)...)
+	payload = append(payload, random...)
+	return c.sign(payload), expires
+}
+
+// open checks the token is an authentic unexpired token of the kind issued to the client, and returns the
+// difficulty of it.
+func (c *Challenger) open(kind byte, client, token string) (int, error) {
+	encodedPayload, encodedMac, found := strings.Cut(token, ".")
+	if !found {
+		return 0, ErrChallengeMalformed
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil || len(payload) < challengeHeaderSize || payload[0] != kind {
+		return 0, ErrChallengeMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedMac)
+	if err != nil {
+		return 0, ErrChallengeMalformed
+	}
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write(payload)
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return 0, ErrChallengeSignatureInvalid
+	}
+	if int64(binary.BigEndian.Uint64(payload[1:9])) < c.now().Unix() {
+		return 0, ErrChallengeExpired
+	}
+	if !hmac.Equal(payload[10:challengeHeaderSize], challengeClientDigest(client)) {
+		return 0, ErrChallengeClientMismatch
+	}
+	return int(payload[9]), nil
+}
+
+// NewChallenge issues a challenge to the client with the configured difficulty.
+func (c *Challenger) NewChallenge(client string) (Challenge, error) {
+	random, err := RandomBytes(challengeRandomSize)
+	if err != nil {
+		return Challenge{}, err
+	}
+	token, expires := c.issue(challengeKindChallenge, client, c.config.ChallengeTTL, c.config.Difficulty, random)
+	return Challenge{Token: token, Difficulty: c.config.Difficulty, Expires: expires}, nil
+}
+
+// ChallengeLeadingZeros returns the leading zero bits of sha256(token + nonce), the work done by the client.
+func ChallengeLeadingZeros(token, nonce string) int {
+	digest := sha256.Sum256([]byte(token + nonce))
+	zeros := 0
+	for _, b := range digest {
+		if b != 0 {
+			return zeros + bits.LeadingZeros8(b)
+		}
+		zeros += 8
+	}
+	return zeros
+}
+
+// VerifySolution checks the nonce solves the challenge issued to the client. A solved challenge is only accepted
+// once if the challenger has a nonce cache.
+func (c *Challenger) VerifySolution(client, token, nonce string) error {
+	difficulty, err := c.open(challengeKindChallenge, client, token)
+	if err != nil {
+		return err
+	}
+	if len(nonce) > maxChallengeNonce || ChallengeLeadingZeros(token, nonce) < difficulty {
+		return ErrChallengeUnsolved
+	}
+	// the token is only recorded once solved, so it can't be burnt by a client which doesn't do the work
+	if c.nonces != nil && !c.nonces.CheckAndStore("challenge:"+token) {
+		return ErrChallengeReplayed
+	}
+	return nil
+}
+
+// NewClearance returns the cookie clearing the client, it should be set once the client solves a challenge.
+func (c *Challenger) NewClearance(client string) Cookie {
+	token, _ := c.issue(challengeKindClearance, client, c.config.TTL, 0, nil)
+	return Cookie{
+		Name:     c.config.CookieName,
+		Value:    token,
+		Path:     "/",
+		Domain:   c.config.CookieDomain,
+		MaxAge:   c.config.TTL,
+		Secure:   c.config.SecureCookie,
+		HttpOnly: true,
+		SameSite: "Lax",
+	}
+}
+
+// VerifyClearance checks the value of the clearance cookie sent by the client.
+func (c *Challenger) VerifyClearance(client, value string) error {
+	_, err := c.open(challengeKindClearance, client, value)
+	return err
+}
+
+// SendChallenge sends the challenge page as the local reply of the request, its action should be returned by the
+// phase.
+func (c *Challenger) SendChallenge(ctx HttpContext, client string) types.Action {
+	challenge, err := c.NewChallenge(client)
+	if err != nil {
+		return Fail(ctx, NewError(http.StatusInternalServerError, ErrorCodeInternal, "").
+			WithDetail("issue challenge").WithCause(err))
+	}
+	return c.page.Send(ctx, "bot_challenge.challenged", TemplateSources{
+		TemplateSourceChallenge: MapTemplateSource(map[string]string{
+			"token":      challenge.Token,
+			"difficulty": strconv.Itoa(challenge.Difficulty),
+			"cookie":     c.SolutionCookieName(),
+			"ttl":        strconv.Itoa(c.config.ChallengeTTL),
+		}),
+	})
+}
+
+// Handle challenges the request in the request headers phase, the plugin decides which requests are suspicious:
+//   - the request goes on if it has a valid clearance cookie
+//   - the client is redirected to the same url with the clearance cookie if it sends a valid solution
+//   - otherwise the challenge page is sent
+//
+// Since the page reloads the url, the challenge suits the navigations of browsers, not the api calls.
+func (c *Challenger) Handle(ctx HttpContext) types.Action {
+	client := ChallengeClientKey(c.config.ClientIPHeader)
+	if value, ok := GetRequestCookie(c.config.CookieName); ok {
+		err := c.VerifyClearance(client, value)
+		if err == nil {
+			return types.ActionContinue
+		}
+		DebugDecision(ctx, "bot challenge clearance rejected: %v", err)
+	}
+	if value, ok := GetRequestCookie(c.SolutionCookieName()); ok {
+		token, nonce, _ := strings.Cut(value, ":")
+		err := c.VerifySolution(client, token, nonce)
+		if err == nil {
+			return c.sendClearance(ctx, client)
+		}
+		DebugDecision(ctx, "bot challenge solution rejected: %v", err)
+	}
+	return c.SendChallenge(ctx, client)
+}
+
+// clearanceLocation rebuilds the redirect location from the parsed path. A path like //evil.com or /\evil.com would
+// be a redirect to another site, since the browsers take the backslash as a slash, so the path is escaped.
+func clearanceLocation(path string) string {
+	u, err := url.ParseRequestURI(path)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+		return "/"
+	}
+	location := u.EscapedPath()
+	if strings.HasPrefix(location, "//") {
+		return "/"
+	}
+	if u.RawQuery != "" {
+		location += "?" + u.RawQuery
+	}
+	return location
+}
+
+func (c *Challenger) sendClearance(ctx HttpContext, client string) types.Action {
+	location := clearanceLocation(ctx.Path())
+	expired := Cookie{Name: c.SolutionCookieName(), Path: "/", MaxAge: -1}
+	headers := [][2]string{
+		{"location", location},
+		{"cache-control", "no-store"},
+		{"set-cookie", c.NewClearance(client).String()},
+		{"set-cookie", expired.String()},
+	}
+	if err := proxywasm.SendHttpResponseWithDetail(http.StatusFound, "bot_challenge.passed", headers, nil, -1); err != nil {
+		proxywasm.LogErrorf("send challenge clearance failed: %v", err)
+		return types.ActionContinue
+	}
+	return types.ActionPause
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+type mapNonceCache map[string]bool
+
+func (c mapNonceCache) CheckAndStore(nonce string) bool {
+	if c[nonce] {
+		return false
+	}
+	c[nonce] = true
+	return true
+}
+
+func solveChallenge(token string, difficulty int) string {
+	for nonce := 0; ; nonce++ {
+		if ChallengeLeadingZeros(token, strconv.Itoa(nonce)) >= difficulty {
+			return strconv.Itoa(nonce)
+		}
+	}
+}
+
+func TestParseChallengeConfig(t *testing.T) {
+	config, err := ParseChallengeConfig(gjson.Parse(`{"secret": "s3cret"}`))
+	require.NoError(t, err)
+	assert.Equal(t, 16, config.Difficulty)
+	assert.Nil(t, config.Page)
+	config, err = ParseChallengeConfig(gjson.Parse(`{"secret": "s3cret", "difficulty": 0, "page": {"body": "{{challenge.token}}"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, 0, config.Difficulty)
+	assert.NotNil(t, config.Page)
+
+	_, err = NewChallenger(ChallengeConfig{Secret: "s3cret", Difficulty: 33}, nil)
+	assert.Error(t, err)
+	_, err = NewChallenger(ChallengeConfig{}, nil)
+	assert.Error(t, err)
+}
+
+func TestChallengeSolution(t *testing.T) {
+	nonces := mapNonceCache{}
+	challenger, err := NewChallenger(ChallengeConfig{Secret: "s3cret", Difficulty: 8}, nonces)
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	challenger.now = func() time.Time { return now }
+
+	challenge, err := challenger.NewChallenge("1.2.3.4|ua")
+	require.NoError(t, err)
+	assert.Equal(t, 8, challenge.Difficulty)
+	assert.Equal(t, now.Add(300*time.Second), challenge.Expires)
+	nonce := solveChallenge(challenge.Token, 8)
+
+	unsolved := "x"
+	for ChallengeLeadingZeros(challenge.Token, unsolved) >= 8 {
+		unsolved += "x"
+	}
+	assert.ErrorIs(t, challenger.VerifySolution("1.2.3.4|ua", challenge.Token, unsolved), ErrChallengeUnsolved)
+	assert.ErrorIs(t, challenger.VerifySolution("5.6.7.8|ua", challenge.Token, nonce), ErrChallengeClientMismatch)
+	assert.ErrorIs(t, challenger.VerifySolution("1.2.3.4|ua", challenge.Token+"A", nonce), ErrChallengeSignatureInvalid)
+	assert.ErrorIs(t, challenger.VerifySolution("1.2.3.4|ua", "bm90IGEgdG9rZW4", nonce), ErrChallengeMalformed)
+	assert.Empty(t, nonces, "the rejected solutions don't burn the token")
+
+	other, err := NewChallenger(ChallengeConfig{Secret: "other", Difficulty: 8}, nil)
+	require.NoError(t, err)
+	other.now = challenger.now
+	assert.ErrorIs(t, other.VerifySolution("1.2.3.4|ua", challenge.Token, nonce), ErrChallengeSignatureInvalid)
+
+	assert.NoError(t, challenger.VerifySolution("1.2.3.4|ua", challenge.Token, nonce))
+	assert.ErrorIs(t, challenger.VerifySolution("1.2.3.4|ua", challenge.Token, nonce), ErrChallengeReplayed)
+
+	challenge, err = challenger.NewChallenge("1.2.3.4|ua")
+	require.NoError(t, err)
+	nonce = solveChallenge(challenge.Token, 8)
+	now = now.Add(301 * time.Second)
+	assert.ErrorIs(t, challenger.VerifySolution("1.2.3.4|ua", challenge.Token, nonce), ErrChallengeExpired)
+}
+
+func TestChallengeClearance(t *testing.T) {
+	challenger, err := NewChallenger(ChallengeConfig{Secret: "s3cret", TTL: 60, SecureCookie: true}, nil)
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	challenger.now = func() time.Time { return now }
+
+	cookie := challenger.NewClearance("1.2.3.4|ua")
+	assert.Equal(t, "higress_challenge", cookie.Name)
+	assert.Equal(t, 60, cookie.MaxAge)
+	assert.True(t, cookie.Secure && cookie.HttpOnly)
+	assert.NoError(t, challenger.VerifyClearance("1.2.3.4|ua", cookie.Value))
+	assert.ErrorIs(t, challenger.VerifyClearance("1.2.3.4|curl", cookie.Value), ErrChallengeClientMismatch)
+
+	// a challenge token can't be used as a clearance
+	challenge, err := challenger.NewChallenge("1.2.3.4|ua")
+	require.NoError(t, err)
+	assert.ErrorIs(t, challenger.VerifyClearance("1.2.3.4|ua", challenge.Token), ErrChallengeMalformed)
+
+	now = now.Add(61 * time.Second)
+	assert.ErrorIs(t, challenger.VerifyClearance("1.2.3.4|ua", cookie.Value), ErrChallengeExpired)
+}
+
+func TestClearanceLocation(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/shop?item=1":      "/shop?item=1",
+		"/a%20b":            "/a%20b",
+		"//evil.com":        "/",
+		"//evil.com/a?b=1":  "/",
+		"/\\evil.com":       "/%5Cevil.com",
+		"/\\/evil.com":      "/%5C/evil.com",
+		"https://evil.com/": "/",
+		"evil.com":          "/",
+		"/\t/evil.com":      "/",
+		"":                  "/",
+	} {
+		assert.Equal(t, expected, clearanceLocation(path), path)
+	}
+}
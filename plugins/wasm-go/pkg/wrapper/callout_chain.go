@@ -0,0 +1,253 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+var ErrChainTimeout = errors.New("callout chain timed out")
+
+// ChainStepFunc dispatches the callout of the step, and completes the step in the callback with Done or Fail. It
+// returns an error if the callout can't be dispatched, which fails the step.
+type ChainStepFunc func(step *ChainStep) error
+
+type ChainStepOption func(*chainStepDef)
+
+// WithStepTimeout sets the timeout of the step in milliseconds, which is passed to the callout by step.Timeout().
+func WithStepTimeout(timeout uint32) ChainStepOption {
+	return func(d *chainStepDef) { d.timeout = timeout }
+}
+
+// ContinueOnError lets the chain go on if the step fails, e.g. for an optional enrichment.
+func ContinueOnError() ChainStepOption {
+	return func(d *chainStepDef) { d.continueOnError = true }
+}
+
+type chainStepDef struct {
+	name            string
+	run             ChainStepFunc
+	timeout         uint32
+	continueOnError bool
+}
+
+// ChainStepResult is the outcome of a step, the steps not run are absent.
+type ChainStepResult struct {
+	Name     string
+	Err      error
+	Duration time.Duration
+}
+
+// ChainErrorHandler handles the failed step which aborts the chain, it usually sends a local response and returns
+// types.ActionPause. The chain resumes the stream if it returns types.ActionContinue.
+type ChainErrorHandler func(step string, err error) types.Action
+
+// Chain runs dependent callouts one after another, e.g. a token introspection, then a quota check, then an
+// enrichment, with a single pause of the stream:
+//
+//	return wrapper.NewChain(ctx).
+//		Then("introspect", introspect, wrapper.WithStepTimeout(1000)).
+//		Then("quota", checkQuota).
+//		Then("enrich", enrich, wrapper.ContinueOnError()).
+//		OnError(func(step string, err error) types.Action {
+//			_ = proxywasm.SendHttpResponseWithDetail(403, "chain."+step, nil, []byte(err.Error()), -1)
+//			return types.ActionPause
+//		}).
+//		Run()
+//
+// The stream is only paused if a step completes asynchronously, and it's resumed once all the steps are done.
+type Chain struct {
+	ctx         HttpContext
+	steps       []chainStepDef
+	onError     ChainErrorHandler
+	timeout     uint32
+	start       time.Time
+	index       int
+	dispatching bool
+	paused      bool
+	finished    bool
+	results     []ChainStepResult
+	// seams of the host calls
+	now    func() time.Time
+	resume func()
+}
+
+// NewChain creates a chain run in the request phases, the request is resumed once it's done.
+func NewChain(ctx HttpContext) *Chain {
+	return &Chain{ctx: ctx, now: time.Now, resume: func() { _ = proxywasm.ResumeHttpRequest() }}
+}
+
+// NewResponseChain creates a chain run in the response phases, the response is resumed once it's done.
+func NewResponseChain(ctx HttpContext) *Chain {
+	return &Chain{ctx: ctx, now: time.Now, resume: func() { _ = proxywasm.ResumeHttpResponse() }}
+}
+
+func (c *Chain) Then(name string, run ChainStepFunc, options ...ChainStepOption) *Chain {
+	step := chainStepDef{name: name, run: run}
+	for _, option := range options {
+		option(&step)
+	}
+	c.steps = append(c.steps, step)
+	return c
+}
+
+// OnError sets the handler of the failed step, by default it's failed by HttpContext.Fail with a 500
+// ErrorCodeCalloutChainFailed error, so the _errors_ policy applies.
+func (c *Chain) OnError(handler ChainErrorHandler) *Chain {
+	c.onError = handler
+	return c
+}
+
+// WithTimeout sets the time budget of the whole chain in milliseconds, the timeouts of the steps are capped by the
+// remaining budget, and the steps are failed with ErrChainTimeout once it's exhausted.
+func (c *Chain) WithTimeout(timeout uint32) *Chain {
+	c.timeout = timeout
+	return c
+}
+
+// Results returns the outcome of the steps run so far.
+func (c *Chain) Results() []ChainStepResult {
+	return c.results
+}
+
+// Run starts the chain, its action should be returned by the phase.
+func (c *Chain) Run() types.Action {
+	c.start = c.now()
+	return c.next()
+}
+
+// ChainStep is a running step of the chain.
+type ChainStep struct {
+	chain     *Chain
+	def       *chainStepDef
+	timeout   uint32
+	start     time.Time
+	completed bool
+	err       error
+}
+
+func (s *ChainStep) Name() string {
+	return s.def.name
+}
+
+// Context returns the HttpContext of the chain, e.g. to pass data to the next steps with SetContext.
+func (s *ChainStep) Context() HttpContext {
+	return s.chain.ctx
+}
+
+// Timeout returns the timeout of the callout in milliseconds, it's 0 if neither the step nor the chain has one,
+// which means the default of the callout.
+func (s *ChainStep) Timeout() uint32 {
+	return s.timeout
+}
+
+func (s *ChainStep) Done() {
+	s.complete(nil)
+}
+
+func (s *ChainStep) Fail(err error) {
+	if err == nil {
+		err = errors.New("step failed")
+	}
+	s.complete(err)
+}
+
+func (s *ChainStep) complete(err error) {
+	if s.completed || s.chain.finished {
+		return
+	}
+	s.completed = true
+	s.err = err
+	s.chain.results = append(s.chain.results, ChainStepResult{Name: s.def.name, Err: err, Duration: s.chain.now().Sub(s.start)})
+	if err != nil {
+		DebugDecision(s.chain.ctx, "chain step %s failed: %v", s.def.name, err)
+	}
+	if s.chain.dispatching {
+		// the loop of next goes on
+		return
+	}
+	if action, done := s.chain.afterStep(s); done {
+		s.chain.settle(action)
+		return
+	}
+	s.chain.settle(s.chain.next())
+}
+
+// remaining returns the remaining budget of the chain in milliseconds, it's -1 if there is no budget.
+func (c *Chain) remaining() int64 {
+	if c.timeout == 0 {
+		return -1
+	}
+	left := int64(c.timeout) - c.now().Sub(c.start).Milliseconds()
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// next runs the steps until one completes asynchronously, or the chain is finished.
+func (c *Chain) next() types.Action {
+	for c.index < len(c.steps) {
+		def := &c.steps[c.index]
+		step := &ChainStep{chain: c, def: def, timeout: def.timeout, start: c.now()}
+		if left := c.remaining(); left == 0 {
+			step.err = ErrChainTimeout
+		} else if left > 0 && (step.timeout == 0 || int64(step.timeout) > left) {
+			step.timeout = uint32(left)
+		}
+		c.dispatching = true
+		if step.err != nil {
+			step.complete(step.err)
+		} else if err := def.run(step); err != nil {
+			step.Fail(err)
+		}
+		c.dispatching = false
+		if !step.completed {
+			c.paused = true
+			return types.ActionPause
+		}
+		if action, done := c.afterStep(step); done {
+			return action
+		}
+	}
+	c.finished = true
+	return types.ActionContinue
+}
+
+// afterStep moves to the next step, or aborts the chain if the step failed, done is true if the chain is aborted.
+func (c *Chain) afterStep(step *ChainStep) (types.Action, bool) {
+	c.index++
+	if step.err == nil || step.def.continueOnError {
+		return types.ActionContinue, false
+	}
+	c.finished = true
+	if c.onError != nil {
+		return c.onError(step.def.name, step.err), true
+	}
+	return Fail(c.ctx, NewError(http.StatusInternalServerError, ErrorCodeCalloutChainFailed, "").
+		WithDetail("step %s", step.def.name).WithCause(step.err)), true
+}
+
+// settle resumes the paused stream once the chain is done in a callback.
+func (c *Chain) settle(action types.Action) {
+	if c.paused && action == types.ActionContinue && c.finished {
+		c.resume()
+	}
+}
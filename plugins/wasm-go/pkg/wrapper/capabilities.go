@@ -0,0 +1,189 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+// Capability is a feature of the host which may be missing in an older Envoy or Higress build.
+type Capability string
+
+// The properties set by the wrapper to control the stream, they are Higress extensions of Envoy.
+const (
+	CapabilityDecoderBufferLimit Capability = "set_decoder_buffer_limit"
+	CapabilityEncoderBufferLimit Capability = "set_encoder_buffer_limit"
+	CapabilityClearRouteCache    Capability = "clear_route_cache"
+)
+
+// The properties of the plugin, read in the root context.
+const (
+	CapabilityPluginName   Capability = "plugin_name"
+	CapabilityPluginRootID Capability = "plugin_root_id"
+	CapabilityPluginVmID   Capability = "plugin_vm_id"
+)
+
+// ForeignFunctionCapability is the capability of calling the foreign function.
+func ForeignFunctionCapability(name string) Capability {
+	return Capability("foreign_function:" + name)
+}
+
+// ErrCapabilityUnsupported is the error of using the capabilities missing in the host, it's wrapped with the capability.
+var ErrCapabilityUnsupported = errors.New("the host doesn't support the capability")
+
+type CapabilityState int
+
+const (
+	// The capability isn't probed or used yet
+	CapabilityUnknown CapabilityState = iota
+	CapabilitySupported
+	CapabilityUnsupported
+)
+
+func (s CapabilityState) String() string {
+	switch s {
+	case CapabilitySupported:
+		return "supported"
+	case CapabilityUnsupported:
+		return "unsupported"
+	}
+	return "unknown"
+}
+
+// hostCapabilities is shared by the plugins in the VM, as they run on the same host.
+var hostCapabilities = map[Capability]CapabilityState{}
+
+// HostCapability returns the state of the capability found by ProbeHostCapabilities, or by the wrapper using it.
+// The properties controlling the stream can't be probed in the root context, so they are unknown until the first
+// request using them, e.g. SetRequestBodyBufferLimit. Plugins should only degrade on CapabilityUnsupported.
+func HostCapability(capability Capability) CapabilityState {
+	return hostCapabilities[capability]
+}
+
+// HostCapabilities returns a copy of the capabilities known so far, e.g. to be logged.
+func HostCapabilities() map[Capability]CapabilityState {
+	result := make(map[Capability]CapabilityState, len(hostCapabilities))
+	for capability, state := range hostCapabilities {
+		result[capability] = state
+	}
+	return result
+}
+
+func recordCapability(capability Capability, err error) {
+	switch err {
+	case nil:
+		hostCapabilities[capability] = CapabilitySupported
+	case types.ErrorStatusNotFound, types.ErrorUnimplemented:
+		hostCapabilities[capability] = CapabilityUnsupported
+	}
+}
+
+// ProbeHostCapabilities probes the properties of the plugin, and the foreign functions by calling them with an
+// empty argument, so only the functions doing nothing in that case should be probed. The host returns NotFound
+// for the unknown functions without calling any. It's called in OnPluginStart without foreign functions.
+func ProbeHostCapabilities(foreignFunctions ...string) map[Capability]CapabilityState {
+	for _, capability := range []Capability{CapabilityPluginName, CapabilityPluginRootID, CapabilityPluginVmID} {
+		_, err := proxywasm.GetProperty([]string{string(capability)})
+		recordCapability(capability, err)
+	}
+	for _, name := range foreignFunctions {
+		_, err := proxywasm.CallForeignFunction(name, nil)
+		if err != nil && err != types.ErrorStatusNotFound && err != types.ErrorUnimplemented {
+			// the function exists but rejects the empty argument
+			err = nil
+		}
+		recordCapability(ForeignFunctionCapability(name), err)
+	}
+	return HostCapabilities()
+}
+
+// setCapabilityProperty sets the property extending the host, it's skipped once the host is found not to support
+// it, and the warning is logged only for the first failure. ErrCapabilityUnsupported is returned in that case, so the
+// caller can fall back.
+func setCapabilityProperty(capability Capability, value []byte, log Log) error {
+	if hostCapabilities[capability] == CapabilityUnsupported {
+		return fmt.Errorf("%w: %s", ErrCapabilityUnsupported, capability)
+	}
+	err := proxywasm.SetProperty([]string{string(capability)}, value)
+	recordCapability(capability, err)
+	if err == nil {
+		return nil
+	}
+	log.Warnf("failed to set %s, the host may not support it: %v", capability, err)
+	if hostCapabilities[capability] == CapabilityUnsupported {
+		return fmt.Errorf("%w: %s", ErrCapabilityUnsupported, capability)
+	}
+	return err
+}
+
+// PropertyDump is a property read by DumpProperties.
+type PropertyDump struct {
+	Path  string `json:"path"`
+	Found bool   `json:"found"`
+	// The value if it's a valid UTF-8 string, or "hex:" followed by the hex encoded value
+	Value string `json:"value,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// DefaultDumpedProperties are dumped by DumpProperties if no path is given.
+var DefaultDumpedProperties = []string{
+	"plugin_name",
+	"plugin_root_id",
+	"plugin_vm_id",
+	"route_name",
+	"cluster_name",
+	"request.path",
+	"request.host",
+	"request.method",
+	"request.protocol",
+	"source.address",
+	"destination.address",
+	"connection.mtls",
+	"connection.requested_server_name",
+	"upstream.address",
+}
+
+// DumpProperties reads the properties of the dot separated paths, e.g. "request.path", to see what the host
+// provides in the current context. The default properties are read if no path is given.
+func DumpProperties(paths ...string) []PropertyDump {
+	if len(paths) == 0 {
+		paths = DefaultDumpedProperties
+	}
+	dumps := make([]PropertyDump, 0, len(paths))
+	for _, path := range paths {
+		dump := PropertyDump{Path: path}
+		value, err := proxywasm.GetProperty(strings.Split(path, "."))
+		switch {
+		case err == nil:
+			dump.Found = true
+			if utf8.Valid(value) {
+				dump.Value = string(value)
+			} else {
+				dump.Value = "hex:" + hex.EncodeToString(value)
+			}
+		case err != types.ErrorStatusNotFound:
+			dump.Error = err.Error()
+		}
+		dumps = append(dumps, dump)
+	}
+	return dumps
+}
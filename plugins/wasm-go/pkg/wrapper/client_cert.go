@@ -0,0 +1,317 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+const XfccHeader = "x-forwarded-client-cert"
+
+var (
+	ErrPeerCertificateMissing  = errors.New("peer certificate is missing")
+	ErrPeerCertificateRejected = errors.New("peer certificate is not allowed")
+)
+
+type PeerCertificate struct {
+	Subject string
+	URISANs []string
+	DNSSANs []string
+	// The hex encoded sha256 digest of the DER encoded certificate
+	Fingerprint string
+	// The certificate, only available if the XFCC header has the Cert or Chain field
+	Certificate *x509.Certificate
+}
+
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	inQuote, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			inQuote = !inQuote
+		case s[i] == sep && !inQuote:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unquoteXfccValue(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`)
+	}
+	return value
+}
+
+// ParseXfcc parses the x-forwarded-client-cert header set by envoy, each element describes the client certificate
+// seen by one proxy, and the last element is added by the nearest one.
+func ParseXfcc(value string) ([]PeerCertificate, error) {
+	var certs []PeerCertificate
+	for _, element := range splitQuoted(value, ',') {
+		if strings.TrimSpace(element) == "" {
+			continue
+		}
+		var cert PeerCertificate
+		for _, pair := range splitQuoted(element, ';') {
+			key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+			if !found {
+				return nil, fmt.Errorf("invalid xfcc field: %s", pair)
+			}
+			value = unquoteXfccValue(value)
+			switch strings.ToLower(key) {
+			case "hash":
+				cert.Fingerprint = strings.ToLower(value)
+			case "subject":
+				cert.Subject = value
+			case "uri":
+				cert.URISANs = append(cert.URISANs, value)
+			case "dns":
+				cert.DNSSANs = append(cert.DNSSANs, value)
+			case "cert", "chain":
+				if cert.Certificate != nil {
+					continue
+				}
+				x509Cert, err := parseXfccCertificate(value)
+				if err != nil {
+					return nil, err
+				}
+				cert.Certificate = x509Cert
+			}
+		}
+		if cert.Certificate != nil {
+			fillFromCertificate(&cert)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+func parseXfccCertificate(value string) (*x509.Certificate, error) {
+	decoded, err := url.QueryUnescape(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid xfcc certificate: %v", err)
+	}
+	block, _ := pem.Decode([]byte(decoded))
+	if block == nil {
+		return nil, errors.New("invalid xfcc certificate: no pem block found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func fillFromCertificate(cert *PeerCertificate) {
+	c := cert.Certificate
+	if cert.Subject == "" {
+		cert.Subject = c.Subject.String()
+	}
+	if len(cert.URISANs) == 0 {
+		for _, uri := range c.URIs {
+			cert.URISANs = append(cert.URISANs, uri.String())
+		}
+	}
+	if len(cert.DNSSANs) == 0 {
+		cert.DNSSANs = append(cert.DNSSANs, c.DNSNames...)
+	}
+	if cert.Fingerprint == "" {
+		sum := sha256.Sum256(c.Raw)
+		cert.Fingerprint = hex.EncodeToString(sum[:])
+	}
+}
+
+func getConnectionProperty(name string) string {
+	value, err := proxywasm.GetProperty([]string{"connection", name})
+	if err != nil {
+		return ""
+	}
+	return string(value)
+}
+
+// GetPeerCertificateFromConnection reads the certificate presented by the downstream from the TLS properties,
+// it requires the gateway to terminate TLS with client certificate validation enabled.
+func GetPeerCertificateFromConnection() (PeerCertificate, bool) {
+	cert := PeerCertificate{
+		Subject:     getConnectionProperty("subject_peer_certificate"),
+		Fingerprint: getConnectionProperty("sha256_peer_certificate_digest"),
+	}
+	if uri := getConnectionProperty("uri_san_peer_certificate"); uri != "" {
+		cert.URISANs = []string{uri}
+	}
+	if dns := getConnectionProperty("dns_san_peer_certificate"); dns != "" {
+		cert.DNSSANs = []string{dns}
+	}
+	if cert.Subject == "" && cert.Fingerprint == "" && len(cert.URISANs) == 0 {
+		return cert, false
+	}
+	return cert, true
+}
+
+// GetPeerCertificate reads the downstream certificate from the TLS properties, then from the last element of the
+// XFCC header if trustXfcc is set. Only trust the XFCC header when it is set by a trusted front proxy.
+func GetPeerCertificate(trustXfcc bool) (PeerCertificate, error) {
+	if cert, ok := GetPeerCertificateFromConnection(); ok {
+		return cert, nil
+	}
+	if !trustXfcc {
+		return PeerCertificate{}, ErrPeerCertificateMissing
+	}
+	xfcc, _ := proxywasm.GetHttpRequestHeader(XfccHeader)
+	if xfcc == "" {
+		return PeerCertificate{}, ErrPeerCertificateMissing
+	}
+	certs, err := ParseXfcc(xfcc)
+	if err != nil {
+		return PeerCertificate{}, err
+	}
+	if len(certs) == 0 {
+		return PeerCertificate{}, ErrPeerCertificateMissing
+	}
+	return certs[len(certs)-1], nil
+}
+
+type PeerCertificateConfig struct {
+	// Read the certificate from the XFCC header if the TLS properties are not available
+	TrustXfcc bool
+	// The certificate is allowed if any of the rules matches, all certificates are allowed if no rule is configured.
+	// The patterns parsed from the config are anchored to match the whole subject or SAN.
+	SubjectPatterns []*regexp.Regexp
+	SANPatterns     []*regexp.Regexp
+	Fingerprints    []string
+	// The prefix of the identity headers injected to the upstream, default is "x-client-cert-"
+	HeaderPrefix string
+}
+
+func ParsePeerCertificateConfig(json gjson.Result) (PeerCertificateConfig, error) {
+	config := PeerCertificateConfig{
+		TrustXfcc:    json.Get("trustXfcc").Bool(),
+		HeaderPrefix: json.Get("headerPrefix").String(),
+	}
+	if config.HeaderPrefix == "" {
+		config.HeaderPrefix = "x-client-cert-"
+	}
+	compile := func(field string) ([]*regexp.Regexp, error) {
+		var patterns []*regexp.Regexp
+		for _, item := range json.Get(field).Array() {
+			// the whole value must match, so CN=admin does not accept CN=admin-evil
+			pattern, err := regexp.Compile("^(?:" + item.String() + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %v", field, item.String(), err)
+			}
+			patterns = append(patterns, pattern)
+		}
+		return patterns, nil
+	}
+	var err error
+	if config.SubjectPatterns, err = compile("allowedSubjects"); err != nil {
+		return config, err
+	}
+	if config.SANPatterns, err = compile("allowedSANs"); err != nil {
+		return config, err
+	}
+	for _, item := range json.Get("allowedFingerprints").Array() {
+		fingerprint := strings.ToLower(strings.ReplaceAll(item.String(), ":", ""))
+		config.Fingerprints = append(config.Fingerprints, fingerprint)
+	}
+	return config, nil
+}
+
+func matchAny(patterns []*regexp.Regexp, values ...string) bool {
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if pattern.MatchString(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Validate checks the certificate against the configured subjects, SANs and fingerprints.
+func (c PeerCertificateConfig) Validate(cert PeerCertificate) error {
+	if len(c.SubjectPatterns) == 0 && len(c.SANPatterns) == 0 && len(c.Fingerprints) == 0 {
+		return nil
+	}
+	if cert.Subject != "" && matchAny(c.SubjectPatterns, cert.Subject) {
+		return nil
+	}
+	if matchAny(c.SANPatterns, append(append([]string{}, cert.URISANs...), cert.DNSSANs...)...) {
+		return nil
+	}
+	if cert.Fingerprint != "" && containsString(c.Fingerprints, cert.Fingerprint) {
+		return nil
+	}
+	return ErrPeerCertificateRejected
+}
+
+func sanitizeHeaderValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, value)
+}
+
+// PeerCertificateHeaders returns the identity headers describing the certificate.
+func (c PeerCertificateConfig) PeerCertificateHeaders(cert PeerCertificate) [][2]string {
+	var headers [][2]string
+	add := func(name string, values ...string) {
+		var sanitized []string
+		for _, value := range values {
+			if value = sanitizeHeaderValue(value); value != "" {
+				sanitized = append(sanitized, value)
+			}
+		}
+		if len(sanitized) > 0 {
+			headers = append(headers, [2]string{c.HeaderPrefix + name, strings.Join(sanitized, ",")})
+		}
+	}
+	add("subject", cert.Subject)
+	add("uri-san", cert.URISANs...)
+	add("dns-san", cert.DNSSANs...)
+	add("fingerprint", cert.Fingerprint)
+	return headers
+}
+
+// InjectPeerCertificateHeaders removes the identity headers sent by the client, then sets the ones describing the
+// certificate. The XFCC header is removed as well unless it is trusted.
+func (c PeerCertificateConfig) InjectPeerCertificateHeaders(cert PeerCertificate) {
+	for _, name := range []string{"subject", "uri-san", "dns-san", "fingerprint"} {
+		_ = proxywasm.RemoveHttpRequestHeader(c.HeaderPrefix + name)
+	}
+	if !c.TrustXfcc {
+		_ = proxywasm.RemoveHttpRequestHeader(XfccHeader)
+	}
+	for _, header := range c.PeerCertificateHeaders(cert) {
+		if err := proxywasm.ReplaceHttpRequestHeader(header[0], header[1]); err != nil {
+			proxywasm.LogWarnf("failed to set %s header: %v", header[0], err)
+		}
+	}
+}
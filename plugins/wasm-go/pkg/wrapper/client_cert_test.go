@@ -0,0 +1,99 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestParseXfcc(t *testing.T) {
+	certs, err := ParseXfcc(`By=spiffe://cluster.local/ns/default/sa/gateway;Hash=AB12;Subject="CN=foo,O=\"a;b\"";URI=spiffe://cluster.local/ns/default/sa/foo;DNS=foo.local,Hash=cd34;URI=spiffe://cluster.local/ns/default/sa/bar`)
+	require.NoError(t, err)
+	require.Len(t, certs, 2)
+	assert.Equal(t, PeerCertificate{
+		Subject:     `CN=foo,O="a;b"`,
+		URISANs:     []string{"spiffe://cluster.local/ns/default/sa/foo"},
+		DNSSANs:     []string{"foo.local"},
+		Fingerprint: "ab12",
+	}, certs[0])
+	assert.Equal(t, []string{"spiffe://cluster.local/ns/default/sa/bar"}, certs[1].URISANs)
+
+	_, err = ParseXfcc("Hash")
+	assert.Error(t, err)
+}
+
+func TestParseXfccCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	spiffeID, _ := url.Parse("spiffe://example.org/workload")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "workload"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		URIs:         []*url.URL{spiffeID},
+		DNSNames:     []string{"workload.example.org"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	encoded := url.QueryEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+
+	certs, err := ParseXfcc(`Cert="` + encoded + `"`)
+	require.NoError(t, err)
+	require.Len(t, certs, 1)
+	assert.Equal(t, "CN=workload", certs[0].Subject)
+	assert.Equal(t, []string{"spiffe://example.org/workload"}, certs[0].URISANs)
+	assert.Equal(t, []string{"workload.example.org"}, certs[0].DNSSANs)
+	assert.Len(t, certs[0].Fingerprint, 64)
+}
+
+func TestPeerCertificateConfig(t *testing.T) {
+	config, err := ParsePeerCertificateConfig(gjson.Parse(`{
+		"allowedSubjects": ["CN=admin,.*", "CN=root"],
+		"allowedSANs": ["spiffe://cluster\\.local/ns/prod/.*"],
+		"allowedFingerprints": ["AB:CD"]
+	}`))
+	require.NoError(t, err)
+	assert.NoError(t, config.Validate(PeerCertificate{Subject: "CN=admin,O=foo"}))
+	assert.NoError(t, config.Validate(PeerCertificate{URISANs: []string{"spiffe://cluster.local/ns/prod/sa/foo"}}))
+	assert.NoError(t, config.Validate(PeerCertificate{Fingerprint: "abcd"}))
+	assert.NoError(t, config.Validate(PeerCertificate{Subject: "CN=root"}))
+	assert.ErrorIs(t, config.Validate(PeerCertificate{Subject: "CN=guest", Fingerprint: "ef"}), ErrPeerCertificateRejected)
+	// the patterns match the whole value
+	assert.ErrorIs(t, config.Validate(PeerCertificate{Subject: "CN=root-evil,O=foo"}), ErrPeerCertificateRejected)
+	assert.ErrorIs(t, config.Validate(PeerCertificate{Subject: "O=foo,CN=admin,"}), ErrPeerCertificateRejected)
+	assert.ErrorIs(t, config.Validate(PeerCertificate{URISANs: []string{"spiffe://evil/spiffe://cluster.local/ns/prod/sa"}}), ErrPeerCertificateRejected)
+
+	assert.Equal(t, [][2]string{
+		{"x-client-cert-subject", "CN=foo"},
+		{"x-client-cert-uri-san", "spiffe://a,spiffe://b"},
+	}, config.PeerCertificateHeaders(PeerCertificate{Subject: "CN=foo\r\n", URISANs: []string{"spiffe://a", "spiffe://b"}}))
+
+	_, err = ParsePeerCertificateConfig(gjson.Parse(`{"allowedSANs": ["("]}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,275 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+// The routes of the gateway match the header to select the upstream cluster picked by the ring, or the endpoint if
+// the members are the hosts of a cluster with header based host override
+const HashUpstreamHeader = "x-higress-hash-upstream"
+
+const hashMemberContextKey = "consistent-hash-member:"
+
+var ErrNoHashMember = errors.New("no member is available in the hash ring")
+
+type ConsistentHashConfig struct {
+	// The virtual nodes of a member of weight 1 on the ring, default is 160
+	Replicas int
+	// A member takes at most LoadFactor times the average load, default is 1.25, values below 1 are raised to 1.
+	// Keys move to the next member on the ring when theirs is full, so a hot consumer doesn't overload one upstream.
+	LoadFactor float64
+	// The request header set to the picked member, default is x-higress-hash-upstream
+	Header string
+	// The members and their weights, default weight is 1
+	Members map[string]int
+}
+
+func ParseConsistentHashConfig(json gjson.Result) ConsistentHashConfig {
+	config := ConsistentHashConfig{
+		Replicas:   int(json.Get("replicas").Int()),
+		LoadFactor: json.Get("loadFactor").Float(),
+		Header:     json.Get("header").String(),
+		Members:    map[string]int{},
+	}
+	for _, member := range json.Get("members").Array() {
+		if member.IsObject() {
+			config.Members[member.Get("name").String()] = int(member.Get("weight").Int())
+		} else {
+			config.Members[member.String()] = 0
+		}
+	}
+	return config
+}
+
+type hashPoint struct {
+	hash   uint64
+	member string
+}
+
+// ConsistentHash maps the keys, e.g. consumers or sessions, to the members of a ring, so the requests of a key stick
+// to the same upstream and only the keys of a removed member move. It's the bounded-load variant: the in-flight
+// requests of this VM are counted per member, and a member at its capacity is skipped.
+type ConsistentHash struct {
+	config  ConsistentHashConfig
+	weights map[string]int
+	// the sum of the weights
+	weight  int
+	points  []hashPoint
+	loads   map[string]int
+	total   int
+	healthy func(member string) bool
+}
+
+func NewConsistentHash(config ConsistentHashConfig) (*ConsistentHash, error) {
+	if config.Replicas <= 0 {
+		config.Replicas = 160
+	}
+	if config.LoadFactor == 0 {
+		config.LoadFactor = 1.25
+	}
+	if config.LoadFactor < 1 {
+		config.LoadFactor = 1
+	}
+	if config.Header == "" {
+		config.Header = HashUpstreamHeader
+	}
+	h := &ConsistentHash{config: config, weights: map[string]int{}, loads: map[string]int{}}
+	for member, weight := range config.Members {
+		if err := h.Add(member, weight); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// SetHealthCheck sets the check of the members, the unhealthy ones are skipped, e.g. OutlierDetector.IsHealthy.
+func (h *ConsistentHash) SetHealthCheck(healthy func(member string) bool) {
+	h.healthy = healthy
+}
+
+// Add adds the member or updates its weight, the weight is 1 if it's not positive.
+func (h *ConsistentHash) Add(member string, weight int) error {
+	if member == "" {
+		return errors.New("hash ring member is empty")
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	h.weights[member] = weight
+	h.build()
+	return nil
+}
+
+func (h *ConsistentHash) Remove(member string) {
+	if _, ok := h.weights[member]; !ok {
+		return
+	}
+	delete(h.weights, member)
+	h.build()
+}
+
+// Members returns the members sorted by name.
+func (h *ConsistentHash) Members() []string {
+	members := make([]string, 0, len(h.weights))
+	for member := range h.weights {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// Load returns the in-flight requests of the member.
+func (h *ConsistentHash) Load(member string) int {
+	return h.loads[member]
+}
+
+func (h *ConsistentHash) build() {
+	h.points = h.points[:0]
+	h.weight = 0
+	for member, weight := range h.weights {
+		h.weight += weight
+		for i := 0; i < h.config.Replicas*weight; i++ {
+			h.points = append(h.points, hashPoint{hash: hashKey(member + "#" + strconv.Itoa(i)), member: member})
+		}
+	}
+	sort.Slice(h.points, func(i, j int) bool {
+		if h.points[i].hash != h.points[j].hash {
+			return h.points[i].hash < h.points[j].hash
+		}
+		return h.points[i].member < h.points[j].member
+	})
+}
+
+func hashKey(key string) uint64 {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(key))
+	// fnv mixes the last bytes poorly, the finalizer of murmur3 spreads them
+	x := hash.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// capacity returns the most in-flight requests the member can take with one more request.
+func (h *ConsistentHash) capacity(member string) int {
+	share := float64(h.total+1) * float64(h.weights[member]) / float64(h.weight)
+	return int(math.Ceil(share * h.config.LoadFactor))
+}
+
+// Get returns the member of the key on the ring without considering the loads, it's stable as long as the member is
+// on the ring and healthy.
+func (h *ConsistentHash) Get(key string) (string, bool) {
+	return h.walk(key, func(string) bool { return true })
+}
+
+// Acquire returns the member of the key with the bounded load, and counts the request in its load. Call Release once
+// the request is done.
+func (h *ConsistentHash) Acquire(key string) (string, bool) {
+	member, ok := h.walk(key, func(member string) bool { return h.loads[member] < h.capacity(member) })
+	if !ok {
+		return "", false
+	}
+	h.loads[member]++
+	h.total++
+	return member, true
+}
+
+func (h *ConsistentHash) Release(member string) {
+	if h.loads[member] <= 0 {
+		return
+	}
+	h.loads[member]--
+	h.total--
+	if h.loads[member] == 0 {
+		delete(h.loads, member)
+	}
+}
+
+// walk returns the first member clockwise from the key which is healthy and accepted.
+func (h *ConsistentHash) walk(key string, accept func(member string) bool) (string, bool) {
+	if len(h.points) == 0 {
+		return "", false
+	}
+	hash := hashKey(key)
+	start := sort.Search(len(h.points), func(i int) bool { return h.points[i].hash >= hash })
+	visited := map[string]bool{}
+	for i := 0; i < len(h.points) && len(visited) < len(h.weights); i++ {
+		member := h.points[(start+i)%len(h.points)].member
+		if visited[member] {
+			continue
+		}
+		visited[member] = true
+		if h.healthy != nil && !h.healthy(member) {
+			continue
+		}
+		if accept(member) {
+			return member, true
+		}
+	}
+	return "", false
+}
+
+// HashKeyOfRequest returns the key of the request for sticky routing: the authenticated consumer, or the value of the
+// session header, or the client ip if both are absent.
+func HashKeyOfRequest(ctx HttpContext, sessionHeader string) string {
+	if consumer := GetAuthenticatedConsumer(ctx); consumer != nil && consumer.Name != "" {
+		return "consumer:" + consumer.Name
+	}
+	if sessionHeader != "" {
+		if session, err := proxywasm.GetHttpRequestHeader(sessionHeader); err == nil && session != "" {
+			return "session:" + session
+		}
+	}
+	return "client:" + GetClientIP("")
+}
+
+// RouteRequest picks the member of the key with the bounded load and sets the header of the ring to it, so the
+// gateway routes the request to the member. It should be called in the request headers phase, and ReleaseRequest
+// in the stream done phase to release the load.
+func (h *ConsistentHash) RouteRequest(ctx HttpContext, key string) (string, error) {
+	member, ok := h.Acquire(key)
+	if !ok {
+		return "", ErrNoHashMember
+	}
+	ctx.SetContext(hashMemberContextKey+h.config.Header, member)
+	if err := proxywasm.ReplaceHttpRequestHeader(h.config.Header, member); err != nil {
+		h.ReleaseRequest(ctx)
+		return "", fmt.Errorf("failed to set %s: %v", h.config.Header, err)
+	}
+	DebugDecision(ctx, "key %s is routed to %s", key, member)
+	return member, nil
+}
+
+// ReleaseRequest releases the load of the member picked by RouteRequest, it does nothing if none is picked.
+func (h *ConsistentHash) ReleaseRequest(ctx HttpContext) {
+	key := hashMemberContextKey + h.config.Header
+	if member, ok := ctx.GetContext(key).(string); ok && member != "" {
+		h.Release(member)
+		ctx.SetContext(key, "")
+	}
+}
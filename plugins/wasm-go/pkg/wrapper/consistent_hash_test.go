@@ -0,0 +1,117 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func newTestHash(t *testing.T, members ...string) *ConsistentHash {
+	config := ConsistentHashConfig{Members: map[string]int{}}
+	for _, member := range members {
+		config.Members[member] = 1
+	}
+	h, err := NewConsistentHash(config)
+	require.NoError(t, err)
+	return h
+}
+
+func TestParseConsistentHashConfig(t *testing.T) {
+	config := ParseConsistentHashConfig(gjson.Parse(`{"loadFactor": 1.5, "members": ["a", {"name": "b", "weight": 3}]}`))
+	assert.Equal(t, 1.5, config.LoadFactor)
+	assert.Equal(t, map[string]int{"a": 0, "b": 3}, config.Members)
+	h, err := NewConsistentHash(config)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, h.Members())
+	assert.Equal(t, HashUpstreamHeader, h.config.Header)
+	assert.Len(t, h.points, 160*4)
+
+	_, err = NewConsistentHash(ConsistentHashConfig{Members: map[string]int{"": 1}})
+	assert.Error(t, err)
+}
+
+func TestConsistentHashGet(t *testing.T) {
+	h := newTestHash(t)
+	_, ok := h.Get("alice")
+	assert.False(t, ok)
+
+	h = newTestHash(t, "a", "b", "c", "d")
+	counts := map[string]int{}
+	before := map[string]string{}
+	for i := 0; i < 4000; i++ {
+		key := fmt.Sprintf("consumer-%d", i)
+		member, ok := h.Get(key)
+		require.True(t, ok)
+		counts[member]++
+		before[key] = member
+	}
+	for _, member := range h.Members() {
+		assert.InDelta(t, 1000, counts[member], 250, member)
+	}
+
+	// only the keys of the removed member move
+	h.Remove("c")
+	for key, member := range before {
+		after, _ := h.Get(key)
+		if member != "c" {
+			assert.Equal(t, member, after, key)
+		} else {
+			assert.NotEqual(t, "c", after, key)
+		}
+	}
+
+	// the unhealthy members are skipped
+	member, _ := h.Get("consumer-1")
+	h.SetHealthCheck(func(m string) bool { return m != member })
+	other, ok := h.Get("consumer-1")
+	assert.True(t, ok)
+	assert.NotEqual(t, member, other)
+	h.SetHealthCheck(func(string) bool { return false })
+	_, ok = h.Get("consumer-1")
+	assert.False(t, ok)
+}
+
+func TestConsistentHashBoundedLoad(t *testing.T) {
+	h := newTestHash(t, "a", "b", "c", "d")
+	sticky, _ := h.Get("hot")
+
+	// the hot key takes at most 1.25 times the average load of its member, then it spills to the next ones
+	members := []string{}
+	for i := 0; i < 40; i++ {
+		member, ok := h.Acquire("hot")
+		require.True(t, ok)
+		members = append(members, member)
+	}
+	assert.Equal(t, sticky, members[0])
+	assert.Equal(t, 13, h.Load(sticky))
+	for _, member := range h.Members() {
+		assert.LessOrEqual(t, h.Load(member), 13, member)
+	}
+
+	for _, member := range members {
+		h.Release(member)
+	}
+	for _, member := range h.Members() {
+		assert.Equal(t, 0, h.Load(member))
+	}
+	assert.Equal(t, 0, h.total)
+	h.Release("a")
+	assert.Equal(t, 0, h.total)
+}
@@ -0,0 +1,251 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// ConsumerHeader carries the authenticated consumer name to the plugins running after the auth plugin and to the upstream
+	ConsumerHeader = "x-mse-consumer"
+
+	authenticatedConsumerKey = "__authenticated_consumer__"
+)
+
+const (
+	CredentialTypeKey    = "key"
+	CredentialTypeBasic  = "basic"
+	CredentialTypeJwt    = "jwt"
+	CredentialTypeHmac   = "hmac"
+	CredentialTypeOAuth2 = "oauth2"
+)
+
+type Credential struct {
+	Type string
+	// The api key, "username:password" for basic auth, the issuer and subject "iss:sub" for jwt, the access key for hmac
+	// and the client id for oauth2
+	Value string
+	// The secret paired with Value, e.g. the hmac secret
+	Secret string
+}
+
+// ConsumerLimits are hints for the rate limit and quota plugins, zero means no limit.
+type ConsumerLimits struct {
+	QueryPerSecond int64
+	QueryPerMinute int64
+	QueryPerHour   int64
+	QueryPerDay    int64
+	Quota          int64
+	QuotaPeriod    QuotaPeriod
+}
+
+type Consumer struct {
+	Name        string
+	Credentials []Credential
+	Metadata    map[string]string
+	Limits      ConsumerLimits
+}
+
+// ConsumerRegistry indexes the consumers by name and by credential, the basic credentials are indexed by username,
+// so the password is compared in constant time rather than by a map lookup.
+type ConsumerRegistry struct {
+	consumers    []*Consumer
+	byName       map[string]*Consumer
+	byCredential map[Credential]*Consumer
+	byUsername   map[string]basicCredential
+}
+
+type basicCredential struct {
+	consumer *Consumer
+	password string
+}
+
+var trustConsumerHeader bool
+
+// SetTrustConsumerHeader makes GetAuthenticatedConsumer fall back to the consumer header set by a previous auth plugin
+// in the chain. The header is sent by the client if no auth plugin runs before, so only enable it if every route the
+// plugin is applied to is authenticated first. Call it in the parseConfig phase.
+func SetTrustConsumerHeader(trust bool) {
+	trustConsumerHeader = trust
+}
+
+// ParseConsumers parses the common consumers section shared by the auth plugins, for example:
+//
+//	consumers:
+//	- name: consumer1
+//	  credentials:
+//	  - type: key
+//	    value: 2bda943c-ba2b-11ec-ba07-00163e1250b5
+//	  - type: basic
+//	    value: admin:123456
+//	  metadata:
+//	    tenant: foo
+//	  limits:
+//	    query_per_minute: 100
+//	    quota: 10000
+//	    quota_period: daily
+func ParseConsumers(json gjson.Result) (*ConsumerRegistry, error) {
+	r := &ConsumerRegistry{
+		byName:       map[string]*Consumer{},
+		byCredential: map[Credential]*Consumer{},
+		byUsername:   map[string]basicCredential{},
+	}
+	for _, item := range json.Array() {
+		consumer := &Consumer{
+			Name:     item.Get("name").String(),
+			Metadata: map[string]string{},
+		}
+		if consumer.Name == "" {
+			return nil, errors.New("consumer name is required")
+		}
+		if _, exists := r.byName[consumer.Name]; exists {
+			return nil, fmt.Errorf("duplicate consumer name: %s", consumer.Name)
+		}
+		for _, c := range item.Get("credentials").Array() {
+			credential := Credential{
+				Type:  c.Get("type").String(),
+				Value: c.Get("value").String(),
+			}
+			if credential.Type == "" || credential.Value == "" {
+				return nil, fmt.Errorf("invalid credential of consumer %s: %s", consumer.Name, c.Raw)
+			}
+			if credential.Type == CredentialTypeBasic {
+				username, password, found := strings.Cut(credential.Value, ":")
+				if !found {
+					return nil, fmt.Errorf("invalid basic credential of consumer %s, expect username:password", consumer.Name)
+				}
+				if other, exists := r.byUsername[username]; exists {
+					return nil, fmt.Errorf("consumer %s and %s have the same basic username %s", other.consumer.Name, consumer.Name, username)
+				}
+				r.byUsername[username] = basicCredential{consumer: consumer, password: password}
+			} else {
+				if other, exists := r.byCredential[credential]; exists {
+					return nil, fmt.Errorf("consumer %s and %s have the same %s credential", other.Name, consumer.Name, credential.Type)
+				}
+				r.byCredential[credential] = consumer
+			}
+			credential.Secret = c.Get("secret").String()
+			consumer.Credentials = append(consumer.Credentials, credential)
+		}
+		item.Get("metadata").ForEach(func(key, value gjson.Result) bool {
+			consumer.Metadata[key.String()] = value.String()
+			return true
+		})
+		limits := item.Get("limits")
+		consumer.Limits = ConsumerLimits{
+			QueryPerSecond: limits.Get("query_per_second").Int(),
+			QueryPerMinute: limits.Get("query_per_minute").Int(),
+			QueryPerHour:   limits.Get("query_per_hour").Int(),
+			QueryPerDay:    limits.Get("query_per_day").Int(),
+			Quota:          limits.Get("quota").Int(),
+			QuotaPeriod:    QuotaPeriod(limits.Get("quota_period").String()),
+		}
+		r.consumers = append(r.consumers, consumer)
+		r.byName[consumer.Name] = consumer
+	}
+	return r, nil
+}
+
+func (r *ConsumerRegistry) Consumers() []*Consumer {
+	return r.consumers
+}
+
+func (r *ConsumerRegistry) Get(name string) (*Consumer, bool) {
+	consumer, ok := r.byName[name]
+	return consumer, ok
+}
+
+// Lookup finds the consumer owning the credential, the value is compared exactly. The basic credential
+// "username:password" is looked up by the username, then the password is compared with BasicAuthPasswordEqual.
+func (r *ConsumerRegistry) Lookup(credentialType, value string) (*Consumer, bool) {
+	if credentialType == CredentialTypeBasic {
+		username, password, _ := strings.Cut(value, ":")
+		basic, ok := r.byUsername[username]
+		if !ok || !BasicAuthPasswordEqual(basic.password, password) {
+			return nil, false
+		}
+		return basic.consumer, true
+	}
+	consumer, ok := r.byCredential[Credential{Type: credentialType, Value: value}]
+	return consumer, ok
+}
+
+// Credential returns the first credential of the type.
+func (c *Consumer) Credential(credentialType string) (Credential, bool) {
+	for _, credential := range c.Credentials {
+		if credential.Type == credentialType {
+			return credential, true
+		}
+	}
+	return Credential{}, false
+}
+
+// SetAuthenticatedConsumer records the consumer identified by the auth plugin, the consumer name is also passed to the
+// plugins running after and to the upstream in the x-mse-consumer header. A nil consumer clears the consumer and the
+// header, e.g. when the credentials are found invalid after all.
+func SetAuthenticatedConsumer(ctx HttpContext, consumer *Consumer) {
+	if c, ok := ctx.(httpContextExtension); ok {
+		c.setAuthenticatedConsumer(consumer)
+	}
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) setAuthenticatedConsumer(consumer *Consumer) {
+	if consumer == nil {
+		delete(ctx.userContext, authenticatedConsumerKey)
+		delete(ctx.userAttribute, "consumer")
+		proxywasm.SetEffectiveContext(ctx.contextID)
+		if err := proxywasm.RemoveHttpRequestHeader(ConsumerHeader); err != nil {
+			ctx.plugin.vm.log.Warnf("failed to remove consumer header: %v", err)
+		}
+		return
+	}
+	ctx.userContext[authenticatedConsumerKey] = consumer
+	ctx.userAttribute["consumer"] = consumer.Name
+	proxywasm.SetEffectiveContext(ctx.contextID)
+	if err := proxywasm.ReplaceHttpRequestHeader(ConsumerHeader, consumer.Name); err != nil {
+		ctx.plugin.vm.log.Warnf("failed to set consumer header: %v", err)
+	}
+}
+
+// GetAuthenticatedConsumer returns the consumer identified in this plugin, or by a previous auth plugin in the chain
+// with only the name filled, which is read from the header only if SetTrustConsumerHeader is enabled, as the header
+// can be forged by the client.
+func GetAuthenticatedConsumer(ctx HttpContext) *Consumer {
+	if c, ok := ctx.(httpContextExtension); ok {
+		return c.getAuthenticatedConsumer()
+	}
+	return nil
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) getAuthenticatedConsumer() *Consumer {
+	if consumer, ok := ctx.userContext[authenticatedConsumerKey].(*Consumer); ok {
+		return consumer
+	}
+	if !trustConsumerHeader {
+		return nil
+	}
+	proxywasm.SetEffectiveContext(ctx.contextID)
+	name, _ := proxywasm.GetHttpRequestHeader(ConsumerHeader)
+	if name == "" {
+		return nil
+	}
+	return &Consumer{Name: name}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestParseConsumers(t *testing.T) {
+	registry, err := ParseConsumers(gjson.Parse(`[
+		{
+			"name": "consumer1",
+			"credentials": [
+				{"type": "key", "value": "token1"},
+				{"type": "hmac", "value": "ak1", "secret": "sk1"},
+				{"type": "basic", "value": "admin:p:ss"}
+			],
+			"metadata": {"tenant": "foo"},
+			"limits": {"query_per_minute": 100, "quota": 1000, "quota_period": "daily"}
+		},
+		{
+			"name": "consumer2",
+			"credentials": [{"type": "key", "value": "token2"}]
+		}
+	]`))
+	require.NoError(t, err)
+	assert.Len(t, registry.Consumers(), 2)
+
+	consumer, ok := registry.Lookup(CredentialTypeKey, "token1")
+	require.True(t, ok)
+	assert.Equal(t, "consumer1", consumer.Name)
+	assert.Equal(t, "foo", consumer.Metadata["tenant"])
+	assert.Equal(t, ConsumerLimits{QueryPerMinute: 100, Quota: 1000, QuotaPeriod: QuotaPeriodDaily}, consumer.Limits)
+
+	consumer, ok = registry.Lookup(CredentialTypeHmac, "ak1")
+	require.True(t, ok)
+	credential, ok := consumer.Credential(CredentialTypeHmac)
+	require.True(t, ok)
+	assert.Equal(t, "sk1", credential.Secret)
+
+	_, ok = registry.Lookup(CredentialTypeBasic, "token1")
+	assert.False(t, ok)
+	consumer, ok = registry.Lookup(CredentialTypeBasic, "admin:p:ss")
+	require.True(t, ok)
+	assert.Equal(t, "consumer1", consumer.Name)
+	for _, value := range []string{"admin:p", "admin:", "admin", ":p:ss", "root:p:ss"} {
+		_, ok = registry.Lookup(CredentialTypeBasic, value)
+		assert.False(t, ok, value)
+	}
+	_, ok = registry.Get("consumer2")
+	assert.True(t, ok)
+}
+
+func TestParseConsumersInvalid(t *testing.T) {
+	cases := []string{
+		`[{"credentials": [{"type": "key", "value": "a"}]}]`,
+		`[{"name": "a"}, {"name": "a"}]`,
+		`[{"name": "a", "credentials": [{"type": "key"}]}]`,
+		`[{"name": "a", "credentials": [{"type": "key", "value": "x"}]}, {"name": "b", "credentials": [{"type": "key", "value": "x"}]}]`,
+		`[{"name": "a", "credentials": [{"type": "basic", "value": "admin"}]}]`,
+		`[{"name": "a", "credentials": [{"type": "basic", "value": "admin:1"}]}, {"name": "b", "credentials": [{"type": "basic", "value": "admin:2"}]}]`,
+	}
+	for _, c := range cases {
+		_, err := ParseConsumers(gjson.Parse(c))
+		assert.Error(t, err, c)
+	}
+}
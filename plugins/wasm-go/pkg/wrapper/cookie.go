@@ -0,0 +1,89 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+)
+
+// Cookie is a cookie set by the set-cookie header of a response.
+type Cookie struct {
+	Name   string
+	Value  string
+	Path   string
+	Domain string
+	// The seconds until the cookie expires, 0 means a session cookie and a negative value deletes the cookie
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	// Strict, Lax or None, it's omitted if empty
+	SameSite string
+}
+
+// String returns the value of the set-cookie header.
+func (c Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	b.WriteString(c.Value)
+	if c.Path != "" {
+		b.WriteString("; Path=")
+		b.WriteString(c.Path)
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=")
+		b.WriteString(c.Domain)
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=")
+		b.WriteString(strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.SameSite != "" {
+		b.WriteString("; SameSite=")
+		b.WriteString(c.SameSite)
+	}
+	return b.String()
+}
+
+// ParseCookie finds the cookie in the value of the cookie header, the first one wins if the name is repeated.
+func ParseCookie(header, name string) (string, bool) {
+	for _, pair := range strings.Split(header, ";") {
+		key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if found && key == name {
+			return strings.Trim(value, `"`), true
+		}
+	}
+	return "", false
+}
+
+// GetRequestCookie returns the cookie of the current request.
+func GetRequestCookie(name string) (string, bool) {
+	header, err := proxywasm.GetHttpRequestHeader("cookie")
+	if err != nil {
+		return "", false
+	}
+	return ParseCookie(header, name)
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCookieString(t *testing.T) {
+	cookie := Cookie{Name: "session", Value: "abc", Path: "/", Domain: "example.com", MaxAge: 60, Secure: true,
+		HttpOnly: true, SameSite: "Lax"}
+	assert.Equal(t, "session=abc; Path=/; Domain=example.com; Max-Age=60; HttpOnly; Secure; SameSite=Lax", cookie.String())
+	assert.Equal(t, "session=; Max-Age=0", Cookie{Name: "session", MaxAge: -1}.String())
+}
+
+func TestParseCookie(t *testing.T) {
+	header := `a=1; session="abc"; empty=; session=shadowed`
+	value, ok := ParseCookie(header, "session")
+	assert.True(t, ok)
+	assert.Equal(t, "abc", value)
+	value, ok = ParseCookie(header, "empty")
+	assert.True(t, ok)
+	assert.Equal(t, "", value)
+	_, ok = ParseCookie(header, "missing")
+	assert.False(t, ok)
+}
@@ -0,0 +1,321 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	corsMatchAll           = "*"
+	corsDefaultAllMethods  = "GET,PUT,POST,DELETE,PATCH,OPTIONS,HEAD,TRACE,CONNECT"
+	corsDefaultMaxAge      = 86400
+	corsResultContextKey   = "__cors_result__"
+	corsDefaultAllowMethod = "GET,PUT,POST,DELETE,PATCH,OPTIONS"
+	corsDefaultAllowHeader = "DNT,X-CustomHeader,Keep-Alive,User-Agent,X-Requested-With," +
+		"If-Modified-Since,Cache-Control,Content-Type,Authorization"
+)
+
+var corsPortsRegex = regexp.MustCompile(`(.*):\[(\*|\d+(,\d+)*)]`)
+
+// CorsPolicy is a CORS engine for the plugins that need CORS alongside other logic, so they can answer preflight
+// requests themselves. It follows the origin patterns of the cors plugin, which keeps its own implementation.
+type CorsPolicy struct {
+	// Exact origins, or "*" for all origins
+	AllowOrigins []string
+	// Origins with "*" anywhere in the host and an optional port list, e.g. "https://*.example.com:[8080,9090]"
+	AllowOriginPatterns []string
+	// Regular expressions matched against the whole origin
+	AllowOriginRegexes []string
+	AllowMethods       []string
+	AllowHeaders       []string
+	ExposeHeaders      []string
+	AllowCredentials   bool
+	// In seconds, default is 86400
+	MaxAge int
+	// Reply 403 to actual requests from disallowed origins instead of passing them without CORS headers
+	RejectDisallowed bool
+
+	originPatterns []*regexp.Regexp
+}
+
+func ParseCorsPolicy(json gjson.Result) (*CorsPolicy, error) {
+	list := func(field string) []string {
+		var values []string
+		for _, item := range json.Get(field).Array() {
+			values = append(values, item.String())
+		}
+		return values
+	}
+	p := &CorsPolicy{
+		AllowOrigins:        list("allow_origins"),
+		AllowOriginPatterns: list("allow_origin_patterns"),
+		AllowOriginRegexes:  list("allow_origin_regexes"),
+		AllowMethods:        list("allow_methods"),
+		AllowHeaders:        list("allow_headers"),
+		ExposeHeaders:       list("expose_headers"),
+		AllowCredentials:    json.Get("allow_credentials").Bool(),
+		MaxAge:              int(json.Get("max_age").Int()),
+		RejectDisallowed:    json.Get("reject_disallowed").Bool(),
+	}
+	return p, p.Init()
+}
+
+// Init validates the policy and compiles the origin patterns, it must be called if the policy is not parsed by ParseCorsPolicy.
+func (p *CorsPolicy) Init() error {
+	if len(p.AllowOrigins) == 0 && len(p.AllowOriginPatterns) == 0 && len(p.AllowOriginRegexes) == 0 && !p.AllowCredentials {
+		p.AllowOrigins = []string{corsMatchAll}
+	}
+	if p.AllowCredentials && containsString(p.AllowOrigins, corsMatchAll) {
+		return errors.New("allow_credentials can not be true when allow_origins is *, use allow_origin_patterns instead")
+	}
+	if len(p.AllowMethods) == 0 {
+		p.AllowMethods = strings.Split(corsDefaultAllowMethod, ",")
+	}
+	if len(p.AllowHeaders) == 0 {
+		p.AllowHeaders = strings.Split(corsDefaultAllowHeader, ",")
+	}
+	for i, method := range p.AllowMethods {
+		p.AllowMethods[i] = strings.ToUpper(strings.TrimSpace(method))
+	}
+	if p.MaxAge <= 0 {
+		p.MaxAge = corsDefaultMaxAge
+	}
+	p.originPatterns = nil
+	for _, pattern := range p.AllowOriginPatterns {
+		p.originPatterns = append(p.originPatterns, compileCorsOriginPattern(pattern))
+	}
+	for _, expr := range p.AllowOriginRegexes {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid allow_origin_regexes %q: %v", expr, err)
+		}
+		p.originPatterns = append(p.originPatterns, re)
+	}
+	return nil
+}
+
+func compileCorsOriginPattern(pattern string) *regexp.Regexp {
+	pattern = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(pattern), "/"))
+	ports := ""
+	if matches := corsPortsRegex.FindStringSubmatch(pattern); matches != nil {
+		pattern, ports = matches[1], matches[2]
+	}
+	expr := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	switch {
+	case ports == corsMatchAll:
+		expr += `(:\d+)?`
+	case ports != "":
+		expr += ":(" + strings.ReplaceAll(ports, ",", "|") + ")"
+	}
+	return regexp.MustCompile("^" + expr + "$")
+}
+
+// CorsRequest holds the request headers relevant to CORS.
+type CorsRequest struct {
+	Scheme string
+	Host   string
+	Method string
+	Origin string
+	// The access-control-request-method and access-control-request-headers headers of a preflight request
+	RequestMethod  string
+	RequestHeaders string
+}
+
+type CorsResult struct {
+	// Whether the request is cross-origin, same-origin requests and requests without origin are not
+	IsCors      bool
+	IsPreflight bool
+	Allowed     bool
+	Reason      string
+	// The headers to add to the response, or to the preflight reply
+	Headers [][2]string
+}
+
+func (p *CorsPolicy) originAllowed(origin string) bool {
+	lower := strings.ToLower(origin)
+	for _, allowed := range p.AllowOrigins {
+		if allowed == corsMatchAll || strings.ToLower(strings.TrimSuffix(allowed, "/")) == lower {
+			return true
+		}
+	}
+	for _, pattern := range p.originPatterns {
+		if pattern.MatchString(lower) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *CorsPolicy) methodAllowed(method string) bool {
+	method = strings.ToUpper(method)
+	return containsString(p.AllowMethods, corsMatchAll) || containsString(p.AllowMethods, method)
+}
+
+func (p *CorsPolicy) headersAllowed(requestHeaders string) bool {
+	if requestHeaders == "" || containsString(p.AllowHeaders, corsMatchAll) {
+		return true
+	}
+	for _, header := range strings.Split(requestHeaders, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		found := false
+		for _, allowed := range p.AllowHeaders {
+			if strings.EqualFold(header, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func isSameOrigin(scheme, host, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	defaultPort := map[string]string{"http": "80", "https": "443"}
+	hostPort := func(scheme, host string) string {
+		if strings.LastIndexByte(host, ':') <= strings.LastIndexByte(host, ']') {
+			host += ":" + defaultPort[scheme]
+		}
+		return strings.ToLower(host)
+	}
+	return strings.EqualFold(u.Scheme, scheme) && hostPort(u.Scheme, u.Host) == hostPort(scheme, host)
+}
+
+// Evaluate applies the policy to the request.
+func (p *CorsPolicy) Evaluate(req CorsRequest) CorsResult {
+	origin := strings.TrimSuffix(strings.TrimSpace(req.Origin), "/")
+	result := CorsResult{Allowed: true}
+	if origin == "" || isSameOrigin(req.Scheme, req.Host, origin) {
+		return result
+	}
+	result.IsCors = true
+	result.IsPreflight = strings.EqualFold(req.Method, http.MethodOptions) && req.RequestMethod != ""
+	method := req.Method
+	if result.IsPreflight {
+		method = req.RequestMethod
+	}
+	switch {
+	case !p.originAllowed(origin):
+		result.Allowed, result.Reason = false, fmt.Sprintf("origin %s is not allowed", origin)
+	case !p.methodAllowed(method):
+		result.Allowed, result.Reason = false, fmt.Sprintf("method %s is not allowed", method)
+	case result.IsPreflight && !p.headersAllowed(req.RequestHeaders):
+		result.Allowed, result.Reason = false, fmt.Sprintf("headers %s are not allowed", req.RequestHeaders)
+	}
+	if !result.Allowed {
+		return result
+	}
+	allowOrigin := origin
+	if !p.AllowCredentials && len(p.AllowOrigins) == 1 && p.AllowOrigins[0] == corsMatchAll {
+		allowOrigin = corsMatchAll
+	}
+	result.Headers = append(result.Headers, [2]string{"access-control-allow-origin", allowOrigin})
+	if allowOrigin != corsMatchAll {
+		result.Headers = append(result.Headers, [2]string{"vary", "Origin"})
+	}
+	if p.AllowCredentials {
+		result.Headers = append(result.Headers, [2]string{"access-control-allow-credentials", "true"})
+	}
+	if result.IsPreflight {
+		allowMethods := strings.Join(p.AllowMethods, ",")
+		if containsString(p.AllowMethods, corsMatchAll) {
+			allowMethods = corsDefaultAllMethods
+		}
+		result.Headers = append(result.Headers, [2]string{"access-control-allow-methods", allowMethods})
+		allowHeaders := strings.Join(p.AllowHeaders, ",")
+		if containsString(p.AllowHeaders, corsMatchAll) {
+			// "*" is not a wildcard for credentialed requests, so echo the requested headers
+			allowHeaders = req.RequestHeaders
+		}
+		if allowHeaders != "" {
+			result.Headers = append(result.Headers, [2]string{"access-control-allow-headers", allowHeaders})
+		}
+		result.Headers = append(result.Headers, [2]string{"access-control-max-age", strconv.Itoa(p.MaxAge)})
+	} else if len(p.ExposeHeaders) > 0 {
+		result.Headers = append(result.Headers, [2]string{"access-control-expose-headers", strings.Join(p.ExposeHeaders, ",")})
+	}
+	return result
+}
+
+// OnRequestHeaders should be called in the request headers phase. Preflight requests are answered directly, and
+// the result of actual requests is kept in the context for OnResponseHeaders.
+func (p *CorsPolicy) OnRequestHeaders(ctx HttpContext) types.Action {
+	origin, _ := proxywasm.GetHttpRequestHeader("origin")
+	if origin == "" {
+		return types.ActionContinue
+	}
+	requestMethod, _ := proxywasm.GetHttpRequestHeader("access-control-request-method")
+	requestHeaders, _ := proxywasm.GetHttpRequestHeader("access-control-request-headers")
+	result := p.Evaluate(CorsRequest{
+		Scheme:         ctx.Scheme(),
+		Host:           ctx.Host(),
+		Method:         ctx.Method(),
+		Origin:         origin,
+		RequestMethod:  requestMethod,
+		RequestHeaders: requestHeaders,
+	})
+	if !result.IsCors {
+		return types.ActionContinue
+	}
+	if !result.Allowed && (result.IsPreflight || p.RejectDisallowed) {
+		_ = proxywasm.SendHttpResponseWithDetail(http.StatusForbidden, "cors.forbidden", nil, []byte("Invalid CORS request"), -1)
+		return types.ActionPause
+	}
+	if result.IsPreflight {
+		_ = proxywasm.SendHttpResponseWithDetail(http.StatusOK, "cors.preflight", result.Headers, nil, -1)
+		return types.ActionPause
+	}
+	ctx.SetContext(corsResultContextKey, result)
+	return types.ActionContinue
+}
+
+// OnResponseHeaders adds the CORS headers to the response of an allowed actual request, the ones set by the
+// upstream are replaced.
+func (p *CorsPolicy) OnResponseHeaders(ctx HttpContext) {
+	result, ok := ctx.GetContext(corsResultContextKey).(CorsResult)
+	if !ok || !result.Allowed {
+		return
+	}
+	for _, header := range result.Headers {
+		if header[0] == "vary" {
+			vary, _ := proxywasm.GetHttpResponseHeader("vary")
+			if vary != "" && !strings.Contains(strings.ToLower(vary), "origin") {
+				header[1] = vary + ", " + header[1]
+			} else if vary != "" {
+				continue
+			}
+		}
+		_ = proxywasm.ReplaceHttpResponseHeader(header[0], header[1])
+	}
+}
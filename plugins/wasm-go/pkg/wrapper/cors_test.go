@@ -0,0 +1,88 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestCorsPolicyDefault(t *testing.T) {
+	p, err := ParseCorsPolicy(gjson.Parse(`{}`))
+	require.NoError(t, err)
+
+	result := p.Evaluate(CorsRequest{Scheme: "https", Host: "api.example.com", Method: "GET", Origin: "https://api.example.com:443"})
+	assert.False(t, result.IsCors)
+
+	result = p.Evaluate(CorsRequest{Scheme: "https", Host: "api.example.com", Method: "GET", Origin: "https://web.example.com"})
+	assert.True(t, result.IsCors)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, [][2]string{{"access-control-allow-origin", "*"}}, result.Headers)
+}
+
+func TestCorsPolicyPreflight(t *testing.T) {
+	p, err := ParseCorsPolicy(gjson.Parse(`{
+		"allow_origin_patterns": ["https://*.example.com:[*]"],
+		"allow_origin_regexes": ["http://localhost:30[0-9]{2}"],
+		"allow_methods": ["get", "post"],
+		"allow_headers": ["content-type", "x-token"],
+		"expose_headers": ["x-request-id"],
+		"allow_credentials": true,
+		"max_age": 600
+	}`))
+	require.NoError(t, err)
+
+	result := p.Evaluate(CorsRequest{
+		Scheme: "https", Host: "api.example.org", Method: "OPTIONS",
+		Origin: "https://web.example.com:8443", RequestMethod: "POST", RequestHeaders: "Content-Type, X-Token",
+	})
+	assert.True(t, result.IsPreflight)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, [][2]string{
+		{"access-control-allow-origin", "https://web.example.com:8443"},
+		{"vary", "Origin"},
+		{"access-control-allow-credentials", "true"},
+		{"access-control-allow-methods", "GET,POST"},
+		{"access-control-allow-headers", "content-type,x-token"},
+		{"access-control-max-age", "600"},
+	}, result.Headers)
+
+	result = p.Evaluate(CorsRequest{Scheme: "https", Host: "api.example.org", Method: "GET", Origin: "http://localhost:3000"})
+	assert.True(t, result.Allowed)
+	assert.Contains(t, result.Headers, [2]string{"access-control-expose-headers", "x-request-id"})
+
+	denied := []CorsRequest{
+		{Method: "GET", Origin: "https://example.com.evil.org"},
+		{Method: "GET", Origin: "http://localhost:4000"},
+		{Method: "OPTIONS", Origin: "https://web.example.com", RequestMethod: "DELETE"},
+		{Method: "OPTIONS", Origin: "https://web.example.com", RequestMethod: "GET", RequestHeaders: "x-other"},
+	}
+	for _, req := range denied {
+		req.Scheme, req.Host = "https", "api.example.org"
+		result = p.Evaluate(req)
+		assert.False(t, result.Allowed, req)
+		assert.Empty(t, result.Headers)
+	}
+}
+
+func TestCorsPolicyInvalid(t *testing.T) {
+	_, err := ParseCorsPolicy(gjson.Parse(`{"allow_origins": ["*"], "allow_credentials": true}`))
+	assert.Error(t, err)
+	_, err = ParseCorsPolicy(gjson.Parse(`{"allow_origin_regexes": ["("]}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/matcher"
+)
+
+const (
+	// The timeout of the route overridden by the client, Envoy honors it for the trusted downstreams
+	UpstreamTimeoutHeader = "x-envoy-upstream-rq-timeout-ms"
+	// The timeout expected by the Envoy in front of the gateway
+	ExpectedTimeoutHeader = "x-envoy-expected-rq-timeout-ms"
+)
+
+var ErrDeadlineExceeded = errors.New("request deadline exceeded")
+
+// routeDeadline is the route timeout set by the parse func of a config.
+type routeDeadline struct {
+	timeout      time.Duration
+	trustHeaders bool
+}
+
+// parsingDeadline collects the route timeout set while a config is parsed.
+var parsingDeadline routeDeadline
+
+// SetDefaultRouteTimeout sets the route timeout the deadline of the requests is derived from, it's usually the
+// timeout of the routes the config is applied to, and 0 means the requests have no deadline. Call it in the
+// parseConfig or parseRuleConfig phase, it applies to the requests matching the config being parsed, and the rules
+// inherit the one of the global config.
+func SetDefaultRouteTimeout(timeout time.Duration) {
+	parsingDeadline.timeout = timeout
+}
+
+// SetTrustTimeoutHeaders makes the timeout headers of the request override the route timeout. The headers are set
+// by the client, so only trust them if the gateway is behind a proxy that sets or strips them, otherwise a client
+// could make every callout fail with ErrDeadlineExceeded. Call it in the parseConfig or parseRuleConfig phase like
+// SetDefaultRouteTimeout.
+func SetTrustTimeoutHeaders(trust bool) {
+	parsingDeadline.trustHeaders = trust
+}
+
+// routeDeadlines holds the route timeouts of the global config and the rules, parsed again on every configuration.
+type routeDeadlines struct {
+	global routeDeadline
+	rules  []routeDeadline
+}
+
+// parse records the route timeout set by the parse func of the global config or a rule, the rules are told by the
+// _match_*_ keys and appended in the order of _rules_, as the matcher parses them.
+func (d *routeDeadlines) parse(json gjson.Result, rule bool, parse func() error) error {
+	rule = rule || isRuleJson(json)
+	parsingDeadline = routeDeadline{}
+	if rule {
+		parsingDeadline = d.global
+	}
+	err := parse()
+	if rule {
+		d.rules = append(d.rules, parsingDeadline)
+	} else if err == nil {
+		d.global = parsingDeadline
+	}
+	return err
+}
+
+func (d *routeDeadlines) rule(index int) routeDeadline {
+	if d == nil {
+		return routeDeadline{}
+	}
+	if index >= 0 && index < len(d.rules) {
+		return d.rules[index]
+	}
+	return d.global
+}
+
+func isRuleJson(json gjson.Result) bool {
+	for _, key := range []string{matcher.MATCH_ROUTE_KEY, matcher.MATCH_DOMAIN_KEY, matcher.MATCH_SERVICE_KEY, matcher.MATCH_ROUTE_PREFIX_KEY} {
+		if json.Get(key).Exists() {
+			return true
+		}
+	}
+	return false
+}
+
+// activeDeadline is the deadline of the request being processed, the callouts dispatched meanwhile are capped by it.
+var activeDeadline time.Time
+
+// routeTimeout returns the route timeout, or the timeout from the request headers if they're trusted.
+func (d routeDeadline) routeTimeout() time.Duration {
+	if !d.trustHeaders {
+		return d.timeout
+	}
+	for _, header := range []string{UpstreamTimeoutHeader, ExpectedTimeoutHeader} {
+		value, err := proxywasm.GetHttpRequestHeader(header)
+		if err != nil || value == "" {
+			continue
+		}
+		ms, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			proxywasm.LogDebugf("invalid %s header: %s", header, value)
+			continue
+		}
+		// 0 disables the timeout of the route
+		return time.Duration(ms) * time.Millisecond
+	}
+	return d.timeout
+}
+
+// requestStartTime returns the time the request is received by the proxy, or now if it's unknown.
+func requestStartTime() time.Time {
+	value, err := proxywasm.GetProperty([]string{"request", "time"})
+	if err != nil || len(value) != 8 {
+		return time.Now()
+	}
+	return time.Unix(0, int64(binary.LittleEndian.Uint64(value)))
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) initDeadline(ruleIndex int) {
+	if timeout := ctx.plugin.deadlines.rule(ruleIndex).routeTimeout(); timeout > 0 {
+		ctx.deadline = requestStartTime().Add(timeout)
+	}
+}
+
+// GetDeadline returns the deadline of the request derived from the route timeout of the matched rule, or false if the route has no
+// timeout. The callouts dispatched in the request phases and their callbacks are capped by it automatically.
+func GetDeadline(ctx HttpContext) (time.Time, bool) {
+	if c, ok := ctx.(httpContextExtension); ok {
+		return c.deadlineOf()
+	}
+	return time.Time{}, false
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) deadlineOf() (time.Time, bool) {
+	return ctx.deadline, !ctx.deadline.IsZero()
+}
+
+// enterDeadline makes the deadline of the request active while the request phase runs, the returned func restores
+// the previous one.
+func (ctx *CommonHttpCtx[PluginConfig]) enterDeadline() func() {
+	return withDeadline(ctx.deadline)
+}
+
+func withDeadline(deadline time.Time) func() {
+	previous := activeDeadline
+	activeDeadline = deadline
+	return func() { activeDeadline = previous }
+}
+
+func deadlineExceeded() bool {
+	return !activeDeadline.IsZero() && !time.Now().Before(activeDeadline)
+}
+
+// capTimeout caps the timeout of a callout in milliseconds by the remaining budget of the active deadline, it
+// returns ErrDeadlineExceeded if the budget is exhausted.
+func capTimeout(timeout uint32) (uint32, error) {
+	if activeDeadline.IsZero() {
+		return timeout, nil
+	}
+	remaining := time.Until(activeDeadline).Milliseconds()
+	if remaining <= 0 {
+		return 0, ErrDeadlineExceeded
+	}
+	if int64(timeout) > remaining {
+		return uint32(remaining), nil
+	}
+	return timeout, nil
+}
+
+// setGrpcTimeout sets the grpc-timeout header of a gRPC callout to its timeout in milliseconds, so the server gives up
+// at the deadline as well.
+func setGrpcTimeout(headers [][2]string, timeout uint32) [][2]string {
+	grpc := false
+	capped := make([][2]string, 0, len(headers)+1)
+	for _, h := range headers {
+		if strings.EqualFold(h[0], "content-type") && strings.HasPrefix(h[1], "application/grpc") {
+			grpc = true
+		}
+		if !strings.EqualFold(h[0], "grpc-timeout") {
+			capped = append(capped, h)
+		}
+	}
+	if !grpc {
+		return headers
+	}
+	return append(capped, [2]string{"grpc-timeout", strconv.FormatUint(uint64(timeout), 10) + "m"})
+}
+
+// RemainingBudget returns the time left before the deadline of the request being processed, ok is false if it has
+// no deadline, e.g. in the response phases or the tick functions.
+func RemainingBudget() (time.Duration, bool) {
+	if activeDeadline.IsZero() {
+		return 0, false
+	}
+	remaining := time.Until(activeDeadline)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
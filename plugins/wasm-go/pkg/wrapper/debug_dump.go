@@ -0,0 +1,282 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/matcher"
+)
+
+const (
+	DefaultDebugHeader     = "x-higress-debug"
+	DefaultDebugDumpHeader = "x-higress-debug-dump"
+)
+
+// The outputs of the debug dump
+const (
+	// The dump is logged at info level when the stream is done
+	DebugDumpLog = "log"
+	// The dump is added to the response headers, it doesn't include the phases after the response headers
+	DebugDumpHeader = "header"
+)
+
+// debugDumpConfig is read from the _debug_ key of the plugin config, which is handled by the wrapper for every plugin:
+//
+//	{
+//	  "_debug_": {"secret": "s3cret", "header": "x-higress-debug", "output": "header"},
+//	  "_rules_": [...]
+//	}
+//
+// A request carrying the header with the secret gets the dump of the plugin for this request.
+type debugDumpConfig struct {
+	header     string
+	secret     string
+	output     string
+	dumpHeader string
+}
+
+func parseDebugDumpConfig(json gjson.Result) (*debugDumpConfig, error) {
+	config := &debugDumpConfig{
+		header:     strings.ToLower(json.Get("header").String()),
+		secret:     json.Get("secret").String(),
+		output:     json.Get("output").String(),
+		dumpHeader: strings.ToLower(json.Get("dumpHeader").String()),
+	}
+	if config.secret == "" {
+		return nil, errors.New("debug secret is empty")
+	}
+	if config.header == "" {
+		config.header = DefaultDebugHeader
+	}
+	if config.dumpHeader == "" {
+		config.dumpHeader = DefaultDebugDumpHeader
+	}
+	switch config.output {
+	case "":
+		config.output = DebugDumpLog
+	case DebugDumpLog, DebugDumpHeader:
+	default:
+		return nil, fmt.Errorf("unknown debug output: %s", config.output)
+	}
+	return config, nil
+}
+
+// enabled reports whether the request carries the debug header with the secret. The header is removed, so the
+// secret never reaches the upstream, the dump is only made by the first plugin in the chain with the same header.
+func (c *debugDumpConfig) enabled() bool {
+	value, err := proxywasm.GetHttpRequestHeader(c.header)
+	if err != nil || value == "" {
+		return false
+	}
+	_ = proxywasm.RemoveHttpRequestHeader(c.header)
+	return subtle.ConstantTimeCompare([]byte(value), []byte(c.secret)) == 1
+}
+
+// DebugRule describes the rule matched by the request in the debug dump.
+type DebugRule struct {
+	// The index in _rules_, -1 for the global config
+	Index int `json:"index"`
+	// One of route, domain, service, route-prefix or global
+	Category string   `json:"category"`
+	Match    []string `json:"match,omitempty"`
+	// The hash of the config of the rule, to tell whether the instances run the same config
+	Fingerprint string `json:"fingerprint"`
+}
+
+// DebugPhase is a phase of the plugin run for the request, the streaming body phases are merged.
+type DebugPhase struct {
+	Phase          string `json:"phase"`
+	Action         string `json:"action,omitempty"`
+	Calls          int    `json:"calls"`
+	DurationMicros int64  `json:"durationMicros"`
+}
+
+// DebugDump tells why the plugin did, or didn't, process the request.
+type DebugDump struct {
+	Plugin string `json:"plugin"`
+	// Nil if no rule is matched, so the plugin doesn't process the request
+	Rule          *DebugRule   `json:"rule"`
+	Phases        []DebugPhase `json:"phases"`
+	Decisions     []string     `json:"decisions,omitempty"`
+	ElapsedMillis int64        `json:"elapsedMillis"`
+	start         time.Time
+}
+
+func (d *DebugDump) addPhase(phase string, action string, start time.Time) {
+	duration := time.Since(start).Microseconds()
+	for i := range d.Phases {
+		if d.Phases[i].Phase == phase {
+			d.Phases[i].Calls++
+			d.Phases[i].DurationMicros += duration
+			d.Phases[i].Action = action
+			return
+		}
+	}
+	d.Phases = append(d.Phases, DebugPhase{Phase: phase, Action: action, Calls: 1, DurationMicros: duration})
+}
+
+func (d *DebugDump) marshal() string {
+	d.ElapsedMillis = time.Since(d.start).Milliseconds()
+	data, _ := json.Marshal(d)
+	return string(data)
+}
+
+func debugActionName(action types.Action) string {
+	switch action {
+	case types.ActionContinue:
+		return "continue"
+	case types.ActionPause:
+		return "pause"
+	}
+	return fmt.Sprint(action)
+}
+
+// debugRules describes the global config and the rules of the plugin config, the fingerprints are computed once
+// in OnPluginStart.
+type debugRules struct {
+	global DebugRule
+	rules  []DebugRule
+}
+
+func newDebugRules(config gjson.Result) *debugRules {
+	global := map[string]json.RawMessage{}
+	config.ForEach(func(key, value gjson.Result) bool {
+		if key.String() != matcher.RULES_KEY && key.String() != matcher.DEBUG_KEY {
+			global[key.String()] = json.RawMessage(value.Raw)
+		}
+		return true
+	})
+	result := &debugRules{
+		global: DebugRule{Index: matcher.GlobalRuleIndex, Category: "global", Fingerprint: configFingerprint(global)},
+	}
+	for i, rule := range config.Get(matcher.RULES_KEY).Array() {
+		debugRule := DebugRule{Index: i}
+		fields := map[string]json.RawMessage{}
+		rule.ForEach(func(key, value gjson.Result) bool {
+			var category string
+			switch key.String() {
+			case matcher.MATCH_ROUTE_KEY:
+				category = "route"
+			case matcher.MATCH_DOMAIN_KEY:
+				category = "domain"
+			case matcher.MATCH_SERVICE_KEY:
+				category = "service"
+			case matcher.MATCH_ROUTE_PREFIX_KEY:
+				category = "route-prefix"
+			default:
+				fields[key.String()] = json.RawMessage(value.Raw)
+				return true
+			}
+			debugRule.Category = category
+			for _, item := range value.Array() {
+				debugRule.Match = append(debugRule.Match, item.String())
+			}
+			return true
+		})
+		debugRule.Fingerprint = configFingerprint(fields)
+		result.rules = append(result.rules, debugRule)
+	}
+	return result
+}
+
+func (r *debugRules) rule(index int) *DebugRule {
+	if index >= 0 && index < len(r.rules) {
+		rule := r.rules[index]
+		return &rule
+	}
+	global := r.global
+	return &global
+}
+
+// configFingerprint hashes the config fields in the order of the keys.
+func configFingerprint(fields map[string]json.RawMessage) string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, key := range keys {
+		var value interface{}
+		// the raw json is normalized, so the formatting doesn't change the fingerprint
+		if err := json.Unmarshal(fields[key], &value); err == nil {
+			normalized, _ := json.Marshal(value)
+			fields[key] = normalized
+		}
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write(fields[key])
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// debugStart returns the start time of a phase, it's zero if the request isn't debugged.
+func (ctx *CommonHttpCtx[PluginConfig]) debugStart() time.Time {
+	if ctx.debug == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) debugAction(phase string, start time.Time, action types.Action) types.Action {
+	if ctx.debug != nil {
+		ctx.debug.addPhase(phase, debugActionName(action), start)
+	}
+	return action
+}
+
+// DebugDecision records a decision of the plugin in the debug dump, e.g. "blocked by ip 10.0.0.1", it does nothing
+// unless the request carries the debug header configured in _debug_.
+func DebugDecision(ctx HttpContext, format string, args ...interface{}) {
+	if c, ok := ctx.(httpContextExtension); ok {
+		c.debugDecision(format, args...)
+	}
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) debugDecision(format string, args ...interface{}) {
+	if ctx.debug != nil {
+		ctx.debug.Decisions = append(ctx.debug.Decisions, fmt.Sprintf(format, args...))
+	}
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) addDebugDumpHeader() {
+	if ctx.debug == nil || ctx.plugin.debug.output != DebugDumpHeader {
+		return
+	}
+	if err := proxywasm.AddHttpResponseHeader(ctx.plugin.debug.dumpHeader, ctx.debug.marshal()); err != nil {
+		ctx.plugin.vm.log.Warnf("failed to add debug dump header: %v", err)
+	}
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) logDebugDump() {
+	if ctx.debug == nil || ctx.plugin.debug.output != DebugDumpLog {
+		return
+	}
+	ctx.plugin.vm.log.Infof("debug dump: %s", ctx.debug.marshal())
+}
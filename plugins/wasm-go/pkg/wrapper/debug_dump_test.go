@@ -0,0 +1,57 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestParseDebugDumpConfig(t *testing.T) {
+	config, err := parseDebugDumpConfig(gjson.Parse(`{"secret": "s3cret", "header": "X-Debug"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "x-debug", config.header)
+	assert.Equal(t, DebugDumpLog, config.output)
+	assert.Equal(t, DefaultDebugDumpHeader, config.dumpHeader)
+	_, err = parseDebugDumpConfig(gjson.Parse(`{"header": "x-debug"}`))
+	assert.Error(t, err)
+	_, err = parseDebugDumpConfig(gjson.Parse(`{"secret": "s3cret", "output": "body"}`))
+	assert.Error(t, err)
+}
+
+func TestDebugRules(t *testing.T) {
+	rules := newDebugRules(gjson.Parse(`{
+		"_debug_": {"secret": "s3cret"},
+		"limit": 10,
+		"_rules_": [
+			{"_match_domain_": ["*.example.com"], "limit": 1},
+			{"_match_service_": ["a.dns"], "limit":1}
+		]
+	}`))
+	require.Len(t, rules.rules, 2)
+	assert.Equal(t, "domain", rules.rule(0).Category)
+	assert.Equal(t, []string{"*.example.com"}, rules.rule(0).Match)
+	assert.Equal(t, "service", rules.rule(1).Category)
+	// the formatting and the match keys don't change the fingerprint
+	assert.Equal(t, rules.rule(0).Fingerprint, rules.rule(1).Fingerprint)
+	assert.Equal(t, "global", rules.rule(-1).Category)
+	assert.NotEqual(t, rules.rule(0).Fingerprint, rules.rule(-1).Fingerprint)
+
+	global := newDebugRules(gjson.Parse(`{"limit": 10}`))
+	assert.Equal(t, rules.rule(-1).Fingerprint, global.rule(-1).Fingerprint)
+}
@@ -0,0 +1,321 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/gjson"
+)
+
+// The actions of the error policy
+const (
+	// Send the local reply of the error
+	ErrorActionReply = "reply"
+	// Log the error and let the request go on, e.g. to fail open when a dependency is down
+	ErrorActionContinue = "continue"
+)
+
+// The formats of the local reply of the errors
+const (
+	// The message as text/plain
+	ErrorFormatText = "text"
+	// {"code": "...", "message": "...", "request_id": "..."} as application/json
+	ErrorFormatJSON = "json"
+)
+
+// TemplateSourceError has the code, message and status of the error for the template of the error policy.
+const TemplateSourceError = "error"
+
+const (
+	// The code of the errors which are not an *Error
+	ErrorCodeInternal = "internal_error"
+	// The code of the failed step of a callout chain without OnError
+	ErrorCodeCalloutChainFailed = "callout_chain_failed"
+)
+
+// Error is a failure of the plugin handled by HttpContext.Fail, which turns it into the local reply, the log and the
+// metric by the _errors_ policy of the config.
+type Error struct {
+	// The status of the local reply, 500 if it's 0
+	Status int
+	// The snake_case code of the error, used in the reply, the metric and the policy of the codes
+	Code string
+	// The message shown to the user, the status text is used if it's empty
+	Message string
+	// The detail only written to the log
+	Detail string
+	// The cause only written to the log
+	Err error
+}
+
+func NewError(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// WithDetail sets the detail written to the log.
+func (e *Error) WithDetail(format string, args ...interface{}) *Error {
+	e.Detail = fmt.Sprintf(format, args...)
+	return e
+}
+
+// WithCause sets the cause written to the log.
+func (e *Error) WithCause(err error) *Error {
+	e.Err = err
+	return e
+}
+
+func (e *Error) Error() string {
+	msg := e.Code
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// AsError returns the *Error in the chain of err, the other errors are internal errors with status 500.
+func AsError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return &Error{Status: http.StatusInternalServerError, Code: ErrorCodeInternal, Err: err}
+}
+
+type errorRule struct {
+	action string
+	status int
+}
+
+// errorPolicy is read from the _errors_ key of the plugin config, which is handled by the wrapper for every plugin:
+//
+//	{
+//	  "_errors_": {
+//	    "action": "reply",
+//	    "format": "json",
+//	    "metrics": true,
+//	    "codes": {"auth_server_unavailable": {"action": "continue"}, "quota_exceeded": {"status": 429}}
+//	  }
+//	}
+//
+// A template can be set instead of the format, which is a ResponseTemplate rendered with the error source, e.g.
+// {{error.message}}, and its status code is replaced by the status of the error. The messages are localized by the
+// MessageCatalog of i18n if it has the code of the error, in the locale negotiated by the Accept-Language header.
+type errorPolicy struct {
+	errorRule
+	format   string
+	template *ResponseTemplate
+	messages *MessageCatalog
+	metrics  bool
+	codes    map[string]errorRule
+}
+
+var defaultErrorPolicy = &errorPolicy{errorRule: errorRule{action: ErrorActionReply}, format: ErrorFormatText, metrics: true}
+
+func parseErrorRule(json gjson.Result, inherited errorRule) (errorRule, error) {
+	rule := inherited
+	if action := json.Get("action"); action.Exists() {
+		switch action.String() {
+		case ErrorActionReply, ErrorActionContinue:
+			rule.action = action.String()
+		default:
+			return rule, fmt.Errorf("unknown error action: %s", action.Raw)
+		}
+	}
+	if status := json.Get("status"); status.Exists() {
+		if status.Int() < 200 || status.Int() > 599 {
+			return rule, fmt.Errorf("invalid error status: %s", status.Raw)
+		}
+		rule.status = int(status.Int())
+	}
+	return rule, nil
+}
+
+func parseErrorPolicy(json gjson.Result) (*errorPolicy, error) {
+	if !json.Exists() {
+		return defaultErrorPolicy, nil
+	}
+	rule, err := parseErrorRule(json, defaultErrorPolicy.errorRule)
+	if err != nil {
+		return nil, err
+	}
+	policy := &errorPolicy{
+		errorRule: rule,
+		format:    json.Get("format").String(),
+		metrics:   !json.Get("metrics").Exists() || json.Get("metrics").Bool(),
+		codes:     map[string]errorRule{},
+	}
+	switch policy.format {
+	case "":
+		policy.format = ErrorFormatText
+	case ErrorFormatText, ErrorFormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown error format: %s", policy.format)
+	}
+	if template := json.Get("template"); template.Exists() {
+		if policy.template, err = ParseResponseTemplate(template); err != nil {
+			return nil, fmt.Errorf("invalid error template: %v", err)
+		}
+	}
+	if i18n := json.Get("i18n"); i18n.Exists() {
+		if policy.messages, err = ParseMessageCatalog(i18n); err != nil {
+			return nil, fmt.Errorf("invalid error i18n: %v", err)
+		}
+	}
+	for code, item := range json.Get("codes").Map() {
+		// the status of the code is kept unless it's set, so it's not inherited
+		if policy.codes[code], err = parseErrorRule(item, errorRule{action: policy.action}); err != nil {
+			return nil, fmt.Errorf("code %s: %v", code, err)
+		}
+	}
+	return policy, nil
+}
+
+func (p *errorPolicy) rule(code string) errorRule {
+	if rule, ok := p.codes[code]; ok {
+		return rule
+	}
+	return p.errorRule
+}
+
+// Fail handles the error failing the request by the _errors_ policy of the config: it's logged, counted and turned into
+// the local reply, use NewError for the status, code and message of the reply, the other errors are internal errors.
+// Return the action from the phase, in the callbacks of the callouts resume the stream if it's types.ActionContinue.
+// For the other implementations of HttpContext, the reply of the error is sent as plain text.
+func Fail(ctx HttpContext, err error) types.Action {
+	if c, ok := ctx.(httpContextExtension); ok {
+		return c.fail(err)
+	}
+	e := AsError(err)
+	status := e.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	message := e.Message
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	if err := proxywasm.SendHttpResponseWithDetail(uint32(status), e.Code, nil, []byte(message), -1); err != nil {
+		return types.ActionContinue
+	}
+	return types.ActionPause
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) fail(err error) types.Action {
+	e := AsError(err)
+	policy := ctx.plugin.errors
+	if policy == nil {
+		policy = defaultErrorPolicy
+	}
+	rule := policy.rule(e.Code)
+	status := e.Status
+	if rule.status != 0 {
+		status = rule.status
+	}
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	if status >= http.StatusInternalServerError {
+		ctx.plugin.vm.log.Errorf("request failed with %d %s", status, e.Error())
+	} else {
+		ctx.plugin.vm.log.Warnf("request failed with %d %s", status, e.Error())
+	}
+	if policy.metrics {
+		ctx.plugin.incrementErrorMetric(e.Code)
+	}
+	DebugDecision(ctx, "failed with %d %s, action: %s", status, e.Code, rule.action)
+	if rule.action == ErrorActionContinue {
+		return types.ActionContinue
+	}
+	message := e.Message
+	var headers [][2]string
+	var locale string
+	if policy.messages != nil {
+		locale = policy.messages.RequestLocale()
+		if localized, ok := policy.messages.Message(locale, e.Code); ok {
+			message = localized
+			headers = append(headers, [2]string{"content-language", locale})
+		}
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	details := ctx.plugin.vm.pluginName + "." + e.Code
+	if policy.template != nil {
+		template := *policy.template
+		template.StatusCode = status
+		sources := TemplateSources{
+			TemplateSourceError: MapTemplateSource(map[string]string{
+				"code":    e.Code,
+				"message": message,
+				"status":  strconv.Itoa(status),
+				"locale":  locale,
+			}),
+		}
+		if policy.messages != nil {
+			sources[TemplateSourceMessage] = policy.messages.TemplateSource(locale)
+		}
+		return template.Send(ctx, details, sources)
+	}
+	var body []byte
+	switch policy.format {
+	case ErrorFormatJSON:
+		requestID, _ := proxywasm.GetHttpRequestHeader("x-request-id")
+		body, _ = json.Marshal(struct {
+			Code      string `json:"code"`
+			Message   string `json:"message"`
+			RequestID string `json:"request_id,omitempty"`
+		}{e.Code, message, requestID})
+		headers = append(headers, [2]string{"content-type", "application/json"})
+	default:
+		body = []byte(message)
+		headers = append(headers, [2]string{"content-type", "text/plain; charset=utf-8"})
+	}
+	if err := proxywasm.SendHttpResponseWithDetail(uint32(status), details, headers, body, -1); err != nil {
+		ctx.plugin.vm.log.Errorf("send error reply failed: %v", err)
+		return types.ActionContinue
+	}
+	return types.ActionPause
+}
+
+// incrementErrorMetric counts the errors by the plugin and the code, the counters are defined on the first error.
+func (ctx *CommonPluginCtx[PluginConfig]) incrementErrorMetric(code string) {
+	name := fmt.Sprintf("plugin.%s.error.%s", ctx.vm.pluginName, code)
+	counter, ok := ctx.errorCounters[name]
+	if !ok {
+		counter = proxywasm.DefineCounterMetric(name)
+		if ctx.errorCounters == nil {
+			ctx.errorCounters = map[string]proxywasm.MetricCounter{}
+		}
+		ctx.errorCounters[name] = counter
+	}
+	counter.Increment(1)
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+)
+
+// The name of the target which served the call is recorded in this response header
+// when the FailoverClient is used through the HttpClient interface.
+const FailoverTargetHeader = "x-higress-failover-target"
+
+// EndpointHealth reports whether an endpoint, identified by name, should receive traffic.
+type EndpointHealth interface {
+	IsHealthy(endpoint string) bool
+}
+
+type FailoverTarget struct {
+	// Name identifies the target in health state, use the cluster name if empty
+	Name    string
+	Cluster Cluster
+}
+
+func (t FailoverTarget) key() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.Cluster.ClusterName()
+}
+
+type FailoverConfig struct {
+	// The total time budget of all attempts in milliseconds, default is 3000ms
+	TotalTimeout uint32
+	// The timeout of a single attempt in milliseconds, default is 500ms
+	TryTimeout uint32
+	// Decide whether to try the next target, retry on 5xx (including timeout) by default
+	RetryOn func(statusCode int) bool
+	// Optional health state, unhealthy targets are only tried when no healthy target is left
+	Health EndpointHealth
+	// Optional observer which receives the outcome of every attempt, e.g. an OutlierDetector
+	Observer EndpointObserver
+}
+
+type FailoverCallback func(target FailoverTarget, statusCode int, responseHeaders http.Header, responseBody []byte)
+
+type FailoverClient struct {
+	targets []FailoverTarget
+	config  FailoverConfig
+}
+
+func NewFailoverClient(targets []FailoverTarget, config FailoverConfig) *FailoverClient {
+	if config.TotalTimeout == 0 {
+		config.TotalTimeout = 3000
+	}
+	if config.TryTimeout == 0 {
+		config.TryTimeout = 500
+	}
+	if config.RetryOn == nil {
+		config.RetryOn = defaultFailoverRetryOn
+	}
+	return &FailoverClient{targets: targets, config: config}
+}
+
+func defaultFailoverRetryOn(statusCode int) bool {
+	return statusCode >= 500
+}
+
+func (c *FailoverClient) Get(rawURL string, headers [][2]string, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.Call(http.MethodGet, rawURL, headers, nil, cb, timeoutMillisecond...)
+}
+func (c *FailoverClient) Head(rawURL string, headers [][2]string, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.Call(http.MethodHead, rawURL, headers, nil, cb, timeoutMillisecond...)
+}
+func (c *FailoverClient) Options(rawURL string, headers [][2]string, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.Call(http.MethodOptions, rawURL, headers, nil, cb, timeoutMillisecond...)
+}
+func (c *FailoverClient) Post(rawURL string, headers [][2]string, body []byte, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.Call(http.MethodPost, rawURL, headers, body, cb, timeoutMillisecond...)
+}
+func (c *FailoverClient) Put(rawURL string, headers [][2]string, body []byte, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.Call(http.MethodPut, rawURL, headers, body, cb, timeoutMillisecond...)
+}
+func (c *FailoverClient) Patch(rawURL string, headers [][2]string, body []byte, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.Call(http.MethodPatch, rawURL, headers, body, cb, timeoutMillisecond...)
+}
+func (c *FailoverClient) Delete(rawURL string, headers [][2]string, body []byte, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.Call(http.MethodDelete, rawURL, headers, body, cb, timeoutMillisecond...)
+}
+func (c *FailoverClient) Connect(rawURL string, headers [][2]string, body []byte, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.Call(http.MethodConnect, rawURL, headers, body, cb, timeoutMillisecond...)
+}
+func (c *FailoverClient) Trace(rawURL string, headers [][2]string, body []byte, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.Call(http.MethodTrace, rawURL, headers, body, cb, timeoutMillisecond...)
+}
+
+// Call implements HttpClient, the served target is recorded in the FailoverTargetHeader response header.
+// The optional timeout overrides the per-try timeout.
+func (c *FailoverClient) Call(method, rawURL string, headers [][2]string, body []byte, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.CallWithTarget(method, rawURL, headers, body, func(target FailoverTarget, statusCode int, responseHeaders http.Header, responseBody []byte) {
+		responseHeaders.Set(FailoverTargetHeader, target.key())
+		cb(statusCode, responseHeaders, responseBody)
+	}, timeoutMillisecond...)
+}
+
+// CallWithTarget tries the targets in order until one of them returns a response which should not be retried,
+// or the total time budget is exhausted. The callback receives the target which served the last attempt.
+// The rawURL should not contain a host, so that the authority of each target cluster is used.
+func (c *FailoverClient) CallWithTarget(method, rawURL string, headers [][2]string, body []byte, cb FailoverCallback, timeoutMillisecond ...uint32) error {
+	targets := c.orderedTargets()
+	if len(targets) == 0 {
+		return errors.New("no failover target is configured")
+	}
+	tryTimeout := c.config.TryTimeout
+	if len(timeoutMillisecond) > 0 {
+		tryTimeout = timeoutMillisecond[0]
+	}
+	attempt := &failoverAttempt{
+		client:     c,
+		targets:    targets,
+		method:     method,
+		rawURL:     rawURL,
+		headers:    headers,
+		body:       body,
+		callback:   cb,
+		tryTimeout: tryTimeout,
+		deadline:   time.Now().Add(time.Duration(c.config.TotalTimeout) * time.Millisecond),
+	}
+	return attempt.try(0)
+}
+
+// orderedTargets puts the healthy targets first, the unhealthy ones are kept as the last resort.
+func (c *FailoverClient) orderedTargets() []FailoverTarget {
+	if c.config.Health == nil {
+		return c.targets
+	}
+	ordered := make([]FailoverTarget, 0, len(c.targets))
+	var unhealthy []FailoverTarget
+	for _, target := range c.targets {
+		if c.config.Health.IsHealthy(target.key()) {
+			ordered = append(ordered, target)
+		} else {
+			unhealthy = append(unhealthy, target)
+		}
+	}
+	return append(ordered, unhealthy...)
+}
+
+type failoverAttempt struct {
+	client     *FailoverClient
+	targets    []FailoverTarget
+	method     string
+	rawURL     string
+	headers    [][2]string
+	body       []byte
+	callback   FailoverCallback
+	tryTimeout uint32
+	deadline   time.Time
+}
+
+func (a *failoverAttempt) remaining() uint32 {
+	left := time.Until(a.deadline).Milliseconds()
+	if left <= 0 {
+		return 0
+	}
+	return uint32(left)
+}
+
+// try dispatches the call to the target at index i, falling through to the following targets
+// if the dispatch fails. An error is returned only when no call could be dispatched at all.
+func (a *failoverAttempt) try(i int) error {
+	var lastErr error
+	for ; i < len(a.targets); i++ {
+		remaining := a.remaining()
+		if remaining == 0 {
+			if lastErr == nil {
+				lastErr = errors.New("failover deadline exceeded")
+			}
+			return lastErr
+		}
+		timeout := a.tryTimeout
+		if timeout > remaining {
+			timeout = remaining
+		}
+		target := a.targets[i]
+		next := i + 1
+		// HttpCall modifies the headers in place, so each attempt uses its own copy
+		headers := make([][2]string, len(a.headers))
+		copy(headers, a.headers)
+		start := time.Now()
+		err := HttpCall(target.Cluster, a.method, a.rawURL, headers, a.body,
+			func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+				if a.client.config.Observer != nil {
+					a.client.config.Observer.ReportResponse(target.key(), statusCode, time.Since(start))
+				}
+				if a.client.config.RetryOn(statusCode) && next < len(a.targets) {
+					proxywasm.LogDebugf("failover target %s responded %d, try next target", target.key(), statusCode)
+					if a.try(next) == nil {
+						return
+					}
+				}
+				a.callback(target, statusCode, responseHeaders, responseBody)
+			}, timeout)
+		if err == nil {
+			return nil
+		}
+		proxywasm.LogWarnf("failed to dispatch call to failover target %s: %v", target.key(), err)
+		if a.client.config.Observer != nil {
+			a.client.config.Observer.ReportResponse(target.key(), 0, 0)
+		}
+		lastErr = err
+	}
+	return lastErr
+}
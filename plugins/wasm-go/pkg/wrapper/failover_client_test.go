@@ -0,0 +1,74 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type staticHealth map[string]bool
+
+func (h staticHealth) IsHealthy(endpoint string) bool {
+	healthy, ok := h[endpoint]
+	return !ok || healthy
+}
+
+func TestFailoverOrderedTargets(t *testing.T) {
+	targets := []FailoverTarget{
+		{Name: "a", Cluster: TargetCluster{Cluster: "outbound|80||a.dns"}},
+		{Name: "b", Cluster: TargetCluster{Cluster: "outbound|80||b.dns"}},
+		{Cluster: TargetCluster{Cluster: "outbound|80||c.dns"}},
+	}
+	cases := []struct {
+		name   string
+		health EndpointHealth
+		expect []string
+	}{
+		{
+			name:   "no health state",
+			expect: []string{"a", "b", "outbound|80||c.dns"},
+		},
+		{
+			name:   "first unhealthy",
+			health: staticHealth{"a": false},
+			expect: []string{"b", "outbound|80||c.dns", "a"},
+		},
+		{
+			name:   "all unhealthy",
+			health: staticHealth{"a": false, "b": false, "outbound|80||c.dns": false},
+			expect: []string{"a", "b", "outbound|80||c.dns"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := NewFailoverClient(targets, FailoverConfig{Health: c.health})
+			var names []string
+			for _, target := range client.orderedTargets() {
+				names = append(names, target.key())
+			}
+			assert.Equal(t, c.expect, names)
+		})
+	}
+}
+
+func TestFailoverDefaultConfig(t *testing.T) {
+	client := NewFailoverClient(nil, FailoverConfig{})
+	assert.Equal(t, uint32(3000), client.config.TotalTimeout)
+	assert.Equal(t, uint32(500), client.config.TryTimeout)
+	assert.True(t, client.config.RetryOn(502))
+	assert.False(t, client.config.RetryOn(404))
+}
@@ -0,0 +1,470 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// {"flags": [{"name": "new-auth", "enabled": true, "rules": [{"consumers": ["c1"], "routes": ["r1"], "rollout": 50}]}]},
+	// or {"flags": {"new-auth": true}} for the flags without rules
+	FeatureFlagsFormatGeneric = "generic"
+	// The response of the client features api of Unleash, /api/client/features
+	FeatureFlagsFormatUnleash = "unleash"
+
+	featureFlagsKeyPrefix      = "feature_flags:"
+	featureFlagsLeaseKeyPrefix = "feature_flags_lease:"
+)
+
+// FlagTarget is the subject a flag is evaluated for.
+type FlagTarget struct {
+	Consumer string
+	Route    string
+}
+
+// FeatureFlags evaluates the flags toggling the behaviors of a plugin in real time, e.g. to roll out a new behavior
+// to some consumers or routes first.
+type FeatureFlags interface {
+	// Enabled returns whether the flag is on for the target, the unknown flags are off
+	Enabled(name string, target FlagTarget) bool
+}
+
+// FeatureFlag is a flag in the normalized form of the formats.
+type FeatureFlag struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	// The enabled flag is on for the targets matching any of the rules, or for all the targets if there are no rules
+	Rules []FlagRule `json:"rules,omitempty"`
+}
+
+// FlagRule matches the targets meeting all of its conditions.
+type FlagRule struct {
+	// The consumers and the routes matched, any if empty
+	Consumers []string `json:"consumers,omitempty"`
+	Routes    []string `json:"routes,omitempty"`
+	// The percentage of the consumers matched. The consumers are bucketed by the hash of the group id and the
+	// consumer like the gradual rollouts of Unleash, so a consumer stays in its bucket as the percentage grows.
+	// The requests without a consumer are only matched by 100.
+	Rollout int `json:"rollout"`
+	// The group id of the buckets, default is the name of the flag
+	GroupID string `json:"groupId,omitempty"`
+}
+
+func (r FlagRule) matches(flag string, target FlagTarget) bool {
+	if len(r.Consumers) > 0 && !containsString(r.Consumers, target.Consumer) {
+		return false
+	}
+	if len(r.Routes) > 0 && !containsString(r.Routes, target.Route) {
+		return false
+	}
+	if r.Rollout >= 100 {
+		return true
+	}
+	if r.Rollout <= 0 || target.Consumer == "" {
+		return false
+	}
+	groupID := r.GroupID
+	if groupID == "" {
+		groupID = flag
+	}
+	return FlagRolloutBucket(groupID, target.Consumer) <= r.Rollout
+}
+
+func (f FeatureFlag) EnabledFor(target FlagTarget) bool {
+	if !f.Enabled {
+		return false
+	}
+	if len(f.Rules) == 0 {
+		return true
+	}
+	for _, rule := range f.Rules {
+		if rule.matches(f.Name, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// FlagRolloutBucket returns the bucket of the consumer in 1-100, it's the murmur3 hash of "groupID:consumer"
+// normalized like the Unleash SDKs, so the gateway rolls out a flag to the same consumers as the other services.
+func FlagRolloutBucket(groupID, consumer string) int {
+	return int(murmur3Hash32([]byte(groupID+":"+consumer), 0)%100) + 1
+}
+
+// murmur3Hash32 is the x86 32-bit variant of MurmurHash3.
+func murmur3Hash32(data []byte, seed uint32) uint32 {
+	const c1, c2 = 0xcc9e2d51, 0x1b873593
+	h := seed
+	n := len(data) / 4 * 4
+	for i := 0; i < n; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+	var k uint32
+	switch len(data) & 3 {
+	case 3:
+		k ^= uint32(data[n+2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(data[n+1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(data[n])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// ParseFeatureFlagsResponse normalizes the flags returned by the endpoint of the format.
+func ParseFeatureFlagsResponse(format string, body []byte) ([]FeatureFlag, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, errors.New("feature flags response is not a valid json")
+	}
+	json := gjson.ParseBytes(body)
+	switch format {
+	case "", FeatureFlagsFormatGeneric:
+		return parseGenericFlags(json)
+	case FeatureFlagsFormatUnleash:
+		return parseUnleashFlags(json)
+	}
+	return nil, fmt.Errorf("unknown feature flags format: %s", format)
+}
+
+func parseGenericFlags(json gjson.Result) ([]FeatureFlag, error) {
+	flagsJson := json.Get("flags")
+	var flags []FeatureFlag
+	if flagsJson.IsObject() {
+		flagsJson.ForEach(func(key, value gjson.Result) bool {
+			flags = append(flags, FeatureFlag{Name: key.String(), Enabled: value.Bool()})
+			return true
+		})
+		return flags, nil
+	}
+	if !flagsJson.IsArray() {
+		return nil, errors.New("flags of the feature flags response should be an array or an object")
+	}
+	for _, flagJson := range flagsJson.Array() {
+		flag := FeatureFlag{Name: flagJson.Get("name").String(), Enabled: flagJson.Get("enabled").Bool()}
+		if flag.Name == "" {
+			return nil, errors.New("name of the feature flag is empty")
+		}
+		for _, ruleJson := range flagJson.Get("rules").Array() {
+			rule := FlagRule{Rollout: 100, GroupID: ruleJson.Get("groupId").String()}
+			if rollout := ruleJson.Get("rollout"); rollout.Exists() {
+				rule.Rollout = int(rollout.Int())
+			}
+			for _, consumer := range ruleJson.Get("consumers").Array() {
+				rule.Consumers = append(rule.Consumers, consumer.String())
+			}
+			for _, route := range ruleJson.Get("routes").Array() {
+				rule.Routes = append(rule.Routes, route.String())
+			}
+			flag.Rules = append(flag.Rules, rule)
+		}
+		flags = append(flags, flag)
+	}
+	return flags, nil
+}
+
+// parseUnleashFlags maps the strategies of the features to the rules. The default, userWithId, flexibleRollout and
+// gradualRolloutUserId strategies are supported, with the IN constraints on userId (the consumer) and route. The
+// other strategies and constraints never match, so the flag stays off rather than being on for everyone.
+func parseUnleashFlags(json gjson.Result) ([]FeatureFlag, error) {
+	features := json.Get("features")
+	if !features.IsArray() {
+		return nil, errors.New("features of the unleash response should be an array")
+	}
+	var flags []FeatureFlag
+	for _, feature := range features.Array() {
+		flag := FeatureFlag{Name: feature.Get("name").String(), Enabled: feature.Get("enabled").Bool()}
+		if flag.Name == "" {
+			return nil, errors.New("name of the unleash feature is empty")
+		}
+		for _, strategy := range feature.Get("strategies").Array() {
+			flag.Rules = append(flag.Rules, unleashRule(strategy))
+		}
+		flags = append(flags, flag)
+	}
+	return flags, nil
+}
+
+func unleashRule(strategy gjson.Result) FlagRule {
+	parameters := strategy.Get("parameters")
+	var rule FlagRule
+	switch strategy.Get("name").String() {
+	case "default":
+		rule.Rollout = 100
+	case "userWithId":
+		rule.Rollout = 100
+		for _, id := range strings.Split(parameters.Get("userIds").String(), ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				rule.Consumers = append(rule.Consumers, id)
+			}
+		}
+		if len(rule.Consumers) == 0 {
+			return FlagRule{}
+		}
+	case "flexibleRollout":
+		rule.Rollout = int(parameters.Get("rollout").Int())
+		rule.GroupID = parameters.Get("groupId").String()
+	case "gradualRolloutUserId":
+		rule.Rollout = int(parameters.Get("percentage").Int())
+		rule.GroupID = parameters.Get("groupId").String()
+	default:
+		return FlagRule{}
+	}
+	for _, constraint := range strategy.Get("constraints").Array() {
+		if constraint.Get("operator").String() != "IN" || constraint.Get("inverted").Bool() {
+			return FlagRule{}
+		}
+		var values []string
+		for _, value := range constraint.Get("values").Array() {
+			values = append(values, value.String())
+		}
+		switch constraint.Get("contextName").String() {
+		case "userId":
+			rule.Consumers = intersectStrings(rule.Consumers, values)
+		case "route":
+			rule.Routes = intersectStrings(rule.Routes, values)
+		default:
+			return FlagRule{}
+		}
+		if len(values) == 0 || (rule.Consumers != nil && len(rule.Consumers) == 0) || (rule.Routes != nil && len(rule.Routes) == 0) {
+			return FlagRule{}
+		}
+	}
+	return rule
+}
+
+// intersectStrings narrows the values by the allowed ones, nil values mean any.
+func intersectStrings(values, allowed []string) []string {
+	if values == nil {
+		return allowed
+	}
+	result := []string{}
+	for _, value := range values {
+		if containsString(allowed, value) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+type FeatureFlagsConfig struct {
+	// Name identifies the flags in shared data
+	Name    string
+	Client  HttpClient
+	Path    string
+	Headers [][2]string
+	// FeatureFlagsFormatGeneric or FeatureFlagsFormatUnleash, default is generic
+	Format string
+	// The poll interval in milliseconds, default is 15000ms
+	Interval int64
+	// The timeout of the poll in milliseconds, default is 2000ms
+	Timeout uint32
+	// The values of the flags before the first poll succeeds, and of the flags absent in the response
+	Defaults map[string]bool
+}
+
+type cachedFeatureFlags struct {
+	Flags []FeatureFlag `json:"flags"`
+}
+
+// PollingFeatureFlags polls the flags on tick, only one of the VMs sharing the data does the poll, and every VM
+// parses the shared copy again only after it changes. The last flags are kept if a poll fails.
+type PollingFeatureFlags struct {
+	config FeatureFlagsConfig
+	flags  map[string]FeatureFlag
+	// cas of the shared data which the flags come from
+	cas uint32
+}
+
+// NewPollingFeatureFlags should be called in parseConfig phase since it registers the poll tick function.
+func NewPollingFeatureFlags(config FeatureFlagsConfig) (*PollingFeatureFlags, error) {
+	if config.Name == "" {
+		return nil, errors.New("feature flags name is empty")
+	}
+	if config.Client == nil || config.Path == "" {
+		return nil, errors.New("feature flags client or path is empty")
+	}
+	switch config.Format {
+	case "":
+		config.Format = FeatureFlagsFormatGeneric
+	case FeatureFlagsFormatGeneric, FeatureFlagsFormatUnleash:
+	default:
+		return nil, fmt.Errorf("unknown feature flags format: %s", config.Format)
+	}
+	if config.Interval <= 0 {
+		config.Interval = 15000
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 2000
+	}
+	f := &PollingFeatureFlags{config: config, flags: map[string]FeatureFlag{}}
+	leaseTTL := 3 * time.Duration(config.Interval) * time.Millisecond
+	RegisteTickFunc(config.Interval, func() {
+		if TryAcquireSharedLease(featureFlagsLeaseKeyPrefix+config.Name, leaseTTL) {
+			f.poll()
+		}
+	})
+	return f, nil
+}
+
+// ParseFeatureFlags parses the config like the _flags_ of the plugin config:
+//
+//	{"format": "unleash", "serviceName": "unleash.dns", "servicePort": 4242, "path": "/api/client/features",
+//	 "headers": {"authorization": "client-token"}, "interval": 15000, "defaults": {"new-auth": false}}
+//
+// The name of the flags in shared data is the service and the path unless it's configured, so the plugins polling
+// the same endpoint share the flags.
+func ParseFeatureFlags(json gjson.Result) (*PollingFeatureFlags, error) {
+	serviceName := json.Get("serviceName").String()
+	if serviceName == "" {
+		return nil, errors.New("feature flags serviceName is empty")
+	}
+	servicePort := json.Get("servicePort").Int()
+	if servicePort == 0 {
+		servicePort = 80
+	}
+	config := FeatureFlagsConfig{
+		Name:     json.Get("name").String(),
+		Client:   NewClusterClient(FQDNCluster{FQDN: serviceName, Host: json.Get("serviceHost").String(), Port: servicePort}),
+		Path:     json.Get("path").String(),
+		Format:   json.Get("format").String(),
+		Interval: json.Get("interval").Int(),
+		Timeout:  uint32(json.Get("timeout").Uint()),
+		Defaults: map[string]bool{},
+	}
+	if config.Name == "" {
+		config.Name = fmt.Sprintf("%s:%d%s", serviceName, servicePort, config.Path)
+	}
+	json.Get("headers").ForEach(func(key, value gjson.Result) bool {
+		config.Headers = append(config.Headers, [2]string{key.String(), value.String()})
+		return true
+	})
+	json.Get("defaults").ForEach(func(key, value gjson.Result) bool {
+		config.Defaults[key.String()] = value.Bool()
+		return true
+	})
+	return NewPollingFeatureFlags(config)
+}
+
+func (f *PollingFeatureFlags) poll() {
+	err := f.config.Client.Get(f.config.Path, f.config.Headers, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if statusCode != http.StatusOK {
+			proxywasm.LogErrorf("failed to poll feature flags %s, status: %d, body: %s", f.config.Name, statusCode, responseBody)
+			return
+		}
+		flags, err := ParseFeatureFlagsResponse(f.config.Format, responseBody)
+		if err != nil {
+			proxywasm.LogErrorf("failed to parse feature flags %s: %v", f.config.Name, err)
+			return
+		}
+		data, err := json.Marshal(cachedFeatureFlags{Flags: flags})
+		if err != nil {
+			proxywasm.LogErrorf("failed to marshal feature flags %s: %v", f.config.Name, err)
+			return
+		}
+		// the shared copy is only written on changes, so the other VMs don't parse it again on every poll
+		current, _, _ := proxywasm.GetSharedData(featureFlagsKeyPrefix + f.config.Name)
+		if bytes.Equal(current, data) {
+			return
+		}
+		if err = proxywasm.SetSharedData(featureFlagsKeyPrefix+f.config.Name, data, 0); err != nil {
+			proxywasm.LogErrorf("failed to store feature flags %s: %v", f.config.Name, err)
+		}
+	}, f.config.Timeout)
+	if err != nil {
+		proxywasm.LogErrorf("failed to poll feature flags %s: %v", f.config.Name, err)
+	}
+}
+
+// Flags returns the latest flags keyed by the name, it's empty before the first poll succeeds.
+func (f *PollingFeatureFlags) Flags() map[string]FeatureFlag {
+	data, cas, err := proxywasm.GetSharedData(featureFlagsKeyPrefix + f.config.Name)
+	if err != nil || cas == f.cas {
+		return f.flags
+	}
+	var cached cachedFeatureFlags
+	if err = json.Unmarshal(data, &cached); err != nil {
+		proxywasm.LogErrorf("failed to unmarshal feature flags %s: %v", f.config.Name, err)
+		return f.flags
+	}
+	flags := make(map[string]FeatureFlag, len(cached.Flags))
+	for _, flag := range cached.Flags {
+		flags[flag.Name] = flag
+	}
+	f.flags = flags
+	f.cas = cas
+	return f.flags
+}
+
+func (f *PollingFeatureFlags) Enabled(name string, target FlagTarget) bool {
+	flag, ok := f.Flags()[name]
+	if !ok {
+		return f.config.Defaults[name]
+	}
+	return flag.EnabledFor(target)
+}
+
+// FlagEnabled evaluates the flag of _flags_ for the consumer and the route of the request, the flags are toggled in
+// real time by the flag service without changing the config. It's false if the plugin config has no _flags_.
+func FlagEnabled(ctx HttpContext, name string) bool {
+	if c, ok := ctx.(httpContextExtension); ok {
+		return c.flagEnabled(name)
+	}
+	return false
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) flagEnabled(name string) bool {
+	if ctx.plugin.flags == nil {
+		return false
+	}
+	var target FlagTarget
+	if consumer := GetAuthenticatedConsumer(ctx); consumer != nil {
+		target.Consumer = consumer.Name
+	}
+	proxywasm.SetEffectiveContext(ctx.contextID)
+	target.Route = getPropertyString("route_name")
+	enabled := ctx.plugin.flags.Enabled(name, target)
+	DebugDecision(ctx, "feature flag %s is %t", name, enabled)
+	return enabled
+}
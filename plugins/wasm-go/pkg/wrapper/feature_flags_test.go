@@ -0,0 +1,97 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMurmur3Hash32(t *testing.T) {
+	assert.Equal(t, uint32(0), murmur3Hash32(nil, 0))
+	assert.Equal(t, uint32(0x248bfa47), murmur3Hash32([]byte("hello"), 0))
+	assert.Equal(t, uint32(0x2e4ff723), murmur3Hash32([]byte("The quick brown fox jumps over the lazy dog"), 0))
+}
+
+func TestFlagRollout(t *testing.T) {
+	flag := FeatureFlag{Name: "new-auth", Enabled: true, Rules: []FlagRule{{Rollout: 30}}}
+	on := 0
+	for i := 0; i < 1000; i++ {
+		consumer := "consumer-" + string(rune('a'+i%26)) + string(rune('a'+i/26))
+		enabled := flag.EnabledFor(FlagTarget{Consumer: consumer})
+		assert.Equal(t, FlagRolloutBucket("new-auth", consumer) <= 30, enabled)
+		if enabled {
+			on++
+			// the consumers on stay on as the rollout grows
+			assert.True(t, FeatureFlag{Name: "new-auth", Enabled: true, Rules: []FlagRule{{Rollout: 60}}}.EnabledFor(FlagTarget{Consumer: consumer}))
+		}
+	}
+	assert.InDelta(t, 300, on, 60)
+	assert.False(t, flag.EnabledFor(FlagTarget{}), "the requests without a consumer only match 100")
+}
+
+func TestParseGenericFlags(t *testing.T) {
+	flags, err := ParseFeatureFlagsResponse(FeatureFlagsFormatGeneric, []byte(`{"flags": [
+		{"name": "a", "enabled": true},
+		{"name": "b", "enabled": true, "rules": [{"consumers": ["c1"]}, {"routes": ["r1"], "rollout": 0}]},
+		{"name": "c", "enabled": false}
+	]}`))
+	require.NoError(t, err)
+	require.Len(t, flags, 3)
+	assert.Equal(t, []FlagRule{{Consumers: []string{"c1"}, Rollout: 100}, {Routes: []string{"r1"}}}, flags[1].Rules)
+	assert.True(t, flags[0].EnabledFor(FlagTarget{}))
+	assert.True(t, flags[1].EnabledFor(FlagTarget{Consumer: "c1", Route: "r2"}))
+	assert.False(t, flags[1].EnabledFor(FlagTarget{Consumer: "c2", Route: "r1"}))
+	assert.False(t, flags[2].EnabledFor(FlagTarget{Consumer: "c1"}))
+
+	flags, err = ParseFeatureFlagsResponse("", []byte(`{"flags": {"a": true, "b": false}}`))
+	require.NoError(t, err)
+	assert.Equal(t, []FeatureFlag{{Name: "a", Enabled: true}, {Name: "b"}}, flags)
+
+	_, err = ParseFeatureFlagsResponse(FeatureFlagsFormatGeneric, []byte(`{"flags": "a"}`))
+	assert.Error(t, err)
+	_, err = ParseFeatureFlagsResponse(FeatureFlagsFormatGeneric, []byte(`{"flags": [{"enabled": true}]}`))
+	assert.Error(t, err)
+	_, err = ParseFeatureFlagsResponse("launchdarkly", []byte(`{}`))
+	assert.Error(t, err)
+}
+
+func TestParseUnleashFlags(t *testing.T) {
+	flags, err := ParseFeatureFlagsResponse(FeatureFlagsFormatUnleash, []byte(`{"version": 1, "features": [
+		{"name": "everyone", "enabled": true, "strategies": [{"name": "default"}]},
+		{"name": "no-strategy", "enabled": true, "strategies": []},
+		{"name": "users", "enabled": true, "strategies": [{"name": "userWithId", "parameters": {"userIds": "c1, c2"}}]},
+		{"name": "rollout", "enabled": true, "strategies": [{"name": "flexibleRollout",
+			"parameters": {"rollout": "50", "stickiness": "default", "groupId": "g1"},
+			"constraints": [{"contextName": "route", "operator": "IN", "values": ["r1"]}]}]},
+		{"name": "unsupported", "enabled": true, "strategies": [
+			{"name": "remoteAddress", "parameters": {"IPs": "10.0.0.1"}},
+			{"name": "default", "constraints": [{"contextName": "userId", "operator": "NOT_IN", "values": ["c1"]}]}
+		]},
+		{"name": "disabled", "enabled": false, "strategies": [{"name": "default"}]}
+	]}`))
+	require.NoError(t, err)
+	require.Len(t, flags, 6)
+	assert.True(t, flags[0].EnabledFor(FlagTarget{}))
+	assert.True(t, flags[1].EnabledFor(FlagTarget{}))
+	assert.Equal(t, []FlagRule{{Consumers: []string{"c1", "c2"}, Rollout: 100}}, flags[2].Rules)
+	assert.Equal(t, []FlagRule{{Routes: []string{"r1"}, Rollout: 50, GroupID: "g1"}}, flags[3].Rules)
+	assert.False(t, flags[3].EnabledFor(FlagTarget{Consumer: "c1", Route: "r2"}))
+	assert.Equal(t, FlagRolloutBucket("g1", "c1") <= 50, flags[3].EnabledFor(FlagTarget{Consumer: "c1", Route: "r1"}))
+	assert.False(t, flags[4].EnabledFor(FlagTarget{Consumer: "c2"}))
+	assert.False(t, flags[5].EnabledFor(FlagTarget{}))
+}
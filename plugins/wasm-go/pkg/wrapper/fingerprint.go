@@ -0,0 +1,173 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+)
+
+const fingerprintContextKey = "__fingerprint__"
+
+// The heuristic signals found in the request, anti-bot plugins can weight them as they see fit.
+const (
+	SignalMissingUserAgent      = "missing-user-agent"
+	SignalMissingAccept         = "missing-accept"
+	SignalMissingAcceptLanguage = "missing-accept-language"
+	SignalMissingAcceptEncoding = "missing-accept-encoding"
+	SignalHeadlessBrowser       = "headless-browser"
+	SignalAutomationTool        = "automation-tool"
+	SignalBrowserWithoutTLS     = "browser-without-tls"
+	SignalBrowserOverHttp1      = "browser-over-http1"
+	SignalConnectionHeaderOnH2  = "connection-header-on-h2"
+)
+
+var automationUserAgents = []string{
+	"curl/", "wget/", "python-requests", "python-urllib", "go-http-client", "java/", "okhttp", "apache-httpclient",
+	"libwww-perl", "node-fetch", "axios/", "scrapy", "httpclient", "postmanruntime",
+}
+
+var headlessUserAgents = []string{"headlesschrome", "phantomjs", "selenium", "puppeteer", "playwright"}
+
+type Fingerprint struct {
+	// The JA3 string and its md5 hash, when the gateway or the front proxy computes them
+	JA3     string
+	JA3Hash string
+	JA4     string
+	// The HTTP/2 fingerprint (settings, window update, priority and pseudo header order), from the front proxy
+	HTTP2       string
+	TLSVersion  string
+	SNI         string
+	Protocol    string
+	HeaderOrder []string
+	// The first 16 hex characters of the sha256 of the header order, stable across requests of the same client
+	HeaderOrderHash string
+	Signals         []string
+}
+
+// FingerprintConfig names the headers carrying the fingerprints computed by a trusted front proxy or CDN.
+// The headers are ignored if the names are empty, since the client could forge them otherwise.
+type FingerprintConfig struct {
+	JA3Header     string
+	JA3HashHeader string
+	JA4Header     string
+	HTTP2Header   string
+}
+
+func (f *Fingerprint) HasSignal(signal string) bool {
+	return containsString(f.Signals, signal)
+}
+
+// ComputeFingerprint builds the fingerprint from the request headers in the order they were received, property
+// reads the connection properties of the host.
+func ComputeFingerprint(config FingerprintConfig, headers [][2]string, property func(path ...string) string) Fingerprint {
+	values := map[string]string{}
+	f := Fingerprint{
+		JA3Hash:    property("connection", "ja3_hash"),
+		TLSVersion: property("connection", "tls_version"),
+		SNI:        property("connection", "requested_server_name"),
+		Protocol:   property("request", "protocol"),
+	}
+	for _, h := range headers {
+		name := strings.ToLower(h[0])
+		values[name] = h[1]
+		f.HeaderOrder = append(f.HeaderOrder, name)
+	}
+	header := func(name string) string {
+		if name == "" {
+			return ""
+		}
+		return values[strings.ToLower(name)]
+	}
+	if ja3 := header(config.JA3Header); ja3 != "" {
+		f.JA3 = ja3
+		sum := md5.Sum([]byte(ja3))
+		f.JA3Hash = hex.EncodeToString(sum[:])
+	}
+	if f.JA3Hash == "" {
+		f.JA3Hash = header(config.JA3HashHeader)
+	}
+	f.JA4 = header(config.JA4Header)
+	f.HTTP2 = header(config.HTTP2Header)
+	sum := sha256.Sum256([]byte(strings.Join(f.HeaderOrder, ",")))
+	f.HeaderOrderHash = hex.EncodeToString(sum[:8])
+
+	userAgent := strings.ToLower(values["user-agent"])
+	isBrowser := strings.HasPrefix(userAgent, "mozilla/")
+	signal := func(cond bool, name string) {
+		if cond {
+			f.Signals = append(f.Signals, name)
+		}
+	}
+	signal(userAgent == "", SignalMissingUserAgent)
+	signal(values["accept"] == "", SignalMissingAccept)
+	signal(isBrowser && values["accept-language"] == "", SignalMissingAcceptLanguage)
+	signal(isBrowser && values["accept-encoding"] == "", SignalMissingAcceptEncoding)
+	signal(containsAny(userAgent, headlessUserAgents), SignalHeadlessBrowser)
+	signal(containsAny(userAgent, automationUserAgents), SignalAutomationTool)
+	signal(isBrowser && f.TLSVersion == "" && values[":scheme"] == "http", SignalBrowserWithoutTLS)
+	signal(isBrowser && strings.HasPrefix(f.Protocol, "HTTP/1"), SignalBrowserOverHttp1)
+	signal(f.Protocol == "HTTP/2" && values["connection"] != "", SignalConnectionHeaderOnH2)
+	return f
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func getPropertyString(path ...string) string {
+	value, err := proxywasm.GetProperty(path)
+	if err != nil {
+		return ""
+	}
+	return string(value)
+}
+
+// GetFingerprint computes the fingerprint of the current request, it should be called in the request headers phase.
+func GetFingerprint(config FingerprintConfig) Fingerprint {
+	headers, err := proxywasm.GetHttpRequestHeaders()
+	if err != nil {
+		proxywasm.LogWarnf("failed to get request headers: %v", err)
+	}
+	return ComputeFingerprint(config, headers, getPropertyString)
+}
+
+// GetRequestFingerprint returns the client fingerprint from the connection properties and the request headers, it's
+// computed once for the request. Use GetFingerprint to also read the fingerprints computed by a trusted front proxy.
+func GetRequestFingerprint(ctx HttpContext) Fingerprint {
+	if c, ok := ctx.(httpContextExtension); ok {
+		return c.fingerprint()
+	}
+	return Fingerprint{}
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) fingerprint() Fingerprint {
+	if f, ok := ctx.userContext[fingerprintContextKey].(Fingerprint); ok {
+		return f
+	}
+	proxywasm.SetEffectiveContext(ctx.contextID)
+	f := GetFingerprint(FingerprintConfig{})
+	ctx.userContext[fingerprintContextKey] = f
+	return f
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeFingerprint(t *testing.T) {
+	properties := map[string]string{
+		"connection.tls_version":           "TLSv1.3",
+		"connection.requested_server_name": "example.com",
+		"request.protocol":                 "HTTP/1.1",
+	}
+	property := func(path ...string) string {
+		return properties[strings.Join(path, ".")]
+	}
+	config := FingerprintConfig{JA3Header: "x-ja3"}
+
+	f := ComputeFingerprint(config, [][2]string{
+		{":method", "GET"},
+		{":authority", "example.com"},
+		{"User-Agent", "Mozilla/5.0 (X11; Linux x86_64) HeadlessChrome/120.0"},
+		{"Accept", "*/*"},
+		{"x-ja3", "771,4865-4866,0-23,29-23,0"},
+	}, property)
+	assert.Equal(t, "TLSv1.3", f.TLSVersion)
+	assert.Equal(t, "example.com", f.SNI)
+	assert.Equal(t, []string{":method", ":authority", "user-agent", "accept", "x-ja3"}, f.HeaderOrder)
+	assert.Len(t, f.HeaderOrderHash, 16)
+	assert.Len(t, f.JA3Hash, 32)
+	assert.Equal(t, []string{
+		SignalMissingAcceptLanguage,
+		SignalMissingAcceptEncoding,
+		SignalHeadlessBrowser,
+		SignalBrowserOverHttp1,
+	}, f.Signals)
+
+	f = ComputeFingerprint(FingerprintConfig{}, [][2]string{
+		{"user-agent", "curl/8.0.1"},
+		{"x-ja3", "forged"},
+	}, property)
+	assert.Empty(t, f.JA3)
+	assert.True(t, f.HasSignal(SignalAutomationTool))
+	assert.True(t, f.HasSignal(SignalMissingAccept))
+	assert.False(t, f.HasSignal(SignalMissingUserAgent))
+
+	other := ComputeFingerprint(FingerprintConfig{}, [][2]string{
+		{"x-ja3", "forged"},
+		{"user-agent", "curl/8.0.1"},
+	}, property)
+	assert.NotEqual(t, f.HeaderOrderHash, other.HeaderOrderHash)
+}
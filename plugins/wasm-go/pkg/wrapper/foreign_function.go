@@ -0,0 +1,217 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+var (
+	ErrForeignFunctionNotFound     = errors.New("foreign function is not found")
+	ErrForeignFunctionIncompatible = errors.New("foreign function version is incompatible")
+)
+
+// ForeignFunctionError is the error reported by the foreign function in its response.
+type ForeignFunctionError struct {
+	Function string
+	Message  string
+}
+
+func (e *ForeignFunctionError) Error() string {
+	return fmt.Sprintf("foreign function %s failed: %s", e.Function, e.Message)
+}
+
+// ForeignCodec encodes the requests and decodes the responses of the foreign functions of a version.
+type ForeignCodec interface {
+	Encode(version string, request interface{}) ([]byte, error)
+	Decode(version string, data []byte, response interface{}) error
+}
+
+// JSONForeignCodec wraps the payloads in a versioned envelope, the foreign function replies with the version of
+// its response, and the error if it fails:
+//
+//	request:  {"version": "v1", "payload": {...}}
+//	response: {"version": "v1.2", "payload": {...}, "error": "..."}
+//
+// The major versions of the request and the response must be the same, the minor versions only add fields.
+var JSONForeignCodec ForeignCodec = jsonForeignCodec{}
+
+// RawForeignCodec passes the bytes as is, for the functions built in the proxy which take their own format, the
+// request must be a []byte or a string and the response a *[]byte or a *string.
+var RawForeignCodec ForeignCodec = rawForeignCodec{}
+
+type foreignEnvelope struct {
+	Version string          `json:"version"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+type jsonForeignCodec struct{}
+
+func (jsonForeignCodec) Encode(version string, request interface{}) ([]byte, error) {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(foreignEnvelope{Version: version, Payload: payload})
+}
+
+func (jsonForeignCodec) Decode(version string, data []byte, response interface{}) error {
+	var envelope foreignEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("invalid foreign function response: %v", err)
+	}
+	if envelope.Error != "" {
+		return &ForeignFunctionError{Message: envelope.Error}
+	}
+	if envelope.Version != "" && majorVersion(envelope.Version) != majorVersion(version) {
+		return fmt.Errorf("%w: expected %s, got %s", ErrForeignFunctionIncompatible, version, envelope.Version)
+	}
+	if len(envelope.Payload) == 0 || string(envelope.Payload) == "null" {
+		return nil
+	}
+	return json.Unmarshal(envelope.Payload, response)
+}
+
+type rawForeignCodec struct{}
+
+func (rawForeignCodec) Encode(version string, request interface{}) ([]byte, error) {
+	switch r := request.(type) {
+	case []byte:
+		return r, nil
+	case string:
+		return []byte(r), nil
+	}
+	return nil, fmt.Errorf("raw foreign function request must be []byte or string, got %T", request)
+}
+
+func (rawForeignCodec) Decode(version string, data []byte, response interface{}) error {
+	switch r := response.(type) {
+	case *[]byte:
+		*r = data
+		return nil
+	case *string:
+		*r = string(data)
+		return nil
+	}
+	return fmt.Errorf("raw foreign function response must be *[]byte or *string, got %T", response)
+}
+
+// majorVersion returns the major version of v1, v1.2 or 1.2.
+func majorVersion(version string) string {
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.IndexByte(version, '.'); i >= 0 {
+		version = version[:i]
+	}
+	return version
+}
+
+// ForeignFunctionInfo describes a foreign function registered by the plugin.
+type ForeignFunctionInfo struct {
+	Name    string
+	Version string
+}
+
+var registeredForeignFunctions = map[string]ForeignFunctionInfo{}
+
+// ForeignFunction is a capability exposed by another filter, e.g. a golang filter or the WAF engine of Higress,
+// called with the typed request and response of its version.
+type ForeignFunction[Request, Response any] struct {
+	Name    string
+	Version string
+	codec   ForeignCodec
+	call    func(name string, param []byte) ([]byte, error)
+}
+
+// RegisterForeignFunction declares a foreign function called by the plugin, it's registered once by name, with the
+// JSONForeignCodec if the codec is nil:
+//
+//	var checkRequest = wrapper.MustRegisterForeignFunction[WafRequest, WafVerdict]("waf_check", "v1", nil)
+//	verdict, err := checkRequest.Call(WafRequest{URI: ctx.Path()})
+func RegisterForeignFunction[Request, Response any](name, version string, codec ForeignCodec) (*ForeignFunction[Request, Response], error) {
+	if name == "" {
+		return nil, errors.New("foreign function name is empty")
+	}
+	if _, err := strconv.Atoi(majorVersion(version)); err != nil {
+		return nil, fmt.Errorf("invalid version %q of foreign function %s, it should be like v1 or v1.2", version, name)
+	}
+	if registered, ok := registeredForeignFunctions[name]; ok && majorVersion(registered.Version) != majorVersion(version) {
+		return nil, fmt.Errorf("foreign function %s is registered with version %s already", name, registered.Version)
+	}
+	if codec == nil {
+		codec = JSONForeignCodec
+	}
+	registeredForeignFunctions[name] = ForeignFunctionInfo{Name: name, Version: version}
+	return &ForeignFunction[Request, Response]{
+		Name:    name,
+		Version: version,
+		codec:   codec,
+		call:    proxywasm.CallForeignFunction,
+	}, nil
+}
+
+// MustRegisterForeignFunction is like RegisterForeignFunction but panics if the registration fails, it's meant for
+// the package level variables.
+func MustRegisterForeignFunction[Request, Response any](name, version string, codec ForeignCodec) *ForeignFunction[Request, Response] {
+	f, err := RegisterForeignFunction[Request, Response](name, version, codec)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// RegisteredForeignFunctions returns the foreign functions registered by the plugin, sorted by name.
+func RegisteredForeignFunctions() []ForeignFunctionInfo {
+	infos := make([]ForeignFunctionInfo, 0, len(registeredForeignFunctions))
+	for _, info := range registeredForeignFunctions {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Call calls the foreign function synchronously, it returns ErrForeignFunctionNotFound if no filter of the proxy
+// exposes the function.
+func (f *ForeignFunction[Request, Response]) Call(request Request) (Response, error) {
+	var response Response
+	param, err := f.codec.Encode(f.Version, request)
+	if err != nil {
+		return response, fmt.Errorf("encode request of foreign function %s failed: %v", f.Name, err)
+	}
+	data, err := f.call(f.Name, param)
+	if err != nil {
+		if errors.Is(err, types.ErrorStatusNotFound) {
+			return response, fmt.Errorf("%w: %s", ErrForeignFunctionNotFound, f.Name)
+		}
+		return response, fmt.Errorf("call foreign function %s failed: %v", f.Name, err)
+	}
+	if err = f.codec.Decode(f.Version, data, &response); err != nil {
+		var functionErr *ForeignFunctionError
+		if errors.As(err, &functionErr) {
+			functionErr.Function = f.Name
+			return response, functionErr
+		}
+		return response, fmt.Errorf("decode response of foreign function %s failed: %w", f.Name, err)
+	}
+	return response, nil
+}
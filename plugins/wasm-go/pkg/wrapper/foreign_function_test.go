@@ -0,0 +1,106 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+type wafRequest struct {
+	URI string `json:"uri"`
+}
+
+type wafVerdict struct {
+	Allow bool   `json:"allow"`
+	Rule  string `json:"rule,omitempty"`
+}
+
+func TestForeignFunctionCall(t *testing.T) {
+	f, err := RegisterForeignFunction[wafRequest, wafVerdict]("test_waf_check", "v1", nil)
+	require.NoError(t, err)
+	var param []byte
+	f.call = func(name string, p []byte) ([]byte, error) {
+		assert.Equal(t, "test_waf_check", name)
+		param = p
+		return []byte(`{"version": "v1.2", "payload": {"allow": false, "rule": "sqli", "extra": 1}}`), nil
+	}
+	verdict, err := f.Call(wafRequest{URI: "/?id=1 or 1=1"})
+	require.NoError(t, err)
+	assert.Equal(t, wafVerdict{Allow: false, Rule: "sqli"}, verdict)
+	assert.Equal(t, "v1", gjson.GetBytes(param, "version").String())
+	assert.Equal(t, "/?id=1 or 1=1", gjson.GetBytes(param, "payload.uri").String())
+
+	f.call = func(string, []byte) ([]byte, error) {
+		return []byte(`{"version": "v2", "payload": {"allow": true}}`), nil
+	}
+	_, err = f.Call(wafRequest{})
+	assert.ErrorIs(t, err, ErrForeignFunctionIncompatible)
+
+	f.call = func(string, []byte) ([]byte, error) {
+		return []byte(`{"version": "v1", "error": "engine is not ready"}`), nil
+	}
+	_, err = f.Call(wafRequest{})
+	var functionErr *ForeignFunctionError
+	require.True(t, errors.As(err, &functionErr))
+	assert.Equal(t, "test_waf_check", functionErr.Function)
+	assert.Equal(t, "engine is not ready", functionErr.Message)
+
+	f.call = func(string, []byte) ([]byte, error) {
+		return nil, types.ErrorStatusNotFound
+	}
+	_, err = f.Call(wafRequest{})
+	assert.ErrorIs(t, err, ErrForeignFunctionNotFound)
+
+	f.call = func(string, []byte) ([]byte, error) {
+		return []byte(`not json`), nil
+	}
+	_, err = f.Call(wafRequest{})
+	assert.Error(t, err)
+}
+
+func TestRawForeignFunction(t *testing.T) {
+	f := MustRegisterForeignFunction[string, []byte]("test_raw", "v1", RawForeignCodec)
+	f.call = func(name string, param []byte) ([]byte, error) {
+		return append([]byte("echo:"), param...), nil
+	}
+	response, err := f.Call("hello")
+	require.NoError(t, err)
+	assert.Equal(t, "echo:hello", string(response))
+
+	g := MustRegisterForeignFunction[int, string]("test_raw_int", "v1", RawForeignCodec)
+	_, err = g.Call(1)
+	assert.Error(t, err)
+}
+
+func TestRegisterForeignFunction(t *testing.T) {
+	_, err := RegisterForeignFunction[wafRequest, wafVerdict]("", "v1", nil)
+	assert.Error(t, err)
+	_, err = RegisterForeignFunction[wafRequest, wafVerdict]("test_version", "latest", nil)
+	assert.Error(t, err)
+	_, err = RegisterForeignFunction[wafRequest, wafVerdict]("test_version", "v1", nil)
+	require.NoError(t, err)
+	_, err = RegisterForeignFunction[wafRequest, wafVerdict]("test_version", "v1.1", nil)
+	assert.NoError(t, err)
+	_, err = RegisterForeignFunction[wafRequest, wafVerdict]("test_version", "v2", nil)
+	assert.Error(t, err)
+	assert.Contains(t, RegisteredForeignFunctions(), ForeignFunctionInfo{Name: "test_version", Version: "v1.1"})
+	assert.Panics(t, func() { MustRegisterForeignFunction[wafRequest, wafVerdict]("test_version", "v3", nil) })
+}
@@ -0,0 +1,324 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+const geoInfoContextKey = "__geo_info__"
+
+type GeoInfo struct {
+	Country string `json:"country,omitempty"`
+	Region  string `json:"region,omitempty"`
+	City    string `json:"city,omitempty"`
+	ISP     string `json:"isp,omitempty"`
+	ASN     uint32 `json:"asn,omitempty"`
+	ASOrg   string `json:"asOrg,omitempty"`
+}
+
+// GetClientIP returns the client ip from the first address of the header, or from the downstream address if
+// header is empty or absent.
+func GetClientIP(header string) string {
+	if header != "" {
+		if value, _ := proxywasm.GetHttpRequestHeader(header); value != "" {
+			first, _, _ := strings.Cut(value, ",")
+			return stripPort(strings.TrimSpace(first))
+		}
+	}
+	address, err := proxywasm.GetProperty([]string{"source", "address"})
+	if err != nil {
+		return ""
+	}
+	return stripPort(string(address))
+}
+
+func stripPort(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return strings.Trim(address, "[]")
+}
+
+func normalizeIP(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip
+}
+
+// GeoLookup looks up the location of an ip locally, it's implemented by GeoDatabase and MMDBReader.
+type GeoLookup interface {
+	Lookup(ip string) (*GeoInfo, bool)
+}
+
+// GeoDatabase looks up the longest matching network of an ip.
+type GeoDatabase struct {
+	// networks by prefix length, ipv4 and ipv6 networks are kept apart by the key length
+	networks map[int]map[string]*GeoInfo
+	// the prefix lengths present, longest first
+	prefixes []int
+}
+
+// ParseGeoDatabase parses lines in the text format of the geo-ip plugin dataset, with optional ASN columns:
+//
+//	cidr|country|region|city|isp[|asn|as organization]
+//
+// The MaxMind DB files, e.g. GeoLite2-City.mmdb, are read by NewMMDBReader instead.
+func ParseGeoDatabase(data string) (*GeoDatabase, error) {
+	db := &GeoDatabase{networks: map[int]map[string]*GeoInfo{}}
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("invalid geo data at line %d: %s", i+1, line)
+		}
+		info := &GeoInfo{Country: fields[1], Region: fields[2], City: fields[3], ISP: fields[4]}
+		if len(fields) > 5 && fields[5] != "" {
+			asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(fields[5]), "AS"), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid asn at line %d: %s", i+1, fields[5])
+			}
+			info.ASN = uint32(asn)
+		}
+		if len(fields) > 6 {
+			info.ASOrg = fields[6]
+		}
+		if err := db.Add(fields[0], info); err != nil {
+			return nil, fmt.Errorf("invalid geo data at line %d: %v", i+1, err)
+		}
+	}
+	return db, nil
+}
+
+func (db *GeoDatabase) key(ip net.IP, ones int) (int, string) {
+	bits := len(ip) * 8
+	masked := ip.Mask(net.CIDRMask(ones, bits))
+	// ipv6 prefixes are shifted so that /0 of both families do not collide
+	if bits == 128 {
+		ones += 1000
+	}
+	return ones, string(masked)
+}
+
+func (db *GeoDatabase) Add(cidr string, info *GeoInfo) error {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	ones, _ := network.Mask.Size()
+	prefix, key := db.key(normalizeIP(network.IP), ones)
+	if db.networks[prefix] == nil {
+		db.networks[prefix] = map[string]*GeoInfo{}
+		db.prefixes = append(db.prefixes, prefix)
+		sort.Sort(sort.Reverse(sort.IntSlice(db.prefixes)))
+	}
+	db.networks[prefix][key] = info
+	return nil
+}
+
+func (db *GeoDatabase) Lookup(ip string) (*GeoInfo, bool) {
+	if db == nil {
+		return nil, false
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil, false
+	}
+	parsed = normalizeIP(parsed)
+	for _, prefix := range db.prefixes {
+		ones := prefix
+		if len(parsed) == net.IPv6len {
+			if prefix < 1000 {
+				continue
+			}
+			ones -= 1000
+		} else if prefix >= 1000 {
+			continue
+		}
+		_, key := db.key(parsed, ones)
+		if info, ok := db.networks[prefix][key]; ok {
+			return info, true
+		}
+	}
+	return nil, false
+}
+
+type GeoIPServiceConfig struct {
+	Client HttpClient
+	// The request path with the {ip} placeholder, e.g. "/lookup?ip={ip}"
+	Path    string
+	Headers [][2]string
+	Timeout uint32
+	// The gjson paths of the fields in the response, default to the GeoInfo json names
+	CountryPath string
+	RegionPath  string
+	CityPath    string
+	ISPPath     string
+	ASNPath     string
+	ASOrgPath   string
+	// How long the results are cached in the VM, default is 1 day, 1 minute for failed lookups
+	CacheTTL         time.Duration
+	NegativeCacheTTL time.Duration
+	// The maximum number of the cached results, the least recently used one is evicted, default is 10000
+	MaxCacheEntries int
+}
+
+// GeoIPResolver resolves the location of the client from the local database first, then from the remote service.
+type GeoIPResolver struct {
+	database GeoLookup
+	service  *GeoIPServiceConfig
+	cache    *LRUCache[string, *GeoInfo]
+	// The header carrying the client ip, the downstream address is used if empty
+	IPHeader string
+}
+
+// NewGeoIPResolver creates a resolver, either database or service can be nil. The database is a GeoDatabase of the
+// geo-ip text format, or a MMDBReader.
+func NewGeoIPResolver(database GeoLookup, service *GeoIPServiceConfig) *GeoIPResolver {
+	if service != nil {
+		if service.CacheTTL <= 0 {
+			service.CacheTTL = 24 * time.Hour
+		}
+		if service.NegativeCacheTTL <= 0 {
+			service.NegativeCacheTTL = time.Minute
+		}
+		if service.Timeout == 0 {
+			service.Timeout = 500
+		}
+		if service.MaxCacheEntries <= 0 {
+			service.MaxCacheEntries = 10000
+		}
+	}
+	resolver := &GeoIPResolver{database: database, service: service}
+	if service != nil {
+		resolver.cache, _ = NewLRUCache[string, *GeoInfo](service.MaxCacheEntries, 0)
+	}
+	return resolver
+}
+
+// Resolve looks up the client ip and keeps the result in the context for GetGeoInfo(ctx). The callback may be called
+// synchronously, and info is nil if the ip is unknown.
+func (r *GeoIPResolver) Resolve(ctx HttpContext, callback func(info *GeoInfo)) error {
+	ip := GetClientIP(r.IPHeader)
+	done := func(info *GeoInfo) {
+		if info != nil {
+			ctx.SetContext(geoInfoContextKey, info)
+		}
+		callback(info)
+	}
+	if r.database != nil {
+		if info, ok := r.database.Lookup(ip); ok {
+			done(info)
+			return nil
+		}
+	}
+	if r.service == nil || net.ParseIP(ip) == nil {
+		done(nil)
+		return nil
+	}
+	return r.Query(ip, done)
+}
+
+// Query asks the remote service, the result is cached in a bounded cache of the VM, as the clients can make up
+// as many ips as they want.
+func (r *GeoIPResolver) Query(ip string, callback func(info *GeoInfo)) error {
+	if r.service == nil {
+		return errors.New("geoip service is not configured")
+	}
+	if info, ok := r.cache.Get(ip); ok {
+		callback(info)
+		return nil
+	}
+	s := r.service
+	path := strings.ReplaceAll(s.Path, "{ip}", url.QueryEscape(ip))
+	return s.Client.Get(path, s.Headers, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		var info *GeoInfo
+		ttl := s.NegativeCacheTTL
+		if statusCode == http.StatusOK && gjson.ValidBytes(responseBody) {
+			info = s.parse(gjson.ParseBytes(responseBody))
+			ttl = s.CacheTTL
+		} else {
+			proxywasm.LogWarnf("geoip lookup of %s failed, status: %d", ip, statusCode)
+		}
+		r.cache.SetWithTTL(ip, info, ttl)
+		callback(info)
+	}, s.Timeout)
+}
+
+func (s *GeoIPServiceConfig) parse(json gjson.Result) *GeoInfo {
+	path := func(p, def string) string {
+		if p == "" {
+			return def
+		}
+		return p
+	}
+	asn := json.Get(path(s.ASNPath, "asn")).String()
+	parsedASN, _ := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(asn), "AS"), 10, 32)
+	return &GeoInfo{
+		Country: json.Get(path(s.CountryPath, "country")).String(),
+		Region:  json.Get(path(s.RegionPath, "region")).String(),
+		City:    json.Get(path(s.CityPath, "city")).String(),
+		ISP:     json.Get(path(s.ISPPath, "isp")).String(),
+		ASN:     uint32(parsedASN),
+		ASOrg:   json.Get(path(s.ASOrgPath, "asOrg")).String(),
+	}
+}
+
+// GetGeoInfo returns the location of the client resolved by GeoIPResolver, or by the geo-ip plugin in the chain.
+// Returns nil if unknown.
+func GetGeoInfo(ctx HttpContext) *GeoInfo {
+	if c, ok := ctx.(httpContextExtension); ok {
+		return c.geoInfo()
+	}
+	return nil
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) geoInfo() *GeoInfo {
+	if info, ok := ctx.userContext[geoInfoContextKey].(*GeoInfo); ok {
+		return info
+	}
+	// fall back to the properties set by the geo-ip plugin
+	proxywasm.SetEffectiveContext(ctx.contextID)
+	property := func(name string) string {
+		value, _ := proxywasm.GetProperty([]string{name})
+		return string(value)
+	}
+	info := &GeoInfo{
+		Country: property("geo-country"),
+		Region:  property("geo-province"),
+		City:    property("geo-city"),
+		ISP:     property("geo-isp"),
+	}
+	if *info == (GeoInfo{}) {
+		return nil
+	}
+	ctx.userContext[geoInfoContextKey] = info
+	return info
+}
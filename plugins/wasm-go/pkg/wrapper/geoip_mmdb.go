@@ -0,0 +1,347 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+)
+
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// The types of the data section of the MaxMind DB format
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBool
+	mmdbFloat
+)
+
+// MMDBReader looks up the ips in a database of the MaxMind DB format, e.g. GeoLite2-City, GeoIP2-ISP or the
+// databases of the other vendors in the format. The whole file is kept in memory, it's usually embedded in the plugin
+// or fetched on start.
+type MMDBReader struct {
+	// The language of the names of the country, the region and the city, default is en
+	Language     string
+	DatabaseType string
+	data         []byte
+	nodeCount    uint32
+	recordSize   int
+	ipVersion    int
+	// the start of the data section, after the search tree and the 16 bytes separator
+	dataStart int
+	// the node where the ipv4 addresses start in an ipv6 tree, i.e. ::/96
+	ipv4Start uint32
+}
+
+func NewMMDBReader(data []byte) (*MMDBReader, error) {
+	index := bytes.LastIndex(data, mmdbMetadataMarker)
+	if index < 0 {
+		return nil, errors.New("invalid mmdb: metadata is not found")
+	}
+	metadataStart := index + len(mmdbMetadataMarker)
+	decoder := mmdbDecoder{data: data[metadataStart:]}
+	value, _, err := decoder.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mmdb metadata: %v", err)
+	}
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid mmdb metadata: not a map")
+	}
+	uintField := func(name string) uint64 {
+		n, _ := metadata[name].(uint64)
+		return n
+	}
+	r := &MMDBReader{
+		Language:   "en",
+		data:       data[:index],
+		nodeCount:  uint32(uintField("node_count")),
+		recordSize: int(uintField("record_size")),
+		ipVersion:  int(uintField("ip_version")),
+	}
+	r.DatabaseType, _ = metadata["database_type"].(string)
+	if major := uintField("binary_format_major_version"); major != 2 {
+		return nil, fmt.Errorf("unsupported mmdb format version: %d", major)
+	}
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported mmdb record size: %d", r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported mmdb ip version: %d", r.ipVersion)
+	}
+	treeSize := int(r.nodeCount) * r.recordSize / 4
+	r.dataStart = treeSize + 16
+	if r.dataStart > len(r.data) {
+		return nil, errors.New("invalid mmdb: search tree is truncated")
+	}
+	if r.ipVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < r.nodeCount; i++ {
+			r.ipv4Start = r.readRecord(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+// readRecord reads the left (bit 0) or the right (bit 1) record of the node.
+func (r *MMDBReader) readRecord(node uint32, bit int) uint32 {
+	nodeSize := r.recordSize / 4
+	b := r.data[int(node)*nodeSize:]
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 28:
+		if bit == 0 {
+			return uint32(b[3]&0xf0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0f)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	default:
+		return binary.BigEndian.Uint32(b[bit*4:])
+	}
+}
+
+// LookupRecord returns the decoded record of the network containing the ip, the maps are map[string]interface{},
+// the arrays are []interface{}, and the integers are uint64 or int64.
+func (r *MMDBReader) LookupRecord(ip string) (interface{}, bool) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil, false
+	}
+	node := uint32(0)
+	if v4 := parsed.To4(); v4 != nil {
+		parsed, node = v4, r.ipv4Start
+	} else if r.ipVersion == 4 {
+		return nil, false
+	}
+	for i := 0; i < len(parsed)*8 && node < r.nodeCount; i++ {
+		node = r.readRecord(node, int(parsed[i/8]>>(7-i%8))&1)
+	}
+	if node <= r.nodeCount {
+		return nil, false
+	}
+	offset := int(node-r.nodeCount) - 16
+	decoder := mmdbDecoder{data: r.data[r.dataStart:]}
+	value, _, err := decoder.decode(offset, 0)
+	if err != nil {
+		proxywasm.LogWarnf("failed to decode the mmdb record of %s: %v", ip, err)
+		return nil, false
+	}
+	return value, true
+}
+
+// Lookup maps the record of the GeoIP2 and GeoLite2 databases to GeoInfo, the region is the first subdivision, and
+// the isp falls back to the organization.
+func (r *MMDBReader) Lookup(ip string) (*GeoInfo, bool) {
+	value, ok := r.LookupRecord(ip)
+	if !ok {
+		return nil, false
+	}
+	record, _ := value.(map[string]interface{})
+	name := func(v interface{}) string {
+		names, _ := mmdbPath(v, "names").(map[string]interface{})
+		s, _ := names[r.Language].(string)
+		return s
+	}
+	info := &GeoInfo{City: name(record["city"])}
+	info.Country, _ = mmdbPath(record, "country", "iso_code").(string)
+	if subdivisions, _ := record["subdivisions"].([]interface{}); len(subdivisions) > 0 {
+		info.Region = name(subdivisions[0])
+	}
+	if info.ISP, _ = record["isp"].(string); info.ISP == "" {
+		info.ISP, _ = record["organization"].(string)
+	}
+	if asn, ok := record["autonomous_system_number"].(uint64); ok && asn <= math.MaxUint32 {
+		info.ASN = uint32(asn)
+	}
+	info.ASOrg, _ = record["autonomous_system_organization"].(string)
+	return info, true
+}
+
+func mmdbPath(value interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+// The pointers can't nest deeper than this in a valid database, it stops the loops of a corrupted one.
+const mmdbMaxDepth = 32
+
+type mmdbDecoder struct {
+	data []byte
+}
+
+// decode decodes the value at offset, and returns the offset after it.
+func (d *mmdbDecoder) decode(offset, depth int) (interface{}, int, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, errors.New("data is nested too deeply")
+	}
+	if offset < 0 || offset >= len(d.data) {
+		return nil, 0, errors.New("offset is out of the data section")
+	}
+	ctrl := d.data[offset]
+	offset++
+	typ := int(ctrl >> 5)
+	if typ == mmdbPointer {
+		pointer, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer, depth+1)
+		return value, next, err
+	}
+	if typ == mmdbExtended {
+		if offset >= len(d.data) {
+			return nil, 0, errors.New("extended type is truncated")
+		}
+		typ = 7 + int(d.data[offset])
+		offset++
+	}
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > len(d.data) {
+			return nil, 0, errors.New("size is truncated")
+		}
+		extra := 0
+		for _, b := range d.data[offset : offset+n] {
+			extra = extra<<8 | int(b)
+		}
+		offset += n
+		size = [...]int{29, 285, 65821}[n-1] + extra
+	}
+	// every entry takes a byte at least, a larger size is corrupted
+	if (typ == mmdbMap || typ == mmdbArray) && size > len(d.data)-offset {
+		return nil, 0, errors.New("container is truncated")
+	}
+	switch typ {
+	case mmdbMap:
+		m := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			if m[k], offset, err = d.decode(next, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case mmdbArray:
+		a := make([]interface{}, size)
+		for i := range a {
+			var err error
+			if a[i], offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return a, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	case mmdbContainer, mmdbEndMarker:
+		return nil, offset, nil
+	}
+	if offset+size > len(d.data) {
+		return nil, 0, errors.New("value is truncated")
+	}
+	b := d.data[offset : offset+size]
+	offset += size
+	switch typ {
+	case mmdbString:
+		return string(b), offset, nil
+	case mmdbBytes:
+		return append([]byte(nil), b...), offset, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		if size > 8 {
+			return nil, 0, errors.New("invalid integer size")
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, offset, nil
+	case mmdbInt32:
+		if size > 4 {
+			return nil, 0, errors.New("invalid integer size")
+		}
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		// the missing leading bytes are zeros, the sign is the top bit of the 4 bytes
+		return int64(int32(n)), offset, nil
+	case mmdbUint128:
+		// too large for the geo fields, kept as the big endian bytes
+		return append([]byte(nil), b...), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unknown data type: %d", typ)
+}
+
+// pointer returns the offset the pointer points to, and the offset after the pointer.
+func (d *mmdbDecoder) pointer(ctrl byte, offset int) (int, int, error) {
+	n := int(ctrl>>3&0x3) + 1
+	if offset+n > len(d.data) {
+		return 0, 0, errors.New("pointer is truncated")
+	}
+	b := d.data[offset : offset+n]
+	value := int(ctrl & 0x7)
+	if n == 4 {
+		value = 0
+	}
+	for _, c := range b {
+		value = value<<8 | int(c)
+	}
+	value += [...]int{0, 2048, 526336, 0}[n-1]
+	return value, offset + n, nil
+}
@@ -0,0 +1,270 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mmdbTestPointer is encoded as a pointer to the offset in the data section
+type mmdbTestPointer int
+
+func mmdbEncodeControl(typ, size int) []byte {
+	var b []byte
+	if typ <= mmdbMap {
+		b = []byte{byte(typ << 5)}
+	} else {
+		b = []byte{0, byte(typ - 7)}
+	}
+	switch {
+	case size < 29:
+		b[0] |= byte(size)
+	case size < 285:
+		b[0] |= 29
+		b = append(b, byte(size-29))
+	default:
+		b[0] |= 30
+		b = append(b, byte((size-285)>>8), byte(size-285))
+	}
+	return b
+}
+
+func mmdbEncodeUint(typ int, n uint64) []byte {
+	var digits []byte
+	for ; n > 0; n >>= 8 {
+		digits = append([]byte{byte(n)}, digits...)
+	}
+	return append(mmdbEncodeControl(typ, len(digits)), digits...)
+}
+
+func mmdbEncode(value interface{}) []byte {
+	switch v := value.(type) {
+	case string:
+		return append(mmdbEncodeControl(mmdbString, len(v)), v...)
+	case uint16:
+		return mmdbEncodeUint(mmdbUint16, uint64(v))
+	case uint32:
+		return mmdbEncodeUint(mmdbUint32, uint64(v))
+	case uint64:
+		return mmdbEncodeUint(mmdbUint64, v)
+	case int32:
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(v))
+		return append(mmdbEncodeControl(mmdbInt32, 4), b...)
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		return mmdbEncodeControl(mmdbBool, size)
+	case float64:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, math.Float64bits(v))
+		return append(mmdbEncodeControl(mmdbDouble, 8), b...)
+	case mmdbTestPointer:
+		return []byte{byte(mmdbPointer<<5) | byte(v>>8&0x7), byte(v)}
+	case []interface{}:
+		b := mmdbEncodeControl(mmdbArray, len(v))
+		for _, item := range v {
+			b = append(b, mmdbEncode(item)...)
+		}
+		return b
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b := mmdbEncodeControl(mmdbMap, len(v))
+		for _, key := range keys {
+			b = append(b, mmdbEncode(key)...)
+			b = append(b, mmdbEncode(v[key])...)
+		}
+		return b
+	}
+	panic(fmt.Sprintf("unsupported value %T", value))
+}
+
+// buildTestMMDB builds a database with the networks pointing to the offsets in the data section.
+func buildTestMMDB(t *testing.T, ipVersion, recordSize int, networks map[string]int, data []byte) []byte {
+	// a record is 0 if empty, the node index if positive, and -(offset+1) for the data
+	nodes := [][2]int{{}}
+	for cidr, offset := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		ones, _ := network.Mask.Size()
+		ip := network.IP.To16()
+		if v4 := network.IP.To4(); v4 != nil {
+			if ipVersion == 4 {
+				ip = v4
+			} else {
+				ip = append(make(net.IP, 12), v4...)
+				ones += 96
+			}
+		}
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := int(ip[i/8]>>(7-i%8)) & 1
+			if i == ones-1 {
+				nodes[node][bit] = -(offset + 1)
+				break
+			}
+			if nodes[node][bit] <= 0 {
+				nodes = append(nodes, [2]int{})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+	nodeCount := len(nodes)
+	var db []byte
+	for _, node := range nodes {
+		var records [2]uint32
+		for i, record := range node {
+			switch {
+			case record == 0:
+				records[i] = uint32(nodeCount)
+			case record > 0:
+				records[i] = uint32(record)
+			default:
+				records[i] = uint32(nodeCount + 16 - record - 1)
+			}
+		}
+		left, right := records[0], records[1]
+		switch recordSize {
+		case 24:
+			db = append(db, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			db = append(db, byte(left>>16), byte(left>>8), byte(left), byte(left>>24<<4)|byte(right>>24&0xf),
+				byte(right>>16), byte(right>>8), byte(right))
+		default:
+			db = binary.BigEndian.AppendUint32(db, left)
+			db = binary.BigEndian.AppendUint32(db, right)
+		}
+	}
+	db = append(db, make([]byte, 16)...)
+	db = append(db, data...)
+	db = append(db, mmdbMetadataMarker...)
+	return append(db, mmdbEncode(map[string]interface{}{
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+		"ip_version":                  uint16(ipVersion),
+		"database_type":               "Test-City",
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+	})...)
+}
+
+func TestMMDBReader(t *testing.T) {
+	// the shared string is referred to by the pointers
+	data := mmdbEncode("CHINANET")
+	cityOffset := len(data)
+	data = append(data, mmdbEncode(map[string]interface{}{
+		"city":         map[string]interface{}{"names": map[string]interface{}{"en": "Fuzhou", "zh-CN": "福州"}},
+		"country":      map[string]interface{}{"iso_code": "CN"},
+		"subdivisions": []interface{}{map[string]interface{}{"names": map[string]interface{}{"en": "Fujian"}}},
+		"location":     map[string]interface{}{"latitude": 26.0614, "metro_code": int32(-1)},
+		"is_anycast":   true,
+		"isp":          mmdbTestPointer(0),
+		"note":         strings.Repeat("x", 300),
+	})...)
+	asnOffset := len(data)
+	data = append(data, mmdbEncode(map[string]interface{}{
+		"autonomous_system_number":       uint32(4134),
+		"autonomous_system_organization": mmdbTestPointer(0),
+		"organization":                   "China Telecom",
+		"traffic":                        uint64(1) << 40,
+	})...)
+	networks := map[string]int{"1.0.1.0/24": cityOffset, "2001:db8::/32": asnOffset}
+
+	for _, recordSize := range []int{24, 28, 32} {
+		t.Run(fmt.Sprintf("record size %d", recordSize), func(t *testing.T) {
+			reader, err := NewMMDBReader(buildTestMMDB(t, 6, recordSize, networks, data))
+			require.NoError(t, err)
+			assert.Equal(t, "Test-City", reader.DatabaseType)
+
+			info, ok := reader.Lookup("1.0.1.7")
+			require.True(t, ok)
+			assert.Equal(t, GeoInfo{Country: "CN", Region: "Fujian", City: "Fuzhou", ISP: "CHINANET"}, *info)
+			info, ok = reader.Lookup("::ffff:1.0.1.1")
+			require.True(t, ok)
+			assert.Equal(t, "Fuzhou", info.City)
+
+			info, ok = reader.Lookup("2001:db8::1")
+			require.True(t, ok)
+			assert.Equal(t, GeoInfo{ISP: "China Telecom", ASN: 4134, ASOrg: "CHINANET"}, *info)
+
+			_, ok = reader.Lookup("1.0.2.1")
+			assert.False(t, ok)
+			_, ok = reader.Lookup("2400::1")
+			assert.False(t, ok)
+			_, ok = reader.Lookup("invalid")
+			assert.False(t, ok)
+		})
+	}
+
+	reader, err := NewMMDBReader(buildTestMMDB(t, 6, 24, networks, data))
+	require.NoError(t, err)
+	record, ok := reader.LookupRecord("1.0.1.1")
+	require.True(t, ok)
+	assert.Equal(t, 26.0614, mmdbPath(record, "location", "latitude"))
+	assert.Equal(t, int64(-1), mmdbPath(record, "location", "metro_code"))
+	assert.Equal(t, true, mmdbPath(record, "is_anycast"))
+	assert.Equal(t, strings.Repeat("x", 300), mmdbPath(record, "note"))
+	record, ok = reader.LookupRecord("2001:db8::1")
+	require.True(t, ok)
+	assert.Equal(t, uint64(1)<<40, mmdbPath(record, "traffic"))
+	reader.Language = "zh-CN"
+	info, ok := reader.Lookup("1.0.1.1")
+	require.True(t, ok)
+	assert.Equal(t, "福州", info.City)
+
+	// the ipv6 addresses aren't in an ipv4 database
+	reader, err = NewMMDBReader(buildTestMMDB(t, 4, 24, map[string]int{"1.0.1.0/24": cityOffset}, data))
+	require.NoError(t, err)
+	_, ok = reader.Lookup("1.0.1.1")
+	assert.True(t, ok)
+	_, ok = reader.Lookup("2001:db8::1")
+	assert.False(t, ok)
+}
+
+func TestMMDBReaderInvalid(t *testing.T) {
+	_, err := NewMMDBReader([]byte("not a database"))
+	assert.Error(t, err)
+	valid := buildTestMMDB(t, 6, 24, map[string]int{"1.0.1.0/24": 0}, mmdbEncode("CN"))
+	_, err = NewMMDBReader(valid[len(valid)-40:])
+	assert.Error(t, err)
+
+	// a pointer to itself
+	decoder := mmdbDecoder{data: mmdbEncode(mmdbTestPointer(0))}
+	_, _, err = decoder.decode(0, 0)
+	assert.Error(t, err)
+	// a map larger than the data
+	decoder = mmdbDecoder{data: mmdbEncodeControl(mmdbMap, 1000)}
+	_, _, err = decoder.decode(0, 0)
+	assert.Error(t, err)
+	decoder = mmdbDecoder{data: mmdbEncode("truncated")[:4]}
+	_, _, err = decoder.decode(0, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestGeoDatabase(t *testing.T) {
+	db, err := ParseGeoDatabase(`
+# cidr|country|region|city|isp|asn|as organization
+1.0.0.0/8|中国|||
+1.0.1.0/24|中国|福建省|福州市|电信|AS4134|CHINANET
+2001:db8::/32|Example|||Example ISP|64496
+::/0|IPv6||||
+`)
+	require.NoError(t, err)
+
+	info, ok := db.Lookup("1.0.1.7")
+	require.True(t, ok)
+	assert.Equal(t, GeoInfo{Country: "中国", Region: "福建省", City: "福州市", ISP: "电信", ASN: 4134, ASOrg: "CHINANET"}, *info)
+
+	info, ok = db.Lookup("1.2.3.4")
+	require.True(t, ok)
+	assert.Equal(t, "中国", info.Country)
+	assert.Empty(t, info.Region)
+
+	info, ok = db.Lookup("2001:db8::1")
+	require.True(t, ok)
+	assert.Equal(t, uint32(64496), info.ASN)
+
+	info, ok = db.Lookup("::ffff:1.0.1.1")
+	require.True(t, ok)
+	assert.Equal(t, "福州市", info.City)
+
+	info, ok = db.Lookup("2400::1")
+	require.True(t, ok)
+	assert.Equal(t, "IPv6", info.Country)
+
+	_, ok = db.Lookup("8.8.8.8")
+	assert.False(t, ok)
+	_, ok = db.Lookup("invalid")
+	assert.False(t, ok)
+
+	_, err = ParseGeoDatabase("1.0.0.0/33|a|b|c|d")
+	assert.Error(t, err)
+	_, err = ParseGeoDatabase("1.0.0.0/8|a|b")
+	assert.Error(t, err)
+}
+
+func TestGeoIPServiceParse(t *testing.T) {
+	config := &GeoIPServiceConfig{CountryPath: "data.country_code", ASNPath: "data.as"}
+	info := config.parse(gjson.Parse(`{"data": {"country_code": "CN", "as": "AS37963"}, "city": "Hangzhou"}`))
+	assert.Equal(t, GeoInfo{Country: "CN", City: "Hangzhou", ASN: 37963}, *info)
+}
+
+func TestStripPort(t *testing.T) {
+	assert.Equal(t, "1.1.1.1", stripPort("1.1.1.1:8080"))
+	assert.Equal(t, "1.1.1.1", stripPort("1.1.1.1"))
+	assert.Equal(t, "::1", stripPort("[::1]:80"))
+	assert.Equal(t, "::1", stripPort("::1"))
+}
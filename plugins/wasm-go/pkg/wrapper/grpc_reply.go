@@ -0,0 +1,167 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+)
+
+// GrpcCode is the status code of gRPC, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+type GrpcCode int32
+
+const (
+	GrpcOK GrpcCode = iota
+	GrpcCanceled
+	GrpcUnknown
+	GrpcInvalidArgument
+	GrpcDeadlineExceeded
+	GrpcNotFound
+	GrpcAlreadyExists
+	GrpcPermissionDenied
+	GrpcResourceExhausted
+	GrpcFailedPrecondition
+	GrpcAborted
+	GrpcOutOfRange
+	GrpcUnimplemented
+	GrpcInternal
+	GrpcUnavailable
+	GrpcDataLoss
+	GrpcUnauthenticated
+)
+
+var grpcCodeNames = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND", "ALREADY_EXISTS",
+	"PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED",
+	"INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED",
+}
+
+func (c GrpcCode) String() string {
+	if c >= 0 && int(c) < len(grpcCodeNames) {
+		return grpcCodeNames[c]
+	}
+	return "CODE(" + strconv.Itoa(int(c)) + ")"
+}
+
+// HTTPStatus returns the HTTP status of the code, used for the reply to the requests which are not gRPC.
+func (c GrpcCode) HTTPStatus() int {
+	switch c {
+	case GrpcOK:
+		return http.StatusOK
+	case GrpcCanceled:
+		return 499
+	case GrpcInvalidArgument, GrpcFailedPrecondition, GrpcOutOfRange:
+		return http.StatusBadRequest
+	case GrpcDeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case GrpcNotFound:
+		return http.StatusNotFound
+	case GrpcAlreadyExists, GrpcAborted:
+		return http.StatusConflict
+	case GrpcPermissionDenied:
+		return http.StatusForbidden
+	case GrpcResourceExhausted:
+		return http.StatusTooManyRequests
+	case GrpcUnimplemented:
+		return http.StatusNotImplemented
+	case GrpcUnavailable:
+		return http.StatusServiceUnavailable
+	case GrpcUnauthenticated:
+		return http.StatusUnauthorized
+	}
+	return http.StatusInternalServerError
+}
+
+// GrpcCodeFromHTTPStatus maps the HTTP status to the gRPC code as the gRPC clients do, so the plugins replying
+// with an HTTP status can reply on the gRPC routes too.
+func GrpcCodeFromHTTPStatus(status int) GrpcCode {
+	switch status {
+	case http.StatusOK:
+		return GrpcOK
+	case http.StatusBadRequest:
+		return GrpcInternal
+	case http.StatusUnauthorized:
+		return GrpcUnauthenticated
+	case http.StatusForbidden:
+		return GrpcPermissionDenied
+	case http.StatusNotFound:
+		return GrpcUnimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return GrpcUnavailable
+	}
+	return GrpcUnknown
+}
+
+// IsGrpcRequest returns true for the requests of gRPC, including gRPC-Web.
+func IsGrpcRequest() bool {
+	contentType, _ := proxywasm.GetHttpRequestHeader("content-type")
+	return strings.HasPrefix(contentType, "application/grpc")
+}
+
+// IsGrpcWebRequest returns true for the requests of gRPC-Web, in binary or text format.
+func IsGrpcWebRequest() bool {
+	contentType, _ := proxywasm.GetHttpRequestHeader("content-type")
+	return strings.HasPrefix(contentType, "application/grpc-web")
+}
+
+// EncodeGrpcMessage percent-encodes the message for the grpc-message header as the gRPC spec requires.
+func EncodeGrpcMessage(message string) string {
+	var buf strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+// SendGrpcLocalReply replies with the gRPC status, it should be followed by returning types.ActionPause:
+//
+//	_ = wrapper.SendGrpcLocalReply(wrapper.GrpcUnauthenticated, "invalid token")
+//	return types.ActionPause
+//
+// The gRPC requests get a trailers-only response, where the proxy adds grpc-status and grpc-message. The gRPC-Web
+// requests get a response of status 200 with grpc-status and grpc-message in the headers, since the proxy doesn't
+// convert the local replies of gRPC-Web unless the grpc_web filter is placed before. The other requests get the
+// HTTP status of the code with the message as the body.
+func SendGrpcLocalReply(code GrpcCode, message string) error {
+	return SendGrpcLocalReplyWithDetail(code, "grpc."+strings.ToLower(code.String()), message)
+}
+
+func SendGrpcLocalReplyWithDetail(code GrpcCode, details string, message string) error {
+	switch {
+	case IsGrpcWebRequest():
+		contentType, _ := proxywasm.GetHttpRequestHeader("content-type")
+		headers := [][2]string{
+			{"content-type", contentType},
+			{"grpc-status", strconv.Itoa(int(code))},
+		}
+		if message != "" {
+			headers = append(headers, [2]string{"grpc-message", EncodeGrpcMessage(message)})
+		}
+		return proxywasm.SendHttpResponseWithDetail(http.StatusOK, details, headers, nil, -1)
+	case IsGrpcRequest():
+		return proxywasm.SendHttpResponseWithDetail(uint32(code.HTTPStatus()), details, nil, []byte(message), int32(code))
+	}
+	return proxywasm.SendHttpResponseWithDetail(uint32(code.HTTPStatus()), details,
+		[][2]string{{"content-type", "text/plain; charset=utf-8"}}, []byte(message), -1)
+}
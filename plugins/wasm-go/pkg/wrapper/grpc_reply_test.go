@@ -0,0 +1,36 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGrpcCode(t *testing.T) {
+	assert.Equal(t, "RESOURCE_EXHAUSTED", GrpcResourceExhausted.String())
+	assert.Equal(t, "CODE(20)", GrpcCode(20).String())
+	assert.Equal(t, http.StatusTooManyRequests, GrpcResourceExhausted.HTTPStatus())
+	assert.Equal(t, http.StatusInternalServerError, GrpcDataLoss.HTTPStatus())
+	assert.Equal(t, GrpcPermissionDenied, GrpcCodeFromHTTPStatus(http.StatusForbidden))
+	assert.Equal(t, GrpcUnavailable, GrpcCodeFromHTTPStatus(http.StatusServiceUnavailable))
+	assert.Equal(t, GrpcUnknown, GrpcCodeFromHTTPStatus(http.StatusTeapot))
+}
+
+func TestEncodeGrpcMessage(t *testing.T) {
+	assert.Equal(t, "100%25%0Anext %E4%BD%A0", EncodeGrpcMessage("100%\nnext 你"))
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+// HeaderLayout controls the order and the casing of the request headers for the picky legacy upstreams, e.g. the
+// backends validating the exact header casing of a signed request:
+//
+//	{"order": ["host", "date", "x-api-key"], "casing": {"x-api-key": "X-API-Key", "date": "Date"}}
+//
+// The pseudo headers come first, then the headers of order in that order, then the others in their original order.
+// Envoy keeps the header names in lower case, so the casing only takes effect on the wire if the upstream cluster
+// formats the header keys with a stateful formatter like preserve_case. Arrange applies the casing to the headers
+// serialized by the plugin, e.g. the signed headers or a raw HTTP/1 payload.
+type HeaderLayout struct {
+	Order []string
+	// The canonical names keyed by the lower case names
+	Casing map[string]string
+}
+
+func ParseHeaderLayout(json gjson.Result) (*HeaderLayout, error) {
+	layout := &HeaderLayout{Casing: map[string]string{}}
+	seen := map[string]bool{}
+	for _, item := range json.Get("order").Array() {
+		name := strings.ToLower(item.String())
+		if name == "" || strings.HasPrefix(name, ":") {
+			return nil, fmt.Errorf("invalid header %q in order", item.String())
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("header %s is duplicated in order", name)
+		}
+		seen[name] = true
+		layout.Order = append(layout.Order, name)
+	}
+	var err error
+	json.Get("casing").ForEach(func(key, value gjson.Result) bool {
+		if !strings.EqualFold(key.String(), value.String()) {
+			err = fmt.Errorf("casing %s of header %s is not the same name", value.String(), key.String())
+			return false
+		}
+		layout.Casing[strings.ToLower(key.String())] = value.String()
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return layout, nil
+}
+
+// CanonicalName returns the name in the casing of the layout, or as it is if it's not in the casing.
+func (l *HeaderLayout) CanonicalName(name string) string {
+	if canonical, ok := l.Casing[strings.ToLower(name)]; ok {
+		return canonical
+	}
+	return name
+}
+
+// Arrange returns the headers in the order and the casing of the layout, the values of a header keep their order.
+func (l *HeaderLayout) Arrange(headers [][2]string) [][2]string {
+	rank := make(map[string]int, len(l.Order))
+	for i, name := range l.Order {
+		rank[name] = i
+	}
+	var pseudo, rest [][2]string
+	ordered := make([][][2]string, len(l.Order))
+	for _, header := range headers {
+		name := strings.ToLower(header[0])
+		switch i, ok := rank[name]; {
+		case strings.HasPrefix(name, ":"):
+			pseudo = append(pseudo, header)
+		case ok:
+			ordered[i] = append(ordered[i], [2]string{l.CanonicalName(header[0]), header[1]})
+		default:
+			rest = append(rest, [2]string{l.CanonicalName(header[0]), header[1]})
+		}
+	}
+	arranged := make([][2]string, 0, len(headers))
+	arranged = append(arranged, pseudo...)
+	for _, group := range ordered {
+		arranged = append(arranged, group...)
+	}
+	return append(arranged, rest...)
+}
+
+// ApplyRequest rearranges the request headers, it should be called in the request headers phase after the headers
+// are modified.
+func (l *HeaderLayout) ApplyRequest() error {
+	headers, err := proxywasm.GetHttpRequestHeaders()
+	if err != nil {
+		return err
+	}
+	return proxywasm.ReplaceHttpRequestHeaders(l.Arrange(headers))
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestHeaderLayoutArrange(t *testing.T) {
+	layout, err := ParseHeaderLayout(gjson.Parse(`{"order": ["Date", "x-api-key"], "casing": {"x-api-key": "X-API-Key", "date": "Date"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"date", "x-api-key"}, layout.Order)
+	arranged := layout.Arrange([][2]string{
+		{":authority", "example.com"},
+		{"accept", "*/*"},
+		{"x-api-key", "k1"},
+		{":path", "/"},
+		{"date", "today"},
+		{"x-api-key", "k2"},
+		{"user-agent", "curl"},
+	})
+	assert.Equal(t, [][2]string{
+		{":authority", "example.com"},
+		{":path", "/"},
+		{"Date", "today"},
+		{"X-API-Key", "k1"},
+		{"X-API-Key", "k2"},
+		{"accept", "*/*"},
+		{"user-agent", "curl"},
+	}, arranged)
+	assert.Equal(t, "content-type", layout.CanonicalName("content-type"))
+}
+
+func TestParseHeaderLayoutInvalid(t *testing.T) {
+	for _, config := range []string{
+		`{"order": [":path"]}`,
+		`{"order": ["date", "Date"]}`,
+		`{"order": [""]}`,
+		`{"casing": {"x-api-key": "X-Api-Token"}}`,
+	} {
+		_, err := ParseHeaderLayout(gjson.Parse(config))
+		assert.Error(t, err, config)
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+const scrubMask = "****"
+
+// DefaultSensitiveHeaders is the shared denylist of headers carrying credentials.
+var DefaultSensitiveHeaders = []string{
+	"authorization",
+	"proxy-authorization",
+	"cookie",
+	"set-cookie",
+	"x-api-key",
+	"api-key",
+	"apikey",
+	"x-auth-token",
+	"x-access-token",
+	"x-csrf-token",
+	"x-amz-security-token",
+	"x-acs-security-token",
+	"x-ca-signature",
+	"x-goog-api-key",
+}
+
+// LogScrubber masks the sensitive values in the user attributes written to the access log. It's nil by default, so
+// the access log of the existing plugins is kept, set it when parsing the config to enable.
+var LogScrubber *HeaderScrubber
+
+// MirrorScrubber removes the sensitive headers from the requests sent by MirrorRequest, set it to nil to disable.
+var MirrorScrubber = NewHeaderScrubber(false, nil)
+
+// HeaderScrubber strips or masks sensitive headers before they are logged or sent to a mirror/shadow upstream.
+type HeaderScrubber struct {
+	names    map[string]bool
+	patterns []*regexp.Regexp
+	// Mask the values instead of removing the headers
+	Mask bool
+	// The number of leading characters of the credential kept when masking, 0 masks the whole value
+	KeepPrefix int
+}
+
+// NewHeaderScrubber creates a scrubber with the default denylist and the extra header names.
+func NewHeaderScrubber(mask bool, extra []string) *HeaderScrubber {
+	s := &HeaderScrubber{names: map[string]bool{}, Mask: mask}
+	for _, name := range append(append([]string{}, DefaultSensitiveHeaders...), extra...) {
+		s.names[strings.ToLower(name)] = true
+	}
+	return s
+}
+
+// ParseHeaderScrubber parses the config, for example:
+//
+//	scrub:
+//	  headers: ["x-my-token"]
+//	  patterns: ["^x-secret-.*"]
+//	  mode: mask # or strip
+//	  keepPrefix: 4
+//	  disableDefault: false
+func ParseHeaderScrubber(json gjson.Result) (*HeaderScrubber, error) {
+	var extra []string
+	for _, item := range json.Get("headers").Array() {
+		extra = append(extra, item.String())
+	}
+	mode := json.Get("mode").String()
+	if mode != "" && mode != "mask" && mode != "strip" {
+		return nil, fmt.Errorf("invalid scrub mode: %s", mode)
+	}
+	s := NewHeaderScrubber(mode != "strip", extra)
+	if json.Get("disableDefault").Bool() {
+		s.names = map[string]bool{}
+		for _, name := range extra {
+			s.names[strings.ToLower(name)] = true
+		}
+	}
+	for _, item := range json.Get("patterns").Array() {
+		pattern, err := regexp.Compile("(?i)" + item.String())
+		if err != nil {
+			return nil, fmt.Errorf("invalid scrub pattern %q: %v", item.String(), err)
+		}
+		s.patterns = append(s.patterns, pattern)
+	}
+	s.KeepPrefix = int(json.Get("keepPrefix").Int())
+	return s, nil
+}
+
+func (s *HeaderScrubber) IsSensitive(name string) bool {
+	name = strings.ToLower(name)
+	if s.names[name] {
+		return true
+	}
+	for _, pattern := range s.patterns {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *HeaderScrubber) maskToken(token string) string {
+	if s.KeepPrefix > 0 && len(token) > s.KeepPrefix*2 {
+		return token[:s.KeepPrefix] + scrubMask
+	}
+	return scrubMask
+}
+
+// MaskValue masks the credential in the header value, the auth scheme and the cookie names are kept.
+func (s *HeaderScrubber) MaskValue(name, value string) string {
+	switch strings.ToLower(name) {
+	case "cookie":
+		cookies := strings.Split(value, ";")
+		for i, cookie := range cookies {
+			if k, v, found := strings.Cut(cookie, "="); found {
+				cookies[i] = k + "=" + s.maskToken(strings.TrimSpace(v))
+			}
+		}
+		return strings.Join(cookies, ";")
+	case "set-cookie":
+		k, rest, found := strings.Cut(value, "=")
+		if !found {
+			return scrubMask
+		}
+		v, attributes, _ := strings.Cut(rest, ";")
+		masked := k + "=" + s.maskToken(v)
+		if attributes != "" {
+			masked += ";" + attributes
+		}
+		return masked
+	case "authorization", "proxy-authorization":
+		if scheme, credential, found := strings.Cut(value, " "); found {
+			return scheme + " " + s.maskToken(strings.TrimSpace(credential))
+		}
+	}
+	return s.maskToken(value)
+}
+
+// Scrub returns a copy of the headers with the sensitive ones masked or removed.
+func (s *HeaderScrubber) Scrub(headers [][2]string) [][2]string {
+	result := make([][2]string, 0, len(headers))
+	for _, h := range headers {
+		if !s.IsSensitive(h[0]) {
+			result = append(result, h)
+		} else if s.Mask {
+			result = append(result, [2]string{h[0], s.MaskValue(h[0], h[1])})
+		}
+	}
+	return result
+}
+
+// ScrubAttributes masks or removes the string values whose keys are sensitive header names, the map is modified in place.
+func (s *HeaderScrubber) ScrubAttributes(attributes map[string]interface{}) {
+	for key, value := range attributes {
+		if !s.IsSensitive(key) && !s.IsSensitive(strings.ReplaceAll(key, "_", "-")) {
+			continue
+		}
+		if !s.Mask {
+			delete(attributes, key)
+			continue
+		}
+		if str, ok := value.(string); ok {
+			attributes[key] = s.MaskValue(strings.ReplaceAll(key, "_", "-"), str)
+		} else {
+			attributes[key] = scrubMask
+		}
+	}
+}
+
+// MirrorRequest sends a copy of the current request to the mirror/shadow upstream and ignores the response. The
+// headers are scrubbed by MirrorScrubber, so the credentials of the client don't reach the shadow upstream.
+func MirrorRequest(client HttpClient, body []byte, timeoutMillisecond ...uint32) error {
+	headers, err := proxywasm.GetHttpRequestHeaders()
+	if err != nil {
+		return err
+	}
+	var method, path string
+	forwarded := make([][2]string, 0, len(headers))
+	for _, h := range headers {
+		switch strings.ToLower(h[0]) {
+		case ":method":
+			method = h[1]
+		case ":path":
+			path = h[1]
+		case "content-length", "transfer-encoding":
+		default:
+			if !strings.HasPrefix(h[0], ":") {
+				forwarded = append(forwarded, h)
+			}
+		}
+	}
+	// the path is not to be taken as the authority of the url
+	if strings.HasPrefix(path, "//") {
+		path = "/" + strings.TrimLeft(path, "/")
+	}
+	if MirrorScrubber != nil {
+		forwarded = MirrorScrubber.Scrub(forwarded)
+	}
+	return client.Call(method, path, forwarded, body, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		proxywasm.LogDebugf("mirrored request of %s is done, status: %d", path, statusCode)
+	}, timeoutMillisecond...)
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestHeaderScrubberMask(t *testing.T) {
+	s := NewHeaderScrubber(true, []string{"X-My-Token"})
+	headers := [][2]string{
+		{":path", "/"},
+		{"Authorization", "Bearer sk-1234567890"},
+		{"cookie", "session=abc; theme=dark"},
+		{"set-cookie", "session=abc; Path=/; HttpOnly"},
+		{"x-my-token", "secret"},
+		{"content-type", "application/json"},
+	}
+	assert.Equal(t, [][2]string{
+		{":path", "/"},
+		{"Authorization", "Bearer ****"},
+		{"cookie", "session=****; theme=****"},
+		{"set-cookie", "session=****; Path=/; HttpOnly"},
+		{"x-my-token", "****"},
+		{"content-type", "application/json"},
+	}, s.Scrub(headers))
+
+	s.KeepPrefix = 3
+	assert.Equal(t, "Bearer sk-****", s.MaskValue("authorization", "Bearer sk-1234567890"))
+	assert.Equal(t, "****", s.MaskValue("x-api-key", "short"))
+}
+
+func TestHeaderScrubberStrip(t *testing.T) {
+	s, err := ParseHeaderScrubber(gjson.Parse(`{"mode": "strip", "patterns": ["^x-secret-"], "disableDefault": true}`))
+	require.NoError(t, err)
+	assert.Equal(t, [][2]string{{"authorization", "Basic abc"}}, s.Scrub([][2]string{
+		{"authorization", "Basic abc"},
+		{"X-Secret-Key", "abc"},
+	}))
+
+	_, err = ParseHeaderScrubber(gjson.Parse(`{"mode": "hide"}`))
+	assert.Error(t, err)
+}
+
+func TestScrubAttributes(t *testing.T) {
+	attributes := map[string]interface{}{
+		"api_key":       "abc",
+		"authorization": "Bearer abc",
+		"consumer":      "consumer1",
+		"x-auth-token":  123,
+	}
+	assert.Nil(t, LogScrubber)
+	NewHeaderScrubber(true, nil).ScrubAttributes(attributes)
+	assert.Equal(t, map[string]interface{}{
+		"api_key":       "****",
+		"authorization": "Bearer ****",
+		"consumer":      "consumer1",
+		"x-auth-token":  "****",
+	}, attributes)
+}
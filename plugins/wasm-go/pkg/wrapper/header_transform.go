@@ -0,0 +1,336 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/matcher"
+)
+
+const (
+	// The scheme, host, path and method of the request
+	TemplateSourceRequest = "request"
+	// The user attributes set by the plugin
+	TemplateSourceAttribute = "attribute"
+	// The string values of the user context set by the plugin
+	TemplateSourceContext = "context"
+	// The response headers, only in the response phase
+	TemplateSourceResponse = "response"
+)
+
+type HeaderOp string
+
+const (
+	// Add a value, the existing values are kept
+	HeaderOpAdd HeaderOp = "add"
+	// Replace the values with the value
+	HeaderOpSet    HeaderOp = "set"
+	HeaderOpRemove HeaderOp = "remove"
+	// Move the values to the header of to
+	HeaderOpRename HeaderOp = "rename"
+)
+
+type HeaderOperation struct {
+	Op   HeaderOp
+	Name string
+	// The value of add and set, the header is untouched if the rendered value is empty
+	Value *PromptTemplate
+	// The new name of rename
+	To string
+}
+
+// HeaderTransform is the list of the operations on the request and response headers, executed in order:
+//
+//	{
+//	  "request": [
+//	    {"op": "set", "name": "x-user", "value": "{{consumer.name | anonymous}}"},
+//	    {"op": "add", "name": "x-forwarded-path", "value": "{{request.path}}"},
+//	    {"op": "rename", "name": "x-token", "to": "authorization"},
+//	    {"op": "remove", "name": "x-internal"}
+//	  ],
+//	  "response": [{"op": "set", "name": "x-upstream-status", "value": "{{response.:status}}"}],
+//	  "layout": {"order": ["host", "authorization"], "casing": {"authorization": "Authorization"}}
+//	}
+//
+// The values are templates of the request, attribute, context, header, query and consumer sources, and the response
+// source in the response phase. The wrapper executes the _headers_ of the global config, even if no rule is matched,
+// and then the ones of the matched rule, so a plugin of simple header transformations needs no code.
+type HeaderTransform struct {
+	Request  []HeaderOperation
+	Response []HeaderOperation
+	// The layout of the request headers applied after the request operations, see HeaderLayout
+	Layout *HeaderLayout
+}
+
+func ParseHeaderTransform(json gjson.Result) (*HeaderTransform, error) {
+	request, err := parseHeaderOperations(json.Get("request"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid request header operation: %v", err)
+	}
+	response, err := parseHeaderOperations(json.Get("response"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid response header operation: %v", err)
+	}
+	transform := &HeaderTransform{Request: request, Response: response}
+	if layout := json.Get("layout"); layout.Exists() {
+		if transform.Layout, err = ParseHeaderLayout(layout); err != nil {
+			return nil, fmt.Errorf("invalid header layout: %v", err)
+		}
+	}
+	return transform, nil
+}
+
+func parseHeaderOperations(json gjson.Result) ([]HeaderOperation, error) {
+	var operations []HeaderOperation
+	for _, item := range json.Array() {
+		operation := HeaderOperation{
+			Op:   HeaderOp(item.Get("op").String()),
+			Name: strings.ToLower(item.Get("name").String()),
+			To:   strings.ToLower(item.Get("to").String()),
+		}
+		if operation.Name == "" {
+			return nil, fmt.Errorf("name is required: %s", item.Raw)
+		}
+		if strings.HasPrefix(operation.Name, ":") || strings.HasPrefix(operation.To, ":") {
+			return nil, fmt.Errorf("pseudo header %s can't be transformed", operation.Name)
+		}
+		switch operation.Op {
+		case HeaderOpAdd, HeaderOpSet:
+			value := item.Get("value")
+			if !value.Exists() {
+				return nil, fmt.Errorf("value of %s %s is required", operation.Op, operation.Name)
+			}
+			template, err := ParsePromptTemplate(value.String(), PromptTemplateOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("value of %s %s: %v", operation.Op, operation.Name, err)
+			}
+			operation.Value = template
+		case HeaderOpRename:
+			if operation.To == "" {
+				return nil, fmt.Errorf("to of rename %s is required", operation.Name)
+			}
+		case HeaderOpRemove:
+		default:
+			return nil, fmt.Errorf("unknown op %q of %s", operation.Op, operation.Name)
+		}
+		operations = append(operations, operation)
+	}
+	return operations, nil
+}
+
+// headerAccessor reads and writes the headers of a phase.
+type headerAccessor struct {
+	get     func() ([][2]string, error)
+	add     func(key, value string) error
+	replace func(key, value string) error
+	remove  func(key string) error
+}
+
+var (
+	requestHeaderAccessor = headerAccessor{
+		get:     proxywasm.GetHttpRequestHeaders,
+		add:     proxywasm.AddHttpRequestHeader,
+		replace: proxywasm.ReplaceHttpRequestHeader,
+		remove:  proxywasm.RemoveHttpRequestHeader,
+	}
+	responseHeaderAccessor = headerAccessor{
+		get:     proxywasm.GetHttpResponseHeaders,
+		add:     proxywasm.AddHttpResponseHeader,
+		replace: proxywasm.ReplaceHttpResponseHeader,
+		remove:  proxywasm.RemoveHttpResponseHeader,
+	}
+)
+
+// ApplyRequest executes the request operations in the request headers phase.
+func (t *HeaderTransform) ApplyRequest(sources TemplateSources) error {
+	if err := applyHeaderOperations(t.Request, requestHeaderAccessor, sources); err != nil {
+		return err
+	}
+	if t.Layout != nil {
+		return t.Layout.ApplyRequest()
+	}
+	return nil
+}
+
+// ApplyResponse executes the response operations in the response headers phase.
+func (t *HeaderTransform) ApplyResponse(sources TemplateSources) error {
+	return applyHeaderOperations(t.Response, responseHeaderAccessor, sources)
+}
+
+func applyHeaderOperations(operations []HeaderOperation, headers headerAccessor, sources TemplateSources) error {
+	for _, operation := range operations {
+		var err error
+		switch operation.Op {
+		case HeaderOpAdd, HeaderOpSet:
+			var value string
+			value, err = operation.Value.Render(sources)
+			if err != nil || value == "" {
+				break
+			}
+			if operation.Op == HeaderOpAdd {
+				err = headers.add(operation.Name, value)
+			} else {
+				err = headers.replace(operation.Name, value)
+			}
+		case HeaderOpRemove:
+			err = headers.remove(operation.Name)
+		case HeaderOpRename:
+			err = renameHeader(headers, operation.Name, operation.To)
+		}
+		if err != nil {
+			return fmt.Errorf("%s header %s failed: %v", operation.Op, operation.Name, err)
+		}
+	}
+	return nil
+}
+
+func renameHeader(headers headerAccessor, from, to string) error {
+	all, err := headers.get()
+	if err != nil {
+		return err
+	}
+	var values []string
+	for _, header := range all {
+		if strings.EqualFold(header[0], from) {
+			values = append(values, header[1])
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	if err = headers.remove(from); err != nil {
+		return err
+	}
+	if err = headers.remove(to); err != nil {
+		return err
+	}
+	for _, value := range values {
+		if err = headers.add(to, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// headerTransforms are the _headers_ of the global config and the rules, the ones of a rule are executed after the
+// global ones.
+type headerTransforms struct {
+	global *HeaderTransform
+	rules  []*HeaderTransform
+}
+
+func parseHeaderTransforms(config gjson.Result) (*headerTransforms, error) {
+	global := &HeaderTransform{}
+	if json := config.Get(matcher.HEADERS_KEY); json.Exists() {
+		var err error
+		if global, err = ParseHeaderTransform(json); err != nil {
+			return nil, err
+		}
+	}
+	transforms := &headerTransforms{global: global}
+	ruleHeaders := false
+	for i, rule := range config.Get(matcher.RULES_KEY).Array() {
+		json := rule.Get(matcher.HEADERS_KEY)
+		if !json.Exists() {
+			transforms.rules = append(transforms.rules, global)
+			continue
+		}
+		transform, err := ParseHeaderTransform(json)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i, err)
+		}
+		// the layout of the rule replaces the global one
+		if transform.Layout == nil {
+			transform.Layout = global.Layout
+		}
+		transforms.rules = append(transforms.rules, &HeaderTransform{
+			Request:  append(append([]HeaderOperation{}, global.Request...), transform.Request...),
+			Response: append(append([]HeaderOperation{}, global.Response...), transform.Response...),
+			Layout:   transform.Layout,
+		})
+		ruleHeaders = true
+	}
+	if !ruleHeaders && len(global.Request) == 0 && len(global.Response) == 0 && global.Layout == nil {
+		return nil, nil
+	}
+	return transforms, nil
+}
+
+func (t *headerTransforms) rule(index int) *HeaderTransform {
+	if index >= 0 && index < len(t.rules) {
+		return t.rules[index]
+	}
+	return t.global
+}
+
+// RequestTemplateSources adds the request, attribute and context sources to the TemplateSources of the request.
+func RequestTemplateSources(ctx HttpContext) TemplateSources {
+	sources := GetTemplateSources(ctx)
+	sources[TemplateSourceRequest] = func(name string) (string, bool) {
+		switch name {
+		case "scheme":
+			return ctx.Scheme(), true
+		case "host":
+			return ctx.Host(), true
+		case "path":
+			return ctx.Path(), true
+		case "method":
+			return ctx.Method(), true
+		}
+		return "", false
+	}
+	sources[TemplateSourceAttribute] = func(name string) (string, bool) {
+		value := ctx.GetUserAttribute(name)
+		if value == nil {
+			return "", false
+		}
+		return fmt.Sprint(value), true
+	}
+	sources[TemplateSourceContext] = func(name string) (string, bool) {
+		value, ok := ctx.GetContext(name).(string)
+		return value, ok
+	}
+	return sources
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) transformRequestHeaders() {
+	if ctx.headerTransform == nil || (len(ctx.headerTransform.Request) == 0 && ctx.headerTransform.Layout == nil) {
+		return
+	}
+	if err := ctx.headerTransform.ApplyRequest(RequestTemplateSources(ctx)); err != nil {
+		ctx.plugin.vm.log.Warnf("transform request headers failed: %v", err)
+		DebugDecision(ctx, "transform request headers failed: %v", err)
+	}
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) transformResponseHeaders() {
+	if ctx.headerTransform == nil || len(ctx.headerTransform.Response) == 0 {
+		return
+	}
+	sources := RequestTemplateSources(ctx)
+	sources[TemplateSourceResponse] = func(name string) (string, bool) {
+		value, err := proxywasm.GetHttpResponseHeader(name)
+		return value, err == nil
+	}
+	if err := ctx.headerTransform.ApplyResponse(sources); err != nil {
+		ctx.plugin.vm.log.Warnf("transform response headers failed: %v", err)
+		DebugDecision(ctx, "transform response headers failed: %v", err)
+	}
+}
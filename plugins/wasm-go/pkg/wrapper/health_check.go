@@ -0,0 +1,195 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	healthCheckStatusKeyPrefix = "health-check-status:"
+	healthCheckLeaseKeyPrefix  = "health-check-lease:"
+)
+
+type HealthCheckConfig struct {
+	// Name identifies the endpoint, it is also the key to query the health status
+	Name    string
+	Cluster Cluster
+	// The path of the probe request, default is "/"
+	Path string
+	// The method of the probe request, default is GET
+	Method  string
+	Headers [][2]string
+	// The probe interval in milliseconds, default is 5000ms
+	Interval int64
+	// The probe timeout in milliseconds, default is 1000ms
+	Timeout uint32
+	// Consecutive successes needed to mark an unhealthy endpoint healthy, default is 1
+	HealthyThreshold int
+	// Consecutive failures needed to mark a healthy endpoint unhealthy, default is 3
+	UnhealthyThreshold int
+	// The status codes treated as success, any 2xx or 3xx status by default
+	ExpectedStatuses []int
+}
+
+// ParseHealthCheckConfig parses the common probe settings, the Name and Cluster should be set by the plugin.
+func ParseHealthCheckConfig(json gjson.Result) HealthCheckConfig {
+	config := HealthCheckConfig{
+		Path:               json.Get("path").String(),
+		Method:             json.Get("method").String(),
+		Interval:           json.Get("interval").Int(),
+		Timeout:            uint32(json.Get("timeout").Uint()),
+		HealthyThreshold:   int(json.Get("healthyThreshold").Int()),
+		UnhealthyThreshold: int(json.Get("unhealthyThreshold").Int()),
+	}
+	for _, status := range json.Get("expectedStatuses").Array() {
+		config.ExpectedStatuses = append(config.ExpectedStatuses, int(status.Int()))
+	}
+	for key, value := range json.Get("headers").Map() {
+		config.Headers = append(config.Headers, [2]string{key, value.String()})
+	}
+	return config
+}
+
+type HealthStatus struct {
+	Healthy              bool  `json:"healthy"`
+	ConsecutiveSuccesses int   `json:"consecutiveSuccesses"`
+	ConsecutiveFailures  int   `json:"consecutiveFailures"`
+	LastStatusCode       int   `json:"lastStatusCode"`
+	LastCheckTime        int64 `json:"lastCheckTime"`
+}
+
+// observe updates the status with the result of one probe and returns whether the health changed.
+func (s *HealthStatus) observe(success bool, statusCode int, config HealthCheckConfig) bool {
+	s.LastStatusCode = statusCode
+	s.LastCheckTime = time.Now().UnixMilli()
+	if success {
+		s.ConsecutiveSuccesses++
+		s.ConsecutiveFailures = 0
+		if !s.Healthy && s.ConsecutiveSuccesses >= config.HealthyThreshold {
+			s.Healthy = true
+			return true
+		}
+		return false
+	}
+	s.ConsecutiveFailures++
+	s.ConsecutiveSuccesses = 0
+	if s.Healthy && s.ConsecutiveFailures >= config.UnhealthyThreshold {
+		s.Healthy = false
+		return true
+	}
+	return false
+}
+
+func (c HealthCheckConfig) isExpectedStatus(statusCode int) bool {
+	if len(c.ExpectedStatuses) == 0 {
+		return statusCode >= 200 && statusCode < 400
+	}
+	for _, status := range c.ExpectedStatuses {
+		if status == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterHealthCheck probes the endpoint periodically, only one of the VMs sharing the data performs the probe,
+// and the result is stored in shared data so it can be queried by GetEndpointHealth in any VM.
+//
+// You should call this function in parseConfig phase, endpoints are treated as healthy until proven otherwise.
+func RegisterHealthCheck(config HealthCheckConfig) error {
+	if config.Name == "" {
+		return errors.New("health check name is empty")
+	}
+	if config.Cluster == nil {
+		return errors.New("health check cluster is empty")
+	}
+	if config.Path == "" {
+		config.Path = "/"
+	}
+	if config.Method == "" {
+		config.Method = http.MethodGet
+	}
+	if config.Interval <= 0 {
+		config.Interval = 5000
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 1000
+	}
+	if config.HealthyThreshold <= 0 {
+		config.HealthyThreshold = 1
+	}
+	if config.UnhealthyThreshold <= 0 {
+		config.UnhealthyThreshold = 3
+	}
+	leaseTTL := 3 * time.Duration(config.Interval) * time.Millisecond
+	RegisteTickFunc(config.Interval, func() {
+		if !TryAcquireSharedLease(healthCheckLeaseKeyPrefix+config.Name, leaseTTL) {
+			return
+		}
+		probeEndpoint(config)
+	})
+	return nil
+}
+
+func probeEndpoint(config HealthCheckConfig) {
+	headers := make([][2]string, len(config.Headers))
+	copy(headers, config.Headers)
+	err := HttpCall(config.Cluster, config.Method, config.Path, headers, nil,
+		func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+			recordProbeResult(config, config.isExpectedStatus(statusCode), statusCode)
+		}, config.Timeout)
+	if err != nil {
+		proxywasm.LogWarnf("failed to dispatch health check to %s: %v", config.Name, err)
+		recordProbeResult(config, false, 0)
+	}
+}
+
+func recordProbeResult(config HealthCheckConfig, success bool, statusCode int) {
+	err := UpdateSharedDataJson(healthCheckStatusKeyPrefix+config.Name, func(status *HealthStatus) bool {
+		if status.LastCheckTime == 0 {
+			status.Healthy = true
+		}
+		if status.observe(success, statusCode, config) {
+			proxywasm.LogInfof("health of endpoint %s changed to %t, last status code: %d", config.Name, status.Healthy, statusCode)
+		}
+		return true
+	})
+	if err != nil {
+		proxywasm.LogErrorf("failed to record health check result of %s: %v", config.Name, err)
+	}
+}
+
+// GetEndpointHealth returns the latest health status of the endpoint, ok is false if it has not been probed yet.
+func GetEndpointHealth(name string) (status HealthStatus, ok bool) {
+	if _, err := GetSharedDataJson(healthCheckStatusKeyPrefix+name, &status); err != nil {
+		proxywasm.LogErrorf("failed to get health status of %s: %v", name, err)
+		return status, false
+	}
+	return status, status.LastCheckTime != 0
+}
+
+// ActiveHealthChecker exposes the results of RegisterHealthCheck as EndpointHealth, e.g. for FailoverClient.
+type ActiveHealthChecker struct{}
+
+func (ActiveHealthChecker) IsHealthy(endpoint string) bool {
+	status, ok := GetEndpointHealth(endpoint)
+	return !ok || status.Healthy
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestHealthStatusObserve(t *testing.T) {
+	config := HealthCheckConfig{HealthyThreshold: 2, UnhealthyThreshold: 2}
+	status := HealthStatus{Healthy: true}
+
+	assert.False(t, status.observe(false, 503, config))
+	assert.True(t, status.Healthy)
+	assert.True(t, status.observe(false, 503, config))
+	assert.False(t, status.Healthy)
+
+	assert.False(t, status.observe(true, 200, config))
+	assert.False(t, status.Healthy)
+	assert.True(t, status.observe(true, 200, config))
+	assert.True(t, status.Healthy)
+	assert.Equal(t, 200, status.LastStatusCode)
+}
+
+func TestHealthCheckExpectedStatus(t *testing.T) {
+	config := ParseHealthCheckConfig(gjson.Parse(`{"path":"/health","expectedStatuses":[200,204]}`))
+	assert.Equal(t, "/health", config.Path)
+	assert.True(t, config.isExpectedStatus(204))
+	assert.False(t, config.isExpectedStatus(302))
+	assert.True(t, HealthCheckConfig{}.isExpectedStatus(302))
+	assert.False(t, HealthCheckConfig{}.isExpectedStatus(500))
+}
@@ -0,0 +1,356 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/gjson"
+)
+
+var (
+	ErrHmacSignatureMissing  = errors.New("hmac signature is missing")
+	ErrHmacKeyNotFound       = errors.New("hmac access key is not found")
+	ErrHmacTimestampInvalid  = errors.New("hmac timestamp is out of the allowed window")
+	ErrHmacNonceReplayed     = errors.New("hmac nonce has been used")
+	ErrHmacSignatureMismatch = errors.New("hmac signature does not match")
+	ErrHmacBodyDigestInvalid = errors.New("hmac body digest does not match")
+)
+
+type HmacSignatureConfig struct {
+	// Headers always covered by the signature, in addition to the ones listed by the client
+	SignedHeaders []string
+	// The header names of the signature parts, the x-ca-* headers by default
+	KeyHeader           string
+	SignatureHeader     string
+	SignedHeadersHeader string
+	TimestampHeader     string
+	NonceHeader         string
+	// The allowed difference between the client timestamp (unix milliseconds) and now, default is 900000ms
+	DateOffset int64
+	// Require the content-md5 header to match the request body
+	ValidateBody bool
+}
+
+func ParseHmacSignatureConfig(json gjson.Result) HmacSignatureConfig {
+	config := HmacSignatureConfig{
+		KeyHeader:           json.Get("keyHeader").String(),
+		SignatureHeader:     json.Get("signatureHeader").String(),
+		SignedHeadersHeader: json.Get("signedHeadersHeader").String(),
+		TimestampHeader:     json.Get("timestampHeader").String(),
+		NonceHeader:         json.Get("nonceHeader").String(),
+		DateOffset:          json.Get("dateOffset").Int(),
+		ValidateBody:        json.Get("validateBody").Bool(),
+	}
+	for _, header := range json.Get("signedHeaders").Array() {
+		config.SignedHeaders = append(config.SignedHeaders, strings.ToLower(header.String()))
+	}
+	return config
+}
+
+func (c *HmacSignatureConfig) setDefaults() {
+	if c.KeyHeader == "" {
+		c.KeyHeader = "x-ca-key"
+	}
+	if c.SignatureHeader == "" {
+		c.SignatureHeader = "x-ca-signature"
+	}
+	if c.SignedHeadersHeader == "" {
+		c.SignedHeadersHeader = "x-ca-signature-headers"
+	}
+	if c.TimestampHeader == "" {
+		c.TimestampHeader = "x-ca-timestamp"
+	}
+	if c.NonceHeader == "" {
+		c.NonceHeader = "x-ca-nonce"
+	}
+	if c.DateOffset <= 0 {
+		c.DateOffset = 900000
+	}
+}
+
+type HmacRequest struct {
+	Method string
+	// The path including the query string
+	Path    string
+	Headers [][2]string
+	Body    []byte
+}
+
+func (r HmacRequest) header(name string) string {
+	for _, h := range r.Headers {
+		if strings.EqualFold(h[0], name) {
+			return h[1]
+		}
+	}
+	return ""
+}
+
+// HmacStringToSign builds the canonical request:
+//
+//	METHOD\nAccept\nContent-MD5\nContent-Type\nDate\n
+//	name:value\n for each signed header, sorted by lowercase name
+//	path?query with every parameter as sent, sorted by name then value
+func HmacStringToSign(req HmacRequest, signedHeaders []string) string {
+	var sb strings.Builder
+	sb.WriteString(strings.ToUpper(req.Method))
+	sb.WriteByte('\n')
+	for _, name := range []string{"accept", "content-md5", "content-type", "date"} {
+		sb.WriteString(req.header(name))
+		sb.WriteByte('\n')
+	}
+	names := make([]string, 0, len(signedHeaders))
+	for _, name := range signedHeaders {
+		names = append(names, strings.ToLower(strings.TrimSpace(name)))
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if name == "" || (i > 0 && names[i-1] == name) {
+			continue
+		}
+		sb.WriteString(name)
+		sb.WriteByte(':')
+		sb.WriteString(strings.TrimSpace(req.header(name)))
+		sb.WriteByte('\n')
+	}
+	sb.WriteString(canonicalPathAndQuery(req.Path))
+	return sb.String()
+}
+
+// canonicalPathAndQuery keeps every value of a repeated parameter, otherwise a parameter appended to a signed
+// request would not be covered by the signature. The parameters are not decoded, they are signed as sent.
+func canonicalPathAndQuery(rawPath string) string {
+	path, rawQuery, found := strings.Cut(rawPath, "?")
+	if !found || rawQuery == "" {
+		return path
+	}
+	type param struct{ key, value string }
+	var params []param
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		params = append(params, param{key, value})
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i].key != params[j].key {
+			return params[i].key < params[j].key
+		}
+		return params[i].value < params[j].value
+	})
+	pairs := make([]string, 0, len(params))
+	for _, p := range params {
+		if p.value == "" {
+			pairs = append(pairs, p.key)
+		} else {
+			pairs = append(pairs, p.key+"="+p.value)
+		}
+	}
+	return path + "?" + strings.Join(pairs, "&")
+}
+
+// HmacSha256Sign returns the base64 encoded HMAC-SHA256 of stringToSign.
+func HmacSha256Sign(secret, stringToSign string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ContentMD5 returns the value of the content-md5 header for body.
+func ContentMD5(body []byte) string {
+	sum := md5.Sum(body)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// NonceCache remembers the nonces seen recently to reject replayed requests.
+type NonceCache interface {
+	// CheckAndStore returns false if the nonce has been seen within the window
+	CheckAndStore(nonce string) bool
+}
+
+type HmacVerifier struct {
+	config HmacSignatureConfig
+	nonces NonceCache
+}
+
+// NewHmacVerifier creates a verifier, nonces are not checked if the cache is nil.
+func NewHmacVerifier(config HmacSignatureConfig, nonces NonceCache) *HmacVerifier {
+	config.setDefaults()
+	return &HmacVerifier{config: config, nonces: nonces}
+}
+
+// Verify checks the signature of req, secretOf looks up the secret of the access key sent by the client.
+// The access key is returned on success so that the caller can resolve the consumer.
+func (v *HmacVerifier) Verify(req HmacRequest, secretOf func(key string) (string, bool)) (string, error) {
+	signature := req.header(v.config.SignatureHeader)
+	key := req.header(v.config.KeyHeader)
+	if signature == "" || key == "" {
+		return "", ErrHmacSignatureMissing
+	}
+	secret, ok := secretOf(key)
+	if !ok {
+		return key, ErrHmacKeyNotFound
+	}
+	timestamp := req.header(v.config.TimestampHeader)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return key, fmt.Errorf("%w: %q", ErrHmacTimestampInvalid, timestamp)
+	}
+	diff := time.Now().UnixMilli() - ts
+	if diff > v.config.DateOffset || diff < -v.config.DateOffset {
+		return key, ErrHmacTimestampInvalid
+	}
+	if v.config.ValidateBody && len(req.Body) > 0 && req.header("content-md5") != ContentMD5(req.Body) {
+		return key, ErrHmacBodyDigestInvalid
+	}
+	// the timestamp and the nonce are always signed, otherwise a captured request could be replayed with fresh ones
+	signedHeaders := append([]string{}, v.config.SignedHeaders...)
+	signedHeaders = append(signedHeaders, v.config.TimestampHeader)
+	if v.nonces != nil {
+		signedHeaders = append(signedHeaders, v.config.NonceHeader)
+	}
+	for _, name := range strings.Split(req.header(v.config.SignedHeadersHeader), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && name != strings.ToLower(v.config.SignatureHeader) && name != strings.ToLower(v.config.SignedHeadersHeader) {
+			signedHeaders = append(signedHeaders, name)
+		}
+	}
+	expected := HmacSha256Sign(secret, HmacStringToSign(req, signedHeaders))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return key, ErrHmacSignatureMismatch
+	}
+	// the nonce is only recorded for authentic requests, so forged requests can not burn it
+	if v.nonces != nil {
+		nonce := req.header(v.config.NonceHeader)
+		if nonce == "" || !v.nonces.CheckAndStore(key+":"+nonce) {
+			return key, ErrHmacNonceReplayed
+		}
+	}
+	return key, nil
+}
+
+// DefaultNonceCacheCapacity holds the nonces of about 145 requests per second in the default 900s window.
+const DefaultNonceCacheCapacity = 1 << 18
+
+const nonceCacheProbes = 16
+
+// NonceCacheCapacity returns the capacity for the peak rate of the requests carrying a nonce and the window in
+// milliseconds. It's twice the number of the nonces in the window, so a nonce rarely finds all of its slots taken.
+func NonceCacheCapacity(requestsPerSecond int64, window int64) uint32 {
+	capacity := 2 * requestsPerSecond * window / 1000
+	if capacity < nonceCacheProbes {
+		return nonceCacheProbes
+	}
+	if capacity > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(capacity)
+}
+
+// SharedDataNonceCache keeps every nonce in a key of its own in shared data, so a check only reads
+// a few small values. The keys are slots of a fixed size table addressed by the hash of the nonce,
+// and a slot is reused once its nonce expires, so the memory is bounded no matter how long the VM runs.
+// The slots are only written when taken, an idle table takes no memory.
+//
+// A nonce may take one of the 16 slots following its hash. Once the nonces in the window approach half
+// of the capacity, some nonces find all of their slots taken, and the cache saturates: CheckAndStore
+// fails closed and rejects them as replayed, logging that the cache is full, until the older nonces
+// expire. Size the capacity with NonceCacheCapacity.
+type SharedDataNonceCache struct {
+	name     string
+	window   int64
+	capacity uint32
+}
+
+// NewSharedDataNonceCache remembers nonces for at least window milliseconds, which should cover
+// the allowed timestamp offset. The capacity is DefaultNonceCacheCapacity.
+func NewSharedDataNonceCache(name string, window int64) *SharedDataNonceCache {
+	return NewSharedDataNonceCacheWithCapacity(name, window, DefaultNonceCacheCapacity)
+}
+
+// NewSharedDataNonceCacheWithCapacity is NewSharedDataNonceCache with the number of the slots.
+func NewSharedDataNonceCacheWithCapacity(name string, window int64, capacity uint32) *SharedDataNonceCache {
+	if capacity == 0 {
+		capacity = DefaultNonceCacheCapacity
+	}
+	return &SharedDataNonceCache{name: name, window: window, capacity: capacity}
+}
+
+func (c *SharedDataNonceCache) slotKey(slot uint32) string {
+	return fmt.Sprintf("nonce:%s:%d", c.name, slot)
+}
+
+// CheckAndStore fails closed: the nonce is rejected if the shared data can not be read or
+// all the slots it may take are held by nonces still in the window.
+func (c *SharedDataNonceCache) CheckAndStore(nonce string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(nonce))
+	start := h.Sum32() % c.capacity
+	probes := uint32(nonceCacheProbes)
+	if probes > c.capacity {
+		probes = c.capacity
+	}
+	for attempt := 0; attempt < sharedDataCasMaxRetries; attempt++ {
+		now := time.Now().UnixMilli()
+		free, freeCas, found := "", uint32(0), false
+		for i := uint32(0); i < probes; i++ {
+			key := c.slotKey((start + i) % c.capacity)
+			data, cas, err := proxywasm.GetSharedData(key)
+			if err != nil && !errors.Is(err, types.ErrorStatusNotFound) {
+				proxywasm.LogErrorf("failed to get nonce slot: %v", err)
+				return false
+			}
+			expiry, stored, _ := strings.Cut(string(data), ":")
+			if expiresAt, _ := strconv.ParseInt(expiry, 10, 64); expiresAt > now {
+				if stored == nonce {
+					return false
+				}
+				continue
+			}
+			if !found {
+				free, freeCas, found = key, cas, true
+			}
+		}
+		if !found {
+			proxywasm.LogWarnf("nonce cache %s is full", c.name)
+			return false
+		}
+		value := strconv.FormatInt(now+c.window, 10) + ":" + nonce
+		err := proxywasm.SetSharedData(free, []byte(value), freeCas)
+		if err == nil {
+			return true
+		}
+		if !errors.Is(err, types.ErrorStatusCasMismatch) {
+			proxywasm.LogErrorf("failed to store nonce: %v", err)
+			return false
+		}
+	}
+	proxywasm.LogErrorf("failed to store nonce: too many cas mismatches")
+	return false
+}
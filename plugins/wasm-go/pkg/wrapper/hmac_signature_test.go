@@ -0,0 +1,113 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHmacStringToSign(t *testing.T) {
+	req := HmacRequest{
+		Method: "post",
+		Path:   "/api/v1?b=2&a=1&c&a=%2F0",
+		Headers: [][2]string{
+			{"Accept", "application/json"},
+			{"Content-Type", "application/json"},
+			{"X-Ca-Key", "key"},
+			{"X-Ca-Timestamp", "1700000000000"},
+		},
+	}
+	expected := "POST\napplication/json\n\napplication/json\n\n" +
+		"x-ca-key:key\nx-ca-timestamp:1700000000000\n" +
+		"/api/v1?a=%2F0&a=1&b=2&c"
+	assert.Equal(t, expected, HmacStringToSign(req, []string{"X-Ca-Timestamp", "x-ca-key", "x-ca-key"}))
+}
+
+func TestHmacVerify(t *testing.T) {
+	secrets := map[string]string{"key": "secret"}
+	secretOf := func(key string) (string, bool) {
+		s, ok := secrets[key]
+		return s, ok
+	}
+	verifier := NewHmacVerifier(HmacSignatureConfig{ValidateBody: true}, nil)
+	sign := func(key, secret string, ts int64, body string) HmacRequest {
+		req := HmacRequest{
+			Method: "POST",
+			Path:   "/foo?a=1&b=2",
+			Headers: [][2]string{
+				{"x-ca-key", key},
+				{"x-ca-timestamp", strconv.FormatInt(ts, 10)},
+				{"x-ca-signature-headers", "x-ca-key,x-ca-timestamp"},
+				{"content-md5", ContentMD5([]byte("body"))},
+			},
+			Body: []byte(body),
+		}
+		signature := HmacSha256Sign(secret, HmacStringToSign(req, []string{"x-ca-key", "x-ca-timestamp"}))
+		req.Headers = append(req.Headers, [2]string{"x-ca-signature", signature})
+		return req
+	}
+	now := time.Now().UnixMilli()
+	// the client leaves the timestamp out of the signed headers, a replay with a fresh one must not verify
+	unsignedTimestamp := HmacRequest{Method: "POST", Path: "/foo", Headers: [][2]string{
+		{"x-ca-key", "key"},
+		{"x-ca-timestamp", strconv.FormatInt(now, 10)},
+		{"x-ca-signature-headers", "x-ca-key"},
+	}}
+	unsignedTimestamp.Headers = append(unsignedTimestamp.Headers,
+		[2]string{"x-ca-signature", HmacSha256Sign("secret", HmacStringToSign(unsignedTimestamp, []string{"x-ca-key"}))})
+	cases := []struct {
+		name   string
+		req    HmacRequest
+		expect error
+	}{
+		{"valid", sign("key", "secret", now, "body"), nil},
+		{"missing", HmacRequest{Method: "GET", Path: "/"}, ErrHmacSignatureMissing},
+		{"unknown key", sign("other", "secret", now, "body"), ErrHmacKeyNotFound},
+		{"wrong secret", sign("key", "wrong", now, "body"), ErrHmacSignatureMismatch},
+		{"expired", sign("key", "secret", now-1000000, "body"), ErrHmacTimestampInvalid},
+		{"tampered body", sign("key", "secret", now, "tampered"), ErrHmacBodyDigestInvalid},
+		{"missing timestamp", withoutHeader(sign("key", "secret", now, "body"), "x-ca-timestamp"), ErrHmacTimestampInvalid},
+		{"unsigned timestamp", unsignedTimestamp, ErrHmacSignatureMismatch},
+		{"reordered parameters", withPath(sign("key", "secret", now, "body"), "/foo?b=2&a=1"), nil},
+		{"duplicated parameter", withPath(sign("key", "secret", now, "body"), "/foo?a=1&b=2&a=evil"), ErrHmacSignatureMismatch},
+		{"encoded parameter", withPath(sign("key", "secret", now, "body"), "/foo?a=%31&b=2"), ErrHmacSignatureMismatch},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := verifier.Verify(c.req, secretOf)
+			assert.ErrorIs(t, err, c.expect)
+		})
+	}
+}
+
+func withoutHeader(req HmacRequest, name string) HmacRequest {
+	var headers [][2]string
+	for _, h := range req.Headers {
+		if h[0] != name {
+			headers = append(headers, h)
+		}
+	}
+	req.Headers = headers
+	return req
+}
+
+func withPath(req HmacRequest, path string) HmacRequest {
+	req.Path = path
+	return req
+}
@@ -106,6 +106,14 @@ func HttpCall(cluster Cluster, method, rawURL string, headers [][2]string, body
 	if len(timeoutMillisecond) > 0 {
 		timeout = timeoutMillisecond[0]
 	}
+	// the callout should not outlive the request it serves
+	timeout, err = capTimeout(timeout)
+	if err != nil {
+		proxywasm.LogWarnf("http call to %s is not dispatched: %v", cluster.ClusterName(), err)
+		return err
+	}
+	deadline := activeDeadline
+	headers = setGrpcTimeout(headers, timeout)
 	headers = append(headers, [2]string{":method", method}, [2]string{":path", path}, [2]string{":authority", authority})
 	requestID := uuid.New().String()
 	_, err = proxywasm.DispatchHttpCall(cluster.ClusterName(), headers, body, nil, timeout, func(numHeaders, bodySize, numTrailers int) {
@@ -134,6 +142,7 @@ func HttpCall(cluster Cluster, method, rawURL string, headers [][2]string, body
 		}
 		proxywasm.LogDebugf("http call end, id: %s, code: %d, normal: %t, body: %s",
 			requestID, code, normalResponse, respBody)
+		defer withDeadline(deadline)()
 		callback(code, headers, respBody)
 	})
 	proxywasm.LogDebugf("http call start, id: %s, cluster: %s, method: %s, url: %s, body: %s, timeout: %d",
@@ -0,0 +1,183 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+// TemplateSourceMessage has the messages of the catalog in the locale of the request, e.g. {{message.blocked}}.
+const TemplateSourceMessage = "message"
+
+// LanguageRange is an item of the Accept-Language header.
+type LanguageRange struct {
+	Tag string
+	Q   float64
+}
+
+// ParseAcceptLanguage parses the Accept-Language header into the ranges by the descending quality, the ones with
+// q=0 or an invalid q are dropped, and the ranges with the same quality keep their order.
+func ParseAcceptLanguage(header string) []LanguageRange {
+	var ranges []LanguageRange
+	for _, item := range strings.Split(header, ",") {
+		parts := strings.Split(item, ";")
+		tag := strings.TrimSpace(parts[0])
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range parts[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.TrimSpace(name) != "q" {
+				continue
+			}
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil || q < 0 || q > 1 {
+				q = 0
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, LanguageRange{Tag: tag, Q: q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].Q > ranges[j].Q })
+	return ranges
+}
+
+// MessageCatalog has the messages of the locales, e.g. the block or limit messages of a plugin:
+//
+//	{
+//	  "defaultLocale": "en",
+//	  "messages": {
+//	    "en": {"blocked": "Your request is blocked"},
+//	    "zh-CN": {"blocked": "请求已被拦截"}
+//	  }
+//	}
+//
+// A message missing in a locale falls back to the parent locale, e.g. zh for zh-CN, then to the default locale.
+type MessageCatalog struct {
+	DefaultLocale string
+	// the lower case tags of the locales in the order of the config
+	locales []string
+	// the messages and the configured tag by the lower case tag
+	messages map[string]map[string]string
+	tags     map[string]string
+}
+
+func ParseMessageCatalog(json gjson.Result) (*MessageCatalog, error) {
+	c := &MessageCatalog{
+		DefaultLocale: json.Get("defaultLocale").String(),
+		messages:      map[string]map[string]string{},
+		tags:          map[string]string{},
+	}
+	var err error
+	json.Get("messages").ForEach(func(locale, messages gjson.Result) bool {
+		if !messages.IsObject() {
+			err = fmt.Errorf("messages of locale %s should be an object", locale.String())
+			return false
+		}
+		key := strings.ToLower(locale.String())
+		if _, ok := c.messages[key]; ok {
+			err = fmt.Errorf("duplicate locale %s", locale.String())
+			return false
+		}
+		c.locales = append(c.locales, key)
+		c.tags[key] = locale.String()
+		c.messages[key] = map[string]string{}
+		for name, message := range messages.Map() {
+			c.messages[key][name] = message.String()
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(c.locales) == 0 {
+		return nil, errors.New("messages of the catalog are empty")
+	}
+	if c.DefaultLocale == "" {
+		c.DefaultLocale = c.tags[c.locales[0]]
+	}
+	if _, ok := c.messages[strings.ToLower(c.DefaultLocale)]; !ok {
+		return nil, fmt.Errorf("default locale %s has no messages", c.DefaultLocale)
+	}
+	return c, nil
+}
+
+// Negotiate returns the locale of the catalog best matching the Accept-Language header, or the default locale. A
+// range matches the locale of the same tag, then the locales of its parent tags, e.g. zh for zh-Hant-TW, and then the
+// other locales of the language, e.g. pt-BR for pt or pt-PT.
+func (c *MessageCatalog) Negotiate(acceptLanguage string) string {
+	for _, r := range ParseAcceptLanguage(acceptLanguage) {
+		tag := strings.ToLower(r.Tag)
+		if tag == "*" {
+			break
+		}
+		for lookup := tag; lookup != ""; {
+			if _, ok := c.messages[lookup]; ok {
+				return c.tags[lookup]
+			}
+			i := strings.LastIndexByte(lookup, '-')
+			if i < 0 {
+				break
+			}
+			lookup = lookup[:i]
+		}
+		language, _, _ := strings.Cut(tag, "-")
+		for _, locale := range c.locales {
+			if strings.HasPrefix(locale, language+"-") {
+				return c.tags[locale]
+			}
+		}
+	}
+	return c.DefaultLocale
+}
+
+// Message returns the message in the locale, falling back to the parent locales and the default locale.
+func (c *MessageCatalog) Message(locale, name string) (string, bool) {
+	for lookup := strings.ToLower(locale); lookup != ""; {
+		if message, ok := c.messages[lookup][name]; ok {
+			return message, true
+		}
+		i := strings.LastIndexByte(lookup, '-')
+		if i < 0 {
+			break
+		}
+		lookup = lookup[:i]
+	}
+	message, ok := c.messages[strings.ToLower(c.DefaultLocale)][name]
+	return message, ok
+}
+
+// RequestLocale negotiates the locale by the Accept-Language header of the current request.
+func (c *MessageCatalog) RequestLocale() string {
+	acceptLanguage, _ := proxywasm.GetHttpRequestHeader("accept-language")
+	return c.Negotiate(acceptLanguage)
+}
+
+// TemplateSource returns the source of the messages in the locale, to be added to the template sources as
+// TemplateSourceMessage.
+func (c *MessageCatalog) TemplateSource(locale string) TemplateSource {
+	return func(name string) (string, bool) {
+		return c.Message(locale, name)
+	}
+}
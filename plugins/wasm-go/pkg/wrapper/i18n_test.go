@@ -0,0 +1,84 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	assert.Equal(t, []LanguageRange{
+		{Tag: "fr-CH", Q: 1},
+		{Tag: "fr", Q: 0.9},
+		{Tag: "en", Q: 0.7},
+		{Tag: "de", Q: 0.7},
+		{Tag: "*", Q: 0.5},
+	}, ParseAcceptLanguage("fr-CH, en;q=0.7, fr;q=0.9, de;q=0.7, *;q=0.5, ja;q=0, ko;q=abc, ,"))
+	assert.Empty(t, ParseAcceptLanguage(""))
+}
+
+func TestMessageCatalog(t *testing.T) {
+	catalog, err := ParseMessageCatalog(gjson.Parse(`{
+		"defaultLocale": "en",
+		"messages": {
+			"en": {"blocked": "Blocked", "limited": "Too many requests"},
+			"zh": {"blocked": "已拦截"},
+			"zh-TW": {"blocked": "已攔截"},
+			"pt-BR": {"blocked": "Bloqueado"}
+		}
+	}`))
+	require.NoError(t, err)
+	for header, expected := range map[string]string{
+		"":                      "en",
+		"zh-CN,zh;q=0.9":        "zh",
+		"zh-tw":                 "zh-TW",
+		"zh-Hant-TW":            "zh",
+		"pt":                    "pt-BR",
+		"ja, pt-PT;q=0.8":       "pt-BR",
+		"ja, *;q=0.5, zh;q=0.1": "en",
+		"de":                    "en",
+	} {
+		assert.Equal(t, expected, catalog.Negotiate(header), header)
+	}
+
+	message, ok := catalog.Message("zh-TW", "blocked")
+	assert.True(t, ok)
+	assert.Equal(t, "已攔截", message)
+	message, _ = catalog.Message("zh-CN", "blocked")
+	assert.Equal(t, "已拦截", message)
+	message, _ = catalog.Message("zh-TW", "limited")
+	assert.Equal(t, "Too many requests", message)
+	_, ok = catalog.Message("zh", "missing")
+	assert.False(t, ok)
+
+	// the first locale is the default
+	catalog, err = ParseMessageCatalog(gjson.Parse(`{"messages": {"fr": {"blocked": "Bloqué"}, "en": {}}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "fr", catalog.DefaultLocale)
+
+	for _, config := range []string{
+		`{}`,
+		`{"messages": {"en": "Blocked"}}`,
+		`{"defaultLocale": "de", "messages": {"en": {}}}`,
+		`{"messages": {"en": {}, "EN": {}}}`,
+	} {
+		_, err := ParseMessageCatalog(gjson.Parse(config))
+		assert.Error(t, err, config)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/fnv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+)
+
+const RequestIDHeader = "x-request-id"
+
+// RandomBytes reads n bytes from the crypto random source, which is backed by the wasi random_get in the Wasm VM.
+func RandomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// RandomHex returns 2n hex characters generated from n random bytes.
+func RandomHex(n int) (string, error) {
+	b, err := RandomBytes(n)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func NewUUIDv4() string {
+	return uuid.New().String()
+}
+
+// NewUUIDv7 returns a time ordered UUID as defined in RFC 9562, the first 48 bits are the unix milliseconds of now.
+func NewUUIDv7(now time.Time) (string, error) {
+	var id uuid.UUID
+	if _, err := rand.Read(id[6:]); err != nil {
+		return "", err
+	}
+	ms := uint64(now.UnixMilli())
+	id[0] = byte(ms >> 40)
+	id[1] = byte(ms >> 32)
+	binary.BigEndian.PutUint32(id[2:], uint32(ms))
+	id[6] = id[6]&0x0f | 0x70
+	id[8] = id[8]&0x3f | 0x80
+	return id.String(), nil
+}
+
+const (
+	// 2024-01-01T00:00:00Z
+	SnowflakeEpoch        int64 = 1704067200000
+	snowflakeWorkerBits         = 10
+	snowflakeSequenceBits       = 12
+	SnowflakeMaxWorkerID  int64 = 1<<snowflakeWorkerBits - 1
+	snowflakeMaxSequence  int64 = 1<<snowflakeSequenceBits - 1
+)
+
+// SnowflakeGenerator generates 63 bits ids composed of 41 bits milliseconds since SnowflakeEpoch, 10 bits worker id
+// and 12 bits sequence. The generator is not shared between VMs, so each VM must use a distinct worker id.
+type SnowflakeGenerator struct {
+	workerID int64
+	lastMs   int64
+	sequence int64
+}
+
+// NewSnowflakeGenerator creates the generator, the worker id is truncated to 10 bits.
+func NewSnowflakeGenerator(workerID int64) *SnowflakeGenerator {
+	return &SnowflakeGenerator{workerID: workerID & SnowflakeMaxWorkerID}
+}
+
+// NewNodeSnowflakeGenerator creates the generator with the worker id derived from the node metadata and the VM id.
+func NewNodeSnowflakeGenerator() *SnowflakeGenerator {
+	return NewSnowflakeGenerator(SnowflakeWorkerID(getPropertyString("node", "id"), GetVMID()))
+}
+
+// SnowflakeWorkerID hashes the node id and the VM id into a worker id. As there are only 1024 worker ids, collisions
+// are possible with many gateway instances, use NewSnowflakeGenerator with an assigned worker id if uniqueness must be
+// guaranteed.
+func SnowflakeWorkerID(nodeID, vmID string) int64 {
+	h := fnv.New32a()
+	h.Write([]byte(nodeID))
+	h.Write([]byte{0})
+	h.Write([]byte(vmID))
+	return int64(h.Sum32()) & SnowflakeMaxWorkerID
+}
+
+func (g *SnowflakeGenerator) WorkerID() int64 {
+	return g.workerID
+}
+
+// Next returns the next id at now. If the clock goes backwards or the sequence of the millisecond is exhausted,
+// the last millisecond keeps being used or is advanced, so the ids are always increasing.
+func (g *SnowflakeGenerator) Next(now time.Time) int64 {
+	ms := now.UnixMilli() - SnowflakeEpoch
+	if ms > g.lastMs {
+		g.lastMs = ms
+		g.sequence = 0
+	} else if g.sequence < snowflakeMaxSequence {
+		g.sequence++
+	} else {
+		g.lastMs++
+		g.sequence = 0
+	}
+	return g.lastMs<<(snowflakeWorkerBits+snowflakeSequenceBits) | g.workerID<<snowflakeSequenceBits | g.sequence
+}
+
+func (g *SnowflakeGenerator) NextID() int64 {
+	return g.Next(time.Now())
+}
+
+// EnsureRequestID returns the x-request-id of the request, if it's absent a new UUID is generated and set in the
+// request header, so it's propagated to the upstream and the plugins running after.
+func EnsureRequestID(ctx HttpContext) string {
+	if c, ok := ctx.(httpContextExtension); ok {
+		return c.ensureRequestID()
+	}
+	return ""
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) ensureRequestID() string {
+	proxywasm.SetEffectiveContext(ctx.contextID)
+	requestID, _ := proxywasm.GetHttpRequestHeader(RequestIDHeader)
+	if requestID != "" {
+		return requestID
+	}
+	requestID = NewUUIDv4()
+	if err := proxywasm.ReplaceHttpRequestHeader(RequestIDHeader, requestID); err != nil {
+		ctx.plugin.vm.log.Warnf("failed to set request id header: %v", err)
+	}
+	_ = proxywasm.SetProperty([]string{"x_request_id"}, []byte(requestID))
+	return requestID
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewUUIDv7(t *testing.T) {
+	now := time.UnixMilli(1717171717171)
+	s, err := NewUUIDv7(now)
+	require.NoError(t, err)
+	id, err := uuid.Parse(s)
+	require.NoError(t, err)
+	assert.Equal(t, uuid.Version(7), id.Version())
+	assert.Equal(t, uuid.RFC4122, id.Variant())
+
+	later, err := NewUUIDv7(now.Add(time.Millisecond))
+	require.NoError(t, err)
+	assert.Less(t, s, later)
+}
+
+func TestSnowflakeGenerator(t *testing.T) {
+	g := NewSnowflakeGenerator(5)
+	now := time.UnixMilli(SnowflakeEpoch + 1000)
+	first := g.Next(now)
+	assert.Equal(t, int64(1000)<<22|5<<12, first)
+	assert.Equal(t, first+1, g.Next(now))
+
+	// the clock goes backwards
+	assert.Equal(t, first+2, g.Next(now.Add(-time.Second)))
+
+	// the sequence is exhausted
+	last := first
+	for i := 0; i < 5000; i++ {
+		id := g.Next(now)
+		require.Greater(t, id, last)
+		last = id
+	}
+	assert.Equal(t, int64(5), last>>12&SnowflakeMaxWorkerID)
+
+	assert.Equal(t, SnowflakeMaxWorkerID, NewSnowflakeGenerator(-1).WorkerID())
+	assert.Equal(t, SnowflakeWorkerID("node-1", "vm"), SnowflakeWorkerID("node-1", "vm"))
+	assert.LessOrEqual(t, SnowflakeWorkerID("node-2", "vm"), SnowflakeMaxWorkerID)
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type IntrospectionConfig struct {
+	Client HttpClient
+	// The url of the introspection endpoint
+	URL string
+	// Credentials used to authenticate to the endpoint with client_secret_basic
+	ClientID     string
+	ClientSecret string
+	// How long an active result is cached in milliseconds, capped by the exp of the token, default is 60000ms
+	CacheTTL int64
+	// How long an inactive result is cached in milliseconds, default is 10000ms
+	NegativeCacheTTL int64
+	// The maximum numbers of the active and the inactive results cached in the VM, the least recently used one is
+	// evicted, default is 10000 and 1000. The inactive results are kept apart, so junk tokens can't evict the valid ones.
+	MaxEntries         int
+	MaxNegativeEntries int
+	// The timeout of the introspection request in milliseconds, default is 2000ms
+	Timeout uint32
+}
+
+// IntrospectionResult is the response defined in RFC 7662.
+type IntrospectionResult struct {
+	Active    bool        `json:"active"`
+	Scope     string      `json:"scope,omitempty"`
+	ClientID  string      `json:"client_id,omitempty"`
+	Username  string      `json:"username,omitempty"`
+	TokenType string      `json:"token_type,omitempty"`
+	Exp       int64       `json:"exp,omitempty"`
+	Iat       int64       `json:"iat,omitempty"`
+	Nbf       int64       `json:"nbf,omitempty"`
+	Sub       string      `json:"sub,omitempty"`
+	Aud       interface{} `json:"aud,omitempty"`
+	Iss       string      `json:"iss,omitempty"`
+	Jti       string      `json:"jti,omitempty"`
+	// The whole response, including the extension fields
+	Raw json.RawMessage `json:"raw,omitempty"`
+}
+
+func (r IntrospectionResult) HasScope(scope string) bool {
+	for _, s := range strings.Fields(r.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type IntrospectionCallback func(result IntrospectionResult, err error)
+
+type IntrospectionClient struct {
+	config   IntrospectionConfig
+	active   *LRUCache[string, IntrospectionResult]
+	inactive *LRUCache[string, IntrospectionResult]
+}
+
+func NewIntrospectionClient(config IntrospectionConfig) (*IntrospectionClient, error) {
+	if config.Client == nil || config.URL == "" {
+		return nil, errors.New("introspection client or url is empty")
+	}
+	if config.CacheTTL <= 0 {
+		config.CacheTTL = 60000
+	}
+	if config.NegativeCacheTTL <= 0 {
+		config.NegativeCacheTTL = 10000
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 2000
+	}
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = 10000
+	}
+	if config.MaxNegativeEntries <= 0 {
+		config.MaxNegativeEntries = 1000
+	}
+	active, err := NewLRUCache[string, IntrospectionResult](config.MaxEntries, 0)
+	if err != nil {
+		return nil, err
+	}
+	inactive, err := NewLRUCache[string, IntrospectionResult](config.MaxNegativeEntries, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &IntrospectionClient{config: config, active: active, inactive: inactive}, nil
+}
+
+// The raw token is never stored, the cache is keyed by its hash.
+func cacheKeyOfToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return string(sum[:])
+}
+
+// Introspect validates the token, the callback is called synchronously if the result is cached.
+func (c *IntrospectionClient) Introspect(token string, callback IntrospectionCallback) error {
+	key := cacheKeyOfToken(token)
+	if result, ok := c.active.Get(key); ok {
+		callback(result, nil)
+		return nil
+	}
+	if result, ok := c.inactive.Get(key); ok {
+		callback(result, nil)
+		return nil
+	}
+	headers := [][2]string{
+		{"content-type", "application/x-www-form-urlencoded"},
+		{"accept", "application/json"},
+	}
+	if c.config.ClientID != "" {
+		credential := url.QueryEscape(c.config.ClientID) + ":" + url.QueryEscape(c.config.ClientSecret)
+		headers = append(headers, [2]string{"authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte(credential))})
+	}
+	body := url.Values{"token": {token}, "token_type_hint": {"access_token"}}.Encode()
+	return c.config.Client.Post(c.config.URL, headers, []byte(body), func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if statusCode != http.StatusOK {
+			callback(IntrospectionResult{}, fmt.Errorf("introspection failed, status: %d, body: %s", statusCode, responseBody))
+			return
+		}
+		var result IntrospectionResult
+		if err := json.Unmarshal(responseBody, &result); err != nil {
+			callback(IntrospectionResult{}, fmt.Errorf("invalid introspection response: %v", err))
+			return
+		}
+		result.Raw = responseBody
+		c.store(key, result)
+		callback(result, nil)
+	}, c.config.Timeout)
+}
+
+func (c *IntrospectionClient) store(key string, result IntrospectionResult) {
+	if !result.Active {
+		c.inactive.SetWithTTL(key, result, time.Duration(c.config.NegativeCacheTTL)*time.Millisecond)
+		return
+	}
+	ttl := c.config.CacheTTL
+	if result.Exp > 0 {
+		if untilExp := result.Exp*1000 - time.Now().UnixMilli(); untilExp < ttl {
+			ttl = untilExp
+		}
+	}
+	if ttl > 0 {
+		c.active.SetWithTTL(key, result, time.Duration(ttl)*time.Millisecond)
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+)
+
+// JSONSchema validates the json values against the commonly used subset of JSON Schema: type, enum, const,
+// properties, required, additionalProperties, items, the string, number and array bounds, pattern, allOf, anyOf
+// and oneOf. The other keywords are ignored, except $ref which is rejected since it's not resolved.
+type JSONSchema struct {
+	types                []string
+	enum                 []interface{}
+	constValue           interface{}
+	hasConst             bool
+	properties           map[string]*JSONSchema
+	required             []string
+	additionalProperties *JSONSchema
+	noAdditional         bool
+	items                *JSONSchema
+	minLength            *int
+	maxLength            *int
+	minimum              *float64
+	maximum              *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+	minItems             *int
+	maxItems             *int
+	pattern              *regexp.Regexp
+	allOf                []*JSONSchema
+	anyOf                []*JSONSchema
+	oneOf                []*JSONSchema
+}
+
+func ParseJSONSchema(schema gjson.Result) (*JSONSchema, error) {
+	if schema.Type == gjson.True {
+		return &JSONSchema{}, nil
+	}
+	if !schema.IsObject() {
+		return nil, fmt.Errorf("invalid schema: %s", schema.Raw)
+	}
+	if schema.Get(`\$ref`).Exists() {
+		return nil, fmt.Errorf("unsupported schema keyword: $ref")
+	}
+	s := &JSONSchema{}
+	switch t := schema.Get("type"); {
+	case t.IsArray():
+		for _, item := range t.Array() {
+			s.types = append(s.types, item.String())
+		}
+	case t.Exists():
+		s.types = []string{t.String()}
+	}
+	for _, item := range schema.Get("enum").Array() {
+		s.enum = append(s.enum, decodeJSONValue(item.Raw))
+	}
+	if c := schema.Get("const"); c.Exists() {
+		s.constValue, s.hasConst = decodeJSONValue(c.Raw), true
+	}
+	var err error
+	if properties := schema.Get("properties"); properties.Exists() {
+		s.properties = map[string]*JSONSchema{}
+		properties.ForEach(func(key, value gjson.Result) bool {
+			s.properties[key.String()], err = ParseJSONSchema(value)
+			return err == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, item := range schema.Get("required").Array() {
+		s.required = append(s.required, item.String())
+	}
+	switch additional := schema.Get("additionalProperties"); {
+	case additional.Type == gjson.False:
+		s.noAdditional = true
+	case additional.IsObject():
+		if s.additionalProperties, err = ParseJSONSchema(additional); err != nil {
+			return nil, err
+		}
+	}
+	if items := schema.Get("items"); items.IsObject() {
+		if s.items, err = ParseJSONSchema(items); err != nil {
+			return nil, err
+		}
+	}
+	s.minLength = schemaInt(schema, "minLength")
+	s.maxLength = schemaInt(schema, "maxLength")
+	s.minItems = schemaInt(schema, "minItems")
+	s.maxItems = schemaInt(schema, "maxItems")
+	s.minimum = schemaFloat(schema, "minimum")
+	s.maximum = schemaFloat(schema, "maximum")
+	s.exclusiveMinimum = schemaFloat(schema, "exclusiveMinimum")
+	s.exclusiveMaximum = schemaFloat(schema, "exclusiveMaximum")
+	if pattern := schema.Get("pattern"); pattern.Exists() {
+		if s.pattern, err = regexp.Compile(pattern.String()); err != nil {
+			return nil, fmt.Errorf("invalid schema pattern %s: %v", pattern.String(), err)
+		}
+	}
+	for keyword, target := range map[string]*[]*JSONSchema{"allOf": &s.allOf, "anyOf": &s.anyOf, "oneOf": &s.oneOf} {
+		for _, item := range schema.Get(keyword).Array() {
+			sub, err := ParseJSONSchema(item)
+			if err != nil {
+				return nil, err
+			}
+			*target = append(*target, sub)
+		}
+	}
+	return s, nil
+}
+
+func schemaInt(schema gjson.Result, keyword string) *int {
+	if value := schema.Get(keyword); value.Exists() {
+		v := int(value.Int())
+		return &v
+	}
+	return nil
+}
+
+func schemaFloat(schema gjson.Result, keyword string) *float64 {
+	if value := schema.Get(keyword); value.Type == gjson.Number {
+		v := value.Float()
+		return &v
+	}
+	return nil
+}
+
+func decodeJSONValue(raw string) interface{} {
+	var value interface{}
+	_ = json.Unmarshal([]byte(raw), &value)
+	return value
+}
+
+// ValidateBytes parses the data and validates it, an error is returned if the data is not valid json.
+func (s *JSONSchema) ValidateBytes(data []byte) ([]string, error) {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return s.Validate(value), nil
+}
+
+// Validate returns the violations of the value decoded by encoding/json, each is prefixed with the json path.
+func (s *JSONSchema) Validate(value interface{}) []string {
+	return s.validate("$", value, nil)
+}
+
+func jsonTypeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+func (s *JSONSchema) validate(path string, value interface{}, errs []string) []string {
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, path+": "+fmt.Sprintf(format, args...))
+	}
+	if len(s.types) > 0 {
+		actual := jsonTypeOf(value)
+		matched := false
+		for _, t := range s.types {
+			if t == actual || (t == "number" && actual == "integer") {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("expected %v, got %s", s.types, actual)
+			return errs
+		}
+	}
+	if len(s.enum) > 0 {
+		found := false
+		for _, item := range s.enum {
+			if reflect.DeepEqual(item, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("value is not one of the enum")
+		}
+	}
+	if s.hasConst && !reflect.DeepEqual(s.constValue, value) {
+		fail("value is not the const")
+	}
+	switch v := value.(type) {
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.minLength != nil && length < *s.minLength {
+			fail("length %d is less than %d", length, *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			fail("length %d is greater than %d", length, *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("value does not match %s", s.pattern.String())
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			fail("%v is less than %v", v, *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			fail("%v is greater than %v", v, *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && v <= *s.exclusiveMinimum {
+			fail("%v is not greater than %v", v, *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && v >= *s.exclusiveMaximum {
+			fail("%v is not less than %v", v, *s.exclusiveMaximum)
+		}
+	case []interface{}:
+		if s.minItems != nil && len(v) < *s.minItems {
+			fail("%d items is less than %d", len(v), *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			fail("%d items is greater than %d", len(v), *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range v {
+				errs = s.items.validate(path+"["+strconv.Itoa(i)+"]", item, errs)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				fail("missing required property %s", name)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		// sort the keys to make the errors stable
+		sort.Strings(keys)
+		for _, key := range keys {
+			if property, ok := s.properties[key]; ok {
+				errs = property.validate(path+"."+key, v[key], errs)
+			} else if s.noAdditional {
+				fail("additional property %s is not allowed", key)
+			} else if s.additionalProperties != nil {
+				errs = s.additionalProperties.validate(path+"."+key, v[key], errs)
+			}
+		}
+	}
+	for _, sub := range s.allOf {
+		errs = sub.validate(path, value, errs)
+	}
+	if len(s.anyOf) > 0 {
+		matched := false
+		for _, sub := range s.anyOf {
+			if len(sub.validate(path, value, nil)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("value does not match any of the schemas")
+		}
+	}
+	if len(s.oneOf) > 0 {
+		matched := 0
+		for _, sub := range s.oneOf {
+			if len(sub.validate(path, value, nil)) == 0 {
+				matched++
+			}
+		}
+		if matched != 1 {
+			fail("value matches %d of the schemas instead of exactly one", matched)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+const testJSONSchema = `{
+  "type": "object",
+  "properties": {
+    "name": {"type": "string", "minLength": 1, "pattern": "^[a-z]+$"},
+    "age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150},
+    "tags": {"type": "array", "items": {"enum": ["a", "b"]}, "maxItems": 2},
+    "score": {"type": ["number", "null"]},
+    "kind": {"const": "person"},
+    "contact": {"oneOf": [
+      {"type": "object", "required": ["email"]},
+      {"type": "object", "required": ["phone"]}
+    ]}
+  },
+  "required": ["name", "age"],
+  "additionalProperties": false
+}`
+
+func TestJSONSchemaValidate(t *testing.T) {
+	schema, err := ParseJSONSchema(gjson.Parse(testJSONSchema))
+	require.NoError(t, err)
+
+	errs, err := schema.ValidateBytes([]byte(`{"name": "alice", "age": 30, "tags": ["a"], "score": null, "kind": "person", "contact": {"email": "a@b.c"}}`))
+	require.NoError(t, err)
+	assert.Empty(t, errs)
+
+	errs, err = schema.ValidateBytes([]byte(`{"name": "Alice", "age": 1.5, "tags": ["a", "c", "b"], "score": "high", "kind": "dog", "contact": {"email": "", "phone": ""}, "extra": 1}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"$.age: expected [integer], got number",
+		"$.contact: value matches 2 of the schemas instead of exactly one",
+		"$: additional property extra is not allowed",
+		"$.kind: value is not the const",
+		"$.name: value does not match ^[a-z]+$",
+		"$.score: expected [number null], got string",
+		"$.tags: 3 items is greater than 2",
+		"$.tags[1]: value is not one of the enum",
+	}, errs)
+
+	errs, _ = schema.ValidateBytes([]byte(`{"age": 150}`))
+	assert.Equal(t, []string{"$: missing required property name", "$.age: 150 is not less than 150"}, errs)
+
+	_, err = schema.ValidateBytes([]byte(`{`))
+	assert.Error(t, err)
+}
+
+func TestParseJSONSchemaInvalid(t *testing.T) {
+	_, err := ParseJSONSchema(gjson.Parse(`{"properties": {"a": {"$ref": "#/defs/a"}}}`))
+	assert.Error(t, err)
+	_, err = ParseJSONSchema(gjson.Parse(`{"pattern": "("}`))
+	assert.Error(t, err)
+	_, err = ParseJSONSchema(gjson.Parse(`"string"`))
+	assert.Error(t, err)
+}
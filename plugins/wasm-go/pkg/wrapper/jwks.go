@@ -0,0 +1,209 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+const (
+	jwksKeyPrefix        = "jwks:"
+	jwksLeaseKeyPrefix   = "jwks-lease:"
+	jwksRefetchKeyPrefix = "jwks-refetch:"
+)
+
+type JwksConfig struct {
+	// Name identifies the key set in shared data
+	Name   string
+	Client HttpClient
+	// The url of the jwks endpoint, e.g. /.well-known/jwks.json
+	URL string
+	// The refresh interval in milliseconds, default is 300000ms
+	RefreshInterval int64
+	// How soon a failed fetch is retried in milliseconds, default is 10000ms
+	RetryInterval int64
+	// The minimum interval of the refetches triggered by the tokens of an unknown kid in milliseconds, default is
+	// 30000ms. It picks up the rotated keys before the next refresh, without letting the forged kids flood the
+	// endpoint.
+	RefetchInterval int64
+	// The timeout of the fetch request in milliseconds, default is 2000ms
+	Timeout uint32
+}
+
+type cachedJwks struct {
+	FetchedAt int64  `json:"fetchedAt"`
+	Jwks      string `json:"jwks"`
+}
+
+// JwksProvider fetches the remote key set on tick, only one of the VMs sharing the data does the fetch,
+// and every VM parses the shared copy again only after it changes. A token of an unknown kid triggers a refetch,
+// at most once per RefetchInterval across the VMs.
+type JwksProvider struct {
+	config JwksConfig
+	keys   JwkSet
+	// cas of the shared data which the parsed keys come from
+	cas uint32
+}
+
+// NewJwksProvider should be called in parseConfig phase since it registers the refresh tick function.
+func NewJwksProvider(config JwksConfig) (*JwksProvider, error) {
+	if config.Name == "" {
+		return nil, errors.New("jwks name is empty")
+	}
+	if config.Client == nil || config.URL == "" {
+		return nil, errors.New("jwks client or url is empty")
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = 300000
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = 10000
+	}
+	if config.RetryInterval > config.RefreshInterval {
+		config.RetryInterval = config.RefreshInterval
+	}
+	if config.RefetchInterval <= 0 {
+		config.RefetchInterval = 30000
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 2000
+	}
+	p := &JwksProvider{config: config}
+	// the tick runs every RetryInterval, the key set is fetched only if the last successful fetch is older than
+	// RefreshInterval, so a failed fetch is retried on the next tick
+	leaseTTL := 3 * time.Duration(config.RetryInterval) * time.Millisecond
+	RegisteTickFunc(config.RetryInterval, func() {
+		if TryAcquireSharedLease(jwksLeaseKeyPrefix+config.Name, leaseTTL) && p.refreshDue() {
+			p.fetch(nil)
+		}
+	})
+	return p, nil
+}
+
+func (p *JwksProvider) refreshDue() bool {
+	var cached cachedJwks
+	if _, err := GetSharedDataJson(jwksKeyPrefix+p.config.Name, &cached); err != nil {
+		proxywasm.LogErrorf("failed to get jwks %s: %v", p.config.Name, err)
+		return true
+	}
+	return time.Now().UnixMilli()-cached.FetchedAt >= p.config.RefreshInterval
+}
+
+// fetch fetches the key set into shared data, done is called when the fetch completes, whether it succeeds or not.
+func (p *JwksProvider) fetch(done func()) {
+	if done == nil {
+		done = func() {}
+	}
+	err := p.config.Client.Get(p.config.URL, nil, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		defer done()
+		if statusCode != http.StatusOK {
+			proxywasm.LogErrorf("failed to fetch jwks %s, status: %d, body: %s", p.config.Name, statusCode, responseBody)
+			return
+		}
+		if _, err := ParseJwks(responseBody); err != nil {
+			proxywasm.LogErrorf("failed to parse jwks %s: %v", p.config.Name, err)
+			return
+		}
+		cached := cachedJwks{FetchedAt: time.Now().UnixMilli(), Jwks: string(responseBody)}
+		if err := SetSharedDataJson(jwksKeyPrefix+p.config.Name, cached, 0); err != nil {
+			proxywasm.LogErrorf("failed to store jwks %s: %v", p.config.Name, err)
+		}
+	}, p.config.Timeout)
+	if err != nil {
+		proxywasm.LogErrorf("failed to fetch jwks %s: %v", p.config.Name, err)
+		done()
+	}
+}
+
+// refetchForKid tells whether the token has a kid absent in the key set and the refetch for it is claimed, the
+// refetches are claimed by the VMs at most once per RefetchInterval.
+func (p *JwksProvider) refetchForKid(token string, keys JwkSet) bool {
+	jwt, err := ParseJwt(token)
+	if err != nil || jwt.Header.Kid == "" || len(keys.Find(jwt.Header.Kid, jwt.Header.Alg)) > 0 {
+		return false
+	}
+	key := jwksRefetchKeyPrefix + p.config.Name
+	var last int64
+	cas, err := GetSharedDataJson(key, &last)
+	if err != nil {
+		proxywasm.LogErrorf("failed to get jwks refetch time %s: %v", p.config.Name, err)
+		return false
+	}
+	now := time.Now().UnixMilli()
+	if now-last < p.config.RefetchInterval {
+		return false
+	}
+	if err = SetSharedDataJson(key, now, cas); err != nil {
+		if !errors.Is(err, types.ErrorStatusCasMismatch) {
+			proxywasm.LogErrorf("failed to set jwks refetch time %s: %v", p.config.Name, err)
+		}
+		return false
+	}
+	proxywasm.LogInfof("refetching jwks %s for the unknown kid %q", p.config.Name, jwt.Header.Kid)
+	return true
+}
+
+// Keys returns the latest key set, it's empty before the first fetch succeeds.
+func (p *JwksProvider) Keys() JwkSet {
+	data, cas, err := proxywasm.GetSharedData(jwksKeyPrefix + p.config.Name)
+	if err != nil || cas == p.cas {
+		return p.keys
+	}
+	var cached cachedJwks
+	if err = json.Unmarshal(data, &cached); err != nil {
+		proxywasm.LogErrorf("failed to unmarshal jwks %s: %v", p.config.Name, err)
+		return p.keys
+	}
+	keys, err := ParseJwks([]byte(cached.Jwks))
+	if err != nil {
+		proxywasm.LogErrorf("failed to parse jwks %s: %v", p.config.Name, err)
+		return p.keys
+	}
+	p.keys = keys
+	p.cas = cas
+	return p.keys
+}
+
+// Verify verifies the token against the latest key set. If the kid of the token is unknown, the key set is refetched
+// in the background, and the token fails.
+func (p *JwksProvider) Verify(token string, options JwtValidationOptions) (*Jwt, error) {
+	keys := p.Keys()
+	jwt, err := VerifyJwt(token, keys, options)
+	if err != nil && p.refetchForKid(token, keys) {
+		p.fetch(nil)
+	}
+	return jwt, err
+}
+
+// VerifyAsync verifies the token like Verify, but if the kid of the token is unknown and the refetch is claimed, the
+// token is verified again after the key set is refetched, e.g. right after the keys are rotated. The callback is
+// called synchronously unless the key set is refetched.
+func (p *JwksProvider) VerifyAsync(token string, options JwtValidationOptions, callback func(jwt *Jwt, err error)) {
+	keys := p.Keys()
+	jwt, err := VerifyJwt(token, keys, options)
+	if err == nil || !p.refetchForKid(token, keys) {
+		callback(jwt, err)
+		return
+	}
+	p.fetch(func() {
+		callback(VerifyJwt(token, p.Keys(), options))
+	})
+}
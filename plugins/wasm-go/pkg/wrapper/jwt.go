@@ -0,0 +1,403 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrJwtMalformed        = errors.New("jwt is malformed")
+	ErrJwtSignatureInvalid = errors.New("jwt signature is invalid")
+	ErrJwtExpired          = errors.New("jwt is expired")
+	ErrJwtNotValidYet      = errors.New("jwt is not valid yet")
+	ErrJwtIssuerInvalid    = errors.New("jwt issuer is not allowed")
+	ErrJwtAudienceInvalid  = errors.New("jwt audience is not allowed")
+)
+
+type JwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+type JwtClaims map[string]interface{}
+
+func (c JwtClaims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+func (c JwtClaims) Issuer() string {
+	return c.String("iss")
+}
+
+func (c JwtClaims) Subject() string {
+	return c.String("sub")
+}
+
+// Audience returns the aud claim, which is either a string or an array of strings.
+func (c JwtClaims) Audience() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		var audiences []string
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+		return audiences
+	}
+	return nil
+}
+
+// NumericDate returns the claim as unix seconds, ok is false if it's absent.
+func (c JwtClaims) NumericDate(name string) (int64, bool) {
+	switch v := c[name].(type) {
+	case float64:
+		return int64(v), true
+	case json.Number:
+		n, err := v.Int64()
+		return n, err == nil
+	}
+	return 0, false
+}
+
+type Jwt struct {
+	Header       JwtHeader
+	Claims       JwtClaims
+	Raw          string
+	signingInput string
+	signature    []byte
+}
+
+// ParseJwt decodes the token without verifying it.
+func ParseJwt(token string) (*Jwt, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJwtMalformed
+	}
+	jwt := &Jwt{Raw: token, signingInput: parts[0] + "." + parts[1]}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid header encoding", ErrJwtMalformed)
+	}
+	if err = json.Unmarshal(headerBytes, &jwt.Header); err != nil {
+		return nil, fmt.Errorf("%w: invalid header", ErrJwtMalformed)
+	}
+	claimsBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid claims encoding", ErrJwtMalformed)
+	}
+	if err = json.Unmarshal(claimsBytes, &jwt.Claims); err != nil {
+		return nil, fmt.Errorf("%w: invalid claims", ErrJwtMalformed)
+	}
+	if jwt.signature, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return nil, fmt.Errorf("%w: invalid signature encoding", ErrJwtMalformed)
+	}
+	return jwt, nil
+}
+
+func jwtHash(alg string) (crypto.Hash, func() hash.Hash, error) {
+	switch alg[2:] {
+	case "256":
+		return crypto.SHA256, sha256.New, nil
+	case "384":
+		return crypto.SHA384, sha512.New384, nil
+	case "512":
+		return crypto.SHA512, sha512.New, nil
+	}
+	return 0, nil, fmt.Errorf("unsupported jwt algorithm: %s", alg)
+}
+
+// Verify checks the signature with key, which should be an *rsa.PublicKey for RS*, an *ecdsa.PublicKey for ES*
+// and a []byte secret for HS* algorithms.
+func (j *Jwt) Verify(key interface{}) error {
+	alg := j.Header.Alg
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported jwt algorithm: %s", alg)
+	}
+	hashType, newHash, err := jwtHash(alg)
+	if err != nil {
+		return err
+	}
+	switch alg[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return fmt.Errorf("key of %s must be a secret", alg)
+		}
+		mac := hmac.New(newHash, secret)
+		mac.Write([]byte(j.signingInput))
+		if !hmac.Equal(mac.Sum(nil), j.signature) {
+			return ErrJwtSignatureInvalid
+		}
+		return nil
+	case "RS":
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key of %s must be a rsa public key", alg)
+		}
+		h := newHash()
+		h.Write([]byte(j.signingInput))
+		if rsa.VerifyPKCS1v15(publicKey, hashType, h.Sum(nil), j.signature) != nil {
+			return ErrJwtSignatureInvalid
+		}
+		return nil
+	case "ES":
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key of %s must be an ecdsa public key", alg)
+		}
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		if len(j.signature) != 2*size {
+			return ErrJwtSignatureInvalid
+		}
+		r := new(big.Int).SetBytes(j.signature[:size])
+		s := new(big.Int).SetBytes(j.signature[size:])
+		h := newHash()
+		h.Write([]byte(j.signingInput))
+		if !ecdsa.Verify(publicKey, h.Sum(nil), r, s) {
+			return ErrJwtSignatureInvalid
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported jwt algorithm: %s", alg)
+}
+
+type JwtValidationOptions struct {
+	// Allowed issuers, any issuer is allowed if empty
+	Issuers []string
+	// Allowed audiences, any audience is allowed if empty
+	Audiences []string
+	// Tolerance of the time based claims, default is 60s
+	ClockSkew time.Duration
+	// Reject tokens without the exp claim
+	RequireExpiration bool
+}
+
+// ValidateClaims checks exp, nbf, iat, iss and aud claims.
+func (j *Jwt) ValidateClaims(options JwtValidationOptions) error {
+	skew := options.ClockSkew
+	if skew == 0 {
+		skew = 60 * time.Second
+	}
+	now := time.Now()
+	if exp, ok := j.Claims.NumericDate("exp"); ok {
+		if now.After(time.Unix(exp, 0).Add(skew)) {
+			return ErrJwtExpired
+		}
+	} else if options.RequireExpiration {
+		return fmt.Errorf("%w: missing exp claim", ErrJwtMalformed)
+	}
+	if nbf, ok := j.Claims.NumericDate("nbf"); ok && now.Add(skew).Before(time.Unix(nbf, 0)) {
+		return ErrJwtNotValidYet
+	}
+	if iat, ok := j.Claims.NumericDate("iat"); ok && now.Add(skew).Before(time.Unix(iat, 0)) {
+		return ErrJwtNotValidYet
+	}
+	if len(options.Issuers) > 0 && !containsString(options.Issuers, j.Claims.Issuer()) {
+		return ErrJwtIssuerInvalid
+	}
+	if len(options.Audiences) > 0 {
+		matched := false
+		for _, aud := range j.Claims.Audience() {
+			if containsString(options.Audiences, aud) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return ErrJwtAudienceInvalid
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+type Jwk struct {
+	Kid string
+	Kty string
+	Alg string
+	// *rsa.PublicKey, *ecdsa.PublicKey or []byte
+	Key interface{}
+}
+
+type JwkSet struct {
+	Keys []Jwk
+}
+
+type rawJwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// ParseJwks parses a JSON Web Key Set, keys of unsupported types or for encryption are skipped.
+func ParseJwks(data []byte) (JwkSet, error) {
+	var raw struct {
+		Keys []rawJwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return JwkSet{}, fmt.Errorf("invalid jwks: %v", err)
+	}
+	var set JwkSet
+	for _, k := range raw.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return JwkSet{}, fmt.Errorf("invalid jwk %s: %v", k.Kid, err)
+		}
+		if key == nil {
+			continue
+		}
+		set.Keys = append(set.Keys, Jwk{Kid: k.Kid, Kty: k.Kty, Alg: k.Alg, Key: key})
+	}
+	return set, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k rawJwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(k.K, "="))
+	}
+	return nil, nil
+}
+
+func jwkMatchesAlg(key Jwk, alg string) bool {
+	if key.Alg != "" {
+		return key.Alg == alg
+	}
+	switch key.Kty {
+	case "RSA":
+		return strings.HasPrefix(alg, "RS")
+	case "EC":
+		return strings.HasPrefix(alg, "ES")
+	case "oct":
+		return strings.HasPrefix(alg, "HS")
+	}
+	return false
+}
+
+// Find returns the candidate keys for a token, all keys of a compatible type are returned if kid is empty.
+func (s JwkSet) Find(kid, alg string) []Jwk {
+	var keys []Jwk
+	for _, key := range s.Keys {
+		if kid != "" && key.Kid != kid {
+			continue
+		}
+		if jwkMatchesAlg(key, alg) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// VerifyJwt parses the token, verifies its signature against the key set and validates its claims.
+func VerifyJwt(token string, keys JwkSet, options JwtValidationOptions) (*Jwt, error) {
+	jwt, err := ParseJwt(token)
+	if err != nil {
+		return nil, err
+	}
+	candidates := keys.Find(jwt.Header.Kid, jwt.Header.Alg)
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w: no key found for kid %q", ErrJwtSignatureInvalid, jwt.Header.Kid)
+	}
+	err = ErrJwtSignatureInvalid
+	for _, key := range candidates {
+		if err = jwt.Verify(key.Key); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = jwt.ValidateClaims(options); err != nil {
+		return nil, err
+	}
+	return jwt, nil
+}
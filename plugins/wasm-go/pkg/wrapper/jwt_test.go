@@ -0,0 +1,133 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func signTestJwt(t *testing.T, header, claims map[string]interface{}, sign func(input []byte) []byte) string {
+	h, err := json.Marshal(header)
+	require.NoError(t, err)
+	c, err := json.Marshal(claims)
+	require.NoError(t, err)
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	return input + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(input)))
+}
+
+func b64BigInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+func TestVerifyJwt(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	secret := []byte("secret")
+	jwks := fmt.Sprintf(`{"keys":[
+		{"kty":"RSA","kid":"rsa","n":"%s","e":"%s"},
+		{"kty":"EC","kid":"ec","crv":"P-256","x":"%s","y":"%s"},
+		{"kty":"oct","kid":"hmac","k":"%s"},
+		{"kty":"RSA","kid":"enc","use":"enc","n":"%s","e":"AQAB"}]}`,
+		b64BigInt(rsaKey.N), b64BigInt(big.NewInt(int64(rsaKey.E))),
+		b64BigInt(ecKey.X), b64BigInt(ecKey.Y),
+		base64.RawURLEncoding.EncodeToString(secret), b64BigInt(rsaKey.N))
+	keys, err := ParseJwks([]byte(jwks))
+	require.NoError(t, err)
+	assert.Len(t, keys.Keys, 3)
+
+	signRS256 := func(input []byte) []byte {
+		digest := sha256.Sum256(input)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+		return sig
+	}
+	signES256 := func(input []byte) []byte {
+		digest := sha256.Sum256(input)
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		require.NoError(t, err)
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig
+	}
+	signHS256 := func(input []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(input)
+		return mac.Sum(nil)
+	}
+	now := time.Now().Unix()
+	valid := map[string]interface{}{"iss": "higress", "sub": "user", "aud": []string{"api"}, "exp": now + 60}
+	options := JwtValidationOptions{Issuers: []string{"higress"}, Audiences: []string{"api"}}
+
+	cases := []struct {
+		name   string
+		header map[string]interface{}
+		claims map[string]interface{}
+		sign   func([]byte) []byte
+		expect error
+	}{
+		{"rs256", map[string]interface{}{"alg": "RS256", "kid": "rsa"}, valid, signRS256, nil},
+		{"es256", map[string]interface{}{"alg": "ES256", "kid": "ec"}, valid, signES256, nil},
+		{"hs256 without kid", map[string]interface{}{"alg": "HS256"}, valid, signHS256, nil},
+		{"wrong key", map[string]interface{}{"alg": "RS256", "kid": "rsa"}, valid, signHS256, ErrJwtSignatureInvalid},
+		{"unknown kid", map[string]interface{}{"alg": "RS256", "kid": "enc"}, valid, signRS256, ErrJwtSignatureInvalid},
+		{"expired", map[string]interface{}{"alg": "HS256"},
+			map[string]interface{}{"iss": "higress", "aud": "api", "exp": now - 120}, signHS256, ErrJwtExpired},
+		{"expired within skew", map[string]interface{}{"alg": "HS256"},
+			map[string]interface{}{"iss": "higress", "aud": "api", "exp": now - 30}, signHS256, nil},
+		{"not before", map[string]interface{}{"alg": "HS256"},
+			map[string]interface{}{"iss": "higress", "aud": "api", "nbf": now + 120}, signHS256, ErrJwtNotValidYet},
+		{"issuer", map[string]interface{}{"alg": "HS256"},
+			map[string]interface{}{"iss": "other", "aud": "api"}, signHS256, ErrJwtIssuerInvalid},
+		{"audience", map[string]interface{}{"alg": "HS256"},
+			map[string]interface{}{"iss": "higress", "aud": "other"}, signHS256, ErrJwtAudienceInvalid},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			token := signTestJwt(t, c.header, c.claims, c.sign)
+			jwt, err := VerifyJwt(token, keys, options)
+			if c.expect != nil {
+				assert.ErrorIs(t, err, c.expect)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.claims["iss"], jwt.Claims.Issuer())
+		})
+	}
+}
+
+func TestParseJwtMalformed(t *testing.T) {
+	for _, token := range []string{"", "a.b", "a.b.c", "e30.e30.!!"} {
+		_, err := ParseJwt(token)
+		assert.ErrorIs(t, err, ErrJwtMalformed, token)
+	}
+}
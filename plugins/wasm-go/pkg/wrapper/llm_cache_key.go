@@ -0,0 +1,233 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/tidwall/gjson"
+)
+
+const cacheKeyScopeField = "cache_scope"
+
+// CacheKeyConfig decides which parts of the request make the cache key and how they are normalized.
+type CacheKeyConfig struct {
+	// The roles of the messages in the key, default is user only
+	Roles []string
+	// Only the last n messages of the roles are used, 0 means all
+	LastN int
+	// Lower case the text
+	Lowercase bool
+	// Remove the punctuations
+	StripPunctuation bool
+	// The words removed from the text, compared after lower casing
+	StopWords map[string]bool
+	// The request parameters in the key, e.g. model and temperature, default is model
+	Params []string
+	// The requests with a higher temperature are not cached, as the responses are expected to vary, 0 means no limit
+	MaxTemperature float64
+	// The prefix of the exact keys
+	KeyPrefix string
+}
+
+// ParseCacheKeyConfig parses the config like:
+//
+//	{"roles": ["system", "user"], "lastN": 1, "lowercase": true, "stripPunctuation": true,
+//	 "stopWords": ["please"], "params": ["model", "temperature"], "maxTemperature": 1, "keyPrefix": "ai-cache:"}
+func ParseCacheKeyConfig(json gjson.Result) CacheKeyConfig {
+	config := CacheKeyConfig{
+		LastN:            int(json.Get("lastN").Int()),
+		Lowercase:        json.Get("lowercase").Bool(),
+		StripPunctuation: json.Get("stripPunctuation").Bool(),
+		MaxTemperature:   json.Get("maxTemperature").Float(),
+		KeyPrefix:        json.Get("keyPrefix").String(),
+	}
+	for _, role := range json.Get("roles").Array() {
+		config.Roles = append(config.Roles, role.String())
+	}
+	if words := json.Get("stopWords").Array(); len(words) > 0 {
+		config.StopWords = map[string]bool{}
+		for _, word := range words {
+			config.StopWords[strings.ToLower(word.String())] = true
+		}
+	}
+	for _, param := range json.Get("params").Array() {
+		config.Params = append(config.Params, param.String())
+	}
+	return config
+}
+
+// NormalizeText collapses the whitespaces and applies the case, punctuation and stop word options.
+func (c CacheKeyConfig) NormalizeText(text string) string {
+	if c.Lowercase {
+		text = strings.ToLower(text)
+	}
+	if c.StripPunctuation {
+		text = strings.Map(func(r rune) rune {
+			if unicode.IsPunct(r) || unicode.IsSymbol(r) {
+				return ' '
+			}
+			return r
+		}, text)
+	}
+	words := strings.Fields(text)
+	if len(c.StopWords) > 0 {
+		kept := words[:0]
+		for _, word := range words {
+			if !c.StopWords[strings.ToLower(word)] {
+				kept = append(kept, word)
+			}
+		}
+		words = kept
+	}
+	return strings.Join(words, " ")
+}
+
+func (c CacheKeyConfig) roles() []string {
+	if len(c.Roles) == 0 {
+		return []string{RoleUser}
+	}
+	return c.Roles
+}
+
+// Prompt returns the normalized text of the messages in the key, it's also the text to embed for the semantic lookup.
+func (c CacheKeyConfig) Prompt(messages []ChatMessage) string {
+	var lines []string
+	roles := c.roles()
+	for _, message := range messages {
+		for _, role := range roles {
+			if message.Role == role {
+				lines = append(lines, c.NormalizeText(message.StringContent()))
+				break
+			}
+		}
+	}
+	if c.LastN > 0 && len(lines) > c.LastN {
+		lines = lines[len(lines)-c.LastN:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// canonicalParams returns the parameters in the key sorted by name, with the values in their json form.
+func (c CacheKeyConfig) canonicalParams(request *ChatCompletionRequest) string {
+	params := c.Params
+	if len(params) == 0 {
+		params = []string{"model"}
+	}
+	data, _ := json.Marshal(request)
+	sorted := append([]string{}, params...)
+	sort.Strings(sorted)
+	var pairs []string
+	for _, param := range sorted {
+		value := gjson.GetBytes(data, param)
+		if value.Exists() {
+			pairs = append(pairs, param+"="+value.Raw)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// Cacheable returns false for the requests whose responses should not be reused.
+func (c CacheKeyConfig) Cacheable(request *ChatCompletionRequest) bool {
+	if request.N > 1 {
+		return false
+	}
+	if c.MaxTemperature > 0 && request.Temperature > c.MaxTemperature {
+		return false
+	}
+	return c.Prompt(request.Messages) != ""
+}
+
+// ExactKey returns the key of the exact match. The scope, e.g. the consumer name, separates the caches of the
+// tenants, so a response is never returned to a caller who could not have seen it.
+func (c CacheKeyConfig) ExactKey(scope string, request *ChatCompletionRequest) string {
+	h := sha256.New()
+	for _, part := range []string{scope, c.canonicalParams(request), c.Prompt(request.Messages)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return c.KeyPrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// SemanticDocument builds the document stored in the vector store for the cached response.
+func (c CacheKeyConfig) SemanticDocument(scope string, request *ChatCompletionRequest, vector []float64, response string) VectorDocument {
+	return VectorDocument{
+		ID:       c.ExactKey(scope, request),
+		Vector:   vector,
+		Content:  response,
+		Metadata: map[string]interface{}{cacheKeyScopeField: scope, "params": c.canonicalParams(request)},
+	}
+}
+
+// SemanticMatcher decides if a search result is close enough to be a cache hit.
+type SemanticMatcher struct {
+	Threshold float64
+	// True if the store returns similarities, false if it returns distances
+	HigherIsCloser bool
+}
+
+func (m SemanticMatcher) Accept(score float64) bool {
+	if m.HigherIsCloser {
+		return score >= m.Threshold
+	}
+	return score <= m.Threshold
+}
+
+// BestMatch returns the closest result accepted by the matcher which has the same scope and parameters as the
+// request, the results from other tenants or of other models are never hits even if the text is similar.
+func (c CacheKeyConfig) BestMatch(matcher SemanticMatcher, scope string, request *ChatCompletionRequest, results []VectorSearchResult) (*VectorSearchResult, bool) {
+	params := c.canonicalParams(request)
+	var best *VectorSearchResult
+	for i := range results {
+		result := &results[i]
+		if result.Metadata[cacheKeyScopeField] != scope || result.Metadata["params"] != params || !matcher.Accept(result.Score) {
+			continue
+		}
+		if best == nil || matcher.closer(result.Score, best.Score) {
+			best = result
+		}
+	}
+	return best, best != nil
+}
+
+func (m SemanticMatcher) closer(a, b float64) bool {
+	if m.HigherIsCloser {
+		return a > b
+	}
+	return a < b
+}
+
+// CosineSimilarity returns the cosine of the angle between the vectors, 0 if they have different dimensions.
+func CosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
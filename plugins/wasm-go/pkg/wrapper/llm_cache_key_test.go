@@ -0,0 +1,82 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestCacheKeyNormalize(t *testing.T) {
+	config := ParseCacheKeyConfig(gjson.Parse(`{"lowercase": true, "stripPunctuation": true, "stopWords": ["Please"]}`))
+	assert.Equal(t, "what is higress", config.NormalizeText("  Please,\n what   is Higress? "))
+
+	request := &ChatCompletionRequest{Model: "qwen-max", Messages: []ChatMessage{
+		{Role: RoleSystem, Content: "You are helpful"},
+		{Role: RoleUser, Content: "Hi"},
+		{Role: RoleAssistant, Content: "Hello"},
+		{Role: RoleUser, Content: "What is Higress?"},
+	}}
+	assert.Equal(t, "hi\nwhat is higress", config.Prompt(request.Messages))
+	config.LastN = 1
+	assert.Equal(t, "what is higress", config.Prompt(request.Messages))
+	config.Roles = []string{RoleSystem, RoleUser}
+	config.LastN = 0
+	assert.Equal(t, "you are helpful\nhi\nwhat is higress", config.Prompt(request.Messages))
+}
+
+func TestCacheKeyExact(t *testing.T) {
+	config := ParseCacheKeyConfig(gjson.Parse(`{"lowercase": true, "params": ["temperature", "model"], "maxTemperature": 1, "keyPrefix": "ai-cache:"}`))
+	a := &ChatCompletionRequest{Model: "qwen-max", Temperature: 0.5, Messages: []ChatMessage{{Role: RoleUser, Content: "What is  Higress"}}}
+	b := &ChatCompletionRequest{Model: "qwen-max", Temperature: 0.5, Messages: []ChatMessage{{Role: RoleUser, Content: "what is higress"}}}
+	assert.Equal(t, "model=\"qwen-max\"&temperature=0.5", config.canonicalParams(a))
+	assert.Equal(t, config.ExactKey("alice", a), config.ExactKey("alice", b))
+	assert.Contains(t, config.ExactKey("alice", a), "ai-cache:")
+	assert.NotEqual(t, config.ExactKey("alice", a), config.ExactKey("bob", a))
+	b.Model = "qwen-turbo"
+	assert.NotEqual(t, config.ExactKey("alice", a), config.ExactKey("alice", b))
+
+	assert.True(t, config.Cacheable(a))
+	assert.False(t, config.Cacheable(&ChatCompletionRequest{N: 2, Messages: a.Messages}))
+	assert.False(t, config.Cacheable(&ChatCompletionRequest{Temperature: 1.5, Messages: a.Messages}))
+	assert.False(t, config.Cacheable(&ChatCompletionRequest{Messages: []ChatMessage{{Role: RoleSystem, Content: "x"}}}))
+}
+
+func TestCacheKeyBestMatch(t *testing.T) {
+	config := CacheKeyConfig{}
+	request := &ChatCompletionRequest{Model: "qwen-max", Messages: []ChatMessage{{Role: RoleUser, Content: "hi"}}}
+	doc := config.SemanticDocument("alice", request, []float64{1, 0}, "hello")
+	results := []VectorSearchResult{
+		{ID: "other-tenant", Score: 0.99, Metadata: map[string]interface{}{cacheKeyScopeField: "bob", "params": doc.Metadata["params"]}},
+		{ID: "close", Score: 0.95, Metadata: doc.Metadata},
+		{ID: "closest", Score: 0.97, Metadata: doc.Metadata},
+		{ID: "far", Score: 0.5, Metadata: doc.Metadata},
+	}
+	best, ok := config.BestMatch(SemanticMatcher{Threshold: 0.9, HigherIsCloser: true}, "alice", request, results)
+	assert.True(t, ok)
+	assert.Equal(t, "closest", best.ID)
+
+	best, ok = config.BestMatch(SemanticMatcher{Threshold: 0.6}, "alice", request, results)
+	assert.True(t, ok)
+	assert.Equal(t, "far", best.ID)
+
+	_, ok = config.BestMatch(SemanticMatcher{Threshold: 0.9, HigherIsCloser: true}, "carol", request, results)
+	assert.False(t, ok)
+
+	assert.InDelta(t, 1, CosineSimilarity([]float64{1, 2}, []float64{2, 4}), 1e-9)
+	assert.Equal(t, float64(0), CosineSimilarity([]float64{1}, []float64{1, 2}))
+}
@@ -0,0 +1,630 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	claudeApiVersion       = "2023-06-01"
+	claudeDefaultMaxTokens = 4096
+)
+
+type claudeRequest struct {
+	Model         string          `json:"model"`
+	System        claudeText      `json:"system,omitempty"`
+	Messages      []claudeMessage `json:"messages"`
+	MaxTokens     int             `json:"max_tokens"`
+	StopSequences []string        `json:"stop_sequences,omitempty"`
+	Stream        bool            `json:"stream,omitempty"`
+	Temperature   float64         `json:"temperature,omitempty"`
+	TopP          float64         `json:"top_p,omitempty"`
+	Tools         []claudeTool    `json:"tools,omitempty"`
+	ToolChoice    *claudeChoice   `json:"tool_choice,omitempty"`
+}
+
+type claudeMessage struct {
+	Role    string               `json:"role"`
+	Content []claudeContentBlock `json:"content"`
+}
+
+type claudeContentBlock struct {
+	Type      string                 `json:"type"`
+	Text      string                 `json:"text,omitempty"`
+	Source    *claudeImageSource     `json:"source,omitempty"`
+	ID        string                 `json:"id,omitempty"`
+	Name      string                 `json:"name,omitempty"`
+	Input     map[string]interface{} `json:"input,omitempty"`
+	ToolUseID string                 `json:"tool_use_id,omitempty"`
+	Content   claudeText             `json:"content,omitempty"`
+}
+
+// claudeText is a string, or an array of text blocks joined by new lines when it's unmarshalled.
+type claudeText string
+
+func (t *claudeText) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*t = claudeText(text)
+		return nil
+	}
+	var blocks []claudeContentBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return err
+	}
+	var texts []string
+	for _, block := range blocks {
+		if block.Type == "text" {
+			texts = append(texts, block.Text)
+		}
+	}
+	*t = claudeText(strings.Join(texts, "\n"))
+	return nil
+}
+
+// UnmarshalJSON accepts the string content, which is a shorthand of a text block.
+func (m *claudeMessage) UnmarshalJSON(data []byte) error {
+	var message struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &message); err != nil {
+		return err
+	}
+	m.Role = message.Role
+	var text string
+	if err := json.Unmarshal(message.Content, &text); err == nil {
+		m.Content = []claudeContentBlock{{Type: "text", Text: text}}
+		return nil
+	}
+	return json.Unmarshal(message.Content, &m.Content)
+}
+
+type claudeImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	Url       string `json:"url,omitempty"`
+}
+
+type claudeTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+type claudeChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+type claudeResponse struct {
+	ID         string               `json:"id"`
+	Type       string               `json:"type"`
+	Role       string               `json:"role"`
+	Model      string               `json:"model"`
+	Content    []claudeContentBlock `json:"content"`
+	StopReason string               `json:"stop_reason"`
+	Usage      claudeUsage          `json:"usage"`
+}
+
+type claudeUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type claudeStreamEvent struct {
+	Type         string              `json:"type"`
+	Index        int                 `json:"index"`
+	Message      *claudeResponse     `json:"message,omitempty"`
+	ContentBlock *claudeContentBlock `json:"content_block,omitempty"`
+	Delta        *struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJson string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta,omitempty"`
+	Usage *claudeUsage `json:"usage,omitempty"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+type claudeDialect struct{}
+
+func (claudeDialect) defaultBasePath() string {
+	return "/v1"
+}
+
+func claudeContent(message ChatMessage) []claudeContentBlock {
+	var blocks []claudeContentBlock
+	for _, part := range message.ContentParts() {
+		switch part.Type {
+		case ContentTypeText:
+			if part.Text != "" {
+				blocks = append(blocks, claudeContentBlock{Type: "text", Text: part.Text})
+			}
+		case ContentTypeImageUrl:
+			if part.ImageUrl == nil {
+				continue
+			}
+			if mediaType, data, ok := parseDataUrl(part.ImageUrl.Url); ok {
+				blocks = append(blocks, claudeContentBlock{Type: "image", Source: &claudeImageSource{Type: "base64", MediaType: mediaType, Data: data}})
+			} else {
+				blocks = append(blocks, claudeContentBlock{Type: "image", Source: &claudeImageSource{Type: "url", Url: part.ImageUrl.Url}})
+			}
+		}
+	}
+	return blocks
+}
+
+// openAIToClaudeRequest converts the request, the system messages are merged into the system prompt and the tool
+// results are sent as user messages.
+func openAIToClaudeRequest(request *ChatCompletionRequest) *claudeRequest {
+	r := &claudeRequest{
+		Model:         request.Model,
+		MaxTokens:     request.MaxTokens,
+		StopSequences: request.Stop,
+		Stream:        request.Stream,
+		Temperature:   request.Temperature,
+		TopP:          request.TopP,
+	}
+	if r.MaxTokens == 0 {
+		r.MaxTokens = claudeDefaultMaxTokens
+	}
+	var system []string
+	for _, message := range request.Messages {
+		switch message.Role {
+		case RoleSystem:
+			system = append(system, message.StringContent())
+		case RoleTool:
+			block := claudeContentBlock{Type: "tool_result", ToolUseID: message.ToolCallID, Content: claudeText(message.StringContent())}
+			// consecutive tool results are sent in one user message
+			if n := len(r.Messages); n > 0 && r.Messages[n-1].Role == RoleUser && r.Messages[n-1].Content[0].Type == "tool_result" {
+				r.Messages[n-1].Content = append(r.Messages[n-1].Content, block)
+			} else {
+				r.Messages = append(r.Messages, claudeMessage{Role: RoleUser, Content: []claudeContentBlock{block}})
+			}
+		default:
+			content := claudeContent(message)
+			for _, call := range message.ToolCalls {
+				content = append(content, claudeContentBlock{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: claudeToolInput(call)})
+			}
+			role := RoleUser
+			if message.Role == RoleAssistant {
+				role = RoleAssistant
+			}
+			r.Messages = append(r.Messages, claudeMessage{Role: role, Content: content})
+		}
+	}
+	r.System = claudeText(strings.Join(system, "\n"))
+	for _, tool := range request.Tools {
+		schema := tool.Function.Parameters
+		if schema == nil {
+			schema = map[string]interface{}{"type": "object"}
+		}
+		r.Tools = append(r.Tools, claudeTool{Name: tool.Function.Name, Description: tool.Function.Description, InputSchema: schema})
+	}
+	switch choice := request.ToolChoice.(type) {
+	case string:
+		switch choice {
+		case "auto":
+			r.ToolChoice = &claudeChoice{Type: "auto"}
+		case "required":
+			r.ToolChoice = &claudeChoice{Type: "any"}
+		case "none":
+			r.ToolChoice = &claudeChoice{Type: "none"}
+		}
+	case map[string]interface{}:
+		if function, ok := choice["function"].(map[string]interface{}); ok {
+			name, _ := function["name"].(string)
+			r.ToolChoice = &claudeChoice{Type: "tool", Name: name}
+		}
+	}
+	return r
+}
+
+// claudeToolInput parses the arguments of the call, the input of a tool use must be an object.
+func claudeToolInput(call ToolCall) map[string]interface{} {
+	var input map[string]interface{}
+	_ = json.Unmarshal([]byte(call.Function.Arguments), &input)
+	if input == nil {
+		input = map[string]interface{}{}
+	}
+	return input
+}
+
+// claudeToOpenAIRequest converts a request of the Messages API, the tool results are sent as tool messages before
+// the rest of the user message.
+func claudeToOpenAIRequest(r *claudeRequest) *ChatCompletionRequest {
+	request := &ChatCompletionRequest{
+		Model:       r.Model,
+		MaxTokens:   r.MaxTokens,
+		Stop:        r.StopSequences,
+		Stream:      r.Stream,
+		Temperature: r.Temperature,
+		TopP:        r.TopP,
+	}
+	if r.Stream {
+		// the usage is reported by message_delta at the end
+		request.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+	if r.System != "" {
+		request.Messages = append(request.Messages, ChatMessage{Role: RoleSystem, Content: string(r.System)})
+	}
+	for _, message := range r.Messages {
+		var parts []ContentPart
+		var calls []ToolCall
+		for _, block := range message.Content {
+			switch block.Type {
+			case "text":
+				parts = append(parts, ContentPart{Type: ContentTypeText, Text: block.Text})
+			case "image":
+				if block.Source == nil {
+					continue
+				}
+				url := block.Source.Url
+				if block.Source.Type == "base64" {
+					url = "data:" + block.Source.MediaType + ";base64," + block.Source.Data
+				}
+				parts = append(parts, ContentPart{Type: ContentTypeImageUrl, ImageUrl: &ImageUrl{Url: url}})
+			case "tool_use":
+				arguments, _ := json.Marshal(block.Input)
+				if block.Input == nil {
+					arguments = []byte("{}")
+				}
+				calls = append(calls, ToolCall{Index: len(calls), ID: block.ID, Type: "function",
+					Function: FunctionCall{Name: block.Name, Arguments: string(arguments)}})
+			case "tool_result":
+				request.Messages = append(request.Messages, ChatMessage{Role: RoleTool, ToolCallID: block.ToolUseID, Content: string(block.Content)})
+			}
+		}
+		if len(parts) == 0 && len(calls) == 0 {
+			continue
+		}
+		request.Messages = append(request.Messages, ChatMessage{Role: message.Role, Content: chatContent(parts), ToolCalls: calls})
+	}
+	for _, tool := range r.Tools {
+		request.Tools = append(request.Tools, Tool{Type: "function", Function: FunctionDefinition{
+			Name: tool.Name, Description: tool.Description, Parameters: tool.InputSchema}})
+	}
+	if r.ToolChoice != nil {
+		switch r.ToolChoice.Type {
+		case "auto", "none":
+			request.ToolChoice = r.ToolChoice.Type
+		case "any":
+			request.ToolChoice = "required"
+		case "tool":
+			request.ToolChoice = map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": r.ToolChoice.Name}}
+		}
+	}
+	return request
+}
+
+func claudeFinishReason(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return FinishReasonLength
+	case "tool_use":
+		return FinishReasonToolCalls
+	case "":
+		return ""
+	}
+	return FinishReasonStop
+}
+
+func claudeToOpenAIResponse(response *claudeResponse) *ChatCompletionResponse {
+	message := &ChatMessage{Role: RoleAssistant}
+	var texts []string
+	for _, block := range response.Content {
+		switch block.Type {
+		case "text":
+			texts = append(texts, block.Text)
+		case "tool_use":
+			arguments, _ := json.Marshal(block.Input)
+			message.ToolCalls = append(message.ToolCalls, ToolCall{
+				Index:    len(message.ToolCalls),
+				ID:       block.ID,
+				Type:     "function",
+				Function: FunctionCall{Name: block.Name, Arguments: string(arguments)},
+			})
+		}
+	}
+	message.Content = strings.Join(texts, "")
+	return &ChatCompletionResponse{
+		ID:      response.ID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   response.Model,
+		Choices: []ChatCompletionChoice{{Message: message, FinishReason: claudeFinishReason(response.StopReason)}},
+		Usage: &Usage{
+			PromptTokens:     response.Usage.InputTokens,
+			CompletionTokens: response.Usage.OutputTokens,
+			TotalTokens:      response.Usage.InputTokens + response.Usage.OutputTokens,
+		},
+	}
+}
+
+func toClaudeStopReason(finishReason string) string {
+	switch finishReason {
+	case FinishReasonLength:
+		return "max_tokens"
+	case FinishReasonToolCalls:
+		return "tool_use"
+	case "":
+		return ""
+	}
+	return "end_turn"
+}
+
+// openAIToClaudeResponse converts the first choice of the response.
+func openAIToClaudeResponse(response *ChatCompletionResponse) *claudeResponse {
+	r := &claudeResponse{
+		ID:      response.ID,
+		Type:    "message",
+		Role:    RoleAssistant,
+		Model:   response.Model,
+		Content: []claudeContentBlock{},
+	}
+	if len(response.Choices) > 0 {
+		choice := response.Choices[0]
+		if message := choice.Message; message != nil {
+			if text := message.StringContent(); text != "" {
+				r.Content = append(r.Content, claudeContentBlock{Type: "text", Text: text})
+			}
+			for _, call := range message.ToolCalls {
+				r.Content = append(r.Content, claudeContentBlock{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: claudeToolInput(call)})
+			}
+		}
+		r.StopReason = toClaudeStopReason(choice.FinishReason)
+	}
+	if response.Usage != nil {
+		r.Usage = claudeUsage{InputTokens: response.Usage.PromptTokens, OutputTokens: response.Usage.CompletionTokens}
+	}
+	return r
+}
+
+func (claudeDialect) chatRequest(config *LLMProviderConfig, request *ChatCompletionRequest) (string, [][2]string, []byte, error) {
+	body, err := json.Marshal(openAIToClaudeRequest(request))
+	headers := [][2]string{
+		{"content-type", "application/json"},
+		{"anthropic-version", claudeApiVersion},
+		{"x-api-key", config.ApiKey},
+	}
+	return config.BasePath + "/messages", headers, body, err
+}
+
+func (claudeDialect) chatResponse(body []byte) (*ChatCompletionResponse, error) {
+	var response claudeResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("invalid claude response: %v", err)
+	}
+	return claudeToOpenAIResponse(&response), nil
+}
+
+func (claudeDialect) parseChatRequest(body []byte) (*ChatCompletionRequest, error) {
+	var request claudeRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("invalid claude request: %v", err)
+	}
+	return claudeToOpenAIRequest(&request), nil
+}
+
+func (claudeDialect) chatRequestBody(request *ChatCompletionRequest) ([]byte, error) {
+	return json.Marshal(openAIToClaudeRequest(request))
+}
+
+func (claudeDialect) chatResponseBody(response *ChatCompletionResponse) ([]byte, error) {
+	return json.Marshal(openAIToClaudeResponse(response))
+}
+
+func (claudeDialect) newStreamEncoder() ChatStreamEncoder {
+	return &claudeStreamEncoder{index: -1}
+}
+
+func (claudeDialect) newStreamDecoder() llmStreamDecoder {
+	return &claudeStreamDecoder{toolIndexes: map[int]int{}}
+}
+
+func (claudeDialect) embeddingsRequest(*LLMProviderConfig, *EmbeddingsRequest) (string, [][2]string, []byte, error) {
+	return "", nil, nil, ErrLLMUnsupported
+}
+
+func (claudeDialect) embeddingsResponse([]byte) (*EmbeddingsResponse, error) {
+	return nil, ErrLLMUnsupported
+}
+
+// claudeStreamDecoder keeps the message info of message_start, which the following chunks refer to.
+type claudeStreamDecoder struct {
+	id           string
+	model        string
+	created      int64
+	inputTokens  int
+	toolIndexes  map[int]int
+	nextToolCall int
+}
+
+func (d *claudeStreamDecoder) chunk(delta *ChatMessage, finishReason string) *ChatCompletionResponse {
+	return &ChatCompletionResponse{
+		ID:      d.id,
+		Object:  "chat.completion.chunk",
+		Created: d.created,
+		Model:   d.model,
+		Choices: []ChatCompletionChoice{{Delta: delta, FinishReason: finishReason}},
+	}
+}
+
+func (d *claudeStreamDecoder) decode(event SSEEvent) ([]*ChatCompletionResponse, bool, error) {
+	if event.Data == "" {
+		return nil, false, nil
+	}
+	var e claudeStreamEvent
+	if err := json.Unmarshal([]byte(event.Data), &e); err != nil {
+		return nil, false, fmt.Errorf("invalid claude stream event: %v", err)
+	}
+	switch e.Type {
+	case "message_start":
+		if e.Message != nil {
+			d.id, d.model, d.created = e.Message.ID, e.Message.Model, time.Now().Unix()
+			d.inputTokens = e.Message.Usage.InputTokens
+		}
+		return []*ChatCompletionResponse{d.chunk(&ChatMessage{Role: RoleAssistant, Content: ""}, "")}, false, nil
+	case "content_block_start":
+		if e.ContentBlock != nil && e.ContentBlock.Type == "tool_use" {
+			index := d.nextToolCall
+			d.toolIndexes[e.Index] = index
+			d.nextToolCall++
+			call := ToolCall{Index: index, ID: e.ContentBlock.ID, Type: "function", Function: FunctionCall{Name: e.ContentBlock.Name}}
+			return []*ChatCompletionResponse{d.chunk(&ChatMessage{ToolCalls: []ToolCall{call}}, "")}, false, nil
+		}
+	case "content_block_delta":
+		if e.Delta == nil {
+			break
+		}
+		switch e.Delta.Type {
+		case "text_delta":
+			return []*ChatCompletionResponse{d.chunk(&ChatMessage{Content: e.Delta.Text}, "")}, false, nil
+		case "input_json_delta":
+			call := ToolCall{Index: d.toolIndexes[e.Index], Function: FunctionCall{Arguments: e.Delta.PartialJson}}
+			return []*ChatCompletionResponse{d.chunk(&ChatMessage{ToolCalls: []ToolCall{call}}, "")}, false, nil
+		}
+	case "message_delta":
+		var finishReason string
+		if e.Delta != nil {
+			finishReason = claudeFinishReason(e.Delta.StopReason)
+		}
+		chunk := d.chunk(&ChatMessage{}, finishReason)
+		if e.Usage != nil {
+			// the input tokens may be reported at the end as well
+			if e.Usage.InputTokens > 0 {
+				d.inputTokens = e.Usage.InputTokens
+			}
+			chunk.Usage = &Usage{
+				PromptTokens:     d.inputTokens,
+				CompletionTokens: e.Usage.OutputTokens,
+				TotalTokens:      d.inputTokens + e.Usage.OutputTokens,
+			}
+		}
+		return []*ChatCompletionResponse{chunk}, false, nil
+	case "message_stop":
+		return nil, true, nil
+	case "error":
+		if e.Error != nil {
+			return nil, true, fmt.Errorf("claude stream error %s: %s", e.Error.Type, e.Error.Message)
+		}
+		return nil, true, fmt.Errorf("claude stream error: %s", event.Data)
+	}
+	return nil, false, nil
+}
+
+// claudeStreamEncoder converts the chunks into the events of the Messages API, the text and each tool call are sent
+// in their own content blocks, and the stop reason and usage are held until the stream finishes.
+type claudeStreamEncoder struct {
+	started  bool
+	finished bool
+	// the index and type of the open block
+	index     int
+	blockType string
+	// the index of the tool call of the open tool_use block
+	toolCall   int
+	stopReason string
+	usage      claudeUsage
+}
+
+func claudeEvent(data map[string]interface{}) []byte {
+	body, _ := json.Marshal(data)
+	return SSEEvent{Event: data["type"].(string), Data: string(body)}.Bytes()
+}
+
+func (e *claudeStreamEncoder) startBlock(buf *bytes.Buffer, block map[string]interface{}) {
+	e.stopBlock(buf)
+	e.index++
+	e.blockType = block["type"].(string)
+	buf.Write(claudeEvent(map[string]interface{}{"type": "content_block_start", "index": e.index, "content_block": block}))
+}
+
+func (e *claudeStreamEncoder) stopBlock(buf *bytes.Buffer) {
+	if e.blockType != "" {
+		buf.Write(claudeEvent(map[string]interface{}{"type": "content_block_stop", "index": e.index}))
+		e.blockType = ""
+	}
+}
+
+func (e *claudeStreamEncoder) Encode(chunk *ChatCompletionResponse) []byte {
+	var buf bytes.Buffer
+	if !e.started {
+		e.started = true
+		buf.Write(claudeEvent(map[string]interface{}{"type": "message_start", "message": map[string]interface{}{
+			"id": chunk.ID, "type": "message", "role": RoleAssistant, "model": chunk.Model, "content": []interface{}{},
+			"stop_reason": nil, "stop_sequence": nil, "usage": map[string]interface{}{"input_tokens": 0, "output_tokens": 0},
+		}}))
+	}
+	if chunk.Usage != nil {
+		e.usage = claudeUsage{InputTokens: chunk.Usage.PromptTokens, OutputTokens: chunk.Usage.CompletionTokens}
+	}
+	if len(chunk.Choices) == 0 {
+		return buf.Bytes()
+	}
+	choice := chunk.Choices[0]
+	if delta := choice.Delta; delta != nil {
+		if text := delta.StringContent(); text != "" {
+			if e.blockType != "text" {
+				e.startBlock(&buf, map[string]interface{}{"type": "text", "text": ""})
+			}
+			buf.Write(claudeEvent(map[string]interface{}{"type": "content_block_delta", "index": e.index,
+				"delta": map[string]interface{}{"type": "text_delta", "text": text}}))
+		}
+		for _, call := range delta.ToolCalls {
+			if e.blockType != "tool_use" || call.Index != e.toolCall {
+				e.toolCall = call.Index
+				e.startBlock(&buf, map[string]interface{}{"type": "tool_use", "id": call.ID, "name": call.Function.Name,
+					"input": map[string]interface{}{}})
+			}
+			if call.Function.Arguments != "" {
+				buf.Write(claudeEvent(map[string]interface{}{"type": "content_block_delta", "index": e.index,
+					"delta": map[string]interface{}{"type": "input_json_delta", "partial_json": call.Function.Arguments}}))
+			}
+		}
+	}
+	if choice.FinishReason != "" {
+		e.stopReason = toClaudeStopReason(choice.FinishReason)
+	}
+	return buf.Bytes()
+}
+
+func (e *claudeStreamEncoder) Finish() []byte {
+	if !e.started || e.finished {
+		return nil
+	}
+	e.finished = true
+	var buf bytes.Buffer
+	e.stopBlock(&buf)
+	stopReason := e.stopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	buf.Write(claudeEvent(map[string]interface{}{"type": "message_delta",
+		"delta": map[string]interface{}{"stop_reason": stopReason, "stop_sequence": nil},
+		"usage": map[string]interface{}{"input_tokens": e.usage.InputTokens, "output_tokens": e.usage.OutputTokens},
+	}))
+	buf.Write(claudeEvent(map[string]interface{}{"type": "message_stop"}))
+	return buf.Bytes()
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+type TrimStrategy string
+
+const (
+	// Drop the oldest messages until the prompt fits the budget
+	TrimDropOldest TrimStrategy = "drop_oldest"
+	// Keep the system messages and the last n messages, regardless of the budget
+	TrimKeepLastN TrimStrategy = "keep_last_n"
+	// Summarize the messages before the last n with a model, then drop the oldest if it still doesn't fit
+	TrimSummarize TrimStrategy = "summarize"
+)
+
+const defaultSummaryPrompt = "Summarize the conversation below in a few sentences, keeping the facts, decisions and " +
+	"open questions needed to continue it. Reply with the summary only."
+
+type ContextTrimConfig struct {
+	Strategy TrimStrategy
+	// The budget of the prompt tokens, the messages are not trimmed by drop_oldest and summarize if it's 0
+	MaxTokens int
+	// The recent messages kept by keep_last_n and summarize, default is 4
+	KeepLast int
+}
+
+func ParseContextTrimConfig(json gjson.Result) (ContextTrimConfig, error) {
+	config := ContextTrimConfig{
+		Strategy:  TrimStrategy(json.Get("strategy").String()),
+		MaxTokens: int(json.Get("maxTokens").Int()),
+		KeepLast:  int(json.Get("keepLast").Int()),
+	}
+	switch config.Strategy {
+	case "":
+		config.Strategy = TrimDropOldest
+	case TrimDropOldest, TrimKeepLastN, TrimSummarize:
+	default:
+		return config, fmt.Errorf("invalid trim strategy: %s", config.Strategy)
+	}
+	if config.KeepLast <= 0 {
+		config.KeepLast = 4
+	}
+	if config.Strategy != TrimKeepLastN && config.MaxTokens <= 0 {
+		return config, errors.New("maxTokens is required by the strategy")
+	}
+	return config, nil
+}
+
+// messageGroups splits the messages into the units trimmed together, an assistant message calling the tools and
+// the tool messages answering it are one unit, since a tool message without its call is rejected by the models.
+func messageGroups(messages []ChatMessage) [][2]int {
+	var groups [][2]int
+	for i := 0; i < len(messages); {
+		end := i + 1
+		if len(messages[i].ToolCalls) > 0 {
+			for end < len(messages) && messages[end].Role == RoleTool {
+				end++
+			}
+		}
+		groups = append(groups, [2]int{i, end})
+		i = end
+	}
+	return groups
+}
+
+func messageTokens(model string, message ChatMessage) int {
+	return CountMessagesTokens(model, []ChatMessage{message}) - tokensPerReply
+}
+
+// DropOldestMessages drops the oldest messages except the system ones until the messages fit the budget.
+// The last message is always kept, so the result may still exceed the budget.
+func DropOldestMessages(model string, messages []ChatMessage, budget int) []ChatMessage {
+	total := CountMessagesTokens(model, messages)
+	if total <= budget {
+		return messages
+	}
+	groups := messageGroups(messages)
+	dropped := make([]bool, len(messages))
+	// the last group is the current turn
+	for _, group := range groups[:len(groups)-1] {
+		if total <= budget {
+			break
+		}
+		if messages[group[0]].Role == RoleSystem {
+			continue
+		}
+		for i := group[0]; i < group[1]; i++ {
+			total -= messageTokens(model, messages[i])
+			dropped[i] = true
+		}
+	}
+	kept := make([]ChatMessage, 0, len(messages))
+	for i, message := range messages {
+		if !dropped[i] {
+			kept = append(kept, message)
+		}
+	}
+	return kept
+}
+
+// splitLastN returns the index where the last n messages except the system ones start, it's moved back to the
+// start of the group, so the kept tool messages have their calls.
+func splitLastN(messages []ChatMessage, n int) int {
+	groups := messageGroups(messages)
+	count := 0
+	for i := len(groups) - 1; i >= 0; i-- {
+		group := groups[i]
+		if messages[group[0]].Role == RoleSystem {
+			continue
+		}
+		count += group[1] - group[0]
+		if count >= n {
+			return group[0]
+		}
+	}
+	return 0
+}
+
+// KeepLastMessages keeps the system messages and the last n other messages.
+func KeepLastMessages(messages []ChatMessage, n int) []ChatMessage {
+	split := splitLastN(messages, n)
+	kept := make([]ChatMessage, 0, len(messages))
+	for i, message := range messages {
+		if i >= split || message.Role == RoleSystem {
+			kept = append(kept, message)
+		}
+	}
+	return kept
+}
+
+// ContextTrimmer fits the messages of the requests into the context window of the models.
+type ContextTrimmer struct {
+	config ContextTrimConfig
+	// Summarizer is required by the summarize strategy
+	Summarizer SessionSummarizer
+}
+
+func NewContextTrimmer(config ContextTrimConfig) *ContextTrimmer {
+	if config.KeepLast <= 0 {
+		config.KeepLast = 4
+	}
+	return &ContextTrimmer{config: config}
+}
+
+// Trim trims the messages of the request in place, the callback is called synchronously unless the messages
+// are summarized, and it's not called if an error is returned.
+func (t *ContextTrimmer) Trim(request *ChatCompletionRequest, callback func(trimmed bool, err error)) error {
+	count := len(request.Messages)
+	switch t.config.Strategy {
+	case TrimKeepLastN:
+		request.Messages = KeepLastMessages(request.Messages, t.config.KeepLast)
+	case TrimSummarize:
+		if t.config.MaxTokens > 0 && CountMessagesTokens(request.Model, request.Messages) > t.config.MaxTokens {
+			return t.summarize(request, callback)
+		}
+	default:
+		if t.config.MaxTokens > 0 {
+			request.Messages = DropOldestMessages(request.Model, request.Messages, t.config.MaxTokens)
+		}
+	}
+	callback(len(request.Messages) != count, nil)
+	return nil
+}
+
+func (t *ContextTrimmer) summarize(request *ChatCompletionRequest, callback func(bool, error)) error {
+	if t.Summarizer == nil {
+		return errors.New("summarizer is not set")
+	}
+	split := splitLastN(request.Messages, t.config.KeepLast)
+	var system, older []ChatMessage
+	for _, message := range request.Messages[:split] {
+		if message.Role == RoleSystem {
+			system = append(system, message)
+		} else {
+			older = append(older, message)
+		}
+	}
+	if len(older) == 0 {
+		request.Messages = DropOldestMessages(request.Model, request.Messages, t.config.MaxTokens)
+		callback(true, nil)
+		return nil
+	}
+	recent := request.Messages[split:]
+	return t.Summarizer(older, func(summary *ChatMessage, err error) {
+		if err != nil {
+			callback(false, err)
+			return
+		}
+		messages := make([]ChatMessage, 0, len(system)+1+len(recent))
+		messages = append(messages, system...)
+		messages = append(messages, *summary)
+		messages = append(messages, recent...)
+		request.Messages = DropOldestMessages(request.Model, messages, t.config.MaxTokens)
+		callback(true, nil)
+	})
+}
+
+// LLMSummarizer summarizes the messages with a cheap model of the provider, the summary is a system message.
+func LLMSummarizer(provider LLMProvider, model string, maxTokens int) SessionSummarizer {
+	return func(messages []ChatMessage, callback func(*ChatMessage, error)) error {
+		var transcript strings.Builder
+		for _, message := range messages {
+			if text := message.StringContent(); text != "" {
+				transcript.WriteString(message.Role + ": " + text + "\n")
+			}
+		}
+		request := &ChatCompletionRequest{
+			Model:     model,
+			MaxTokens: maxTokens,
+			Messages: []ChatMessage{
+				{Role: RoleSystem, Content: defaultSummaryPrompt},
+				{Role: RoleUser, Content: transcript.String()},
+			},
+		}
+		return provider.ChatCompletion(request, func(response *ChatCompletionResponse, err error) {
+			if err != nil {
+				callback(nil, err)
+				return
+			}
+			callback(SessionSummaryMessage(response.Content()), nil)
+		})
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func trimTestMessages() []ChatMessage {
+	long := strings.Repeat("lorem ipsum ", 50)
+	return []ChatMessage{
+		{Role: RoleSystem, Content: "sys"},
+		{Role: RoleUser, Content: "q1 " + long},
+		{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "call_1", Type: "function", Function: FunctionCall{Name: "search", Arguments: "{}"}}}},
+		{Role: RoleTool, ToolCallID: "call_1", Content: long},
+		{Role: RoleAssistant, Content: "a1"},
+		{Role: RoleUser, Content: "q2"},
+	}
+}
+
+func messageContents(messages []ChatMessage) []string {
+	var contents []string
+	for _, message := range messages {
+		content := message.StringContent()
+		if i := strings.IndexByte(content, ' '); i > 0 {
+			content = content[:i]
+		}
+		if len(message.ToolCalls) > 0 {
+			content = "call"
+		}
+		if message.Role == RoleTool {
+			content = "tool"
+		}
+		contents = append(contents, content)
+	}
+	return contents
+}
+
+func TestDropOldestMessages(t *testing.T) {
+	messages := trimTestMessages()
+	assert.Equal(t, messages, DropOldestMessages("gpt-4", messages, 10000))
+
+	// the tool call and its result are dropped together
+	trimmed := DropOldestMessages("gpt-4", messages, 60)
+	assert.Equal(t, []string{"sys", "a1", "q2"}, messageContents(trimmed))
+
+	// the last message is kept even if it exceeds the budget
+	trimmed = DropOldestMessages("gpt-4", messages, 1)
+	assert.Equal(t, []string{"sys", "q2"}, messageContents(trimmed))
+}
+
+func TestKeepLastMessages(t *testing.T) {
+	messages := trimTestMessages()
+	assert.Equal(t, []string{"sys", "a1", "q2"}, messageContents(KeepLastMessages(messages, 2)))
+	// extended to the tool call of the kept tool message
+	assert.Equal(t, []string{"sys", "call", "tool", "a1", "q2"}, messageContents(KeepLastMessages(messages, 3)))
+	assert.Len(t, KeepLastMessages(messages, 10), len(messages))
+}
+
+func TestContextTrimmerSummarize(t *testing.T) {
+	config, err := ParseContextTrimConfig(gjson.Parse(`{"strategy": "summarize", "maxTokens": 200, "keepLast": 2}`))
+	require.NoError(t, err)
+	trimmer := NewContextTrimmer(config)
+	var summarized []ChatMessage
+	trimmer.Summarizer = func(messages []ChatMessage, callback func(*ChatMessage, error)) error {
+		summarized = messages
+		callback(SessionSummaryMessage("earlier"), nil)
+		return nil
+	}
+	request := &ChatCompletionRequest{Model: "gpt-4", Messages: trimTestMessages()}
+	called := false
+	require.NoError(t, trimmer.Trim(request, func(trimmed bool, err error) {
+		require.NoError(t, err)
+		assert.True(t, trimmed)
+		called = true
+	}))
+	assert.True(t, called)
+	assert.Equal(t, []string{"q1", "call", "tool"}, messageContents(summarized))
+	assert.Equal(t, []string{"sys", "Summary", "a1", "q2"}, messageContents(request.Messages))
+
+	_, err = ParseContextTrimConfig(gjson.Parse(`{"strategy": "summarize"}`))
+	assert.Error(t, err)
+	_, err = ParseContextTrimConfig(gjson.Parse(`{"strategy": "random"}`))
+	assert.Error(t, err)
+	config, err = ParseContextTrimConfig(gjson.Parse(`{"strategy": "keep_last_n", "keepLast": 1}`))
+	require.NoError(t, err)
+	request = &ChatCompletionRequest{Messages: trimTestMessages()}
+	require.NoError(t, NewContextTrimmer(config).Trim(request, func(trimmed bool, err error) { assert.True(t, trimmed) }))
+	assert.Equal(t, []string{"sys", "q2"}, messageContents(request.Messages))
+}
+
+type fakeChatProvider struct {
+	LLMProvider
+	request *ChatCompletionRequest
+}
+
+func (p *fakeChatProvider) ChatCompletion(request *ChatCompletionRequest, callback func(*ChatCompletionResponse, error)) error {
+	p.request = request
+	callback(&ChatCompletionResponse{Choices: []ChatCompletionChoice{{Message: &ChatMessage{Role: RoleAssistant, Content: "they talked"}}}}, nil)
+	return nil
+}
+
+func TestLLMSummarizer(t *testing.T) {
+	provider := &fakeChatProvider{}
+	var summary *ChatMessage
+	err := LLMSummarizer(provider, "qwen-turbo", 256)(trimTestMessages()[4:], func(message *ChatMessage, err error) {
+		require.NoError(t, err)
+		summary = message
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "qwen-turbo", provider.request.Model)
+	assert.Equal(t, "assistant: a1\nuser: q2\n", provider.request.Messages[1].StringContent())
+	assert.Equal(t, RoleSystem, summary.Role)
+	assert.Contains(t, summary.StringContent(), "they talked")
+}
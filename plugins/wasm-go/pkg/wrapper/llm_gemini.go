@@ -0,0 +1,577 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type geminiRequest struct {
+	Contents          []geminiContent         `json:"contents"`
+	SystemInstruction *geminiContent          `json:"systemInstruction,omitempty"`
+	Tools             []geminiTool            `json:"tools,omitempty"`
+	GenerationConfig  *geminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	InlineData       *geminiBlob             `json:"inlineData,omitempty"`
+	FileData         *geminiFileData         `json:"fileData,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiFileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileUri  string `json:"fileUri"`
+}
+
+type geminiFunctionCall struct {
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []FunctionDefinition `json:"functionDeclarations"`
+}
+
+type geminiGenerationConfig struct {
+	MaxOutputTokens  int      `json:"maxOutputTokens,omitempty"`
+	Temperature      float64  `json:"temperature,omitempty"`
+	TopP             float64  `json:"topP,omitempty"`
+	StopSequences    []string `json:"stopSequences,omitempty"`
+	CandidateCount   int      `json:"candidateCount,omitempty"`
+	ResponseMimeType string   `json:"responseMimeType,omitempty"`
+}
+
+type geminiResponse struct {
+	ResponseID    string               `json:"responseId,omitempty"`
+	ModelVersion  string               `json:"modelVersion,omitempty"`
+	Candidates    []geminiCandidate    `json:"candidates"`
+	UsageMetadata *geminiUsageMetadata `json:"usageMetadata,omitempty"`
+}
+
+type geminiUsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
+
+type geminiCandidate struct {
+	Index        int           `json:"index"`
+	Content      geminiContent `json:"content"`
+	FinishReason string        `json:"finishReason,omitempty"`
+}
+
+type geminiEmbeddingsRequest struct {
+	Requests []geminiEmbedContentRequest `json:"requests"`
+}
+
+type geminiEmbedContentRequest struct {
+	Model                string        `json:"model"`
+	Content              geminiContent `json:"content"`
+	OutputDimensionality int           `json:"outputDimensionality,omitempty"`
+}
+
+type geminiEmbeddingsResponse struct {
+	Embeddings []struct {
+		Values []float64 `json:"values"`
+	} `json:"embeddings"`
+}
+
+type geminiDialect struct{}
+
+func (geminiDialect) defaultBasePath() string {
+	return "/v1beta"
+}
+
+func geminiParts(message ChatMessage) []geminiPart {
+	var parts []geminiPart
+	for _, part := range message.ContentParts() {
+		switch part.Type {
+		case ContentTypeText:
+			if part.Text != "" {
+				parts = append(parts, geminiPart{Text: part.Text})
+			}
+		case ContentTypeImageUrl:
+			if part.ImageUrl == nil {
+				continue
+			}
+			if mimeType, data, ok := parseDataUrl(part.ImageUrl.Url); ok {
+				parts = append(parts, geminiPart{InlineData: &geminiBlob{MimeType: mimeType, Data: data}})
+			} else {
+				parts = append(parts, geminiPart{FileData: &geminiFileData{FileUri: part.ImageUrl.Url}})
+			}
+		}
+	}
+	return parts
+}
+
+// openAIToGeminiRequest converts the request, the model is not part of the body but of the path.
+func openAIToGeminiRequest(request *ChatCompletionRequest) *geminiRequest {
+	r := &geminiRequest{}
+	// the function response refers to the function by name instead of the call id
+	callNames := map[string]string{}
+	var system []geminiPart
+	for _, message := range request.Messages {
+		switch message.Role {
+		case RoleSystem:
+			system = append(system, geminiParts(message)...)
+		case RoleTool:
+			part := geminiPart{FunctionResponse: &geminiFunctionResponse{
+				Name:     callNames[message.ToolCallID],
+				Response: map[string]interface{}{"content": message.StringContent()},
+			}}
+			if n := len(r.Contents); n > 0 && r.Contents[n-1].Role == "function" {
+				r.Contents[n-1].Parts = append(r.Contents[n-1].Parts, part)
+			} else {
+				r.Contents = append(r.Contents, geminiContent{Role: "function", Parts: []geminiPart{part}})
+			}
+		default:
+			parts := geminiParts(message)
+			for _, call := range message.ToolCalls {
+				callNames[call.ID] = call.Function.Name
+				var args map[string]interface{}
+				_ = json.Unmarshal([]byte(call.Function.Arguments), &args)
+				parts = append(parts, geminiPart{FunctionCall: &geminiFunctionCall{Name: call.Function.Name, Args: args}})
+			}
+			role := "user"
+			if message.Role == RoleAssistant {
+				role = "model"
+			}
+			r.Contents = append(r.Contents, geminiContent{Role: role, Parts: parts})
+		}
+	}
+	if len(system) > 0 {
+		r.SystemInstruction = &geminiContent{Parts: system}
+	}
+	if len(request.Tools) > 0 {
+		var declarations []FunctionDefinition
+		for _, tool := range request.Tools {
+			declarations = append(declarations, tool.Function)
+		}
+		r.Tools = []geminiTool{{FunctionDeclarations: declarations}}
+	}
+	config := geminiGenerationConfig{
+		MaxOutputTokens: request.MaxTokens,
+		Temperature:     request.Temperature,
+		TopP:            request.TopP,
+		StopSequences:   request.Stop,
+		CandidateCount:  request.N,
+	}
+	if t, _ := request.ResponseFormat["type"].(string); t == "json_object" || t == "json_schema" {
+		config.ResponseMimeType = "application/json"
+	}
+	if config.MaxOutputTokens > 0 || config.Temperature > 0 || config.TopP > 0 || len(config.StopSequences) > 0 ||
+		config.CandidateCount > 0 || config.ResponseMimeType != "" {
+		r.GenerationConfig = &config
+	}
+	return r
+}
+
+// geminiToOpenAIRequest converts a request of the generateContent API, the model is taken from the path by
+// ParseGeminiPath. The function responses refer to the calls by name, they are given the ids of the earliest
+// unanswered calls of the same name.
+func geminiToOpenAIRequest(r *geminiRequest) *ChatCompletionRequest {
+	request := &ChatCompletionRequest{}
+	if config := r.GenerationConfig; config != nil {
+		request.MaxTokens = config.MaxOutputTokens
+		request.Temperature = config.Temperature
+		request.TopP = config.TopP
+		request.Stop = config.StopSequences
+		request.N = config.CandidateCount
+		if config.ResponseMimeType == "application/json" {
+			request.ResponseFormat = map[string]interface{}{"type": "json_object"}
+		}
+	}
+	if r.SystemInstruction != nil {
+		var texts []string
+		for _, part := range r.SystemInstruction.Parts {
+			if part.Text != "" {
+				texts = append(texts, part.Text)
+			}
+		}
+		request.Messages = append(request.Messages, ChatMessage{Role: RoleSystem, Content: strings.Join(texts, "\n")})
+	}
+	pendingCalls := map[string][]string{}
+	count := 0
+	for _, content := range r.Contents {
+		var parts []ContentPart
+		var calls []ToolCall
+		for _, part := range content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				name := part.FunctionCall.Name
+				id := fmt.Sprintf("call_%s_%d", name, count)
+				count++
+				pendingCalls[name] = append(pendingCalls[name], id)
+				arguments := []byte("{}")
+				if part.FunctionCall.Args != nil {
+					arguments, _ = json.Marshal(part.FunctionCall.Args)
+				}
+				calls = append(calls, ToolCall{Index: len(calls), ID: id, Type: "function", Function: FunctionCall{Name: name, Arguments: string(arguments)}})
+			case part.FunctionResponse != nil:
+				name := part.FunctionResponse.Name
+				var id string
+				if ids := pendingCalls[name]; len(ids) > 0 {
+					id, pendingCalls[name] = ids[0], ids[1:]
+				}
+				result, ok := part.FunctionResponse.Response["content"].(string)
+				if !ok {
+					data, _ := json.Marshal(part.FunctionResponse.Response)
+					result = string(data)
+				}
+				request.Messages = append(request.Messages, ChatMessage{Role: RoleTool, ToolCallID: id, Content: result})
+			case part.InlineData != nil:
+				if format := strings.TrimPrefix(part.InlineData.MimeType, "audio/"); format != part.InlineData.MimeType {
+					parts = append(parts, ContentPart{Type: ContentTypeInputAudio, InputAudio: &InputAudio{Data: part.InlineData.Data, Format: format}})
+				} else {
+					parts = append(parts, ContentPart{Type: ContentTypeImageUrl, ImageUrl: &ImageUrl{Url: "data:" + part.InlineData.MimeType + ";base64," + part.InlineData.Data}})
+				}
+			case part.FileData != nil:
+				parts = append(parts, ContentPart{Type: ContentTypeImageUrl, ImageUrl: &ImageUrl{Url: part.FileData.FileUri}})
+			case part.Text != "":
+				parts = append(parts, ContentPart{Type: ContentTypeText, Text: part.Text})
+			}
+		}
+		if len(parts) == 0 && len(calls) == 0 {
+			continue
+		}
+		role := RoleUser
+		if content.Role == "model" {
+			role = RoleAssistant
+		}
+		request.Messages = append(request.Messages, ChatMessage{Role: role, Content: chatContent(parts), ToolCalls: calls})
+	}
+	for _, tool := range r.Tools {
+		for _, declaration := range tool.FunctionDeclarations {
+			request.Tools = append(request.Tools, Tool{Type: "function", Function: declaration})
+		}
+	}
+	return request
+}
+
+func geminiFinishReason(reason string) string {
+	switch reason {
+	case "":
+		return ""
+	case "MAX_TOKENS":
+		return FinishReasonLength
+	case "STOP":
+		return FinishReasonStop
+	}
+	return "content_filter"
+}
+
+// geminiToOpenAIResponse converts a response or a chunk of the stream.
+func geminiToOpenAIResponse(response *geminiResponse, chunk bool) *ChatCompletionResponse {
+	r := &ChatCompletionResponse{
+		ID:      response.ResponseID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   response.ModelVersion,
+	}
+	if chunk {
+		r.Object = "chat.completion.chunk"
+	}
+	if r.ID == "" {
+		r.ID = "chatcmpl-" + uuid.New().String()
+	}
+	for _, candidate := range response.Candidates {
+		message := &ChatMessage{Role: RoleAssistant}
+		var texts []string
+		for _, part := range candidate.Content.Parts {
+			if part.FunctionCall != nil {
+				arguments, _ := json.Marshal(part.FunctionCall.Args)
+				message.ToolCalls = append(message.ToolCalls, ToolCall{
+					Index:    len(message.ToolCalls),
+					ID:       fmt.Sprintf("call_%s_%d", part.FunctionCall.Name, len(message.ToolCalls)),
+					Type:     "function",
+					Function: FunctionCall{Name: part.FunctionCall.Name, Arguments: string(arguments)},
+				})
+			} else {
+				texts = append(texts, part.Text)
+			}
+		}
+		message.Content = strings.Join(texts, "")
+		finishReason := geminiFinishReason(candidate.FinishReason)
+		if len(message.ToolCalls) > 0 && finishReason == FinishReasonStop {
+			finishReason = FinishReasonToolCalls
+		}
+		choice := ChatCompletionChoice{Index: candidate.Index, FinishReason: finishReason}
+		if chunk {
+			choice.Delta = message
+		} else {
+			choice.Message = message
+		}
+		r.Choices = append(r.Choices, choice)
+	}
+	if response.UsageMetadata != nil {
+		r.Usage = &Usage{
+			PromptTokens:     response.UsageMetadata.PromptTokenCount,
+			CompletionTokens: response.UsageMetadata.CandidatesTokenCount,
+			TotalTokens:      response.UsageMetadata.TotalTokenCount,
+		}
+	}
+	return r
+}
+
+func toGeminiFinishReason(finishReason string) string {
+	switch finishReason {
+	case "":
+		return ""
+	case FinishReasonLength:
+		return "MAX_TOKENS"
+	case "content_filter":
+		return "SAFETY"
+	}
+	return "STOP"
+}
+
+func geminiResponseParts(message *ChatMessage) []geminiPart {
+	parts := []geminiPart{}
+	if message == nil {
+		return parts
+	}
+	if text := message.StringContent(); text != "" {
+		parts = append(parts, geminiPart{Text: text})
+	}
+	for _, call := range message.ToolCalls {
+		var args map[string]interface{}
+		_ = json.Unmarshal([]byte(call.Function.Arguments), &args)
+		parts = append(parts, geminiPart{FunctionCall: &geminiFunctionCall{Name: call.Function.Name, Args: args}})
+	}
+	return parts
+}
+
+func toGeminiUsage(usage *Usage) *geminiUsageMetadata {
+	if usage == nil {
+		return nil
+	}
+	return &geminiUsageMetadata{
+		PromptTokenCount:     usage.PromptTokens,
+		CandidatesTokenCount: usage.CompletionTokens,
+		TotalTokenCount:      usage.TotalTokens,
+	}
+}
+
+func openAIToGeminiResponse(response *ChatCompletionResponse) *geminiResponse {
+	r := &geminiResponse{
+		ResponseID:    response.ID,
+		ModelVersion:  response.Model,
+		Candidates:    []geminiCandidate{},
+		UsageMetadata: toGeminiUsage(response.Usage),
+	}
+	for _, choice := range response.Choices {
+		r.Candidates = append(r.Candidates, geminiCandidate{
+			Index:        choice.Index,
+			Content:      geminiContent{Role: "model", Parts: geminiResponseParts(choice.Message)},
+			FinishReason: toGeminiFinishReason(choice.FinishReason),
+		})
+	}
+	return r
+}
+
+func (geminiDialect) headers(config *LLMProviderConfig) [][2]string {
+	return [][2]string{
+		{"content-type", "application/json"},
+		{"x-goog-api-key", config.ApiKey},
+	}
+}
+
+func (d geminiDialect) chatRequest(config *LLMProviderConfig, request *ChatCompletionRequest) (string, [][2]string, []byte, error) {
+	body, err := json.Marshal(openAIToGeminiRequest(request))
+	path := config.BasePath + "/models/" + request.Model + ":generateContent"
+	if request.Stream {
+		path = config.BasePath + "/models/" + request.Model + ":streamGenerateContent?alt=sse"
+	}
+	return path, d.headers(config), body, err
+}
+
+func (geminiDialect) chatResponse(body []byte) (*ChatCompletionResponse, error) {
+	var response geminiResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("invalid gemini response: %v", err)
+	}
+	return geminiToOpenAIResponse(&response, false), nil
+}
+
+func (geminiDialect) parseChatRequest(body []byte) (*ChatCompletionRequest, error) {
+	var request geminiRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("invalid gemini request: %v", err)
+	}
+	return geminiToOpenAIRequest(&request), nil
+}
+
+func (geminiDialect) chatRequestBody(request *ChatCompletionRequest) ([]byte, error) {
+	return json.Marshal(openAIToGeminiRequest(request))
+}
+
+func (geminiDialect) chatResponseBody(response *ChatCompletionResponse) ([]byte, error) {
+	return json.Marshal(openAIToGeminiResponse(response))
+}
+
+func (geminiDialect) newStreamEncoder() ChatStreamEncoder {
+	return &geminiStreamEncoder{calls: map[int][]*ToolCall{}}
+}
+
+func (geminiDialect) newStreamDecoder() llmStreamDecoder {
+	return &geminiStreamDecoder{}
+}
+
+func (d geminiDialect) embeddingsRequest(config *LLMProviderConfig, request *EmbeddingsRequest) (string, [][2]string, []byte, error) {
+	model := "models/" + strings.TrimPrefix(request.Model, "models/")
+	r := geminiEmbeddingsRequest{}
+	for _, input := range request.Input {
+		r.Requests = append(r.Requests, geminiEmbedContentRequest{
+			Model:                model,
+			Content:              geminiContent{Parts: []geminiPart{{Text: input}}},
+			OutputDimensionality: request.Dimensions,
+		})
+	}
+	body, err := json.Marshal(r)
+	return config.BasePath + "/" + model + ":batchEmbedContents", d.headers(config), body, err
+}
+
+func (geminiDialect) embeddingsResponse(body []byte) (*EmbeddingsResponse, error) {
+	var response geminiEmbeddingsResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("invalid gemini embeddings response: %v", err)
+	}
+	r := &EmbeddingsResponse{Object: "list"}
+	for i, embedding := range response.Embeddings {
+		r.Data = append(r.Data, Embedding{Object: "embedding", Index: i, Embedding: embedding.Values})
+	}
+	return r, nil
+}
+
+// geminiStreamDecoder keeps the id of the first chunk, so all the chunks share it.
+type geminiStreamDecoder struct {
+	id string
+}
+
+func (d *geminiStreamDecoder) decode(event SSEEvent) ([]*ChatCompletionResponse, bool, error) {
+	if event.Data == "" {
+		return nil, false, nil
+	}
+	var response geminiResponse
+	if err := json.Unmarshal([]byte(event.Data), &response); err != nil {
+		return nil, false, fmt.Errorf("invalid gemini stream chunk: %v", err)
+	}
+	chunk := geminiToOpenAIResponse(&response, true)
+	if d.id == "" {
+		d.id = chunk.ID
+	}
+	chunk.ID = d.id
+	return []*ChatCompletionResponse{chunk}, false, nil
+}
+
+// geminiStreamEncoder converts the chunks into the chunks of streamGenerateContent. A function call is sent whole
+// in Gemini, so the tool calls are held until the choice finishes.
+type geminiStreamEncoder struct {
+	calls map[int][]*ToolCall
+}
+
+func (e *geminiStreamEncoder) addCall(choice int, delta ToolCall) {
+	for _, call := range e.calls[choice] {
+		if call.Index == delta.Index {
+			call.Function.Arguments += delta.Function.Arguments
+			return
+		}
+	}
+	call := delta
+	e.calls[choice] = append(e.calls[choice], &call)
+}
+
+func (e *geminiStreamEncoder) takeCalls(choice int) []geminiPart {
+	calls := e.calls[choice]
+	delete(e.calls, choice)
+	var message ChatMessage
+	for _, call := range calls {
+		message.ToolCalls = append(message.ToolCalls, *call)
+	}
+	return geminiResponseParts(&message)
+}
+
+func (e *geminiStreamEncoder) Encode(chunk *ChatCompletionResponse) []byte {
+	r := &geminiResponse{
+		ResponseID:    chunk.ID,
+		ModelVersion:  chunk.Model,
+		Candidates:    []geminiCandidate{},
+		UsageMetadata: toGeminiUsage(chunk.Usage),
+	}
+	for _, choice := range chunk.Choices {
+		candidate := geminiCandidate{Index: choice.Index, Content: geminiContent{Role: "model", Parts: []geminiPart{}}}
+		if delta := choice.Delta; delta != nil {
+			if text := delta.StringContent(); text != "" {
+				candidate.Content.Parts = append(candidate.Content.Parts, geminiPart{Text: text})
+			}
+			for _, call := range delta.ToolCalls {
+				e.addCall(choice.Index, call)
+			}
+		}
+		if choice.FinishReason != "" {
+			candidate.Content.Parts = append(candidate.Content.Parts, e.takeCalls(choice.Index)...)
+			candidate.FinishReason = toGeminiFinishReason(choice.FinishReason)
+		}
+		if len(candidate.Content.Parts) > 0 || candidate.FinishReason != "" {
+			r.Candidates = append(r.Candidates, candidate)
+		}
+	}
+	if len(r.Candidates) == 0 && r.UsageMetadata == nil {
+		return nil
+	}
+	body, _ := json.Marshal(r)
+	return SSEEvent{Data: string(body)}.Bytes()
+}
+
+// Finish sends the tool calls of the choices which never finished.
+func (e *geminiStreamEncoder) Finish() []byte {
+	if len(e.calls) == 0 {
+		return nil
+	}
+	r := &geminiResponse{}
+	for choice := range e.calls {
+		r.Candidates = append(r.Candidates, geminiCandidate{Index: choice, Content: geminiContent{Role: "model"}, FinishReason: "STOP"})
+	}
+	sort.Slice(r.Candidates, func(i, j int) bool { return r.Candidates[i].Index < r.Candidates[j].Index })
+	for i := range r.Candidates {
+		r.Candidates[i].Content.Parts = e.takeCalls(r.Candidates[i].Index)
+	}
+	body, _ := json.Marshal(r)
+	return SSEEvent{Data: string(body)}.Bytes()
+}
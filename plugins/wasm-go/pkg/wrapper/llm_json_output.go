@@ -0,0 +1,216 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	JSONRepairCodeFence     = "code_fence"
+	JSONRepairExtractBlock  = "extract_block"
+	JSONRepairTrailingComma = "trailing_comma"
+)
+
+// The repairs are tried in order and accumulated until the output is valid json
+var jsonRepairs = []struct {
+	name   string
+	repair func(string) string
+}{
+	{JSONRepairCodeFence, stripCodeFence},
+	{JSONRepairExtractBlock, extractLargestJSONBlock},
+	{JSONRepairTrailingComma, removeTrailingCommas},
+}
+
+type JSONOutputOptions struct {
+	// The schema of the output, only the json syntax is checked if it's nil
+	Schema *JSONSchema
+	// Don't repair the output if it's false
+	Repair bool
+	// The outputs longer than the bytes are rejected without repairing, default is 1MB
+	MaxLength int
+}
+
+// JSONOutputResult tells whether the model output is valid, and how it's repaired.
+type JSONOutputResult struct {
+	Valid bool
+	// The repaired output if it's valid json, otherwise the original output
+	Output string
+	// The names of the repairs applied
+	Repairs []string
+	// The syntax error or the schema violations
+	Errors []string
+}
+
+func (r JSONOutputResult) Repaired() bool {
+	return len(r.Repairs) > 0
+}
+
+// Feedback returns the message asking the model to correct the output, it can be appended to the messages for a retry.
+func (r JSONOutputResult) Feedback() string {
+	return "Your previous reply is not valid JSON matching the required schema:\n- " + strings.Join(r.Errors, "\n- ") +
+		"\nReply with the corrected JSON only, without any explanation or code fence."
+}
+
+// ValidateJSONOutput checks the model output of the json mode, applying the bounded repairs if it's not valid json.
+// The repairs never change the values, so an output violating the schema is not fixed by them.
+func ValidateJSONOutput(output string, options JSONOutputOptions) JSONOutputResult {
+	if options.MaxLength <= 0 {
+		options.MaxLength = 1 << 20
+	}
+	result := JSONOutputResult{Output: output}
+	if len(output) > options.MaxLength {
+		result.Errors = []string{fmt.Sprintf("output exceeds %d bytes", options.MaxLength)}
+		return result
+	}
+	var value interface{}
+	err := json.Unmarshal([]byte(output), &value)
+	if err != nil && options.Repair {
+		repaired := output
+		var applied []string
+		for _, r := range jsonRepairs {
+			next := r.repair(repaired)
+			if next == repaired {
+				continue
+			}
+			repaired = next
+			applied = append(applied, r.name)
+			if err = json.Unmarshal([]byte(repaired), &value); err == nil {
+				result.Output, result.Repairs = repaired, applied
+				break
+			}
+		}
+	}
+	if err != nil {
+		result.Errors = []string{"invalid json: " + err.Error()}
+		return result
+	}
+	if options.Schema != nil {
+		result.Errors = options.Schema.Validate(value)
+	}
+	result.Valid = len(result.Errors) == 0
+	return result
+}
+
+// stripCodeFence returns the content of the first markdown code fence.
+func stripCodeFence(text string) string {
+	start := strings.Index(text, "```")
+	if start < 0 {
+		return text
+	}
+	body := text[start+3:]
+	// skip the language tag
+	if i := strings.IndexByte(body, '\n'); i >= 0 {
+		body = body[i+1:]
+	}
+	if end := strings.Index(body, "```"); end >= 0 {
+		body = body[:end]
+	}
+	return strings.TrimSpace(body)
+}
+
+// extractLargestJSONBlock returns the largest balanced object or array in the text, the brackets in the strings
+// are skipped.
+func extractLargestJSONBlock(text string) string {
+	best := ""
+	for i := 0; i < len(text); i++ {
+		if text[i] != '{' && text[i] != '[' {
+			continue
+		}
+		end := matchJSONBracket(text, i)
+		if end < 0 {
+			continue
+		}
+		if end-i+1 > len(best) {
+			best = text[i : end+1]
+		}
+		// the blocks inside are smaller
+		i = end
+	}
+	if best == "" {
+		return text
+	}
+	return best
+}
+
+// matchJSONBracket returns the index of the bracket closing the one at start, or -1 if it's not closed.
+func matchJSONBracket(text string, start int) int {
+	var stack []byte
+	inString, escaped := false, false
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{':
+			stack = append(stack, '}')
+		case '[':
+			stack = append(stack, ']')
+		case '}', ']':
+			if len(stack) == 0 || stack[len(stack)-1] != c {
+				return -1
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// removeTrailingCommas removes the commas before the closing brackets outside the strings.
+func removeTrailingCommas(text string) string {
+	var buf strings.Builder
+	inString, escaped := false, false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+		} else if c == '"' {
+			inString = true
+		} else if c == ',' {
+			j := i + 1
+			for j < len(text) && strings.IndexByte(" \t\r\n", text[j]) >= 0 {
+				j++
+			}
+			if j < len(text) && (text[j] == '}' || text[j] == ']') {
+				continue
+			}
+		}
+		buf.WriteByte(c)
+	}
+	return buf.String()
+}
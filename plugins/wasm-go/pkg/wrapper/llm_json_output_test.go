@@ -0,0 +1,62 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestValidateJSONOutputRepair(t *testing.T) {
+	schema, err := ParseJSONSchema(gjson.Parse(`{"type": "object", "required": ["answer"]}`))
+	require.NoError(t, err)
+	options := JSONOutputOptions{Schema: schema, Repair: true}
+
+	result := ValidateJSONOutput(`{"answer": 42}`, options)
+	assert.True(t, result.Valid)
+	assert.False(t, result.Repaired())
+
+	result = ValidateJSONOutput("Sure!\n```json\n{\"answer\": 42, \"list\": [1, 2,],}\n```\nHope it helps.", options)
+	assert.True(t, result.Valid)
+	assert.Equal(t, []string{JSONRepairCodeFence, JSONRepairTrailingComma}, result.Repairs)
+	assert.Equal(t, `{"answer": 42, "list": [1, 2]}`, result.Output)
+
+	result = ValidateJSONOutput(`The result is {"note": "use {x}"} or {"answer": {"text": "a, ]"}} as you like.`, options)
+	assert.True(t, result.Valid)
+	assert.Equal(t, []string{JSONRepairExtractBlock}, result.Repairs)
+	assert.Equal(t, `{"answer": {"text": "a, ]"}}`, result.Output)
+
+	result = ValidateJSONOutput(`{"result": 42}`, options)
+	assert.False(t, result.Valid)
+	assert.Equal(t, []string{"$: missing required property answer"}, result.Errors)
+	assert.Contains(t, result.Feedback(), "missing required property answer")
+
+	result = ValidateJSONOutput(`{"answer": 42,}`, JSONOutputOptions{})
+	assert.False(t, result.Valid)
+	assert.Equal(t, `{"answer": 42,}`, result.Output)
+
+	result = ValidateJSONOutput(`{"answer": "`+strings.Repeat("a", 100)+`"}`, JSONOutputOptions{MaxLength: 64, Repair: true})
+	assert.False(t, result.Valid)
+}
+
+func TestRemoveTrailingCommas(t *testing.T) {
+	assert.Equal(t, `{"a": [1, 2 ], "b": ",]"}`, removeTrailingCommas(`{"a": [1, 2, ], "b": ",]",}`))
+	assert.Equal(t, `no json`, extractLargestJSONBlock(`no json`))
+	assert.Equal(t, `[1]`, extractLargestJSONBlock(`{"a": [1} [1]`))
+}
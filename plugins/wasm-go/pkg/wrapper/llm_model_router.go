@@ -0,0 +1,235 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// The routes of the gateway match the headers to select the upstream cluster of the model and the provider
+	ModelRouteHeader    = "x-higress-llm-model"
+	ProviderRouteHeader = "x-higress-llm-provider"
+)
+
+// RequestFeatures are the properties of a chat completion request used to route it.
+type RequestFeatures struct {
+	Model        string
+	PromptTokens int
+	MaxTokens    int
+	HasImages    bool
+	HasTools     bool
+	Stream       bool
+	// The tier of the consumer, e.g. taken from the consumer metadata
+	Tier string
+}
+
+func ExtractRequestFeatures(request *ChatCompletionRequest, tier string) RequestFeatures {
+	features := RequestFeatures{
+		Model:        request.Model,
+		PromptTokens: CountRequestTokens(request),
+		MaxTokens:    request.MaxTokens,
+		HasTools:     len(request.Tools) > 0,
+		Stream:       request.Stream,
+		Tier:         tier,
+	}
+	for _, message := range request.Messages {
+		for _, part := range message.ContentParts() {
+			if part.Type == ContentTypeImageUrl {
+				features.HasImages = true
+			}
+		}
+	}
+	return features
+}
+
+// ModelRouteCondition matches the features, all the conditions set must be met, the zero values are ignored.
+type ModelRouteCondition struct {
+	// The requested models, a pattern may end with * to match by prefix
+	Models []string
+	// The bounds of the prompt tokens, inclusive
+	MinPromptTokens int
+	MaxPromptTokens int
+	// The requests asking for at least the completion tokens
+	MinMaxTokens int
+	HasImages    *bool
+	HasTools     *bool
+	Stream       *bool
+	Tiers        []string
+}
+
+func (c *ModelRouteCondition) Match(features RequestFeatures) bool {
+	if len(c.Models) > 0 {
+		matched := false
+		for _, pattern := range c.Models {
+			if matchModelPattern(pattern, features.Model) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if c.MinPromptTokens > 0 && features.PromptTokens < c.MinPromptTokens {
+		return false
+	}
+	if c.MaxPromptTokens > 0 && features.PromptTokens > c.MaxPromptTokens {
+		return false
+	}
+	if c.MinMaxTokens > 0 && features.MaxTokens < c.MinMaxTokens {
+		return false
+	}
+	if c.HasImages != nil && *c.HasImages != features.HasImages {
+		return false
+	}
+	if c.HasTools != nil && *c.HasTools != features.HasTools {
+		return false
+	}
+	if c.Stream != nil && *c.Stream != features.Stream {
+		return false
+	}
+	if len(c.Tiers) > 0 {
+		for _, tier := range c.Tiers {
+			if tier == features.Tier {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+type ModelRoute struct {
+	Name string
+	When ModelRouteCondition
+	// The target model, the requested one is kept if it's empty
+	Model string
+	// The target provider, the header is not set if it's empty
+	Provider string
+}
+
+// ModelRouter selects the target of a request by the first route matching its features.
+type ModelRouter struct {
+	Routes []ModelRoute
+	// The route used when none matches, the request is not changed if it's nil
+	Default *ModelRoute
+	// The key of the consumer metadata holding the tier, default is tier
+	TierKey string
+}
+
+// ParseModelRouter parses the config like:
+//
+//	{"tierKey": "tier", "routes": [
+//	   {"name": "vision", "when": {"hasImages": true}, "model": "qwen-vl-max", "provider": "qwen"},
+//	   {"name": "long", "when": {"minPromptTokens": 30000}, "model": "qwen-long"},
+//	   {"name": "free", "when": {"tiers": ["free"], "models": ["gpt-4*"]}, "model": "gpt-4o-mini"}],
+//	 "default": {"model": "qwen-turbo"}}
+func ParseModelRouter(json gjson.Result) (*ModelRouter, error) {
+	router := &ModelRouter{TierKey: json.Get("tierKey").String()}
+	if router.TierKey == "" {
+		router.TierKey = "tier"
+	}
+	for i, item := range json.Get("routes").Array() {
+		route, err := parseModelRoute(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid model route %d: %v", i, err)
+		}
+		router.Routes = append(router.Routes, route)
+	}
+	if item := json.Get("default"); item.Exists() {
+		route, err := parseModelRoute(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default model route: %v", err)
+		}
+		router.Default = &route
+	}
+	return router, nil
+}
+
+func parseModelRoute(json gjson.Result) (ModelRoute, error) {
+	when := json.Get("when")
+	route := ModelRoute{
+		Name:     json.Get("name").String(),
+		Model:    json.Get("model").String(),
+		Provider: json.Get("provider").String(),
+		When: ModelRouteCondition{
+			MinPromptTokens: int(when.Get("minPromptTokens").Int()),
+			MaxPromptTokens: int(when.Get("maxPromptTokens").Int()),
+			MinMaxTokens:    int(when.Get("minMaxTokens").Int()),
+			HasImages:       optionalBool(when.Get("hasImages")),
+			HasTools:        optionalBool(when.Get("hasTools")),
+			Stream:          optionalBool(when.Get("stream")),
+		},
+	}
+	if route.Model == "" && route.Provider == "" {
+		return route, errors.New("model and provider are both empty")
+	}
+	for _, model := range when.Get("models").Array() {
+		route.When.Models = append(route.When.Models, model.String())
+	}
+	for _, tier := range when.Get("tiers").Array() {
+		route.When.Tiers = append(route.When.Tiers, tier.String())
+	}
+	return route, nil
+}
+
+func optionalBool(value gjson.Result) *bool {
+	if !value.Exists() {
+		return nil
+	}
+	b := value.Bool()
+	return &b
+}
+
+// Route returns the first route matching the features, or the default route.
+func (r *ModelRouter) Route(features RequestFeatures) (*ModelRoute, bool) {
+	for i := range r.Routes {
+		if r.Routes[i].When.Match(features) {
+			return &r.Routes[i], true
+		}
+	}
+	return r.Default, r.Default != nil
+}
+
+// RouteRequest routes the request of the current consumer, and applies the route to the request and its headers.
+// It should be called in the request body phase, the route cache is cleared by the header change unless the
+// reroute is disabled, so the gateway selects the upstream cluster of the target.
+func (r *ModelRouter) RouteRequest(ctx HttpContext, request *ChatCompletionRequest) (*ModelRoute, bool) {
+	tier := ""
+	if consumer := GetAuthenticatedConsumer(ctx); consumer != nil {
+		tier = consumer.Metadata[r.TierKey]
+	}
+	route, ok := r.Route(ExtractRequestFeatures(request, tier))
+	if !ok {
+		return nil, false
+	}
+	if route.Model != "" {
+		request.Model = route.Model
+	}
+	if err := proxywasm.ReplaceHttpRequestHeader(ModelRouteHeader, request.Model); err != nil {
+		proxywasm.LogWarnf("failed to set %s: %v", ModelRouteHeader, err)
+	}
+	if route.Provider != "" {
+		if err := proxywasm.ReplaceHttpRequestHeader(ProviderRouteHeader, route.Provider); err != nil {
+			proxywasm.LogWarnf("failed to set %s: %v", ProviderRouteHeader, err)
+		}
+	}
+	return route, true
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestExtractRequestFeatures(t *testing.T) {
+	request := &ChatCompletionRequest{
+		Model:     "gpt-4o",
+		MaxTokens: 2048,
+		Stream:    true,
+		Tools:     []Tool{{Type: "function", Function: FunctionDefinition{Name: "search"}}},
+		Messages: []ChatMessage{
+			{Role: RoleUser, Content: []interface{}{
+				map[string]interface{}{"type": "text", "text": "what is it"},
+				map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "https://example.com/a.png"}},
+			}},
+		},
+	}
+	features := ExtractRequestFeatures(request, "pro")
+	assert.True(t, features.HasImages)
+	assert.True(t, features.HasTools)
+	assert.True(t, features.Stream)
+	assert.Equal(t, 2048, features.MaxTokens)
+	assert.Equal(t, "pro", features.Tier)
+	assert.Greater(t, features.PromptTokens, 0)
+
+	features = ExtractRequestFeatures(&ChatCompletionRequest{Messages: []ChatMessage{{Role: RoleUser, Content: "hi"}}}, "")
+	assert.False(t, features.HasImages)
+	assert.False(t, features.HasTools)
+}
+
+func TestModelRouter(t *testing.T) {
+	router, err := ParseModelRouter(gjson.Parse(`{"routes": [
+		{"name": "vision", "when": {"hasImages": true}, "model": "qwen-vl-max", "provider": "qwen"},
+		{"name": "long", "when": {"minPromptTokens": 1000}, "model": "qwen-long"},
+		{"name": "free", "when": {"tiers": ["free"], "models": ["gpt-4*"]}, "model": "gpt-4o-mini"}],
+		"default": {"model": "qwen-turbo"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "tier", router.TierKey)
+
+	cases := []struct {
+		features RequestFeatures
+		expect   string
+	}{
+		{RequestFeatures{HasImages: true, PromptTokens: 5000}, "vision"},
+		{RequestFeatures{PromptTokens: 5000}, "long"},
+		{RequestFeatures{Model: "gpt-4-turbo", Tier: "free"}, "free"},
+		{RequestFeatures{Model: "gpt-4-turbo", Tier: "pro"}, ""},
+		{RequestFeatures{Model: "claude-3", Tier: "free"}, ""},
+	}
+	for _, c := range cases {
+		route, ok := router.Route(c.features)
+		require.True(t, ok)
+		assert.Equal(t, c.expect, route.Name, "%+v", c.features)
+	}
+
+	request := &ChatCompletionRequest{Model: "gpt-4", Messages: []ChatMessage{{Role: RoleUser, Content: strings.Repeat("hello ", 2000)}}}
+	route, _ := router.Route(ExtractRequestFeatures(request, ""))
+	assert.Equal(t, "qwen-long", route.Model)
+
+	_, err = ParseModelRouter(gjson.Parse(`{"routes": [{"when": {"stream": true}}]}`))
+	assert.Error(t, err)
+	router, _ = ParseModelRouter(gjson.Parse(`{"routes": [{"when": {"stream": false}, "provider": "openai"}]}`))
+	_, ok := router.Route(RequestFeatures{Stream: true})
+	assert.False(t, ok)
+}
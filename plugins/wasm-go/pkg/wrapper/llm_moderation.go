@@ -0,0 +1,416 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	ModerationAliyun  = "aliyun"
+	ModerationOpenAI  = "openai"
+	ModerationWebhook = "webhook"
+
+	// The header carrying the annotation of the flagged content
+	ModerationHeader = "x-higress-moderation"
+)
+
+type ModerationStage string
+
+const (
+	ModerationStageRequest  ModerationStage = "request"
+	ModerationStageResponse ModerationStage = "response"
+)
+
+type ModerationAction string
+
+const (
+	ModerationPass ModerationAction = "pass"
+	// Reject the request or stop the response
+	ModerationBlock ModerationAction = "block"
+	// Mask the risk words, it's escalated to block if the service doesn't report them
+	ModerationRedact ModerationAction = "redact"
+	// Let the content pass with the ModerationHeader added
+	ModerationAnnotate ModerationAction = "annotate"
+)
+
+type ModerationResult struct {
+	Flagged    bool
+	Categories []string
+	// The flagged words or phrases in the text
+	RiskWords []string
+	// The outcome decided by the configured action, it's pass if the text is not flagged
+	Action ModerationAction
+	// The text after redaction, or the original text for the other actions
+	Text string
+}
+
+// Annotation returns the value of ModerationHeader.
+func (r *ModerationResult) Annotation() string {
+	if !r.Flagged {
+		return "pass"
+	}
+	return "flagged; categories=" + strings.Join(r.Categories, ",")
+}
+
+// Moderation checks the text with a content moderation service, the callback is not called if an error is returned.
+type Moderation interface {
+	Check(stage ModerationStage, text string, callback func(result *ModerationResult, err error)) error
+}
+
+type ModerationConfig struct {
+	Type   string
+	Client HttpClient
+	// The api key of openai, or the bearer token of the webhook
+	ApiKey string
+	// The path of the webhook, default is /
+	Path string
+	// The endpoint host and the signer of aliyun, e.g. green-cip.cn-shanghai.aliyuncs.com
+	Host   string
+	Signer *AliyunSigner
+	// The aliyun services of the stages, default are llm_query_moderation and llm_response_moderation
+	RequestService  string
+	ResponseService string
+	// The lowest aliyun risk level flagged, low, medium or high, default is high
+	RiskLevel string
+	// The openai moderation model, default is omni-moderation-latest
+	Model string
+	// The action of the flagged text, default is block
+	Action ModerationAction
+	// The timeout of the calls in milliseconds, default is 5000ms
+	Timeout uint32
+}
+
+type moderationDialect interface {
+	request(config *ModerationConfig, stage ModerationStage, text string) (string, string, [][2]string, []byte, error)
+	response(config *ModerationConfig, body []byte) (*ModerationResult, error)
+}
+
+var moderationDialects = map[string]moderationDialect{
+	ModerationAliyun:  aliyunModerationDialect{},
+	ModerationOpenAI:  openAIModerationDialect{},
+	ModerationWebhook: webhookModerationDialect{},
+}
+
+func NewModeration(config ModerationConfig) (Moderation, error) {
+	dialect, ok := moderationDialects[config.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown moderation type: %s", config.Type)
+	}
+	if config.Client == nil {
+		return nil, errors.New("moderation client is empty")
+	}
+	if config.Type == ModerationAliyun && (config.Signer == nil || config.Host == "") {
+		return nil, errors.New("aliyun moderation signer or host is empty")
+	}
+	switch config.Action {
+	case "":
+		config.Action = ModerationBlock
+	case ModerationBlock, ModerationRedact, ModerationAnnotate:
+	default:
+		return nil, fmt.Errorf("invalid moderation action: %s", config.Action)
+	}
+	if _, ok := aliyunRiskLevels[config.RiskLevel]; !ok {
+		if config.RiskLevel != "" {
+			return nil, fmt.Errorf("invalid moderation risk level: %s", config.RiskLevel)
+		}
+		config.RiskLevel = "high"
+	}
+	if config.Path == "" {
+		config.Path = "/"
+	}
+	if config.RequestService == "" {
+		config.RequestService = "llm_query_moderation"
+	}
+	if config.ResponseService == "" {
+		config.ResponseService = "llm_response_moderation"
+	}
+	if config.Model == "" {
+		config.Model = "omni-moderation-latest"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 5000
+	}
+	return &httpModeration{config: config, dialect: dialect}, nil
+}
+
+// ParseModeration parses the config like:
+//
+//	{"type": "aliyun", "serviceName": "green.dns", "serviceHost": "green-cip.cn-shanghai.aliyuncs.com",
+//	 "accessKey": "xxx", "secretKey": "xxx", "riskLevel": "medium", "action": "block"}
+func ParseModeration(json gjson.Result) (Moderation, error) {
+	serviceName := json.Get("serviceName").String()
+	if serviceName == "" {
+		return nil, errors.New("moderation serviceName is empty")
+	}
+	servicePort := json.Get("servicePort").Int()
+	if servicePort == 0 {
+		servicePort = 443
+	}
+	config := ModerationConfig{
+		Type:            json.Get("type").String(),
+		Client:          NewClusterClient(FQDNCluster{FQDN: serviceName, Host: json.Get("serviceHost").String(), Port: servicePort}),
+		ApiKey:          json.Get("apiKey").String(),
+		Path:            json.Get("path").String(),
+		Host:            json.Get("serviceHost").String(),
+		RequestService:  json.Get("requestService").String(),
+		ResponseService: json.Get("responseService").String(),
+		RiskLevel:       json.Get("riskLevel").String(),
+		Model:           json.Get("model").String(),
+		Action:          ModerationAction(json.Get("action").String()),
+		Timeout:         uint32(json.Get("timeout").Uint()),
+	}
+	if accessKey := json.Get("accessKey").String(); accessKey != "" {
+		config.Signer = &AliyunSigner{Credentials: StaticAliyunCredentials{
+			AccessKeyID:     accessKey,
+			AccessKeySecret: json.Get("secretKey").String(),
+		}}
+	}
+	return NewModeration(config)
+}
+
+type httpModeration struct {
+	config  ModerationConfig
+	dialect moderationDialect
+}
+
+func (m *httpModeration) Check(stage ModerationStage, text string, callback func(*ModerationResult, error)) error {
+	method, path, headers, body, err := m.dialect.request(&m.config, stage, text)
+	if err != nil {
+		return err
+	}
+	return m.config.Client.Call(method, path, headers, body, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if statusCode < 200 || statusCode >= 300 {
+			callback(nil, fmt.Errorf("moderation service responded with status %d: %s", statusCode, responseBody))
+			return
+		}
+		result, err := m.dialect.response(&m.config, responseBody)
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		decideModeration(m.config.Action, text, result)
+		callback(result, nil)
+	}, m.config.Timeout)
+}
+
+// decideModeration applies the configured action to the flagged text.
+func decideModeration(action ModerationAction, text string, result *ModerationResult) {
+	if !result.Flagged {
+		result.Action, result.Text = ModerationPass, text
+		return
+	}
+	result.Action = action
+	if action != ModerationRedact {
+		result.Text = text
+		return
+	}
+	// the text may be redacted by the webhook already
+	if result.Text != "" {
+		return
+	}
+	if len(result.RiskWords) == 0 {
+		result.Action, result.Text = ModerationBlock, text
+		return
+	}
+	result.Text = RedactRiskWords(text, result.RiskWords)
+}
+
+// RedactRiskWords masks each rune of the risk words in the text with *.
+func RedactRiskWords(text string, words []string) string {
+	// replace the longer words first, so the words containing the others are masked entirely
+	sorted := append([]string{}, words...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	for _, word := range sorted {
+		if word != "" {
+			text = strings.ReplaceAll(text, word, strings.Repeat("*", utf8.RuneCountInString(word)))
+		}
+	}
+	return text
+}
+
+var aliyunRiskLevels = map[string]int{"none": 0, "low": 1, "medium": 2, "high": 3}
+
+// aliyunModerationDialect calls the TextModerationPlus API of the Aliyun content security service.
+type aliyunModerationDialect struct{}
+
+func (aliyunModerationDialect) request(config *ModerationConfig, stage ModerationStage, text string) (string, string, [][2]string, []byte, error) {
+	service := config.RequestService
+	if stage == ModerationStageResponse {
+		service = config.ResponseService
+	}
+	parameters, _ := json.Marshal(map[string]string{"content": text})
+	query := url.Values{}
+	query.Set("Service", service)
+	query.Set("ServiceParameters", string(parameters))
+	path := "/?" + query.Encode()
+	headers, err := config.Signer.SignAcs3(AliyunRequest{
+		Method:  http.MethodPost,
+		Path:    path,
+		Host:    config.Host,
+		Action:  "TextModerationPlus",
+		Version: "2022-03-02",
+	}, time.Now())
+	if err != nil {
+		return "", "", nil, nil, err
+	}
+	return http.MethodPost, path, headers, nil, nil
+}
+
+func (aliyunModerationDialect) response(config *ModerationConfig, body []byte) (*ModerationResult, error) {
+	response := gjson.ParseBytes(body)
+	if code := response.Get("Code").Int(); code != 200 {
+		return nil, fmt.Errorf("aliyun moderation failed with code %d: %s", code, response.Get("Message").String())
+	}
+	result := &ModerationResult{}
+	level := response.Get("Data.RiskLevel").String()
+	result.Flagged = aliyunRiskLevels[level] >= aliyunRiskLevels[config.RiskLevel]
+	for _, item := range response.Get("Data.Result").Array() {
+		label := item.Get("Label").String()
+		if label == "" || label == "nonLabel" {
+			continue
+		}
+		result.Categories = append(result.Categories, label)
+		for _, word := range strings.Split(item.Get("RiskWords").String(), ",") {
+			if word = strings.TrimSpace(word); word != "" {
+				result.RiskWords = append(result.RiskWords, word)
+			}
+		}
+	}
+	return result, nil
+}
+
+// openAIModerationDialect calls the OpenAI moderations API, it doesn't report the risk words.
+type openAIModerationDialect struct{}
+
+func (openAIModerationDialect) request(config *ModerationConfig, stage ModerationStage, text string) (string, string, [][2]string, []byte, error) {
+	body, err := json.Marshal(map[string]string{"model": config.Model, "input": text})
+	if err != nil {
+		return "", "", nil, nil, err
+	}
+	headers := [][2]string{{"content-type", "application/json"}, {"authorization", "Bearer " + config.ApiKey}}
+	return http.MethodPost, "/v1/moderations", headers, body, nil
+}
+
+func (openAIModerationDialect) response(config *ModerationConfig, body []byte) (*ModerationResult, error) {
+	item := gjson.GetBytes(body, "results.0")
+	if !item.Exists() {
+		return nil, fmt.Errorf("invalid moderation response: %s", body)
+	}
+	result := &ModerationResult{Flagged: item.Get("flagged").Bool()}
+	item.Get("categories").ForEach(func(key, value gjson.Result) bool {
+		if value.Bool() {
+			result.Categories = append(result.Categories, key.String())
+		}
+		return true
+	})
+	sort.Strings(result.Categories)
+	return result, nil
+}
+
+// webhookModerationDialect posts {"stage": "request", "text": "..."} to the webhook, which responds with
+// {"flagged": true, "categories": ["spam"], "riskWords": ["..."], "text": "the redacted text"}.
+type webhookModerationDialect struct{}
+
+func (webhookModerationDialect) request(config *ModerationConfig, stage ModerationStage, text string) (string, string, [][2]string, []byte, error) {
+	body, err := json.Marshal(map[string]string{"stage": string(stage), "text": text})
+	if err != nil {
+		return "", "", nil, nil, err
+	}
+	headers := [][2]string{{"content-type", "application/json"}}
+	if config.ApiKey != "" {
+		headers = append(headers, [2]string{"authorization", "Bearer " + config.ApiKey})
+	}
+	return http.MethodPost, config.Path, headers, body, nil
+}
+
+func (webhookModerationDialect) response(config *ModerationConfig, body []byte) (*ModerationResult, error) {
+	var response struct {
+		Flagged    bool     `json:"flagged"`
+		Categories []string `json:"categories"`
+		RiskWords  []string `json:"riskWords"`
+		Text       string   `json:"text"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("invalid moderation response: %v", err)
+	}
+	return &ModerationResult{
+		Flagged:    response.Flagged,
+		Categories: response.Categories,
+		RiskWords:  response.RiskWords,
+		Text:       response.Text,
+	}, nil
+}
+
+// ModerationStream splits the streaming text into the windows to check. Each window starts with the tail of the
+// previous one, so the content split across the windows is still seen as a whole. The plugin should hold the
+// chunks until the window containing them passes the check.
+type ModerationStream struct {
+	// The runes of the new text in a window, default is 200
+	Interval int
+	// The runes of the previous window repeated in the next one
+	Overlap int
+
+	pending string
+	tail    string
+}
+
+func NewModerationStream(interval, overlap int) *ModerationStream {
+	if interval <= 0 {
+		interval = 200
+	}
+	if overlap < 0 {
+		overlap = 0
+	}
+	return &ModerationStream{Interval: interval, Overlap: overlap}
+}
+
+// Feed adds the delta of the stream, it returns the window to check once enough text is collected.
+func (s *ModerationStream) Feed(delta string) (string, bool) {
+	s.pending += delta
+	if utf8.RuneCountInString(s.pending) < s.Interval {
+		return "", false
+	}
+	return s.window(), true
+}
+
+// Finish returns the window of the remaining text at the end of the stream.
+func (s *ModerationStream) Finish() (string, bool) {
+	if s.pending == "" {
+		return "", false
+	}
+	return s.window(), true
+}
+
+func (s *ModerationStream) window() string {
+	window := s.tail + s.pending
+	s.pending = ""
+	s.tail = window
+	if count := utf8.RuneCountInString(window); count > s.Overlap {
+		runes := []rune(window)
+		s.tail = string(runes[count-s.Overlap:])
+	}
+	return window
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestAliyunModerationDialect(t *testing.T) {
+	config := &ModerationConfig{
+		Host:            "green-cip.cn-shanghai.aliyuncs.com",
+		Signer:          &AliyunSigner{Credentials: StaticAliyunCredentials{AccessKeyID: "ak", AccessKeySecret: "sk"}},
+		RequestService:  "llm_query_moderation",
+		ResponseService: "llm_response_moderation",
+		RiskLevel:       "medium",
+	}
+	_, path, headers, _, err := aliyunModerationDialect{}.request(config, ModerationStageResponse, "hello")
+	require.NoError(t, err)
+	parsed, _ := url.Parse(path)
+	assert.Equal(t, "llm_response_moderation", parsed.Query().Get("Service"))
+	assert.JSONEq(t, `{"content": "hello"}`, parsed.Query().Get("ServiceParameters"))
+	assert.Contains(t, headers, [2]string{"x-acs-action", "TextModerationPlus"})
+
+	result, err := aliyunModerationDialect{}.response(config, []byte(`{"Code": 200, "Data": {"RiskLevel": "medium",
+		"Result": [{"Label": "political_entity", "RiskWords": "foo, bar"}, {"Label": "nonLabel"}]}}`))
+	require.NoError(t, err)
+	assert.True(t, result.Flagged)
+	assert.Equal(t, []string{"political_entity"}, result.Categories)
+	assert.Equal(t, []string{"foo", "bar"}, result.RiskWords)
+
+	result, _ = aliyunModerationDialect{}.response(config, []byte(`{"Code": 200, "Data": {"RiskLevel": "low", "Result": [{"Label": "ad"}]}}`))
+	assert.False(t, result.Flagged)
+	_, err = aliyunModerationDialect{}.response(config, []byte(`{"Code": 401, "Message": "invalid access key"}`))
+	assert.Error(t, err)
+}
+
+func TestOpenAIAndWebhookModerationDialect(t *testing.T) {
+	config := &ModerationConfig{ApiKey: "sk", Model: "omni-moderation-latest", Path: "/check"}
+	_, path, headers, body, err := openAIModerationDialect{}.request(config, ModerationStageRequest, "hello")
+	require.NoError(t, err)
+	assert.Equal(t, "/v1/moderations", path)
+	assert.Contains(t, headers, [2]string{"authorization", "Bearer sk"})
+	assert.Equal(t, "hello", gjson.GetBytes(body, "input").String())
+	result, err := openAIModerationDialect{}.response(config, []byte(`{"results": [{"flagged": true, "categories": {"violence": true, "hate": true, "sexual": false}}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, &ModerationResult{Flagged: true, Categories: []string{"hate", "violence"}}, result)
+
+	_, path, _, body, err = webhookModerationDialect{}.request(config, ModerationStageResponse, "hello")
+	require.NoError(t, err)
+	assert.Equal(t, "/check", path)
+	assert.JSONEq(t, `{"stage": "response", "text": "hello"}`, string(body))
+	result, err = webhookModerationDialect{}.response(config, []byte(`{"flagged": true, "categories": ["pii"], "text": "call ***"}`))
+	require.NoError(t, err)
+	decideModeration(ModerationRedact, "call 110", result)
+	assert.Equal(t, ModerationRedact, result.Action)
+	assert.Equal(t, "call ***", result.Text)
+	assert.Equal(t, "flagged; categories=pii", result.Annotation())
+}
+
+func TestDecideModeration(t *testing.T) {
+	result := &ModerationResult{Flagged: true, RiskWords: []string{"坏", "坏人"}}
+	decideModeration(ModerationRedact, "他是坏人, 很坏", result)
+	assert.Equal(t, ModerationRedact, result.Action)
+	assert.Equal(t, "他是**, 很*", result.Text)
+
+	result = &ModerationResult{Flagged: true}
+	decideModeration(ModerationRedact, "text", result)
+	assert.Equal(t, ModerationBlock, result.Action)
+
+	result = &ModerationResult{}
+	decideModeration(ModerationBlock, "text", result)
+	assert.Equal(t, ModerationPass, result.Action)
+	assert.Equal(t, "text", result.Text)
+
+	_, err := ParseModeration(gjson.Parse(`{"type": "aliyun", "serviceName": "green.dns"}`))
+	assert.Error(t, err)
+	_, err = ParseModeration(gjson.Parse(`{"type": "openai", "serviceName": "openai.dns", "action": "drop"}`))
+	assert.Error(t, err)
+	_, err = ParseModeration(gjson.Parse(`{"type": "openai", "serviceName": "openai.dns", "apiKey": "sk"}`))
+	assert.NoError(t, err)
+}
+
+func TestModerationStream(t *testing.T) {
+	stream := NewModerationStream(5, 2)
+	_, ready := stream.Feed("abc")
+	assert.False(t, ready)
+	window, ready := stream.Feed("de")
+	assert.True(t, ready)
+	assert.Equal(t, "abcde", window)
+	window, ready = stream.Feed(strings.Repeat("f", 6))
+	assert.True(t, ready)
+	assert.Equal(t, "deffffff", window)
+	_, ready = stream.Finish()
+	assert.False(t, ready)
+	stream.Feed("g")
+	window, ready = stream.Finish()
+	assert.True(t, ready)
+	assert.Equal(t, "ffg", window)
+}
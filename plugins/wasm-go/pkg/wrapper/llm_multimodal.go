@@ -0,0 +1,359 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// MediaPart locates an image or audio part in the messages.
+type MediaPart struct {
+	// The index of the message, and the index of the part in its ContentParts
+	Message int
+	Part    int
+	Type    string
+	// The url of a remote image, it's empty for the inline data
+	Url string
+	// The media type of the inline data, e.g. image/png or audio/wav
+	MediaType string
+	// The decoded size of the inline data in bytes
+	Size int
+}
+
+func (p MediaPart) Inline() bool {
+	return p.Url == ""
+}
+
+// Base64DecodedSize returns the size of the decoded data without decoding it, the padding is optional.
+func Base64DecodedSize(data string) int {
+	n := len(data)
+	padding := 0
+	if n > 0 && data[n-1] == '=' {
+		padding++
+		if n > 1 && data[n-2] == '=' {
+			padding++
+		}
+	}
+	return (n - padding) * 3 / 4
+}
+
+// MediaParts returns the image and audio parts of the messages in order.
+func MediaParts(messages []ChatMessage) []MediaPart {
+	var media []MediaPart
+	for i, message := range messages {
+		if _, ok := message.Content.(string); ok {
+			continue
+		}
+		for j, part := range message.ContentParts() {
+			switch {
+			case part.Type == ContentTypeImageUrl && part.ImageUrl != nil:
+				item := MediaPart{Message: i, Part: j, Type: part.Type}
+				if mediaType, data, ok := parseDataUrl(part.ImageUrl.Url); ok {
+					item.MediaType = mediaType
+					item.Size = Base64DecodedSize(data)
+				} else {
+					item.Url = part.ImageUrl.Url
+				}
+				media = append(media, item)
+			case part.Type == ContentTypeInputAudio && part.InputAudio != nil:
+				media = append(media, MediaPart{Message: i, Part: j, Type: part.Type,
+					MediaType: "audio/" + part.InputAudio.Format, Size: Base64DecodedSize(part.InputAudio.Data)})
+			}
+		}
+	}
+	return media
+}
+
+// MediaLimits bounds the media of a request, the zero values are unlimited.
+type MediaLimits struct {
+	MaxParts int
+	// The max decoded size of an inline part, and of all the inline parts
+	MaxPartBytes  int
+	MaxTotalBytes int
+	// The media types allowed for the inline data, e.g. image/png
+	MediaTypes []string
+	// Reject the remote images, so the upstream doesn't fetch the urls given by the clients
+	DenyRemote bool
+}
+
+// ParseMediaLimits parses the config like:
+//
+//	{"maxParts": 4, "maxPartBytes": 5242880, "maxTotalBytes": 10485760,
+//	 "mediaTypes": ["image/png", "image/jpeg", "audio/wav"], "denyRemote": true}
+func ParseMediaLimits(json gjson.Result) MediaLimits {
+	limits := MediaLimits{
+		MaxParts:      int(json.Get("maxParts").Int()),
+		MaxPartBytes:  int(json.Get("maxPartBytes").Int()),
+		MaxTotalBytes: int(json.Get("maxTotalBytes").Int()),
+		DenyRemote:    json.Get("denyRemote").Bool(),
+	}
+	for _, mediaType := range json.Get("mediaTypes").Array() {
+		limits.MediaTypes = append(limits.MediaTypes, mediaType.String())
+	}
+	return limits
+}
+
+// Check returns the first violation of the limits.
+func (l MediaLimits) Check(media []MediaPart) error {
+	if l.MaxParts > 0 && len(media) > l.MaxParts {
+		return fmt.Errorf("too many media parts: %d > %d", len(media), l.MaxParts)
+	}
+	total := 0
+	for _, part := range media {
+		if !part.Inline() {
+			if l.DenyRemote {
+				return fmt.Errorf("remote media is not allowed: %s", part.Url)
+			}
+			continue
+		}
+		if l.MaxPartBytes > 0 && part.Size > l.MaxPartBytes {
+			return fmt.Errorf("media part of message %d is too large: %d > %d bytes", part.Message, part.Size, l.MaxPartBytes)
+		}
+		if len(l.MediaTypes) > 0 && !containsString(l.MediaTypes, part.MediaType) {
+			return fmt.Errorf("media type is not allowed: %s", part.MediaType)
+		}
+		total += part.Size
+	}
+	if l.MaxTotalBytes > 0 && total > l.MaxTotalBytes {
+		return fmt.Errorf("media parts are too large: %d > %d bytes", total, l.MaxTotalBytes)
+	}
+	return nil
+}
+
+// setContentPart replaces a part of the message, the content becomes []ContentPart.
+func setContentPart(message *ChatMessage, index int, part ContentPart) {
+	parts := append([]ContentPart(nil), message.ContentParts()...)
+	parts[index] = part
+	message.Content = parts
+}
+
+// RewriteImageUrls rewrites the urls of the images in place, including the data urls, the rewrite returns false to
+// keep the url. It returns the number of the rewritten urls.
+func RewriteImageUrls(messages []ChatMessage, rewrite func(url string) (string, bool)) int {
+	count := 0
+	for _, media := range MediaParts(messages) {
+		if media.Type != ContentTypeImageUrl {
+			continue
+		}
+		part := messages[media.Message].ContentParts()[media.Part]
+		url, ok := rewrite(part.ImageUrl.Url)
+		if !ok {
+			continue
+		}
+		part.ImageUrl = &ImageUrl{Url: url, Detail: part.ImageUrl.Detail}
+		setContentPart(&messages[media.Message], media.Part, part)
+		count++
+	}
+	return count
+}
+
+// UrlPrefixRewriter rewrites the urls matching the first of the prefixes, e.g. from the public domain of an object
+// store to its internal endpoint.
+func UrlPrefixRewriter(prefixes [][2]string) func(url string) (string, bool) {
+	return func(url string) (string, bool) {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(url, prefix[0]) {
+				return prefix[1] + strings.TrimPrefix(url, prefix[0]), true
+			}
+		}
+		return url, false
+	}
+}
+
+type MediaUploaderConfig struct {
+	Client HttpClient
+	// The objects are put to PathPrefix/{name}, and referenced by UrlPrefix/{name}
+	PathPrefix string
+	UrlPrefix  string
+	Headers    [][2]string
+	// The inline images smaller than it are kept, default is 0
+	MinSize int
+	// The timeout of an upload in milliseconds, default is 5000ms
+	Timeout uint32
+}
+
+// MediaUploader moves the inline images of the requests to an object store, so the upstream fetches them from the
+// store instead of receiving them in the body, and the logs and caches keep the urls only.
+type MediaUploader struct {
+	config MediaUploaderConfig
+}
+
+func NewMediaUploader(config MediaUploaderConfig) (*MediaUploader, error) {
+	if config.Client == nil {
+		return nil, errors.New("media uploader client is empty")
+	}
+	if config.UrlPrefix == "" {
+		return nil, errors.New("media uploader urlPrefix is empty")
+	}
+	config.PathPrefix = strings.TrimSuffix(config.PathPrefix, "/")
+	config.UrlPrefix = strings.TrimSuffix(config.UrlPrefix, "/")
+	if config.Timeout == 0 {
+		config.Timeout = 5000
+	}
+	return &MediaUploader{config: config}, nil
+}
+
+// ParseMediaUploader parses the config like:
+//
+//	{"serviceName": "oss-internal.dns", "servicePort": 80, "pathPrefix": "/llm-media",
+//	 "urlPrefix": "http://oss-internal.example.com/llm-media", "minSize": 65536, "headers": {"x-oss-object-acl": "private"}}
+func ParseMediaUploader(json gjson.Result) (*MediaUploader, error) {
+	serviceName := json.Get("serviceName").String()
+	if serviceName == "" {
+		return nil, errors.New("media uploader serviceName is empty")
+	}
+	servicePort := json.Get("servicePort").Int()
+	if servicePort == 0 {
+		servicePort = 443
+	}
+	config := MediaUploaderConfig{
+		Client:     NewClusterClient(FQDNCluster{FQDN: serviceName, Host: json.Get("serviceHost").String(), Port: servicePort}),
+		PathPrefix: json.Get("pathPrefix").String(),
+		UrlPrefix:  json.Get("urlPrefix").String(),
+		MinSize:    int(json.Get("minSize").Int()),
+		Timeout:    uint32(json.Get("timeout").Uint()),
+	}
+	json.Get("headers").ForEach(func(key, value gjson.Result) bool {
+		config.Headers = append(config.Headers, [2]string{key.String(), value.String()})
+		return true
+	})
+	return NewMediaUploader(config)
+}
+
+// mediaObjectName names the object by the hash of the data, so the same image is stored once.
+func mediaObjectName(mediaType string, data []byte) string {
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:])
+	if _, ext, found := strings.Cut(mediaType, "/"); found && ext != "" {
+		name += "." + ext
+	}
+	return name
+}
+
+// Upload uploads the inline images of the messages one by one, and replaces their data urls with the urls of the
+// objects. The callback is called once all the images are uploaded or one of them fails, it's not called if an
+// error is returned.
+func (u *MediaUploader) Upload(messages []ChatMessage, callback func(uploaded int, err error)) error {
+	var pending []MediaPart
+	for _, media := range MediaParts(messages) {
+		if media.Type == ContentTypeImageUrl && media.Inline() && media.Size >= u.config.MinSize {
+			pending = append(pending, media)
+		}
+	}
+	if len(pending) == 0 {
+		callback(0, nil)
+		return nil
+	}
+	var next func(i int) error
+	next = func(i int) error {
+		media := pending[i]
+		part := messages[media.Message].ContentParts()[media.Part]
+		_, encoded, _ := parseDataUrl(part.ImageUrl.Url)
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("invalid image data of message %d: %v", media.Message, err)
+		}
+		name := mediaObjectName(media.MediaType, data)
+		headers := append([][2]string{{"content-type", media.MediaType}}, u.config.Headers...)
+		return u.config.Client.Put(u.config.PathPrefix+"/"+name, headers, data, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+			if statusCode < 200 || statusCode >= 300 {
+				callback(i, fmt.Errorf("failed to upload image, status %d: %s", statusCode, bytes.TrimSpace(responseBody)))
+				return
+			}
+			part.ImageUrl = &ImageUrl{Url: u.config.UrlPrefix + "/" + name, Detail: part.ImageUrl.Detail}
+			setContentPart(&messages[media.Message], media.Part, part)
+			if i+1 == len(pending) {
+				callback(len(pending), nil)
+				return
+			}
+			if err := next(i + 1); err != nil {
+				callback(i+1, err)
+			}
+		}, u.config.Timeout)
+	}
+	return next(0)
+}
+
+func mediaPlaceholder(mediaType string, size int) string {
+	return fmt.Sprintf("[%s %d bytes]", mediaType, size)
+}
+
+// StripMediaForLog returns a copy of the messages with the inline data replaced by placeholders of the media type
+// and size, the remote urls are kept.
+func StripMediaForLog(messages []ChatMessage) []ChatMessage {
+	stripped := append([]ChatMessage(nil), messages...)
+	for _, media := range MediaParts(messages) {
+		if !media.Inline() {
+			continue
+		}
+		part := stripped[media.Message].ContentParts()[media.Part]
+		placeholder := mediaPlaceholder(media.MediaType, media.Size)
+		if media.Type == ContentTypeImageUrl {
+			part.ImageUrl = &ImageUrl{Url: placeholder, Detail: part.ImageUrl.Detail}
+		} else {
+			part.InputAudio = &InputAudio{Data: placeholder, Format: part.InputAudio.Format}
+		}
+		setContentPart(&stripped[media.Message], media.Part, part)
+	}
+	return stripped
+}
+
+// StripMediaFromBody replaces the inline data in the messages of a request body like StripMediaForLog, the other
+// fields are kept as is. The body is returned unchanged if it's not a json object.
+func StripMediaFromBody(body []byte) []byte {
+	if !bytes.Contains(body, []byte(";base64,")) && !bytes.Contains(body, []byte(ContentTypeInputAudio)) {
+		return body
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var request map[string]interface{}
+	if err := decoder.Decode(&request); err != nil {
+		return body
+	}
+	messages, _ := request["messages"].([]interface{})
+	for _, item := range messages {
+		message, _ := item.(map[string]interface{})
+		parts, _ := message["content"].([]interface{})
+		for _, item := range parts {
+			part, _ := item.(map[string]interface{})
+			if image, ok := part["image_url"].(map[string]interface{}); ok {
+				url, _ := image["url"].(string)
+				if mediaType, data, ok := parseDataUrl(url); ok {
+					image["url"] = mediaPlaceholder(mediaType, Base64DecodedSize(data))
+				}
+			}
+			if audio, ok := part["input_audio"].(map[string]interface{}); ok {
+				data, _ := audio["data"].(string)
+				format, _ := audio["format"].(string)
+				audio["data"] = mediaPlaceholder("audio/"+format, Base64DecodedSize(data))
+			}
+		}
+	}
+	stripped, err := json.Marshal(request)
+	if err != nil {
+		return body
+	}
+	return stripped
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+const multimodalTestBody = `{"model": "gpt-4o", "temperature": 0.1, "messages": [
+	{"role": "system", "content": "describe"},
+	{"role": "user", "content": [
+		{"type": "text", "text": "what is it"},
+		{"type": "image_url", "image_url": {"url": "data:image/png;base64,aGVsbG8gd29ybGQ=", "detail": "low"}},
+		{"type": "image_url", "image_url": {"url": "https://cdn.example.com/a.jpg"}},
+		{"type": "input_audio", "input_audio": {"data": "aGk=", "format": "wav"}}]}]}`
+
+func multimodalTestMessages(t *testing.T) []ChatMessage {
+	var request ChatCompletionRequest
+	require.NoError(t, json.Unmarshal([]byte(multimodalTestBody), &request))
+	return request.Messages
+}
+
+func TestBase64DecodedSize(t *testing.T) {
+	assert.Equal(t, 11, Base64DecodedSize("aGVsbG8gd29ybGQ="))
+	assert.Equal(t, 11, Base64DecodedSize("aGVsbG8gd29ybGQ"))
+	assert.Equal(t, 2, Base64DecodedSize("aGk="))
+	assert.Equal(t, 3, Base64DecodedSize("aGV5"))
+	assert.Equal(t, 0, Base64DecodedSize(""))
+}
+
+func TestMediaParts(t *testing.T) {
+	media := MediaParts(multimodalTestMessages(t))
+	assert.Equal(t, []MediaPart{
+		{Message: 1, Part: 1, Type: ContentTypeImageUrl, MediaType: "image/png", Size: 11},
+		{Message: 1, Part: 2, Type: ContentTypeImageUrl, Url: "https://cdn.example.com/a.jpg"},
+		{Message: 1, Part: 3, Type: ContentTypeInputAudio, MediaType: "audio/wav", Size: 2},
+	}, media)
+
+	assert.NoError(t, MediaLimits{MaxParts: 3, MaxTotalBytes: 13}.Check(media))
+	assert.EqualError(t, MediaLimits{MaxParts: 2}.Check(media), "too many media parts: 3 > 2")
+	assert.EqualError(t, MediaLimits{MaxPartBytes: 10}.Check(media), "media part of message 1 is too large: 11 > 10 bytes")
+	assert.EqualError(t, MediaLimits{MaxTotalBytes: 12}.Check(media), "media parts are too large: 13 > 12 bytes")
+	assert.EqualError(t, MediaLimits{DenyRemote: true}.Check(media), "remote media is not allowed: https://cdn.example.com/a.jpg")
+	limits := ParseMediaLimits(gjson.Parse(`{"mediaTypes": ["image/png"]}`))
+	assert.EqualError(t, limits.Check(media), "media type is not allowed: audio/wav")
+}
+
+func TestRewriteImageUrls(t *testing.T) {
+	messages := multimodalTestMessages(t)
+	count := RewriteImageUrls(messages, UrlPrefixRewriter([][2]string{{"https://cdn.example.com/", "http://oss-internal/"}}))
+	assert.Equal(t, 1, count)
+	parts := messages[1].ContentParts()
+	assert.Equal(t, "http://oss-internal/a.jpg", parts[2].ImageUrl.Url)
+	assert.Equal(t, "data:image/png;base64,aGVsbG8gd29ybGQ=", parts[1].ImageUrl.Url)
+	assert.Equal(t, "what is it", messages[1].StringContent())
+	assert.Equal(t, "describe", messages[0].Content)
+}
+
+func TestStripMediaForLog(t *testing.T) {
+	messages := multimodalTestMessages(t)
+	stripped := StripMediaForLog(messages)
+	parts := stripped[1].ContentParts()
+	assert.Equal(t, "[image/png 11 bytes]", parts[1].ImageUrl.Url)
+	assert.Equal(t, "low", parts[1].ImageUrl.Detail)
+	assert.Equal(t, "https://cdn.example.com/a.jpg", parts[2].ImageUrl.Url)
+	assert.Equal(t, &InputAudio{Data: "[audio/wav 2 bytes]", Format: "wav"}, parts[3].InputAudio)
+	// the original messages are not changed
+	assert.Equal(t, "data:image/png;base64,aGVsbG8gd29ybGQ=", messages[1].ContentParts()[1].ImageUrl.Url)
+
+	body := StripMediaFromBody([]byte(multimodalTestBody))
+	assert.Equal(t, "[image/png 11 bytes]", gjson.GetBytes(body, "messages.1.content.1.image_url.url").String())
+	assert.Equal(t, "[audio/wav 2 bytes]", gjson.GetBytes(body, "messages.1.content.3.input_audio.data").String())
+	assert.Equal(t, "0.1", gjson.GetBytes(body, "temperature").Raw)
+	plain := []byte(`{"messages": [{"role": "user", "content": "hi"}]}`)
+	assert.Equal(t, plain, StripMediaFromBody(plain))
+}
+
+type fakeObjectStore struct {
+	HttpClient
+	objects map[string][]byte
+	status  int
+}
+
+func (s *fakeObjectStore) Put(rawURL string, headers [][2]string, body []byte, cb ResponseCallback, timeoutMillisecond ...uint32) error {
+	s.objects[rawURL] = body
+	cb(s.status, http.Header{}, nil)
+	return nil
+}
+
+func TestMediaUploader(t *testing.T) {
+	store := &fakeObjectStore{objects: map[string][]byte{}, status: http.StatusOK}
+	uploader, err := NewMediaUploader(MediaUploaderConfig{Client: store, PathPrefix: "/media/", UrlPrefix: "http://oss-internal/media"})
+	require.NoError(t, err)
+	messages := multimodalTestMessages(t)
+	uploaded := -1
+	require.NoError(t, uploader.Upload(messages, func(n int, err error) {
+		require.NoError(t, err)
+		uploaded = n
+	}))
+	assert.Equal(t, 1, uploaded)
+	name := "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9.png"
+	assert.Equal(t, []byte("hello world"), store.objects["/media/"+name])
+	assert.Equal(t, "http://oss-internal/media/"+name, messages[1].ContentParts()[1].ImageUrl.Url)
+
+	store.status = http.StatusForbidden
+	messages = multimodalTestMessages(t)
+	require.NoError(t, uploader.Upload(messages, func(n int, err error) {
+		assert.Equal(t, 0, n)
+		assert.Error(t, err)
+	}))
+
+	uploader, err = NewMediaUploader(MediaUploaderConfig{Client: store, UrlPrefix: "http://oss-internal", MinSize: 1024})
+	require.NoError(t, err)
+	require.NoError(t, uploader.Upload(messages, func(n int, err error) { assert.Equal(t, 0, n) }))
+}
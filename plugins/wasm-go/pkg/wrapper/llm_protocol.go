@@ -0,0 +1,215 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// The chat APIs the clients may speak, the requests and responses are converted to and from the OpenAI format,
+// so a plugin proxying one of them to a provider of another handles the OpenAI format only.
+const (
+	ChatProtocolOpenAI = "openai"
+	// The Messages API of Anthropic
+	ChatProtocolClaude = "claude"
+	// The generateContent and streamGenerateContent API of Gemini
+	ChatProtocolGemini = "gemini"
+)
+
+// chatProtocol converts the bodies of an API, in the direction opposite to llmDialect as well.
+type chatProtocol interface {
+	parseChatRequest(body []byte) (*ChatCompletionRequest, error)
+	chatRequestBody(request *ChatCompletionRequest) ([]byte, error)
+	chatResponse(body []byte) (*ChatCompletionResponse, error)
+	chatResponseBody(response *ChatCompletionResponse) ([]byte, error)
+	newStreamDecoder() llmStreamDecoder
+	newStreamEncoder() ChatStreamEncoder
+}
+
+var chatProtocols = map[string]chatProtocol{
+	ChatProtocolOpenAI: openAIDialect{},
+	ChatProtocolClaude: claudeDialect{},
+	ChatProtocolGemini: geminiDialect{},
+}
+
+func getChatProtocol(protocol string) (chatProtocol, error) {
+	p, ok := chatProtocols[protocol]
+	if !ok {
+		return nil, fmt.Errorf("unknown chat protocol: %s", protocol)
+	}
+	return p, nil
+}
+
+// chatContent returns the string content if all the parts are text, the parts otherwise.
+func chatContent(parts []ContentPart) interface{} {
+	var texts []string
+	for _, part := range parts {
+		if part.Type != ContentTypeText {
+			return parts
+		}
+		texts = append(texts, part.Text)
+	}
+	if len(texts) == 0 {
+		return nil
+	}
+	return strings.Join(texts, "\n")
+}
+
+// DecodeChatRequest converts the request body of the protocol into the OpenAI format.
+func DecodeChatRequest(protocol string, body []byte) (*ChatCompletionRequest, error) {
+	p, err := getChatProtocol(protocol)
+	if err != nil {
+		return nil, err
+	}
+	return p.parseChatRequest(body)
+}
+
+// EncodeChatRequest converts the request into the request body of the protocol.
+func EncodeChatRequest(protocol string, request *ChatCompletionRequest) ([]byte, error) {
+	p, err := getChatProtocol(protocol)
+	if err != nil {
+		return nil, err
+	}
+	return p.chatRequestBody(request)
+}
+
+// DecodeChatResponse converts the response body of the protocol into the OpenAI format.
+func DecodeChatResponse(protocol string, body []byte) (*ChatCompletionResponse, error) {
+	p, err := getChatProtocol(protocol)
+	if err != nil {
+		return nil, err
+	}
+	return p.chatResponse(body)
+}
+
+// EncodeChatResponse converts the response into the response body of the protocol.
+func EncodeChatResponse(protocol string, response *ChatCompletionResponse) ([]byte, error) {
+	p, err := getChatProtocol(protocol)
+	if err != nil {
+		return nil, err
+	}
+	return p.chatResponseBody(response)
+}
+
+// ParseGeminiPath returns the model of a Gemini request path like /v1beta/models/gemini-pro:streamGenerateContent,
+// which is not part of the body, and whether the response is streamed.
+func ParseGeminiPath(path string) (model string, stream bool, ok bool) {
+	path, _, _ = strings.Cut(path, "?")
+	_, rest, found := strings.Cut(path, "/models/")
+	if !found {
+		return "", false, false
+	}
+	model, method, found := strings.Cut(rest, ":")
+	if !found || model == "" {
+		return "", false, false
+	}
+	switch method {
+	case "generateContent":
+		return model, false, true
+	case "streamGenerateContent":
+		return model, true, true
+	}
+	return "", false, false
+}
+
+// ChatStreamDecoder converts the response stream of the protocol into chunks, the body may be fed in pieces.
+type ChatStreamDecoder struct {
+	parser  SSEParser
+	decoder llmStreamDecoder
+	done    bool
+}
+
+func NewChatStreamDecoder(protocol string) (*ChatStreamDecoder, error) {
+	p, err := getChatProtocol(protocol)
+	if err != nil {
+		return nil, err
+	}
+	return &ChatStreamDecoder{decoder: p.newStreamDecoder()}, nil
+}
+
+// Decode returns the chunks of the complete events in the data, the events after the end of the stream are ignored.
+func (d *ChatStreamDecoder) Decode(data []byte, endOfStream bool) ([]*ChatCompletionResponse, error) {
+	events := d.parser.Feed(data)
+	if endOfStream {
+		events = append(events, d.parser.Flush()...)
+	}
+	var chunks []*ChatCompletionResponse
+	for _, event := range events {
+		if d.done {
+			break
+		}
+		decoded, done, err := d.decoder.decode(event)
+		if err != nil {
+			return chunks, err
+		}
+		chunks = append(chunks, decoded...)
+		d.done = done
+	}
+	return chunks, nil
+}
+
+// Done returns true once the event ending the stream is decoded.
+func (d *ChatStreamDecoder) Done() bool {
+	return d.done
+}
+
+// ChatStreamEncoder converts the chunks into the response stream of a protocol.
+type ChatStreamEncoder interface {
+	// Encode returns the events of the chunk, it may be empty since some protocols hold parts of the chunks
+	Encode(chunk *ChatCompletionResponse) []byte
+	// Finish returns the events ending the stream, it should be called once the last chunk is encoded
+	Finish() []byte
+}
+
+func NewChatStreamEncoder(protocol string) (ChatStreamEncoder, error) {
+	p, err := getChatProtocol(protocol)
+	if err != nil {
+		return nil, err
+	}
+	return p.newStreamEncoder(), nil
+}
+
+func (openAIDialect) parseChatRequest(body []byte) (*ChatCompletionRequest, error) {
+	var request ChatCompletionRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("invalid chat completion request: %v", err)
+	}
+	return &request, nil
+}
+
+func (openAIDialect) chatRequestBody(request *ChatCompletionRequest) ([]byte, error) {
+	return json.Marshal(request)
+}
+
+func (openAIDialect) chatResponseBody(response *ChatCompletionResponse) ([]byte, error) {
+	return json.Marshal(response)
+}
+
+func (openAIDialect) newStreamEncoder() ChatStreamEncoder {
+	return openAIStreamEncoder{}
+}
+
+type openAIStreamEncoder struct{}
+
+func (openAIStreamEncoder) Encode(chunk *ChatCompletionResponse) []byte {
+	data, _ := json.Marshal(chunk)
+	return SSEEvent{Data: string(data)}.Bytes()
+}
+
+func (openAIStreamEncoder) Finish() []byte {
+	return SSEEvent{Data: SSEDone}.Bytes()
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestDecodeClaudeRequest(t *testing.T) {
+	request, err := DecodeChatRequest(ChatProtocolClaude, []byte(`{
+		"model": "claude-3-5-sonnet", "max_tokens": 1024, "stream": true,
+		"system": [{"type": "text", "text": "be brief"}],
+		"messages": [
+			{"role": "user", "content": "hi"},
+			{"role": "assistant", "content": [{"type": "text", "text": "checking"}, {"type": "tool_use", "id": "toolu_1", "name": "lookup", "input": {"q": "x"}}]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "found"}]},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "AAAA"}}]}
+		],
+		"tool_choice": {"type": "tool", "name": "lookup"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "claude-3-5-sonnet", request.Model)
+	assert.Equal(t, &StreamOptions{IncludeUsage: true}, request.StreamOptions)
+	assert.Equal(t, []ChatMessage{
+		{Role: RoleSystem, Content: "be brief"},
+		{Role: RoleUser, Content: "hi"},
+		{Role: RoleAssistant, Content: "checking", ToolCalls: []ToolCall{{ID: "toolu_1", Type: "function", Function: FunctionCall{Name: "lookup", Arguments: `{"q":"x"}`}}}},
+		{Role: RoleTool, ToolCallID: "toolu_1", Content: "found"},
+		{Role: RoleUser, Content: []ContentPart{{Type: ContentTypeImageUrl, ImageUrl: &ImageUrl{Url: "data:image/png;base64,AAAA"}}}},
+	}, request.Messages)
+	assert.Equal(t, map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": "lookup"}}, request.ToolChoice)
+
+	// the request survives the round trip through the claude format
+	body, err := EncodeChatRequest(ChatProtocolClaude, testToolRequest)
+	require.NoError(t, err)
+	request, err = DecodeChatRequest(ChatProtocolClaude, body)
+	require.NoError(t, err)
+	expected := *testToolRequest
+	expected.MaxTokens = claudeDefaultMaxTokens
+	assert.Equal(t, &expected, request)
+}
+
+func TestEncodeClaudeResponse(t *testing.T) {
+	response := &ChatCompletionResponse{ID: "chatcmpl-1", Model: "gpt-4o",
+		Choices: []ChatCompletionChoice{{Message: &ChatMessage{Role: RoleAssistant, Content: "let me check",
+			ToolCalls: []ToolCall{{ID: "call_1", Function: FunctionCall{Name: "get_weather", Arguments: `{"city":"Beijing"}`}}}},
+			FinishReason: FinishReasonToolCalls}},
+		Usage: &Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+	}
+	body, err := EncodeChatResponse(ChatProtocolClaude, response)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id": "chatcmpl-1", "type": "message", "role": "assistant", "model": "gpt-4o", "stop_reason": "tool_use",
+		"content": [{"type": "text", "text": "let me check"}, {"type": "tool_use", "id": "call_1", "name": "get_weather", "input": {"city": "Beijing"}}],
+		"usage": {"input_tokens": 10, "output_tokens": 5}}`, string(body))
+
+	decoded, err := DecodeChatResponse(ChatProtocolClaude, body)
+	require.NoError(t, err)
+	assert.Equal(t, response.Choices[0].Message.ToolCalls[0].Function, decoded.Choices[0].Message.ToolCalls[0].Function)
+	assert.Equal(t, response.Usage, decoded.Usage)
+}
+
+var testStreamChunks = []*ChatCompletionResponse{
+	{ID: "chatcmpl-1", Model: "gpt-4o", Choices: []ChatCompletionChoice{{Delta: &ChatMessage{Role: RoleAssistant, Content: ""}}}},
+	{ID: "chatcmpl-1", Model: "gpt-4o", Choices: []ChatCompletionChoice{{Delta: &ChatMessage{Content: "Hel"}}}},
+	{ID: "chatcmpl-1", Model: "gpt-4o", Choices: []ChatCompletionChoice{{Delta: &ChatMessage{Content: "lo"}}}},
+	{ID: "chatcmpl-1", Model: "gpt-4o", Choices: []ChatCompletionChoice{{Delta: &ChatMessage{ToolCalls: []ToolCall{{ID: "call_1", Type: "function", Function: FunctionCall{Name: "get_weather"}}}}}}},
+	{ID: "chatcmpl-1", Model: "gpt-4o", Choices: []ChatCompletionChoice{{Delta: &ChatMessage{ToolCalls: []ToolCall{{Function: FunctionCall{Arguments: `{"city"`}}}}}}},
+	{ID: "chatcmpl-1", Model: "gpt-4o", Choices: []ChatCompletionChoice{{Delta: &ChatMessage{ToolCalls: []ToolCall{{Function: FunctionCall{Arguments: `:"Beijing"}`}}}}}}},
+	{ID: "chatcmpl-1", Model: "gpt-4o", Choices: []ChatCompletionChoice{{Delta: &ChatMessage{}, FinishReason: FinishReasonToolCalls}}},
+	{ID: "chatcmpl-1", Model: "gpt-4o", Choices: []ChatCompletionChoice{}, Usage: &Usage{PromptTokens: 10, CompletionTokens: 7, TotalTokens: 17}},
+}
+
+func encodeTestStream(t *testing.T, protocol string) []byte {
+	encoder, err := NewChatStreamEncoder(protocol)
+	require.NoError(t, err)
+	var stream []byte
+	for _, chunk := range testStreamChunks {
+		stream = append(stream, encoder.Encode(chunk)...)
+	}
+	return append(stream, encoder.Finish()...)
+}
+
+func TestClaudeStreamEncoder(t *testing.T) {
+	stream := encodeTestStream(t, ChatProtocolClaude)
+	var parser SSEParser
+	var types []string
+	for _, event := range parser.Feed(stream) {
+		assert.Equal(t, event.Event, gjson.Get(event.Data, "type").String())
+		types = append(types, event.Event)
+	}
+	assert.Equal(t, []string{"message_start",
+		"content_block_start", "content_block_delta", "content_block_delta", "content_block_stop",
+		"content_block_start", "content_block_delta", "content_block_delta", "content_block_stop",
+		"message_delta", "message_stop"}, types)
+
+	// decoded back by the claude dialect
+	decoder, err := NewChatStreamDecoder(ChatProtocolClaude)
+	require.NoError(t, err)
+	var chunks []*ChatCompletionResponse
+	for i := 0; i < len(stream); i += 50 {
+		end := i + 50
+		if end > len(stream) {
+			end = len(stream)
+		}
+		decoded, err := decoder.Decode(stream[i:end], end == len(stream))
+		require.NoError(t, err)
+		chunks = append(chunks, decoded...)
+	}
+	assert.True(t, decoder.Done())
+	var text, arguments string
+	for _, chunk := range chunks {
+		text += chunk.Content()
+		for _, call := range chunk.Choices[0].Delta.ToolCalls {
+			arguments += call.Function.Arguments
+		}
+	}
+	assert.Equal(t, "Hello", text)
+	assert.Equal(t, `{"city":"Beijing"}`, arguments)
+	last := chunks[len(chunks)-1]
+	assert.Equal(t, FinishReasonToolCalls, last.Choices[0].FinishReason)
+	assert.Equal(t, &Usage{PromptTokens: 10, CompletionTokens: 7, TotalTokens: 17}, last.Usage)
+}
+
+func TestDecodeGeminiRequest(t *testing.T) {
+	request, err := DecodeChatRequest(ChatProtocolGemini, []byte(`{
+		"systemInstruction": {"parts": [{"text": "be brief"}]},
+		"contents": [
+			{"role": "user", "parts": [{"text": "weather?"}, {"inlineData": {"mimeType": "audio/wav", "data": "AAAA"}}]},
+			{"role": "model", "parts": [{"functionCall": {"name": "get_weather", "args": {"city": "Hangzhou"}}}]},
+			{"role": "function", "parts": [{"functionResponse": {"name": "get_weather", "response": {"temperature": 20}}}]}
+		],
+		"tools": [{"functionDeclarations": [{"name": "get_weather"}]}],
+		"generationConfig": {"maxOutputTokens": 100, "responseMimeType": "application/json"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, []ChatMessage{
+		{Role: RoleSystem, Content: "be brief"},
+		{Role: RoleUser, Content: []ContentPart{{Type: ContentTypeText, Text: "weather?"}, {Type: ContentTypeInputAudio, InputAudio: &InputAudio{Data: "AAAA", Format: "wav"}}}},
+		{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "call_get_weather_0", Type: "function", Function: FunctionCall{Name: "get_weather", Arguments: `{"city":"Hangzhou"}`}}}},
+		{Role: RoleTool, ToolCallID: "call_get_weather_0", Content: `{"temperature":20}`},
+	}, request.Messages)
+	assert.Equal(t, []Tool{{Type: "function", Function: FunctionDefinition{Name: "get_weather"}}}, request.Tools)
+	assert.Equal(t, 100, request.MaxTokens)
+	assert.Equal(t, map[string]interface{}{"type": "json_object"}, request.ResponseFormat)
+
+	model, stream, ok := ParseGeminiPath("/v1beta/models/gemini-1.5-pro:streamGenerateContent?alt=sse")
+	assert.Equal(t, "gemini-1.5-pro", model)
+	assert.True(t, stream)
+	assert.True(t, ok)
+	_, _, ok = ParseGeminiPath("/v1beta/models/gemini-1.5-pro:countTokens")
+	assert.False(t, ok)
+}
+
+func TestEncodeGeminiResponse(t *testing.T) {
+	body, err := EncodeChatResponse(ChatProtocolGemini, &ChatCompletionResponse{ID: "chatcmpl-1", Model: "gpt-4o",
+		Choices: []ChatCompletionChoice{{Message: &ChatMessage{Role: RoleAssistant, Content: "sunny"}, FinishReason: FinishReasonLength}},
+		Usage:   &Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"responseId": "chatcmpl-1", "modelVersion": "gpt-4o",
+		"candidates": [{"index": 0, "content": {"role": "model", "parts": [{"text": "sunny"}]}, "finishReason": "MAX_TOKENS"}],
+		"usageMetadata": {"promptTokenCount": 10, "candidatesTokenCount": 5, "totalTokenCount": 15}}`, string(body))
+}
+
+func TestGeminiStreamEncoder(t *testing.T) {
+	var parser SSEParser
+	var data []string
+	for _, event := range parser.Feed(encodeTestStream(t, ChatProtocolGemini)) {
+		data = append(data, event.Data)
+	}
+	require.Len(t, data, 4)
+	assert.JSONEq(t, `{"responseId": "chatcmpl-1", "modelVersion": "gpt-4o", "candidates": [{"index": 0, "content": {"role": "model", "parts": [{"text": "Hel"}]}}]}`, data[0])
+	// the tool call is sent whole once the choice finishes
+	assert.JSONEq(t, `{"responseId": "chatcmpl-1", "modelVersion": "gpt-4o", "candidates": [{"index": 0, "finishReason": "STOP",
+		"content": {"role": "model", "parts": [{"functionCall": {"name": "get_weather", "args": {"city": "Beijing"}}}]}}]}`, data[2])
+	assert.Equal(t, int64(17), gjson.Get(data[3], "usageMetadata.totalTokenCount").Int())
+
+	stream := encodeTestStream(t, ChatProtocolOpenAI)
+	decoder, err := NewChatStreamDecoder(ChatProtocolOpenAI)
+	require.NoError(t, err)
+	chunks, err := decoder.Decode(stream, true)
+	require.NoError(t, err)
+	assert.Equal(t, testStreamChunks, chunks)
+	assert.True(t, decoder.Done())
+
+	_, err = NewChatStreamEncoder("unknown")
+	assert.Error(t, err)
+}
@@ -0,0 +1,402 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	LLMProviderOpenAI = "openai"
+	LLMProviderQwen   = "qwen"
+	LLMProviderClaude = "claude"
+	LLMProviderGemini = "gemini"
+)
+
+var ErrLLMUnsupported = errors.New("operation is not supported by the llm provider")
+
+// LLMError is returned when the provider responds with a non 2xx status.
+type LLMError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *LLMError) Error() string {
+	return fmt.Sprintf("llm provider responded with status %d: %s", e.StatusCode, e.Body)
+}
+
+// LLMProvider calls a model with the OpenAI compatible request and response, whatever the API of the provider is.
+// The callbacks are not called if an error is returned.
+//
+// It's for the plugins calling a model on their own with an http callout, e.g. for moderation or rag. The ai-proxy
+// plugin, which converts the proxied request and response in place, keeps its own providers and doesn't use it.
+type LLMProvider interface {
+	Name() string
+	Type() string
+	ChatCompletion(request *ChatCompletionRequest, callback func(response *ChatCompletionResponse, err error)) error
+	// ChatCompletionStream requests a streaming response and passes the chunks to onChunk, then calls onDone.
+	// As the http call of the Wasm VM returns the whole response at once, all the chunks are delivered when the
+	// stream ends, it's mostly useful when the model only supports streaming.
+	ChatCompletionStream(request *ChatCompletionRequest, onChunk func(chunk *ChatCompletionResponse), onDone func(err error)) error
+	Embeddings(request *EmbeddingsRequest, callback func(response *EmbeddingsResponse, err error)) error
+}
+
+type LLMProviderConfig struct {
+	Name   string
+	Type   string
+	Client HttpClient
+	ApiKey string
+	// The path prefix of the API, the default depends on the type, e.g. /v1 for openai
+	BasePath string
+	// The model used when the request does not specify one
+	DefaultModel string
+	// The models served by the provider, see LLMProviderRegistry.ForModel
+	Models []string
+	// The timeout of the calls in milliseconds, default is 60000ms
+	Timeout uint32
+}
+
+// llmDialect converts between the OpenAI format and the API of the provider.
+type llmDialect interface {
+	defaultBasePath() string
+	chatRequest(config *LLMProviderConfig, request *ChatCompletionRequest) (string, [][2]string, []byte, error)
+	chatResponse(body []byte) (*ChatCompletionResponse, error)
+	newStreamDecoder() llmStreamDecoder
+	embeddingsRequest(config *LLMProviderConfig, request *EmbeddingsRequest) (string, [][2]string, []byte, error)
+	embeddingsResponse(body []byte) (*EmbeddingsResponse, error)
+}
+
+// llmStreamDecoder converts the events of the provider into chunks, done is true when the stream ends.
+type llmStreamDecoder interface {
+	decode(event SSEEvent) (chunks []*ChatCompletionResponse, done bool, err error)
+}
+
+type LLMProviderFactory func(config LLMProviderConfig) (LLMProvider, error)
+
+var llmProviderFactories = map[string]LLMProviderFactory{
+	LLMProviderOpenAI: dialectProviderFactory(openAIDialect{basePath: "/v1"}),
+	LLMProviderQwen:   dialectProviderFactory(openAIDialect{basePath: "/compatible-mode/v1"}),
+	LLMProviderClaude: dialectProviderFactory(claudeDialect{}),
+	LLMProviderGemini: dialectProviderFactory(geminiDialect{}),
+}
+
+// RegisterLLMProviderFactory adds a provider type, or replaces a builtin one. It should be called in init.
+func RegisterLLMProviderFactory(providerType string, factory LLMProviderFactory) {
+	llmProviderFactories[providerType] = factory
+}
+
+func NewLLMProvider(config LLMProviderConfig) (LLMProvider, error) {
+	factory, ok := llmProviderFactories[config.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown llm provider type: %s", config.Type)
+	}
+	if config.Client == nil {
+		return nil, errors.New("llm provider client is empty")
+	}
+	if config.Name == "" {
+		config.Name = config.Type
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 60000
+	}
+	return factory(config)
+}
+
+func dialectProviderFactory(dialect llmDialect) LLMProviderFactory {
+	return func(config LLMProviderConfig) (LLMProvider, error) {
+		if config.BasePath == "" {
+			config.BasePath = dialect.defaultBasePath()
+		}
+		config.BasePath = strings.TrimSuffix(config.BasePath, "/")
+		return &dialectProvider{config: config, dialect: dialect}, nil
+	}
+}
+
+type dialectProvider struct {
+	config  LLMProviderConfig
+	dialect llmDialect
+}
+
+func (p *dialectProvider) Name() string {
+	return p.config.Name
+}
+
+func (p *dialectProvider) Type() string {
+	return p.config.Type
+}
+
+func (p *dialectProvider) prepare(request *ChatCompletionRequest, stream bool) *ChatCompletionRequest {
+	r := *request
+	if r.Model == "" {
+		r.Model = p.config.DefaultModel
+	}
+	r.Stream = stream
+	if stream {
+		r.StreamOptions = &StreamOptions{IncludeUsage: true}
+	}
+	return &r
+}
+
+func llmResponseError(statusCode int, body []byte) error {
+	if statusCode >= 200 && statusCode < 300 {
+		return nil
+	}
+	return &LLMError{StatusCode: statusCode, Body: string(body)}
+}
+
+func (p *dialectProvider) ChatCompletion(request *ChatCompletionRequest, callback func(*ChatCompletionResponse, error)) error {
+	path, headers, body, err := p.dialect.chatRequest(&p.config, p.prepare(request, false))
+	if err != nil {
+		return err
+	}
+	return p.config.Client.Post(path, headers, body, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if err := llmResponseError(statusCode, responseBody); err != nil {
+			callback(nil, err)
+			return
+		}
+		callback(p.dialect.chatResponse(responseBody))
+	}, p.config.Timeout)
+}
+
+func (p *dialectProvider) ChatCompletionStream(request *ChatCompletionRequest, onChunk func(*ChatCompletionResponse), onDone func(error)) error {
+	path, headers, body, err := p.dialect.chatRequest(&p.config, p.prepare(request, true))
+	if err != nil {
+		return err
+	}
+	return p.config.Client.Post(path, headers, body, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if err := llmResponseError(statusCode, responseBody); err != nil {
+			onDone(err)
+			return
+		}
+		onDone(decodeLLMStream(p.dialect.newStreamDecoder(), responseBody, onChunk))
+	}, p.config.Timeout)
+}
+
+func decodeLLMStream(decoder llmStreamDecoder, body []byte, onChunk func(*ChatCompletionResponse)) error {
+	var parser SSEParser
+	events := append(parser.Feed(body), parser.Flush()...)
+	for _, event := range events {
+		chunks, done, err := decoder.decode(event)
+		if err != nil {
+			return err
+		}
+		for _, chunk := range chunks {
+			onChunk(chunk)
+		}
+		if done {
+			break
+		}
+	}
+	return nil
+}
+
+func (p *dialectProvider) Embeddings(request *EmbeddingsRequest, callback func(*EmbeddingsResponse, error)) error {
+	r := *request
+	if r.Model == "" {
+		r.Model = p.config.DefaultModel
+	}
+	path, headers, body, err := p.dialect.embeddingsRequest(&p.config, &r)
+	if err != nil {
+		return err
+	}
+	return p.config.Client.Post(path, headers, body, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if err := llmResponseError(statusCode, responseBody); err != nil {
+			callback(nil, err)
+			return
+		}
+		callback(p.dialect.embeddingsResponse(responseBody))
+	}, p.config.Timeout)
+}
+
+// openAIDialect is used by OpenAI and the providers offering an OpenAI compatible API.
+type openAIDialect struct {
+	basePath string
+}
+
+func (d openAIDialect) defaultBasePath() string {
+	return d.basePath
+}
+
+func (d openAIDialect) headers(config *LLMProviderConfig) [][2]string {
+	headers := [][2]string{{"content-type", "application/json"}}
+	if config.ApiKey != "" {
+		headers = append(headers, [2]string{"authorization", "Bearer " + config.ApiKey})
+	}
+	return headers
+}
+
+func (d openAIDialect) chatRequest(config *LLMProviderConfig, request *ChatCompletionRequest) (string, [][2]string, []byte, error) {
+	body, err := json.Marshal(request)
+	return config.BasePath + "/chat/completions", d.headers(config), body, err
+}
+
+func (d openAIDialect) chatResponse(body []byte) (*ChatCompletionResponse, error) {
+	var response ChatCompletionResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("invalid chat completion response: %v", err)
+	}
+	return &response, nil
+}
+
+func (d openAIDialect) newStreamDecoder() llmStreamDecoder {
+	return openAIStreamDecoder{}
+}
+
+func (d openAIDialect) embeddingsRequest(config *LLMProviderConfig, request *EmbeddingsRequest) (string, [][2]string, []byte, error) {
+	body, err := json.Marshal(request)
+	return config.BasePath + "/embeddings", d.headers(config), body, err
+}
+
+func (d openAIDialect) embeddingsResponse(body []byte) (*EmbeddingsResponse, error) {
+	var response EmbeddingsResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("invalid embeddings response: %v", err)
+	}
+	return &response, nil
+}
+
+type openAIStreamDecoder struct{}
+
+func (openAIStreamDecoder) decode(event SSEEvent) ([]*ChatCompletionResponse, bool, error) {
+	if event.Data == SSEDone {
+		return nil, true, nil
+	}
+	if event.Data == "" {
+		return nil, false, nil
+	}
+	var chunk ChatCompletionResponse
+	if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+		return nil, false, fmt.Errorf("invalid chat completion chunk: %v", err)
+	}
+	return []*ChatCompletionResponse{&chunk}, false, nil
+}
+
+// LLMProviderRegistry holds the providers configured for the plugin.
+type LLMProviderRegistry struct {
+	providers []LLMProvider
+	configs   []LLMProviderConfig
+}
+
+func NewLLMProviderRegistry(configs ...LLMProviderConfig) (*LLMProviderRegistry, error) {
+	registry := &LLMProviderRegistry{}
+	for _, config := range configs {
+		if err := registry.Add(config); err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+func (r *LLMProviderRegistry) Add(config LLMProviderConfig) error {
+	provider, err := NewLLMProvider(config)
+	if err != nil {
+		return err
+	}
+	if r.Get(provider.Name()) != nil {
+		return fmt.Errorf("duplicate llm provider: %s", provider.Name())
+	}
+	r.providers = append(r.providers, provider)
+	r.configs = append(r.configs, config)
+	return nil
+}
+
+// ParseLLMProviders parses the providers config like:
+//
+//	[{"name": "qwen", "type": "qwen", "serviceName": "dashscope.dns", "servicePort": 443,
+//	  "serviceHost": "dashscope.aliyuncs.com", "apiKey": "sk-xxx", "models": ["qwen-*"]}]
+func ParseLLMProviders(json gjson.Result) (*LLMProviderRegistry, error) {
+	registry := &LLMProviderRegistry{}
+	for _, item := range json.Array() {
+		serviceName := item.Get("serviceName").String()
+		if serviceName == "" {
+			return nil, errors.New("llm provider serviceName is empty")
+		}
+		servicePort := item.Get("servicePort").Int()
+		if servicePort == 0 {
+			servicePort = 443
+		}
+		config := LLMProviderConfig{
+			Name:         item.Get("name").String(),
+			Type:         item.Get("type").String(),
+			Client:       NewClusterClient(FQDNCluster{FQDN: serviceName, Host: item.Get("serviceHost").String(), Port: servicePort}),
+			ApiKey:       item.Get("apiKey").String(),
+			BasePath:     item.Get("basePath").String(),
+			DefaultModel: item.Get("defaultModel").String(),
+			Timeout:      uint32(item.Get("timeout").Uint()),
+		}
+		for _, model := range item.Get("models").Array() {
+			config.Models = append(config.Models, model.String())
+		}
+		if err := registry.Add(config); err != nil {
+			return nil, err
+		}
+	}
+	if len(registry.providers) == 0 {
+		return nil, errors.New("no llm provider is configured")
+	}
+	return registry, nil
+}
+
+func (r *LLMProviderRegistry) Get(name string) LLMProvider {
+	for _, provider := range r.providers {
+		if provider.Name() == name {
+			return provider
+		}
+	}
+	return nil
+}
+
+// Default returns the first provider.
+func (r *LLMProviderRegistry) Default() LLMProvider {
+	if len(r.providers) == 0 {
+		return nil
+	}
+	return r.providers[0]
+}
+
+// ForModel returns the first provider whose models match the model, a model pattern may end with * to match by
+// prefix. The default provider is returned if none matches.
+func (r *LLMProviderRegistry) ForModel(model string) LLMProvider {
+	for i, config := range r.configs {
+		for _, pattern := range config.Models {
+			if matchModelPattern(pattern, model) {
+				return r.providers[i]
+			}
+		}
+	}
+	return r.Default()
+}
+
+func matchModelPattern(pattern, model string) bool {
+	return pattern == model || strings.HasSuffix(pattern, "*") && strings.HasPrefix(model, strings.TrimSuffix(pattern, "*"))
+}
+
+// parseDataUrl splits data:<mime type>;base64,<data>.
+func parseDataUrl(url string) (string, string, bool) {
+	if !strings.HasPrefix(url, "data:") {
+		return "", "", false
+	}
+	meta, data, found := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	if !found || !strings.HasSuffix(meta, ";base64") {
+		return "", "", false
+	}
+	return strings.TrimSuffix(meta, ";base64"), data, true
+}
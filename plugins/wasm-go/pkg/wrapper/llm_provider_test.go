@@ -0,0 +1,212 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestSSEParser(t *testing.T) {
+	var parser SSEParser
+	events := parser.Feed([]byte("data: {\"a\":1}\n\nevent: message_start\r\ndata: {\"b\""))
+	require.Len(t, events, 1)
+	assert.Equal(t, `{"a":1}`, events[0].Data)
+
+	events = parser.Feed([]byte(":2}\r\n\r\n: ping\n\ndata: line1\ndata: line2\n\ndata: [DONE]"))
+	require.Len(t, events, 2)
+	assert.Equal(t, SSEEvent{Event: "message_start", Data: `{"b":2}`}, events[0])
+	assert.Equal(t, "line1\nline2", events[1].Data)
+
+	events = parser.Flush()
+	require.Len(t, events, 1)
+	assert.Equal(t, SSEDone, events[0].Data)
+	assert.Empty(t, parser.Pending())
+
+	assert.Equal(t, "event: delta\ndata: a\ndata: b\n\n", string(SSEEvent{Event: "delta", Data: "a\nb"}.Bytes()))
+}
+
+func TestChatMessageContent(t *testing.T) {
+	var message ChatMessage
+	require.NoError(t, json.Unmarshal([]byte(`{"role": "user", "content": [
+		{"type": "text", "text": "what's this?"},
+		{"type": "image_url", "image_url": {"url": "data:image/png;base64,AAAA"}},
+		{"type": "text", "text": "be brief"}
+	]}`), &message))
+	assert.Equal(t, "what's this?\nbe brief", message.StringContent())
+	parts := message.ContentParts()
+	require.Len(t, parts, 3)
+	assert.Equal(t, "data:image/png;base64,AAAA", parts[1].ImageUrl.Url)
+
+	mimeType, data, ok := parseDataUrl(parts[1].ImageUrl.Url)
+	assert.True(t, ok)
+	assert.Equal(t, "image/png", mimeType)
+	assert.Equal(t, "AAAA", data)
+	_, _, ok = parseDataUrl("https://example.com/a.png")
+	assert.False(t, ok)
+}
+
+var testToolRequest = &ChatCompletionRequest{
+	Model: "model",
+	Messages: []ChatMessage{
+		{Role: RoleSystem, Content: "you are a weather bot"},
+		{Role: RoleUser, Content: "weather of Hangzhou?"},
+		{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "call_1", Type: "function", Function: FunctionCall{Name: "get_weather", Arguments: `{"city":"Hangzhou"}`}}}},
+		{Role: RoleTool, ToolCallID: "call_1", Content: "sunny"},
+	},
+	Tools: []Tool{{Type: "function", Function: FunctionDefinition{
+		Name:       "get_weather",
+		Parameters: map[string]interface{}{"type": "object", "properties": map[string]interface{}{"city": map[string]interface{}{"type": "string"}}},
+	}}},
+	ToolChoice: "required",
+	Stop:       []string{"END"},
+}
+
+func TestClaudeDialect(t *testing.T) {
+	config := &LLMProviderConfig{ApiKey: "key", BasePath: "/v1"}
+	path, headers, body, err := claudeDialect{}.chatRequest(config, testToolRequest)
+	require.NoError(t, err)
+	assert.Equal(t, "/v1/messages", path)
+	assert.Contains(t, headers, [2]string{"x-api-key", "key"})
+	assert.JSONEq(t, `{
+		"model": "model",
+		"system": "you are a weather bot",
+		"max_tokens": 4096,
+		"stop_sequences": ["END"],
+		"messages": [
+			{"role": "user", "content": [{"type": "text", "text": "weather of Hangzhou?"}]},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "call_1", "name": "get_weather", "input": {"city": "Hangzhou"}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "call_1", "content": "sunny"}]}
+		],
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object", "properties": {"city": {"type": "string"}}}}],
+		"tool_choice": {"type": "any"}
+	}`, string(body))
+
+	response, err := claudeDialect{}.chatResponse([]byte(`{"id": "msg_1", "model": "claude", "stop_reason": "tool_use",
+		"content": [{"type": "text", "text": "let me check"}, {"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Beijing"}}],
+		"usage": {"input_tokens": 10, "output_tokens": 5}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "let me check", response.Content())
+	assert.Equal(t, FinishReasonToolCalls, response.Choices[0].FinishReason)
+	assert.Equal(t, `{"city":"Beijing"}`, response.Choices[0].Message.ToolCalls[0].Function.Arguments)
+	assert.Equal(t, &Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}, response.Usage)
+}
+
+func TestClaudeStreamDecoder(t *testing.T) {
+	stream := `event: message_start
+data: {"type": "message_start", "message": {"id": "msg_1", "model": "claude", "usage": {"input_tokens": 10}}}
+
+event: content_block_delta
+data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hello"}}
+
+event: content_block_start
+data: {"type": "content_block_start", "index": 1, "content_block": {"type": "tool_use", "id": "toolu_1", "name": "get_weather"}}
+
+event: content_block_delta
+data: {"type": "content_block_delta", "index": 1, "delta": {"type": "input_json_delta", "partial_json": "{\"city\""}}
+
+event: message_delta
+data: {"type": "message_delta", "delta": {"stop_reason": "end_turn"}, "usage": {"output_tokens": 7}}
+
+event: message_stop
+data: {"type": "message_stop"}
+
+`
+	var chunks []*ChatCompletionResponse
+	err := decodeLLMStream(claudeDialect{}.newStreamDecoder(), []byte(stream), func(chunk *ChatCompletionResponse) {
+		chunks = append(chunks, chunk)
+	})
+	require.NoError(t, err)
+	require.Len(t, chunks, 5)
+	assert.Equal(t, "msg_1", chunks[1].ID)
+	assert.Equal(t, "Hello", chunks[1].Content())
+	assert.Equal(t, "get_weather", chunks[2].Choices[0].Delta.ToolCalls[0].Function.Name)
+	assert.Equal(t, `{"city"`, chunks[3].Choices[0].Delta.ToolCalls[0].Function.Arguments)
+	assert.Equal(t, FinishReasonStop, chunks[4].Choices[0].FinishReason)
+	assert.Equal(t, &Usage{PromptTokens: 10, CompletionTokens: 7, TotalTokens: 17}, chunks[4].Usage)
+
+	err = decodeLLMStream(claudeDialect{}.newStreamDecoder(), []byte(`data: {"type": "error", "error": {"type": "overloaded_error", "message": "Overloaded"}}`+"\n\n"), func(*ChatCompletionResponse) {})
+	assert.ErrorContains(t, err, "Overloaded")
+}
+
+func TestGeminiDialect(t *testing.T) {
+	config := &LLMProviderConfig{ApiKey: "key", BasePath: "/v1beta"}
+	path, _, body, err := geminiDialect{}.chatRequest(config, testToolRequest)
+	require.NoError(t, err)
+	assert.Equal(t, "/v1beta/models/model:generateContent", path)
+	assert.JSONEq(t, `{
+		"systemInstruction": {"parts": [{"text": "you are a weather bot"}]},
+		"contents": [
+			{"role": "user", "parts": [{"text": "weather of Hangzhou?"}]},
+			{"role": "model", "parts": [{"functionCall": {"name": "get_weather", "args": {"city": "Hangzhou"}}}]},
+			{"role": "function", "parts": [{"functionResponse": {"name": "get_weather", "response": {"content": "sunny"}}}]}
+		],
+		"tools": [{"functionDeclarations": [{"name": "get_weather", "parameters": {"type": "object", "properties": {"city": {"type": "string"}}}}]}],
+		"generationConfig": {"stopSequences": ["END"]}
+	}`, string(body))
+
+	stream := *testToolRequest
+	stream.Stream = true
+	path, _, _, err = geminiDialect{}.chatRequest(config, &stream)
+	require.NoError(t, err)
+	assert.Equal(t, "/v1beta/models/model:streamGenerateContent?alt=sse", path)
+
+	response, err := geminiDialect{}.chatResponse([]byte(`{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hi"}, {"text": " there"}]}, "finishReason": "MAX_TOKENS"}],
+		"usageMetadata": {"promptTokenCount": 3, "candidatesTokenCount": 2, "totalTokenCount": 5}, "modelVersion": "gemini-1.5"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "Hi there", response.Content())
+	assert.Equal(t, FinishReasonLength, response.Choices[0].FinishReason)
+	assert.Equal(t, 5, response.Usage.TotalTokens)
+
+	path, _, body, err = geminiDialect{}.embeddingsRequest(config, &EmbeddingsRequest{Model: "text-embedding-004", Input: []string{"a", "b"}})
+	require.NoError(t, err)
+	assert.Equal(t, "/v1beta/models/text-embedding-004:batchEmbedContents", path)
+	assert.Equal(t, "models/text-embedding-004", gjson.GetBytes(body, "requests.1.model").String())
+	embeddings, err := geminiDialect{}.embeddingsResponse([]byte(`{"embeddings": [{"values": [0.1, 0.2]}, {"values": [0.3]}]}`))
+	require.NoError(t, err)
+	require.Len(t, embeddings.Data, 2)
+	assert.Equal(t, 1, embeddings.Data[1].Index)
+}
+
+func TestLLMProviderRegistry(t *testing.T) {
+	registry, err := ParseLLMProviders(gjson.Parse(`[
+		{"name": "dashscope", "type": "qwen", "serviceName": "dashscope.dns", "apiKey": "sk-1", "models": ["qwen-*"]},
+		{"type": "claude", "serviceName": "anthropic.dns", "apiKey": "sk-2", "models": ["claude-3-5-sonnet"]}
+	]`))
+	require.NoError(t, err)
+	assert.Equal(t, "dashscope", registry.Default().Name())
+	assert.Equal(t, "claude", registry.Get("claude").Type())
+	assert.Equal(t, "dashscope", registry.ForModel("qwen-max").Name())
+	assert.Equal(t, "claude", registry.ForModel("claude-3-5-sonnet").Name())
+	assert.Equal(t, "dashscope", registry.ForModel("gpt-4o").Name())
+
+	provider := registry.Get("dashscope").(*dialectProvider)
+	path, headers, _, err := provider.dialect.chatRequest(&provider.config, provider.prepare(&ChatCompletionRequest{Model: "qwen-max"}, true))
+	require.NoError(t, err)
+	assert.Equal(t, "/compatible-mode/v1/chat/completions", path)
+	assert.Contains(t, headers, [2]string{"authorization", "Bearer sk-1"})
+
+	err = registry.Get("claude").Embeddings(&EmbeddingsRequest{Input: []string{"a"}}, nil)
+	assert.ErrorIs(t, err, ErrLLMUnsupported)
+
+	_, err = ParseLLMProviders(gjson.Parse(`[{"type": "unknown", "serviceName": "a.dns"}]`))
+	assert.Error(t, err)
+	_, err = ParseLLMProviders(gjson.Parse(`[{"type": "openai", "serviceName": "a.dns"}, {"type": "openai", "serviceName": "b.dns"}]`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,319 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// The request is sent without the context if a stage fails
+	RAGFallbackSkip = "skip"
+	// The request fails if a stage fails
+	RAGFallbackFail = "fail"
+
+	// The context is a system message after the leading system messages
+	RAGInjectSystem = "system"
+	// The context is prepended to the last user message
+	RAGInjectUser = "user"
+
+	RAGStageEmbed  = "embed"
+	RAGStageSearch = "search"
+	RAGStageRender = "render"
+
+	defaultRAGChunkTemplate   = "{{chunk.content}}"
+	defaultRAGContextTemplate = "Answer the question with the context below if it's relevant.\n\nContext:\n{{rag.context}}"
+)
+
+// ragTemplateOptions keeps the retrieved text as it is, it's bounded by the token budget instead.
+var ragTemplateOptions = PromptTemplateOptions{Escape: TemplateEscapeNone, MaxValueLength: 1 << 20, MaxOutputLength: 1 << 22}
+
+type RAGPipelineConfig struct {
+	Embeddings *EmbeddingsClient
+	Store      VectorStore
+	// The chunks retrieved, default is 3
+	TopK int
+	// Filters the chunks by the score, all the chunks are kept if it's nil
+	Matcher *SemanticMatcher
+	// The budget of the chunks in tokens, the less relevant ones exceeding it are dropped, 0 means no limit
+	MaxContextTokens int
+	// Renders a chunk with the variables chunk.content, chunk.id, chunk.score, chunk.index and chunk.{metadata key}
+	ChunkTemplate *PromptTemplate
+	// Renders the context with the variables rag.context, the chunks joined by blank lines, and rag.query
+	ContextTemplate *PromptTemplate
+	// Where the context is injected, default is system
+	Inject string
+	// The action when a stage fails, default is skip
+	Fallback string
+	// Query returns the text searched, default is the last user message
+	Query func(request *ChatCompletionRequest) string
+}
+
+// RAGPipeline retrieves the chunks relevant to the request from a vector store and injects them into the messages:
+// embed the query, search the store, render the chunks and inject the context. The timeout of each stage is the
+// timeout of its client.
+type RAGPipeline struct {
+	config RAGPipelineConfig
+}
+
+// RAGResult describes a run of the pipeline.
+type RAGResult struct {
+	Query string
+	// The chunks injected, in the order of relevance
+	Chunks  []VectorSearchResult
+	Context string
+	// The stage failed and its error, the request is unchanged if a stage failed
+	FailedStage string
+	Err         error
+}
+
+func (r *RAGResult) Injected() bool {
+	return r.Context != ""
+}
+
+func NewRAGPipeline(config RAGPipelineConfig) (*RAGPipeline, error) {
+	if config.Embeddings == nil || config.Store == nil {
+		return nil, errors.New("rag embeddings or vector store is empty")
+	}
+	if config.TopK <= 0 {
+		config.TopK = 3
+	}
+	var err error
+	if config.ChunkTemplate == nil {
+		if config.ChunkTemplate, err = ParsePromptTemplate(defaultRAGChunkTemplate, ragTemplateOptions); err != nil {
+			return nil, err
+		}
+	}
+	if config.ContextTemplate == nil {
+		if config.ContextTemplate, err = ParsePromptTemplate(defaultRAGContextTemplate, ragTemplateOptions); err != nil {
+			return nil, err
+		}
+	}
+	switch config.Inject {
+	case "":
+		config.Inject = RAGInjectSystem
+	case RAGInjectSystem, RAGInjectUser:
+	default:
+		return nil, fmt.Errorf("invalid rag inject: %s", config.Inject)
+	}
+	switch config.Fallback {
+	case "":
+		config.Fallback = RAGFallbackSkip
+	case RAGFallbackSkip, RAGFallbackFail:
+	default:
+		return nil, fmt.Errorf("invalid rag fallback: %s", config.Fallback)
+	}
+	if config.Query == nil {
+		config.Query = lastUserMessage
+	}
+	return &RAGPipeline{config: config}, nil
+}
+
+// ParseRAGPipeline parses the config like below, the embeddings provider is configured like one of
+// ParseLLMProviders, and the timeouts of the providers and the store bound the stages:
+//
+//	{"embeddings": {"type": "qwen", "serviceName": "dashscope.dns", "apiKey": "sk-xxx", "model": "text-embedding-v2", "timeout": 2000},
+//	 "vectorStore": {"type": "dashvector", "serviceName": "dashvector.dns", "apiKey": "sk-xxx", "collection": "docs", "timeout": 2000},
+//	 "topK": 5, "threshold": 0.4, "higherIsCloser": false, "maxContextTokens": 2000,
+//	 "chunkTemplate": "[{{chunk.index}}] {{chunk.title | untitled}}\n{{chunk.content}}",
+//	 "contextTemplate": "Use the documents below.\n\n{{rag.context}}", "inject": "system", "fallback": "skip"}
+func ParseRAGPipeline(json gjson.Result) (*RAGPipeline, error) {
+	embeddings := json.Get("embeddings")
+	providers, err := ParseLLMProviders(embeddings)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rag embeddings: %v", err)
+	}
+	store, err := ParseVectorStore(json.Get("vectorStore"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid rag vector store: %v", err)
+	}
+	config := RAGPipelineConfig{
+		Embeddings:       NewEmbeddingsClient(providers.Default(), embeddings.Get("model").String()),
+		Store:            store,
+		TopK:             int(json.Get("topK").Int()),
+		MaxContextTokens: int(json.Get("maxContextTokens").Int()),
+		Inject:           json.Get("inject").String(),
+		Fallback:         json.Get("fallback").String(),
+	}
+	if threshold := json.Get("threshold"); threshold.Exists() {
+		config.Matcher = &SemanticMatcher{Threshold: threshold.Float(), HigherIsCloser: json.Get("higherIsCloser").Bool()}
+	}
+	if text := json.Get("chunkTemplate").String(); text != "" {
+		if config.ChunkTemplate, err = ParsePromptTemplate(text, ragTemplateOptions); err != nil {
+			return nil, fmt.Errorf("invalid rag chunk template: %v", err)
+		}
+	}
+	if text := json.Get("contextTemplate").String(); text != "" {
+		if config.ContextTemplate, err = ParsePromptTemplate(text, ragTemplateOptions); err != nil {
+			return nil, fmt.Errorf("invalid rag context template: %v", err)
+		}
+	}
+	return NewRAGPipeline(config)
+}
+
+func lastUserMessage(request *ChatCompletionRequest) string {
+	for i := len(request.Messages) - 1; i >= 0; i-- {
+		if request.Messages[i].Role == RoleUser {
+			return request.Messages[i].StringContent()
+		}
+	}
+	return ""
+}
+
+// Run augments the messages of the request in place, then calls the callback. On a failed stage the callback gets
+// the error if the fallback is fail, or nil with the request unchanged if it's skip. The callback is not called
+// if an error is returned.
+func (p *RAGPipeline) Run(request *ChatCompletionRequest, callback func(result *RAGResult, err error)) error {
+	result := &RAGResult{Query: strings.TrimSpace(p.config.Query(request))}
+	if result.Query == "" {
+		callback(result, nil)
+		return nil
+	}
+	err := p.config.Embeddings.Embed([]string{result.Query}, func(vectors [][]float64, err error) {
+		if err != nil {
+			p.fail(result, RAGStageEmbed, err, callback)
+			return
+		}
+		err = p.config.Store.Query(vectors[0], p.config.TopK, func(results []VectorSearchResult, err error) {
+			if err != nil {
+				p.fail(result, RAGStageSearch, err, callback)
+				return
+			}
+			if err := p.inject(request, result, results); err != nil {
+				p.fail(result, RAGStageRender, err, callback)
+				return
+			}
+			callback(result, nil)
+		})
+		if err != nil {
+			p.fail(result, RAGStageSearch, err, callback)
+		}
+	})
+	if err != nil {
+		if p.config.Fallback == RAGFallbackFail {
+			return err
+		}
+		p.fail(result, RAGStageEmbed, err, callback)
+	}
+	return nil
+}
+
+func (p *RAGPipeline) fail(result *RAGResult, stage string, err error, callback func(*RAGResult, error)) {
+	result.FailedStage, result.Err = stage, err
+	if p.config.Fallback == RAGFallbackFail {
+		callback(result, fmt.Errorf("rag %s failed: %v", stage, err))
+		return
+	}
+	callback(result, nil)
+}
+
+// selectChunks keeps the chunks accepted by the matcher within the token budget.
+func (p *RAGPipeline) selectChunks(model string, results []VectorSearchResult) []VectorSearchResult {
+	var chunks []VectorSearchResult
+	tokens := 0
+	for _, result := range results {
+		if result.Content == "" || p.config.Matcher != nil && !p.config.Matcher.Accept(result.Score) {
+			continue
+		}
+		if p.config.MaxContextTokens > 0 {
+			tokens += CountTokens(model, result.Content)
+			if tokens > p.config.MaxContextTokens {
+				break
+			}
+		}
+		chunks = append(chunks, result)
+	}
+	return chunks
+}
+
+func chunkTemplateSource(index int, chunk VectorSearchResult) TemplateSource {
+	return func(name string) (string, bool) {
+		switch name {
+		case "content":
+			return chunk.Content, true
+		case "id":
+			return chunk.ID, true
+		case "score":
+			return strconv.FormatFloat(chunk.Score, 'f', -1, 64), true
+		case "index":
+			return strconv.Itoa(index), true
+		}
+		value, ok := chunk.Metadata[name]
+		if !ok || value == nil {
+			return "", false
+		}
+		return fmt.Sprint(value), true
+	}
+}
+
+func (p *RAGPipeline) inject(request *ChatCompletionRequest, result *RAGResult, results []VectorSearchResult) error {
+	chunks := p.selectChunks(request.Model, results)
+	if len(chunks) == 0 {
+		return nil
+	}
+	rendered := make([]string, 0, len(chunks))
+	for i, chunk := range chunks {
+		text, err := p.config.ChunkTemplate.Render(TemplateSources{"chunk": chunkTemplateSource(i+1, chunk)})
+		if err != nil {
+			return err
+		}
+		rendered = append(rendered, text)
+	}
+	context, err := p.config.ContextTemplate.Render(TemplateSources{"rag": MapTemplateSource(map[string]string{
+		"context": strings.Join(rendered, "\n\n"),
+		"query":   result.Query,
+	})})
+	if err != nil {
+		return err
+	}
+	if p.config.Inject == RAGInjectUser {
+		injectUserContext(request, context)
+	} else {
+		injectSystemContext(request, context)
+	}
+	result.Chunks, result.Context = chunks, context
+	return nil
+}
+
+func injectSystemContext(request *ChatCompletionRequest, context string) {
+	i := 0
+	for i < len(request.Messages) && request.Messages[i].Role == RoleSystem {
+		i++
+	}
+	messages := make([]ChatMessage, 0, len(request.Messages)+1)
+	messages = append(messages, request.Messages[:i]...)
+	messages = append(messages, ChatMessage{Role: RoleSystem, Content: context})
+	request.Messages = append(messages, request.Messages[i:]...)
+}
+
+func injectUserContext(request *ChatCompletionRequest, context string) {
+	for i := len(request.Messages) - 1; i >= 0; i-- {
+		message := &request.Messages[i]
+		if message.Role != RoleUser {
+			continue
+		}
+		if text, ok := message.Content.(string); ok {
+			message.Content = context + "\n\n" + text
+		} else {
+			message.Content = append([]ContentPart{{Type: ContentTypeText, Text: context}}, message.ContentParts()...)
+		}
+		return
+	}
+}
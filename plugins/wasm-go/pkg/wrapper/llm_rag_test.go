@@ -0,0 +1,127 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+type fakeVectorStore struct {
+	VectorStore
+	results []VectorSearchResult
+	err     error
+	topK    int
+}
+
+func (s *fakeVectorStore) Query(vector []float64, topK int, callback func([]VectorSearchResult, error)) error {
+	s.topK = topK
+	if s.err != nil {
+		callback(nil, s.err)
+		return nil
+	}
+	callback(s.results, nil)
+	return nil
+}
+
+func ragTestRequest() *ChatCompletionRequest {
+	return &ChatCompletionRequest{Model: "gpt-4", Messages: []ChatMessage{
+		{Role: RoleSystem, Content: "be brief"},
+		{Role: RoleUser, Content: "what is higress?"},
+	}}
+}
+
+func TestRAGPipeline(t *testing.T) {
+	store := &fakeVectorStore{results: []VectorSearchResult{
+		{ID: "1", Score: 0.1, Content: "Higress is an AI gateway.", Metadata: map[string]interface{}{"title": "intro"}},
+		{ID: "2", Score: 0.9, Content: "unrelated"},
+		{ID: "3", Score: 0.2, Content: "It is based on Envoy."},
+	}}
+	chunkTemplate, err := ParsePromptTemplate("[{{chunk.index}}] {{chunk.title | untitled}}: {{chunk.content}}", ragTemplateOptions)
+	require.NoError(t, err)
+	pipeline, err := NewRAGPipeline(RAGPipelineConfig{
+		Embeddings:    NewEmbeddingsClient(fakeEmbeddingsProvider{}, "text-embedding-v2"),
+		Store:         store,
+		Matcher:       &SemanticMatcher{Threshold: 0.5},
+		ChunkTemplate: chunkTemplate,
+	})
+	require.NoError(t, err)
+	request := ragTestRequest()
+	var result *RAGResult
+	require.NoError(t, pipeline.Run(request, func(r *RAGResult, err error) {
+		require.NoError(t, err)
+		result = r
+	}))
+	assert.Equal(t, 3, store.topK)
+	assert.Equal(t, "what is higress?", result.Query)
+	assert.Len(t, result.Chunks, 2)
+	require.Len(t, request.Messages, 3)
+	assert.Equal(t, "Answer the question with the context below if it's relevant.\n\nContext:\n"+
+		"[1] intro: Higress is an AI gateway.\n\n[2] untitled: It is based on Envoy.", request.Messages[1].Content)
+	assert.Equal(t, RoleUser, request.Messages[2].Role)
+
+	// the chunks beyond the budget are dropped, and the context is prepended to the user message
+	contextTemplate, err := ParsePromptTemplate("{{rag.context}}", ragTemplateOptions)
+	require.NoError(t, err)
+	pipeline, err = NewRAGPipeline(RAGPipelineConfig{
+		Embeddings:       NewEmbeddingsClient(fakeEmbeddingsProvider{}, "text-embedding-v2"),
+		Store:            store,
+		MaxContextTokens: 8,
+		ContextTemplate:  contextTemplate,
+		Inject:           RAGInjectUser,
+	})
+	require.NoError(t, err)
+	request = ragTestRequest()
+	require.NoError(t, pipeline.Run(request, func(r *RAGResult, err error) {
+		require.NoError(t, err)
+		result = r
+	}))
+	assert.Len(t, result.Chunks, 2)
+	assert.Equal(t, "Higress is an AI gateway.\n\nunrelated\n\nwhat is higress?", request.Messages[1].Content)
+}
+
+func TestRAGPipelineFallback(t *testing.T) {
+	store := &fakeVectorStore{err: errors.New("timeout")}
+	config := RAGPipelineConfig{Embeddings: NewEmbeddingsClient(fakeEmbeddingsProvider{}, "m"), Store: store}
+	pipeline, err := NewRAGPipeline(config)
+	require.NoError(t, err)
+	request := ragTestRequest()
+	called := false
+	require.NoError(t, pipeline.Run(request, func(result *RAGResult, err error) {
+		require.NoError(t, err)
+		assert.Equal(t, RAGStageSearch, result.FailedStage)
+		assert.False(t, result.Injected())
+		called = true
+	}))
+	assert.True(t, called)
+	assert.Len(t, request.Messages, 2)
+
+	config.Fallback = RAGFallbackFail
+	pipeline, err = NewRAGPipeline(config)
+	require.NoError(t, err)
+	require.NoError(t, pipeline.Run(ragTestRequest(), func(result *RAGResult, err error) {
+		assert.EqualError(t, err, "rag search failed: timeout")
+	}))
+
+	config.Fallback = "retry"
+	_, err = NewRAGPipeline(config)
+	assert.Error(t, err)
+	_, err = ParseRAGPipeline(gjson.Parse(`{"embeddings": {"type": "qwen", "serviceName": "dashscope.dns"}}`))
+	assert.ErrorContains(t, err, "invalid rag vector store")
+}
@@ -0,0 +1,236 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+const SessionIDHeader = "x-session-id"
+
+// Append the messages, keep the last ARGV[1] ones and refresh the ttl, returns the length of the history
+const sessionAppendScript = `
+for i = 3, #ARGV do
+  redis.call('rpush', KEYS[1], ARGV[i])
+end
+redis.call('ltrim', KEYS[1], -tonumber(ARGV[1]), -1)
+redis.call('expire', KEYS[1], ARGV[2])
+return redis.call('llen', KEYS[1])
+`
+
+// Replace the first ARGV[1] messages with the summary ARGV[3] and refresh the ttl. The messages appended after the
+// history was loaded for summarizing are kept, since only the head of the list is replaced.
+const sessionSummarizeScript = `
+redis.call('ltrim', KEYS[1], ARGV[1], -1)
+redis.call('lpush', KEYS[1], ARGV[3])
+redis.call('expire', KEYS[1], ARGV[2])
+return redis.call('llen', KEYS[1])
+`
+
+type SessionConfig struct {
+	// The prefix of the redis keys, default is "session:"
+	KeyPrefix string
+	// The max messages kept in the history, the oldest ones are dropped first, default is 20
+	MaxMessages int
+	// The history expires if the session is idle for the seconds, default is 3600
+	TTL int
+	// The older messages are summarized once the history has more messages than the threshold, 0 means never.
+	// It should be less than MaxMessages, otherwise the messages are dropped before being summarized.
+	SummarizeThreshold int
+	// The recent messages kept as they are when summarizing, default is half of the threshold
+	KeepRecent int
+}
+
+func ParseSessionConfig(json gjson.Result) (SessionConfig, error) {
+	config := SessionConfig{
+		KeyPrefix:          json.Get("keyPrefix").String(),
+		MaxMessages:        int(json.Get("maxMessages").Int()),
+		TTL:                int(json.Get("ttl").Int()),
+		SummarizeThreshold: int(json.Get("summarizeThreshold").Int()),
+		KeepRecent:         int(json.Get("keepRecent").Int()),
+	}
+	config.setDefaults()
+	if config.SummarizeThreshold > 0 && config.KeepRecent >= config.SummarizeThreshold {
+		return config, errors.New("keepRecent should be less than summarizeThreshold")
+	}
+	return config, nil
+}
+
+func (c *SessionConfig) setDefaults() {
+	if c.KeyPrefix == "" {
+		c.KeyPrefix = "session:"
+	}
+	if c.MaxMessages <= 0 {
+		c.MaxMessages = 20
+	}
+	if c.TTL <= 0 {
+		c.TTL = 3600
+	}
+	if c.SummarizeThreshold > 0 && c.KeepRecent <= 0 {
+		c.KeepRecent = c.SummarizeThreshold / 2
+	}
+}
+
+// SessionKey scopes the session id by the consumer, so a caller can't read the history of others by guessing the id.
+func SessionKey(consumer, sessionID string) string {
+	return consumer + ":" + sessionID
+}
+
+// SessionSummarizer condenses the older messages into one, usually by calling a model with LLMProvider.
+type SessionSummarizer func(messages []ChatMessage, callback func(summary *ChatMessage, err error)) error
+
+// SessionStore keeps the multi-turn history of the sessions.
+type SessionStore interface {
+	// Load reads the history of the session, it's empty if the session doesn't exist or has expired
+	Load(key string, callback func(history []ChatMessage, err error)) error
+	// Append adds the messages of the turn to the history and refreshes the ttl
+	Append(key string, messages []ChatMessage, callback func(err error)) error
+	Clear(key string, callback func(err error)) error
+}
+
+// RedisSessionStore keeps the history of a session in a redis list of json encoded messages.
+type RedisSessionStore struct {
+	client RedisClient
+	config SessionConfig
+	// Summarizer is called after Append when the history exceeds the threshold, nil disables the summarization
+	Summarizer SessionSummarizer
+}
+
+func NewRedisSessionStore(client RedisClient, config SessionConfig) *RedisSessionStore {
+	config.setDefaults()
+	return &RedisSessionStore{client: client, config: config}
+}
+
+func (s *RedisSessionStore) redisKey(key string) string {
+	return s.config.KeyPrefix + key
+}
+
+func (s *RedisSessionStore) Load(key string, callback func(history []ChatMessage, err error)) error {
+	return s.client.LRange(s.redisKey(key), 0, -1, func(response resp.Value) {
+		if response.Error() != nil {
+			callback(nil, response.Error())
+			return
+		}
+		callback(decodeSessionHistory(response), nil)
+	})
+}
+
+func decodeSessionHistory(response resp.Value) []ChatMessage {
+	var history []ChatMessage
+	for _, item := range response.Array() {
+		var message ChatMessage
+		// skip the broken items instead of losing the whole history
+		if err := json.Unmarshal(item.Bytes(), &message); err != nil {
+			continue
+		}
+		history = append(history, message)
+	}
+	return history
+}
+
+func (s *RedisSessionStore) Append(key string, messages []ChatMessage, callback func(err error)) error {
+	if len(messages) == 0 {
+		callback(nil)
+		return nil
+	}
+	args := []interface{}{s.config.MaxMessages, s.config.TTL}
+	for _, message := range messages {
+		data, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		args = append(args, string(data))
+	}
+	return s.client.Eval(sessionAppendScript, 1, []interface{}{s.redisKey(key)}, args, func(response resp.Value) {
+		if response.Error() != nil {
+			callback(response.Error())
+			return
+		}
+		if s.Summarizer == nil || s.config.SummarizeThreshold <= 0 || response.Integer() <= s.config.SummarizeThreshold {
+			callback(nil)
+			return
+		}
+		if err := s.summarize(key, callback); err != nil {
+			callback(err)
+		}
+	})
+}
+
+func (s *RedisSessionStore) summarize(key string, callback func(err error)) error {
+	return s.Load(key, func(history []ChatMessage, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+		count := len(history) - s.config.KeepRecent
+		if count <= 0 {
+			callback(nil)
+			return
+		}
+		err = s.Summarizer(history[:count], func(summary *ChatMessage, err error) {
+			if err != nil {
+				callback(err)
+				return
+			}
+			data, err := json.Marshal(summary)
+			if err != nil {
+				callback(err)
+				return
+			}
+			err = s.client.Eval(sessionSummarizeScript, 1, []interface{}{s.redisKey(key)}, []interface{}{count, s.config.TTL, string(data)}, func(response resp.Value) {
+				callback(response.Error())
+			})
+			if err != nil {
+				callback(err)
+			}
+		})
+		if err != nil {
+			callback(err)
+		}
+	})
+}
+
+func (s *RedisSessionStore) Clear(key string, callback func(err error)) error {
+	return s.client.Del(s.redisKey(key), func(response resp.Value) {
+		callback(response.Error())
+	})
+}
+
+// SessionSummaryMessage wraps the summary text as the system message replacing the summarized history.
+func SessionSummaryMessage(summary string) *ChatMessage {
+	return &ChatMessage{Role: RoleSystem, Content: "Summary of the earlier conversation:\n" + strings.TrimSpace(summary)}
+}
+
+// InjectHistory inserts the history after the leading system messages of the request,
+// so the system prompt of the current request stays first.
+func InjectHistory(request *ChatCompletionRequest, history []ChatMessage) {
+	if len(history) == 0 {
+		return
+	}
+	i := 0
+	for i < len(request.Messages) && request.Messages[i].Role == RoleSystem {
+		i++
+	}
+	messages := make([]ChatMessage, 0, len(request.Messages)+len(history))
+	messages = append(messages, request.Messages[:i]...)
+	messages = append(messages, history...)
+	messages = append(messages, request.Messages[i:]...)
+	request.Messages = messages
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+// fakeSessionRedis runs the session scripts against in-memory lists
+type fakeSessionRedis struct {
+	RedisClient
+	lists map[string][]string
+}
+
+func (r *fakeSessionRedis) LRange(key string, start, stop int, callback RedisResponseCallback) error {
+	var values []resp.Value
+	for _, item := range r.lists[key] {
+		values = append(values, resp.StringValue(item))
+	}
+	callback(resp.ArrayValue(values))
+	return nil
+}
+
+func (r *fakeSessionRedis) Del(key string, callback RedisResponseCallback) error {
+	delete(r.lists, key)
+	callback(resp.IntegerValue(1))
+	return nil
+}
+
+func (r *fakeSessionRedis) Eval(script string, numkeys int, keys, args []interface{}, callback RedisResponseCallback) error {
+	key := keys[0].(string)
+	list := r.lists[key]
+	switch script {
+	case sessionAppendScript:
+		for _, arg := range args[2:] {
+			list = append(list, arg.(string))
+		}
+		if limit := args[0].(int); len(list) > limit {
+			list = list[len(list)-limit:]
+		}
+	case sessionSummarizeScript:
+		list = append([]string{args[2].(string)}, list[args[0].(int):]...)
+	}
+	r.lists[key] = list
+	callback(resp.IntegerValue(len(list)))
+	return nil
+}
+
+func sessionTurn(i int) []ChatMessage {
+	return []ChatMessage{
+		{Role: RoleUser, Content: fmt.Sprintf("q%d", i)},
+		{Role: RoleAssistant, Content: fmt.Sprintf("a%d", i)},
+	}
+}
+
+func loadSession(t *testing.T, store SessionStore, key string) []ChatMessage {
+	var history []ChatMessage
+	require.NoError(t, store.Load(key, func(h []ChatMessage, err error) {
+		require.NoError(t, err)
+		history = h
+	}))
+	return history
+}
+
+func TestRedisSessionStoreBounded(t *testing.T) {
+	redis := &fakeSessionRedis{lists: map[string][]string{}}
+	store := NewRedisSessionStore(redis, SessionConfig{MaxMessages: 4})
+	key := SessionKey("consumer1", "s1")
+	for i := 0; i < 3; i++ {
+		require.NoError(t, store.Append(key, sessionTurn(i), func(err error) { require.NoError(t, err) }))
+	}
+	history := loadSession(t, store, key)
+	require.Len(t, history, 4)
+	assert.Equal(t, "q1", history[0].StringContent())
+	assert.Contains(t, redis.lists, "session:consumer1:s1")
+
+	require.NoError(t, store.Clear(key, func(err error) { require.NoError(t, err) }))
+	assert.Empty(t, loadSession(t, store, key))
+}
+
+func TestRedisSessionStoreSummarize(t *testing.T) {
+	redis := &fakeSessionRedis{lists: map[string][]string{}}
+	config, err := ParseSessionConfig(gjson.Parse(`{"maxMessages": 10, "summarizeThreshold": 4}`))
+	require.NoError(t, err)
+	assert.Equal(t, 2, config.KeepRecent)
+	store := NewRedisSessionStore(redis, config)
+	var summarized []ChatMessage
+	store.Summarizer = func(messages []ChatMessage, callback func(*ChatMessage, error)) error {
+		summarized = messages
+		callback(SessionSummaryMessage(fmt.Sprintf("%d messages", len(messages))), nil)
+		return nil
+	}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, store.Append("s1", sessionTurn(i), func(err error) { require.NoError(t, err) }))
+	}
+	assert.Len(t, summarized, 4)
+	history := loadSession(t, store, "s1")
+	require.Len(t, history, 3)
+	assert.Equal(t, RoleSystem, history[0].Role)
+	assert.Contains(t, history[0].StringContent(), "4 messages")
+	assert.Equal(t, "q2", history[1].StringContent())
+
+	_, err = ParseSessionConfig(gjson.Parse(`{"summarizeThreshold": 4, "keepRecent": 4}`))
+	assert.Error(t, err)
+}
+
+func TestInjectHistory(t *testing.T) {
+	request := &ChatCompletionRequest{Messages: []ChatMessage{
+		{Role: RoleSystem, Content: "sys"},
+		{Role: RoleUser, Content: "q2"},
+	}}
+	InjectHistory(request, sessionTurn(1))
+	var roles []string
+	for _, message := range request.Messages {
+		roles = append(roles, message.StringContent())
+	}
+	assert.Equal(t, []string{"sys", "q1", "a1", "q2"}, roles)
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+const streamingTokenCounterContextKey = "__streaming_token_counter__"
+
+// StreamingTokenCounter counts the completion tokens of an OpenAI compatible event stream while the chunks pass.
+// The counts are estimated from the deltas until the usage is reported by the model, usually in the last chunk.
+type StreamingTokenCounter struct {
+	// The model of the tokenizer, it's taken from the chunks if empty
+	Model string
+	// The estimated or reported prompt tokens
+	PromptTokens int
+	// The max completion tokens allowed, 0 means no limit
+	Budget int
+
+	completionTokens int
+	usageReported    bool
+	done             bool
+	id               string
+	parser           SSEParser
+}
+
+func NewStreamingTokenCounter(model string, promptTokens, budget int) *StreamingTokenCounter {
+	return &StreamingTokenCounter{Model: model, PromptTokens: promptTokens, Budget: budget}
+}
+
+// Feed parses the chunk of the response body and updates the counts.
+// It returns true if the completion tokens exceed the budget.
+func (c *StreamingTokenCounter) Feed(chunk []byte) bool {
+	for _, event := range c.parser.Feed(chunk) {
+		c.onEvent(event)
+	}
+	return c.Exceeded()
+}
+
+// Finish parses the remaining data at the end of the stream.
+func (c *StreamingTokenCounter) Finish() {
+	for _, event := range c.parser.Flush() {
+		c.onEvent(event)
+	}
+	c.done = true
+}
+
+func (c *StreamingTokenCounter) onEvent(event SSEEvent) {
+	if event.Data == SSEDone {
+		c.done = true
+		return
+	}
+	if event.Data == "" {
+		return
+	}
+	var chunk ChatCompletionResponse
+	if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+		return
+	}
+	if c.Model == "" {
+		c.Model = chunk.Model
+	}
+	if c.id == "" {
+		c.id = chunk.ID
+	}
+	if chunk.Usage != nil && chunk.Usage.CompletionTokens > 0 {
+		c.usageReported = true
+		c.completionTokens = chunk.Usage.CompletionTokens
+		if chunk.Usage.PromptTokens > 0 {
+			c.PromptTokens = chunk.Usage.PromptTokens
+		}
+		return
+	}
+	if c.usageReported {
+		return
+	}
+	tokenizer := TokenizerForModel(c.Model)
+	for _, choice := range chunk.Choices {
+		if choice.Delta == nil {
+			continue
+		}
+		if text := choice.Delta.StringContent(); text != "" {
+			c.completionTokens += tokenizer.CountTokens(text)
+		}
+		for _, call := range choice.Delta.ToolCalls {
+			c.completionTokens += tokenizer.CountTokens(call.Function.Name + call.Function.Arguments)
+		}
+	}
+}
+
+func (c *StreamingTokenCounter) CompletionTokens() int {
+	return c.completionTokens
+}
+
+func (c *StreamingTokenCounter) Usage() Usage {
+	return Usage{
+		PromptTokens:     c.PromptTokens,
+		CompletionTokens: c.completionTokens,
+		TotalTokens:      c.PromptTokens + c.completionTokens,
+	}
+}
+
+// UsageReported returns true if the counts are reported by the model instead of estimated.
+func (c *StreamingTokenCounter) UsageReported() bool {
+	return c.usageReported
+}
+
+func (c *StreamingTokenCounter) Done() bool {
+	return c.done
+}
+
+func (c *StreamingTokenCounter) Exceeded() bool {
+	return c.Budget > 0 && c.completionTokens > c.Budget
+}
+
+// CutoffEvents returns the events ending the stream early, a chunk with the length finish reason and the usage,
+// followed by [DONE]. The plugin should replace the rest of the response body with them once the budget is exceeded.
+func (c *StreamingTokenCounter) CutoffEvents() []byte {
+	usage := c.Usage()
+	chunk := ChatCompletionResponse{
+		ID:      c.id,
+		Object:  "chat.completion.chunk",
+		Model:   c.Model,
+		Choices: []ChatCompletionChoice{{Delta: &ChatMessage{}, FinishReason: FinishReasonLength}},
+		Usage:   &usage,
+	}
+	data, _ := json.Marshal(chunk)
+	var buf bytes.Buffer
+	buf.Write(SSEEvent{Data: string(data)}.Bytes())
+	buf.Write(SSEEvent{Data: SSEDone}.Bytes())
+	c.done = true
+	return buf.Bytes()
+}
+
+// GetStreamingTokenCounter returns the counter of the response stream, it's created on the first call. Feed it with the
+// chunks in onHttpStreamingResponseBody, so the running totals are available to the plugin.
+func GetStreamingTokenCounter(ctx HttpContext) *StreamingTokenCounter {
+	if c, ok := ctx.(httpContextExtension); ok {
+		return c.streamingTokenCounter()
+	}
+	return &StreamingTokenCounter{}
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) streamingTokenCounter() *StreamingTokenCounter {
+	if counter, ok := ctx.userContext[streamingTokenCounterContextKey].(*StreamingTokenCounter); ok {
+		return counter
+	}
+	counter := &StreamingTokenCounter{}
+	ctx.userContext[streamingTokenCounterContextKey] = counter
+	return counter
+}
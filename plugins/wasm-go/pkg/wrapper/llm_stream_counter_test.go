@@ -0,0 +1,58 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestStreamingTokenCounter(t *testing.T) {
+	counter := NewStreamingTokenCounter("", 20, 0)
+	assert.False(t, counter.Feed([]byte(`data: {"id":"c1","model":"gpt-4","choices":[{"delta":{"role":"assistant","content":"Hello"}}]}`+"\n\ndata: {\"id\":\"c1\",\"choices\":[{\"del")))
+	assert.Equal(t, "gpt-4", counter.Model)
+	assert.Equal(t, 1, counter.CompletionTokens())
+
+	counter.Feed([]byte(`ta":{"content":" world"}}]}` + "\n\n"))
+	assert.Equal(t, 2, counter.CompletionTokens())
+	assert.False(t, counter.UsageReported())
+
+	counter.Feed([]byte(`data: {"id":"c1","choices":[],"usage":{"prompt_tokens":12,"completion_tokens":3,"total_tokens":15}}` + "\n\ndata: [DONE]\n\n"))
+	assert.True(t, counter.UsageReported())
+	assert.True(t, counter.Done())
+	assert.Equal(t, Usage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15}, counter.Usage())
+}
+
+func TestStreamingTokenCounterBudget(t *testing.T) {
+	counter := NewStreamingTokenCounter("gpt-4", 10, 3)
+	exceeded := false
+	for _, word := range []string{"one", " two", " three", " four"} {
+		exceeded = counter.Feed([]byte(`data: {"id":"c2","choices":[{"delta":{"content":"` + word + `"}}]}` + "\n\n"))
+	}
+	require.True(t, exceeded)
+
+	events := strings.Split(strings.TrimSpace(string(counter.CutoffEvents())), "\n\n")
+	require.Len(t, events, 2)
+	last := gjson.Parse(strings.TrimPrefix(events[0], "data: "))
+	assert.Equal(t, "c2", last.Get("id").String())
+	assert.Equal(t, FinishReasonLength, last.Get("choices.0.finish_reason").String())
+	assert.Equal(t, int64(14), last.Get("usage.total_tokens").Int())
+	assert.Equal(t, "data: [DONE]", events[1])
+	assert.True(t, counter.Done())
+}
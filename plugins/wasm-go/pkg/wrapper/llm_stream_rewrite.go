@@ -0,0 +1,329 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+)
+
+const streamRewriterContextKey = "__stream_rewriter__"
+
+// DeltaTransformer rewrites the chunks of an OpenAI compatible response stream.
+type DeltaTransformer interface {
+	// Transform returns the chunks replacing the chunk, an empty result drops it. The chunk may be modified in place.
+	Transform(chunk *ChatCompletionResponse) []*ChatCompletionResponse
+	// Finish returns the chunks injected at the end of the stream, before [DONE]
+	Finish() []*ChatCompletionResponse
+}
+
+// DeltaTransformerFunc is a stateless transformer injecting nothing at the end.
+type DeltaTransformerFunc func(chunk *ChatCompletionResponse) []*ChatCompletionResponse
+
+func (f DeltaTransformerFunc) Transform(chunk *ChatCompletionResponse) []*ChatCompletionResponse {
+	return f(chunk)
+}
+
+func (f DeltaTransformerFunc) Finish() []*ChatCompletionResponse {
+	return nil
+}
+
+// MapDeltaText rewrites the text of each delta, the text split across the deltas is not seen as a whole,
+// see ReplaceDeltaStrings for that.
+func MapDeltaText(f func(text string) string) DeltaTransformer {
+	return DeltaTransformerFunc(func(chunk *ChatCompletionResponse) []*ChatCompletionResponse {
+		for _, choice := range chunk.Choices {
+			if choice.Delta != nil {
+				if text, ok := choice.Delta.Content.(string); ok && text != "" {
+					choice.Delta.Content = f(text)
+				}
+			}
+		}
+		return []*ChatCompletionResponse{chunk}
+	})
+}
+
+// DropChunks drops the chunks matching the predicate.
+func DropChunks(drop func(chunk *ChatCompletionResponse) bool) DeltaTransformer {
+	return DeltaTransformerFunc(func(chunk *ChatCompletionResponse) []*ChatCompletionResponse {
+		if drop(chunk) {
+			return nil
+		}
+		return []*ChatCompletionResponse{chunk}
+	})
+}
+
+// chunkLike returns a chunk with the id and model of the template, holding the delta of the choice.
+func chunkLike(template *ChatCompletionResponse, index int, delta *ChatMessage) *ChatCompletionResponse {
+	return &ChatCompletionResponse{
+		ID:      template.ID,
+		Object:  "chat.completion.chunk",
+		Created: template.Created,
+		Model:   template.Model,
+		Choices: []ChatCompletionChoice{{Index: index, Delta: delta}},
+	}
+}
+
+// emptyChunk is true if the chunk carries nothing after its text is taken.
+func emptyChunk(chunk *ChatCompletionResponse) bool {
+	if chunk.Usage != nil {
+		return false
+	}
+	for _, choice := range chunk.Choices {
+		if choice.FinishReason != "" {
+			return false
+		}
+		if delta := choice.Delta; delta != nil && (delta.Role != "" || len(delta.ToolCalls) > 0 || delta.StringContent() != "") {
+			return false
+		}
+	}
+	return true
+}
+
+// deltaReplacer replaces the strings across the deltas, the tail of the text which may start an old string is
+// held until the next delta of the choice, or the chunk finishing the choice.
+type deltaReplacer struct {
+	oldnew   []string
+	held     map[int]string
+	template *ChatCompletionResponse
+}
+
+// ReplaceDeltaStrings replaces the old strings with the new ones like strings.NewReplacer, including the old
+// strings split across the deltas.
+func ReplaceDeltaStrings(oldnew ...string) DeltaTransformer {
+	if len(oldnew)%2 == 1 {
+		panic("ReplaceDeltaStrings: odd argument count")
+	}
+	return &deltaReplacer{oldnew: oldnew, held: map[int]string{}}
+}
+
+// replace returns the replaced text and the tail held back.
+func (r *deltaReplacer) replace(text string) (string, string) {
+	var out strings.Builder
+	for i := 0; i < len(text); {
+		matched, partial := false, false
+		for j := 0; j < len(r.oldnew); j += 2 {
+			old := r.oldnew[j]
+			if old == "" {
+				continue
+			}
+			if strings.HasPrefix(text[i:], old) {
+				out.WriteString(r.oldnew[j+1])
+				i += len(old)
+				matched = true
+				break
+			}
+			if strings.HasPrefix(old, text[i:]) {
+				partial = true
+			}
+		}
+		if matched {
+			continue
+		}
+		if partial {
+			return out.String(), text[i:]
+		}
+		out.WriteByte(text[i])
+		i++
+	}
+	return out.String(), ""
+}
+
+func (r *deltaReplacer) Transform(chunk *ChatCompletionResponse) []*ChatCompletionResponse {
+	r.template = chunk
+	for i := range chunk.Choices {
+		choice := &chunk.Choices[i]
+		held := r.held[choice.Index]
+		if choice.Delta == nil {
+			if choice.FinishReason != "" && held != "" {
+				choice.Delta = &ChatMessage{}
+			} else {
+				continue
+			}
+		}
+		text, _ := choice.Delta.Content.(string)
+		replaced, tail := r.replace(held + text)
+		if choice.FinishReason != "" {
+			// nothing follows, the tail can't be completed
+			replaced, tail = replaced+tail, ""
+		}
+		r.held[choice.Index] = tail
+		if replaced != "" || choice.Delta.Content != nil {
+			choice.Delta.Content = replaced
+		}
+	}
+	if emptyChunk(chunk) {
+		return nil
+	}
+	return []*ChatCompletionResponse{chunk}
+}
+
+func (r *deltaReplacer) Finish() []*ChatCompletionResponse {
+	var chunks []*ChatCompletionResponse
+	for index, held := range r.held {
+		if held != "" {
+			chunks = append(chunks, chunkLike(r.template, index, &ChatMessage{Content: held}))
+		}
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Choices[0].Index < chunks[j].Choices[0].Index })
+	r.held = map[int]string{}
+	return chunks
+}
+
+// deltaInjector injects the text chunks before the first content and before the first choice finishing.
+type deltaInjector struct {
+	prefix, suffix     string
+	prefixed, suffixed bool
+	template           *ChatCompletionResponse
+}
+
+// InjectDeltaText injects the prefix as a delta before the first text of the stream, and the suffix before the
+// chunk finishing the first choice, or at the end of the stream if it never finishes. Either may be empty.
+func InjectDeltaText(prefix, suffix string) DeltaTransformer {
+	return &deltaInjector{prefix: prefix, suffix: suffix, prefixed: prefix == "", suffixed: suffix == ""}
+}
+
+func (d *deltaInjector) Transform(chunk *ChatCompletionResponse) []*ChatCompletionResponse {
+	d.template = chunk
+	chunks := []*ChatCompletionResponse{chunk}
+	if len(chunk.Choices) == 0 {
+		return chunks
+	}
+	choice := chunk.Choices[0]
+	if !d.prefixed && choice.Delta != nil && (choice.Delta.StringContent() != "" || choice.FinishReason != "") {
+		d.prefixed = true
+		chunks = append([]*ChatCompletionResponse{chunkLike(chunk, choice.Index, &ChatMessage{Content: d.prefix})}, chunks...)
+	}
+	if !d.suffixed && choice.FinishReason != "" {
+		d.suffixed = true
+		chunks = append(chunks[:len(chunks)-1], chunkLike(chunk, choice.Index, &ChatMessage{Content: d.suffix}), chunk)
+	}
+	return chunks
+}
+
+func (d *deltaInjector) Finish() []*ChatCompletionResponse {
+	if d.suffixed || d.template == nil {
+		return nil
+	}
+	d.suffixed = true
+	return []*ChatCompletionResponse{chunkLike(d.template, 0, &ChatMessage{Content: d.suffix})}
+}
+
+// StreamRewriter parses the response stream, passes the chunks through the transformers in order and serializes
+// them back. The events which are not chunks pass unchanged.
+type StreamRewriter struct {
+	transformers []DeltaTransformer
+	parser       SSEParser
+	finished     bool
+}
+
+func NewStreamRewriter(transformers ...DeltaTransformer) *StreamRewriter {
+	return &StreamRewriter{transformers: transformers}
+}
+
+// Rewrite returns the rewritten events of the complete events in the data, the partial event is held until the
+// next call. The transformers are finished on [DONE] or at the end of the stream.
+func (r *StreamRewriter) Rewrite(data []byte, endOfStream bool) []byte {
+	events := r.parser.Feed(data)
+	if endOfStream {
+		events = append(events, r.parser.Flush()...)
+	}
+	var buf bytes.Buffer
+	for _, event := range events {
+		if r.finished {
+			buf.Write(event.Bytes())
+			continue
+		}
+		if event.Data == SSEDone {
+			writeChunkEvents(&buf, r.finish())
+			buf.Write(event.Bytes())
+			continue
+		}
+		var chunk ChatCompletionResponse
+		if event.Event != "" || event.Data == "" || json.Unmarshal([]byte(event.Data), &chunk) != nil {
+			buf.Write(event.Bytes())
+			continue
+		}
+		chunks := []*ChatCompletionResponse{&chunk}
+		for _, transformer := range r.transformers {
+			chunks = transformChunks(transformer, chunks)
+		}
+		writeChunkEvents(&buf, chunks)
+	}
+	if endOfStream && !r.finished {
+		writeChunkEvents(&buf, r.finish())
+	}
+	return buf.Bytes()
+}
+
+func transformChunks(transformer DeltaTransformer, chunks []*ChatCompletionResponse) []*ChatCompletionResponse {
+	var transformed []*ChatCompletionResponse
+	for _, chunk := range chunks {
+		transformed = append(transformed, transformer.Transform(chunk)...)
+	}
+	return transformed
+}
+
+// finish passes the chunks injected by each transformer through the following ones.
+func (r *StreamRewriter) finish() []*ChatCompletionResponse {
+	r.finished = true
+	var chunks []*ChatCompletionResponse
+	for _, transformer := range r.transformers {
+		chunks = append(transformChunks(transformer, chunks), transformer.Finish()...)
+	}
+	return chunks
+}
+
+func writeChunkEvents(buf *bytes.Buffer, chunks []*ChatCompletionResponse) {
+	for _, chunk := range chunks {
+		data, _ := json.Marshal(chunk)
+		buf.Write(SSEEvent{Data: string(data)}.Bytes())
+	}
+}
+
+// PrepareStreamRewrite should be called in the response headers phase before rewriting the stream, it removes the
+// content-length since the size of the body changes. It returns false if the response is not an event stream or
+// it's encoded, which can't be rewritten.
+func PrepareStreamRewrite() bool {
+	contentType, _ := proxywasm.GetHttpResponseHeader("content-type")
+	if !strings.Contains(contentType, "text/event-stream") || IsBinaryResponseBody() {
+		return false
+	}
+	_ = proxywasm.RemoveHttpResponseHeader("content-length")
+	return true
+}
+
+// RewriteStreamingResponseBody returns the handler for ProcessStreamingResponseBodyBy, it rewrites the response
+// stream with the transformers created by newTransformers on the first chunk of each request. The body passes
+// unchanged if no transformer is returned, e.g. when PrepareStreamRewrite returned false.
+func RewriteStreamingResponseBody[PluginConfig any](newTransformers func(ctx HttpContext, config PluginConfig) []DeltaTransformer) onHttpStreamingBodyFunc[PluginConfig] {
+	return func(ctx HttpContext, config PluginConfig, chunk []byte, isLastChunk bool, log Log) []byte {
+		rewriter, ok := ctx.GetContext(streamRewriterContextKey).(*StreamRewriter)
+		if !ok {
+			if transformers := newTransformers(ctx, config); len(transformers) > 0 {
+				rewriter = NewStreamRewriter(transformers...)
+			}
+			ctx.SetContext(streamRewriterContextKey, rewriter)
+		}
+		if rewriter == nil {
+			return chunk
+		}
+		return rewriter.Rewrite(chunk, isLastChunk)
+	}
+}
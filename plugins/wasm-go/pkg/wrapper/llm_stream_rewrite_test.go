@@ -0,0 +1,94 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func textStream(deltas ...string) []byte {
+	var stream []byte
+	for i, delta := range deltas {
+		chunk := ChatCompletionResponse{ID: "chatcmpl-1", Model: "gpt-4o", Choices: []ChatCompletionChoice{{Delta: &ChatMessage{Content: delta}}}}
+		if i == len(deltas)-1 {
+			chunk.Choices[0].FinishReason = FinishReasonStop
+		}
+		data, _ := json.Marshal(chunk)
+		stream = append(stream, SSEEvent{Data: string(data)}.Bytes()...)
+	}
+	return append(stream, SSEEvent{Data: SSEDone}.Bytes()...)
+}
+
+// rewriteStream feeds the stream in small pieces, and returns the text deltas of the output and whether it ends with [DONE].
+func rewriteStream(t *testing.T, rewriter *StreamRewriter, stream []byte) ([]string, bool) {
+	var output []byte
+	for i := 0; i < len(stream); i += 7 {
+		end := i + 7
+		if end > len(stream) {
+			end = len(stream)
+		}
+		output = append(output, rewriter.Rewrite(stream[i:end], end == len(stream))...)
+	}
+	decoder, err := NewChatStreamDecoder(ChatProtocolOpenAI)
+	require.NoError(t, err)
+	chunks, err := decoder.Decode(output, true)
+	require.NoError(t, err)
+	var deltas []string
+	for _, chunk := range chunks {
+		deltas = append(deltas, chunk.Content())
+	}
+	return deltas, decoder.Done()
+}
+
+func TestReplaceDeltaStrings(t *testing.T) {
+	rewriter := NewStreamRewriter(ReplaceDeltaStrings("secret", "******", "ab", "x"))
+	deltas, done := rewriteStream(t, rewriter, textStream("my sec", "r", "et is a", "b", "c and se", "c"))
+	assert.True(t, done)
+	assert.Equal(t, "my ****** is xc and sec", strings.Join(deltas, ""))
+	// the deltas holding a prefix of an old string are dropped until it's resolved
+	assert.Equal(t, []string{"my ", "****** is ", "x", "c and ", "sec"}, deltas)
+
+	// the held text is flushed at the end of a stream without the finish reason and [DONE]
+	rewriter = NewStreamRewriter(ReplaceDeltaStrings("secret", "******"))
+	stream := []byte(`data: {"choices": [{"index": 0, "delta": {"content": "the se"}}]}` + "\n\n")
+	deltas, done = rewriteStream(t, rewriter, stream)
+	assert.False(t, done)
+	assert.Equal(t, []string{"the ", "se"}, deltas)
+}
+
+func TestStreamRewriterPipeline(t *testing.T) {
+	rewriter := NewStreamRewriter(
+		MapDeltaText(strings.ToUpper),
+		InjectDeltaText("[", "]"),
+		DropChunks(func(chunk *ChatCompletionResponse) bool { return chunk.Content() == "DROP" }),
+	)
+	deltas, done := rewriteStream(t, rewriter, textStream("a", "drop", "b"))
+	assert.True(t, done)
+	assert.Equal(t, []string{"[", "A", "]", "B"}, deltas)
+
+	// the suffix is injected at the end if no choice finishes, and the other events pass unchanged
+	rewriter = NewStreamRewriter(InjectDeltaText("", "!"))
+	stream := []byte("event: ping\ndata: {}\n\n" + `data: {"id": "x", "choices": [{"index": 0, "delta": {"content": "hi"}}]}` + "\n\ndata: [DONE]\n\n")
+	output := string(rewriter.Rewrite(stream, true))
+	assert.True(t, strings.HasPrefix(output, "event: ping\ndata: {}\n\n"))
+	assert.Contains(t, output, `"content":"!"`)
+	assert.True(t, strings.HasSuffix(output, "data: [DONE]\n\n"))
+	assert.Equal(t, "", string(rewriter.Rewrite(nil, true)))
+}
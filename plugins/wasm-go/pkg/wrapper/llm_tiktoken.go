@@ -0,0 +1,136 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+const (
+	tiktokenKeyPrefix      = "tiktoken:"
+	tiktokenLeaseKeyPrefix = "tiktoken-lease:"
+)
+
+type RemoteTokenizerConfig struct {
+	// Name identifies the ranks in shared data, e.g. cl100k_base
+	Name   string
+	Client HttpClient
+	// The url of the ranks in the tiktoken format, e.g. /cl100k_base.tiktoken served by an object storage
+	URL string
+	// The tokenizer counting the tokens before the ranks are loaded, default is HeuristicTokenizerCl100k
+	Fallback Tokenizer
+	// How soon a failed fetch is retried in milliseconds, default is 10000ms
+	RetryInterval int64
+	// The timeout of the fetch request in milliseconds, default is 10000ms
+	Timeout uint32
+}
+
+// RemoteTokenizer counts the tokens exactly with the BPE ranks of a model family, e.g. cl100k_base or o200k_base,
+// which are too large to be embedded in the plugin. The ranks are fetched on tick by only one of the VMs sharing the
+// data, and every VM parses the shared copy once, on tick as well. The fallback tokenizer counts the tokens meanwhile.
+// The parsed ranks take about 10MB of the memory of each VM for cl100k_base, and twice that for o200k_base. The text
+// is split like cl100k_base, so the counts of o200k_base may differ slightly around the punctuations and the cases.
+type RemoteTokenizer struct {
+	config RemoteTokenizerConfig
+	bpe    *BPETokenizer
+}
+
+// NewRemoteTokenizer should be called in parseConfig phase since it registers the tick function loading the ranks,
+// e.g. with RegisterTokenizer("gpt-4o", tokenizer).
+func NewRemoteTokenizer(config RemoteTokenizerConfig) (*RemoteTokenizer, error) {
+	if config.Name == "" {
+		return nil, errors.New("tokenizer name is empty")
+	}
+	if config.Client == nil || config.URL == "" {
+		return nil, errors.New("tokenizer client or url is empty")
+	}
+	if config.Fallback == nil {
+		config.Fallback = HeuristicTokenizerCl100k
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = 10000
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10000
+	}
+	t := &RemoteTokenizer{config: config}
+	leaseTTL := 3 * time.Duration(config.RetryInterval) * time.Millisecond
+	RegisteTickFunc(config.RetryInterval, func() {
+		if t.bpe != nil || t.load() {
+			return
+		}
+		if TryAcquireSharedLease(tiktokenLeaseKeyPrefix+config.Name, leaseTTL) {
+			t.fetch()
+		}
+	})
+	return t, nil
+}
+
+// load parses the ranks in shared data, it returns false if they're not fetched yet.
+func (t *RemoteTokenizer) load() bool {
+	data, _, err := proxywasm.GetSharedData(tiktokenKeyPrefix + t.config.Name)
+	if err != nil || len(data) == 0 {
+		if err != nil && !errors.Is(err, types.ErrorStatusNotFound) {
+			proxywasm.LogErrorf("failed to get tiktoken ranks %s: %v", t.config.Name, err)
+		}
+		return false
+	}
+	ranks, err := ParseTiktokenRanks(string(data))
+	if err != nil {
+		proxywasm.LogErrorf("failed to parse tiktoken ranks %s: %v", t.config.Name, err)
+		return false
+	}
+	t.bpe = NewBPETokenizer(ranks)
+	return true
+}
+
+func (t *RemoteTokenizer) fetch() {
+	err := t.config.Client.Get(t.config.URL, nil, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if statusCode != http.StatusOK {
+			proxywasm.LogErrorf("failed to fetch tiktoken ranks %s, status: %d", t.config.Name, statusCode)
+			return
+		}
+		ranks, err := ParseTiktokenRanks(string(responseBody))
+		if err != nil {
+			proxywasm.LogErrorf("failed to parse tiktoken ranks %s: %v", t.config.Name, err)
+			return
+		}
+		if err = proxywasm.SetSharedData(tiktokenKeyPrefix+t.config.Name, responseBody, 0); err != nil {
+			proxywasm.LogErrorf("failed to store tiktoken ranks %s: %v", t.config.Name, err)
+		}
+		t.bpe = NewBPETokenizer(ranks)
+		proxywasm.LogInfof("tiktoken ranks %s are loaded, %d tokens", t.config.Name, len(ranks))
+	}, t.config.Timeout)
+	if err != nil {
+		proxywasm.LogErrorf("failed to fetch tiktoken ranks %s: %v", t.config.Name, err)
+	}
+}
+
+// Loaded tells whether the ranks are loaded in the VM, the tokens are estimated by the fallback tokenizer otherwise.
+func (t *RemoteTokenizer) Loaded() bool {
+	return t.bpe != nil
+}
+
+func (t *RemoteTokenizer) CountTokens(text string) int {
+	if t.bpe == nil {
+		return t.config.Fallback.CountTokens(text)
+	}
+	return t.bpe.CountTokens(text)
+}
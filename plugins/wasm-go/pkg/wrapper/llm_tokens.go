@@ -0,0 +1,383 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tokenizer counts the tokens of a text as the model would.
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// HeuristicTokenizer estimates the tokens without the vocabulary, by splitting the text like the BPE pre-tokenizers
+// and assuming an average number of characters per token. The error is usually within 10% for natural language.
+type HeuristicTokenizer struct {
+	// The average number of letters per token in a word, a word is at least one token
+	CharsPerToken float64
+	// The average number of tokens per CJK character
+	TokensPerCJK float64
+}
+
+// The calibrated estimators of the common model families, the BPE vocabularies are too large to be embedded in
+// the plugin, register a RemoteTokenizer loading the ranks of the family for exact counts.
+var (
+	HeuristicTokenizerCl100k = HeuristicTokenizer{CharsPerToken: 6, TokensPerCJK: 1.0}
+	HeuristicTokenizerO200k  = HeuristicTokenizer{CharsPerToken: 6.5, TokensPerCJK: 0.8}
+	HeuristicTokenizerQwen   = HeuristicTokenizer{CharsPerToken: 6, TokensPerCJK: 0.7}
+	HeuristicTokenizerClaude = HeuristicTokenizer{CharsPerToken: 5, TokensPerCJK: 1.2}
+	HeuristicTokenizerGemini = HeuristicTokenizer{CharsPerToken: 6, TokensPerCJK: 0.9}
+)
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) ||
+		unicode.Is(unicode.Hangul, r)
+}
+
+func (t HeuristicTokenizer) CountTokens(text string) int {
+	var cjk float64
+	tokens := 0
+	for _, piece := range preTokenize(text) {
+		var letters, others int
+		for _, r := range piece {
+			switch {
+			case isCJK(r):
+				cjk++
+			case unicode.IsLetter(r) || unicode.IsNumber(r):
+				letters++
+			case !unicode.IsSpace(r):
+				others++
+			}
+		}
+		if letters > 0 {
+			if n := int(math.Round(float64(letters) / t.CharsPerToken)); n > 1 {
+				tokens += n
+			} else {
+				tokens++
+			}
+		}
+		if others > 0 {
+			tokens += (others + 1) / 2
+		}
+		if letters == 0 && others == 0 && strings.ContainsAny(piece, "\r\n") {
+			tokens++
+		}
+	}
+	return tokens + int(math.Ceil(cjk*t.TokensPerCJK))
+}
+
+// preTokenize splits the text like the pattern of the cl100k tokenizer: contractions, letters with an optional
+// leading symbol, up to 3 digits, punctuations with an optional leading space, and whitespaces, where the last space
+// before a word is attached to the word.
+func preTokenize(text string) []string {
+	var pieces []string
+	runes := []rune(text)
+	n := len(runes)
+	isLetter := func(i int) bool { return i < n && unicode.IsLetter(runes[i]) }
+	isNumber := func(i int) bool { return i < n && unicode.IsNumber(runes[i]) }
+	isSpace := func(i int) bool { return i < n && unicode.IsSpace(runes[i]) }
+	isNewline := func(i int) bool { return i < n && (runes[i] == '\r' || runes[i] == '\n') }
+	for i := 0; i < n; {
+		start := i
+		switch {
+		case runes[i] == '\'' && contractionLength(runes[i+1:]) > 0:
+			i += 1 + contractionLength(runes[i+1:])
+		case isLetter(i) || !isNumber(i) && !isNewline(i) && isLetter(i+1):
+			i++
+			for isLetter(i) {
+				i++
+			}
+		case isNumber(i):
+			for i < n && i-start < 3 && isNumber(i) {
+				i++
+			}
+		case !isSpace(i) || runes[i] == ' ' && i+1 < n && !isSpace(i+1) && !isLetter(i+1) && !isNumber(i+1):
+			if runes[i] == ' ' {
+				i++
+			}
+			for i < n && !isSpace(i) && !isLetter(i) && !isNumber(i) {
+				i++
+			}
+			for isNewline(i) {
+				i++
+			}
+		default:
+			for isSpace(i) {
+				i++
+			}
+			// keep the newlines and the spaces before them together, and leave the last space to the next word
+			if j := lastNewline(runes[start:i]); j >= 0 && start+j+1 < i {
+				i = start + j + 1
+			} else if i < n && i-start > 1 && runes[i-1] == ' ' {
+				i--
+			}
+		}
+		pieces = append(pieces, string(runes[start:i]))
+	}
+	return pieces
+}
+
+func contractionLength(runes []rune) int {
+	if len(runes) == 0 {
+		return 0
+	}
+	if len(runes) >= 2 {
+		switch strings.ToLower(string(runes[:2])) {
+		case "re", "ve", "ll":
+			return 2
+		}
+	}
+	switch unicode.ToLower(runes[0]) {
+	case 's', 't', 'm', 'd':
+		return 1
+	}
+	return 0
+}
+
+func lastNewline(runes []rune) int {
+	for i := len(runes) - 1; i >= 0; i-- {
+		if runes[i] == '\n' || runes[i] == '\r' {
+			return i
+		}
+	}
+	return -1
+}
+
+// BPETokenizer counts the tokens with the byte level BPE merge ranks, in the tiktoken format.
+type BPETokenizer struct {
+	ranks map[string]int
+}
+
+func NewBPETokenizer(ranks map[string]int) *BPETokenizer {
+	return &BPETokenizer{ranks: ranks}
+}
+
+// ParseTiktokenRanks parses the lines of "<base64 token> <rank>", as the .tiktoken files.
+func ParseTiktokenRanks(data string) (map[string]int, error) {
+	ranks := map[string]int{}
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		encoded, rankStr, found := strings.Cut(line, " ")
+		if !found {
+			return nil, fmt.Errorf("invalid rank at line %d: %s", i+1, line)
+		}
+		token, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token at line %d: %v", i+1, err)
+		}
+		rank, err := strconv.Atoi(rankStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rank at line %d: %v", i+1, err)
+		}
+		ranks[string(token)] = rank
+	}
+	return ranks, nil
+}
+
+func (t *BPETokenizer) CountTokens(text string) int {
+	tokens := 0
+	for _, piece := range preTokenize(text) {
+		tokens += len(t.encodePiece([]byte(piece)))
+	}
+	return tokens
+}
+
+// Encode returns the ranks of the tokens, the bytes not in the vocabulary are -1.
+func (t *BPETokenizer) Encode(text string) []int {
+	var ids []int
+	for _, piece := range preTokenize(text) {
+		for _, part := range t.encodePiece([]byte(piece)) {
+			if rank, ok := t.ranks[part]; ok {
+				ids = append(ids, rank)
+			} else {
+				ids = append(ids, -1)
+			}
+		}
+	}
+	return ids
+}
+
+// The merging is quadratic in the length of the piece, the longer pieces, e.g. a long run of symbols, are encoded in
+// chunks of the length, as tiktoken does.
+const bpeMaxPieceLength = 256
+
+// encodePiece merges the pair of the lowest rank repeatedly, starting from the bytes.
+func (t *BPETokenizer) encodePiece(piece []byte) []string {
+	if _, ok := t.ranks[string(piece)]; ok {
+		return []string{string(piece)}
+	}
+	if len(piece) > bpeMaxPieceLength {
+		var parts []string
+		for len(piece) > bpeMaxPieceLength {
+			parts = append(parts, t.encodePiece(piece[:bpeMaxPieceLength])...)
+			piece = piece[bpeMaxPieceLength:]
+		}
+		return append(parts, t.encodePiece(piece)...)
+	}
+	parts := make([]string, len(piece))
+	for i := range piece {
+		parts[i] = string(piece[i : i+1])
+	}
+	for len(parts) > 1 {
+		best, bestRank := -1, math.MaxInt32
+		for i := 0; i+1 < len(parts); i++ {
+			if rank, ok := t.ranks[parts[i]+parts[i+1]]; ok && rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		parts[best] += parts[best+1]
+		parts = append(parts[:best+1], parts[best+2:]...)
+	}
+	return parts
+}
+
+type tokenizerEntry struct {
+	prefix    string
+	tokenizer Tokenizer
+}
+
+var modelTokenizers = []tokenizerEntry{
+	{"gpt-4o", HeuristicTokenizerO200k},
+	{"gpt-4.1", HeuristicTokenizerO200k},
+	{"o1", HeuristicTokenizerO200k},
+	{"o3", HeuristicTokenizerO200k},
+	{"o4", HeuristicTokenizerO200k},
+	{"gpt-", HeuristicTokenizerCl100k},
+	{"text-embedding-", HeuristicTokenizerCl100k},
+	{"qwen", HeuristicTokenizerQwen},
+	{"qwq", HeuristicTokenizerQwen},
+	{"claude", HeuristicTokenizerClaude},
+	{"gemini", HeuristicTokenizerGemini},
+}
+
+// RegisterTokenizer sets the tokenizer of the models with the prefix, it takes precedence over the builtin ones.
+// It should be called when parsing the config, registering the prefix again replaces the tokenizer.
+func RegisterTokenizer(modelPrefix string, tokenizer Tokenizer) {
+	modelPrefix = strings.ToLower(modelPrefix)
+	entries := []tokenizerEntry{{modelPrefix, tokenizer}}
+	for _, entry := range modelTokenizers {
+		if entry.prefix != modelPrefix {
+			entries = append(entries, entry)
+		}
+	}
+	modelTokenizers = entries
+}
+
+// TokenizerForModel returns the tokenizer of the model family, the cl100k estimator is used for unknown models.
+func TokenizerForModel(model string) Tokenizer {
+	model = strings.ToLower(model)
+	for _, entry := range modelTokenizers {
+		if strings.HasPrefix(model, entry.prefix) {
+			return entry.tokenizer
+		}
+	}
+	return HeuristicTokenizerCl100k
+}
+
+func CountTokens(model, text string) int {
+	return TokenizerForModel(model).CountTokens(text)
+}
+
+const (
+	// Each message is wrapped by <|start|>{role/name}\n{content}<|end|>\n
+	tokensPerMessage = 3
+	tokensPerName    = 1
+	// Every reply is primed with <|start|>assistant<|message|>
+	tokensPerReply = 3
+	// An image is counted as a low detail image of OpenAI, the actual count depends on the size and the model
+	tokensPerImage = 85
+)
+
+// CountMessagesTokens estimates the prompt tokens of the messages, following the accounting of OpenAI.
+func CountMessagesTokens(model string, messages []ChatMessage) int {
+	tokenizer := TokenizerForModel(model)
+	tokens := tokensPerReply
+	for _, message := range messages {
+		tokens += tokensPerMessage + tokenizer.CountTokens(message.Role)
+		if message.Name != "" {
+			tokens += tokensPerName + tokenizer.CountTokens(message.Name)
+		}
+		for _, part := range message.ContentParts() {
+			if part.Type == ContentTypeImageUrl {
+				tokens += tokensPerImage
+			} else {
+				tokens += tokenizer.CountTokens(part.Text)
+			}
+		}
+		for _, call := range message.ToolCalls {
+			tokens += tokenizer.CountTokens(call.Function.Name) + tokenizer.CountTokens(call.Function.Arguments)
+		}
+	}
+	return tokens
+}
+
+// CountRequestTokens estimates the prompt tokens of the request, including the tool definitions.
+func CountRequestTokens(request *ChatCompletionRequest) int {
+	tokens := CountMessagesTokens(request.Model, request.Messages)
+	if len(request.Tools) > 0 {
+		definitions, _ := json.Marshal(request.Tools)
+		tokens += CountTokens(request.Model, string(definitions))
+	}
+	return tokens
+}
+
+// TokenEstimate is the estimation before sending the request, it's reconciled with the usage of the response.
+type TokenEstimate struct {
+	Model        string
+	PromptTokens int
+	// The max_tokens of the request, the upper bound of the completion tokens
+	MaxCompletionTokens int
+}
+
+func EstimateRequestTokens(request *ChatCompletionRequest) TokenEstimate {
+	return TokenEstimate{Model: request.Model, PromptTokens: CountRequestTokens(request), MaxCompletionTokens: request.MaxTokens}
+}
+
+// Reconcile returns the tokens to add to the reserved amount, which is the estimated prompt tokens plus the max
+// completion tokens, to make it the actual total. It's negative if too many tokens were reserved. If the response
+// has no usage, the total is the estimated prompt tokens plus the tokens counted in the completion text.
+func (e TokenEstimate) Reconcile(usage *Usage, completion string) int {
+	reserved := e.PromptTokens + e.MaxCompletionTokens
+	if usage == nil {
+		return e.PromptTokens + CountCompletionTokens(e.Model, completion) - reserved
+	}
+	total := usage.TotalTokens
+	if total == 0 {
+		total = usage.PromptTokens + usage.CompletionTokens
+	}
+	return total - reserved
+}
+
+// CountCompletionTokens estimates the tokens of the completion text, when the response has no usage.
+func CountCompletionTokens(model, completion string) int {
+	if !utf8.ValidString(completion) {
+		completion = strings.ToValidUTF8(completion, "")
+	}
+	return CountTokens(model, completion)
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreTokenize(t *testing.T) {
+	assert.Equal(t, []string{"Hello", ",", " world", "!"}, preTokenize("Hello, world!"))
+	assert.Equal(t, []string{"I", "'m", " fine", " ", " ok"}, preTokenize("I'm fine  ok"))
+	assert.Equal(t, []string{"123", "456", "7", " (", "x", ")\n\n", "y"}, preTokenize("1234567 (x)\n\ny"))
+	assert.Equal(t, []string{"a", "  \n", "b"}, preTokenize("a  \nb"))
+	assert.Equal(t, "你好，世界", strings.Join(preTokenize("你好，世界"), ""))
+}
+
+func TestHeuristicTokenizer(t *testing.T) {
+	// 10 tokens with cl100k
+	assert.InDelta(t, 10, HeuristicTokenizerCl100k.CountTokens("The quick brown fox jumps over the lazy dog."), 1)
+	assert.InDelta(t, 4, HeuristicTokenizerCl100k.CountTokens("internationalization"), 1)
+	assert.InDelta(t, 16, HeuristicTokenizerCl100k.CountTokens("人工智能正在改变世界的每一个角落"), 4)
+	assert.Less(t, CountTokens("qwen-max", "人工智能正在改变世界的每一个角落"), CountTokens("gpt-4", "人工智能正在改变世界的每一个角落"))
+	assert.Equal(t, 0, HeuristicTokenizerCl100k.CountTokens(""))
+}
+
+func TestBPETokenizer(t *testing.T) {
+	var lines []string
+	for i, token := range []string{"l", "o", "w", "e", "r", " ", "lo", "low", " low", "er", " lower"} {
+		lines = append(lines, fmt.Sprintf("%s %d", base64.StdEncoding.EncodeToString([]byte(token)), i))
+	}
+	ranks, err := ParseTiktokenRanks(strings.Join(lines, "\n"))
+	require.NoError(t, err)
+	tokenizer := NewBPETokenizer(ranks)
+	assert.Equal(t, []int{2, 10}, tokenizer.Encode("w lower"))
+	assert.Equal(t, []int{8, 3, 3}, tokenizer.Encode(" lowee"))
+	assert.Equal(t, 2, tokenizer.CountTokens("low lower"))
+
+	_, err = ParseTiktokenRanks("bG8= x")
+	assert.Error(t, err)
+
+	// the long pieces are encoded in chunks
+	long := strings.Repeat("w", bpeMaxPieceLength*3+1)
+	assert.Equal(t, len(long), tokenizer.CountTokens(long))
+
+	defer func(entries []tokenizerEntry) { modelTokenizers = entries }(modelTokenizers)
+	builtins := len(modelTokenizers)
+	RegisterTokenizer("test-bpe", HeuristicTokenizerQwen)
+	RegisterTokenizer("Test-BPE", tokenizer)
+	assert.Len(t, modelTokenizers, builtins+1)
+	assert.Equal(t, tokenizer, TokenizerForModel("test-bpe-v1"))
+	assert.Equal(t, HeuristicTokenizerO200k, TokenizerForModel("GPT-4o-mini"))
+	assert.Equal(t, HeuristicTokenizerCl100k, TokenizerForModel("unknown"))
+}
+
+func TestCountRequestTokens(t *testing.T) {
+	request := &ChatCompletionRequest{
+		Model: "gpt-4",
+		Messages: []ChatMessage{
+			{Role: RoleSystem, Content: "You are a helpful assistant."},
+			{Role: RoleUser, Content: []interface{}{
+				map[string]interface{}{"type": "text", "text": "Describe the image."},
+				map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "https://example.com/a.png"}},
+			}},
+		},
+		MaxTokens: 100,
+	}
+	estimate := EstimateRequestTokens(request)
+	// the priming, the roles, the texts and the image
+	assert.InDelta(t, 3+2*(3+1)+6+4+85, estimate.PromptTokens, 2)
+
+	withTools := *request
+	withTools.Tools = []Tool{{Type: "function", Function: FunctionDefinition{Name: "get_weather"}}}
+	assert.Greater(t, CountRequestTokens(&withTools), estimate.PromptTokens)
+
+	assert.Equal(t, 120-estimate.PromptTokens-100, estimate.Reconcile(&Usage{PromptTokens: 100, CompletionTokens: 20}, ""))
+	// the completion is counted if the response has no usage
+	completion := "The weather is sunny today."
+	assert.Equal(t, CountCompletionTokens(request.Model, completion)-100, estimate.Reconcile(nil, completion))
+	assert.Equal(t, -100, estimate.Reconcile(nil, ""))
+}
@@ -0,0 +1,257 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// The request declaring a tool not in the allowlist is rejected
+	ToolUnlistedReject = "reject"
+	// The tools not in the allowlist are removed from the request
+	ToolUnlistedRemove = "remove"
+)
+
+// ToolRule allows the tools matching the name.
+type ToolRule struct {
+	// The tool name, a pattern may end with * to match by prefix
+	Name string
+	// The schema of the arguments, the calls are validated against the declared parameters if it's nil
+	Parameters *JSONSchema
+	// The raw schema, it replaces the declared parameters if the parameters are pinned
+	parameters map[string]interface{}
+}
+
+// ToolPolicy enforces the allowlist of the tools declared in the requests, and validates the tool calls of the
+// responses, so the agentic traffic can only use the vetted tools in the vetted way.
+type ToolPolicy struct {
+	Rules []ToolRule
+	// The action on the unlisted tools, default is reject
+	Unlisted string
+	// The max tools declared in a request, 0 is unlimited
+	MaxTools int
+	// Replace the declared parameters with the configured ones, so the model sees the vetted schema
+	PinParameters bool
+}
+
+// ParseToolPolicy parses the config like:
+//
+//	{"unlisted": "remove", "maxTools": 16, "pinParameters": true, "tools": [
+//	   {"name": "get_weather", "parameters": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}},
+//	   {"name": "search_*"}]}
+func ParseToolPolicy(json gjson.Result) (*ToolPolicy, error) {
+	policy := &ToolPolicy{
+		Unlisted:      json.Get("unlisted").String(),
+		MaxTools:      int(json.Get("maxTools").Int()),
+		PinParameters: json.Get("pinParameters").Bool(),
+	}
+	switch policy.Unlisted {
+	case "":
+		policy.Unlisted = ToolUnlistedReject
+	case ToolUnlistedReject, ToolUnlistedRemove:
+	default:
+		return nil, fmt.Errorf("invalid unlisted tool action: %s", policy.Unlisted)
+	}
+	for i, item := range json.Get("tools").Array() {
+		rule := ToolRule{Name: item.Get("name").String()}
+		if rule.Name == "" {
+			return nil, fmt.Errorf("tool %d has no name", i)
+		}
+		if parameters := item.Get("parameters"); parameters.Exists() {
+			schema, err := ParseJSONSchema(parameters)
+			if err != nil {
+				return nil, fmt.Errorf("invalid parameters of tool %s: %v", rule.Name, err)
+			}
+			rule.Parameters = schema
+			rule.parameters, _ = parameters.Value().(map[string]interface{})
+		}
+		policy.Rules = append(policy.Rules, rule)
+	}
+	return policy, nil
+}
+
+// Rule returns the first rule allowing the tool.
+func (p *ToolPolicy) Rule(name string) (*ToolRule, bool) {
+	for i := range p.Rules {
+		if matchModelPattern(p.Rules[i].Name, name) {
+			return &p.Rules[i], true
+		}
+	}
+	return nil, false
+}
+
+// ApplyRequest enforces the policy on the tools declared in the request, the unlisted tools are removed or rejected.
+// It returns the names of the removed tools.
+func (p *ToolPolicy) ApplyRequest(request *ChatCompletionRequest) ([]string, error) {
+	var removed []string
+	tools := request.Tools[:0:0]
+	for _, tool := range request.Tools {
+		rule, ok := p.Rule(tool.Function.Name)
+		if !ok {
+			if p.Unlisted == ToolUnlistedRemove {
+				removed = append(removed, tool.Function.Name)
+				continue
+			}
+			return nil, fmt.Errorf("tool is not allowed: %s", tool.Function.Name)
+		}
+		if p.PinParameters && rule.parameters != nil {
+			tool.Function.Parameters = rule.parameters
+		}
+		tools = append(tools, tool)
+	}
+	if p.MaxTools > 0 && len(tools) > p.MaxTools {
+		return nil, fmt.Errorf("too many tools: %d > %d", len(tools), p.MaxTools)
+	}
+	request.Tools = tools
+	if len(tools) == 0 {
+		request.Tools = nil
+		request.ToolChoice = nil
+		return removed, nil
+	}
+	// the forced tool must be one of the remaining tools
+	if choice, ok := request.ToolChoice.(map[string]interface{}); ok {
+		function, _ := choice["function"].(map[string]interface{})
+		name, _ := function["name"].(string)
+		if !declaresTool(tools, name) {
+			return nil, fmt.Errorf("tool choice is not allowed: %s", name)
+		}
+	}
+	return removed, nil
+}
+
+func declaresTool(tools []Tool, name string) bool {
+	for _, tool := range tools {
+		if tool.Function.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ToolCallViolation is a tool call breaking the policy.
+type ToolCallViolation struct {
+	ID     string
+	Name   string
+	Errors []string
+}
+
+func (v ToolCallViolation) Error() string {
+	return fmt.Sprintf("invalid call of tool %s: %s", v.Name, strings.Join(v.Errors, "; "))
+}
+
+// CheckToolCalls validates the calls against the tools declared in the request. A call of an undeclared or
+// unlisted tool is a violation, and the arguments are validated against the configured parameters, or the
+// declared ones if the rule has none.
+func (p *ToolPolicy) CheckToolCalls(request *ChatCompletionRequest, calls []ToolCall) []ToolCallViolation {
+	var violations []ToolCallViolation
+	for _, call := range calls {
+		if errs := p.checkToolCall(request, call); len(errs) > 0 {
+			violations = append(violations, ToolCallViolation{ID: call.ID, Name: call.Function.Name, Errors: errs})
+		}
+	}
+	return violations
+}
+
+func (p *ToolPolicy) checkToolCall(request *ChatCompletionRequest, call ToolCall) []string {
+	name := call.Function.Name
+	if !declaresTool(request.Tools, name) {
+		return []string{"tool is not declared"}
+	}
+	rule, ok := p.Rule(name)
+	if !ok {
+		return []string{"tool is not allowed"}
+	}
+	arguments := call.Function.Arguments
+	if strings.TrimSpace(arguments) == "" {
+		arguments = "{}"
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(arguments), &value); err != nil {
+		return []string{fmt.Sprintf("arguments are not valid json: %v", err)}
+	}
+	schema := rule.Parameters
+	if schema == nil {
+		schema = declaredParameters(request.Tools, name)
+	}
+	if schema == nil {
+		return nil
+	}
+	return schema.Validate(value)
+}
+
+// declaredParameters parses the parameters declared for the tool, it's nil if they are absent or not supported.
+func declaredParameters(tools []Tool, name string) *JSONSchema {
+	for _, tool := range tools {
+		if tool.Function.Name != name || tool.Function.Parameters == nil {
+			continue
+		}
+		data, err := json.Marshal(tool.Function.Parameters)
+		if err != nil {
+			return nil
+		}
+		schema, err := ParseJSONSchema(gjson.ParseBytes(data))
+		if err != nil {
+			return nil
+		}
+		return schema
+	}
+	return nil
+}
+
+// CheckResponse validates the tool calls of all the choices of the response.
+func (p *ToolPolicy) CheckResponse(request *ChatCompletionRequest, response *ChatCompletionResponse) []ToolCallViolation {
+	var violations []ToolCallViolation
+	for _, choice := range response.Choices {
+		if choice.Message != nil {
+			violations = append(violations, p.CheckToolCalls(request, choice.Message.ToolCalls)...)
+		}
+	}
+	return violations
+}
+
+// ToolCallAccumulator joins the tool call deltas of the first choice of a stream, so the calls can be validated
+// once the choice finishes.
+type ToolCallAccumulator struct {
+	calls []ToolCall
+}
+
+func (a *ToolCallAccumulator) Add(chunk *ChatCompletionResponse) {
+	if len(chunk.Choices) == 0 || chunk.Choices[0].Delta == nil {
+		return
+	}
+	for _, delta := range chunk.Choices[0].Delta.ToolCalls {
+		a.addCall(delta)
+	}
+}
+
+func (a *ToolCallAccumulator) addCall(delta ToolCall) {
+	for i := range a.calls {
+		if a.calls[i].Index == delta.Index {
+			a.calls[i].Function.Arguments += delta.Function.Arguments
+			return
+		}
+	}
+	a.calls = append(a.calls, delta)
+}
+
+// Calls returns the calls accumulated so far.
+func (a *ToolCallAccumulator) Calls() []ToolCall {
+	return a.calls
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+const testToolPolicy = `{"unlisted": "remove", "pinParameters": true, "tools": [
+	{"name": "get_weather", "parameters": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"], "additionalProperties": false}},
+	{"name": "search_*"}]}`
+
+func toolPolicyRequest() *ChatCompletionRequest {
+	return &ChatCompletionRequest{
+		Tools: []Tool{
+			{Type: "function", Function: FunctionDefinition{Name: "get_weather", Parameters: map[string]interface{}{"type": "object"}}},
+			{Type: "function", Function: FunctionDefinition{Name: "search_web", Parameters: map[string]interface{}{
+				"type": "object", "properties": map[string]interface{}{"q": map[string]interface{}{"type": "string"}}}}},
+			{Type: "function", Function: FunctionDefinition{Name: "run_shell"}},
+		},
+	}
+}
+
+func TestToolPolicyApplyRequest(t *testing.T) {
+	policy, err := ParseToolPolicy(gjson.Parse(testToolPolicy))
+	require.NoError(t, err)
+	request := toolPolicyRequest()
+	removed, err := policy.ApplyRequest(request)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"run_shell"}, removed)
+	require.Len(t, request.Tools, 2)
+	assert.Equal(t, []interface{}{"city"}, request.Tools[0].Function.Parameters["required"])
+
+	request = toolPolicyRequest()
+	request.ToolChoice = map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": "run_shell"}}
+	_, err = policy.ApplyRequest(request)
+	assert.EqualError(t, err, "tool choice is not allowed: run_shell")
+
+	policy.Unlisted = ToolUnlistedReject
+	_, err = policy.ApplyRequest(toolPolicyRequest())
+	assert.EqualError(t, err, "tool is not allowed: run_shell")
+
+	policy.Unlisted = ToolUnlistedRemove
+	policy.MaxTools = 1
+	_, err = policy.ApplyRequest(toolPolicyRequest())
+	assert.EqualError(t, err, "too many tools: 2 > 1")
+
+	_, err = ParseToolPolicy(gjson.Parse(`{"unlisted": "ignore"}`))
+	assert.Error(t, err)
+	_, err = ParseToolPolicy(gjson.Parse(`{"tools": [{"name": "a", "parameters": {"$ref": "#/a"}}]}`))
+	assert.Error(t, err)
+}
+
+func TestToolPolicyCheckToolCalls(t *testing.T) {
+	policy, err := ParseToolPolicy(gjson.Parse(testToolPolicy))
+	require.NoError(t, err)
+	request := toolPolicyRequest()
+	call := func(id, name, arguments string) ToolCall {
+		return ToolCall{ID: id, Type: "function", Function: FunctionCall{Name: name, Arguments: arguments}}
+	}
+	violations := policy.CheckToolCalls(request, []ToolCall{
+		call("1", "get_weather", `{"city": "Hangzhou"}`),
+		call("2", "get_weather", `{"town": "Hangzhou"}`),
+		call("3", "search_web", `{"q": 1}`),
+		call("4", "search_web", `{"q": `),
+		call("5", "run_shell", `{}`),
+		call("6", "read_file", `{}`),
+	})
+	require.Len(t, violations, 5)
+	assert.Equal(t, "2", violations[0].ID)
+	assert.Equal(t, []string{"$: missing required property city", "$: additional property town is not allowed"}, violations[0].Errors)
+	// validated against the declared parameters
+	assert.Equal(t, "invalid call of tool search_web: $.q: expected [string], got integer", violations[1].Error())
+	assert.Contains(t, violations[2].Errors[0], "arguments are not valid json")
+	assert.Equal(t, []string{"tool is not allowed"}, violations[3].Errors)
+	assert.Equal(t, []string{"tool is not declared"}, violations[4].Errors)
+
+	var accumulator ToolCallAccumulator
+	for _, delta := range []ToolCall{call("7", "get_weather", `{"ci`), call("", "", `ty": "Ha`), call("", "", `ngzhou"}`)} {
+		accumulator.Add(&ChatCompletionResponse{Choices: []ChatCompletionChoice{{Delta: &ChatMessage{ToolCalls: []ToolCall{delta}}}}})
+	}
+	assert.Equal(t, "7", accumulator.Calls()[0].ID)
+	assert.Equal(t, `{"city": "Hangzhou"}`, accumulator.Calls()[0].Function.Arguments)
+	assert.Empty(t, policy.CheckToolCalls(request, accumulator.Calls()))
+}
@@ -0,0 +1,411 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	TraceExportLangfuse = "langfuse"
+	TraceExportOTLP     = "otlp"
+)
+
+// GenerationTrace is a model call observed by the gateway.
+type GenerationTrace struct {
+	// The ids are generated if they are empty, the trace id is 32 hex digits and the id is 16 hex digits
+	TraceID  string
+	ID       string
+	Name     string
+	Model    string
+	Provider string
+	Consumer string
+	Start    time.Time
+	End      time.Time
+	// The time of the first streaming chunk, zero if the response is not streamed
+	FirstToken time.Time
+	Prompt     []ChatMessage
+	Completion string
+	Usage      Usage
+	// The scores of the generation, e.g. the moderation or the validation results
+	Scores   map[string]float64
+	Metadata map[string]string
+	// The error message if the call failed
+	Error string
+}
+
+type RedactionMode string
+
+const (
+	RedactionNone RedactionMode = "none"
+	// Replace the text with its sha256, so the equal prompts can still be correlated
+	RedactionHash RedactionMode = "hash"
+	RedactionDrop RedactionMode = "drop"
+)
+
+// TextRedactor removes the sensitive content from the prompts and completions before they leave the gateway.
+type TextRedactor struct {
+	Mode RedactionMode
+	// The matches are replaced with [REDACTED] in the none mode
+	Patterns []*regexp.Regexp
+	// The text is truncated to the runes in the none mode, 0 means no limit
+	MaxLength int
+}
+
+func ParseTextRedactor(json gjson.Result) (*TextRedactor, error) {
+	r := &TextRedactor{Mode: RedactionMode(json.Get("mode").String()), MaxLength: int(json.Get("maxLength").Int())}
+	switch r.Mode {
+	case "":
+		r.Mode = RedactionNone
+	case RedactionNone, RedactionHash, RedactionDrop:
+	default:
+		return nil, fmt.Errorf("invalid redaction mode: %s", r.Mode)
+	}
+	for _, pattern := range json.Get("patterns").Array() {
+		re, err := regexp.Compile(pattern.String())
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %s: %v", pattern.String(), err)
+		}
+		r.Patterns = append(r.Patterns, re)
+	}
+	return r, nil
+}
+
+func (r *TextRedactor) Redact(text string) string {
+	if r == nil || text == "" {
+		return text
+	}
+	switch r.Mode {
+	case RedactionDrop:
+		return ""
+	case RedactionHash:
+		sum := sha256.Sum256([]byte(text))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	for _, re := range r.Patterns {
+		text = re.ReplaceAllString(text, "[REDACTED]")
+	}
+	if r.MaxLength > 0 {
+		if truncated := truncateRunes(text, r.MaxLength); truncated != text {
+			text = truncated + "..."
+		}
+	}
+	return text
+}
+
+type GenerationExporterConfig struct {
+	Type     string
+	Exporter BatchExporterConfig
+	// The redaction of the prompts and the completions, they are exported as they are if nil
+	Redactor *TextRedactor
+	// The service name of the otlp resource, default is higress
+	ServiceName string
+}
+
+// GenerationExporter sends the generation traces to Langfuse or an OTLP/HTTP collector in batches.
+type GenerationExporter struct {
+	config   GenerationExporterConfig
+	exporter *BatchExporter
+}
+
+func NewGenerationExporter(config GenerationExporterConfig) (*GenerationExporter, error) {
+	if config.ServiceName == "" {
+		config.ServiceName = "higress"
+	}
+	switch config.Type {
+	case TraceExportLangfuse:
+		if config.Exporter.Path == "" {
+			config.Exporter.Path = "/api/public/ingestion"
+		}
+		config.Exporter.Encode = encodeLangfuseBatch
+	case TraceExportOTLP:
+		if config.Exporter.Path == "" {
+			config.Exporter.Path = "/v1/traces"
+		}
+		serviceName := config.ServiceName
+		config.Exporter.Encode = func(spans []json.RawMessage) ([]byte, error) {
+			return encodeOTLPSpans(serviceName, spans)
+		}
+	default:
+		return nil, fmt.Errorf("unknown trace export type: %s", config.Type)
+	}
+	config.Exporter.Headers = append(config.Exporter.Headers, [2]string{"content-type", "application/json"})
+	exporter, err := NewBatchExporter(config.Exporter)
+	if err != nil {
+		return nil, err
+	}
+	return &GenerationExporter{config: config, exporter: exporter}, nil
+}
+
+// ParseGenerationExporter parses the config like:
+//
+//	{"type": "langfuse", "serviceName": "langfuse.dns", "publicKey": "pk-lf-xxx", "secretKey": "sk-lf-xxx",
+//	 "batchSize": 50, "flushInterval": 5000, "redaction": {"patterns": ["\\d{11}"], "maxLength": 4000}}
+//
+// The otlp type takes the headers like {"headers": {"x-api-key": "xxx"}} instead of the keys.
+func ParseGenerationExporter(json gjson.Result) (*GenerationExporter, error) {
+	serviceName := json.Get("serviceName").String()
+	if serviceName == "" {
+		return nil, errors.New("trace export serviceName is empty")
+	}
+	servicePort := json.Get("servicePort").Int()
+	if servicePort == 0 {
+		servicePort = 443
+	}
+	config := GenerationExporterConfig{
+		Type: json.Get("type").String(),
+		Exporter: BatchExporterConfig{
+			Client:        NewClusterClient(FQDNCluster{FQDN: serviceName, Host: json.Get("serviceHost").String(), Port: servicePort}),
+			Path:          json.Get("path").String(),
+			BatchSize:     int(json.Get("batchSize").Int()),
+			FlushInterval: json.Get("flushInterval").Int(),
+			Timeout:       uint32(json.Get("timeout").Uint()),
+		},
+		ServiceName: json.Get("resourceServiceName").String(),
+	}
+	if publicKey := json.Get("publicKey").String(); publicKey != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(publicKey + ":" + json.Get("secretKey").String()))
+		config.Exporter.Headers = append(config.Exporter.Headers, [2]string{"authorization", "Basic " + credentials})
+	}
+	json.Get("headers").ForEach(func(key, value gjson.Result) bool {
+		config.Exporter.Headers = append(config.Exporter.Headers, [2]string{key.String(), value.String()})
+		return true
+	})
+	if redaction := json.Get("redaction"); redaction.Exists() {
+		redactor, err := ParseTextRedactor(redaction)
+		if err != nil {
+			return nil, err
+		}
+		config.Redactor = redactor
+	}
+	return NewGenerationExporter(config)
+}
+
+// Export redacts the trace and adds it to the batch.
+func (e *GenerationExporter) Export(trace *GenerationTrace) error {
+	if err := fillTraceIDs(trace); err != nil {
+		return err
+	}
+	var records []interface{}
+	if e.config.Type == TraceExportLangfuse {
+		records = langfuseEvents(trace, e.config.Redactor)
+	} else {
+		records = []interface{}{otlpSpan(trace, e.config.Redactor)}
+	}
+	for _, record := range records {
+		if err := e.exporter.Add(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fillTraceIDs(trace *GenerationTrace) error {
+	var err error
+	if trace.TraceID == "" {
+		if trace.TraceID, err = RandomHex(16); err != nil {
+			return err
+		}
+	}
+	if trace.ID == "" {
+		trace.ID, err = RandomHex(8)
+	}
+	return err
+}
+
+func redactMessages(messages []ChatMessage, redactor *TextRedactor) []map[string]string {
+	redacted := make([]map[string]string, 0, len(messages))
+	for _, message := range messages {
+		redacted = append(redacted, map[string]string{"role": message.Role, "content": redactor.Redact(message.StringContent())})
+	}
+	return redacted
+}
+
+func langfuseTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// langfuseEvents returns the trace, generation and score events of the ingestion api.
+func langfuseEvents(trace *GenerationTrace, redactor *TextRedactor) []interface{} {
+	event := func(eventType, id string, body map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"id": id, "type": eventType, "timestamp": langfuseTime(trace.End), "body": body}
+	}
+	input := redactMessages(trace.Prompt, redactor)
+	output := redactor.Redact(trace.Completion)
+	events := []interface{}{
+		event("trace-create", trace.TraceID, map[string]interface{}{
+			"id":        trace.TraceID,
+			"name":      trace.Name,
+			"userId":    trace.Consumer,
+			"timestamp": langfuseTime(trace.Start),
+			"input":     input,
+			"output":    output,
+		}),
+	}
+	generation := map[string]interface{}{
+		"id":        trace.ID,
+		"traceId":   trace.TraceID,
+		"name":      trace.Name,
+		"model":     trace.Model,
+		"startTime": langfuseTime(trace.Start),
+		"endTime":   langfuseTime(trace.End),
+		"input":     input,
+		"output":    output,
+		"usage": map[string]interface{}{
+			"input":  trace.Usage.PromptTokens,
+			"output": trace.Usage.CompletionTokens,
+			"total":  trace.Usage.TotalTokens,
+			"unit":   "TOKENS",
+		},
+		"metadata": traceMetadata(trace),
+	}
+	if !trace.FirstToken.IsZero() {
+		generation["completionStartTime"] = langfuseTime(trace.FirstToken)
+	}
+	if trace.Error != "" {
+		generation["level"] = "ERROR"
+		generation["statusMessage"] = trace.Error
+	}
+	events = append(events, event("generation-create", trace.ID, generation))
+	for _, name := range sortedScoreNames(trace.Scores) {
+		id := trace.ID + "-" + name
+		events = append(events, event("score-create", id, map[string]interface{}{
+			"id":            id,
+			"traceId":       trace.TraceID,
+			"observationId": trace.ID,
+			"name":          name,
+			"value":         trace.Scores[name],
+		}))
+	}
+	return events
+}
+
+func traceMetadata(trace *GenerationTrace) map[string]string {
+	metadata := map[string]string{}
+	for k, v := range trace.Metadata {
+		metadata[k] = v
+	}
+	if trace.Provider != "" {
+		metadata["provider"] = trace.Provider
+	}
+	return metadata
+}
+
+func sortedScoreNames(scores map[string]float64) []string {
+	names := make([]string, 0, len(scores))
+	for name := range scores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func encodeLangfuseBatch(events []json.RawMessage) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{"batch": events})
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func otlpString(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]interface{}{"stringValue": value}}
+}
+
+func otlpInt(key string, value int) otlpAttribute {
+	// the int64 values are strings in the json encoding of otlp
+	return otlpAttribute{Key: key, Value: map[string]interface{}{"intValue": strconv.Itoa(value)}}
+}
+
+func otlpDouble(key string, value float64) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]interface{}{"doubleValue": value}}
+}
+
+// otlpSpan returns the span of the generation with the gen_ai attributes used by OpenLLMetry.
+func otlpSpan(trace *GenerationTrace, redactor *TextRedactor) map[string]interface{} {
+	attributes := []otlpAttribute{
+		otlpString("gen_ai.system", trace.Provider),
+		otlpString("gen_ai.request.model", trace.Model),
+		otlpInt("gen_ai.usage.input_tokens", trace.Usage.PromptTokens),
+		otlpInt("gen_ai.usage.output_tokens", trace.Usage.CompletionTokens),
+	}
+	if trace.Consumer != "" {
+		attributes = append(attributes, otlpString("user.id", trace.Consumer))
+	}
+	for i, message := range redactMessages(trace.Prompt, redactor) {
+		prefix := "gen_ai.prompt." + strconv.Itoa(i)
+		attributes = append(attributes, otlpString(prefix+".role", message["role"]), otlpString(prefix+".content", message["content"]))
+	}
+	attributes = append(attributes,
+		otlpString("gen_ai.completion.0.role", RoleAssistant),
+		otlpString("gen_ai.completion.0.content", redactor.Redact(trace.Completion)))
+	if !trace.FirstToken.IsZero() {
+		attributes = append(attributes, otlpInt("gen_ai.response.first_token_ms", int(trace.FirstToken.Sub(trace.Start).Milliseconds())))
+	}
+	for _, name := range sortedScoreNames(trace.Scores) {
+		attributes = append(attributes, otlpDouble("gen_ai.score."+name, trace.Scores[name]))
+	}
+	metadata := traceMetadata(trace)
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		attributes = append(attributes, otlpString("higress."+key, metadata[key]))
+	}
+	name := trace.Name
+	if name == "" {
+		name = "chat " + trace.Model
+	}
+	status := map[string]interface{}{"code": 1}
+	if trace.Error != "" {
+		status = map[string]interface{}{"code": 2, "message": trace.Error}
+	}
+	return map[string]interface{}{
+		"traceId":           trace.TraceID,
+		"spanId":            trace.ID,
+		"name":              name,
+		"kind":              3,
+		"startTimeUnixNano": strconv.FormatInt(trace.Start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(trace.End.UnixNano(), 10),
+		"attributes":        attributes,
+		"status":            status,
+	}
+}
+
+func encodeOTLPSpans(serviceName string, spans []json.RawMessage) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": []otlpAttribute{otlpString("service.name", serviceName)}},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "higress-ai"},
+				"spans": spans,
+			}},
+		}},
+	})
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func testGenerationTrace() *GenerationTrace {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	return &GenerationTrace{
+		TraceID:    "0af7651916cd43dd8448eb211c80319c",
+		ID:         "b7ad6b7169203331",
+		Name:       "chat",
+		Model:      "qwen-max",
+		Provider:   "qwen",
+		Consumer:   "alice",
+		Start:      start,
+		FirstToken: start.Add(200 * time.Millisecond),
+		End:        start.Add(time.Second),
+		Prompt:     []ChatMessage{{Role: RoleUser, Content: "my phone is 13800000000"}},
+		Completion: "noted",
+		Usage:      Usage{PromptTokens: 8, CompletionTokens: 2, TotalTokens: 10},
+		Scores:     map[string]float64{"moderation": 0},
+	}
+}
+
+func TestTextRedactor(t *testing.T) {
+	redactor, err := ParseTextRedactor(gjson.Parse(`{"patterns": ["1\\d{10}"], "maxLength": 15}`))
+	require.NoError(t, err)
+	assert.Equal(t, "my phone is [RE...", redactor.Redact("my phone is 13800000000"))
+	assert.Equal(t, "call [REDACTED]", redactor.Redact("call 13800000000"))
+
+	redactor, _ = ParseTextRedactor(gjson.Parse(`{"mode": "hash"}`))
+	assert.Equal(t, "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", redactor.Redact("hello"))
+	redactor, _ = ParseTextRedactor(gjson.Parse(`{"mode": "drop"}`))
+	assert.Equal(t, "", redactor.Redact("hello"))
+	var none *TextRedactor
+	assert.Equal(t, "hello", none.Redact("hello"))
+
+	_, err = ParseTextRedactor(gjson.Parse(`{"mode": "mask"}`))
+	assert.Error(t, err)
+}
+
+func TestLangfuseExport(t *testing.T) {
+	client := &fakeHttpClient{status: 207}
+	redactor, _ := ParseTextRedactor(gjson.Parse(`{"patterns": ["1\\d{10}"]}`))
+	exporter, err := NewGenerationExporter(GenerationExporterConfig{Type: TraceExportLangfuse, Exporter: BatchExporterConfig{Client: client}, Redactor: redactor})
+	require.NoError(t, err)
+	require.NoError(t, exporter.Export(testGenerationTrace()))
+	exporter.exporter.Flush()
+	require.Len(t, client.bodies, 1)
+
+	batch := gjson.Parse(client.bodies[0]).Get("batch")
+	assert.Equal(t, `["trace-create","generation-create","score-create"]`, batch.Get("#.type").Raw)
+	generation := batch.Get("1.body")
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", generation.Get("traceId").String())
+	assert.Equal(t, "2024-05-01T08:00:00.200Z", generation.Get("completionStartTime").String())
+	assert.Equal(t, "my phone is [REDACTED]", generation.Get("input.0.content").String())
+	assert.Equal(t, int64(10), generation.Get("usage.total").Int())
+	assert.Equal(t, "qwen", generation.Get("metadata.provider").String())
+	assert.Equal(t, "moderation", batch.Get("2.body.name").String())
+}
+
+func TestOTLPExport(t *testing.T) {
+	client := &fakeHttpClient{status: 200}
+	exporter, err := NewGenerationExporter(GenerationExporterConfig{Type: TraceExportOTLP, Exporter: BatchExporterConfig{Client: client}})
+	require.NoError(t, err)
+	trace := testGenerationTrace()
+	trace.TraceID, trace.ID, trace.Error = "", "", "upstream timeout"
+	require.NoError(t, exporter.Export(trace))
+	assert.Len(t, trace.TraceID, 32)
+	assert.Len(t, trace.ID, 16)
+	exporter.exporter.Flush()
+	require.Len(t, client.bodies, 1)
+
+	body := gjson.Parse(client.bodies[0])
+	assert.Equal(t, "higress", body.Get("resourceSpans.0.resource.attributes.0.value.stringValue").String())
+	span := body.Get("resourceSpans.0.scopeSpans.0.spans.0")
+	assert.Equal(t, trace.TraceID, span.Get("traceId").String())
+	assert.Equal(t, "1714550400000000000", span.Get("startTimeUnixNano").String())
+	assert.Equal(t, int64(2), span.Get("status.code").Int())
+	attribute := func(key string) gjson.Result {
+		return span.Get(`attributes.#(key=="` + key + `").value`)
+	}
+	assert.Equal(t, "8", attribute("gen_ai.usage.input_tokens").Get("intValue").String())
+	assert.Equal(t, "my phone is 13800000000", attribute("gen_ai.prompt.0.content").Get("stringValue").String())
+	assert.Equal(t, "200", attribute("gen_ai.response.first_token_ms").Get("intValue").String())
+
+	_, err = NewGenerationExporter(GenerationExporterConfig{Type: "zipkin", Exporter: BatchExporterConfig{Client: client}})
+	assert.Error(t, err)
+}
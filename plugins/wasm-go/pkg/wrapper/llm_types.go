@@ -0,0 +1,340 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"bytes"
+	"strings"
+)
+
+// The types follow the OpenAI Chat Completions API, which is used as the common format of all the providers.
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+
+	ContentTypeText       = "text"
+	ContentTypeImageUrl   = "image_url"
+	ContentTypeInputAudio = "input_audio"
+
+	FinishReasonStop      = "stop"
+	FinishReasonLength    = "length"
+	FinishReasonToolCalls = "tool_calls"
+)
+
+type ChatCompletionRequest struct {
+	Model            string                 `json:"model"`
+	Messages         []ChatMessage          `json:"messages"`
+	MaxTokens        int                    `json:"max_tokens,omitempty"`
+	FrequencyPenalty float64                `json:"frequency_penalty,omitempty"`
+	N                int                    `json:"n,omitempty"`
+	PresencePenalty  float64                `json:"presence_penalty,omitempty"`
+	Seed             int                    `json:"seed,omitempty"`
+	Stream           bool                   `json:"stream,omitempty"`
+	StreamOptions    *StreamOptions         `json:"stream_options,omitempty"`
+	Temperature      float64                `json:"temperature,omitempty"`
+	TopP             float64                `json:"top_p,omitempty"`
+	Tools            []Tool                 `json:"tools,omitempty"`
+	ToolChoice       interface{}            `json:"tool_choice,omitempty"`
+	User             string                 `json:"user,omitempty"`
+	Stop             []string               `json:"stop,omitempty"`
+	ResponseFormat   map[string]interface{} `json:"response_format,omitempty"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage,omitempty"`
+}
+
+type Tool struct {
+	Type     string             `json:"type"`
+	Function FunctionDefinition `json:"function"`
+}
+
+type FunctionDefinition struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+}
+
+type ToolCall struct {
+	Index    int          `json:"index"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// ChatMessage is a message of the conversation, the content is either a string or an array of content parts.
+type ChatMessage struct {
+	Role       string      `json:"role,omitempty"`
+	Name       string      `json:"name,omitempty"`
+	Content    interface{} `json:"content,omitempty"`
+	ToolCalls  []ToolCall  `json:"tool_calls,omitempty"`
+	ToolCallID string      `json:"tool_call_id,omitempty"`
+}
+
+type ContentPart struct {
+	Type       string      `json:"type"`
+	Text       string      `json:"text,omitempty"`
+	ImageUrl   *ImageUrl   `json:"image_url,omitempty"`
+	InputAudio *InputAudio `json:"input_audio,omitempty"`
+}
+
+type ImageUrl struct {
+	Url    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// InputAudio is the base64 encoded audio of a user message, the format is wav or mp3.
+type InputAudio struct {
+	Data   string `json:"data"`
+	Format string `json:"format"`
+}
+
+// StringContent returns the string content, or the text parts joined by new lines.
+func (m ChatMessage) StringContent() string {
+	switch content := m.Content.(type) {
+	case string:
+		return content
+	case []ContentPart:
+		var texts []string
+		for _, part := range content {
+			if part.Type == ContentTypeText {
+				texts = append(texts, part.Text)
+			}
+		}
+		return strings.Join(texts, "\n")
+	case []interface{}:
+		var texts []string
+		for _, item := range content {
+			part, ok := item.(map[string]interface{})
+			if !ok || part["type"] != ContentTypeText {
+				continue
+			}
+			if text, ok := part["text"].(string); ok {
+				texts = append(texts, text)
+			}
+		}
+		return strings.Join(texts, "\n")
+	}
+	return ""
+}
+
+// ContentParts returns the content as parts, a string content becomes a single text part.
+func (m ChatMessage) ContentParts() []ContentPart {
+	switch content := m.Content.(type) {
+	case string:
+		return []ContentPart{{Type: ContentTypeText, Text: content}}
+	case []ContentPart:
+		return content
+	case []interface{}:
+		var parts []ContentPart
+		for _, item := range content {
+			part, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch part["type"] {
+			case ContentTypeText:
+				text, _ := part["text"].(string)
+				parts = append(parts, ContentPart{Type: ContentTypeText, Text: text})
+			case ContentTypeImageUrl:
+				image, _ := part["image_url"].(map[string]interface{})
+				url, _ := image["url"].(string)
+				detail, _ := image["detail"].(string)
+				parts = append(parts, ContentPart{Type: ContentTypeImageUrl, ImageUrl: &ImageUrl{Url: url, Detail: detail}})
+			case ContentTypeInputAudio:
+				audio, _ := part["input_audio"].(map[string]interface{})
+				data, _ := audio["data"].(string)
+				format, _ := audio["format"].(string)
+				parts = append(parts, ContentPart{Type: ContentTypeInputAudio, InputAudio: &InputAudio{Data: data, Format: format}})
+			}
+		}
+		return parts
+	}
+	return nil
+}
+
+type ChatCompletionResponse struct {
+	ID                string                 `json:"id,omitempty"`
+	Object            string                 `json:"object,omitempty"`
+	Created           int64                  `json:"created,omitempty"`
+	Model             string                 `json:"model,omitempty"`
+	SystemFingerprint string                 `json:"system_fingerprint,omitempty"`
+	Choices           []ChatCompletionChoice `json:"choices"`
+	Usage             *Usage                 `json:"usage,omitempty"`
+}
+
+type ChatCompletionChoice struct {
+	Index        int          `json:"index"`
+	Message      *ChatMessage `json:"message,omitempty"`
+	Delta        *ChatMessage `json:"delta,omitempty"`
+	FinishReason string       `json:"finish_reason,omitempty"`
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Content returns the content of the first choice, from the message or the delta of a chunk.
+func (r *ChatCompletionResponse) Content() string {
+	if len(r.Choices) == 0 {
+		return ""
+	}
+	if r.Choices[0].Message != nil {
+		return r.Choices[0].Message.StringContent()
+	}
+	if r.Choices[0].Delta != nil {
+		return r.Choices[0].Delta.StringContent()
+	}
+	return ""
+}
+
+type EmbeddingsRequest struct {
+	Model          string   `json:"model"`
+	Input          []string `json:"input"`
+	Dimensions     int      `json:"dimensions,omitempty"`
+	EncodingFormat string   `json:"encoding_format,omitempty"`
+}
+
+type EmbeddingsResponse struct {
+	Object string      `json:"object,omitempty"`
+	Model  string      `json:"model,omitempty"`
+	Data   []Embedding `json:"data"`
+	Usage  *Usage      `json:"usage,omitempty"`
+}
+
+type Embedding struct {
+	Object    string    `json:"object,omitempty"`
+	Index     int       `json:"index"`
+	Embedding []float64 `json:"embedding"`
+}
+
+const SSEDone = "[DONE]"
+
+// SSEEvent is an event of the text/event-stream.
+type SSEEvent struct {
+	ID    string
+	Event string
+	Data  string
+	Retry string
+}
+
+// Bytes serializes the event, including the blank line ending it.
+func (e SSEEvent) Bytes() []byte {
+	var buf bytes.Buffer
+	if e.ID != "" {
+		buf.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		buf.WriteString("event: " + e.Event + "\n")
+	}
+	if e.Retry != "" {
+		buf.WriteString("retry: " + e.Retry + "\n")
+	}
+	for _, line := range strings.Split(e.Data, "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+// SSEParser parses the event stream incrementally, the data of an event may be split across chunks.
+type SSEParser struct {
+	buffer []byte
+}
+
+// Feed appends the chunk and returns the complete events, the incomplete tail is kept until the next chunk.
+func (p *SSEParser) Feed(chunk []byte) []SSEEvent {
+	p.buffer = append(p.buffer, chunk...)
+	var events []SSEEvent
+	for {
+		end, size := sseEventEnd(p.buffer)
+		if end < 0 {
+			break
+		}
+		if event, ok := parseSSEEvent(p.buffer[:end]); ok {
+			events = append(events, event)
+		}
+		p.buffer = p.buffer[end+size:]
+	}
+	if len(p.buffer) == 0 {
+		p.buffer = nil
+	}
+	return events
+}
+
+// Flush returns the last event if the stream ends without a blank line.
+func (p *SSEParser) Flush() []SSEEvent {
+	data := p.buffer
+	p.buffer = nil
+	if event, ok := parseSSEEvent(data); ok {
+		return []SSEEvent{event}
+	}
+	return nil
+}
+
+// Pending returns the bytes which are not parsed into events yet.
+func (p *SSEParser) Pending() []byte {
+	return p.buffer
+}
+
+// sseEventEnd returns the position and the size of the first blank line.
+func sseEventEnd(data []byte) (int, int) {
+	end, size := -1, 0
+	for _, sep := range []string{"\r\n\r\n", "\n\n", "\r\r"} {
+		if i := bytes.Index(data, []byte(sep)); i >= 0 && (end < 0 || i < end) {
+			end, size = i, len(sep)
+		}
+	}
+	return end, size
+}
+
+func parseSSEEvent(data []byte) (SSEEvent, bool) {
+	var event SSEEvent
+	var dataLines []string
+	hasField := false
+	lines := strings.FieldsFunc(string(data), func(r rune) bool { return r == '\n' || r == '\r' })
+	for _, line := range lines {
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "data":
+			dataLines = append(dataLines, value)
+		case "event":
+			event.Event = value
+		case "id":
+			event.ID = value
+		case "retry":
+			event.Retry = value
+		default:
+			continue
+		}
+		hasField = true
+	}
+	event.Data = strings.Join(dataLines, "\n")
+	return event, hasField
+}
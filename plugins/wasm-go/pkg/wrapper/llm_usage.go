@@ -0,0 +1,289 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	usageRecordContextKey = "__usage_record__"
+
+	UsageExportWebhook = "webhook"
+	// Kafka is reached through the Confluent REST proxy, the records are posted to /topics/{topic}
+	UsageExportKafka = "kafka"
+)
+
+// The metric and ai_log attribute names, the same as the ai-statistics plugin
+const (
+	UsageModel                 = "model"
+	UsageInputToken            = "input_token"
+	UsageOutputToken           = "output_token"
+	UsageLLMFirstTokenDuration = "llm_first_token_duration"
+	UsageLLMServiceDuration    = "llm_service_duration"
+	UsageLLMDurationCount      = "llm_duration_count"
+	UsageCacheHitCount         = "cache_hit_count"
+)
+
+// UsageRecord is the usage of a model call, the plugins fill it along the phases and record it at the end.
+type UsageRecord struct {
+	RequestID string `json:"request_id,omitempty"`
+	// The start time in unix milliseconds
+	Timestamp        int64  `json:"timestamp"`
+	Route            string `json:"route,omitempty"`
+	Cluster          string `json:"cluster,omitempty"`
+	Consumer         string `json:"consumer,omitempty"`
+	Provider         string `json:"provider,omitempty"`
+	Model            string `json:"model,omitempty"`
+	Stream           bool   `json:"stream"`
+	CacheHit         bool   `json:"cache_hit"`
+	StatusCode       int    `json:"status_code,omitempty"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+	// The latencies in milliseconds
+	FirstTokenLatency int64 `json:"first_token_latency,omitempty"`
+	Latency           int64 `json:"latency"`
+}
+
+func NewUsageRecord(start time.Time) *UsageRecord {
+	return &UsageRecord{Timestamp: start.UnixMilli()}
+}
+
+func (r *UsageRecord) SetUsage(usage Usage) {
+	r.PromptTokens = usage.PromptTokens
+	r.CompletionTokens = usage.CompletionTokens
+	r.TotalTokens = usage.TotalTokens
+}
+
+// MarkFirstToken records the latency of the first streaming chunk, the later calls are ignored.
+func (r *UsageRecord) MarkFirstToken(now time.Time) {
+	if r.FirstTokenLatency == 0 {
+		r.FirstTokenLatency = now.UnixMilli() - r.Timestamp
+	}
+}
+
+// Finish records the total latency.
+func (r *UsageRecord) Finish(now time.Time) {
+	r.Latency = now.UnixMilli() - r.Timestamp
+	if r.TotalTokens == 0 {
+		r.TotalTokens = r.PromptTokens + r.CompletionTokens
+	}
+}
+
+// GetUsageRecord returns the usage record of the request, it's created with the current time on the first call,
+// so it should be called in the request headers phase first.
+func GetUsageRecord(ctx HttpContext) *UsageRecord {
+	if c, ok := ctx.(httpContextExtension); ok {
+		return c.usageRecord()
+	}
+	return NewUsageRecord(time.Now())
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) usageRecord() *UsageRecord {
+	if record, ok := ctx.userContext[usageRecordContextKey].(*UsageRecord); ok {
+		return record
+	}
+	record := NewUsageRecord(time.Now())
+	ctx.userContext[usageRecordContextKey] = record
+	return record
+}
+
+type UsageRecorderConfig struct {
+	DisableMetrics bool
+	DisableLog     bool
+	// The records are exported if it's set
+	Exporter *BatchExporter
+}
+
+// UsageRecorder writes the usage records to the metrics, the ai_log filter state and the exporter in the same
+// format, whichever AI plugin produces them.
+type UsageRecorder struct {
+	config   UsageRecorderConfig
+	counters map[string]proxywasm.MetricCounter
+}
+
+func NewUsageRecorder(config UsageRecorderConfig) *UsageRecorder {
+	return &UsageRecorder{config: config, counters: map[string]proxywasm.MetricCounter{}}
+}
+
+// ParseUsageRecorder parses the config like:
+//
+//	{"metrics": true, "log": true, "export": {"type": "kafka", "serviceName": "kafka-rest.dns", "servicePort": 8082,
+//	 "topic": "ai-usage", "batchSize": 100, "flushInterval": 5000}}
+func ParseUsageRecorder(json gjson.Result) (*UsageRecorder, error) {
+	config := UsageRecorderConfig{
+		DisableMetrics: json.Get("metrics").Exists() && !json.Get("metrics").Bool(),
+		DisableLog:     json.Get("log").Exists() && !json.Get("log").Bool(),
+	}
+	if export := json.Get("export"); export.Exists() {
+		exporterConfig, err := parseUsageExport(export)
+		if err != nil {
+			return nil, err
+		}
+		if config.Exporter, err = NewBatchExporter(exporterConfig); err != nil {
+			return nil, err
+		}
+	}
+	return NewUsageRecorder(config), nil
+}
+
+func parseUsageExport(json gjson.Result) (BatchExporterConfig, error) {
+	serviceName := json.Get("serviceName").String()
+	if serviceName == "" {
+		return BatchExporterConfig{}, fmt.Errorf("usage export serviceName is empty")
+	}
+	servicePort := json.Get("servicePort").Int()
+	if servicePort == 0 {
+		servicePort = 443
+	}
+	config := BatchExporterConfig{
+		Client:        NewClusterClient(FQDNCluster{FQDN: serviceName, Host: json.Get("serviceHost").String(), Port: servicePort}),
+		Path:          json.Get("path").String(),
+		BatchSize:     int(json.Get("batchSize").Int()),
+		FlushInterval: json.Get("flushInterval").Int(),
+		Timeout:       uint32(json.Get("timeout").Uint()),
+	}
+	switch json.Get("type").String() {
+	case UsageExportWebhook, "":
+		config.Headers = [][2]string{{"content-type", "application/json"}}
+	case UsageExportKafka:
+		topic := json.Get("topic").String()
+		if topic == "" {
+			return config, fmt.Errorf("usage export topic is empty")
+		}
+		config.Path = "/topics/" + topic
+		config.Headers = [][2]string{{"content-type", "application/vnd.kafka.json.v2+json"}}
+		config.Encode = encodeKafkaRecords
+	default:
+		return config, fmt.Errorf("unknown usage export type: %s", json.Get("type").String())
+	}
+	if apiKey := json.Get("apiKey").String(); apiKey != "" {
+		config.Headers = append(config.Headers, [2]string{"authorization", "Bearer " + apiKey})
+	}
+	return config, nil
+}
+
+// encodeKafkaRecords encodes the records in the format of the Kafka REST proxy v2.
+func encodeKafkaRecords(records []json.RawMessage) ([]byte, error) {
+	type kafkaRecord struct {
+		Value json.RawMessage `json:"value"`
+	}
+	body := struct {
+		Records []kafkaRecord `json:"records"`
+	}{}
+	for _, record := range records {
+		body.Records = append(body.Records, kafkaRecord{Value: record})
+	}
+	return json.Marshal(body)
+}
+
+// Record completes the record with the route, cluster, consumer and request id of the request, and writes it.
+// It should be called once per request, usually in the stream done or the response body phase.
+func (u *UsageRecorder) Record(ctx HttpContext, record *UsageRecord) {
+	if record.Latency == 0 {
+		record.Finish(time.Now())
+	}
+	if record.Route == "" {
+		record.Route = getPropertyString("route_name")
+	}
+	if record.Cluster == "" {
+		record.Cluster = getPropertyString("cluster_name")
+	}
+	if record.RequestID == "" {
+		record.RequestID = getPropertyString("request", "id")
+	}
+	if record.Consumer == "" {
+		if consumer := GetAuthenticatedConsumer(ctx); consumer != nil {
+			record.Consumer = consumer.Name
+		}
+	}
+	if !u.config.DisableMetrics {
+		for name, value := range usageMetrics(record) {
+			u.incrementCounter(usageMetricName(record, name), value)
+		}
+	}
+	if !u.config.DisableLog {
+		for key, value := range usageAttributes(record) {
+			ctx.SetUserAttribute(key, value)
+		}
+		_ = ctx.WriteUserAttributeToLogWithKey(AILogKey)
+	}
+	if u.config.Exporter != nil {
+		if err := u.config.Exporter.Add(record); err != nil {
+			proxywasm.LogWarnf("failed to export usage record: %v", err)
+		}
+	}
+}
+
+func (u *UsageRecorder) incrementCounter(name string, value uint64) {
+	counter, ok := u.counters[name]
+	if !ok {
+		counter = proxywasm.DefineCounterMetric(name)
+		u.counters[name] = counter
+	}
+	counter.Increment(value)
+}
+
+func usageMetricName(record *UsageRecord, metric string) string {
+	return fmt.Sprintf("route.%s.upstream.%s.model.%s.consumer.%s.metric.%s",
+		usageDimension(record.Route), usageDimension(record.Cluster), usageDimension(record.Model), usageDimension(record.Consumer), metric)
+}
+
+func usageDimension(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func usageMetrics(record *UsageRecord) map[string]uint64 {
+	metrics := map[string]uint64{
+		UsageInputToken:         uint64(record.PromptTokens),
+		UsageOutputToken:        uint64(record.CompletionTokens),
+		UsageLLMServiceDuration: uint64(record.Latency),
+		UsageLLMDurationCount:   1,
+	}
+	if record.Stream && record.FirstTokenLatency > 0 {
+		metrics[UsageLLMFirstTokenDuration] = uint64(record.FirstTokenLatency)
+	}
+	if record.CacheHit {
+		metrics[UsageCacheHitCount] = 1
+	}
+	return metrics
+}
+
+func usageAttributes(record *UsageRecord) map[string]interface{} {
+	attributes := map[string]interface{}{
+		UsageModel:              record.Model,
+		UsageInputToken:         record.PromptTokens,
+		UsageOutputToken:        record.CompletionTokens,
+		UsageLLMServiceDuration: record.Latency,
+		"provider":              record.Provider,
+		"cache_hit":             record.CacheHit,
+	}
+	if record.Stream {
+		attributes[UsageLLMFirstTokenDuration] = record.FirstTokenLatency
+	}
+	if record.Consumer != "" {
+		attributes["consumer"] = record.Consumer
+	}
+	return attributes
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestUsageRecord(t *testing.T) {
+	start := time.UnixMilli(1700000000000)
+	record := NewUsageRecord(start)
+	record.Model, record.Stream, record.CacheHit = "qwen-max", true, true
+	record.MarkFirstToken(start.Add(300 * time.Millisecond))
+	record.MarkFirstToken(start.Add(500 * time.Millisecond))
+	record.SetUsage(Usage{PromptTokens: 10, CompletionTokens: 20})
+	record.Finish(start.Add(2 * time.Second))
+	assert.Equal(t, int64(300), record.FirstTokenLatency)
+	assert.Equal(t, int64(2000), record.Latency)
+	assert.Equal(t, 30, record.TotalTokens)
+
+	assert.Equal(t, map[string]uint64{
+		UsageInputToken:            10,
+		UsageOutputToken:           20,
+		UsageLLMServiceDuration:    2000,
+		UsageLLMDurationCount:      1,
+		UsageLLMFirstTokenDuration: 300,
+		UsageCacheHitCount:         1,
+	}, usageMetrics(record))
+	assert.Equal(t, "route.-.upstream.-.model.qwen-max.consumer.-.metric.input_token", usageMetricName(record, UsageInputToken))
+	attributes := usageAttributes(record)
+	assert.Equal(t, "qwen-max", attributes[UsageModel])
+	assert.Equal(t, true, attributes["cache_hit"])
+	assert.NotContains(t, attributes, "consumer")
+}
+
+func TestParseUsageExport(t *testing.T) {
+	config, err := parseUsageExport(gjson.Parse(`{"type": "kafka", "serviceName": "kafka-rest.dns", "topic": "ai-usage", "apiKey": "token"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "/topics/ai-usage", config.Path)
+	assert.Contains(t, config.Headers, [2]string{"authorization", "Bearer token"})
+	body, err := config.Encode([]json.RawMessage{[]byte(`{"model":"a"}`), []byte(`{"model":"b"}`)})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"records": [{"value": {"model": "a"}}, {"value": {"model": "b"}}]}`, string(body))
+
+	_, err = parseUsageExport(gjson.Parse(`{"type": "kafka", "serviceName": "kafka-rest.dns"}`))
+	assert.Error(t, err)
+	_, err = parseUsageExport(gjson.Parse(`{"type": "sls", "serviceName": "sls.dns"}`))
+	assert.Error(t, err)
+	config, err = parseUsageExport(gjson.Parse(`{"serviceName": "collector.dns", "path": "/usage"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "/usage", config.Path)
+	assert.Nil(t, config.Encode)
+}
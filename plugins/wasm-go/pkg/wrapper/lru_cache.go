@@ -0,0 +1,196 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"errors"
+	"time"
+)
+
+type lruEntry[K comparable, V any] struct {
+	key        K
+	value      V
+	expiresAt  int64
+	prev, next *lruEntry[K, V]
+}
+
+// LRUCache is a cache of the VM bounded by the number of entries, the least recently used entry is evicted when it's
+// full. It's not shared with the other VMs, and not safe for concurrent use, which is fine since a VM is single
+// threaded. The expired entries are removed when they're read, or by RemoveExpired which can run on tick with
+// ExpireOnTick, there is no goroutine involved.
+type LRUCache[K comparable, V any] struct {
+	size  int
+	ttl   time.Duration
+	items map[K]*lruEntry[K, V]
+	// head is the most recently used entry, tail the least
+	head, tail *lruEntry[K, V]
+	hits       uint64
+	misses     uint64
+	evictions  uint64
+	now        func() time.Time
+}
+
+type LRUCacheStats struct {
+	Size      int
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// NewLRUCache creates a cache of at most size entries, the entries expire after ttl, or never if ttl is 0.
+func NewLRUCache[K comparable, V any](size int, ttl time.Duration) (*LRUCache[K, V], error) {
+	if size <= 0 {
+		return nil, errors.New("lru cache size must be positive")
+	}
+	if ttl < 0 {
+		return nil, errors.New("lru cache ttl must not be negative")
+	}
+	return &LRUCache[K, V]{
+		size:  size,
+		ttl:   ttl,
+		items: make(map[K]*lruEntry[K, V], size),
+		now:   time.Now,
+	}, nil
+}
+
+// ExpireOnTick removes the expired entries every tickPeriod milliseconds, so the memory of the entries never read
+// again is released. Like RegisteTickFunc, it should be called in parseConfig phase.
+func (c *LRUCache[K, V]) ExpireOnTick(tickPeriod int64) {
+	RegisteTickFunc(tickPeriod, func() { c.RemoveExpired() })
+}
+
+// Get returns the value of the key and marks it as recently used.
+func (c *LRUCache[K, V]) Get(key K) (V, bool) {
+	entry, ok := c.items[key]
+	if ok && c.expired(entry, c.now().UnixMilli()) {
+		c.remove(entry)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		var zero V
+		return zero, false
+	}
+	c.hits++
+	c.moveToFront(entry)
+	return entry.value, true
+}
+
+// Set adds or replaces the value of the key with the ttl of the cache.
+func (c *LRUCache[K, V]) Set(key K, value V) {
+	c.SetWithTTL(key, value, c.ttl)
+}
+
+// SetWithTTL adds or replaces the value of the key with its own ttl, e.g. the exp of a token, 0 means it never
+// expires.
+func (c *LRUCache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = c.now().Add(ttl).UnixMilli()
+	}
+	if entry, ok := c.items[key]; ok {
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.moveToFront(entry)
+		return
+	}
+	if len(c.items) >= c.size {
+		c.remove(c.tail)
+		c.evictions++
+	}
+	entry := &lruEntry[K, V]{key: key, value: value, expiresAt: expiresAt}
+	c.items[key] = entry
+	c.pushFront(entry)
+}
+
+func (c *LRUCache[K, V]) Delete(key K) bool {
+	entry, ok := c.items[key]
+	if ok {
+		c.remove(entry)
+	}
+	return ok
+}
+
+// Len returns the number of entries, including the expired ones not removed yet.
+func (c *LRUCache[K, V]) Len() int {
+	return len(c.items)
+}
+
+func (c *LRUCache[K, V]) Purge() {
+	c.items = make(map[K]*lruEntry[K, V], c.size)
+	c.head, c.tail = nil, nil
+}
+
+// RemoveExpired removes the expired entries, and returns the number of them.
+func (c *LRUCache[K, V]) RemoveExpired() int {
+	now := c.now().UnixMilli()
+	removed := 0
+	for entry := c.tail; entry != nil; {
+		prev := entry.prev
+		if c.expired(entry, now) {
+			c.remove(entry)
+			removed++
+		}
+		entry = prev
+	}
+	return removed
+}
+
+func (c *LRUCache[K, V]) Stats() LRUCacheStats {
+	return LRUCacheStats{Size: len(c.items), Hits: c.hits, Misses: c.misses, Evictions: c.evictions}
+}
+
+func (c *LRUCache[K, V]) expired(entry *lruEntry[K, V], now int64) bool {
+	return entry.expiresAt > 0 && entry.expiresAt <= now
+}
+
+func (c *LRUCache[K, V]) pushFront(entry *lruEntry[K, V]) {
+	entry.prev = nil
+	entry.next = c.head
+	if c.head != nil {
+		c.head.prev = entry
+	}
+	c.head = entry
+	if c.tail == nil {
+		c.tail = entry
+	}
+}
+
+func (c *LRUCache[K, V]) unlink(entry *lruEntry[K, V]) {
+	if entry.prev != nil {
+		entry.prev.next = entry.next
+	} else {
+		c.head = entry.next
+	}
+	if entry.next != nil {
+		entry.next.prev = entry.prev
+	} else {
+		c.tail = entry.prev
+	}
+	entry.prev, entry.next = nil, nil
+}
+
+func (c *LRUCache[K, V]) moveToFront(entry *lruEntry[K, V]) {
+	if c.head == entry {
+		return
+	}
+	c.unlink(entry)
+	c.pushFront(entry)
+}
+
+func (c *LRUCache[K, V]) remove(entry *lruEntry[K, V]) {
+	c.unlink(entry)
+	delete(c.items, entry.key)
+}
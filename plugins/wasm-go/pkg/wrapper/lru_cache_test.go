@@ -0,0 +1,88 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUCache(t *testing.T) {
+	_, err := NewLRUCache[string, int](0, time.Minute)
+	assert.Error(t, err)
+
+	cache, err := NewLRUCache[string, int](2, time.Minute)
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	cache.now = func() time.Time { return now }
+
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	value, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+	// b is the least recently used
+	cache.Set("c", 3)
+	_, ok = cache.Get("b")
+	assert.False(t, ok)
+	assert.Equal(t, 2, cache.Len())
+	cache.Set("a", 10)
+	value, _ = cache.Get("a")
+	assert.Equal(t, 10, value)
+
+	cache.SetWithTTL("c", 3, time.Hour)
+	now = now.Add(2 * time.Minute)
+	_, ok = cache.Get("a")
+	assert.False(t, ok)
+	_, ok = cache.Get("c")
+	assert.True(t, ok)
+	assert.Equal(t, LRUCacheStats{Size: 1, Hits: 3, Misses: 2, Evictions: 1}, cache.Stats())
+
+	assert.True(t, cache.Delete("c"))
+	assert.False(t, cache.Delete("c"))
+	assert.Equal(t, 0, cache.Len())
+}
+
+func TestLRUCacheRemoveExpired(t *testing.T) {
+	cache, err := NewLRUCache[int, string](10, time.Second)
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	cache.now = func() time.Time { return now }
+	for i := 0; i < 5; i++ {
+		cache.Set(i, "v")
+	}
+	cache.SetWithTTL(5, "forever", 0)
+	now = now.Add(time.Second)
+	cache.Set(6, "fresh")
+	assert.Equal(t, 5, cache.RemoveExpired())
+	assert.Equal(t, 2, cache.Len())
+	_, ok := cache.Get(5)
+	assert.True(t, ok)
+	cache.Purge()
+	assert.Equal(t, 0, cache.Len())
+	cache.Set(1, "again")
+	assert.Equal(t, 1, cache.Len())
+
+	globalOnTickFuncs = nil
+	defer func() { globalOnTickFuncs = nil }()
+	cache.ExpireOnTick(1000)
+	require.Len(t, globalOnTickFuncs, 1)
+	now = now.Add(time.Second)
+	globalOnTickFuncs[0].tickFunc()
+	assert.Equal(t, 0, cache.Len())
+}
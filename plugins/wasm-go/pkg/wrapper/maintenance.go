@@ -0,0 +1,331 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	TemplateSourceMaintenance = "maintenance"
+
+	maintenanceKeyPrefix      = "maintenance:"
+	maintenanceLeaseKeyPrefix = "maintenance_lease:"
+)
+
+const defaultMaintenancePage = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Under maintenance</title></head>
+<body><h1>{{request.host}} is under maintenance</h1><p>{{maintenance.message | We will be back soon.}}</p></body></html>
+`
+
+// MaintenanceState is the switch of a maintenance mode kept in shared data, so it's shared by the worker threads
+// and can be flipped without a config rollout.
+type MaintenanceState struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+	// The unix seconds the maintenance is expected to end at, it's sent as the retry-after header
+	Until     int64 `json:"until,omitempty"`
+	UpdatedAt int64 `json:"updatedAt"`
+}
+
+// SetMaintenance flips the switch of the maintenance mode name, e.g. by the admin api of a plugin. The switch is
+// overwritten by the next poll if the maintenance mode has a remote flag.
+func SetMaintenance(name string, state MaintenanceState) error {
+	state.UpdatedAt = time.Now().Unix()
+	return SetSharedDataJson(maintenanceKeyPrefix+name, state, 0)
+}
+
+// GetMaintenance returns the switch of the maintenance mode name, ok is false if it has never been set.
+func GetMaintenance(name string) (state MaintenanceState, ok bool) {
+	if _, err := GetSharedDataJson(maintenanceKeyPrefix+name, &state); err != nil {
+		proxywasm.LogErrorf("failed to get maintenance %s: %v", name, err)
+		return state, false
+	}
+	return state, state.UpdatedAt != 0
+}
+
+type MaintenanceRemoteConfig struct {
+	Client  HttpClient
+	Path    string
+	Headers [][2]string
+	// The poll interval in milliseconds, default is 10000ms
+	Interval int64
+	// The timeout of the poll in milliseconds, default is 2000ms
+	Timeout uint32
+	// The gjson paths of the fields in the response, default are enabled, message and until. The switch is kept
+	// if the response isn't 200 or has no enabled field.
+	EnabledPath string
+	MessagePath string
+	UntilPath   string
+}
+
+type MaintenanceConfig struct {
+	// The name of the switch in shared data, default is "default"
+	Name string
+	// Whether the maintenance mode is on before the switch is set
+	Enabled bool
+	// The flag polled on tick by one of the VMs sharing the data, it's optional
+	Remote *MaintenanceRemoteConfig
+	// The maintenance reply, the variables of the maintenance source are message, until (an http date) and
+	// retryAfter (the seconds until then). It's a 503 html page by default.
+	Response *ResponseTemplate
+	// The ips or cidrs of the clients let through, e.g. the office network to verify the release
+	AllowIPs []string
+	// The headers letting the request through, the value is compared as a secret, and any value matches if it's
+	// empty
+	AllowHeaders   [][2]string
+	ClientIPHeader string
+}
+
+// ParseMaintenanceConfig parses the config like:
+//
+//	{"name": "shop", "clientIPHeader": "x-real-ip",
+//	 "allowIPs": ["10.0.0.0/8", "192.168.1.10"], "allowHeaders": {"x-maintenance-bypass": "s3cret"},
+//	 "remote": {"serviceName": "flags.dns", "servicePort": 80, "path": "/maintenance/shop", "interval": 10000},
+//	 "response": {"statusCode": 503, "contentType": "application/json", "body": "{\"message\": \"{{maintenance.message}}\"}"}}
+func ParseMaintenanceConfig(json gjson.Result) (MaintenanceConfig, error) {
+	config := MaintenanceConfig{
+		Name:           json.Get("name").String(),
+		Enabled:        json.Get("enabled").Bool(),
+		ClientIPHeader: json.Get("clientIPHeader").String(),
+	}
+	for _, ip := range json.Get("allowIPs").Array() {
+		config.AllowIPs = append(config.AllowIPs, ip.String())
+	}
+	json.Get("allowHeaders").ForEach(func(key, value gjson.Result) bool {
+		config.AllowHeaders = append(config.AllowHeaders, [2]string{strings.ToLower(key.String()), value.String()})
+		return true
+	})
+	if response := json.Get("response"); response.Exists() {
+		var err error
+		if config.Response, err = ParseResponseTemplate(response); err != nil {
+			return config, err
+		}
+	}
+	if remote := json.Get("remote"); remote.Exists() {
+		serviceName := remote.Get("serviceName").String()
+		if serviceName == "" {
+			return config, errors.New("maintenance remote serviceName is empty")
+		}
+		servicePort := remote.Get("servicePort").Int()
+		if servicePort == 0 {
+			servicePort = 80
+		}
+		config.Remote = &MaintenanceRemoteConfig{
+			Client:      NewClusterClient(FQDNCluster{FQDN: serviceName, Host: remote.Get("serviceHost").String(), Port: servicePort}),
+			Path:        remote.Get("path").String(),
+			Interval:    remote.Get("interval").Int(),
+			Timeout:     uint32(remote.Get("timeout").Uint()),
+			EnabledPath: remote.Get("enabledPath").String(),
+			MessagePath: remote.Get("messagePath").String(),
+			UntilPath:   remote.Get("untilPath").String(),
+		}
+		remote.Get("headers").ForEach(func(key, value gjson.Result) bool {
+			config.Remote.Headers = append(config.Remote.Headers, [2]string{key.String(), value.String()})
+			return true
+		})
+	}
+	return config, nil
+}
+
+// Maintenance replies the requests with the maintenance response while the switch is on, except the allowlisted
+// ones.
+type Maintenance struct {
+	config   MaintenanceConfig
+	allowIPs []*net.IPNet
+	response *ResponseTemplate
+	state    MaintenanceState
+	// cas of the shared data which the state comes from
+	cas uint32
+}
+
+// NewMaintenance should be called in parseConfig phase since it registers the poll tick function.
+func NewMaintenance(config MaintenanceConfig) (*Maintenance, error) {
+	if config.Name == "" {
+		config.Name = "default"
+	}
+	m := &Maintenance{config: config, response: config.Response, state: MaintenanceState{Enabled: config.Enabled}}
+	for _, ip := range config.AllowIPs {
+		if !strings.Contains(ip, "/") {
+			if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() != nil {
+				ip += "/32"
+			} else {
+				ip += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(ip)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance allowed ip: %v", err)
+		}
+		m.allowIPs = append(m.allowIPs, network)
+	}
+	if m.response == nil {
+		body, err := ParsePromptTemplate(defaultMaintenancePage, PromptTemplateOptions{Escape: TemplateEscapeHTML})
+		if err != nil {
+			return nil, err
+		}
+		retryAfter, _ := ParsePromptTemplate("{{maintenance.retryAfter}}", PromptTemplateOptions{})
+		noStore, _ := ParsePromptTemplate("no-store", PromptTemplateOptions{})
+		m.response = &ResponseTemplate{
+			StatusCode:  http.StatusServiceUnavailable,
+			ContentType: "text/html; charset=utf-8",
+			headers: []responseTemplateHeader{
+				{name: "retry-after", value: retryAfter},
+				{name: "cache-control", value: noStore},
+			},
+			body: body,
+		}
+	}
+	if remote := config.Remote; remote != nil {
+		if remote.Client == nil || remote.Path == "" {
+			return nil, errors.New("maintenance remote client or path is empty")
+		}
+		if remote.Interval <= 0 {
+			remote.Interval = 10000
+		}
+		if remote.Timeout == 0 {
+			remote.Timeout = 2000
+		}
+		if remote.EnabledPath == "" {
+			remote.EnabledPath = "enabled"
+		}
+		if remote.MessagePath == "" {
+			remote.MessagePath = "message"
+		}
+		if remote.UntilPath == "" {
+			remote.UntilPath = "until"
+		}
+		leaseTTL := 3 * time.Duration(remote.Interval) * time.Millisecond
+		RegisteTickFunc(remote.Interval, func() {
+			if TryAcquireSharedLease(maintenanceLeaseKeyPrefix+config.Name, leaseTTL) {
+				m.poll()
+			}
+		})
+	}
+	return m, nil
+}
+
+func (m *Maintenance) poll() {
+	remote := m.config.Remote
+	err := remote.Client.Get(remote.Path, remote.Headers, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if statusCode != http.StatusOK {
+			proxywasm.LogErrorf("failed to poll maintenance %s, status: %d, body: %s", m.config.Name, statusCode, responseBody)
+			return
+		}
+		body := gjson.ParseBytes(responseBody)
+		enabled := body.Get(remote.EnabledPath)
+		if !enabled.Exists() {
+			proxywasm.LogErrorf("failed to poll maintenance %s, %s is absent in: %s", m.config.Name, remote.EnabledPath, responseBody)
+			return
+		}
+		state := MaintenanceState{
+			Enabled: enabled.Bool(),
+			Message: body.Get(remote.MessagePath).String(),
+			Until:   body.Get(remote.UntilPath).Int(),
+		}
+		current, ok := GetMaintenance(m.config.Name)
+		if ok && current.Enabled == state.Enabled && current.Message == state.Message && current.Until == state.Until {
+			return
+		}
+		if err := SetMaintenance(m.config.Name, state); err != nil {
+			proxywasm.LogErrorf("failed to set maintenance %s: %v", m.config.Name, err)
+			return
+		}
+		proxywasm.LogInfof("maintenance %s is switched to %t by the remote flag", m.config.Name, state.Enabled)
+	}, remote.Timeout)
+	if err != nil {
+		proxywasm.LogErrorf("failed to poll maintenance %s: %v", m.config.Name, err)
+	}
+}
+
+// State returns the current switch, the shared copy is only parsed again after it changes.
+func (m *Maintenance) State() MaintenanceState {
+	data, cas, err := proxywasm.GetSharedData(maintenanceKeyPrefix + m.config.Name)
+	if err != nil || cas == m.cas {
+		return m.state
+	}
+	var state MaintenanceState
+	if err = json.Unmarshal(data, &state); err != nil {
+		proxywasm.LogErrorf("failed to unmarshal maintenance %s: %v", m.config.Name, err)
+		return m.state
+	}
+	m.state = state
+	m.cas = cas
+	return m.state
+}
+
+// Allowed tells whether the current request is let through by the allowlisted ips or headers.
+func (m *Maintenance) Allowed() bool {
+	for _, header := range m.config.AllowHeaders {
+		value, err := proxywasm.GetHttpRequestHeader(header[0])
+		if err != nil {
+			continue
+		}
+		if header[1] == "" || subtle.ConstantTimeCompare([]byte(value), []byte(header[1])) == 1 {
+			return true
+		}
+	}
+	if len(m.allowIPs) == 0 {
+		return false
+	}
+	ip := net.ParseIP(GetClientIP(m.config.ClientIPHeader))
+	if ip == nil {
+		return false
+	}
+	for _, network := range m.allowIPs {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle sends the maintenance response in the request headers phase if the switch is on and the request isn't
+// allowlisted, the routes under maintenance are the ones matched by the rule of the plugin config.
+func (m *Maintenance) Handle(ctx HttpContext) types.Action {
+	state := m.State()
+	if !state.Enabled {
+		return types.ActionContinue
+	}
+	if m.Allowed() {
+		DebugDecision(ctx, "maintenance %s is bypassed by the allowlist", m.config.Name)
+		return types.ActionContinue
+	}
+	DebugDecision(ctx, "maintenance %s is on", m.config.Name)
+	values := map[string]string{}
+	if state.Message != "" {
+		values["message"] = state.Message
+	}
+	if state.Until > 0 {
+		until := time.Unix(state.Until, 0)
+		values["until"] = until.UTC().Format(http.TimeFormat)
+		if seconds := int64(time.Until(until).Seconds()); seconds > 0 {
+			values["retryAfter"] = strconv.FormatInt(seconds, 10)
+		}
+	}
+	return m.response.Send(ctx, "maintenance."+m.config.Name, TemplateSources{TemplateSourceMaintenance: MapTemplateSource(values)})
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestParseMaintenanceConfig(t *testing.T) {
+	config, err := ParseMaintenanceConfig(gjson.Parse(`{
+		"enabled": true,
+		"allowIPs": ["10.0.0.0/8", "192.168.1.10", "2001:db8::1"],
+		"allowHeaders": {"X-Bypass": ""}
+	}`))
+	require.NoError(t, err)
+	assert.True(t, config.Enabled)
+	assert.Equal(t, [][2]string{{"x-bypass", ""}}, config.AllowHeaders)
+	assert.Nil(t, config.Remote)
+	m, err := NewMaintenance(config)
+	require.NoError(t, err)
+	assert.Equal(t, "default", m.config.Name)
+	require.Len(t, m.allowIPs, 3)
+	assert.Equal(t, "192.168.1.10/32", m.allowIPs[1].String())
+	assert.Equal(t, "2001:db8::1/128", m.allowIPs[2].String())
+	assert.Equal(t, 503, m.response.StatusCode)
+
+	_, err = ParseMaintenanceConfig(gjson.Parse(`{"remote": {"path": "/flag"}}`))
+	assert.EqualError(t, err, "maintenance remote serviceName is empty")
+	_, err = ParseMaintenanceConfig(gjson.Parse(`{"response": {"statusCode": 503}}`))
+	assert.Error(t, err)
+	_, err = NewMaintenance(MaintenanceConfig{AllowIPs: []string{"10.0.0.300"}})
+	assert.Error(t, err)
+}
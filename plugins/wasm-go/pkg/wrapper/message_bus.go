@@ -0,0 +1,311 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+const defaultQueueMaxAttempts = 3
+
+var ErrQueueMessageDecode = errors.New("failed to decode queue message")
+
+// MessageCodec encodes the messages put on the shared queues.
+type MessageCodec interface {
+	Marshal(message interface{}) ([]byte, error)
+	Unmarshal(data []byte, message interface{}) error
+}
+
+// ProtoMessage is implemented by the protobuf messages generated with the marshal methods, e.g. by gogoproto or
+// vtprotobuf, so that the protobuf runtime and its reflection are not needed in the plugin.
+type ProtoMessage interface {
+	Marshal() ([]byte, error)
+	Unmarshal(data []byte) error
+}
+
+// JSONMessageCodec encodes the messages with encoding/json.
+var JSONMessageCodec MessageCodec = jsonMessageCodec{}
+
+// ProtoMessageCodec encodes the messages implementing ProtoMessage, the messages handled must be pointers.
+var ProtoMessageCodec MessageCodec = protoMessageCodec{}
+
+type jsonMessageCodec struct{}
+
+func (jsonMessageCodec) Marshal(message interface{}) ([]byte, error) {
+	return json.Marshal(message)
+}
+
+func (jsonMessageCodec) Unmarshal(data []byte, message interface{}) error {
+	return json.Unmarshal(data, message)
+}
+
+type protoMessageCodec struct{}
+
+func (protoMessageCodec) Marshal(message interface{}) ([]byte, error) {
+	m, ok := message.(ProtoMessage)
+	if !ok {
+		return nil, fmt.Errorf("queue message %T doesn't implement ProtoMessage", message)
+	}
+	return m.Marshal()
+}
+
+func (protoMessageCodec) Unmarshal(data []byte, message interface{}) error {
+	m, ok := message.(ProtoMessage)
+	if !ok {
+		return fmt.Errorf("queue message %T doesn't implement ProtoMessage", message)
+	}
+	return m.Unmarshal(data)
+}
+
+// queueEnvelope is put on the queue around the encoded message, to count the deliveries of the message.
+type queueEnvelope struct {
+	Attempts int    `json:"attempts"`
+	Payload  []byte `json:"payload"`
+}
+
+// DeadLetter is a message which failed to be handled, it's put on the dead-letter queue of the consumer in JSON.
+type DeadLetter struct {
+	// The queue the message was consumed from
+	Queue    string `json:"queue"`
+	Attempts int    `json:"attempts"`
+	// The encoded message
+	Payload []byte `json:"payload"`
+	Error   string `json:"error"`
+}
+
+// QueueConsumerConfig configures the handling of the messages of a queue.
+type QueueConsumerConfig struct {
+	// The codec of the messages, JSONMessageCodec by default
+	Codec MessageCodec
+	// The deliveries of a message before it's moved to the dead-letter queue, 3 by default
+	MaxAttempts int
+	// The queue of the messages failed to be handled, "<name>.dlq" by default. The messages are dropped if no
+	// consumer registers the dead-letter queue.
+	DeadLetterQueue string
+}
+
+type queueConsumer struct {
+	name   string
+	config QueueConsumerConfig
+	handle func(payload []byte) error
+}
+
+var globalQueueConsumers []*queueConsumer
+
+// RegisterQueueConsumer registers the shared queue and handles the JSON messages put on it by EnqueueMessage, from
+// this plugin or others in the same VM. If the handler fails, the message is delivered again on the next
+// OnQueueReady, and moved to the dead-letter queue "<name>.dlq" after 3 attempts, see RegisterDeadLetterConsumer.
+//
+// Like RegisteTickFunc, you should call this function in parseConfig phase, for example:
+//
+//	func parseConfig(json gjson.Result, config *BlacklistConfig, log wrapper.Log) error {
+//		wrapper.RegisterQueueConsumer("blacklist-updated", func(event BlacklistEvent) error {
+//			return config.Reload(event.Version)
+//		})
+//		return nil
+//	}
+func RegisterQueueConsumer[T any](name string, handler func(message T) error) {
+	RegisterQueueConsumerWithConfig(name, QueueConsumerConfig{}, handler)
+}
+
+// RegisterQueueConsumerWithConfig registers the shared queue like RegisterQueueConsumer, with the codec and the
+// dead-letter handling of the config. It's registered once if called for the same queue more than once, the last
+// handler is used.
+func RegisterQueueConsumerWithConfig[T any](name string, config QueueConsumerConfig, handler func(message T) error) {
+	if config.Codec == nil {
+		config.Codec = JSONMessageCodec
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultQueueMaxAttempts
+	}
+	if config.DeadLetterQueue == "" {
+		config.DeadLetterQueue = name + ".dlq"
+	}
+	codec := config.Codec
+	consumer := &queueConsumer{
+		name:   name,
+		config: config,
+		handle: func(payload []byte) error {
+			message, err := decodeQueueMessage[T](codec, payload)
+			if err != nil {
+				return err
+			}
+			return handler(message)
+		},
+	}
+	addQueueConsumer(consumer)
+}
+
+func addQueueConsumer(consumer *queueConsumer) {
+	for i := range globalQueueConsumers {
+		if globalQueueConsumers[i].name == consumer.name {
+			globalQueueConsumers[i] = consumer
+			return
+		}
+	}
+	globalQueueConsumers = append(globalQueueConsumers, consumer)
+}
+
+// decodeQueueMessage decodes the message into a T, or into a new value T points to if T is a pointer, as the protobuf
+// messages are.
+func decodeQueueMessage[T any](codec MessageCodec, payload []byte) (T, error) {
+	var message T
+	var target interface{} = &message
+	if t := reflect.TypeOf(message); t != nil && t.Kind() == reflect.Ptr {
+		message = reflect.New(t.Elem()).Interface().(T)
+		target = message
+	}
+	if err := codec.Unmarshal(payload, target); err != nil {
+		return message, fmt.Errorf("%w: %v", ErrQueueMessageDecode, err)
+	}
+	return message, nil
+}
+
+type queueConsumers map[uint32]*queueConsumer
+
+// registerQueueConsumers registers the shared queues of the consumers, it's called in OnPluginStart.
+func registerQueueConsumers(consumers []*queueConsumer) (queueConsumers, error) {
+	if len(consumers) == 0 {
+		return nil, nil
+	}
+	registered := make(queueConsumers, len(consumers))
+	for _, consumer := range consumers {
+		queueID, err := proxywasm.RegisterSharedQueue(consumer.name)
+		if err != nil {
+			return nil, fmt.Errorf("register shared queue %s failed: %v", consumer.name, err)
+		}
+		registered[queueID] = consumer
+	}
+	return registered, nil
+}
+
+// consume handles the messages on the queue until it's empty. The failed messages are put back on the queue after
+// that, so they are delivered again on the next OnQueueReady.
+func (c queueConsumers) consume(queueID uint32, log Log) {
+	consumer, ok := c[queueID]
+	if !ok {
+		log.Warnf("no consumer of the shared queue %d", queueID)
+		return
+	}
+	var retries []queueEnvelope
+	for {
+		data, err := proxywasm.DequeueSharedQueue(queueID)
+		if err == types.ErrorStatusEmpty {
+			break
+		}
+		if err != nil {
+			log.Errorf("dequeue shared queue %s failed: %v", consumer.name, err)
+			break
+		}
+		var envelope queueEnvelope
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			consumer.deadLetter(queueEnvelope{Payload: data}, fmt.Errorf("%w: %v", ErrQueueMessageDecode, err), log)
+			continue
+		}
+		envelope.Attempts++
+		err = consumer.handle(envelope.Payload)
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, ErrQueueMessageDecode) || envelope.Attempts >= consumer.config.MaxAttempts {
+			consumer.deadLetter(envelope, err, log)
+			continue
+		}
+		log.Warnf("handle message of shared queue %s failed, attempt %d: %v", consumer.name, envelope.Attempts, err)
+		retries = append(retries, envelope)
+	}
+	for _, envelope := range retries {
+		if err := enqueueEnvelope(queueID, envelope); err != nil {
+			consumer.deadLetter(envelope, err, log)
+		}
+	}
+}
+
+func (c *queueConsumer) deadLetter(envelope queueEnvelope, err error, log Log) {
+	log.Errorf("message of shared queue %s is dead after %d attempts: %v", c.name, envelope.Attempts, err)
+	letter, _ := json.Marshal(DeadLetter{
+		Queue:    c.name,
+		Attempts: envelope.Attempts,
+		Payload:  envelope.Payload,
+		Error:    err.Error(),
+	})
+	if err := enqueueEnvelopeByName("", c.config.DeadLetterQueue, queueEnvelope{Payload: letter}); err != nil {
+		log.Errorf("message of shared queue %s is dropped, put it on %s failed: %v", c.name, c.config.DeadLetterQueue, err)
+	}
+}
+
+// RegisterDeadLetterConsumer handles the messages moved to the dead-letter queue, e.g. to log or report them.
+func RegisterDeadLetterConsumer(name string, handler func(letter DeadLetter) error) {
+	RegisterQueueConsumerWithConfig(name, QueueConsumerConfig{MaxAttempts: 1}, handler)
+}
+
+// resolvedQueues caches the ids of the shared queues, keyed by the vm id and the name.
+var resolvedQueues = map[[2]string]uint32{}
+
+// EnqueueMessage encodes the message in JSON and puts it on the shared queue registered by RegisterQueueConsumer,
+// in the VM of the plugin.
+func EnqueueMessage(name string, message interface{}) error {
+	return EnqueueMessageWithCodec("", name, message, JSONMessageCodec)
+}
+
+// EnqueueMessageWithCodec puts the message on the shared queue registered in the VM, the VM of the plugin is used
+// if the vmID is empty. The codec must be the one of the consumer.
+func EnqueueMessageWithCodec(vmID, name string, message interface{}, codec MessageCodec) error {
+	payload, err := codec.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("encode queue message failed: %v", err)
+	}
+	return enqueueEnvelopeByName(vmID, name, queueEnvelope{Payload: payload})
+}
+
+func enqueueEnvelopeByName(vmID, name string, envelope queueEnvelope) error {
+	if vmID == "" {
+		id, err := proxywasm.GetProperty([]string{"plugin_vm_id"})
+		if err != nil && err != types.ErrorStatusNotFound {
+			return fmt.Errorf("get vm id failed: %v", err)
+		}
+		vmID = string(id)
+	}
+	key := [2]string{vmID, name}
+	queueID, ok := resolvedQueues[key]
+	if !ok {
+		var err error
+		queueID, err = proxywasm.ResolveSharedQueue(vmID, name)
+		if err != nil {
+			return fmt.Errorf("resolve shared queue %s failed: %v", name, err)
+		}
+		resolvedQueues[key] = queueID
+	}
+	err := enqueueEnvelope(queueID, envelope)
+	if err == types.ErrorStatusNotFound {
+		// the queue is gone with the VM registering it
+		delete(resolvedQueues, key)
+	}
+	return err
+}
+
+func enqueueEnvelope(queueID uint32, envelope queueEnvelope) error {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return proxywasm.EnqueueSharedQueue(queueID, data)
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProto implements ProtoMessage like the generated messages.
+type fakeProto struct {
+	value string
+}
+
+func (m *fakeProto) Marshal() ([]byte, error) {
+	return []byte(m.value), nil
+}
+
+func (m *fakeProto) Unmarshal(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("empty message")
+	}
+	m.value = string(data)
+	return nil
+}
+
+func TestDecodeQueueMessage(t *testing.T) {
+	type event struct {
+		IP string `json:"ip"`
+	}
+	data, err := JSONMessageCodec.Marshal(event{IP: "10.0.0.1"})
+	require.NoError(t, err)
+	decoded, err := decodeQueueMessage[event](JSONMessageCodec, data)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", decoded.IP)
+	_, err = decodeQueueMessage[event](JSONMessageCodec, []byte("{"))
+	assert.ErrorIs(t, err, ErrQueueMessageDecode)
+
+	data, err = ProtoMessageCodec.Marshal(&fakeProto{value: "updated"})
+	require.NoError(t, err)
+	message, err := decodeQueueMessage[*fakeProto](ProtoMessageCodec, data)
+	require.NoError(t, err)
+	assert.Equal(t, "updated", message.value)
+	_, err = decodeQueueMessage[*fakeProto](ProtoMessageCodec, nil)
+	assert.ErrorIs(t, err, ErrQueueMessageDecode)
+	_, err = ProtoMessageCodec.Marshal(event{})
+	assert.Error(t, err)
+}
+
+func TestRegisterQueueConsumerDefaults(t *testing.T) {
+	globalQueueConsumers = nil
+	defer func() { globalQueueConsumers = nil }()
+	RegisterQueueConsumer("events", func(message string) error { return nil })
+	RegisterQueueConsumerWithConfig("events", QueueConsumerConfig{MaxAttempts: 5}, func(message string) error { return nil })
+	RegisterDeadLetterConsumer("events.dlq", func(letter DeadLetter) error { return nil })
+	require.Len(t, globalQueueConsumers, 2)
+	assert.Equal(t, 5, globalQueueConsumers[0].config.MaxAttempts)
+	assert.Equal(t, "events.dlq", globalQueueConsumers[0].config.DeadLetterQueue)
+	assert.Equal(t, JSONMessageCodec, globalQueueConsumers[0].config.Codec)
+	assert.Equal(t, 1, globalQueueConsumers[1].config.MaxAttempts)
+}
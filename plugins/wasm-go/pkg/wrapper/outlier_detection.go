@@ -0,0 +1,189 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+const outlierDetectionKeyPrefix = "outlier-detection:"
+
+// EndpointObserver receives the outcome of each call made to an endpoint.
+type EndpointObserver interface {
+	ReportResponse(endpoint string, statusCode int, latency time.Duration)
+}
+
+type OutlierDetectionConfig struct {
+	// Consecutive failures before the endpoint is ejected, default is 5
+	ConsecutiveFailures int
+	// The ejection time is BaseEjectionTime multiplied by the times the endpoint has been ejected, default is 30000ms
+	BaseEjectionTime int64
+	// The upper bound of the ejection time, default is 300000ms
+	MaxEjectionTime int64
+	// Calls slower than this are counted as failures, 0 means latency is not considered
+	SlowThreshold int64
+	// At most this percentage of the known endpoints can be ejected at the same time, default is 50
+	MaxEjectionPercent int
+}
+
+func ParseOutlierDetectionConfig(json gjson.Result) OutlierDetectionConfig {
+	return OutlierDetectionConfig{
+		ConsecutiveFailures: int(json.Get("consecutiveFailures").Int()),
+		BaseEjectionTime:    json.Get("baseEjectionTime").Int(),
+		MaxEjectionTime:     json.Get("maxEjectionTime").Int(),
+		SlowThreshold:       json.Get("slowThreshold").Int(),
+		MaxEjectionPercent:  int(json.Get("maxEjectionPercent").Int()),
+	}
+}
+
+type EndpointStats struct {
+	Successes           int64 `json:"successes"`
+	Failures            int64 `json:"failures"`
+	ConsecutiveFailures int   `json:"consecutiveFailures"`
+	// Exponential moving average of the latency in milliseconds
+	AvgLatency    int64 `json:"avgLatency"`
+	EjectionCount int   `json:"ejectionCount"`
+	EjectedUntil  int64 `json:"ejectedUntil"`
+}
+
+func (s *EndpointStats) ejected(now int64) bool {
+	return s.EjectedUntil > now
+}
+
+// OutlierDetector aggregates the observations reported by all VMs in shared data,
+// endpoints failing consecutively are ejected for a growing period of time.
+type OutlierDetector struct {
+	key    string
+	config OutlierDetectionConfig
+}
+
+func NewOutlierDetector(name string, config OutlierDetectionConfig) *OutlierDetector {
+	if config.ConsecutiveFailures <= 0 {
+		config.ConsecutiveFailures = 5
+	}
+	if config.BaseEjectionTime <= 0 {
+		config.BaseEjectionTime = 30000
+	}
+	if config.MaxEjectionTime <= 0 {
+		config.MaxEjectionTime = 300000
+	}
+	if config.MaxEjectionPercent <= 0 {
+		config.MaxEjectionPercent = 50
+	}
+	return &OutlierDetector{key: outlierDetectionKeyPrefix + name, config: config}
+}
+
+// Report records the outcome of a call to the endpoint.
+func (d *OutlierDetector) Report(endpoint string, success bool, latency time.Duration) {
+	now := time.Now().UnixMilli()
+	err := UpdateSharedDataJson(d.key, func(stats *map[string]*EndpointStats) bool {
+		if *stats == nil {
+			*stats = map[string]*EndpointStats{}
+		}
+		if d.observe(*stats, endpoint, success, latency.Milliseconds(), now) {
+			proxywasm.LogInfof("endpoint %s is ejected until %d", endpoint, (*stats)[endpoint].EjectedUntil)
+		}
+		return true
+	})
+	if err != nil {
+		proxywasm.LogErrorf("failed to report outlier detection result of %s: %v", endpoint, err)
+	}
+}
+
+// ReportResponse treats 5xx and failed calls (status 0) as failures.
+func (d *OutlierDetector) ReportResponse(endpoint string, statusCode int, latency time.Duration) {
+	d.Report(endpoint, statusCode > 0 && statusCode < 500, latency)
+}
+
+func (d *OutlierDetector) IsHealthy(endpoint string) bool {
+	stats, ok := d.GetStats(endpoint)
+	return !ok || !stats.ejected(time.Now().UnixMilli())
+}
+
+func (d *OutlierDetector) GetStats(endpoint string) (EndpointStats, bool) {
+	var stats map[string]*EndpointStats
+	if _, err := GetSharedDataJson(d.key, &stats); err != nil {
+		proxywasm.LogErrorf("failed to get outlier detection stats: %v", err)
+		return EndpointStats{}, false
+	}
+	if s, ok := stats[endpoint]; ok {
+		return *s, true
+	}
+	return EndpointStats{}, false
+}
+
+// EjectedEndpoints returns the endpoints which are currently ejected.
+func (d *OutlierDetector) EjectedEndpoints() []string {
+	var stats map[string]*EndpointStats
+	if _, err := GetSharedDataJson(d.key, &stats); err != nil {
+		proxywasm.LogErrorf("failed to get outlier detection stats: %v", err)
+		return nil
+	}
+	now := time.Now().UnixMilli()
+	var ejected []string
+	for endpoint, s := range stats {
+		if s.ejected(now) {
+			ejected = append(ejected, endpoint)
+		}
+	}
+	return ejected
+}
+
+// observe applies one observation and returns true if the endpoint gets ejected by it.
+func (d *OutlierDetector) observe(stats map[string]*EndpointStats, endpoint string, success bool, latency, now int64) bool {
+	s, ok := stats[endpoint]
+	if !ok {
+		s = &EndpointStats{}
+		stats[endpoint] = s
+	}
+	if s.AvgLatency == 0 {
+		s.AvgLatency = latency
+	} else {
+		s.AvgLatency = (s.AvgLatency*7 + latency) / 8
+	}
+	if d.config.SlowThreshold > 0 && latency > d.config.SlowThreshold {
+		success = false
+	}
+	if success {
+		s.Successes++
+		s.ConsecutiveFailures = 0
+		return false
+	}
+	s.Failures++
+	s.ConsecutiveFailures++
+	if s.ejected(now) || s.ConsecutiveFailures < d.config.ConsecutiveFailures {
+		return false
+	}
+	ejectedCount := 0
+	for _, other := range stats {
+		if other.ejected(now) {
+			ejectedCount++
+		}
+	}
+	if (ejectedCount+1)*100 > len(stats)*d.config.MaxEjectionPercent {
+		return false
+	}
+	s.EjectionCount++
+	ejectionTime := d.config.BaseEjectionTime * int64(s.EjectionCount)
+	if ejectionTime > d.config.MaxEjectionTime {
+		ejectionTime = d.config.MaxEjectionTime
+	}
+	s.EjectedUntil = now + ejectionTime
+	s.ConsecutiveFailures = 0
+	return true
+}
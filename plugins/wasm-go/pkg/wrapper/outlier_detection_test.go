@@ -0,0 +1,57 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutlierDetectorObserve(t *testing.T) {
+	d := NewOutlierDetector("test", OutlierDetectionConfig{
+		ConsecutiveFailures: 2,
+		BaseEjectionTime:    1000,
+		MaxEjectionTime:     1500,
+		SlowThreshold:       100,
+	})
+	stats := map[string]*EndpointStats{"b": {}}
+	var now int64 = 10000
+
+	assert.False(t, d.observe(stats, "a", false, 10, now))
+	assert.True(t, d.observe(stats, "a", false, 10, now))
+	assert.Equal(t, now+1000, stats["a"].EjectedUntil)
+
+	// slow calls are failures, the second ejection lasts longer but is capped
+	now += 2000
+	assert.False(t, d.observe(stats, "a", true, 200, now))
+	assert.True(t, d.observe(stats, "a", true, 200, now))
+	assert.Equal(t, now+1500, stats["a"].EjectedUntil)
+
+	// b can not be ejected since half of the endpoints are ejected already
+	assert.False(t, d.observe(stats, "b", false, 10, now))
+	assert.False(t, d.observe(stats, "b", false, 10, now))
+	assert.Equal(t, int64(0), stats["b"].EjectedUntil)
+}
+
+func TestOutlierDetectorSuccessResets(t *testing.T) {
+	d := NewOutlierDetector("test", OutlierDetectionConfig{ConsecutiveFailures: 2})
+	stats := map[string]*EndpointStats{"a": {}, "b": {}, "c": {}}
+	assert.False(t, d.observe(stats, "a", false, 10, 0))
+	assert.False(t, d.observe(stats, "a", true, 10, 0))
+	assert.False(t, d.observe(stats, "a", false, 10, 0))
+	assert.Equal(t, int64(2), stats["a"].Failures)
+	assert.Equal(t, int64(1), stats["a"].Successes)
+}
@@ -16,6 +16,7 @@ package wrapper
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
@@ -60,14 +61,40 @@ type HttpContext interface {
 	// If the onHttpStreamingResponseBody handle is not set, and the onHttpResponseBody handle is set, the response body will be buffered by default
 	BufferResponseBody()
 	// If any request header is changed in onHttpRequestHeaders, envoy will re-calculate the route. Call this function to disable the re-routing.
-	// You need to call this before making any header modification operations.
+	// You need to call this before making any header modification operations. HostCapability(CapabilityClearRouteCache) tells whether
+	// the host supports it, a host not supporting it doesn't re-calculate the route for the header changes either.
 	DisableReroute()
 	// Note that this parameter affects the gateway's memory usage！Support setting a maximum buffer size for each request body individually in request phase.
+	// If HostCapability(CapabilityDecoderBufferLimit) is unsupported, the wrapper enforces the limit instead by replying 413 to the
+	// larger request bodies, but it can't raise the limit of the host.
 	SetRequestBodyBufferLimit(size uint32)
 	// Note that this parameter affects the gateway's memory usage! Support setting a maximum buffer size for each response body individually in response phase.
+	// If HostCapability(CapabilityEncoderBufferLimit) is unsupported, the wrapper enforces the limit instead by replying 500 to the
+	// larger response bodies as envoy does, which only works before the response headers are sent.
 	SetResponseBodyBufferLimit(size uint32)
 }
 
+// httpContextExtension is implemented by CommonHttpCtx for the package helpers taking HttpContext, e.g.
+// GetAuthenticatedConsumer, so HttpContext doesn't grow with every feature and its other implementations, like the
+// mocks in the plugin tests, keep compiling. The helpers fall back to the zero values for the other implementations.
+type httpContextExtension interface {
+	setAuthenticatedConsumer(consumer *Consumer)
+	getAuthenticatedConsumer() *Consumer
+	geoInfo() *GeoInfo
+	fingerprint() Fingerprint
+	ensureRequestID() string
+	streamingTokenCounter() *StreamingTokenCounter
+	templateSources() TemplateSources
+	usageRecord() *UsageRecord
+	debugDecision(format string, args ...interface{})
+	deadlineOf() (time.Time, bool)
+	replaceRequestBody(body []byte) error
+	replaceResponseBody(body []byte) error
+	isWebSocket() bool
+	fail(err error) types.Action
+	flagEnabled(name string) bool
+}
+
 type ParseConfigFunc[PluginConfig any] func(json gjson.Result, config *PluginConfig, log Log) error
 type ParseRuleConfigFunc[PluginConfig any] func(json gjson.Result, global PluginConfig, config *PluginConfig, log Log) error
 type onHttpHeadersFunc[PluginConfig any] func(context HttpContext, config PluginConfig, log Log) types.Action
@@ -89,6 +116,7 @@ type CommonVmCtx[PluginConfig any] struct {
 	onHttpResponseBody          onHttpBodyFunc[PluginConfig]
 	onHttpStreamingResponseBody onHttpStreamingBodyFunc[PluginConfig]
 	onHttpStreamDone            onHttpStreamDoneFunc[PluginConfig]
+	holdBodyHeaders             bool
 }
 
 type TickFuncEntry struct {
@@ -236,6 +264,19 @@ func ProcessStreamDoneBy[PluginConfig any](f onHttpStreamDoneFunc[PluginConfig])
 	return &onProcessStreamDoneOption[PluginConfig]{f}
 }
 
+type holdBodyHeadersOption[PluginConfig any] struct{}
+
+func (o holdBodyHeadersOption[PluginConfig]) Apply(ctx *CommonVmCtx[PluginConfig]) {
+	ctx.holdBodyHeaders = true
+}
+
+// HoldHeadersForBodyReplacement makes the wrapper hold the headers carrying the content-length or a digest until the
+// buffered body is processed, so ReplaceRequestBody and ReplaceResponseBody can fix them. It delays the headers of
+// every request with a body, so only use it if the plugin replaces the body.
+func HoldHeadersForBodyReplacement[PluginConfig any]() CtxOption[PluginConfig] {
+	return holdBodyHeadersOption[PluginConfig]{}
+}
+
 type logOption[PluginConfig any] struct {
 	logger Log
 }
@@ -288,13 +329,26 @@ func (ctx *CommonVmCtx[PluginConfig]) NewPluginContext(uint32) types.PluginConte
 type CommonPluginCtx[PluginConfig any] struct {
 	types.DefaultPluginContext
 	matcher.RuleMatcher[PluginConfig]
-	vm          *CommonVmCtx[PluginConfig]
-	onTickFuncs []TickFuncEntry
+	vm             *CommonVmCtx[PluginConfig]
+	onTickFuncs    []TickFuncEntry
+	queueConsumers queueConsumers
+	debug          *debugDumpConfig
+	debugRules     *debugRules
+	headers        *headerTransforms
+	bufferLimits   *bodyBufferLimits
+	errors         *errorPolicy
+	errorCounters  map[string]proxywasm.MetricCounter
+	flags          FeatureFlags
+	deadlines      *routeDeadlines
 }
 
 func (ctx *CommonPluginCtx[PluginConfig]) OnPluginStart(int) types.OnPluginStartStatus {
 	data, err := proxywasm.GetPluginConfiguration()
+	// the capabilities are learned again on every configuration
+	hostCapabilities = map[Capability]CapabilityState{}
+	ProbeHostCapabilities()
 	globalOnTickFuncs = nil
+	globalQueueConsumers = nil
 	if err != nil && err != types.ErrorStatusNotFound {
 		ctx.vm.log.Criticalf("error reading plugin configuration: %v", err)
 		return types.OnPluginStartStatusFailed
@@ -313,15 +367,20 @@ func (ctx *CommonPluginCtx[PluginConfig]) OnPluginStart(int) types.OnPluginStart
 		jsonData = gjson.ParseBytes(data)
 	}
 
+	deadlines := &routeDeadlines{}
 	var parseOverrideConfig func(gjson.Result, PluginConfig, *PluginConfig) error
 	if ctx.vm.parseRuleConfig != nil {
 		parseOverrideConfig = func(js gjson.Result, global PluginConfig, cfg *PluginConfig) error {
-			return ctx.vm.parseRuleConfig(js, global, cfg, ctx.vm.log)
+			return deadlines.parse(js, true, func() error {
+				return ctx.vm.parseRuleConfig(js, global, cfg, ctx.vm.log)
+			})
 		}
 	}
 	err = ctx.ParseRuleConfig(jsonData,
 		func(js gjson.Result, cfg *PluginConfig) error {
-			return ctx.vm.parseConfig(js, cfg, ctx.vm.log)
+			return deadlines.parse(js, false, func() error {
+				return ctx.vm.parseConfig(js, cfg, ctx.vm.log)
+			})
 		},
 		parseOverrideConfig,
 	)
@@ -329,6 +388,40 @@ func (ctx *CommonPluginCtx[PluginConfig]) OnPluginStart(int) types.OnPluginStart
 		ctx.vm.log.Warnf("parse rule config failed: %v", err)
 		return types.OnPluginStartStatusFailed
 	}
+	ctx.deadlines = deadlines
+	ctx.debug, ctx.debugRules = nil, nil
+	if debugJson := jsonData.Get(matcher.DEBUG_KEY); debugJson.Exists() {
+		ctx.debug, err = parseDebugDumpConfig(debugJson)
+		if err != nil {
+			ctx.vm.log.Warnf("parse debug config failed: %v", err)
+			return types.OnPluginStartStatusFailed
+		}
+		ctx.debugRules = newDebugRules(jsonData)
+	}
+	ctx.headers, err = parseHeaderTransforms(jsonData)
+	if err != nil {
+		ctx.vm.log.Warnf("parse header transformation failed: %v", err)
+		return types.OnPluginStartStatusFailed
+	}
+	ctx.bufferLimits, err = parseBodyBufferLimits(jsonData)
+	if err != nil {
+		ctx.vm.log.Warnf("parse body buffer limit failed: %v", err)
+		return types.OnPluginStartStatusFailed
+	}
+	ctx.errors, err = parseErrorPolicy(jsonData.Get(matcher.ERRORS_KEY))
+	if err != nil {
+		ctx.vm.log.Warnf("parse error policy failed: %v", err)
+		return types.OnPluginStartStatusFailed
+	}
+	ctx.flags = nil
+	if flagsJson := jsonData.Get(matcher.FLAGS_KEY); flagsJson.Exists() {
+		flags, err := ParseFeatureFlags(flagsJson)
+		if err != nil {
+			ctx.vm.log.Warnf("parse feature flags failed: %v", err)
+			return types.OnPluginStartStatusFailed
+		}
+		ctx.flags = flags
+	}
 	if globalOnTickFuncs != nil {
 		ctx.onTickFuncs = globalOnTickFuncs
 		if err := proxywasm.SetTickPeriodMilliSeconds(100); err != nil {
@@ -336,9 +429,20 @@ func (ctx *CommonPluginCtx[PluginConfig]) OnPluginStart(int) types.OnPluginStart
 			return types.OnPluginStartStatusFailed
 		}
 	}
+	if globalQueueConsumers != nil {
+		ctx.queueConsumers, err = registerQueueConsumers(globalQueueConsumers)
+		if err != nil {
+			ctx.vm.log.Errorf("%v, queue consumers will not take effect.", err)
+			return types.OnPluginStartStatusFailed
+		}
+	}
 	return types.OnPluginStartStatusOK
 }
 
+func (ctx *CommonPluginCtx[PluginConfig]) OnQueueReady(queueID uint32) {
+	ctx.queueConsumers.consume(queueID, ctx.vm.log)
+}
+
 func (ctx *CommonPluginCtx[PluginConfig]) OnTick() {
 	for i := range ctx.onTickFuncs {
 		currentTimeStamp := time.Now().UnixMilli()
@@ -385,6 +489,18 @@ type CommonHttpCtx[PluginConfig any] struct {
 	contextID             uint32
 	userContext           map[string]interface{}
 	userAttribute         map[string]interface{}
+	debug                 *DebugDump
+	deadline              time.Time
+	headerTransform       *HeaderTransform
+	bufferLimit           bodyBufferLimit
+	// the limits enforced by the wrapper for the hosts not supporting the buffer limit properties, 0 if not enforced
+	requestBodyLimit      uint32
+	responseBodyLimit     uint32
+	requestBodyForwarded  int
+	responseBodyForwarded int
+	requestHeadersHeld    bool
+	responseHeadersHeld   bool
+	webSocket             bool
 }
 
 func (ctx *CommonHttpCtx[PluginConfig]) SetContext(key string, value interface{}) {
@@ -424,6 +540,9 @@ func (ctx *CommonHttpCtx[PluginConfig]) WriteUserAttributeToLogWithKey(key strin
 	for k, v := range ctx.userAttribute {
 		newAttributeMap[k] = v
 	}
+	if LogScrubber != nil {
+		LogScrubber.ScrubAttributes(newAttributeMap)
+	}
 	// e.g. {"field1":"value1","field2":2,"field3":"value3"}
 	jsonStr, _ := json.Marshal(newAttributeMap)
 	// e.g. {\"field1\":\"value1\",\"field2\":2,\"field3\":\"value3\"}
@@ -503,51 +622,98 @@ func (ctx *CommonHttpCtx[PluginConfig]) BufferResponseBody() {
 }
 
 func (ctx *CommonHttpCtx[PluginConfig]) DisableReroute() {
-	_ = proxywasm.SetProperty([]string{"clear_route_cache"}, []byte("off"))
+	_ = setCapabilityProperty(CapabilityClearRouteCache, []byte("off"), ctx.plugin.vm.log)
 }
 
 func (ctx *CommonHttpCtx[PluginConfig]) SetRequestBodyBufferLimit(size uint32) {
 	ctx.plugin.vm.log.Infof("SetRequestBodyBufferLimit: %d", size)
-	_ = proxywasm.SetProperty([]string{"set_decoder_buffer_limit"}, []byte(strconv.Itoa(int(size))))
+	err := setCapabilityProperty(CapabilityDecoderBufferLimit, []byte(strconv.Itoa(int(size))), ctx.plugin.vm.log)
+	if errors.Is(err, ErrCapabilityUnsupported) {
+		ctx.requestBodyLimit = size
+	}
 }
 
 func (ctx *CommonHttpCtx[PluginConfig]) SetResponseBodyBufferLimit(size uint32) {
 	ctx.plugin.vm.log.Infof("SetResponseBodyBufferLimit: %d", size)
-	_ = proxywasm.SetProperty([]string{"set_encoder_buffer_limit"}, []byte(strconv.Itoa(int(size))))
+	err := setCapabilityProperty(CapabilityEncoderBufferLimit, []byte(strconv.Itoa(int(size))), ctx.plugin.vm.log)
+	if errors.Is(err, ErrCapabilityUnsupported) {
+		ctx.responseBodyLimit = size
+	}
 }
 
 func (ctx *CommonHttpCtx[PluginConfig]) OnHttpRequestHeaders(numHeaders int, endOfStream bool) types.Action {
 	requestID, _ := proxywasm.GetHttpRequestHeader("x-request-id")
 	_ = proxywasm.SetProperty([]string{"x_request_id"}, []byte(requestID))
-	config, err := ctx.plugin.GetMatchConfig()
+	ctx.webSocket = IsWebSocketUpgrade()
+	if ctx.plugin.debug != nil && ctx.plugin.debug.enabled() {
+		ctx.debug = &DebugDump{Plugin: ctx.plugin.vm.pluginName, Phases: []DebugPhase{}, start: time.Now()}
+	}
+	config, ruleIndex, err := ctx.plugin.GetMatchRule()
 	if err != nil {
 		ctx.plugin.vm.log.Errorf("get match config failed, err:%v", err)
+		DebugDecision(ctx, "get match config failed: %v", err)
 		return types.ActionContinue
 	}
+	// the global _headers_ and buffer limits take effect even if no rule is matched
+	if ctx.plugin.bufferLimits != nil {
+		ctx.bufferLimit = ctx.plugin.bufferLimits.rule(ruleIndex)
+		if ctx.bufferLimit.request > 0 {
+			ctx.SetRequestBodyBufferLimit(ctx.bufferLimit.request)
+		}
+	}
+	if ctx.plugin.headers != nil {
+		ctx.headerTransform = ctx.plugin.headers.rule(ruleIndex)
+		ctx.transformRequestHeaders()
+	}
 	if config == nil {
-		return types.ActionContinue
+		DebugDecision(ctx, "no rule is matched")
+		return ctx.checkRequestBodyLimit(types.ActionContinue)
 	}
 	ctx.config = config
+	if ctx.debug != nil {
+		ctx.debug.Rule = ctx.plugin.debugRules.rule(ruleIndex)
+	}
+	if ctx.webSocket && webSocketInspected() {
+		PrepareWebSocketInspection()
+	}
+	ctx.initDeadline(ruleIndex)
 	// To avoid unexpected operations, plugins do not read the binary content body
 	if IsBinaryRequestBody() {
 		ctx.needRequestBody = false
 	}
 	if ctx.plugin.vm.onHttpRequestHeaders == nil {
-		return types.ActionContinue
+		return ctx.holdRequestHeaders(ctx.checkRequestBodyLimit(types.ActionContinue), endOfStream)
 	}
-	return ctx.plugin.vm.onHttpRequestHeaders(ctx, *config, ctx.plugin.vm.log)
+	defer ctx.enterDeadline()()
+	start := ctx.debugStart()
+	action := ctx.debugAction("request-headers", start, ctx.plugin.vm.onHttpRequestHeaders(ctx, *config, ctx.plugin.vm.log))
+	return ctx.holdRequestHeaders(ctx.checkRequestBodyLimit(action), endOfStream)
 }
 
-func (ctx *CommonHttpCtx[PluginConfig]) OnHttpRequestBody(bodySize int, endOfStream bool) types.Action {
+func (ctx *CommonHttpCtx[PluginConfig]) OnHttpRequestBody(bodySize int, endOfStream bool) (action types.Action) {
+	if ctx.requestBodyLimit > 0 {
+		if ctx.requestBodyForwarded+bodySize > int(ctx.requestBodyLimit) {
+			return ctx.rejectRequestBody()
+		}
+		// the size is of the body buffered so far if the last chunk is paused
+		defer func() {
+			if action == types.ActionContinue {
+				ctx.requestBodyForwarded += bodySize
+			}
+		}()
+	}
 	if ctx.config == nil {
 		return types.ActionContinue
 	}
 	if !ctx.needRequestBody {
 		return types.ActionContinue
 	}
+	defer ctx.enterDeadline()()
 	if ctx.plugin.vm.onHttpStreamingRequestBody != nil && ctx.streamingRequestBody {
 		chunk, _ := proxywasm.GetHttpRequestBody(0, bodySize)
+		start := ctx.debugStart()
 		modifiedChunk := ctx.plugin.vm.onHttpStreamingRequestBody(ctx, *ctx.config, chunk, endOfStream, ctx.plugin.vm.log)
+		ctx.debugAction("request-body", start, types.ActionContinue)
 		err := proxywasm.ReplaceHttpRequestBody(modifiedChunk)
 		if err != nil {
 			ctx.plugin.vm.log.Warnf("replace request body chunk failed: %v", err)
@@ -565,26 +731,52 @@ func (ctx *CommonHttpCtx[PluginConfig]) OnHttpRequestBody(bodySize int, endOfStr
 			ctx.plugin.vm.log.Warnf("get request body failed: %v", err)
 			return types.ActionContinue
 		}
-		return ctx.plugin.vm.onHttpRequestBody(ctx, *ctx.config, body, ctx.plugin.vm.log)
+		start := ctx.debugStart()
+		action := ctx.debugAction("request-body", start, ctx.plugin.vm.onHttpRequestBody(ctx, *ctx.config, body, ctx.plugin.vm.log))
+		if action == types.ActionContinue {
+			// the held headers are sent with the body
+			ctx.requestHeadersHeld = false
+		}
+		return action
 	}
 	return types.ActionContinue
 }
 
 func (ctx *CommonHttpCtx[PluginConfig]) OnHttpResponseHeaders(numHeaders int, endOfStream bool) types.Action {
+	ctx.transformResponseHeaders()
+	if ctx.bufferLimit.response > 0 {
+		ctx.SetResponseBodyBufferLimit(ctx.bufferLimit.response)
+	}
 	if ctx.config == nil {
-		return types.ActionContinue
+		ctx.addDebugDumpHeader()
+		return ctx.checkResponseBodyLimit(types.ActionContinue)
 	}
 	// To avoid unexpected operations, plugins do not read the binary content body
 	if IsBinaryResponseBody() {
 		ctx.needResponseBody = false
 	}
 	if ctx.plugin.vm.onHttpResponseHeaders == nil {
-		return types.ActionContinue
+		ctx.addDebugDumpHeader()
+		return ctx.holdResponseHeaders(ctx.checkResponseBodyLimit(types.ActionContinue), endOfStream)
 	}
-	return ctx.plugin.vm.onHttpResponseHeaders(ctx, *ctx.config, ctx.plugin.vm.log)
+	start := ctx.debugStart()
+	action := ctx.debugAction("response-headers", start, ctx.plugin.vm.onHttpResponseHeaders(ctx, *ctx.config, ctx.plugin.vm.log))
+	ctx.addDebugDumpHeader()
+	return ctx.holdResponseHeaders(ctx.checkResponseBodyLimit(action), endOfStream)
 }
 
-func (ctx *CommonHttpCtx[PluginConfig]) OnHttpResponseBody(bodySize int, endOfStream bool) types.Action {
+func (ctx *CommonHttpCtx[PluginConfig]) OnHttpResponseBody(bodySize int, endOfStream bool) (action types.Action) {
+	if ctx.responseBodyLimit > 0 {
+		if ctx.responseBodyForwarded+bodySize > int(ctx.responseBodyLimit) {
+			return ctx.rejectResponseBody()
+		}
+		// the size is of the body buffered so far if the last chunk is paused
+		defer func() {
+			if action == types.ActionContinue {
+				ctx.responseBodyForwarded += bodySize
+			}
+		}()
+	}
 	if ctx.config == nil {
 		return types.ActionContinue
 	}
@@ -593,7 +785,9 @@ func (ctx *CommonHttpCtx[PluginConfig]) OnHttpResponseBody(bodySize int, endOfSt
 	}
 	if ctx.plugin.vm.onHttpStreamingResponseBody != nil && ctx.streamingResponseBody {
 		chunk, _ := proxywasm.GetHttpResponseBody(0, bodySize)
+		start := ctx.debugStart()
 		modifiedChunk := ctx.plugin.vm.onHttpStreamingResponseBody(ctx, *ctx.config, chunk, endOfStream, ctx.plugin.vm.log)
+		ctx.debugAction("response-body", start, types.ActionContinue)
 		err := proxywasm.ReplaceHttpResponseBody(modifiedChunk)
 		if err != nil {
 			ctx.plugin.vm.log.Warnf("replace response body chunk failed: %v", err)
@@ -611,17 +805,28 @@ func (ctx *CommonHttpCtx[PluginConfig]) OnHttpResponseBody(bodySize int, endOfSt
 			ctx.plugin.vm.log.Warnf("get response body failed: %v", err)
 			return types.ActionContinue
 		}
-		return ctx.plugin.vm.onHttpResponseBody(ctx, *ctx.config, body, ctx.plugin.vm.log)
+		start := ctx.debugStart()
+		action := ctx.debugAction("response-body", start, ctx.plugin.vm.onHttpResponseBody(ctx, *ctx.config, body, ctx.plugin.vm.log))
+		if action == types.ActionContinue {
+			// the held headers are sent with the body
+			ctx.responseHeadersHeld = false
+		}
+		return action
 	}
 	return types.ActionContinue
 }
 
 func (ctx *CommonHttpCtx[PluginConfig]) OnHttpStreamDone() {
+	defer ctx.logDebugDump()
 	if ctx.config == nil {
 		return
 	}
 	if ctx.plugin.vm.onHttpStreamDone == nil {
 		return
 	}
+	start := ctx.debugStart()
 	ctx.plugin.vm.onHttpStreamDone(ctx, *ctx.config, ctx.plugin.vm.log)
+	if ctx.debug != nil {
+		ctx.debug.addPhase("stream-done", "", start)
+	}
 }
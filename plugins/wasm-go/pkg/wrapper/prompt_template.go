@@ -0,0 +1,283 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	TemplateSourceHeader   = "header"
+	TemplateSourceQuery    = "query"
+	TemplateSourceConsumer = "consumer"
+	TemplateSourceConfig   = "config"
+)
+
+type TemplateEscape string
+
+const (
+	// Remove the control characters and join the lines, so a value can't start a new line of instructions
+	TemplateEscapeText TemplateEscape = "text"
+	// Escape the value to be embedded in a json string
+	TemplateEscapeJSON TemplateEscape = "json"
+	// Escape the value to be embedded in html
+	TemplateEscapeHTML TemplateEscape = "html"
+	// Insert the value as it is, only for the trusted sources like config
+	TemplateEscapeNone TemplateEscape = "none"
+)
+
+type PromptTemplateOptions struct {
+	// The escaping of the values, default is text
+	Escape TemplateEscape
+	// The values longer than the runes are truncated, default is 1024
+	MaxValueLength int
+	// Rendering fails if the output is longer than the bytes, default is 65536
+	MaxOutputLength int
+	// Rendering fails if a variable without default is missing, otherwise it's rendered as empty
+	Strict bool
+}
+
+func (o *PromptTemplateOptions) setDefaults() {
+	if o.Escape == "" {
+		o.Escape = TemplateEscapeText
+	}
+	if o.MaxValueLength <= 0 {
+		o.MaxValueLength = 1024
+	}
+	if o.MaxOutputLength <= 0 {
+		o.MaxOutputLength = 65536
+	}
+}
+
+type templateSegment struct {
+	literal string
+	// The variable is source.name, the segment is a literal if the source is empty
+	source       string
+	name         string
+	defaultValue string
+	hasDefault   bool
+}
+
+// PromptTemplate renders the text with the variables like {{header.x-user-lang}} or {{query.lang | en}},
+// the part after | is the default value. The template is parsed once and the values are never evaluated as
+// templates, so the user input can't inject variables.
+type PromptTemplate struct {
+	segments []templateSegment
+	options  PromptTemplateOptions
+}
+
+// TemplateSource looks up the value of a variable by the name.
+type TemplateSource func(name string) (string, bool)
+
+// TemplateSources are the sources of the variables keyed by the source name.
+type TemplateSources map[string]TemplateSource
+
+func ParsePromptTemplate(text string, options PromptTemplateOptions) (*PromptTemplate, error) {
+	options.setDefaults()
+	switch options.Escape {
+	case TemplateEscapeText, TemplateEscapeJSON, TemplateEscapeHTML, TemplateEscapeNone:
+	default:
+		return nil, fmt.Errorf("invalid template escape: %s", options.Escape)
+	}
+	t := &PromptTemplate{options: options}
+	for {
+		start := strings.Index(text, "{{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(text[start:], "}}")
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated variable at: %s", text[start:])
+		}
+		if start > 0 {
+			t.segments = append(t.segments, templateSegment{literal: text[:start]})
+		}
+		segment, err := parseTemplateVariable(text[start+2 : start+end])
+		if err != nil {
+			return nil, err
+		}
+		t.segments = append(t.segments, segment)
+		text = text[start+end+2:]
+	}
+	if text != "" {
+		t.segments = append(t.segments, templateSegment{literal: text})
+	}
+	return t, nil
+}
+
+func parseTemplateVariable(expr string) (templateSegment, error) {
+	var segment templateSegment
+	variable := expr
+	if i := strings.Index(expr, "|"); i >= 0 {
+		variable = expr[:i]
+		segment.defaultValue = strings.TrimSpace(expr[i+1:])
+		segment.hasDefault = true
+	}
+	variable = strings.TrimSpace(variable)
+	i := strings.Index(variable, ".")
+	if i <= 0 || i == len(variable)-1 {
+		return segment, fmt.Errorf("invalid variable {{%s}}, it should be like {{source.name}}", expr)
+	}
+	segment.source, segment.name = variable[:i], variable[i+1:]
+	return segment, nil
+}
+
+// Variables returns the variables referenced by the template as source.name.
+func (t *PromptTemplate) Variables() []string {
+	var variables []string
+	for _, segment := range t.segments {
+		if segment.source != "" {
+			variables = append(variables, segment.source+"."+segment.name)
+		}
+	}
+	return variables
+}
+
+func (t *PromptTemplate) Render(sources TemplateSources) (string, error) {
+	var buf strings.Builder
+	for _, segment := range t.segments {
+		if segment.source == "" {
+			buf.WriteString(segment.literal)
+		} else {
+			value, ok := "", false
+			if source := sources[segment.source]; source != nil {
+				value, ok = source(segment.name)
+			}
+			if !ok {
+				if !segment.hasDefault && t.options.Strict {
+					return "", fmt.Errorf("template variable %s.%s is missing", segment.source, segment.name)
+				}
+				// the default is a part of the template, so it's trusted
+				value = segment.defaultValue
+			} else {
+				value = t.escape(truncateRunes(value, t.options.MaxValueLength))
+			}
+			buf.WriteString(value)
+		}
+		if buf.Len() > t.options.MaxOutputLength {
+			return "", fmt.Errorf("rendered template exceeds %d bytes", t.options.MaxOutputLength)
+		}
+	}
+	return buf.String(), nil
+}
+
+func (t *PromptTemplate) escape(value string) string {
+	switch t.options.Escape {
+	case TemplateEscapeJSON:
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		_ = encoder.Encode(value)
+		// strip the quotes and the trailing newline added by the encoder
+		encoded := bytes.TrimSpace(buf.Bytes())
+		return string(encoded[1 : len(encoded)-1])
+	case TemplateEscapeHTML:
+		return html.EscapeString(value)
+	case TemplateEscapeText:
+		value = strings.Map(func(r rune) rune {
+			if r == '\n' || r == '\r' || r == '\t' {
+				return ' '
+			}
+			if unicode.IsControl(r) {
+				return -1
+			}
+			return r
+		}, value)
+		return strings.Join(strings.Fields(value), " ")
+	}
+	return value
+}
+
+func truncateRunes(value string, limit int) string {
+	if len(value) <= limit {
+		return value
+	}
+	count := 0
+	for i := range value {
+		if count == limit {
+			return value[:i]
+		}
+		count++
+	}
+	return value
+}
+
+// MapTemplateSource looks up the variables in the map.
+func MapTemplateSource(values map[string]string) TemplateSource {
+	return func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	}
+}
+
+// JsonTemplateSource looks up the variables by the gjson path, e.g. the plugin config.
+func JsonTemplateSource(json gjson.Result) TemplateSource {
+	return func(name string) (string, bool) {
+		value := json.Get(name)
+		return value.String(), value.Exists()
+	}
+}
+
+// GetTemplateSources returns the header, query and consumer sources of the request. The consumer source has the
+// name and the metadata of the authenticated consumer. The config source should be added by the plugin.
+func GetTemplateSources(ctx HttpContext) TemplateSources {
+	if c, ok := ctx.(httpContextExtension); ok {
+		return c.templateSources()
+	}
+	return TemplateSources{}
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) templateSources() TemplateSources {
+	return TemplateSources{
+		TemplateSourceHeader: func(name string) (string, bool) {
+			proxywasm.SetEffectiveContext(ctx.contextID)
+			value, err := proxywasm.GetHttpRequestHeader(name)
+			return value, err == nil
+		},
+		TemplateSourceQuery: func(name string) (string, bool) {
+			path := ctx.Path()
+			i := strings.Index(path, "?")
+			if i < 0 {
+				return "", false
+			}
+			query, _ := url.ParseQuery(path[i+1:])
+			values, ok := query[name]
+			if !ok || len(values) == 0 {
+				return "", false
+			}
+			return values[0], true
+		},
+		TemplateSourceConsumer: func(name string) (string, bool) {
+			consumer := GetAuthenticatedConsumer(ctx)
+			if consumer == nil {
+				return "", false
+			}
+			if name == "name" {
+				return consumer.Name, true
+			}
+			value, ok := consumer.Metadata[name]
+			return value, ok
+		},
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+type QuotaPeriod string
+
+const (
+	QuotaPeriodDaily   QuotaPeriod = "daily"
+	QuotaPeriodMonthly QuotaPeriod = "monthly"
+	// The counter never resets
+	QuotaPeriodTotal QuotaPeriod = "total"
+)
+
+// Increase the counter and set its expiration atomically, the expiration is skipped when ARGV[2] is 0
+const quotaIncrScript = `
+local used = redis.call('incrby', KEYS[1], ARGV[1])
+if ARGV[2] ~= '0' then
+  redis.call('expireat', KEYS[1], ARGV[2])
+end
+return used
+`
+
+type QuotaConfig struct {
+	// The prefix of the redis keys
+	Name   string
+	Period QuotaPeriod
+	// The offset of the period boundary from UTC in seconds, e.g. 28800 for UTC+8
+	TimezoneOffset int
+	// How long the locally cached usage is trusted in milliseconds, default is 1000ms
+	CacheTTL int64
+	// The interval of writing the local consumption back to redis in milliseconds, default is 1000ms
+	FlushInterval int64
+}
+
+func ParseQuotaConfig(json gjson.Result) (QuotaConfig, error) {
+	config := QuotaConfig{
+		Name:           json.Get("name").String(),
+		Period:         QuotaPeriod(json.Get("period").String()),
+		TimezoneOffset: int(json.Get("timezoneOffset").Int()),
+		CacheTTL:       json.Get("cacheTTL").Int(),
+		FlushInterval:  json.Get("flushInterval").Int(),
+	}
+	switch config.Period {
+	case "":
+		config.Period = QuotaPeriodDaily
+	case QuotaPeriodDaily, QuotaPeriodMonthly, QuotaPeriodTotal:
+	default:
+		return config, fmt.Errorf("invalid quota period: %s", config.Period)
+	}
+	return config, nil
+}
+
+// QuotaSubject joins the dimensions of a quota counter, e.g. QuotaSubject(consumer, route).
+func QuotaSubject(parts ...string) string {
+	return strings.Join(parts, ":")
+}
+
+type quotaEntry struct {
+	// The usage last read from redis
+	used int64
+	// The local consumption not written to redis yet
+	pending   int64
+	fetchedAt int64
+	flushing  bool
+	// The unix time the counter of the period expires in redis, or 0 if it never expires
+	expireAt int64
+}
+
+// QuotaManager keeps period based counters in redis. Consumption is accumulated locally and
+// written back on tick, so Check is served from the local cache most of the time.
+type QuotaManager struct {
+	client  RedisClient
+	config  QuotaConfig
+	entries map[string]*quotaEntry
+}
+
+// NewQuotaManager should be called in parseConfig phase since it registers the flush tick function.
+func NewQuotaManager(client RedisClient, config QuotaConfig) *QuotaManager {
+	if config.Period == "" {
+		config.Period = QuotaPeriodDaily
+	}
+	if config.CacheTTL <= 0 {
+		config.CacheTTL = 1000
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 1000
+	}
+	q := &QuotaManager{
+		client:  client,
+		config:  config,
+		entries: map[string]*quotaEntry{},
+	}
+	RegisteTickFunc(config.FlushInterval, q.Flush)
+	return q
+}
+
+func (q *QuotaManager) now() time.Time {
+	return time.Now().UTC().Add(time.Duration(q.config.TimezoneOffset) * time.Second)
+}
+
+// periodKey returns the identity of the period containing t, t should be shifted by the timezone offset already.
+func periodKey(period QuotaPeriod, t time.Time) string {
+	switch period {
+	case QuotaPeriodMonthly:
+		return t.Format("200601")
+	case QuotaPeriodTotal:
+		return "total"
+	default:
+		return t.Format("20060102")
+	}
+}
+
+// periodEnd returns the unix time when the period containing t ends, or 0 if it never ends.
+func periodEnd(period QuotaPeriod, t time.Time, timezoneOffset int) int64 {
+	var end time.Time
+	switch period {
+	case QuotaPeriodMonthly:
+		end = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	case QuotaPeriodTotal:
+		return 0
+	default:
+		end = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+	}
+	return end.Unix() - int64(timezoneOffset)
+}
+
+// entry returns the redis key and the local entry of the counter of subject in the current period.
+func (q *QuotaManager) entry(subject string) (string, *quotaEntry) {
+	now := q.now()
+	key := fmt.Sprintf("%s:%s:%s", q.config.Name, subject, periodKey(q.config.Period, now))
+	e, ok := q.entries[key]
+	if !ok {
+		e = &quotaEntry{expireAt: periodEnd(q.config.Period, now, q.config.TimezoneOffset)}
+		if e.expireAt != 0 {
+			// keep the counter for one more day for reconciliation
+			e.expireAt += 86400
+		}
+		q.entries[key] = e
+	}
+	return key, e
+}
+
+// Check tells whether amount can still be consumed by subject within limit. The callback is called
+// synchronously when the local cache is fresh, otherwise after the usage is read from redis.
+func (q *QuotaManager) Check(subject string, limit, amount int64, callback func(allowed bool, remaining int64)) error {
+	key, e := q.entry(subject)
+	reply := func() {
+		remaining := limit - e.used - e.pending
+		callback(remaining >= amount, remaining)
+	}
+	if time.Now().UnixMilli()-e.fetchedAt < q.config.CacheTTL {
+		reply()
+		return nil
+	}
+	return q.client.Get(key, func(response resp.Value) {
+		if response.Error() != nil {
+			proxywasm.LogErrorf("failed to get quota usage of %s: %v", key, response.Error())
+		} else {
+			e.used = int64(response.Integer())
+			e.fetchedAt = time.Now().UnixMilli()
+		}
+		reply()
+	})
+}
+
+// Usage reads the usage of subject in the current period, including the consumption not flushed yet.
+func (q *QuotaManager) Usage(subject string, callback func(used int64, err error)) error {
+	key, e := q.entry(subject)
+	return q.client.Get(key, func(response resp.Value) {
+		if response.Error() != nil {
+			callback(0, response.Error())
+			return
+		}
+		e.used = int64(response.Integer())
+		e.fetchedAt = time.Now().UnixMilli()
+		callback(e.used+e.pending, nil)
+	})
+}
+
+// Consume records the consumption locally, it's written to redis by the next flush.
+func (q *QuotaManager) Consume(subject string, amount int64) {
+	_, e := q.entry(subject)
+	e.pending += amount
+}
+
+// Refund gives back the consumption, e.g. when the upstream call failed.
+func (q *QuotaManager) Refund(subject string, amount int64) {
+	q.Consume(subject, -amount)
+}
+
+// Flush writes the local consumption back to redis, it's called on tick automatically. The consumption left from
+// the previous period is written to the counter of that period, which expires by its own period end.
+func (q *QuotaManager) Flush() {
+	current := periodKey(q.config.Period, q.now())
+	for key, e := range q.entries {
+		if e.flushing {
+			continue
+		}
+		if e.pending == 0 {
+			if !strings.HasSuffix(key, ":"+current) {
+				delete(q.entries, key)
+			}
+			continue
+		}
+		key, e := key, e
+		delta := e.pending
+		e.flushing = true
+		err := q.client.Eval(quotaIncrScript, 1, []interface{}{key}, []interface{}{delta, e.expireAt}, func(response resp.Value) {
+			e.flushing = false
+			if response.Error() != nil {
+				proxywasm.LogErrorf("failed to flush quota usage of %s: %v", key, response.Error())
+				return
+			}
+			e.pending -= delta
+			e.used = int64(response.Integer())
+			e.fetchedAt = time.Now().UnixMilli()
+		})
+		if err != nil {
+			e.flushing = false
+			proxywasm.LogErrorf("failed to flush quota usage of %s: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+func TestQuotaPeriod(t *testing.T) {
+	// 2024-01-31 20:00 in UTC+8
+	now := time.Date(2024, 1, 31, 20, 0, 0, 0, time.UTC)
+	offset := 8 * 3600
+	cases := []struct {
+		name      string
+		period    QuotaPeriod
+		expectKey string
+		expectEnd int64
+	}{
+		{
+			name:      "daily",
+			period:    QuotaPeriodDaily,
+			expectKey: "20240131",
+			expectEnd: time.Date(2024, 1, 31, 16, 0, 0, 0, time.UTC).Unix(),
+		},
+		{
+			name:      "monthly",
+			period:    QuotaPeriodMonthly,
+			expectKey: "202401",
+			expectEnd: time.Date(2024, 1, 31, 16, 0, 0, 0, time.UTC).Unix(),
+		},
+		{
+			name:      "total",
+			period:    QuotaPeriodTotal,
+			expectKey: "total",
+			expectEnd: 0,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expectKey, periodKey(c.period, now))
+			assert.Equal(t, c.expectEnd, periodEnd(c.period, now, offset))
+		})
+	}
+}
+
+func TestParseQuotaConfig(t *testing.T) {
+	config, err := ParseQuotaConfig(gjson.Parse(`{"name":"ai-quota"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, QuotaPeriodDaily, config.Period)
+	_, err = ParseQuotaConfig(gjson.Parse(`{"name":"ai-quota","period":"weekly"}`))
+	assert.Error(t, err)
+	assert.Equal(t, "consumer1:route1", QuotaSubject("consumer1", "route1"))
+}
+
+// fakeQuotaRedis records the expiry of the flushed counters
+type fakeQuotaRedis struct {
+	RedisClient
+	expireAt map[string]int64
+}
+
+func (r *fakeQuotaRedis) Eval(script string, numkeys int, keys, args []interface{}, callback RedisResponseCallback) error {
+	r.expireAt[keys[0].(string)] = args[1].(int64)
+	callback(resp.IntegerValue(int(args[0].(int64))))
+	return nil
+}
+
+func TestQuotaFlushExpiry(t *testing.T) {
+	redis := &fakeQuotaRedis{expireAt: map[string]int64{}}
+	q := NewQuotaManager(redis, QuotaConfig{Name: "quota", Period: QuotaPeriodDaily})
+	q.Consume("alice", 10)
+	key, e := q.entry("alice")
+	assert.Equal(t, periodEnd(QuotaPeriodDaily, q.now(), 0)+86400, e.expireAt)
+	// the consumption left from yesterday expires by the end of yesterday
+	yesterday := q.now().AddDate(0, 0, -1)
+	lastKey := "quota:alice:" + periodKey(QuotaPeriodDaily, yesterday)
+	lastExpireAt := periodEnd(QuotaPeriodDaily, yesterday, 0) + 86400
+	q.entries[lastKey] = &quotaEntry{pending: 5, expireAt: lastExpireAt}
+
+	q.Flush()
+	require.Len(t, redis.expireAt, 2)
+	assert.Equal(t, e.expireAt, redis.expireAt[key])
+	assert.Equal(t, lastExpireAt, redis.expireAt[lastKey])
+	assert.Equal(t, int64(0), q.entries[lastKey].pending)
+	// the drained entry of the previous period is dropped by the next flush
+	q.Flush()
+	assert.NotContains(t, q.entries, lastKey)
+	assert.Contains(t, q.entries, key)
+
+	total := NewQuotaManager(redis, QuotaConfig{Name: "quota", Period: QuotaPeriodTotal})
+	_, e = total.entry("alice")
+	assert.Equal(t, int64(0), e.expireAt)
+}
@@ -115,6 +115,12 @@ func RedisInit(cluster Cluster, username, password string, timeout uint32) error
 }
 
 func RedisCall(cluster Cluster, respQuery []byte, callback RedisResponseCallback) error {
+	// the timeout of the redis calls is set by Init, so the calls are only refused once the deadline is exceeded
+	if deadlineExceeded() {
+		proxywasm.LogWarnf("redis call to %s is not dispatched: %v", cluster.ClusterName(), ErrDeadlineExceeded)
+		return ErrDeadlineExceeded
+	}
+	deadline := activeDeadline
 	requestID := uuid.New().String()
 	_, err := proxywasm.DispatchRedisCall(
 		cluster.ClusterName(),
@@ -143,6 +149,7 @@ func RedisCall(cluster Cluster, respQuery []byte, callback RedisResponseCallback
 				}
 			}
 			if callback != nil {
+				defer withDeadline(deadline)()
 				callback(responseValue)
 			}
 		})
@@ -0,0 +1,162 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/gjson"
+)
+
+// The default limit of the template and the rendered body
+const DefaultResponseTemplateMaxSize = 64 * 1024
+
+// ResponseTemplate is a local reply rendered with the variables of the request, e.g. a maintenance page, a branded
+// error page or a captive portal:
+//
+//	{
+//	  "statusCode": 503,
+//	  "contentType": "text/html; charset=utf-8",
+//	  "headers": {"retry-after": "{{config.retryAfter | 600}}"},
+//	  "body": "<h1>{{request.host}} is under maintenance</h1><p>request id: {{header.x-request-id}}</p>",
+//	  "maxSize": 65536
+//	}
+//
+// The values are escaped by the content type of the body: html for text/html, json for application/json and text
+// otherwise, and the values of the headers as text. The template and the rendered body are limited to maxSize bytes.
+type ResponseTemplate struct {
+	StatusCode  int
+	ContentType string
+	headers     []responseTemplateHeader
+	body        *PromptTemplate
+}
+
+type responseTemplateHeader struct {
+	name  string
+	value *PromptTemplate
+}
+
+func ParseResponseTemplate(json gjson.Result) (*ResponseTemplate, error) {
+	t := &ResponseTemplate{
+		StatusCode:  int(json.Get("statusCode").Int()),
+		ContentType: json.Get("contentType").String(),
+	}
+	if t.StatusCode == 0 {
+		t.StatusCode = http.StatusOK
+	}
+	if t.StatusCode < 100 || t.StatusCode > 599 {
+		return nil, fmt.Errorf("invalid status code %d of response template", t.StatusCode)
+	}
+	if t.ContentType == "" {
+		t.ContentType = "text/html; charset=utf-8"
+	}
+	maxSize := int(json.Get("maxSize").Int())
+	if maxSize <= 0 {
+		maxSize = DefaultResponseTemplateMaxSize
+	}
+	body := json.Get("body").String()
+	if body == "" {
+		return nil, errors.New("body of response template is required")
+	}
+	if len(body) > maxSize {
+		return nil, fmt.Errorf("body of response template exceeds %d bytes", maxSize)
+	}
+	var err error
+	t.body, err = ParsePromptTemplate(body, PromptTemplateOptions{
+		Escape:          templateEscapeOf(t.ContentType),
+		MaxValueLength:  maxSize,
+		MaxOutputLength: maxSize,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid body of response template: %v", err)
+	}
+	json.Get("headers").ForEach(func(key, value gjson.Result) bool {
+		var header *PromptTemplate
+		header, err = ParsePromptTemplate(value.String(), PromptTemplateOptions{})
+		if err != nil {
+			err = fmt.Errorf("invalid header %s of response template: %v", key.String(), err)
+			return false
+		}
+		t.headers = append(t.headers, responseTemplateHeader{name: strings.ToLower(key.String()), value: header})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func templateEscapeOf(contentType string) TemplateEscape {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		return TemplateEscapeHTML
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return TemplateEscapeJSON
+	}
+	return TemplateEscapeText
+}
+
+// Render renders the headers and the body, the content-type header is added unless it's set by the headers.
+func (t *ResponseTemplate) Render(sources TemplateSources) ([][2]string, []byte, error) {
+	headers := [][2]string{{"content-type", t.ContentType}}
+	for _, header := range t.headers {
+		value, err := header.value.Render(sources)
+		if err != nil {
+			return nil, nil, fmt.Errorf("render header %s failed: %v", header.name, err)
+		}
+		if value == "" {
+			continue
+		}
+		if header.name == "content-type" {
+			headers[0][1] = value
+		} else {
+			headers = append(headers, [2]string{header.name, value})
+		}
+	}
+	body, err := t.body.Render(sources)
+	if err != nil {
+		return nil, nil, fmt.Errorf("render body failed: %v", err)
+	}
+	return headers, []byte(body), nil
+}
+
+// Send renders the template with the request sources and the extra ones, e.g. the config source, and sends it as
+// the local reply of the request. The returned action should be returned by the phase, a plain 500 is sent if the
+// rendering fails.
+func (t *ResponseTemplate) Send(ctx HttpContext, details string, extraSources TemplateSources) types.Action {
+	sources := RequestTemplateSources(ctx)
+	for name, source := range extraSources {
+		sources[name] = source
+	}
+	headers, body, err := t.Render(sources)
+	if err != nil {
+		proxywasm.LogErrorf("render response template failed: %v", err)
+		DebugDecision(ctx, "render response template failed: %v", err)
+		_ = proxywasm.SendHttpResponseWithDetail(http.StatusInternalServerError, details+".render_failed", nil, nil, -1)
+		return types.ActionPause
+	}
+	if err = proxywasm.SendHttpResponseWithDetail(uint32(t.StatusCode), details, headers, body, -1); err != nil {
+		proxywasm.LogErrorf("send response template failed: %v", err)
+		return types.ActionContinue
+	}
+	return types.ActionPause
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestResponseTemplate(t *testing.T) {
+	sources := TemplateSources{
+		TemplateSourceHeader: MapTemplateSource(map[string]string{"x-user": `<script>"alice"</script>`}),
+		TemplateSourceConfig: JsonTemplateSource(gjson.Parse(`{"retryAfter": 60}`)),
+	}
+	tmpl, err := ParseResponseTemplate(gjson.Parse(`{
+		"statusCode": 503,
+		"headers": {"Retry-After": "{{config.retryAfter}}", "x-empty": "{{header.x-missing}}"},
+		"body": "<p>Hi {{header.x-user | guest}}</p>"
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, 503, tmpl.StatusCode)
+	headers, body, err := tmpl.Render(sources)
+	require.NoError(t, err)
+	assert.Equal(t, [][2]string{{"content-type", "text/html; charset=utf-8"}, {"retry-after", "60"}}, headers)
+	assert.Equal(t, "<p>Hi &lt;script&gt;&#34;alice&#34;&lt;/script&gt;</p>", string(body))
+
+	tmpl, err = ParseResponseTemplate(gjson.Parse(`{
+		"contentType": "application/problem+json",
+		"headers": {"content-type": "application/json"},
+		"body": "{\"user\": \"{{header.x-user}}\"}"
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, 200, tmpl.StatusCode)
+	headers, body, err = tmpl.Render(sources)
+	require.NoError(t, err)
+	assert.Equal(t, [][2]string{{"content-type", "application/json"}}, headers)
+	assert.Equal(t, `{"user": "<script>\"alice\"</script>"}`, string(body))
+
+	tmpl, err = ParseResponseTemplate(gjson.Parse(`{"contentType": "text/plain", "body": "{{header.x-user}}{{header.x-user}}", "maxSize": 40}`))
+	require.NoError(t, err)
+	_, _, err = tmpl.Render(sources)
+	assert.Error(t, err)
+}
+
+func TestParseResponseTemplateInvalid(t *testing.T) {
+	for _, config := range []string{
+		`{}`,
+		`{"statusCode": 99, "body": "x"}`,
+		`{"body": "{{user}}"}`,
+		`{"body": "ok", "headers": {"x-a": "{{a"}}`,
+		`{"body": "` + strings.Repeat("x", 11) + `", "maxSize": 10}`,
+	} {
+		_, err := ParseResponseTemplate(gjson.Parse(config))
+		assert.Error(t, err, config)
+	}
+}
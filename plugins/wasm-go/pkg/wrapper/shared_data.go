@@ -0,0 +1,120 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+const sharedDataCasMaxRetries = 10
+
+var vmID string
+
+// GetVMID returns a random id generated once per Wasm VM, it's used to tell the VMs sharing the same data apart.
+func GetVMID() string {
+	if vmID == "" {
+		vmID = uuid.New().String()
+	}
+	return vmID
+}
+
+// GetSharedDataJson reads the shared data of key and unmarshals it into value.
+// The value is left untouched if the key does not exist, and the returned cas can be used for the following write.
+func GetSharedDataJson(key string, value interface{}) (uint32, error) {
+	data, cas, err := proxywasm.GetSharedData(key)
+	if err != nil {
+		if errors.Is(err, types.ErrorStatusNotFound) {
+			return cas, nil
+		}
+		return 0, err
+	}
+	if len(data) == 0 {
+		return cas, nil
+	}
+	if err = json.Unmarshal(data, value); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal shared data %s: %v", key, err)
+	}
+	return cas, nil
+}
+
+// SetSharedDataJson marshals value and writes it into the shared data of key with the given cas.
+func SetSharedDataJson(key string, value interface{}, cas uint32) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal shared data %s: %v", key, err)
+	}
+	return proxywasm.SetSharedData(key, data, cas)
+}
+
+// UpdateSharedDataJson reads the shared data of key, applies update on it and writes it back, retrying on cas mismatch.
+// Nothing is written if update returns false.
+func UpdateSharedDataJson[T any](key string, update func(value *T) bool) error {
+	for attempt := 1; attempt <= sharedDataCasMaxRetries; attempt++ {
+		var value T
+		cas, err := GetSharedDataJson(key, &value)
+		if err != nil {
+			return err
+		}
+		if !update(&value) {
+			return nil
+		}
+		err = SetSharedDataJson(key, value, cas)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, types.ErrorStatusCasMismatch) {
+			return err
+		}
+		proxywasm.LogDebugf("cas mismatch when updating shared data %s, attempt: %d", key, attempt)
+	}
+	return fmt.Errorf("failed to update shared data %s after %d attempts", key, sharedDataCasMaxRetries)
+}
+
+type sharedLease struct {
+	VMID      string `json:"vmID"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// TryAcquireSharedLease lets only one of the VMs sharing the data hold the lease of key at a time,
+// it returns true if the current VM acquired or renewed the lease. The lease is released implicitly
+// when the holder stops renewing it for longer than ttl.
+func TryAcquireSharedLease(key string, ttl time.Duration) bool {
+	now := time.Now().UnixMilli()
+	var lease sharedLease
+	cas, err := GetSharedDataJson(key, &lease)
+	if err != nil {
+		proxywasm.LogErrorf("failed to get lease %s: %v", key, err)
+		return false
+	}
+	if lease.VMID != "" && lease.VMID != GetVMID() && now-lease.Timestamp <= ttl.Milliseconds() {
+		return false
+	}
+	lease.VMID = GetVMID()
+	lease.Timestamp = now
+	if err = SetSharedDataJson(key, lease, cas); err != nil {
+		if !errors.Is(err, types.ErrorStatusCasMismatch) {
+			proxywasm.LogErrorf("failed to set lease %s: %v", key, err)
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/cryptoutil"
+)
+
+var ErrSharedDataDecrypt = errors.New("failed to decrypt shared data")
+
+// ResolveSecretKey resolves the key spec of the config into an AES key:
+//   - env:NAME reads the environment variable NAME of the VM, whose value is resolved again
+//   - base64:VALUE and hex:VALUE decode VALUE, it must be 16, 24 or 32 bytes long
+//   - any other value is used as a passphrase, the key is its SHA-256 digest
+func ResolveSecretKey(spec string) ([]byte, error) {
+	switch {
+	case spec == "":
+		return nil, errors.New("secret key is empty")
+	case strings.HasPrefix(spec, "env:"):
+		name := strings.TrimPrefix(spec, "env:")
+		value := os.Getenv(name)
+		if value == "" || strings.HasPrefix(value, "env:") {
+			return nil, fmt.Errorf("environment variable %s is not set", name)
+		}
+		return ResolveSecretKey(value)
+	case strings.HasPrefix(spec, "base64:"):
+		return checkSecretKey(base64.StdEncoding.DecodeString(strings.TrimPrefix(spec, "base64:")))
+	case strings.HasPrefix(spec, "hex:"):
+		return checkSecretKey(hex.DecodeString(strings.TrimPrefix(spec, "hex:")))
+	}
+	digest := sha256.Sum256([]byte(spec))
+	return digest[:], nil
+}
+
+func checkSecretKey(key []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, fmt.Errorf("invalid secret key size %d", len(key))
+}
+
+// SharedDataCipher encrypts the values stored in the shared data with AES-GCM, the shared data key is used as the
+// additional data, so a value can't be moved to another key. The previous keys are only used for decryption,
+// which allows rotating the key without losing the cached values.
+type SharedDataCipher struct {
+	key          []byte
+	previousKeys [][]byte
+}
+
+func NewSharedDataCipher(key []byte, previousKeys ...[]byte) (*SharedDataCipher, error) {
+	if _, err := checkSecretKey(key, nil); err != nil {
+		return nil, err
+	}
+	for _, k := range previousKeys {
+		if _, err := checkSecretKey(k, nil); err != nil {
+			return nil, err
+		}
+	}
+	return &SharedDataCipher{key: key, previousKeys: previousKeys}, nil
+}
+
+// ParseSharedDataCipher parses the config like:
+//
+//	{"key": "env:SHARED_DATA_KEY", "previousKeys": ["base64:..."]}
+func ParseSharedDataCipher(json gjson.Result) (*SharedDataCipher, error) {
+	key, err := ResolveSecretKey(json.Get("key").String())
+	if err != nil {
+		return nil, err
+	}
+	var previousKeys [][]byte
+	for _, item := range json.Get("previousKeys").Array() {
+		k, err := ResolveSecretKey(item.String())
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous key: %v", err)
+		}
+		previousKeys = append(previousKeys, k)
+	}
+	return NewSharedDataCipher(key, previousKeys...)
+}
+
+// Seal encrypts the value to be stored in the shared data of key.
+func (c *SharedDataCipher) Seal(key string, value []byte) ([]byte, error) {
+	return cryptoutil.AESGCMEncrypt(c.key, value, []byte(key))
+}
+
+// Open decrypts the value read from the shared data of key.
+func (c *SharedDataCipher) Open(key string, data []byte) ([]byte, error) {
+	if value, err := cryptoutil.AESGCMDecrypt(c.key, data, []byte(key)); err == nil {
+		return value, nil
+	}
+	for _, k := range c.previousKeys {
+		if value, err := cryptoutil.AESGCMDecrypt(k, data, []byte(key)); err == nil {
+			return value, nil
+		}
+	}
+	return nil, ErrSharedDataDecrypt
+}
+
+// SetSharedDataEncrypted encrypts value and writes it into the shared data of key with the given cas.
+func (c *SharedDataCipher) SetSharedDataEncrypted(key string, value []byte, cas uint32) error {
+	data, err := c.Seal(key, value)
+	if err != nil {
+		return err
+	}
+	return proxywasm.SetSharedData(key, data, cas)
+}
+
+// GetSharedDataDecrypted reads and decrypts the shared data of key. A nil value is returned if the key does not
+// exist, and ErrSharedDataDecrypt if the value can't be decrypted, e.g. the key was rotated, in that case the
+// returned cas can still be used to overwrite it.
+func (c *SharedDataCipher) GetSharedDataDecrypted(key string) ([]byte, uint32, error) {
+	data, cas, err := proxywasm.GetSharedData(key)
+	if err != nil {
+		if errors.Is(err, types.ErrorStatusNotFound) {
+			return nil, cas, nil
+		}
+		return nil, 0, err
+	}
+	if len(data) == 0 {
+		return nil, cas, nil
+	}
+	value, err := c.Open(key, data)
+	if err != nil {
+		return nil, cas, err
+	}
+	return value, cas, nil
+}
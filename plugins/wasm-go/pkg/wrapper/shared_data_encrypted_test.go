@@ -0,0 +1,77 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestResolveSecretKey(t *testing.T) {
+	key, err := ResolveSecretKey("hex:000102030405060708090a0b0c0d0e0f")
+	require.NoError(t, err)
+	assert.Len(t, key, 16)
+
+	key, err = ResolveSecretKey("base64:AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=")
+	require.NoError(t, err)
+	assert.Len(t, key, 32)
+
+	key, err = ResolveSecretKey("passphrase")
+	require.NoError(t, err)
+	assert.Len(t, key, 32)
+
+	t.Setenv("TEST_SHARED_DATA_KEY", "passphrase")
+	fromEnv, err := ResolveSecretKey("env:TEST_SHARED_DATA_KEY")
+	require.NoError(t, err)
+	assert.Equal(t, key, fromEnv)
+
+	_, err = ResolveSecretKey("env:TEST_SHARED_DATA_KEY_MISSING")
+	assert.Error(t, err)
+	_, err = ResolveSecretKey("hex:0001")
+	assert.Error(t, err)
+	_, err = ResolveSecretKey("")
+	assert.Error(t, err)
+}
+
+func TestSharedDataCipher(t *testing.T) {
+	old, err := ParseSharedDataCipher(gjson.Parse(`{"key": "old"}`))
+	require.NoError(t, err)
+	c, err := ParseSharedDataCipher(gjson.Parse(`{"key": "new", "previousKeys": ["old"]}`))
+	require.NoError(t, err)
+
+	data, err := c.Seal("token", []byte("secret"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+	value, err := c.Open("token", data)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(value))
+
+	_, err = c.Open("other", data)
+	assert.ErrorIs(t, err, ErrSharedDataDecrypt)
+	_, err = old.Open("token", data)
+	assert.ErrorIs(t, err, ErrSharedDataDecrypt)
+
+	data, err = old.Seal("token", []byte("secret"))
+	require.NoError(t, err)
+	value, err = c.Open("token", data)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(value))
+
+	_, err = NewSharedDataCipher([]byte("short"))
+	assert.Error(t, err)
+}
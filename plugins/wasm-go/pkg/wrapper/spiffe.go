@@ -0,0 +1,188 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// SPIFFE ID: https://github.com/spiffe/spiffe/blob/main/standards/SPIFFE-ID.md
+
+package wrapper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+const spiffeScheme = "spiffe://"
+
+var ErrSpiffeIDMissing = errors.New("peer certificate does not have exactly one spiffe id")
+
+type SpiffeID struct {
+	TrustDomain string
+	// The path with the leading slash, empty for the trust domain itself
+	Path string
+}
+
+func (id SpiffeID) String() string {
+	return spiffeScheme + id.TrustDomain + id.Path
+}
+
+func isTrustDomainChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '.' || c == '-' || c == '_'
+}
+
+func isSpiffePathChar(c byte) bool {
+	return isTrustDomainChar(c) || (c >= 'A' && c <= 'Z')
+}
+
+// ParseSpiffeID parses and validates a SPIFFE ID, the trust domain must be lowercase and no query,
+// fragment, port or user info is allowed.
+func ParseSpiffeID(s string) (SpiffeID, error) {
+	if !strings.HasPrefix(strings.ToLower(s), spiffeScheme) {
+		return SpiffeID{}, fmt.Errorf("invalid spiffe id %q: scheme must be spiffe", s)
+	}
+	rest := s[len(spiffeScheme):]
+	trustDomain, path := rest, ""
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		trustDomain, path = rest[:i], rest[i:]
+	}
+	if trustDomain == "" {
+		return SpiffeID{}, fmt.Errorf("invalid spiffe id %q: trust domain is empty", s)
+	}
+	for i := 0; i < len(trustDomain); i++ {
+		if !isTrustDomainChar(trustDomain[i]) {
+			return SpiffeID{}, fmt.Errorf("invalid spiffe id %q: bad character in trust domain", s)
+		}
+	}
+	if path != "" {
+		if err := validateSpiffePath(path, false); err != nil {
+			return SpiffeID{}, fmt.Errorf("invalid spiffe id %q: %v", s, err)
+		}
+	}
+	return SpiffeID{TrustDomain: trustDomain, Path: path}, nil
+}
+
+func validateSpiffePath(path string, allowWildcard bool) error {
+	for _, segment := range strings.Split(path[1:], "/") {
+		switch segment {
+		case "":
+			return errors.New("empty path segment")
+		case ".", "..":
+			return errors.New("dot path segment")
+		case "*", "**":
+			if allowWildcard {
+				continue
+			}
+		}
+		for i := 0; i < len(segment); i++ {
+			if !isSpiffePathChar(segment[i]) {
+				return errors.New("bad character in path")
+			}
+		}
+	}
+	return nil
+}
+
+// SpiffeIDFromCertificate returns the SPIFFE ID of an X509-SVID, which must contain exactly one URI SAN.
+func SpiffeIDFromCertificate(cert PeerCertificate) (SpiffeID, error) {
+	if len(cert.URISANs) != 1 {
+		return SpiffeID{}, ErrSpiffeIDMissing
+	}
+	return ParseSpiffeID(cert.URISANs[0])
+}
+
+type spiffePattern struct {
+	trustDomain string
+	segments    []string
+}
+
+func (p spiffePattern) match(id SpiffeID) bool {
+	if p.trustDomain != id.TrustDomain {
+		return false
+	}
+	var segments []string
+	if id.Path != "" {
+		segments = strings.Split(id.Path[1:], "/")
+	}
+	for i, pattern := range p.segments {
+		if pattern == "**" {
+			return true
+		}
+		if i >= len(segments) || (pattern != "*" && pattern != segments[i]) {
+			return false
+		}
+	}
+	return len(segments) == len(p.segments)
+}
+
+// SpiffeMatcher matches SPIFFE IDs against patterns such as "spiffe://cluster.local/ns/*/sa/gateway", where "*"
+// matches one path segment and a trailing "**" matches any remaining segments. The trust domain is matched exactly.
+type SpiffeMatcher struct {
+	patterns []spiffePattern
+}
+
+func NewSpiffeMatcher(patterns []string) (*SpiffeMatcher, error) {
+	m := &SpiffeMatcher{}
+	for _, s := range patterns {
+		if !strings.HasPrefix(s, spiffeScheme) {
+			return nil, fmt.Errorf("invalid spiffe id pattern %q: scheme must be spiffe", s)
+		}
+		rest := s[len(spiffeScheme):]
+		trustDomain, path, _ := strings.Cut(rest, "/")
+		pattern := spiffePattern{trustDomain: trustDomain}
+		if _, err := ParseSpiffeID(spiffeScheme + trustDomain); err != nil {
+			return nil, err
+		}
+		if path != "" {
+			if err := validateSpiffePath("/"+path, true); err != nil {
+				return nil, fmt.Errorf("invalid spiffe id pattern %q: %v", s, err)
+			}
+			pattern.segments = strings.Split(path, "/")
+			for i, segment := range pattern.segments {
+				if segment == "**" && i != len(pattern.segments)-1 {
+					return nil, fmt.Errorf("invalid spiffe id pattern %q: ** must be the last segment", s)
+				}
+			}
+		}
+		m.patterns = append(m.patterns, pattern)
+	}
+	return m, nil
+}
+
+// ParseSpiffeMatcher parses an array of SPIFFE ID patterns.
+func ParseSpiffeMatcher(json gjson.Result) (*SpiffeMatcher, error) {
+	var patterns []string
+	for _, item := range json.Array() {
+		patterns = append(patterns, item.String())
+	}
+	return NewSpiffeMatcher(patterns)
+}
+
+func (m *SpiffeMatcher) Match(id SpiffeID) bool {
+	for _, pattern := range m.patterns {
+		if pattern.match(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchCertificate extracts the SPIFFE ID from the peer certificate and matches it.
+func (m *SpiffeMatcher) MatchCertificate(cert PeerCertificate) (SpiffeID, bool) {
+	id, err := SpiffeIDFromCertificate(cert)
+	if err != nil {
+		return id, false
+	}
+	return id, m.Match(id)
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSpiffeID(t *testing.T) {
+	id, err := ParseSpiffeID("spiffe://cluster.local/ns/default/sa/foo")
+	require.NoError(t, err)
+	assert.Equal(t, SpiffeID{TrustDomain: "cluster.local", Path: "/ns/default/sa/foo"}, id)
+	assert.Equal(t, "spiffe://cluster.local/ns/default/sa/foo", id.String())
+
+	for _, invalid := range []string{
+		"http://cluster.local/foo",
+		"spiffe:///foo",
+		"spiffe://Cluster.local/foo",
+		"spiffe://cluster.local:8080/foo",
+		"spiffe://cluster.local/foo/",
+		"spiffe://cluster.local/foo/../bar",
+		"spiffe://cluster.local/foo?a=b",
+	} {
+		_, err := ParseSpiffeID(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestSpiffeMatcher(t *testing.T) {
+	m, err := NewSpiffeMatcher([]string{
+		"spiffe://cluster.local/ns/*/sa/gateway",
+		"spiffe://prod.example.org/**",
+	})
+	require.NoError(t, err)
+	cases := map[string]bool{
+		"spiffe://cluster.local/ns/default/sa/gateway": true,
+		"spiffe://cluster.local/ns/default/sa/other":   false,
+		"spiffe://cluster.local/ns/default/sa":         false,
+		"spiffe://prod.example.org":                    true,
+		"spiffe://prod.example.org/a/b/c":              true,
+		"spiffe://example.org/a":                       false,
+	}
+	for s, expected := range cases {
+		id, err := ParseSpiffeID(s)
+		require.NoError(t, err)
+		assert.Equal(t, expected, m.Match(id), s)
+	}
+
+	_, ok := m.MatchCertificate(PeerCertificate{URISANs: []string{"spiffe://prod.example.org/a", "spiffe://prod.example.org/b"}})
+	assert.False(t, ok)
+	id, ok := m.MatchCertificate(PeerCertificate{URISANs: []string{"spiffe://prod.example.org/a"}})
+	assert.True(t, ok)
+	assert.Equal(t, "/a", id.Path)
+
+	_, err = NewSpiffeMatcher([]string{"spiffe://cluster.local/**/foo"})
+	assert.Error(t, err)
+	_, err = NewSpiffeMatcher([]string{"cluster.local/foo"})
+	assert.Error(t, err)
+}
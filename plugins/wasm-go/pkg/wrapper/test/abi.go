@@ -0,0 +1,230 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"unsafe"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+// The proxytest package of the sdk is out of sync with the host ABI of the sdk, and the hooks to install a host
+// live in an internal package, so they are linked by name here. The types mirror the ones of the internal package,
+// the method set of abiHost must stay identical to internal.ProxyWasmHost.
+
+type (
+	status     uint32
+	logLevel   uint32
+	mapType    uint32
+	bufferType uint32
+	streamType uint32
+	metricType uint32
+)
+
+const (
+	statusOK            status = 0
+	statusNotFound      status = 1
+	statusBadArgument   status = 2
+	statusEmpty         status = 7
+	statusCasMismatch   status = 8
+	statusUnimplemented status = 12
+
+	mapTypeHttpRequestHeaders      mapType = 0
+	mapTypeHttpRequestTrailers     mapType = 1
+	mapTypeHttpResponseHeaders     mapType = 2
+	mapTypeHttpResponseTrailers    mapType = 3
+	mapTypeHttpCallResponseHeaders mapType = 6
+
+	bufferTypeHttpRequestBody      bufferType = 0
+	bufferTypeHttpResponseBody     bufferType = 1
+	bufferTypeHttpCallResponseBody bufferType = 4
+	bufferTypeVMConfiguration      bufferType = 6
+	bufferTypePluginConfiguration  bufferType = 7
+	bufferTypeRedisCallResponse    bufferType = 9
+)
+
+type abiHost interface {
+	ProxyLog(logLevel logLevel, messageData *byte, messageSize int) status
+	ProxySetProperty(pathData *byte, pathSize int, valueData *byte, valueSize int) status
+	ProxyGetProperty(pathData *byte, pathSize int, returnValueData **byte, returnValueSize *int) status
+	ProxySendLocalResponse(statusCode uint32, statusCodeDetailData *byte, statusCodeDetailsSize int, bodyData *byte, bodySize int, headersData *byte, headersSize int, grpcStatus int32) status
+	ProxyGetSharedData(keyData *byte, keySize int, returnValueData **byte, returnValueSize *int, returnCas *uint32) status
+	ProxySetSharedData(keyData *byte, keySize int, valueData *byte, valueSize int, cas uint32) status
+	ProxyRegisterSharedQueue(nameData *byte, nameSize int, returnID *uint32) status
+	ProxyResolveSharedQueue(vmIDData *byte, vmIDSize int, nameData *byte, nameSize int, returnID *uint32) status
+	ProxyDequeueSharedQueue(queueID uint32, returnValueData **byte, returnValueSize *int) status
+	ProxyEnqueueSharedQueue(queueID uint32, valueData *byte, valueSize int) status
+	ProxyGetHeaderMapValue(mapType mapType, keyData *byte, keySize int, returnValueData **byte, returnValueSize *int) status
+	ProxyAddHeaderMapValue(mapType mapType, keyData *byte, keySize int, valueData *byte, valueSize int) status
+	ProxyReplaceHeaderMapValue(mapType mapType, keyData *byte, keySize int, valueData *byte, valueSize int) status
+	ProxyContinueStream(streamType streamType) status
+	ProxyCloseStream(streamType streamType) status
+	ProxyRemoveHeaderMapValue(mapType mapType, keyData *byte, keySize int) status
+	ProxyGetHeaderMapPairs(mapType mapType, returnValueData **byte, returnValueSize *int) status
+	ProxySetHeaderMapPairs(mapType mapType, mapData *byte, mapSize int) status
+	ProxyGetBufferBytes(bufferType bufferType, start int, maxSize int, returnBufferData **byte, returnBufferSize *int) status
+	ProxySetBufferBytes(bufferType bufferType, start int, maxSize int, bufferData *byte, bufferSize int) status
+	ProxyHttpCall(upstreamData *byte, upstreamSize int, headerData *byte, headerSize int, bodyData *byte, bodySize int, trailersData *byte, trailersSize int, timeout uint32, calloutIDPtr *uint32) status
+	ProxyRedisInit(upstreamData *byte, upstreamSize int, usernameData *byte, usernameSize int, passwordData *byte, passwordSize int, timeout uint32) status
+	ProxyRedisCall(upstreamData *byte, upstreamSize int, queryData *byte, querySize int, calloutIDPtr *uint32) status
+	ProxyCallForeignFunction(funcNamePtr *byte, funcNameSize int, paramPtr *byte, paramSize int, returnData **byte, returnSize *int) status
+	ProxySetTickPeriodMilliseconds(period uint32) status
+	ProxySetEffectiveContext(contextID uint32) status
+	ProxyDone() status
+	ProxyDefineMetric(metricType metricType, metricNameData *byte, metricNameSize int, returnMetricIDPtr *uint32) status
+	ProxyIncrementMetric(metricID uint32, offset int64) status
+	ProxyRecordMetric(metricID uint32, value uint64) status
+	ProxyGetMetric(metricID uint32, returnMetricValue *uint64) status
+}
+
+// keepHostMethods looks up the methods of the host by reflection, which keeps the linker from pruning them, since
+// the sdk calls them through its own interface which the linker doesn't relate to the methods.
+func keepHostMethods(host abiHost) {
+	hostType := reflect.TypeOf(host)
+	for i := 0; i < hostType.NumMethod(); i++ {
+		_ = hostType.Method(i)
+	}
+}
+
+//go:linkname registerMockWasmHost github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.RegisterMockWasmHost
+func registerMockWasmHost(host abiHost) (release func())
+
+//go:linkname vmStateReset github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.VMStateReset
+func vmStateReset()
+
+//go:linkname vmStateGetActiveContextID github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.VMStateGetActiveContextID
+func vmStateGetActiveContextID() uint32
+
+//go:linkname vmStateSetActiveContextID github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.VMStateSetActiveContextID
+func vmStateSetActiveContextID(contextID uint32)
+
+//go:linkname proxyOnVMStart github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnVMStart
+func proxyOnVMStart(pluginContextID uint32, vmConfigurationSize int) types.OnVMStartStatus
+
+//go:linkname proxyOnConfigure github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnConfigure
+func proxyOnConfigure(pluginContextID uint32, pluginConfigurationSize int) types.OnPluginStartStatus
+
+//go:linkname proxyOnContextCreate github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnContextCreate
+func proxyOnContextCreate(contextID uint32, pluginContextID uint32)
+
+//go:linkname proxyOnRequestHeaders github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnRequestHeaders
+func proxyOnRequestHeaders(contextID uint32, numHeaders int, endOfStream bool) types.Action
+
+//go:linkname proxyOnRequestBody github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnRequestBody
+func proxyOnRequestBody(contextID uint32, bodySize int, endOfStream bool) types.Action
+
+//go:linkname proxyOnResponseHeaders github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnResponseHeaders
+func proxyOnResponseHeaders(contextID uint32, numHeaders int, endOfStream bool) types.Action
+
+//go:linkname proxyOnResponseBody github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnResponseBody
+func proxyOnResponseBody(contextID uint32, bodySize int, endOfStream bool) types.Action
+
+//go:linkname proxyOnHttpCallResponse github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnHttpCallResponse
+func proxyOnHttpCallResponse(pluginContextID, calloutID uint32, numHeaders, bodySize, numTrailers int)
+
+//go:linkname proxyOnRedisCallResponse github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.proxyOnRedisCallResponse
+func proxyOnRedisCallResponse(pluginContextID, calloutID uint32, status, responseSize int)
+
+//go:linkname proxyOnQueueReady github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnQueueReady
+func proxyOnQueueReady(contextID, queueID uint32)
+
+//go:linkname proxyOnTick github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnTick
+func proxyOnTick(pluginContextID uint32)
+
+//go:linkname proxyOnLog github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnLog
+func proxyOnLog(contextID uint32)
+
+//go:linkname proxyOnDone github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnDone
+func proxyOnDone(contextID uint32) bool
+
+//go:linkname proxyOnDelete github.com/higress-group/proxy-wasm-go-sdk/proxywasm/internal.ProxyOnDelete
+func proxyOnDelete(contextID uint32)
+
+// rawBytes copies the data of the plugin, which may be reused once the host call returns.
+func rawBytes(data *byte, size int) []byte {
+	if data == nil || size == 0 {
+		return nil
+	}
+	return append([]byte(nil), unsafe.Slice(data, size)...)
+}
+
+func rawString(data *byte, size int) string {
+	return string(rawBytes(data, size))
+}
+
+// returnBytes hands the data to the plugin, it must not be modified afterwards.
+func returnBytes(data []byte, returnData **byte, returnSize *int) {
+	*returnSize = len(data)
+	if len(data) > 0 {
+		*returnData = &data[0]
+	} else {
+		*returnData = nil
+	}
+}
+
+func serializeMap(pairs [][2]string) []byte {
+	size := 4
+	for _, pair := range pairs {
+		size += len(pair[0]) + len(pair[1]) + 10
+	}
+	data := make([]byte, size)
+	binary.LittleEndian.PutUint32(data, uint32(len(pairs)))
+	offset := 4
+	for _, pair := range pairs {
+		binary.LittleEndian.PutUint32(data[offset:], uint32(len(pair[0])))
+		binary.LittleEndian.PutUint32(data[offset+4:], uint32(len(pair[1])))
+		offset += 8
+	}
+	for _, pair := range pairs {
+		offset += copy(data[offset:], pair[0]) + 1
+		offset += copy(data[offset:], pair[1]) + 1
+	}
+	return data
+}
+
+func deserializeMap(data []byte) [][2]string {
+	if len(data) < 4 {
+		return nil
+	}
+	n := int(binary.LittleEndian.Uint32(data))
+	pairs := make([][2]string, n)
+	sizeOffset, dataOffset := 4, 4+8*n
+	for i := 0; i < n; i++ {
+		for j := 0; j < 2; j++ {
+			size := int(binary.LittleEndian.Uint32(data[sizeOffset:]))
+			sizeOffset += 4
+			pairs[i][j] = string(data[dataOffset : dataOffset+size])
+			dataOffset += size + 1
+		}
+	}
+	return pairs
+}
+
+func propertyKey(path []string) string {
+	return strings.Join(path, "\x00")
+}
+
+func lowerCaseKeys(pairs [][2]string) [][2]string {
+	lowered := make([][2]string, len(pairs))
+	for i, pair := range pairs {
+		lowered[i] = [2]string{strings.ToLower(pair[0]), pair[1]}
+	}
+	return lowered
+}
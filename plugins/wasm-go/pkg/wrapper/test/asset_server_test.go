@@ -0,0 +1,131 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+var testAssets = fstest.MapFS{
+	"assets/challenge.js":       {Data: []byte(strings.Repeat("console.log('challenge');\n", 100))},
+	"assets/favicon.ico":        {Data: []byte{0, 0, 1, 0}},
+	"assets/oidc/callback.html": {Data: []byte("<html>callback</html>")},
+	"other.txt":                 {Data: []byte("not served")},
+}
+
+func newAssetServerVmCtx(assets *wrapper.AssetServer) *wrapper.CommonVmCtx[struct{}] {
+	return wrapper.NewCommonVmCtx("assets",
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config struct{}, log wrapper.Log) types.Action {
+			if name, ok := assets.Match(ctx.Method(), ctx.Path()); ok {
+				return assets.ServeAsset(name)
+			}
+			return types.ActionContinue
+		}),
+	)
+}
+
+func localResponseHeader(response *LocalResponse, name string) string {
+	for _, header := range response.Headers {
+		if header[0] == name {
+			return header[1]
+		}
+	}
+	return ""
+}
+
+func TestAssetServer(t *testing.T) {
+	assets, err := wrapper.NewAssetServer(testAssets, wrapper.AssetServerConfig{Root: "assets", Prefix: "/.static"})
+	require.NoError(t, err)
+	host, reset := NewHost(newAssetServerVmCtx(assets))
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin(nil))
+
+	send := func(method, path string, headers ...[2]string) *HttpStream {
+		stream := host.NewHttpStream()
+		stream.CallOnRequestHeaders(append([][2]string{{":authority", "example.com"}, {":method", method}, {":path", path}}, headers...), true)
+		return stream
+	}
+
+	response := send("GET", "/.static/oidc/callback.html?code=abc").LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, uint32(200), response.StatusCode)
+	assert.Equal(t, "<html>callback</html>", string(response.Body))
+	assert.Equal(t, "text/html; charset=utf-8", localResponseHeader(response, "content-type"))
+	assert.Equal(t, wrapper.DefaultAssetCacheControl, localResponseHeader(response, "cache-control"))
+	etag := localResponseHeader(response, "etag")
+	assert.NotEmpty(t, etag)
+
+	response = send("GET", "/.static/oidc/callback.html", [2]string{"if-none-match", `W/"other", ` + etag}).LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, uint32(304), response.StatusCode)
+	assert.Empty(t, response.Body)
+
+	// the script is compressed for the clients accepting gzip
+	response = send("GET", "/.static/challenge.js", [2]string{"accept-encoding", "gzip, br"}).LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, "gzip", localResponseHeader(response, "content-encoding"))
+	assert.Equal(t, "accept-encoding", localResponseHeader(response, "vary"))
+	reader, err := gzip.NewReader(bytes.NewReader(response.Body))
+	require.NoError(t, err)
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, testAssets["assets/challenge.js"].Data, body)
+	response = send("GET", "/.static/challenge.js", [2]string{"accept-encoding", "gzip;q=0"}).LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, "", localResponseHeader(response, "content-encoding"))
+	assert.Equal(t, testAssets["assets/challenge.js"].Data, response.Body)
+
+	response = send("HEAD", "/.static/favicon.ico").LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, uint32(200), response.StatusCode)
+	assert.Equal(t, "image/x-icon", localResponseHeader(response, "content-type"))
+	assert.Empty(t, response.Body)
+
+	for _, request := range [][2]string{
+		{"POST", "/.static/favicon.ico"},
+		{"GET", "/.static/missing.js"},
+		{"GET", "/.static/../other.txt"},
+		{"GET", "/favicon.ico"},
+	} {
+		stream := send(request[0], request[1])
+		assert.Nil(t, stream.LocalResponse(), request)
+		assert.Equal(t, types.ActionContinue, stream.Action())
+	}
+
+	stream := host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":method", "GET"}, {":path", "/"}}, true)
+	assert.Equal(t, types.ActionPause, assets.ServeAsset("missing.js"))
+	require.NotNil(t, stream.LocalResponse())
+	assert.Equal(t, uint32(404), stream.LocalResponse().StatusCode)
+}
+
+func TestAssetServerMaxSize(t *testing.T) {
+	_, err := wrapper.NewAssetServer(testAssets, wrapper.AssetServerConfig{MaxSize: 100})
+	assert.True(t, errors.Is(err, wrapper.ErrAssetsTooLarge))
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+func TestBodyBufferLimit(t *testing.T) {
+	host, reset := NewHost(wrapper.NewCommonVmCtx[struct{}]("buffer-limit"))
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{
+		"_requestBodyBufferLimit_": 1024,
+		"_rules_": [{"_match_route_": ["upload"], "_requestBodyBufferLimit_": 10485760, "_responseBodyBufferLimit_": 2048}]
+	}`)))
+
+	stream := host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}}, true)
+	limit, _ := stream.GetProperty([]string{"set_decoder_buffer_limit"})
+	assert.Equal(t, "1024", string(limit))
+	stream.CallOnResponseHeaders([][2]string{{":status", "200"}}, true)
+	_, ok := stream.GetProperty([]string{"set_encoder_buffer_limit"})
+	assert.False(t, ok)
+
+	host.SetProperty([]string{"route_name"}, []byte("upload"))
+	stream = host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}}, true)
+	limit, _ = stream.GetProperty([]string{"set_decoder_buffer_limit"})
+	assert.Equal(t, "10485760", string(limit))
+	stream.CallOnResponseHeaders([][2]string{{":status", "200"}}, true)
+	limit, _ = stream.GetProperty([]string{"set_encoder_buffer_limit"})
+	assert.Equal(t, "2048", string(limit))
+}
+
+func TestBodyBufferLimitInvalid(t *testing.T) {
+	for _, config := range []string{
+		`{"_requestBodyBufferLimit_": 0}`,
+		`{"_requestBodyBufferLimit_": "1M"}`,
+		`{"_responseBodyBufferLimit_": 1.5}`,
+		`{"_rules_": [{"_match_route_": ["r"], "_responseBodyBufferLimit_": -1}]}`,
+	} {
+		host, reset := NewHost(wrapper.NewCommonVmCtx[struct{}]("buffer-limit"))
+		assert.Equal(t, types.OnPluginStartStatusFailed, host.StartPlugin([]byte(config)), config)
+		reset()
+	}
+}
+
+func TestBodyBufferLimitFallback(t *testing.T) {
+	var states []wrapper.CapabilityState
+	host, reset := NewHost(wrapper.NewCommonVmCtx("buffer-limit",
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config struct{}, log wrapper.Log) types.Action {
+			ctx.SetRequestBodyBufferLimit(10)
+			ctx.DisableReroute()
+			states = append(states, wrapper.HostCapability(wrapper.CapabilityDecoderBufferLimit),
+				wrapper.HostCapability(wrapper.CapabilityClearRouteCache))
+			return types.ActionContinue
+		}),
+		wrapper.ProcessRequestBodyBy(func(ctx wrapper.HttpContext, config struct{}, body []byte, log wrapper.Log) types.Action {
+			return types.ActionContinue
+		}),
+	))
+	defer reset()
+	host.RejectProperty([]string{"set_decoder_buffer_limit"})
+	host.RejectProperty([]string{"set_encoder_buffer_limit"})
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{"_responseBodyBufferLimit_": 8}`)))
+
+	// rejected by the content-length
+	stream := host.NewHttpStream()
+	action := stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}, {"content-length", "11"}}, false)
+	assert.Equal(t, types.ActionPause, action)
+	require.NotNil(t, stream.LocalResponse())
+	assert.Equal(t, uint32(413), stream.LocalResponse().StatusCode)
+	assert.Equal(t, []wrapper.CapabilityState{wrapper.CapabilityUnsupported, wrapper.CapabilitySupported}, states)
+
+	// rejected by the buffered body
+	stream = host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}}, false)
+	assert.Nil(t, stream.LocalResponse())
+	assert.Equal(t, types.ActionPause, stream.CallOnRequestBody([]byte("123456"), false))
+	assert.Nil(t, stream.LocalResponse())
+	stream.CallOnRequestBody([]byte("123456"), true)
+	require.NotNil(t, stream.LocalResponse())
+	assert.Equal(t, uint32(413), stream.LocalResponse().StatusCode)
+	// the host is not asked again
+	assert.Equal(t, wrapper.CapabilityUnsupported, wrapper.HostCapability(wrapper.CapabilityDecoderBufferLimit))
+	assert.Equal(t, wrapper.CapabilityUnsupported, states[2])
+
+	stream = host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}}, false)
+	assert.Equal(t, types.ActionContinue, stream.CallOnRequestBody([]byte("12345"), true))
+	assert.Nil(t, stream.LocalResponse())
+	stream.CallOnResponseHeaders([][2]string{{":status", "200"}}, false)
+	assert.Equal(t, types.ActionContinue, stream.CallOnResponseBody([]byte("12345"), false))
+	stream.CallOnResponseBody([]byte("12345"), true)
+	require.NotNil(t, stream.LocalResponse())
+	assert.Equal(t, uint32(500), stream.LocalResponse().StatusCode)
+}
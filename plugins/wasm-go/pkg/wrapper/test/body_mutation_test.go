@@ -0,0 +1,99 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+func newBodyMutationVmCtx(options ...wrapper.CtxOption[struct{}]) *wrapper.CommonVmCtx[struct{}] {
+	return wrapper.NewCommonVmCtx("body-mutation", append(options,
+		wrapper.ProcessRequestBodyBy(func(ctx wrapper.HttpContext, config struct{}, body []byte, log wrapper.Log) types.Action {
+			_ = wrapper.ReplaceRequestBody(ctx, append(body, []byte(" world")...))
+			return types.ActionContinue
+		}),
+		wrapper.ProcessResponseBodyBy(func(ctx wrapper.HttpContext, config struct{}, body []byte, log wrapper.Log) types.Action {
+			_ = wrapper.ReplaceResponseBody(ctx, []byte("ok"))
+			return types.ActionContinue
+		}),
+	)...)
+}
+
+func TestReplaceBodyFixesHeaders(t *testing.T) {
+	host, reset := NewHost(newBodyMutationVmCtx(wrapper.HoldHeadersForBodyReplacement[struct{}]()))
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin(nil))
+
+	stream := host.NewHttpStream()
+	// the headers are held until the body is replaced
+	assert.Equal(t, types.ActionPause, stream.CallOnRequestHeaders([][2]string{
+		{":authority", "example.com"}, {":path", "/"}, {"content-length", "5"}, {"content-md5", "XUFAKrxLKna5cZ2REBfFkg=="},
+	}, false))
+	assert.Equal(t, types.ActionContinue, stream.CallOnRequestBody([]byte("hello"), true))
+	assert.Equal(t, "hello world", string(stream.RequestBody()))
+	length, _ := stream.RequestHeader("content-length")
+	assert.Equal(t, "11", length)
+	_, ok := stream.RequestHeader("content-md5")
+	assert.False(t, ok)
+
+	assert.Equal(t, types.ActionPause, stream.CallOnResponseHeaders([][2]string{{":status", "200"}, {"content-length", "9"}, {"digest", "sha-256=x"}}, false))
+	assert.Equal(t, types.ActionContinue, stream.CallOnResponseBody([]byte("not found"), true))
+	length, _ = stream.ResponseHeader("content-length")
+	assert.Equal(t, "2", length)
+	_, ok = stream.ResponseHeader("digest")
+	assert.False(t, ok)
+	assert.Empty(t, host.Logs(wrapper.LogLevelWarn))
+}
+
+func TestReplaceBodyChunked(t *testing.T) {
+	host, reset := NewHost(newBodyMutationVmCtx(wrapper.HoldHeadersForBodyReplacement[struct{}]()))
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin(nil))
+
+	// the chunked body needs no fix, so the headers are not held
+	stream := host.NewHttpStream()
+	assert.Equal(t, types.ActionContinue, stream.CallOnRequestHeaders([][2]string{
+		{":authority", "example.com"}, {":path", "/"}, {"transfer-encoding", "chunked"},
+	}, false))
+	assert.Equal(t, types.ActionContinue, stream.CallOnRequestBody([]byte("hello"), true))
+	assert.Equal(t, "hello world", string(stream.RequestBody()))
+	_, ok := stream.RequestHeader("content-length")
+	assert.False(t, ok)
+}
+
+func TestReplaceBodyWithoutHoldingHeaders(t *testing.T) {
+	host, reset := NewHost(newBodyMutationVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin(nil))
+
+	// the headers are not held unless the plugin opts in, the stale content-length is warned
+	stream := host.NewHttpStream()
+	assert.Equal(t, types.ActionContinue, stream.CallOnRequestHeaders([][2]string{
+		{":authority", "example.com"}, {":path", "/"}, {"content-length", "5"},
+	}, false))
+	assert.Equal(t, types.ActionContinue, stream.CallOnRequestBody([]byte("hello"), true))
+	assert.Equal(t, "hello world", string(stream.RequestBody()))
+	length, _ := stream.RequestHeader("content-length")
+	assert.Equal(t, "5", length)
+	assert.Len(t, host.Logs(wrapper.LogLevelWarn), 1)
+}
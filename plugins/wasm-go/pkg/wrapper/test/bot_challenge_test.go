@@ -0,0 +1,100 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+func TestBotChallenge(t *testing.T) {
+	challenger, err := wrapper.NewChallenger(wrapper.ChallengeConfig{Secret: "s3cret", Difficulty: 6, ClientIPHeader: "x-real-ip"},
+		wrapper.NewSharedDataNonceCache("challenge", 300000))
+	require.NoError(t, err)
+	vmctx := wrapper.NewCommonVmCtx("challenge",
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config struct{}, log wrapper.Log) types.Action {
+			return challenger.Handle(ctx)
+		}),
+	)
+	host, reset := NewHost(vmctx)
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin(nil))
+
+	send := func(ip, cookie string) *HttpStream {
+		stream := host.NewHttpStream()
+		headers := [][2]string{{":authority", "example.com"}, {":method", "GET"}, {":path", "/shop?item=1"},
+			{"user-agent", "Mozilla/5.0"}, {"x-real-ip", ip}}
+		if cookie != "" {
+			headers = append(headers, [2]string{"cookie", cookie})
+		}
+		stream.CallOnRequestHeaders(headers, true)
+		return stream
+	}
+
+	response := send("1.2.3.4", "").LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, uint32(403), response.StatusCode)
+	assert.Equal(t, "no-store", localResponseHeader(response, "cache-control"))
+	body := string(response.Body)
+	assert.Contains(t, body, "difficulty = 6,")
+	assert.Contains(t, body, `document.cookie = "higress_challenge_solution=" + token`)
+	match := regexp.MustCompile(`var token = "([^"]+)"`).FindStringSubmatch(body)
+	require.Len(t, match, 2)
+	token := match[1]
+	nonce := 0
+	for wrapper.ChallengeLeadingZeros(token, strconv.Itoa(nonce)) < 6 {
+		nonce++
+	}
+	solution := "higress_challenge_solution=" + token + ":" + strconv.Itoa(nonce)
+
+	// the solution is bound to the client which got the challenge
+	response = send("5.6.7.8", solution).LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, uint32(403), response.StatusCode)
+
+	response = send("1.2.3.4", solution).LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, uint32(302), response.StatusCode)
+	assert.Equal(t, "/shop?item=1", localResponseHeader(response, "location"))
+	var clearance string
+	for _, header := range response.Headers {
+		if header[0] == "set-cookie" && strings.HasPrefix(header[1], "higress_challenge=") {
+			clearance, _, _ = strings.Cut(header[1], ";")
+		}
+	}
+	require.NotEmpty(t, clearance)
+
+	// a solution is only accepted once
+	response = send("1.2.3.4", solution).LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, uint32(403), response.StatusCode)
+
+	stream := send("1.2.3.4", "lang=en; "+clearance)
+	assert.Nil(t, stream.LocalResponse())
+	assert.Equal(t, types.ActionContinue, stream.Action())
+	response = send("5.6.7.8", clearance).LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, uint32(403), response.StatusCode)
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+type chainConfig struct {
+	client wrapper.HttpClient
+}
+
+func calloutStep(client wrapper.HttpClient, path string) wrapper.ChainStepFunc {
+	return func(step *wrapper.ChainStep) error {
+		return client.Get(path, nil, func(statusCode int, headers http.Header, body []byte) {
+			if statusCode != http.StatusOK {
+				step.Fail(fmt.Errorf("status %d", statusCode))
+				return
+			}
+			_ = proxywasm.AddHttpRequestHeader("x-"+step.Name(), string(body))
+			step.Done()
+		}, step.Timeout())
+	}
+}
+
+func newChainVmCtx() *wrapper.CommonVmCtx[chainConfig] {
+	return wrapper.NewCommonVmCtx("chain-plugin",
+		wrapper.ParseConfigBy(func(json gjson.Result, config *chainConfig, log wrapper.Log) error {
+			config.client = wrapper.NewClusterClient(wrapper.FQDNCluster{FQDN: "auth.dns", Port: 80})
+			return nil
+		}),
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config chainConfig, log wrapper.Log) types.Action {
+			chain := wrapper.NewChain(ctx).
+				Then("introspect", calloutStep(config.client, "/introspect"), wrapper.WithStepTimeout(300)).
+				Then("local", func(step *wrapper.ChainStep) error {
+					if ctx.Path() == "/local-error" {
+						return errors.New("local error")
+					}
+					step.Done()
+					return nil
+				}).
+				Then("quota", calloutStep(config.client, "/quota")).
+				Then("enrich", calloutStep(config.client, "/enrich"), wrapper.ContinueOnError()).
+				WithTimeout(1000)
+			if ctx.Path() == "/custom" {
+				chain.OnError(func(step string, err error) types.Action {
+					_ = proxywasm.SendHttpResponse(http.StatusForbidden, nil, []byte(step+": "+err.Error()), -1)
+					return types.ActionPause
+				})
+			}
+			return chain.Run()
+		}),
+	)
+}
+
+func startChainStream(t *testing.T, path string) (*Host, *HttpStream, func()) {
+	host, reset := NewHost(newChainVmCtx())
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin(nil))
+	stream := host.NewHttpStream()
+	assert.Equal(t, types.ActionPause, stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", path}}, true))
+	return host, stream, reset
+}
+
+func replyCallout(t *testing.T, host *Host, path string, status string, body string) *HttpCallout {
+	callouts := host.HttpCallouts()
+	require.Len(t, callouts, 1)
+	callout := callouts[0]
+	require.Equal(t, path, callout.Header(":path"))
+	host.CallOnHttpCallResponse(callout.ID, [][2]string{{":status", status}}, []byte(body))
+	return callout
+}
+
+func TestCalloutChain(t *testing.T) {
+	host, stream, reset := startChainStream(t, "/")
+	defer reset()
+
+	callout := replyCallout(t, host, "/introspect", "200", "alice")
+	assert.Equal(t, uint32(300), callout.Timeout)
+	assert.Equal(t, types.ActionPause, stream.Action())
+	callout = replyCallout(t, host, "/quota", "200", "ok")
+	assert.LessOrEqual(t, callout.Timeout, uint32(1000))
+	assert.Equal(t, types.ActionPause, stream.Action())
+	// the failure of enrich is ignored
+	replyCallout(t, host, "/enrich", "503", "")
+	assert.Equal(t, types.ActionContinue, stream.Action())
+	assert.Nil(t, stream.LocalResponse())
+	user, _ := stream.RequestHeader("x-introspect")
+	assert.Equal(t, "alice", user)
+	quota, _ := stream.RequestHeader("x-quota")
+	assert.Equal(t, "ok", quota)
+	_, ok := stream.RequestHeader("x-enrich")
+	assert.False(t, ok)
+}
+
+func TestCalloutChainFailure(t *testing.T) {
+	host, stream, reset := startChainStream(t, "/")
+	defer reset()
+
+	replyCallout(t, host, "/introspect", "401", "")
+	assert.Empty(t, host.HttpCallouts())
+	response := stream.LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, uint32(http.StatusInternalServerError), response.StatusCode)
+	assert.Equal(t, types.ActionPause, stream.Action())
+}
+
+func TestCalloutChainDispatchFailure(t *testing.T) {
+	host, stream, reset := startChainStream(t, "/local-error")
+	defer reset()
+
+	replyCallout(t, host, "/introspect", "200", "alice")
+	assert.Empty(t, host.HttpCallouts())
+	require.NotNil(t, stream.LocalResponse())
+	assert.Equal(t, uint32(http.StatusInternalServerError), stream.LocalResponse().StatusCode)
+}
+
+func TestCalloutChainOnError(t *testing.T) {
+	host, stream, reset := startChainStream(t, "/custom")
+	defer reset()
+
+	replyCallout(t, host, "/introspect", "200", "alice")
+	replyCallout(t, host, "/quota", "429", "")
+	response := stream.LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, uint32(http.StatusForbidden), response.StatusCode)
+	assert.Equal(t, "quota: status 429", string(response.Body))
+}
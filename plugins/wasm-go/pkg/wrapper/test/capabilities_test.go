@@ -0,0 +1,78 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+func TestHostCapabilities(t *testing.T) {
+	host, reset := NewHost(wrapper.NewCommonVmCtx("capabilities",
+		wrapper.ParseConfigBy(func(json gjson.Result, config *struct{}, log wrapper.Log) error {
+			return nil
+		}),
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config struct{}, log wrapper.Log) types.Action {
+			ctx.SetRequestBodyBufferLimit(1024)
+			ctx.DisableReroute()
+			return types.ActionContinue
+		}),
+	))
+	defer reset()
+	host.SetProperty([]string{"plugin_name"}, []byte("capabilities"))
+	host.RejectProperty([]string{"set_decoder_buffer_limit"})
+	host.RegisterForeignFunction("get_version", func(param []byte) []byte { return []byte("1.0") })
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{}`)))
+
+	assert.Equal(t, wrapper.CapabilitySupported, wrapper.HostCapability(wrapper.CapabilityPluginName))
+	assert.Equal(t, wrapper.CapabilityUnsupported, wrapper.HostCapability(wrapper.CapabilityPluginVmID))
+	assert.Equal(t, wrapper.CapabilityUnknown, wrapper.HostCapability(wrapper.CapabilityDecoderBufferLimit))
+
+	capabilities := wrapper.ProbeHostCapabilities("get_version", "missing")
+	assert.Equal(t, wrapper.CapabilitySupported, capabilities[wrapper.ForeignFunctionCapability("get_version")])
+	assert.Equal(t, wrapper.CapabilityUnsupported, capabilities[wrapper.ForeignFunctionCapability("missing")])
+
+	for i := 0; i < 2; i++ {
+		stream := host.NewHttpStream()
+		stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}}, true)
+		_, ok := stream.GetProperty([]string{"clear_route_cache"})
+		assert.True(t, ok)
+	}
+	assert.Equal(t, wrapper.CapabilityUnsupported, wrapper.HostCapability(wrapper.CapabilityDecoderBufferLimit))
+	assert.Equal(t, wrapper.CapabilitySupported, wrapper.HostCapability(wrapper.CapabilityClearRouteCache))
+	warnings := 0
+	for _, message := range host.Logs(wrapper.LogLevelWarn) {
+		if strings.Contains(message, "set_decoder_buffer_limit") {
+			warnings++
+		}
+	}
+	assert.Equal(t, 1, warnings)
+
+	dumps := wrapper.DumpProperties("plugin_name", "plugin_vm_id")
+	assert.Equal(t, []wrapper.PropertyDump{
+		{Path: "plugin_name", Found: true, Value: "capabilities"},
+		{Path: "plugin_vm_id"},
+	}, dumps)
+	assert.Len(t, wrapper.DumpProperties(), len(wrapper.DefaultDumpedProperties))
+}
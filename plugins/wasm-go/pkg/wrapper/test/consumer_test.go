@@ -0,0 +1,70 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+type consumerConfig struct{}
+
+func newConsumerVmCtx() *wrapper.CommonVmCtx[consumerConfig] {
+	return wrapper.NewCommonVmCtx("consumer",
+		wrapper.ParseConfigBy(func(json gjson.Result, config *consumerConfig, log wrapper.Log) error {
+			return nil
+		}),
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config consumerConfig, log wrapper.Log) types.Action {
+			wrapper.SetAuthenticatedConsumer(ctx, &wrapper.Consumer{Name: "alice"})
+			if ctx.Path() == "/revoked" {
+				wrapper.SetAuthenticatedConsumer(ctx, nil)
+			}
+			if consumer := wrapper.GetAuthenticatedConsumer(ctx); consumer != nil {
+				_ = proxywasm.AddHttpRequestHeader("x-consumer-name", consumer.Name)
+			}
+			return types.ActionContinue
+		}),
+	)
+}
+
+func TestAuthenticatedConsumer(t *testing.T) {
+	host, reset := NewHost(newConsumerVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{}`)))
+
+	stream := host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}}, true)
+	value, _ := stream.RequestHeader(wrapper.ConsumerHeader)
+	assert.Equal(t, "alice", value)
+	value, _ = stream.RequestHeader("x-consumer-name")
+	assert.Equal(t, "alice", value)
+
+	// a nil consumer clears the consumer and the header
+	stream = host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/revoked"}}, true)
+	_, found := stream.RequestHeader(wrapper.ConsumerHeader)
+	assert.False(t, found)
+	_, found = stream.RequestHeader("x-consumer-name")
+	assert.False(t, found)
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+type deadlineConfig struct {
+	client wrapper.HttpClient
+}
+
+func newDeadlineVmCtx() *wrapper.CommonVmCtx[deadlineConfig] {
+	return wrapper.NewCommonVmCtx("deadline-plugin",
+		wrapper.ParseConfigBy(func(json gjson.Result, config *deadlineConfig, log wrapper.Log) error {
+			config.client = wrapper.NewClusterClient(wrapper.FQDNCluster{FQDN: "auth.dns", Port: 80})
+			wrapper.SetDefaultRouteTimeout(time.Duration(json.Get("routeTimeout").Int()) * time.Millisecond)
+			wrapper.SetTrustTimeoutHeaders(json.Get("trustTimeoutHeaders").Bool())
+			return nil
+		}),
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config deadlineConfig, log wrapper.Log) types.Action {
+			if ctx.Path() == "/grpc" {
+				headers := [][2]string{{"content-type", "application/grpc"}, {"grpc-timeout", "1H"}}
+				_ = config.client.Post("/pkg.Service/Method", headers, nil, func(statusCode int, headers http.Header, body []byte) {}, 10000)
+				return types.ActionContinue
+			}
+			err := config.client.Get("/first", nil, func(statusCode int, headers http.Header, body []byte) {
+				// the callout of the callback is capped too
+				_ = config.client.Get("/second", nil, func(statusCode int, headers http.Header, body []byte) {
+					_ = proxywasm.ResumeHttpRequest()
+				}, 10000)
+			}, 10000)
+			if err != nil {
+				_ = proxywasm.SendHttpResponse(http.StatusGatewayTimeout, nil, []byte(err.Error()), -1)
+			}
+			return types.ActionPause
+		}),
+		wrapper.ProcessResponseHeadersBy(func(ctx wrapper.HttpContext, config deadlineConfig, log wrapper.Log) types.Action {
+			_ = config.client.Get("/response", nil, func(statusCode int, headers http.Header, body []byte) {}, 10000)
+			return types.ActionContinue
+		}),
+	)
+}
+
+func TestDeadlineCapsCallouts(t *testing.T) {
+	host, reset := NewHost(newDeadlineVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{"routeTimeout": 3000}`)))
+
+	stream := host.NewHttpStream()
+	assert.Equal(t, types.ActionPause, stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}}, true))
+	callouts := host.HttpCallouts()
+	require.Len(t, callouts, 1)
+	assert.LessOrEqual(t, callouts[0].Timeout, uint32(3000))
+	assert.Greater(t, callouts[0].Timeout, uint32(2000))
+	host.CallOnHttpCallResponse(callouts[0].ID, [][2]string{{":status", "200"}}, nil)
+	callouts = host.HttpCallouts()
+	require.Len(t, callouts, 1)
+	assert.Equal(t, "/second", callouts[0].Header(":path"))
+	assert.LessOrEqual(t, callouts[0].Timeout, uint32(3000))
+	host.CallOnHttpCallResponse(callouts[0].ID, [][2]string{{":status", "200"}}, nil)
+	assert.Equal(t, types.ActionContinue, stream.Action())
+
+	// the response phases are not capped
+	stream.CallOnResponseHeaders([][2]string{{":status", "200"}}, true)
+	callouts = host.HttpCallouts()
+	require.Len(t, callouts, 1)
+	assert.Equal(t, uint32(10000), callouts[0].Timeout)
+}
+
+func TestDeadlineFromHeader(t *testing.T) {
+	host, reset := NewHost(newDeadlineVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{"routeTimeout": 3000}`)))
+
+	// the timeout headers are set by the client, they're ignored unless trusted
+	stream := host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}, {wrapper.UpstreamTimeoutHeader, "1"}}, true)
+	callouts := host.HttpCallouts()
+	require.Len(t, callouts, 1)
+	assert.Greater(t, callouts[0].Timeout, uint32(2000))
+
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{"trustTimeoutHeaders": true}`)))
+	stream = host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}, {wrapper.UpstreamTimeoutHeader, "200"}}, true)
+	callouts = host.HttpCallouts()
+	require.Len(t, callouts, 2)
+	assert.LessOrEqual(t, callouts[1].Timeout, uint32(200))
+
+	// no deadline without a route timeout
+	stream = host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}}, true)
+	callouts = host.HttpCallouts()
+	require.Len(t, callouts, 3)
+	assert.Equal(t, uint32(10000), callouts[2].Timeout)
+}
+
+func TestDeadlineOfMatchedRule(t *testing.T) {
+	host, reset := NewHost(newDeadlineVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{
+		"routeTimeout": 3000,
+		"_rules_": [{"_match_route_": ["fast"], "routeTimeout": 200}, {"_match_route_": ["stream"], "routeTimeout": 0}]
+	}`)))
+
+	for i, c := range []struct {
+		route string
+		min   uint32
+		max   uint32
+	}{
+		{route: "fast", min: 100, max: 200},
+		{route: "other", min: 2000, max: 3000},
+		{route: "stream", min: 10000, max: 10000},
+	} {
+		host.SetProperty([]string{"route_name"}, []byte(c.route))
+		stream := host.NewHttpStream()
+		stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}}, true)
+		callouts := host.HttpCallouts()
+		require.Len(t, callouts, i+1, c.route)
+		assert.GreaterOrEqual(t, callouts[i].Timeout, c.min, c.route)
+		assert.LessOrEqual(t, callouts[i].Timeout, c.max, c.route)
+	}
+
+	// the timeouts of the previous config don't survive the reload
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{"header": "x-test"}`)))
+	host.SetProperty([]string{"route_name"}, []byte("fast"))
+	stream := host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}}, true)
+	callouts := host.HttpCallouts()
+	require.Len(t, callouts, 4)
+	assert.Equal(t, uint32(10000), callouts[3].Timeout)
+}
+
+func TestDeadlineCapsGrpcTimeout(t *testing.T) {
+	host, reset := NewHost(newDeadlineVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{"routeTimeout": 3000}`)))
+
+	stream := host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/grpc"}}, true)
+	callouts := host.HttpCallouts()
+	require.Len(t, callouts, 1)
+	assert.LessOrEqual(t, callouts[0].Timeout, uint32(3000))
+	assert.Equal(t, fmt.Sprintf("%dm", callouts[0].Timeout), callouts[0].Header("grpc-timeout"))
+}
+
+func TestDeadlineExceeded(t *testing.T) {
+	host, reset := NewHost(newDeadlineVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{"routeTimeout": 500}`)))
+	start := make([]byte, 8)
+	binary.LittleEndian.PutUint64(start, uint64(time.Now().Add(-time.Second).UnixNano()))
+	host.SetProperty([]string{"request", "time"}, start)
+
+	stream := host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}}, true)
+	assert.Empty(t, host.HttpCallouts())
+	response := stream.LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, uint32(http.StatusGatewayTimeout), response.StatusCode)
+	assert.Equal(t, wrapper.ErrDeadlineExceeded.Error(), string(response.Body))
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+func newDebugVmCtx() *wrapper.CommonVmCtx[testConfig] {
+	return wrapper.NewCommonVmCtx("debug-plugin",
+		wrapper.ParseConfigBy(func(json gjson.Result, config *testConfig, log wrapper.Log) error {
+			config.header = json.Get("header").String()
+			return nil
+		}),
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config testConfig, log wrapper.Log) types.Action {
+			wrapper.DebugDecision(ctx, "tagged with %s", config.header)
+			return types.ActionContinue
+		}),
+	)
+}
+
+func TestHostDebugDump(t *testing.T) {
+	host, reset := NewHost(newDebugVmCtx())
+	defer reset()
+	assert.Equal(t, types.OnPluginStartStatusFailed, host.StartPlugin([]byte(`{"_debug_": {"output": "header"}}`)))
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{
+		"_debug_": {"secret": "s3cret", "output": "header"},
+		"_rules_": [{"_match_route_": ["route-a"], "header": "x-a"}]
+	}`)))
+
+	host.SetProperty([]string{"route_name"}, []byte("route-a"))
+	stream := host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}, {"x-higress-debug", "s3cret"}}, true)
+	stream.CallOnResponseHeaders([][2]string{{":status", "200"}}, true)
+	dump, ok := stream.ResponseHeader(wrapper.DefaultDebugDumpHeader)
+	require.True(t, ok)
+	_, ok = stream.RequestHeader(wrapper.DefaultDebugHeader)
+	assert.False(t, ok)
+	assert.Equal(t, "debug-plugin", gjson.Get(dump, "plugin").String())
+	assert.Equal(t, int64(0), gjson.Get(dump, "rule.index").Int())
+	assert.Equal(t, "route", gjson.Get(dump, "rule.category").String())
+	assert.Equal(t, "route-a", gjson.Get(dump, "rule.match.0").String())
+	assert.Len(t, gjson.Get(dump, "rule.fingerprint").String(), 16)
+	assert.Equal(t, "request-headers", gjson.Get(dump, "phases.0.phase").String())
+	assert.Equal(t, "continue", gjson.Get(dump, "phases.0.action").String())
+	assert.Equal(t, "tagged with x-a", gjson.Get(dump, "decisions.0").String())
+
+	host.SetProperty([]string{"route_name"}, []byte("route-b"))
+	stream = host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}, {"x-higress-debug", "s3cret"}}, true)
+	stream.CallOnResponseHeaders([][2]string{{":status", "200"}}, true)
+	dump, ok = stream.ResponseHeader(wrapper.DefaultDebugDumpHeader)
+	require.True(t, ok)
+	assert.Equal(t, gjson.Null, gjson.Get(dump, "rule").Type)
+	assert.Equal(t, "no rule is matched", gjson.Get(dump, "decisions.0").String())
+
+	stream = host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}, {"x-higress-debug", "wrong"}}, true)
+	stream.CallOnResponseHeaders([][2]string{{":status", "200"}}, true)
+	_, ok = stream.ResponseHeader(wrapper.DefaultDebugDumpHeader)
+	assert.False(t, ok)
+	_, ok = stream.RequestHeader(wrapper.DefaultDebugHeader)
+	assert.False(t, ok)
+}
+
+func TestHostDebugDumpLog(t *testing.T) {
+	host, reset := NewHost(newDebugVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{"_debug_": {"secret": "s3cret"}}`)))
+	stream := host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}, {"x-higress-debug", "s3cret"}}, true)
+	stream.CallOnResponseHeaders([][2]string{{":status", "200"}}, true)
+	stream.Complete()
+	_, ok := stream.ResponseHeader(wrapper.DefaultDebugDumpHeader)
+	assert.False(t, ok)
+	logs := host.Logs(wrapper.LogLevelInfo)
+	require.NotEmpty(t, logs)
+	assert.Contains(t, logs[len(logs)-1], `"category":"global"`)
+}
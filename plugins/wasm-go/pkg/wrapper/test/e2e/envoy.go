@@ -0,0 +1,430 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+// Package e2e runs a compiled plugin in a real Envoy, so the plugins can be tested against the host ABI of the
+// proxy rather than the fake host of the test package:
+//
+//	envoy := e2e.Start(t, e2e.Config{
+//		WasmPath:     "../../extensions/my-plugin/plugin.wasm",
+//		PluginConfig: json.RawMessage(`{"key": "value"}`),
+//	})
+//	response := envoy.Get(t, "/get", nil)
+//	envoy.WaitAccessLog(t, `"GET /get" 200`, 5*time.Second)
+//
+// Envoy is run from the binary of the ENVOY_BIN environment variable or found in PATH, otherwise from the docker
+// image of the ENVOY_IMAGE environment variable. The test is skipped if neither is available. The plugins using
+// the Higress extensions of the ABI, e.g. the Redis calls, need the Higress gateway image.
+package e2e
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	DefaultEnvoyImage      = "envoyproxy/envoy:v1.27-latest"
+	DefaultRouteName       = "e2e-route"
+	DefaultAccessLogFormat = "\"%REQ(:METHOD)% %REQ(X-ENVOY-ORIGINAL-PATH?:PATH)%\" %RESPONSE_CODE% %RESPONSE_FLAGS% %RESPONSE_CODE_DETAILS%\n"
+
+	upstreamCluster = "upstream"
+	readyTimeout    = 30 * time.Second
+)
+
+type Config struct {
+	// The compiled plugin, e.g. extensions/{name}/plugin.wasm
+	WasmPath     string
+	PluginName   string
+	PluginConfig json.RawMessage
+	// The address of the upstream, an echo server is started if it's empty
+	Upstream        string
+	RouteName       string
+	AccessLogFormat string
+	// Envoy is run from the binary if set, otherwise from the docker image if set
+	EnvoyBinary string
+	DockerImage string
+	// The extra arguments passed to Envoy, e.g. --component-log-level wasm:debug
+	ExtraArgs []string
+}
+
+// Bootstrap is the Envoy bootstrap generated from the config, listening on the ports.
+func Bootstrap(config Config, listenPort, adminPort int, accessLogPath string) ([]byte, error) {
+	if config.WasmPath == "" {
+		return nil, errors.New("missing wasm path")
+	}
+	wasmPath, err := filepath.Abs(config.WasmPath)
+	if err != nil {
+		return nil, err
+	}
+	upstreamHost, port, err := net.SplitHostPort(config.Upstream)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream %s: %v", config.Upstream, err)
+	}
+	upstreamPort, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream port %s: %v", port, err)
+	}
+	pluginConfig := "{}"
+	if len(config.PluginConfig) > 0 {
+		if !json.Valid(config.PluginConfig) {
+			return nil, errors.New("the plugin config is not a valid json")
+		}
+		pluginConfig = string(config.PluginConfig)
+	}
+	routeName := config.RouteName
+	if routeName == "" {
+		routeName = DefaultRouteName
+	}
+	accessLogFormat := config.AccessLogFormat
+	if accessLogFormat == "" {
+		accessLogFormat = DefaultAccessLogFormat
+	}
+	type object = map[string]interface{}
+	type array = []interface{}
+	bootstrap := object{
+		"admin": object{
+			"address": socketAddress("127.0.0.1", adminPort),
+		},
+		"static_resources": object{
+			"listeners": array{object{
+				"name":    "main",
+				"address": socketAddress("127.0.0.1", listenPort),
+				"filter_chains": array{object{
+					"filters": array{object{
+						"name": "envoy.filters.network.http_connection_manager",
+						"typed_config": object{
+							"@type":       "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager",
+							"stat_prefix": "ingress_http",
+							"access_log": array{object{
+								"name": "envoy.access_loggers.file",
+								"typed_config": object{
+									"@type": "type.googleapis.com/envoy.extensions.access_loggers.file.v3.FileAccessLog",
+									"path":  accessLogPath,
+									"log_format": object{
+										"text_format_source": object{"inline_string": accessLogFormat},
+									},
+								},
+							}},
+							"route_config": object{
+								"name": "local_route",
+								"virtual_hosts": array{object{
+									"name":    "local_service",
+									"domains": array{"*"},
+									"routes": array{object{
+										"name":  routeName,
+										"match": object{"prefix": "/"},
+										"route": object{"cluster": upstreamCluster},
+									}},
+								}},
+							},
+							"http_filters": array{
+								object{
+									"name": "envoy.filters.http.wasm",
+									"typed_config": object{
+										"@type": "type.googleapis.com/envoy.extensions.filters.http.wasm.v3.Wasm",
+										"config": object{
+											"name": config.PluginName,
+											"vm_config": object{
+												"runtime": "envoy.wasm.runtime.v8",
+												"code":    object{"local": object{"filename": wasmPath}},
+											},
+											"configuration": object{
+												"@type": "type.googleapis.com/google.protobuf.StringValue",
+												"value": pluginConfig,
+											},
+										},
+									},
+								},
+								object{
+									"name": "envoy.filters.http.router",
+									"typed_config": object{
+										"@type": "type.googleapis.com/envoy.extensions.filters.http.router.v3.Router",
+									},
+								},
+							},
+						},
+					}},
+				}},
+			}},
+			"clusters": array{object{
+				"name":            upstreamCluster,
+				"connect_timeout": "5s",
+				"type":            "STRICT_DNS",
+				"load_assignment": object{
+					"cluster_name": upstreamCluster,
+					"endpoints": array{object{
+						"lb_endpoints": array{object{
+							"endpoint": object{"address": socketAddress(upstreamHost, upstreamPort)},
+						}},
+					}},
+				},
+			}},
+		},
+	}
+	return json.MarshalIndent(bootstrap, "", "  ")
+}
+
+func socketAddress(address string, port int) map[string]interface{} {
+	return map[string]interface{}{
+		"socket_address": map[string]interface{}{"address": address, "port_value": port},
+	}
+}
+
+// Envoy is a running Envoy with the plugin, it's stopped when the test finishes.
+type Envoy struct {
+	BaseURL       string
+	AdminURL      string
+	accessLogPath string
+	cmd           *exec.Cmd
+	output        *syncBuffer
+	client        *http.Client
+}
+
+// Start runs Envoy with the plugin, the test is skipped if Envoy can't be found.
+func Start(t *testing.T, config Config) *Envoy {
+	t.Helper()
+	if config.EnvoyBinary == "" && config.DockerImage == "" {
+		config.EnvoyBinary, config.DockerImage = findEnvoy()
+	}
+	if config.EnvoyBinary == "" && config.DockerImage == "" {
+		t.Skip("neither envoy nor docker is available, set ENVOY_BIN or ENVOY_IMAGE to run the e2e tests")
+	}
+	if config.Upstream == "" {
+		upstream := httptest.NewServer(http.HandlerFunc(echo))
+		t.Cleanup(upstream.Close)
+		config.Upstream = upstream.Listener.Addr().String()
+	}
+	dir := t.TempDir()
+	envoy := &Envoy{
+		accessLogPath: filepath.Join(dir, "access.log"),
+		output:        &syncBuffer{},
+		client:        &http.Client{Timeout: 30 * time.Second},
+	}
+	listenPort, adminPort := freePort(t), freePort(t)
+	bootstrap, err := Bootstrap(config, listenPort, adminPort, envoy.accessLogPath)
+	if err != nil {
+		t.Fatalf("generate envoy bootstrap failed: %v", err)
+	}
+	bootstrapPath := filepath.Join(dir, "envoy.json")
+	if err := os.WriteFile(bootstrapPath, bootstrap, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// the base id keeps the shared memory of the concurrent Envoy processes apart
+	args := append([]string{"-c", bootstrapPath, "--base-id", fmt.Sprint(adminPort)}, config.ExtraArgs...)
+	if config.EnvoyBinary != "" {
+		envoy.cmd = exec.Command(config.EnvoyBinary, args...)
+	} else {
+		wasmPath, _ := filepath.Abs(config.WasmPath)
+		dockerArgs := []string{"run", "--rm", "--network", "host", "--user", fmt.Sprintf("%d", os.Getuid()),
+			"-v", dir + ":" + dir, "-v", wasmPath + ":" + wasmPath + ":ro", config.DockerImage, "envoy"}
+		envoy.cmd = exec.Command("docker", append(dockerArgs, args...)...)
+	}
+	envoy.cmd.Stdout = envoy.output
+	envoy.cmd.Stderr = envoy.output
+	if err := envoy.cmd.Start(); err != nil {
+		t.Fatalf("start envoy failed: %v", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- envoy.cmd.Wait() }()
+	t.Cleanup(func() {
+		_ = envoy.cmd.Process.Signal(os.Interrupt)
+		select {
+		case <-exited:
+		case <-time.After(10 * time.Second):
+			_ = envoy.cmd.Process.Kill()
+			<-exited
+		}
+		if t.Failed() {
+			t.Logf("envoy output:\n%s", envoy.output.String())
+		}
+	})
+	envoy.BaseURL = fmt.Sprintf("http://127.0.0.1:%d", listenPort)
+	envoy.AdminURL = fmt.Sprintf("http://127.0.0.1:%d", adminPort)
+	envoy.waitReady(t, exited)
+	return envoy
+}
+
+func findEnvoy() (binary, image string) {
+	if binary = os.Getenv("ENVOY_BIN"); binary != "" {
+		return binary, ""
+	}
+	if image = os.Getenv("ENVOY_IMAGE"); image != "" {
+		return "", image
+	}
+	if path, err := exec.LookPath("envoy"); err == nil {
+		return path, ""
+	}
+	if _, err := exec.LookPath("docker"); err == nil {
+		return "", DefaultEnvoyImage
+	}
+	return "", ""
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func (e *Envoy) waitReady(t *testing.T, exited chan error) {
+	t.Helper()
+	deadline := time.Now().Add(readyTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-exited:
+			exited <- err
+			t.Fatalf("envoy exited: %v\n%s", err, e.output.String())
+		default:
+		}
+		response, err := e.client.Get(e.AdminURL + "/ready")
+		if err == nil {
+			response.Body.Close()
+			if response.StatusCode == http.StatusOK {
+				return
+			}
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	t.Fatalf("envoy is not ready in %s\n%s", readyTimeout, e.output.String())
+}
+
+// syncBuffer collects the output of Envoy while it's read by the test.
+type syncBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.String()
+}
+
+// Response is the response read fully.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Do sends the request to Envoy, the URL of the request is relative to Envoy, e.g. /path?query.
+func (e *Envoy) Do(t *testing.T, request *http.Request) *Response {
+	t.Helper()
+	target, err := request.URL.Parse(e.BaseURL + request.URL.RequestURI())
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.URL = target
+	response, err := e.client.Do(request)
+	if err != nil {
+		t.Fatalf("request %s failed: %v", target, err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("read the response of %s failed: %v", target, err)
+	}
+	return &Response{StatusCode: response.StatusCode, Header: response.Header, Body: body}
+}
+
+func (e *Envoy) Get(t *testing.T, path string, header http.Header) *Response {
+	t.Helper()
+	return e.send(t, http.MethodGet, path, header, nil)
+}
+
+func (e *Envoy) Post(t *testing.T, path string, header http.Header, body []byte) *Response {
+	t.Helper()
+	return e.send(t, http.MethodPost, path, header, body)
+}
+
+func (e *Envoy) send(t *testing.T, method, path string, header http.Header, body []byte) *Response {
+	t.Helper()
+	request, err := http.NewRequest(method, e.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	return e.Do(t, request)
+}
+
+// AccessLogs returns the access logs written so far.
+func (e *Envoy) AccessLogs() []string {
+	data, err := os.ReadFile(e.accessLogPath)
+	if err != nil {
+		return nil
+	}
+	return strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+}
+
+// WaitAccessLog waits for an access log containing the text, since Envoy flushes the access logs periodically.
+func (e *Envoy) WaitAccessLog(t *testing.T, text string, timeout time.Duration) string {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		for _, line := range e.AccessLogs() {
+			if strings.Contains(line, text) {
+				return line
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no access log contains %q in %s, access logs:\n%s", text, timeout, strings.Join(e.AccessLogs(), "\n"))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Output returns the logs of Envoy, including the logs of the plugin.
+func (e *Envoy) Output() string {
+	return e.output.String()
+}
+
+// echo responds with the request as json, so the tests can check the request received by the upstream.
+func echo(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"method":  r.Method,
+		"path":    r.URL.RequestURI(),
+		"host":    r.Host,
+		"headers": r.Header,
+		"body":    string(body),
+	})
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package e2e
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestBootstrap(t *testing.T) {
+	data, err := Bootstrap(Config{
+		WasmPath:     "/plugins/test.wasm",
+		PluginName:   "test",
+		PluginConfig: json.RawMessage(`{"header": "x-test"}`),
+		Upstream:     "127.0.0.1:8080",
+	}, 10000, 10001, "/tmp/access.log")
+	require.NoError(t, err)
+	bootstrap := gjson.ParseBytes(data)
+	assert.Equal(t, int64(10001), bootstrap.Get("admin.address.socket_address.port_value").Int())
+	listener := bootstrap.Get("static_resources.listeners.0")
+	assert.Equal(t, int64(10000), listener.Get("address.socket_address.port_value").Int())
+	manager := listener.Get("filter_chains.0.filters.0.typed_config")
+	assert.Equal(t, "/tmp/access.log", manager.Get("access_log.0.typed_config.path").String())
+	assert.Equal(t, DefaultRouteName, manager.Get("route_config.virtual_hosts.0.routes.0.name").String())
+	wasm := manager.Get("http_filters.0.typed_config.config")
+	assert.Equal(t, "test", wasm.Get("name").String())
+	assert.Equal(t, "/plugins/test.wasm", wasm.Get("vm_config.code.local.filename").String())
+	assert.Equal(t, `{"header": "x-test"}`, wasm.Get("configuration.value").String())
+	assert.Equal(t, "envoy.filters.http.router", manager.Get("http_filters.1.name").String())
+	endpoint := bootstrap.Get("static_resources.clusters.0.load_assignment.endpoints.0.lb_endpoints.0.endpoint")
+	assert.Equal(t, "127.0.0.1", endpoint.Get("address.socket_address.address").String())
+	assert.Equal(t, int64(8080), endpoint.Get("address.socket_address.port_value").Int())
+
+	_, err = Bootstrap(Config{Upstream: "127.0.0.1:8080"}, 10000, 10001, "")
+	assert.Error(t, err)
+	_, err = Bootstrap(Config{WasmPath: "test.wasm", Upstream: "127.0.0.1"}, 10000, 10001, "")
+	assert.Error(t, err)
+	_, err = Bootstrap(Config{WasmPath: "test.wasm", Upstream: "127.0.0.1:8080", PluginConfig: json.RawMessage(`{`)}, 10000, 10001, "")
+	assert.Error(t, err)
+}
+
+// TestEnvoy runs the plugin of E2E_WASM_PATH, e.g. E2E_WASM_PATH=../../../../extensions/hello-world/plugin.wasm
+func TestEnvoy(t *testing.T) {
+	wasmPath := os.Getenv("E2E_WASM_PATH")
+	if wasmPath == "" {
+		t.Skip("E2E_WASM_PATH is not set")
+	}
+	envoy := Start(t, Config{WasmPath: wasmPath, PluginName: "e2e"})
+	response := envoy.Get(t, "/e2e?key=value", http.Header{"X-Test": {"on"}})
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "/e2e?key=value", gjson.GetBytes(response.Body, "path").String())
+	envoy.WaitAccessLog(t, `"GET /e2e?key=value" 200`, 10*time.Second)
+}
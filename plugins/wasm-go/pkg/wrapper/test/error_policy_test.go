@@ -0,0 +1,176 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+func newErrorPolicyVmCtx() *wrapper.CommonVmCtx[struct{}] {
+	return wrapper.NewCommonVmCtx("errors",
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config struct{}, log wrapper.Log) types.Action {
+			switch ctx.Path() {
+			case "/quota":
+				return wrapper.Fail(ctx, wrapper.NewError(http.StatusForbidden, "quota_exceeded", "quota of the consumer is exceeded").
+					WithDetail("consumer %s", "c1"))
+			case "/auth":
+				return wrapper.Fail(ctx, wrapper.NewError(http.StatusUnauthorized, "auth_server_unavailable", "").
+					WithCause(errors.New("connection refused")))
+			case "/internal":
+				return wrapper.Fail(ctx, errors.New("unexpected"))
+			}
+			return types.ActionContinue
+		}),
+	)
+}
+
+func sendErrorPolicyRequest(host *Host, path string) *HttpStream {
+	stream := host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", path}, {"x-request-id", "req-1"}}, true)
+	return stream
+}
+
+func TestErrorPolicy(t *testing.T) {
+	host, reset := NewHost(newErrorPolicyVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{
+		"_errors_": {
+			"format": "json",
+			"codes": {"quota_exceeded": {"status": 429}, "auth_server_unavailable": {"action": "continue"}}
+		}
+	}`)))
+
+	stream := sendErrorPolicyRequest(host, "/quota")
+	assert.Equal(t, types.ActionPause, stream.Action())
+	response := stream.LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, uint32(http.StatusTooManyRequests), response.StatusCode)
+	assert.Contains(t, response.Headers, [2]string{"content-type", "application/json"})
+	assert.JSONEq(t, `{"code": "quota_exceeded", "message": "quota of the consumer is exceeded", "request_id": "req-1"}`, string(response.Body))
+	// the detail is only logged
+	found := false
+	for _, message := range host.Logs(wrapper.LogLevelWarn) {
+		found = found || strings.Contains(message, "consumer c1")
+	}
+	assert.True(t, found)
+
+	stream = sendErrorPolicyRequest(host, "/auth")
+	assert.Equal(t, types.ActionContinue, stream.Action())
+	assert.Nil(t, stream.LocalResponse())
+	found = false
+	for _, message := range host.Logs(wrapper.LogLevelWarn) {
+		found = found || strings.Contains(message, "connection refused")
+	}
+	assert.True(t, found)
+
+	stream = sendErrorPolicyRequest(host, "/internal")
+	response = stream.LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, uint32(http.StatusInternalServerError), response.StatusCode)
+	assert.JSONEq(t, `{"code": "internal_error", "message": "Internal Server Error", "request_id": "req-1"}`, string(response.Body))
+
+	sendErrorPolicyRequest(host, "/quota")
+	count, _ := host.Metric("plugin.errors.error.quota_exceeded")
+	assert.Equal(t, uint64(2), count)
+	count, _ = host.Metric("plugin.errors.error.auth_server_unavailable")
+	assert.Equal(t, uint64(1), count)
+}
+
+func TestErrorPolicyDefault(t *testing.T) {
+	host, reset := NewHost(newErrorPolicyVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin(nil))
+
+	response := sendErrorPolicyRequest(host, "/auth").LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, uint32(http.StatusUnauthorized), response.StatusCode)
+	assert.Equal(t, "Unauthorized", string(response.Body))
+	assert.Contains(t, response.Headers, [2]string{"content-type", "text/plain; charset=utf-8"})
+}
+
+func TestErrorPolicyTemplate(t *testing.T) {
+	host, reset := NewHost(newErrorPolicyVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{
+		"_errors_": {
+			"metrics": false,
+			"template": {"body": "<p>{{error.status}} {{error.code}}: {{error.message}} ({{header.x-request-id}})</p>"}
+		}
+	}`)))
+
+	response := sendErrorPolicyRequest(host, "/quota").LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, uint32(http.StatusForbidden), response.StatusCode)
+	assert.Equal(t, "<p>403 quota_exceeded: quota of the consumer is exceeded (req-1)</p>", string(response.Body))
+	_, ok := host.Metric("plugin.errors.error.quota_exceeded")
+	assert.False(t, ok)
+}
+
+func TestErrorPolicyI18n(t *testing.T) {
+	host, reset := NewHost(newErrorPolicyVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{
+		"_errors_": {
+			"i18n": {
+				"defaultLocale": "en",
+				"messages": {"en": {"quota_exceeded": "Quota exceeded"}, "zh-CN": {"quota_exceeded": "配额已用尽"}}
+			}
+		}
+	}`)))
+
+	stream := host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/quota"}, {"accept-language", "zh-CN,zh;q=0.9,en;q=0.8"}}, true)
+	response := stream.LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, "配额已用尽", string(response.Body))
+	assert.Contains(t, response.Headers, [2]string{"content-language", "zh-CN"})
+
+	response = sendErrorPolicyRequest(host, "/quota").LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, "Quota exceeded", string(response.Body))
+
+	// the message of the error is kept if the catalog doesn't have the code
+	response = sendErrorPolicyRequest(host, "/auth").LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, "Unauthorized", string(response.Body))
+	for _, header := range response.Headers {
+		assert.NotEqual(t, "content-language", header[0])
+	}
+}
+
+func TestErrorPolicyInvalid(t *testing.T) {
+	for _, config := range []string{
+		`{"_errors_": {"action": "drop"}}`,
+		`{"_errors_": {"format": "xml"}}`,
+		`{"_errors_": {"codes": {"quota_exceeded": {"status": 1000}}}}`,
+		`{"_errors_": {"template": {"body": ""}}}`,
+		`{"_errors_": {"i18n": {"messages": {}}}}`,
+	} {
+		host, reset := NewHost(newErrorPolicyVmCtx())
+		assert.Equal(t, types.OnPluginStartStatusFailed, host.StartPlugin([]byte(config)), config)
+		reset()
+	}
+}
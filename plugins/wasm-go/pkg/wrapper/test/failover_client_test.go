@@ -0,0 +1,158 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+type failoverReport struct {
+	endpoint   string
+	statusCode int
+}
+
+type failoverObserver struct {
+	reports []failoverReport
+}
+
+func (o *failoverObserver) ReportResponse(endpoint string, statusCode int, latency time.Duration) {
+	o.reports = append(o.reports, failoverReport{endpoint, statusCode})
+}
+
+type failoverConfig struct {
+	client *wrapper.FailoverClient
+}
+
+func newFailoverVmCtx(observer *failoverObserver) *wrapper.CommonVmCtx[failoverConfig] {
+	return wrapper.NewCommonVmCtx("failover",
+		wrapper.ParseConfigBy(func(json gjson.Result, config *failoverConfig, log wrapper.Log) error {
+			var targets []wrapper.FailoverTarget
+			for _, name := range []string{"a", "b", "c"} {
+				targets = append(targets, wrapper.FailoverTarget{Name: name, Cluster: wrapper.FQDNCluster{FQDN: name + ".dns", Port: 80}})
+			}
+			config.client = wrapper.NewFailoverClient(targets, wrapper.FailoverConfig{
+				TotalTimeout: uint32(json.Get("totalTimeout").Int()),
+				Observer:     observer,
+			})
+			return nil
+		}),
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config failoverConfig, log wrapper.Log) types.Action {
+			err := config.client.Get("/check", nil, func(statusCode int, headers http.Header, body []byte) {
+				_ = proxywasm.AddHttpRequestHeader("x-status", strconv.Itoa(statusCode))
+				_ = proxywasm.AddHttpRequestHeader("x-target", headers.Get(wrapper.FailoverTargetHeader))
+				_ = proxywasm.ResumeHttpRequest()
+			})
+			if err != nil {
+				return types.ActionContinue
+			}
+			return types.ActionPause
+		}),
+	)
+}
+
+func startFailover(t *testing.T, config string) (*Host, *HttpStream, *failoverObserver, func()) {
+	observer := &failoverObserver{}
+	host, reset := NewHost(newFailoverVmCtx(observer))
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(config)))
+	stream := host.NewHttpStream()
+	require.Equal(t, types.ActionPause, stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}}, true))
+	return host, stream, observer, reset
+}
+
+// replyFailover answers the pending callout, which should be sent to the target, nil headers are the timeout of Envoy.
+func replyFailover(t *testing.T, host *Host, target string, headers [][2]string) *HttpCallout {
+	callouts := host.HttpCallouts()
+	require.Len(t, callouts, 1)
+	callout := callouts[0]
+	require.Equal(t, fmt.Sprintf("outbound|80||%s.dns", target), callout.Cluster)
+	host.CallOnHttpCallResponse(callout.ID, headers, nil)
+	return callout
+}
+
+func assertFailoverResult(t *testing.T, stream *HttpStream, status string, target string) {
+	assert.Equal(t, types.ActionContinue, stream.Action())
+	value, _ := stream.RequestHeader("x-status")
+	assert.Equal(t, status, value)
+	value, _ = stream.RequestHeader("x-target")
+	assert.Equal(t, target, value)
+}
+
+func TestFailoverOn5xx(t *testing.T) {
+	host, stream, observer, reset := startFailover(t, `{}`)
+	defer reset()
+
+	callout := replyFailover(t, host, "a", [][2]string{{":status", "503"}})
+	assert.Equal(t, "/check", callout.Header(":path"))
+	assert.Equal(t, uint32(500), callout.Timeout)
+	assert.Equal(t, types.ActionPause, stream.Action())
+	replyFailover(t, host, "b", [][2]string{{":status", "200"}})
+	assertFailoverResult(t, stream, "200", "b")
+	assert.Empty(t, host.HttpCallouts())
+	assert.Equal(t, []failoverReport{{"a", 503}, {"b", 200}}, observer.reports)
+}
+
+func TestFailoverOnTimeout(t *testing.T) {
+	host, stream, observer, reset := startFailover(t, `{}`)
+	defer reset()
+
+	replyFailover(t, host, "a", nil)
+	replyFailover(t, host, "b", nil)
+	// the response of the last target is passed on whatever it is
+	replyFailover(t, host, "c", [][2]string{{":status", "504"}})
+	assertFailoverResult(t, stream, "504", "c")
+	assert.Equal(t, []failoverReport{{"a", 502}, {"b", 502}, {"c", 504}}, observer.reports)
+}
+
+func TestFailoverNotRetried(t *testing.T) {
+	host, stream, observer, reset := startFailover(t, `{}`)
+	defer reset()
+
+	replyFailover(t, host, "a", [][2]string{{":status", "404"}})
+	assertFailoverResult(t, stream, "404", "a")
+	assert.Empty(t, host.HttpCallouts())
+	assert.Equal(t, []failoverReport{{"a", 404}}, observer.reports)
+}
+
+func TestFailoverDeadline(t *testing.T) {
+	host, stream, observer, reset := startFailover(t, `{"totalTimeout": 100}`)
+	defer reset()
+
+	// the try timeout is capped by the total timeout
+	callouts := host.HttpCallouts()
+	require.Len(t, callouts, 1)
+	assert.LessOrEqual(t, callouts[0].Timeout, uint32(100))
+	assert.Greater(t, callouts[0].Timeout, uint32(0))
+
+	// no target is tried once the total timeout is exhausted
+	time.Sleep(110 * time.Millisecond)
+	replyFailover(t, host, "a", [][2]string{{":status", "503"}})
+	assertFailoverResult(t, stream, "503", "a")
+	assert.Empty(t, host.HttpCallouts())
+	assert.Equal(t, []failoverReport{{"a", 503}}, observer.reports)
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+func newFeatureFlagsVmCtx() *wrapper.CommonVmCtx[struct{}] {
+	return wrapper.NewCommonVmCtx("flags",
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config struct{}, log wrapper.Log) types.Action {
+			if wrapper.FlagEnabled(ctx, "new-auth") {
+				_ = proxywasm.AddHttpRequestHeader("x-auth-version", "v2")
+			}
+			return types.ActionContinue
+		}),
+	)
+}
+
+func TestFeatureFlags(t *testing.T) {
+	host, reset := NewHost(newFeatureFlagsVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusFailed, host.StartPlugin([]byte(`{"_flags_": {"path": "/api/client/features"}}`)))
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{
+		"_flags_": {
+			"format": "unleash",
+			"serviceName": "unleash.dns",
+			"servicePort": 4242,
+			"path": "/api/client/features",
+			"headers": {"authorization": "client-token"},
+			"defaults": {"new-auth": true}
+		}
+	}`)))
+
+	authVersion := func(route, consumer string) string {
+		host.SetProperty([]string{"route_name"}, []byte(route))
+		stream := host.NewHttpStream()
+		headers := [][2]string{{":authority", "example.com"}, {":path", "/"}}
+		if consumer != "" {
+			headers = append(headers, [2]string{"x-mse-consumer", consumer})
+		}
+		stream.CallOnRequestHeaders(headers, true)
+		value, _ := stream.RequestHeader("x-auth-version")
+		return value
+	}
+
+	// the defaults apply before the first poll
+	assert.Equal(t, "v2", authVersion("shop", "c2"))
+
+	host.Tick()
+	callouts := host.HttpCallouts()
+	require.Len(t, callouts, 1)
+	assert.Equal(t, "outbound|4242||unleash.dns", callouts[0].Cluster)
+	assert.Equal(t, "/api/client/features", callouts[0].Header(":path"))
+	assert.Equal(t, "client-token", callouts[0].Header("authorization"))
+	host.CallOnHttpCallResponse(callouts[0].ID, [][2]string{{":status", "200"}}, []byte(`{"version": 1, "features": [
+		{"name": "new-auth", "enabled": true, "strategies": [
+			{"name": "userWithId", "parameters": {"userIds": "c1"}},
+			{"name": "default", "constraints": [{"contextName": "route", "operator": "IN", "values": ["beta"]}]}
+		]}
+	]}`))
+
+	// the consumer header is sent by the client unless it's trusted
+	assert.Equal(t, "", authVersion("shop", "c1"))
+	wrapper.SetTrustConsumerHeader(true)
+	defer wrapper.SetTrustConsumerHeader(false)
+	assert.Equal(t, "v2", authVersion("shop", "c1"))
+	assert.Equal(t, "", authVersion("shop", "c2"))
+	assert.Equal(t, "", authVersion("shop", ""))
+	assert.Equal(t, "v2", authVersion("beta", "c2"))
+
+}
+
+func TestFeatureFlagsAbsent(t *testing.T) {
+	host, reset := NewHost(newFeatureFlagsVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin(nil))
+	stream := host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}, {"x-mse-consumer", "c1"}}, true)
+	_, ok := stream.RequestHeader("x-auth-version")
+	assert.False(t, ok)
+	assert.Zero(t, host.TickPeriod())
+}
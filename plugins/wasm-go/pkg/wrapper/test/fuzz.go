@@ -0,0 +1,116 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"fmt"
+	"runtime/debug"
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+// PanicError is a panic of the plugin, which aborts the VM when running in the proxy.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("plugin panicked: %v\n%s", e.Value, e.Stack)
+}
+
+// CallSafely calls the function and returns the panic raised in it as a *PanicError.
+func CallSafely(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	f()
+	return nil
+}
+
+// FuzzConfig fuzzes the plugin configuration, which goes through parseConfig, and parseRuleConfig for the rules
+// of the _rules_ field. The plugin may reject the configuration, but it must not panic:
+//
+//	func FuzzParseConfig(f *testing.F) {
+//		test.FuzzConfig(f, newVMContext, []byte(`{"key": "value"}`), []byte(`{"_rules_": [{"_match_route_": ["r1"]}]}`))
+//	}
+func FuzzConfig(f *testing.F, newVMContext func() types.VMContext, seeds ...[]byte) {
+	f.Helper()
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, config []byte) {
+		host, reset := NewHost(newVMContext())
+		defer reset()
+		if err := CallSafely(func() { host.StartPlugin(config) }); err != nil {
+			t.Fatalf("config %q: %v", config, err)
+		}
+	})
+}
+
+// BodyFuzzTarget is the stream the fuzzed bodies are sent on, the plugin is started with the config.
+type BodyFuzzTarget struct {
+	Config         []byte
+	RequestHeaders [][2]string
+	// The response headers, the fuzzed body is sent as the request body if they are nil
+	ResponseHeaders [][2]string
+}
+
+// FuzzBody fuzzes the request body, or the response body if the target has the response headers. Each input is
+// split into two chunks at a fuzzed offset, so the streaming body handlers are exercised as well. The plugin must
+// not panic whatever the body is.
+func FuzzBody(f *testing.F, newVMContext func() types.VMContext, target BodyFuzzTarget, seeds ...[]byte) {
+	f.Helper()
+	for _, seed := range seeds {
+		f.Add(seed, uint16(len(seed)))
+	}
+	f.Fuzz(func(t *testing.T, body []byte, split uint16) {
+		host, reset := NewHost(newVMContext())
+		defer reset()
+		if status := host.StartPlugin(target.Config); status != types.OnPluginStartStatusOK {
+			t.Fatalf("plugin start failed with config %s", target.Config)
+		}
+		offset := int(split)
+		if offset > len(body) {
+			offset = len(body)
+		}
+		err := CallSafely(func() {
+			stream := host.NewHttpStream()
+			defer stream.Complete()
+			callOnBody := stream.CallOnRequestBody
+			if target.ResponseHeaders == nil {
+				stream.CallOnRequestHeaders(target.RequestHeaders, false)
+			} else {
+				stream.CallOnRequestHeaders(target.RequestHeaders, true)
+				stream.CallOnResponseHeaders(target.ResponseHeaders, false)
+				callOnBody = stream.CallOnResponseBody
+			}
+			if offset < len(body) {
+				callOnBody(body[:offset], false)
+				callOnBody(body[offset:], true)
+			} else {
+				callOnBody(body, true)
+			}
+		})
+		if err != nil {
+			t.Fatalf("body %q: %v", body, err)
+		}
+	})
+}
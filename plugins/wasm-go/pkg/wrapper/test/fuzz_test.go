@@ -0,0 +1,64 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+func TestCallSafely(t *testing.T) {
+	assert.NoError(t, CallSafely(func() {}))
+	err := CallSafely(func() {
+		var m map[string]int
+		m["key"] = 1
+	})
+	var panicErr *PanicError
+	require.ErrorAs(t, err, &panicErr)
+	assert.Contains(t, err.Error(), "assignment to entry in nil map")
+
+	host, reset := NewHost(wrapper.NewCommonVmCtx("panic",
+		wrapper.ParseConfigBy(func(json gjson.Result, config *struct{}, log wrapper.Log) error {
+			_ = json.Get("items").Array()[1]
+			return nil
+		})))
+	defer reset()
+	err = CallSafely(func() { host.StartPlugin([]byte(`{"items": [1]}`)) })
+	require.ErrorAs(t, err, &panicErr)
+	assert.Contains(t, err.Error(), "index out of range")
+}
+
+func FuzzTestPluginConfig(f *testing.F) {
+	FuzzConfig(f, newFixtureVmCtx,
+		[]byte(`{"header": "x-test"}`),
+		[]byte(`{"header": "x-test", "_rules_": [{"_match_route_": ["r1"], "header": "x-rule"}]}`),
+		[]byte(`{"_rules_": [{"_match_domain_": ["*.example.com"]}]}`),
+		[]byte(`{"header"`),
+	)
+}
+
+func FuzzTestPluginRequestBody(f *testing.F) {
+	FuzzBody(f, newFixtureVmCtx, BodyFuzzTarget{
+		Config:         []byte(`{"header": "x-test"}`),
+		RequestHeaders: [][2]string{{":authority", "example.com"}, {":path", "/echo"}, {":method", "POST"}},
+	}, []byte("hello world"), []byte(`{"model": "gpt"}`), nil)
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+type geoConfig struct {
+	resolver *wrapper.GeoIPResolver
+}
+
+func newGeoVmCtx() *wrapper.CommonVmCtx[geoConfig] {
+	return wrapper.NewCommonVmCtx("geoip",
+		wrapper.ParseConfigBy(func(json gjson.Result, config *geoConfig, log wrapper.Log) error {
+			config.resolver = wrapper.NewGeoIPResolver(nil, &wrapper.GeoIPServiceConfig{
+				Client:          wrapper.NewClusterClient(wrapper.FQDNCluster{FQDN: "geo.dns", Port: 80}),
+				Path:            "/lookup?ip={ip}",
+				MaxCacheEntries: 2,
+			})
+			config.resolver.IPHeader = "x-real-ip"
+			return nil
+		}),
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config geoConfig, log wrapper.Log) types.Action {
+			pending := true
+			cached := false
+			_ = config.resolver.Resolve(ctx, func(info *wrapper.GeoInfo) {
+				cached = pending
+				if info != nil {
+					_ = proxywasm.AddHttpRequestHeader("x-country", info.Country)
+				}
+				if !cached {
+					_ = proxywasm.ResumeHttpRequest()
+				}
+			})
+			pending = false
+			if cached {
+				return types.ActionContinue
+			}
+			return types.ActionPause
+		}),
+	)
+}
+
+func TestGeoIPServiceCache(t *testing.T) {
+	host, reset := NewHost(newGeoVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin(nil))
+
+	resolve := func(ip string, body string) string {
+		stream := host.NewHttpStream()
+		stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}, {"x-real-ip", ip}}, true)
+		if callouts := host.HttpCallouts(); len(callouts) > 0 {
+			require.Len(t, callouts, 1)
+			require.NotEmpty(t, body, "unexpected lookup of %s", ip)
+			assert.Equal(t, "/lookup?ip="+ip, callouts[0].Header(":path"))
+			host.CallOnHttpCallResponse(callouts[0].ID, [][2]string{{":status", "200"}}, []byte(body))
+		} else {
+			require.Empty(t, body, "%s is not looked up", ip)
+		}
+		country, _ := stream.RequestHeader("x-country")
+		return country
+	}
+
+	assert.Equal(t, "CN", resolve("1.1.1.1", `{"country": "CN"}`))
+	assert.Equal(t, "CN", resolve("1.1.1.1", ""))
+	assert.Equal(t, "US", resolve("2.2.2.2", `{"country": "US"}`))
+	assert.Equal(t, "JP", resolve("3.3.3.3", `{"country": "JP"}`))
+	// the cache is bounded, the least recently used one is evicted
+	assert.Equal(t, "US", resolve("2.2.2.2", ""))
+	assert.Equal(t, "CN", resolve("1.1.1.1", `{"country": "CN"}`))
+}
@@ -0,0 +1,275 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update-golden", false, "write the results of the fixtures into their golden files")
+
+// Fixture is a request, and optionally its response, processed by the plugin with the config. The result is checked
+// against the expectation if it's set, and against the golden file if the fixture is run with golden files.
+type Fixture struct {
+	Name     string          `json:"name"`
+	Config   json.RawMessage `json:"config,omitempty"`
+	Request  FixtureMessage  `json:"request"`
+	Response *FixtureMessage `json:"response,omitempty"`
+	// The responses to the HTTP calls of the plugin in the order dispatched, the status code is the :status header
+	HttpCallResponses []FixtureMessage `json:"httpCallResponses,omitempty"`
+	Expect            *Result          `json:"expect,omitempty"`
+}
+
+// FixtureMessage is a request or a response, the body is sent with the headers if it's empty.
+type FixtureMessage struct {
+	Headers [][2]string `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+}
+
+// Result is the outcome of a fixture, the headers and bodies include the changes made by the plugin.
+type Result struct {
+	PluginStartFailed bool          `json:"pluginStartFailed,omitempty"`
+	RequestHeaders    [][2]string   `json:"requestHeaders,omitempty"`
+	RequestBody       string        `json:"requestBody,omitempty"`
+	ResponseHeaders   [][2]string   `json:"responseHeaders,omitempty"`
+	ResponseBody      string        `json:"responseBody,omitempty"`
+	LocalResponse     *FixtureReply `json:"localResponse,omitempty"`
+	HttpCalls         []FixtureCall `json:"httpCalls,omitempty"`
+	// The request or response is left paused, e.g. waiting for an HTTP call without a response in the fixture
+	Paused bool `json:"paused,omitempty"`
+}
+
+type FixtureReply struct {
+	StatusCode uint32      `json:"statusCode"`
+	Headers    [][2]string `json:"headers,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+type FixtureCall struct {
+	Cluster string      `json:"cluster"`
+	Headers [][2]string `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+	Timeout uint32      `json:"timeout,omitempty"`
+}
+
+// LoadFixtures reads the fixtures from the json files matching the pattern, a file holds a fixture or an array of
+// them. The fixtures without a name are named after their file.
+func LoadFixtures(pattern string) ([]Fixture, error) {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var fixtures []Fixture
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var loaded []Fixture
+		if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+			err = json.Unmarshal(data, &loaded)
+		} else {
+			var fixture Fixture
+			err = json.Unmarshal(data, &fixture)
+			loaded = []Fixture{fixture}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid fixture file %s: %v", file, err)
+		}
+		base := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		for i := range loaded {
+			if loaded[i].Name == "" {
+				loaded[i].Name = base
+				if len(loaded) > 1 {
+					loaded[i].Name = fmt.Sprintf("%s-%d", base, i)
+				}
+			}
+		}
+		fixtures = append(fixtures, loaded...)
+	}
+	return fixtures, nil
+}
+
+// Run processes the fixture with a new host running the VM context.
+func (f Fixture) Run(newVMContext func() types.VMContext) *Result {
+	host, reset := NewHost(newVMContext())
+	defer reset()
+	result := &Result{}
+	if host.StartPlugin(f.Config) != types.OnPluginStartStatusOK {
+		result.PluginStartFailed = true
+		return result
+	}
+	stream := host.NewHttpStream()
+	defer func() {
+		result.RequestHeaders = stream.RequestHeaders()
+		result.RequestBody = string(stream.RequestBody())
+		result.ResponseHeaders = stream.ResponseHeaders()
+		result.ResponseBody = string(stream.ResponseBody())
+		if reply := stream.LocalResponse(); reply != nil {
+			result.LocalResponse = &FixtureReply{StatusCode: reply.StatusCode, Headers: reply.Headers, Body: string(reply.Body)}
+		}
+		stream.Complete()
+	}()
+	responses := f.HttpCallResponses
+	recorded := map[uint32]bool{}
+	// respond to the calls dispatched so far, it returns false if the stream stays paused or is replied locally
+	proceed := func() bool {
+		for {
+			for _, callout := range host.HttpCallouts() {
+				if !recorded[callout.ID] {
+					recorded[callout.ID] = true
+					result.HttpCalls = append(result.HttpCalls, fixtureCall(callout))
+				}
+			}
+			callouts := host.HttpCallouts()
+			if len(callouts) == 0 || len(responses) == 0 {
+				break
+			}
+			host.CallOnHttpCallResponse(callouts[0].ID, responses[0].Headers, []byte(responses[0].Body))
+			responses = responses[1:]
+		}
+		if stream.LocalResponse() != nil {
+			return false
+		}
+		if stream.Action() != types.ActionContinue {
+			result.Paused = true
+			return false
+		}
+		return true
+	}
+	if !sendMessage(stream.CallOnRequestHeaders, stream.CallOnRequestBody, f.Request, proceed) {
+		return result
+	}
+	if f.Response != nil {
+		sendMessage(stream.CallOnResponseHeaders, stream.CallOnResponseBody, *f.Response, proceed)
+	}
+	return result
+}
+
+// sendMessage sends the headers and the body of the message, it returns false if the stream can't proceed.
+func sendMessage(onHeaders func([][2]string, bool) types.Action, onBody func([]byte, bool) types.Action,
+	message FixtureMessage, proceed func() bool) bool {
+	onHeaders(message.Headers, message.Body == "")
+	if !proceed() {
+		return false
+	}
+	if message.Body != "" {
+		onBody([]byte(message.Body), true)
+		return proceed()
+	}
+	return true
+}
+
+func fixtureCall(callout *HttpCallout) FixtureCall {
+	return FixtureCall{Cluster: callout.Cluster, Headers: callout.Headers, Body: string(callout.Body), Timeout: callout.Timeout}
+}
+
+// RunFixtures runs each fixture as a subtest, checking the result against the expectation of the fixture.
+// If goldenDir is not empty, the result is compared with the golden file {goldenDir}/{name}.golden.json as well,
+// run go test with -update-golden to write them.
+func RunFixtures(t *testing.T, newVMContext func() types.VMContext, goldenDir string, fixtures ...Fixture) {
+	t.Helper()
+	for _, fixture := range fixtures {
+		fixture := fixture
+		t.Run(fixture.Name, func(t *testing.T) {
+			result := fixture.Run(newVMContext)
+			if fixture.Expect != nil {
+				checkExpectation(t, fixture.Expect, result)
+			}
+			if goldenDir != "" {
+				checkGolden(t, filepath.Join(goldenDir, goldenFileName(fixture.Name)), result)
+			}
+		})
+	}
+}
+
+// RunFixtureFiles loads the fixtures matching the pattern and runs them with the golden files in goldenDir.
+func RunFixtureFiles(t *testing.T, newVMContext func() types.VMContext, pattern string, goldenDir string) {
+	t.Helper()
+	fixtures, err := LoadFixtures(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatalf("no fixture matches %s", pattern)
+	}
+	RunFixtures(t, newVMContext, goldenDir, fixtures...)
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func goldenFileName(name string) string {
+	return unsafeFileChars.ReplaceAllString(name, "_") + ".golden.json"
+}
+
+// checkExpectation compares the fields set in the expectation only.
+func checkExpectation(t *testing.T, expect, result *Result) {
+	t.Helper()
+	assert.Equal(t, expect.PluginStartFailed, result.PluginStartFailed, "pluginStartFailed")
+	assert.Equal(t, expect.Paused, result.Paused, "paused")
+	if expect.RequestHeaders != nil {
+		assert.Equal(t, expect.RequestHeaders, result.RequestHeaders, "requestHeaders")
+	}
+	if expect.RequestBody != "" {
+		assert.Equal(t, expect.RequestBody, result.RequestBody, "requestBody")
+	}
+	if expect.ResponseHeaders != nil {
+		assert.Equal(t, expect.ResponseHeaders, result.ResponseHeaders, "responseHeaders")
+	}
+	if expect.ResponseBody != "" {
+		assert.Equal(t, expect.ResponseBody, result.ResponseBody, "responseBody")
+	}
+	if expect.LocalResponse != nil {
+		assert.Equal(t, expect.LocalResponse, result.LocalResponse, "localResponse")
+	}
+	if expect.HttpCalls != nil {
+		assert.Equal(t, expect.HttpCalls, result.HttpCalls, "httpCalls")
+	}
+}
+
+func checkGolden(t *testing.T, file string, result *Result) {
+	t.Helper()
+	actual, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual = append(actual, '\n')
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, actual, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("read golden file failed, run with -update-golden to create it: %v", err)
+	}
+	assert.JSONEq(t, string(expected), string(actual), "golden file %s", file)
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFixtureVmCtx() types.VMContext {
+	ticks := 0
+	return newTestVmCtx(&ticks)
+}
+
+func TestFixtureExpectations(t *testing.T) {
+	RunFixtures(t, newFixtureVmCtx, "",
+		Fixture{
+			Name:   "invalid config",
+			Config: json.RawMessage(`{}`),
+			Expect: &Result{PluginStartFailed: true},
+		},
+		Fixture{
+			Name:   "uppercase body",
+			Config: json.RawMessage(`{"header": "x-test"}`),
+			Request: FixtureMessage{
+				Headers: [][2]string{{":authority", "example.com"}, {":path", "/echo"}},
+				Body:    "hello",
+			},
+			Response: &FixtureMessage{Headers: [][2]string{{":status", "200"}, {"server", "envoy"}}},
+			Expect: &Result{
+				RequestHeaders:  [][2]string{{":authority", "example.com"}, {":path", "/echo"}, {"x-test", "on"}},
+				RequestBody:     "HELLO",
+				ResponseHeaders: [][2]string{{":status", "200"}},
+			},
+		},
+		Fixture{
+			Name:    "deny",
+			Config:  json.RawMessage(`{"header": "x-test"}`),
+			Request: FixtureMessage{Headers: [][2]string{{":authority", "example.com"}, {":path", "/deny"}}},
+			Expect: &Result{
+				LocalResponse: &FixtureReply{StatusCode: 403, Headers: [][2]string{{"content-type", "text/plain"}}, Body: "denied"},
+			},
+		},
+		Fixture{
+			Name:              "auth",
+			Config:            json.RawMessage(`{"header": "x-test"}`),
+			Request:           FixtureMessage{Headers: [][2]string{{":authority", "example.com"}, {":path", "/auth"}}},
+			HttpCallResponses: []FixtureMessage{{Headers: [][2]string{{":status", "200"}}, Body: "ok"}},
+			Expect: &Result{
+				RequestHeaders: [][2]string{{":authority", "example.com"}, {":path", "/auth"}, {"x-test", "on"}, {"x-auth", "ok"}},
+				HttpCalls: []FixtureCall{{
+					Cluster: "outbound|80||auth.dns",
+					Headers: [][2]string{{"x-user", "alice"}, {":method", "GET"}, {":path", "/check"}, {":authority", "auth.dns"}},
+					Timeout: 500,
+				}},
+			},
+		},
+	)
+}
+
+func TestFixtureFiles(t *testing.T) {
+	RunFixtureFiles(t, newFixtureVmCtx, "testdata/fixtures/*.json", "testdata/golden")
+}
+
+func TestLoadFixtures(t *testing.T) {
+	fixtures, err := LoadFixtures("testdata/fixtures/*.json")
+	require.NoError(t, err)
+	var names []string
+	for _, fixture := range fixtures {
+		names = append(names, fixture.Name)
+	}
+	assert.Equal(t, []string{"auth-allowed", "auth-pending", "deny"}, names)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"request": 1}`), 0o644))
+	_, err = LoadFixtures(filepath.Join(dir, "*.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+func TestSendGrpcLocalReply(t *testing.T) {
+	host, reset := NewHost(wrapper.NewCommonVmCtx("grpc-reply",
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config struct{}, log wrapper.Log) types.Action {
+			_ = wrapper.SendGrpcLocalReply(wrapper.GrpcUnauthenticated, "invalid token: 50%")
+			return types.ActionPause
+		}),
+	))
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin(nil))
+
+	stream := host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/pkg.Service/Call"}, {"content-type", "application/grpc+proto"}}, false)
+	response := stream.LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, int32(wrapper.GrpcUnauthenticated), response.GrpcStatus)
+	assert.Equal(t, uint32(http.StatusUnauthorized), response.StatusCode)
+	assert.Equal(t, "invalid token: 50%", string(response.Body))
+
+	stream = host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/pkg.Service/Call"}, {"content-type", "application/grpc-web-text"}}, false)
+	response = stream.LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, int32(-1), response.GrpcStatus)
+	assert.Equal(t, uint32(http.StatusOK), response.StatusCode)
+	assert.Equal(t, [][2]string{
+		{"content-type", "application/grpc-web-text"}, {"grpc-status", "16"}, {"grpc-message", "invalid token: 50%25"},
+	}, response.Headers)
+	assert.Empty(t, response.Body)
+
+	stream = host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}}, true)
+	response = stream.LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, int32(-1), response.GrpcStatus)
+	assert.Equal(t, uint32(http.StatusUnauthorized), response.StatusCode)
+	assert.Equal(t, "invalid token: 50%", string(response.Body))
+}
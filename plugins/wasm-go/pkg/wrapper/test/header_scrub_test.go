@@ -0,0 +1,80 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+type scrubConfig struct {
+	mirror wrapper.HttpClient
+}
+
+func newScrubVmCtx() *wrapper.CommonVmCtx[scrubConfig] {
+	return wrapper.NewCommonVmCtx("scrub",
+		wrapper.ParseConfigBy(func(json gjson.Result, config *scrubConfig, log wrapper.Log) error {
+			config.mirror = wrapper.NewClusterClient(wrapper.FQDNCluster{FQDN: "shadow.dns", Port: 80})
+			return nil
+		}),
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config scrubConfig, log wrapper.Log) types.Action {
+			ctx.SetUserAttribute("authorization", "Bearer abc")
+			_ = ctx.WriteUserAttributeToLog()
+			_ = wrapper.MirrorRequest(config.mirror, nil)
+			return types.ActionContinue
+		}),
+	)
+}
+
+func TestMirrorRequestScrubbed(t *testing.T) {
+	host, reset := NewHost(newScrubVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin(nil))
+
+	stream := host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":method", "GET"}, {":path", "//evil.com/a?b=1"},
+		{"authorization", "Bearer abc"}, {"cookie", "session=abc"}, {"x-tag", "canary"}}, true)
+	callouts := host.HttpCallouts()
+	require.Len(t, callouts, 1)
+	mirrored := callouts[0]
+	assert.Equal(t, "GET", mirrored.Header(":method"))
+	assert.Equal(t, "/evil.com/a?b=1", mirrored.Header(":path"))
+	assert.Equal(t, "shadow.dns", mirrored.Header(":authority"))
+	assert.Equal(t, "canary", mirrored.Header("x-tag"))
+	assert.Empty(t, mirrored.Header("authorization"))
+	assert.Empty(t, mirrored.Header("cookie"))
+	host.CallOnHttpCallResponse(mirrored.ID, [][2]string{{":status", "200"}}, nil)
+	// the original request is untouched
+	value, _ := stream.RequestHeader("authorization")
+	assert.Equal(t, "Bearer abc", value)
+
+	// the user attributes are logged as they are unless the log scrubber is set
+	log, _ := stream.GetProperty([]string{wrapper.CustomLogKey})
+	assert.Contains(t, string(log), "Bearer abc")
+	wrapper.LogScrubber = wrapper.NewHeaderScrubber(true, nil)
+	defer func() { wrapper.LogScrubber = nil }()
+	stream = host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":method", "GET"}, {":path", "/"}}, true)
+	log, _ = stream.GetProperty([]string{wrapper.CustomLogKey})
+	assert.Contains(t, string(log), "Bearer ****")
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+const headerTransformConfig = `{
+	"_headers_": {
+		"request": [
+			{"op": "set", "name": "x-user", "value": "{{consumer.name | anonymous}}"},
+			{"op": "add", "name": "x-original-path", "value": "{{request.method}} {{request.path}}"},
+			{"op": "rename", "name": "x-token", "to": "authorization"},
+			{"op": "set", "name": "x-empty", "value": "{{header.x-missing}}"}
+		],
+		"response": [{"op": "remove", "name": "server"}],
+		"layout": {"order": ["x-user", "authorization"]}
+	},
+	"_rules_": [{
+		"_match_route_": ["internal"],
+		"_headers_": {
+			"request": [{"op": "remove", "name": "x-user"}, {"op": "set", "name": "x-lang", "value": "{{query.lang | en}}"}],
+			"response": [{"op": "set", "name": "x-status", "value": "status {{response.:status}}"}]
+		}
+	}]
+}`
+
+func TestHeaderTransform(t *testing.T) {
+	host, reset := NewHost(wrapper.NewCommonVmCtx[struct{}]("header-transform"))
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(headerTransformConfig)))
+
+	// the global transformation
+	stream := host.NewHttpStream()
+	assert.Equal(t, types.ActionContinue, stream.CallOnRequestHeaders([][2]string{
+		{":authority", "example.com"}, {":method", "GET"}, {":path", "/a?lang=zh"}, {"x-token", "t1"}, {"x-token", "t2"}, {"authorization", "old"},
+	}, true))
+	value, _ := stream.RequestHeader("x-user")
+	assert.Equal(t, "anonymous", value)
+	value, _ = stream.RequestHeader("x-original-path")
+	assert.Equal(t, "GET /a?lang=zh", value)
+	_, ok := stream.RequestHeader("x-token")
+	assert.False(t, ok)
+	var authorization []string
+	for _, header := range stream.RequestHeaders() {
+		if header[0] == "authorization" {
+			authorization = append(authorization, header[1])
+		}
+	}
+	assert.Equal(t, []string{"t1", "t2"}, authorization)
+	var names []string
+	for _, header := range stream.RequestHeaders() {
+		names = append(names, header[0])
+	}
+	assert.Equal(t, []string{":authority", ":method", ":path", "x-user", "authorization", "authorization", "x-original-path"}, names)
+	_, ok = stream.RequestHeader("x-empty")
+	assert.False(t, ok)
+	stream.CallOnResponseHeaders([][2]string{{":status", "200"}, {"server", "envoy"}}, true)
+	_, ok = stream.ResponseHeader("server")
+	assert.False(t, ok)
+	stream.Complete()
+
+	// the rule runs the global operations first
+	host.SetProperty([]string{"route_name"}, []byte("internal"))
+	stream = host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":method", "GET"}, {":path", "/a?lang=zh"}}, true)
+	_, ok = stream.RequestHeader("x-user")
+	assert.False(t, ok)
+	value, _ = stream.RequestHeader("x-lang")
+	assert.Equal(t, "zh", value)
+	value, _ = stream.RequestHeader("x-original-path")
+	assert.Equal(t, "GET /a?lang=zh", value)
+	stream.CallOnResponseHeaders([][2]string{{":status", "404"}, {"server", "envoy"}}, true)
+	value, _ = stream.ResponseHeader("x-status")
+	assert.Equal(t, "status 404", value)
+	_, ok = stream.ResponseHeader("server")
+	assert.False(t, ok)
+}
+
+func TestHeaderTransformInvalid(t *testing.T) {
+	for _, config := range []string{
+		`{"_headers_": {"request": [{"op": "move", "name": "x-a"}]}}`,
+		`{"_headers_": {"request": [{"op": "set", "name": "x-a"}]}}`,
+		`{"_headers_": {"request": [{"op": "rename", "name": "x-a"}]}}`,
+		`{"_headers_": {"response": [{"op": "remove", "name": ":status"}]}}`,
+		`{"_headers_": {"request": [{"op": "set", "name": "x-a", "value": "{{user}}"}]}}`,
+		`{"_rules_": [{"_match_route_": ["r"], "_headers_": {"request": [{"op": "remove"}]}}]}`,
+	} {
+		host, reset := NewHost(wrapper.NewCommonVmCtx[struct{}]("header-transform"))
+		assert.Equal(t, types.OnPluginStartStatusFailed, host.StartPlugin([]byte(config)), config)
+		reset()
+	}
+}
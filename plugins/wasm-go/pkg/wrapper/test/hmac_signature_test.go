@@ -0,0 +1,83 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+func TestSharedDataNonceCache(t *testing.T) {
+	host, reset := NewHost(wrapper.NewCommonVmCtx[struct{}]("nonce"))
+	defer reset()
+
+	cache := wrapper.NewSharedDataNonceCache("hmac", 60000)
+	assert.True(t, cache.CheckAndStore("key:1"))
+	assert.False(t, cache.CheckAndStore("key:1"))
+	assert.True(t, cache.CheckAndStore("key:2"))
+
+	// every nonce takes a slot of its own
+	h := fnv.New32a()
+	h.Write([]byte("key:1"))
+	value, ok := host.GetSharedData(fmt.Sprintf("nonce:hmac:%d", h.Sum32()%wrapper.DefaultNonceCacheCapacity))
+	assert.True(t, ok)
+	assert.Regexp(t, `^\d+:key:1$`, string(value))
+
+	// the slot of an expired nonce is reused
+	expiring := wrapper.NewSharedDataNonceCache("short", 1)
+	assert.True(t, expiring.CheckAndStore("key:1"))
+	time.Sleep(5 * time.Millisecond)
+	assert.True(t, expiring.CheckAndStore("key:1"))
+
+	// the nonce is rejected if all of its slots are taken
+	h = fnv.New32a()
+	h.Write([]byte("key:3"))
+	live := strconv.FormatInt(time.Now().Add(time.Minute).UnixMilli(), 10)
+	for i := uint32(0); i < 16; i++ {
+		host.SetSharedData(fmt.Sprintf("nonce:full:%d", (h.Sum32()+i)%wrapper.DefaultNonceCacheCapacity), []byte(live+":other"))
+	}
+	assert.False(t, wrapper.NewSharedDataNonceCache("full", 60000).CheckAndStore("key:3"))
+}
+
+func TestSharedDataNonceCacheSaturation(t *testing.T) {
+	_, reset := NewHost(wrapper.NewCommonVmCtx[struct{}]("nonce"))
+	defer reset()
+
+	assert.Equal(t, uint32(180000), wrapper.NonceCacheCapacity(100, 900000))
+	assert.Equal(t, uint32(16), wrapper.NonceCacheCapacity(1, 1000))
+
+	// a nonce may take any slot of a table no larger than the probes
+	cache := wrapper.NewSharedDataNonceCacheWithCapacity("small", 20, 16)
+	for i := 0; i < 16; i++ {
+		assert.True(t, cache.CheckAndStore(fmt.Sprintf("key:%d", i)))
+	}
+	// the saturated cache fails closed, even for a fresh nonce
+	assert.False(t, cache.CheckAndStore("key:16"))
+	assert.False(t, cache.CheckAndStore("key:0"))
+
+	// and recovers once the nonces in the window expire
+	time.Sleep(30 * time.Millisecond)
+	assert.True(t, cache.CheckAndStore("key:16"))
+	assert.True(t, cache.CheckAndStore("key:0"))
+}
@@ -0,0 +1,456 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+// Package test provides an in-process proxy-wasm host, so the plugins can be unit tested with go test:
+//
+//	host, reset := test.NewHost(wrapper.NewCommonVmCtx("my-plugin",
+//		wrapper.ParseConfigBy(parseConfig),
+//		wrapper.ProcessRequestHeadersBy(onHttpRequestHeaders)))
+//	defer reset()
+//	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{"key": "value"}`)))
+//	stream := host.NewHttpStream()
+//	action := stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}}, true)
+//
+// The host serves the header and body calls of the streams, the properties, the shared data, the ticks, the
+// metrics and the logs, and keeps the HTTP and Redis callouts pending until the test responds to them.
+package test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+type sharedDataEntry struct {
+	value []byte
+	cas   uint32
+}
+
+// sharedQueue is registered by the context receiving OnQueueReady, the queues are shared by all the VMs of the host.
+type sharedQueue struct {
+	name      string
+	contextID uint32
+	messages  [][]byte
+	ready     bool
+}
+
+type callResponse struct {
+	headers [][2]string
+	body    []byte
+}
+
+// Host is a fake proxy-wasm host running a plugin, it's not safe for concurrent use. Only one host can be alive at a
+// time since the sdk keeps its state globally, NewHost blocks until the previous host is reset.
+type Host struct {
+	vmContext     types.VMContext
+	rootID        uint32
+	nextID        uint32
+	vmConfig      []byte
+	pluginConfig  []byte
+	properties    map[string][]byte
+	sharedData    map[string]*sharedDataEntry
+	logs          map[wrapper.LogLevel][]string
+	tickPeriod    uint32
+	streams       map[uint32]*HttpStream
+	httpCallouts  []*HttpCallout
+	redisCallouts []*RedisCallout
+	callResponse  *callResponse
+	metricIDs     map[string]uint32
+	metrics       map[uint32]uint64
+	foreign       map[string]func(param []byte) []byte
+	queues        []*sharedQueue
+	rejected      map[string]bool
+}
+
+// NewHost installs a host running the VM context, the returned function must be called to release it.
+func NewHost(vmContext types.VMContext) (host *Host, reset func()) {
+	host = &Host{
+		vmContext:  vmContext,
+		nextID:     1,
+		properties: map[string][]byte{},
+		sharedData: map[string]*sharedDataEntry{},
+		logs:       map[wrapper.LogLevel][]string{},
+		streams:    map[uint32]*HttpStream{},
+		metricIDs:  map[string]uint32{},
+		metrics:    map[uint32]uint64{},
+		foreign:    map[string]func([]byte) []byte{},
+		rejected:   map[string]bool{},
+	}
+	keepHostMethods((*hostABI)(host))
+	release := registerMockWasmHost((*hostABI)(host))
+	vmStateReset()
+	proxywasm.SetVMContext(vmContext)
+	host.rootID = host.newContextID()
+	return host, func() {
+		vmStateReset()
+		release()
+	}
+}
+
+func (h *Host) newContextID() uint32 {
+	id := h.nextID
+	h.nextID++
+	return id
+}
+
+// SetVMConfiguration sets the VM configuration read in OnVMStart, it should be called before StartPlugin.
+func (h *Host) SetVMConfiguration(config []byte) {
+	h.vmConfig = config
+}
+
+// StartPlugin starts the VM and the plugin with the plugin configuration, which is parsed by parseConfig of the
+// wrapper.
+func (h *Host) StartPlugin(config []byte) types.OnPluginStartStatus {
+	h.pluginConfig = config
+	if proxyOnVMStart(h.rootID, len(h.vmConfig)) != types.OnVMStartStatusOK {
+		return types.OnPluginStartStatusFailed
+	}
+	proxyOnContextCreate(h.rootID, 0)
+	return proxyOnConfigure(h.rootID, len(config))
+}
+
+// StopPlugin calls OnPluginDone of the plugin.
+func (h *Host) StopPlugin() bool {
+	return proxyOnDone(h.rootID)
+}
+
+// SetProperty sets the property of the host, the properties set by the plugin during a stream are kept in the
+// stream, shadowing the ones of the host.
+func (h *Host) SetProperty(path []string, value []byte) {
+	h.properties[propertyKey(path)] = value
+}
+
+// RejectProperty makes setting the property fail with NotFound, as a host without the extension does, e.g. an
+// Envoy not supporting set_decoder_buffer_limit.
+func (h *Host) RejectProperty(path []string) {
+	h.rejected[propertyKey(path)] = true
+}
+
+func (h *Host) GetProperty(path []string) ([]byte, bool) {
+	value, ok := h.properties[propertyKey(path)]
+	return value, ok
+}
+
+func (h *Host) SetSharedData(key string, value []byte) {
+	if entry, ok := h.sharedData[key]; ok {
+		entry.value = value
+		entry.cas++
+		return
+	}
+	h.sharedData[key] = &sharedDataEntry{value: value, cas: 1}
+}
+
+func (h *Host) GetSharedData(key string) ([]byte, bool) {
+	entry, ok := h.sharedData[key]
+	if !ok {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Logs returns the messages logged at the level.
+func (h *Host) Logs(level wrapper.LogLevel) []string {
+	return h.logs[level]
+}
+
+// TickPeriod returns the tick period in milliseconds set by the plugin, 0 means the ticks are off.
+func (h *Host) TickPeriod() uint32 {
+	return h.tickPeriod
+}
+
+// Tick calls OnTick of the plugin, the tick functions registered by wrapper.RegisteTickFunc run when their period
+// has elapsed in the real time.
+func (h *Host) Tick() {
+	proxyOnTick(h.rootID)
+}
+
+// Metric returns the value of the metric defined by the plugin.
+func (h *Host) Metric(name string) (uint64, bool) {
+	id, ok := h.metricIDs[name]
+	if !ok {
+		return 0, false
+	}
+	return h.metrics[id], true
+}
+
+// RegisterForeignFunction serves the foreign function called by proxywasm.CallForeignFunction.
+func (h *Host) RegisterForeignFunction(name string, f func(param []byte) []byte) {
+	h.foreign[name] = f
+}
+
+func (h *Host) sharedQueue(name string) *sharedQueue {
+	for _, queue := range h.queues {
+		if queue.name == name {
+			return queue
+		}
+	}
+	return nil
+}
+
+// EnqueueSharedQueue puts the message on the shared queue registered by the plugin, as another plugin does, it's
+// delivered by ProcessSharedQueues.
+func (h *Host) EnqueueSharedQueue(name string, message []byte) error {
+	queue := h.sharedQueue(name)
+	if queue == nil {
+		return fmt.Errorf("shared queue %s is not registered", name)
+	}
+	queue.messages = append(queue.messages, message)
+	queue.ready = true
+	return nil
+}
+
+// SharedQueueMessages returns the messages left on the shared queue.
+func (h *Host) SharedQueueMessages(name string) [][]byte {
+	if queue := h.sharedQueue(name); queue != nil {
+		return queue.messages
+	}
+	return nil
+}
+
+// ProcessSharedQueues calls OnQueueReady for the queues enqueued since the last call, until no more is enqueued
+// by the plugin. Like Envoy, OnQueueReady isn't called in the host call enqueuing the message.
+func (h *Host) ProcessSharedQueues() {
+	for delivered := true; delivered; {
+		delivered = false
+		for i, queue := range h.queues {
+			if !queue.ready {
+				continue
+			}
+			queue.ready = false
+			delivered = true
+			proxyOnQueueReady(queue.contextID, uint32(i+1))
+		}
+	}
+}
+
+// HttpCallout is an HTTP call dispatched by the plugin.
+type HttpCallout struct {
+	ID uint32
+	// The context making the call, it's the root context for the calls made in OnPluginStart or OnTick
+	ContextID uint32
+	Cluster   string
+	Headers   [][2]string
+	Body      []byte
+	Trailers  [][2]string
+	Timeout   uint32
+}
+
+// Header returns the first value of the header.
+func (c *HttpCallout) Header(key string) string {
+	return headerValue(c.Headers, key)
+}
+
+// HttpCallouts returns the HTTP calls without a response yet, in the order dispatched.
+func (h *Host) HttpCallouts() []*HttpCallout {
+	return h.httpCallouts
+}
+
+// CallOnHttpCallResponse delivers the response of the HTTP call to the plugin, the status code is the :status
+// header like in Envoy.
+func (h *Host) CallOnHttpCallResponse(calloutID uint32, headers [][2]string, body []byte) {
+	found := false
+	for i, callout := range h.httpCallouts {
+		if callout.ID == calloutID {
+			h.httpCallouts = append(h.httpCallouts[:i:i], h.httpCallouts[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		panic(fmt.Sprintf("no pending http callout %d", calloutID))
+	}
+	h.callResponse = &callResponse{headers: lowerCaseKeys(headers), body: body}
+	defer func() { h.callResponse = nil }()
+	proxyOnHttpCallResponse(h.rootID, calloutID, len(headers), len(body), 0)
+}
+
+// RedisCallout is a Redis call dispatched by the plugin.
+type RedisCallout struct {
+	ID        uint32
+	ContextID uint32
+	Cluster   string
+	// The command in the RESP format
+	Query []byte
+}
+
+// RedisCallouts returns the Redis calls without a response yet, in the order dispatched.
+func (h *Host) RedisCallouts() []*RedisCallout {
+	return h.redisCallouts
+}
+
+// CallOnRedisCallResponse delivers the response in the RESP format to the plugin, the status is 0 on success.
+func (h *Host) CallOnRedisCallResponse(calloutID uint32, status int, response []byte) {
+	found := false
+	for i, callout := range h.redisCallouts {
+		if callout.ID == calloutID {
+			h.redisCallouts = append(h.redisCallouts[:i:i], h.redisCallouts[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		panic(fmt.Sprintf("no pending redis callout %d", calloutID))
+	}
+	h.callResponse = &callResponse{body: response}
+	defer func() { h.callResponse = nil }()
+	proxyOnRedisCallResponse(h.rootID, calloutID, status, len(response))
+}
+
+// LocalResponse is the response sent by the plugin with proxywasm.SendHttpResponse.
+type LocalResponse struct {
+	StatusCode       uint32
+	StatusCodeDetail string
+	Headers          [][2]string
+	Body             []byte
+	GrpcStatus       int32
+}
+
+// HttpStream is an HTTP stream processed by the plugin, the headers and bodies reflect the changes made by it.
+type HttpStream struct {
+	host                              *Host
+	id                                uint32
+	requestHeaders, responseHeaders   [][2]string
+	requestTrailers, responseTrailers [][2]string
+	// the body buffered by the plugin returning types.ActionPause, it's cleared once the plugin continues
+	requestBuffer, responseBuffer []byte
+	requestBody, responseBody     []byte
+	properties                    map[string][]byte
+	action                        types.Action
+	localResponse                 *LocalResponse
+}
+
+// NewHttpStream creates an HTTP context of the plugin, StartPlugin must be called before.
+func (h *Host) NewHttpStream() *HttpStream {
+	stream := &HttpStream{host: h, id: h.newContextID(), properties: map[string][]byte{}, action: types.ActionContinue}
+	h.streams[stream.id] = stream
+	proxyOnContextCreate(stream.id, h.rootID)
+	return stream
+}
+
+func (s *HttpStream) ID() uint32 {
+	return s.id
+}
+
+// CallOnRequestHeaders calls OnHttpRequestHeaders with the headers, including the pseudo headers like :authority,
+// :path and :method which the plugins usually read.
+func (s *HttpStream) CallOnRequestHeaders(headers [][2]string, endOfStream bool) types.Action {
+	s.requestHeaders = lowerCaseKeys(headers)
+	s.action = proxyOnRequestHeaders(s.id, len(headers), endOfStream)
+	return s.action
+}
+
+// CallOnRequestBody calls OnHttpRequestBody with the chunk appended to the body buffered so far.
+func (s *HttpStream) CallOnRequestBody(chunk []byte, endOfStream bool) types.Action {
+	s.requestBody = append(s.requestBuffer, chunk...)
+	s.action = proxyOnRequestBody(s.id, len(s.requestBody), endOfStream)
+	if s.action == types.ActionPause {
+		s.requestBuffer = s.requestBody
+	} else {
+		s.requestBuffer = nil
+	}
+	return s.action
+}
+
+func (s *HttpStream) CallOnResponseHeaders(headers [][2]string, endOfStream bool) types.Action {
+	s.responseHeaders = lowerCaseKeys(headers)
+	s.action = proxyOnResponseHeaders(s.id, len(headers), endOfStream)
+	return s.action
+}
+
+// CallOnResponseBody calls OnHttpResponseBody with the chunk appended to the body buffered so far.
+func (s *HttpStream) CallOnResponseBody(chunk []byte, endOfStream bool) types.Action {
+	s.responseBody = append(s.responseBuffer, chunk...)
+	s.action = proxyOnResponseBody(s.id, len(s.responseBody), endOfStream)
+	if s.action == types.ActionPause {
+		s.responseBuffer = s.responseBody
+	} else {
+		s.responseBuffer = nil
+	}
+	return s.action
+}
+
+// Complete calls OnHttpStreamDone and deletes the context.
+func (s *HttpStream) Complete() {
+	proxyOnLog(s.id)
+	proxyOnDelete(s.id)
+	delete(s.host.streams, s.id)
+}
+
+// Action returns the action of the last callback, it becomes types.ActionContinue once the plugin resumes the
+// stream, e.g. after an HTTP call.
+func (s *HttpStream) Action() types.Action {
+	return s.action
+}
+
+func (s *HttpStream) RequestHeaders() [][2]string {
+	return s.requestHeaders
+}
+
+// RequestHeader returns the first value of the request header.
+func (s *HttpStream) RequestHeader(key string) (string, bool) {
+	return lookupHeader(s.requestHeaders, key)
+}
+
+func (s *HttpStream) ResponseHeaders() [][2]string {
+	return s.responseHeaders
+}
+
+// ResponseHeader returns the first value of the response header.
+func (s *HttpStream) ResponseHeader(key string) (string, bool) {
+	return lookupHeader(s.responseHeaders, key)
+}
+
+// RequestBody returns the request body the plugin saw last, including the changes made by it.
+func (s *HttpStream) RequestBody() []byte {
+	return s.requestBody
+}
+
+// ResponseBody returns the response body the plugin saw last, including the changes made by it.
+func (s *HttpStream) ResponseBody() []byte {
+	return s.responseBody
+}
+
+// LocalResponse returns the response sent by the plugin, it's nil if none is sent.
+func (s *HttpStream) LocalResponse() *LocalResponse {
+	return s.localResponse
+}
+
+// GetProperty returns the property of the stream, or the one of the host.
+func (s *HttpStream) GetProperty(path []string) ([]byte, bool) {
+	if value, ok := s.properties[propertyKey(path)]; ok {
+		return value, true
+	}
+	return s.host.GetProperty(path)
+}
+
+func lookupHeader(headers [][2]string, key string) (string, bool) {
+	key = strings.ToLower(key)
+	for _, header := range headers {
+		if header[0] == key {
+			return header[1], true
+		}
+	}
+	return "", false
+}
+
+func headerValue(headers [][2]string, key string) string {
+	value, _ := lookupHeader(headers, key)
+	return value
+}
@@ -0,0 +1,414 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"strings"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+// hostABI serves the host calls of the sdk, the calls of a stream apply to the active context.
+type hostABI Host
+
+var _ abiHost = (*hostABI)(nil)
+
+// activeStream returns the stream of the active context, it's nil for the root context.
+func (h *hostABI) activeStream() *HttpStream {
+	return h.streams[vmStateGetActiveContextID()]
+}
+
+func (h *hostABI) headerMap(mapType mapType) *[][2]string {
+	if mapType == mapTypeHttpCallResponseHeaders {
+		if h.callResponse == nil {
+			return nil
+		}
+		return &h.callResponse.headers
+	}
+	stream := h.activeStream()
+	if stream == nil {
+		return nil
+	}
+	switch mapType {
+	case mapTypeHttpRequestHeaders:
+		return &stream.requestHeaders
+	case mapTypeHttpRequestTrailers:
+		return &stream.requestTrailers
+	case mapTypeHttpResponseHeaders:
+		return &stream.responseHeaders
+	case mapTypeHttpResponseTrailers:
+		return &stream.responseTrailers
+	}
+	return nil
+}
+
+func (h *hostABI) ProxyLog(logLevel logLevel, messageData *byte, messageSize int) status {
+	level := wrapper.LogLevel(logLevel)
+	h.logs[level] = append(h.logs[level], rawString(messageData, messageSize))
+	return statusOK
+}
+
+func (h *hostABI) ProxySetProperty(pathData *byte, pathSize int, valueData *byte, valueSize int) status {
+	key, value := rawString(pathData, pathSize), rawBytes(valueData, valueSize)
+	if h.rejected[key] {
+		return statusNotFound
+	}
+	if stream := h.activeStream(); stream != nil {
+		stream.properties[key] = value
+	} else {
+		h.properties[key] = value
+	}
+	return statusOK
+}
+
+func (h *hostABI) ProxyGetProperty(pathData *byte, pathSize int, returnValueData **byte, returnValueSize *int) status {
+	key := rawString(pathData, pathSize)
+	value, ok := h.properties[key]
+	if stream := h.activeStream(); stream != nil {
+		if streamValue, found := stream.properties[key]; found {
+			value, ok = streamValue, true
+		}
+	}
+	if !ok {
+		return statusNotFound
+	}
+	returnBytes(value, returnValueData, returnValueSize)
+	return statusOK
+}
+
+func (h *hostABI) ProxySendLocalResponse(statusCode uint32, statusCodeDetailData *byte, statusCodeDetailsSize int, bodyData *byte, bodySize int, headersData *byte, headersSize int, grpcStatus int32) status {
+	stream := h.activeStream()
+	if stream == nil {
+		return statusBadArgument
+	}
+	stream.localResponse = &LocalResponse{
+		StatusCode:       statusCode,
+		StatusCodeDetail: rawString(statusCodeDetailData, statusCodeDetailsSize),
+		Headers:          deserializeMap(rawBytes(headersData, headersSize)),
+		Body:             rawBytes(bodyData, bodySize),
+		GrpcStatus:       grpcStatus,
+	}
+	return statusOK
+}
+
+func (h *hostABI) ProxyGetSharedData(keyData *byte, keySize int, returnValueData **byte, returnValueSize *int, returnCas *uint32) status {
+	entry, ok := h.sharedData[rawString(keyData, keySize)]
+	if !ok {
+		return statusNotFound
+	}
+	returnBytes(entry.value, returnValueData, returnValueSize)
+	*returnCas = entry.cas
+	return statusOK
+}
+
+// ProxySetSharedData follows Envoy, a nonzero cas must match the one of the existing value.
+func (h *hostABI) ProxySetSharedData(keyData *byte, keySize int, valueData *byte, valueSize int, cas uint32) status {
+	key := rawString(keyData, keySize)
+	if entry, ok := h.sharedData[key]; ok && cas != 0 && cas != entry.cas {
+		return statusCasMismatch
+	}
+	(*Host)(h).SetSharedData(key, rawBytes(valueData, valueSize))
+	return statusOK
+}
+
+func (h *hostABI) ProxyRegisterSharedQueue(nameData *byte, nameSize int, returnID *uint32) status {
+	name := rawString(nameData, nameSize)
+	for i, queue := range h.queues {
+		if queue.name == name {
+			queue.contextID = vmStateGetActiveContextID()
+			*returnID = uint32(i + 1)
+			return statusOK
+		}
+	}
+	h.queues = append(h.queues, &sharedQueue{name: name, contextID: vmStateGetActiveContextID()})
+	*returnID = uint32(len(h.queues))
+	return statusOK
+}
+
+// ProxyResolveSharedQueue ignores the vm id, as the host runs a single VM.
+func (h *hostABI) ProxyResolveSharedQueue(vmIDData *byte, vmIDSize int, nameData *byte, nameSize int, returnID *uint32) status {
+	name := rawString(nameData, nameSize)
+	for i, queue := range h.queues {
+		if queue.name == name {
+			*returnID = uint32(i + 1)
+			return statusOK
+		}
+	}
+	return statusNotFound
+}
+
+func (h *hostABI) queue(queueID uint32) *sharedQueue {
+	if queueID == 0 || int(queueID) > len(h.queues) {
+		return nil
+	}
+	return h.queues[queueID-1]
+}
+
+func (h *hostABI) ProxyDequeueSharedQueue(queueID uint32, returnValueData **byte, returnValueSize *int) status {
+	queue := h.queue(queueID)
+	if queue == nil {
+		return statusNotFound
+	}
+	if len(queue.messages) == 0 {
+		return statusEmpty
+	}
+	returnBytes(queue.messages[0], returnValueData, returnValueSize)
+	queue.messages = queue.messages[1:]
+	return statusOK
+}
+
+func (h *hostABI) ProxyEnqueueSharedQueue(queueID uint32, valueData *byte, valueSize int) status {
+	queue := h.queue(queueID)
+	if queue == nil {
+		return statusNotFound
+	}
+	queue.messages = append(queue.messages, rawBytes(valueData, valueSize))
+	queue.ready = true
+	return statusOK
+}
+
+func (h *hostABI) ProxyGetHeaderMapValue(mapType mapType, keyData *byte, keySize int, returnValueData **byte, returnValueSize *int) status {
+	headers := h.headerMap(mapType)
+	if headers == nil {
+		return statusBadArgument
+	}
+	value, ok := lookupHeader(*headers, rawString(keyData, keySize))
+	if !ok {
+		return statusNotFound
+	}
+	returnBytes([]byte(value), returnValueData, returnValueSize)
+	return statusOK
+}
+
+func (h *hostABI) ProxyAddHeaderMapValue(mapType mapType, keyData *byte, keySize int, valueData *byte, valueSize int) status {
+	headers := h.headerMap(mapType)
+	if headers == nil {
+		return statusBadArgument
+	}
+	*headers = append(*headers, [2]string{strings.ToLower(rawString(keyData, keySize)), rawString(valueData, valueSize)})
+	return statusOK
+}
+
+// ProxyReplaceHeaderMapValue replaces all the values of the header with the value, or adds it if absent.
+func (h *hostABI) ProxyReplaceHeaderMapValue(mapType mapType, keyData *byte, keySize int, valueData *byte, valueSize int) status {
+	headers := h.headerMap(mapType)
+	if headers == nil {
+		return statusBadArgument
+	}
+	key := strings.ToLower(rawString(keyData, keySize))
+	*headers = append(removeHeader(*headers, key), [2]string{key, rawString(valueData, valueSize)})
+	return statusOK
+}
+
+func (h *hostABI) ProxyRemoveHeaderMapValue(mapType mapType, keyData *byte, keySize int) status {
+	headers := h.headerMap(mapType)
+	if headers == nil {
+		return statusBadArgument
+	}
+	*headers = removeHeader(*headers, strings.ToLower(rawString(keyData, keySize)))
+	return statusOK
+}
+
+func removeHeader(headers [][2]string, key string) [][2]string {
+	kept := headers[:0:0]
+	for _, header := range headers {
+		if header[0] != key {
+			kept = append(kept, header)
+		}
+	}
+	return kept
+}
+
+func (h *hostABI) ProxyGetHeaderMapPairs(mapType mapType, returnValueData **byte, returnValueSize *int) status {
+	headers := h.headerMap(mapType)
+	if headers == nil {
+		return statusBadArgument
+	}
+	returnBytes(serializeMap(*headers), returnValueData, returnValueSize)
+	return statusOK
+}
+
+func (h *hostABI) ProxySetHeaderMapPairs(mapType mapType, mapData *byte, mapSize int) status {
+	headers := h.headerMap(mapType)
+	if headers == nil {
+		return statusBadArgument
+	}
+	*headers = lowerCaseKeys(deserializeMap(rawBytes(mapData, mapSize)))
+	return statusOK
+}
+
+func (h *hostABI) ProxyContinueStream(streamType streamType) status {
+	if stream := h.activeStream(); stream != nil {
+		stream.action = types.ActionContinue
+	}
+	return statusOK
+}
+
+func (h *hostABI) ProxyCloseStream(streamType streamType) status {
+	return statusOK
+}
+
+func (h *hostABI) buffer(bufferType bufferType) *[]byte {
+	switch bufferType {
+	case bufferTypeVMConfiguration:
+		return &h.vmConfig
+	case bufferTypePluginConfiguration:
+		return &h.pluginConfig
+	case bufferTypeHttpCallResponseBody, bufferTypeRedisCallResponse:
+		if h.callResponse == nil {
+			return nil
+		}
+		return &h.callResponse.body
+	}
+	stream := h.activeStream()
+	if stream == nil {
+		return nil
+	}
+	switch bufferType {
+	case bufferTypeHttpRequestBody:
+		return &stream.requestBody
+	case bufferTypeHttpResponseBody:
+		return &stream.responseBody
+	}
+	return nil
+}
+
+func (h *hostABI) ProxyGetBufferBytes(bufferType bufferType, start int, maxSize int, returnBufferData **byte, returnBufferSize *int) status {
+	buffer := h.buffer(bufferType)
+	if buffer == nil {
+		return statusBadArgument
+	}
+	data := *buffer
+	if len(data) == 0 {
+		return statusNotFound
+	}
+	if start >= len(data) {
+		return statusBadArgument
+	}
+	end := len(data)
+	if maxSize < end-start {
+		end = start + maxSize
+	}
+	returnBytes(data[start:end], returnBufferData, returnBufferSize)
+	return statusOK
+}
+
+// ProxySetBufferBytes prepends the data with start 0 and size 0, replaces the buffer with start 0 and a size
+// covering it, and appends the data with start at the end, like the setters of proxywasm do.
+func (h *hostABI) ProxySetBufferBytes(bufferType bufferType, start int, maxSize int, bufferData *byte, bufferSize int) status {
+	buffer := h.buffer(bufferType)
+	if buffer == nil {
+		return statusBadArgument
+	}
+	data := rawBytes(bufferData, bufferSize)
+	switch {
+	case start == 0 && maxSize == 0:
+		*buffer = append(data, *buffer...)
+	case start == 0 && maxSize >= len(*buffer):
+		*buffer = data
+	case start >= len(*buffer):
+		*buffer = append(*buffer, data...)
+	default:
+		return statusBadArgument
+	}
+	return statusOK
+}
+
+func (h *hostABI) ProxyHttpCall(upstreamData *byte, upstreamSize int, headerData *byte, headerSize int, bodyData *byte, bodySize int, trailersData *byte, trailersSize int, timeout uint32, calloutIDPtr *uint32) status {
+	callout := &HttpCallout{
+		ID:        (*Host)(h).newContextID(),
+		ContextID: vmStateGetActiveContextID(),
+		Cluster:   rawString(upstreamData, upstreamSize),
+		Headers:   deserializeMap(rawBytes(headerData, headerSize)),
+		Body:      rawBytes(bodyData, bodySize),
+		Trailers:  deserializeMap(rawBytes(trailersData, trailersSize)),
+		Timeout:   timeout,
+	}
+	h.httpCallouts = append(h.httpCallouts, callout)
+	*calloutIDPtr = callout.ID
+	return statusOK
+}
+
+func (h *hostABI) ProxyRedisInit(upstreamData *byte, upstreamSize int, usernameData *byte, usernameSize int, passwordData *byte, passwordSize int, timeout uint32) status {
+	return statusOK
+}
+
+func (h *hostABI) ProxyRedisCall(upstreamData *byte, upstreamSize int, queryData *byte, querySize int, calloutIDPtr *uint32) status {
+	callout := &RedisCallout{
+		ID:        (*Host)(h).newContextID(),
+		ContextID: vmStateGetActiveContextID(),
+		Cluster:   rawString(upstreamData, upstreamSize),
+		Query:     rawBytes(queryData, querySize),
+	}
+	h.redisCallouts = append(h.redisCallouts, callout)
+	*calloutIDPtr = callout.ID
+	return statusOK
+}
+
+func (h *hostABI) ProxyCallForeignFunction(funcNamePtr *byte, funcNameSize int, paramPtr *byte, paramSize int, returnData **byte, returnSize *int) status {
+	f, ok := h.foreign[rawString(funcNamePtr, funcNameSize)]
+	if !ok {
+		return statusNotFound
+	}
+	returnBytes(f(rawBytes(paramPtr, paramSize)), returnData, returnSize)
+	return statusOK
+}
+
+func (h *hostABI) ProxySetTickPeriodMilliseconds(period uint32) status {
+	h.tickPeriod = period
+	return statusOK
+}
+
+func (h *hostABI) ProxySetEffectiveContext(contextID uint32) status {
+	vmStateSetActiveContextID(contextID)
+	return statusOK
+}
+
+func (h *hostABI) ProxyDone() status {
+	return statusOK
+}
+
+func (h *hostABI) ProxyDefineMetric(metricType metricType, metricNameData *byte, metricNameSize int, returnMetricIDPtr *uint32) status {
+	name := rawString(metricNameData, metricNameSize)
+	id, ok := h.metricIDs[name]
+	if !ok {
+		id = uint32(len(h.metricIDs) + 1)
+		h.metricIDs[name] = id
+	}
+	*returnMetricIDPtr = id
+	return statusOK
+}
+
+func (h *hostABI) ProxyIncrementMetric(metricID uint32, offset int64) status {
+	h.metrics[metricID] = uint64(int64(h.metrics[metricID]) + offset)
+	return statusOK
+}
+
+func (h *hostABI) ProxyRecordMetric(metricID uint32, value uint64) status {
+	h.metrics[metricID] = value
+	return statusOK
+}
+
+func (h *hostABI) ProxyGetMetric(metricID uint32, returnMetricValue *uint64) status {
+	if metricID == 0 || int(metricID) > len(h.metricIDs) {
+		return statusNotFound
+	}
+	*returnMetricValue = h.metrics[metricID]
+	return statusOK
+}
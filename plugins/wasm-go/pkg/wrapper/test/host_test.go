@@ -0,0 +1,200 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+type testConfig struct {
+	header string
+	client wrapper.HttpClient
+	ticks  *int
+}
+
+func newTestVmCtx(ticks *int) *wrapper.CommonVmCtx[testConfig] {
+	return wrapper.NewCommonVmCtx("test-plugin",
+		wrapper.ParseConfigBy(func(json gjson.Result, config *testConfig, log wrapper.Log) error {
+			config.header = json.Get("header").String()
+			if config.header == "" {
+				return errors.New("missing header")
+			}
+			config.client = wrapper.NewClusterClient(wrapper.FQDNCluster{FQDN: "auth.dns", Port: 80})
+			config.ticks = ticks
+			wrapper.RegisteTickFunc(1000, func() { *ticks++ })
+			return nil
+		}),
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config testConfig, log wrapper.Log) types.Action {
+			_ = proxywasm.AddHttpRequestHeader(config.header, "on")
+			switch ctx.Path() {
+			case "/deny":
+				_ = proxywasm.SendHttpResponse(http.StatusForbidden, [][2]string{{"content-type", "text/plain"}}, []byte("denied"), -1)
+				return types.ActionPause
+			case "/auth":
+				err := config.client.Get("/check", [][2]string{{"x-user", "alice"}}, func(statusCode int, headers http.Header, body []byte) {
+					_ = proxywasm.ReplaceHttpRequestHeader("x-auth", string(body))
+					_ = proxywasm.ResumeHttpRequest()
+				}, 500)
+				if err != nil {
+					return types.ActionContinue
+				}
+				return types.ActionPause
+			}
+			return types.ActionContinue
+		}),
+		wrapper.ProcessRequestBodyBy(func(ctx wrapper.HttpContext, config testConfig, body []byte, log wrapper.Log) types.Action {
+			_ = proxywasm.ReplaceHttpRequestBody(bytes.ToUpper(body))
+			_ = proxywasm.SetSharedData("last_body", body, 0)
+			return types.ActionContinue
+		}),
+		wrapper.ProcessResponseHeadersBy(func(ctx wrapper.HttpContext, config testConfig, log wrapper.Log) types.Action {
+			_ = proxywasm.RemoveHttpResponseHeader("server")
+			log.Infof("response of %s", ctx.Path())
+			return types.ActionContinue
+		}),
+	)
+}
+
+func TestHostPluginStart(t *testing.T) {
+	ticks := 0
+	host, reset := NewHost(newTestVmCtx(&ticks))
+	defer reset()
+	assert.Equal(t, types.OnPluginStartStatusFailed, host.StartPlugin([]byte(`{}`)))
+	assert.Equal(t, types.OnPluginStartStatusFailed, host.StartPlugin([]byte(`{"header"`)))
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{"header": "x-test"}`)))
+	assert.Equal(t, uint32(100), host.TickPeriod())
+	host.Tick()
+	host.Tick()
+	assert.Equal(t, 1, ticks)
+}
+
+func TestHostHttpStream(t *testing.T) {
+	ticks := 0
+	host, reset := NewHost(newTestVmCtx(&ticks))
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{"header": "x-test"}`)))
+
+	stream := host.NewHttpStream()
+	action := stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/echo"}, {"X-Request-Id", "r1"}}, false)
+	assert.Equal(t, types.ActionContinue, action)
+	value, ok := stream.RequestHeader("x-test")
+	assert.True(t, ok)
+	assert.Equal(t, "on", value)
+	requestID, _ := stream.GetProperty([]string{"x_request_id"})
+	assert.Equal(t, "r1", string(requestID))
+
+	assert.Equal(t, types.ActionPause, stream.CallOnRequestBody([]byte("hello "), false))
+	assert.Equal(t, types.ActionContinue, stream.CallOnRequestBody([]byte("world"), true))
+	assert.Equal(t, "HELLO WORLD", string(stream.RequestBody()))
+	shared, _ := host.GetSharedData("last_body")
+	assert.Equal(t, "hello world", string(shared))
+
+	stream.CallOnResponseHeaders([][2]string{{":status", "200"}, {"Server", "envoy"}}, true)
+	_, ok = stream.ResponseHeader("server")
+	assert.False(t, ok)
+	assert.Contains(t, host.Logs(wrapper.LogLevelInfo), "[test-plugin] [r1] response of /echo")
+	stream.Complete()
+
+	stream = host.NewHttpStream()
+	assert.Equal(t, types.ActionPause, stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/deny"}}, true))
+	response := stream.LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, uint32(http.StatusForbidden), response.StatusCode)
+	assert.Equal(t, "denied", string(response.Body))
+	assert.Equal(t, [][2]string{{"content-type", "text/plain"}}, response.Headers)
+}
+
+func TestHostHttpCallout(t *testing.T) {
+	ticks := 0
+	host, reset := NewHost(newTestVmCtx(&ticks))
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{"header": "x-test"}`)))
+
+	stream := host.NewHttpStream()
+	assert.Equal(t, types.ActionPause, stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/auth"}}, true))
+	callouts := host.HttpCallouts()
+	require.Len(t, callouts, 1)
+	callout := callouts[0]
+	assert.Equal(t, "outbound|80||auth.dns", callout.Cluster)
+	assert.Equal(t, "/check", callout.Header(":path"))
+	assert.Equal(t, "alice", callout.Header("x-user"))
+	assert.Equal(t, uint32(500), callout.Timeout)
+	assert.Equal(t, stream.ID(), callout.ContextID)
+
+	host.CallOnHttpCallResponse(callout.ID, [][2]string{{":status", "200"}}, []byte("ok"))
+	assert.Empty(t, host.HttpCallouts())
+	assert.Equal(t, types.ActionContinue, stream.Action())
+	value, _ := stream.RequestHeader("x-auth")
+	assert.Equal(t, "ok", value)
+	assert.Panics(t, func() { host.CallOnHttpCallResponse(callout.ID, nil, nil) })
+}
+
+func TestHostSharedDataAndMetrics(t *testing.T) {
+	host, reset := NewHost(wrapper.NewCommonVmCtx[struct{}]("empty"))
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin(nil))
+
+	require.NoError(t, proxywasm.SetSharedData("key", []byte("v1"), 0))
+	value, cas, err := proxywasm.GetSharedData("key")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(value))
+	require.NoError(t, proxywasm.SetSharedData("key", []byte("v2"), cas))
+	assert.ErrorIs(t, proxywasm.SetSharedData("key", []byte("v3"), cas), types.ErrorStatusCasMismatch)
+	_, _, err = proxywasm.GetSharedData("missing")
+	assert.ErrorIs(t, err, types.ErrorStatusNotFound)
+
+	counter := proxywasm.DefineCounterMetric("requests")
+	counter.Increment(2)
+	counter.Increment(3)
+	value2, ok := host.Metric("requests")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(5), value2)
+
+	host.SetProperty([]string{"route_name"}, []byte("route-a"))
+	route, err := proxywasm.GetProperty([]string{"route_name"})
+	require.NoError(t, err)
+	assert.Equal(t, "route-a", string(route))
+}
+
+func TestHostRedisCallout(t *testing.T) {
+	host, reset := NewHost(wrapper.NewCommonVmCtx[struct{}]("empty"))
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin(nil))
+
+	client := wrapper.NewRedisClusterClient(wrapper.FQDNCluster{FQDN: "redis.dns", Port: 6379})
+	require.NoError(t, client.Init("", "", 1000))
+	var value resp.Value
+	require.NoError(t, client.Get("key", func(response resp.Value) { value = response }))
+	callouts := host.RedisCallouts()
+	require.Len(t, callouts, 1)
+	assert.Equal(t, "outbound|6379||redis.dns", callouts[0].Cluster)
+	assert.Equal(t, "*2\r\n$3\r\nget\r\n$3\r\nkey\r\n", string(callouts[0].Query))
+	host.CallOnRedisCallResponse(callouts[0].ID, 0, []byte("$5\r\nvalue\r\n"))
+	assert.Equal(t, "value", value.String())
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+type introspectionConfig struct {
+	client *wrapper.IntrospectionClient
+}
+
+func newIntrospectionVmCtx() *wrapper.CommonVmCtx[introspectionConfig] {
+	return wrapper.NewCommonVmCtx("introspection",
+		wrapper.ParseConfigBy(func(json gjson.Result, config *introspectionConfig, log wrapper.Log) error {
+			client, err := wrapper.NewIntrospectionClient(wrapper.IntrospectionConfig{
+				Client:             wrapper.NewClusterClient(wrapper.FQDNCluster{FQDN: "idp.dns", Port: 80}),
+				URL:                "/introspect",
+				ClientID:           "gateway",
+				ClientSecret:       "secret",
+				NegativeCacheTTL:   json.Get("negativeCacheTTL").Int(),
+				MaxNegativeEntries: int(json.Get("maxNegativeEntries").Int()),
+			})
+			config.client = client
+			return err
+		}),
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config introspectionConfig, log wrapper.Log) types.Action {
+			token := strings.TrimPrefix(ctx.Host(), "token.")
+			pending := true
+			cached := false
+			err := config.client.Introspect(token, func(result wrapper.IntrospectionResult, err error) {
+				cached = pending
+				switch {
+				case err != nil:
+					_ = proxywasm.SendHttpResponse(http.StatusBadGateway, nil, []byte(err.Error()), -1)
+					return
+				case !result.Active:
+					_ = proxywasm.SendHttpResponse(http.StatusUnauthorized, nil, nil, -1)
+					return
+				}
+				_ = proxywasm.AddHttpRequestHeader("x-user", result.Username)
+				if !cached {
+					_ = proxywasm.ResumeHttpRequest()
+				}
+			})
+			pending = false
+			if err != nil || cached {
+				return types.ActionContinue
+			}
+			return types.ActionPause
+		}),
+	)
+}
+
+func TestIntrospection(t *testing.T) {
+	host, reset := NewHost(newIntrospectionVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{"negativeCacheTTL": 50, "maxNegativeEntries": 2}`)))
+
+	// introspect sends the request to the endpoint, the result is cached if it's not stale
+	introspect := func(token string) (*HttpStream, *HttpCallout) {
+		stream := host.NewHttpStream()
+		stream.CallOnRequestHeaders([][2]string{{":authority", "token." + token}, {":path", "/"}}, true)
+		callouts := host.HttpCallouts()
+		if len(callouts) == 0 {
+			return stream, nil
+		}
+		require.Len(t, callouts, 1)
+		return stream, callouts[0]
+	}
+	reply := func(callout *HttpCallout, status string, body string) {
+		host.CallOnHttpCallResponse(callout.ID, [][2]string{{":status", status}}, []byte(body))
+	}
+	user := func(stream *HttpStream) string {
+		value, _ := stream.RequestHeader("x-user")
+		return value
+	}
+	status := func(stream *HttpStream) uint32 {
+		require.NotNil(t, stream.LocalResponse())
+		return stream.LocalResponse().StatusCode
+	}
+
+	// cache miss
+	stream, callout := introspect("alice")
+	require.NotNil(t, callout)
+	assert.Equal(t, "/introspect", callout.Header(":path"))
+	assert.Equal(t, "Basic Z2F0ZXdheTpzZWNyZXQ=", callout.Header("authorization"))
+	assert.Equal(t, "token=alice&token_type_hint=access_token", string(callout.Body))
+	assert.Equal(t, types.ActionPause, stream.Action())
+	reply(callout, "200", fmt.Sprintf(`{"active": true, "username": "alice", "exp": %d}`, time.Now().Add(time.Hour).Unix()))
+	assert.Equal(t, types.ActionContinue, stream.Action())
+	assert.Equal(t, "alice", user(stream))
+
+	// cache hit
+	stream, callout = introspect("alice")
+	assert.Nil(t, callout)
+	assert.Equal(t, types.ActionContinue, stream.Action())
+	assert.Equal(t, "alice", user(stream))
+
+	// the active result isn't cached beyond the exp of the token
+	stream, callout = introspect("bob")
+	require.NotNil(t, callout)
+	reply(callout, "200", fmt.Sprintf(`{"active": true, "username": "bob", "exp": %d}`, time.Now().Add(-time.Second).Unix()))
+	assert.Equal(t, "bob", user(stream))
+	_, callout = introspect("bob")
+	require.NotNil(t, callout)
+	reply(callout, "200", `{"active": false}`)
+
+	// the inactive result is cached for the negative ttl
+	stream, callout = introspect("junk")
+	require.NotNil(t, callout)
+	reply(callout, "200", `{"active": false}`)
+	assert.Equal(t, uint32(http.StatusUnauthorized), status(stream))
+	stream, callout = introspect("junk")
+	assert.Nil(t, callout)
+	assert.Equal(t, uint32(http.StatusUnauthorized), status(stream))
+	time.Sleep(60 * time.Millisecond)
+	_, callout = introspect("junk")
+	require.NotNil(t, callout)
+	reply(callout, "200", `{"active": false}`)
+
+	// the negative cache is bounded, the junk tokens don't evict the active results
+	for _, token := range []string{"junk1", "junk2"} {
+		_, callout = introspect(token)
+		require.NotNil(t, callout)
+		reply(callout, "200", `{"active": false}`)
+	}
+	_, callout = introspect("junk")
+	require.NotNil(t, callout)
+	reply(callout, "200", `{"active": false}`)
+	_, callout = introspect("alice")
+	assert.Nil(t, callout)
+
+	// the errors are not cached
+	stream, callout = introspect("carol")
+	require.NotNil(t, callout)
+	reply(callout, "503", "unavailable")
+	assert.Equal(t, uint32(http.StatusBadGateway), status(stream))
+	stream, callout = introspect("carol")
+	require.NotNil(t, callout)
+	reply(callout, "200", "not json")
+	assert.Equal(t, uint32(http.StatusBadGateway), status(stream))
+	_, callout = introspect("carol")
+	require.NotNil(t, callout)
+	reply(callout, "200", `{"active": true, "username": "carol"}`)
+}
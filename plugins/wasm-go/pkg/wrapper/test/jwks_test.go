@@ -0,0 +1,145 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+type jwksConfig struct {
+	jwks *wrapper.JwksProvider
+}
+
+func newJwksVmCtx() *wrapper.CommonVmCtx[jwksConfig] {
+	return wrapper.NewCommonVmCtx("jwks",
+		wrapper.ParseConfigBy(func(json gjson.Result, config *jwksConfig, log wrapper.Log) error {
+			jwks, err := wrapper.NewJwksProvider(wrapper.JwksConfig{
+				Name:          "idp",
+				Client:        wrapper.NewClusterClient(wrapper.FQDNCluster{FQDN: "idp.dns", Port: 80}),
+				URL:           "/jwks.json",
+				RetryInterval: json.Get("retryInterval").Int(),
+			})
+			config.jwks = jwks
+			return err
+		}),
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config jwksConfig, log wrapper.Log) types.Action {
+			token, _ := proxywasm.GetHttpRequestHeader("authorization")
+			pending := true
+			verified := false
+			config.jwks.VerifyAsync(strings.TrimPrefix(token, "Bearer "), wrapper.JwtValidationOptions{}, func(jwt *wrapper.Jwt, err error) {
+				if err != nil {
+					_ = proxywasm.SendHttpResponse(http.StatusUnauthorized, nil, []byte(err.Error()), -1)
+					return
+				}
+				verified = pending
+				_ = proxywasm.AddHttpRequestHeader("x-user", jwt.Claims.Subject())
+				if !pending {
+					_ = proxywasm.ResumeHttpRequest()
+				}
+			})
+			pending = false
+			if verified {
+				return types.ActionContinue
+			}
+			return types.ActionPause
+		}),
+	)
+}
+
+func hmacJwks(kids ...string) string {
+	var keys []string
+	for _, kid := range kids {
+		keys = append(keys, fmt.Sprintf(`{"kty":"oct","kid":"%s","k":"%s"}`, kid, base64.RawURLEncoding.EncodeToString([]byte("secret-"+kid))))
+	}
+	return `{"keys":[` + strings.Join(keys, ",") + `]}`
+}
+
+func hmacJwt(kid, subject string) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"alg":"HS256","kid":"%s"}`, kid)))
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub":"%s"}`, subject)))
+	mac := hmac.New(sha256.New, []byte("secret-"+kid))
+	mac.Write([]byte(header + "." + claims))
+	return header + "." + claims + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestJwksProvider(t *testing.T) {
+	host, reset := NewHost(newJwksVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{"retryInterval": 1}`)))
+
+	fetch := func() *HttpCallout {
+		time.Sleep(2 * time.Millisecond)
+		host.Tick()
+		callouts := host.HttpCallouts()
+		if len(callouts) == 0 {
+			return nil
+		}
+		require.Len(t, callouts, 1)
+		assert.Equal(t, "/jwks.json", callouts[0].Header(":path"))
+		return callouts[0]
+	}
+	verify := func(kid string) *HttpStream {
+		stream := host.NewHttpStream()
+		stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}, {"authorization", "Bearer " + hmacJwt(kid, "alice")}}, true)
+		return stream
+	}
+
+	// a failed fetch is retried on the next tick instead of after the refresh interval
+	callout := fetch()
+	require.NotNil(t, callout)
+	host.CallOnHttpCallResponse(callout.ID, [][2]string{{":status", "503"}}, nil)
+	callout = fetch()
+	require.NotNil(t, callout)
+	host.CallOnHttpCallResponse(callout.ID, [][2]string{{":status", "200"}}, []byte(hmacJwks("k1")))
+	assert.Nil(t, fetch())
+
+	stream := verify("k1")
+	assert.Equal(t, types.ActionContinue, stream.Action())
+	value, _ := stream.RequestHeader("x-user")
+	assert.Equal(t, "alice", value)
+
+	// an unknown kid refetches the rotated keys before the token is verified
+	stream = verify("k2")
+	assert.Equal(t, types.ActionPause, stream.Action())
+	callouts := host.HttpCallouts()
+	require.Len(t, callouts, 1)
+	host.CallOnHttpCallResponse(callouts[0].ID, [][2]string{{":status", "200"}}, []byte(hmacJwks("k1", "k2")))
+	assert.Equal(t, types.ActionContinue, stream.Action())
+	assert.Nil(t, stream.LocalResponse())
+
+	// the refetches are rate limited, the forged kids fail without calling the endpoint
+	stream = verify("forged")
+	assert.Empty(t, host.HttpCallouts())
+	require.NotNil(t, stream.LocalResponse())
+	assert.Equal(t, uint32(http.StatusUnauthorized), stream.LocalResponse().StatusCode)
+	assert.Equal(t, types.ActionContinue, verify("k2").Action())
+}
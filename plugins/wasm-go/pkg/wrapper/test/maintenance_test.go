@@ -0,0 +1,151 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+type maintenanceConfig struct {
+	maintenance *wrapper.Maintenance
+}
+
+func newMaintenanceVmCtx() *wrapper.CommonVmCtx[maintenanceConfig] {
+	return wrapper.NewCommonVmCtx("maintenance",
+		wrapper.ParseConfigBy(func(json gjson.Result, config *maintenanceConfig, log wrapper.Log) error {
+			if !json.Get("maintenance").Exists() {
+				return nil
+			}
+			maintenanceConfig, err := wrapper.ParseMaintenanceConfig(json.Get("maintenance"))
+			if err != nil {
+				return err
+			}
+			config.maintenance, err = wrapper.NewMaintenance(maintenanceConfig)
+			return err
+		}),
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config maintenanceConfig, log wrapper.Log) types.Action {
+			if config.maintenance == nil {
+				return types.ActionContinue
+			}
+			return config.maintenance.Handle(ctx)
+		}),
+	)
+}
+
+func sendMaintenanceRequest(host *Host, route string, headers ...[2]string) *HttpStream {
+	host.SetProperty([]string{"route_name"}, []byte(route))
+	stream := host.NewHttpStream()
+	stream.CallOnRequestHeaders(append([][2]string{{":authority", "example.com"}, {":method", "GET"}, {":path", "/"}}, headers...), true)
+	return stream
+}
+
+func TestMaintenance(t *testing.T) {
+	host, reset := NewHost(newMaintenanceVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{
+		"foo": "bar",
+		"_rules_": [{
+			"_match_route_": ["shop"],
+			"maintenance": {
+				"name": "shop",
+				"clientIPHeader": "x-real-ip",
+				"allowIPs": ["10.0.0.0/8", "192.168.1.10"],
+				"allowHeaders": {"X-Maintenance-Bypass": "s3cret"}
+			}
+		}]
+	}`)))
+
+	stream := sendMaintenanceRequest(host, "shop", [2]string{"x-real-ip", "1.2.3.4"})
+	assert.Nil(t, stream.LocalResponse())
+	assert.Equal(t, types.ActionContinue, stream.Action())
+
+	host.SetSharedData("maintenance:shop", []byte(fmt.Sprintf(`{"enabled": true, "message": "<b>upgrading</b>", "until": %d, "updatedAt": 1}`,
+		time.Now().Add(10*time.Minute).Unix())))
+	response := sendMaintenanceRequest(host, "shop", [2]string{"x-real-ip", "1.2.3.4"}).LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, uint32(503), response.StatusCode)
+	assert.Contains(t, string(response.Body), "example.com is under maintenance")
+	assert.Contains(t, string(response.Body), "&lt;b&gt;upgrading&lt;/b&gt;")
+	retryAfter, err := strconv.Atoi(localResponseHeader(response, "retry-after"))
+	require.NoError(t, err)
+	assert.InDelta(t, 600, retryAfter, 5)
+
+	// the other routes aren't under maintenance
+	stream = sendMaintenanceRequest(host, "blog", [2]string{"x-real-ip", "1.2.3.4"})
+	assert.Nil(t, stream.LocalResponse())
+
+	for _, headers := range [][][2]string{
+		{{"x-real-ip", "10.1.2.3"}},
+		{{"x-real-ip", "192.168.1.10"}},
+		{{"x-real-ip", "1.2.3.4"}, {"x-maintenance-bypass", "s3cret"}},
+	} {
+		stream = sendMaintenanceRequest(host, "shop", headers...)
+		assert.Nil(t, stream.LocalResponse(), headers)
+		assert.Equal(t, types.ActionContinue, stream.Action())
+	}
+	for _, headers := range [][][2]string{
+		{{"x-real-ip", "192.168.1.11"}},
+		{{"x-real-ip", "1.2.3.4"}, {"x-maintenance-bypass", "guess"}},
+	} {
+		response = sendMaintenanceRequest(host, "shop", headers...).LocalResponse()
+		require.NotNil(t, response, headers)
+		assert.Equal(t, uint32(503), response.StatusCode)
+	}
+
+	host.SetSharedData("maintenance:shop", []byte(`{"enabled": false, "updatedAt": 2}`))
+	stream = sendMaintenanceRequest(host, "shop", [2]string{"x-real-ip", "1.2.3.4"})
+	assert.Nil(t, stream.LocalResponse())
+}
+
+func TestMaintenanceRemoteFlag(t *testing.T) {
+	host, reset := NewHost(newMaintenanceVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{
+		"maintenance": {
+			"name": "api",
+			"remote": {"serviceName": "flags.dns", "path": "/flags/maintenance", "enabledPath": "data.on"},
+			"response": {"statusCode": 503, "contentType": "application/json", "body": "{\"message\": \"{{maintenance.message | down}}\"}"}
+		}
+	}`)))
+	stream := sendMaintenanceRequest(host, "api")
+	assert.Nil(t, stream.LocalResponse())
+
+	host.Tick()
+	callouts := host.HttpCallouts()
+	require.Len(t, callouts, 1)
+	assert.Equal(t, "outbound|80||flags.dns", callouts[0].Cluster)
+	assert.Equal(t, "/flags/maintenance", callouts[0].Header(":path"))
+	host.CallOnHttpCallResponse(callouts[0].ID, [][2]string{{":status", "200"}}, []byte(`{"data": {"on": true}, "message": "db migration"}`))
+	state, ok := wrapper.GetMaintenance("api")
+	assert.True(t, ok)
+	assert.True(t, state.Enabled)
+
+	response := sendMaintenanceRequest(host, "api").LocalResponse()
+	require.NotNil(t, response)
+	assert.Equal(t, uint32(503), response.StatusCode)
+	assert.JSONEq(t, `{"message": "db migration"}`, string(response.Body))
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+type blacklistEvent struct {
+	IP string `json:"ip"`
+}
+
+func TestHostMessageBus(t *testing.T) {
+	var (
+		blocked []string
+		dead    []wrapper.DeadLetter
+		calls   int
+	)
+	host, reset := NewHost(wrapper.NewCommonVmCtx("bus",
+		wrapper.ParseConfigBy(func(json gjson.Result, config *struct{}, log wrapper.Log) error {
+			wrapper.RegisterQueueConsumer("blacklist", func(event blacklistEvent) error {
+				calls++
+				if event.IP == "flaky" && calls%2 == 1 {
+					return errors.New("reload failed")
+				}
+				if event.IP == "broken" {
+					return errors.New("reload failed")
+				}
+				blocked = append(blocked, event.IP)
+				return nil
+			})
+			wrapper.RegisterDeadLetterConsumer("blacklist.dlq", func(letter wrapper.DeadLetter) error {
+				dead = append(dead, letter)
+				return nil
+			})
+			return nil
+		}),
+	))
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{"enabled": true}`)))
+
+	require.NoError(t, wrapper.EnqueueMessage("blacklist", blacklistEvent{IP: "10.0.0.1"}))
+	require.NoError(t, wrapper.EnqueueMessage("blacklist", blacklistEvent{IP: "flaky"}))
+	require.NoError(t, wrapper.EnqueueMessage("blacklist", blacklistEvent{IP: "broken"}))
+	require.NoError(t, host.EnqueueSharedQueue("blacklist", []byte("not an envelope")))
+	assert.Len(t, host.SharedQueueMessages("blacklist"), 4)
+	assert.Error(t, wrapper.EnqueueMessage("missing", blacklistEvent{}))
+
+	host.ProcessSharedQueues()
+	assert.Equal(t, []string{"10.0.0.1", "flaky"}, blocked)
+	assert.Empty(t, host.SharedQueueMessages("blacklist"))
+	assert.Empty(t, host.SharedQueueMessages("blacklist.dlq"))
+	require.Len(t, dead, 2)
+	assert.Equal(t, "blacklist", dead[0].Queue)
+	assert.Equal(t, "not an envelope", string(dead[0].Payload))
+	assert.Equal(t, "broken", gjson.GetBytes(dead[1].Payload, "ip").String())
+	assert.Equal(t, 3, dead[1].Attempts)
+	assert.Equal(t, "reload failed", dead[1].Error)
+}
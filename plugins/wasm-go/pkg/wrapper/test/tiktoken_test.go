@@ -0,0 +1,94 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+type tiktokenConfig struct {
+	tokenizer *wrapper.RemoteTokenizer
+}
+
+func newTiktokenVmCtx() *wrapper.CommonVmCtx[tiktokenConfig] {
+	return wrapper.NewCommonVmCtx("tiktoken",
+		wrapper.ParseConfigBy(func(json gjson.Result, config *tiktokenConfig, log wrapper.Log) error {
+			tokenizer, err := wrapper.NewRemoteTokenizer(wrapper.RemoteTokenizerConfig{
+				Name:          "test_base",
+				Client:        wrapper.NewClusterClient(wrapper.FQDNCluster{FQDN: "oss.dns", Port: 80}),
+				URL:           "/test_base.tiktoken",
+				RetryInterval: 1,
+			})
+			config.tokenizer = tokenizer
+			return err
+		}),
+		wrapper.ProcessRequestHeadersBy(func(ctx wrapper.HttpContext, config tiktokenConfig, log wrapper.Log) types.Action {
+			_ = proxywasm.AddHttpRequestHeader("x-tokens", strconv.Itoa(config.tokenizer.CountTokens("aaaa")))
+			return types.ActionContinue
+		}),
+	)
+}
+
+func TestRemoteTokenizer(t *testing.T) {
+	host, reset := NewHost(newTiktokenVmCtx())
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{}`)))
+
+	tick := func() {
+		time.Sleep(2 * time.Millisecond)
+		host.Tick()
+	}
+	tokens := func() string {
+		stream := host.NewHttpStream()
+		stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":path", "/"}}, true)
+		value, _ := stream.RequestHeader("x-tokens")
+		return value
+	}
+
+	// the fallback estimates the tokens until the ranks are loaded, a failed fetch is retried
+	tick()
+	callouts := host.HttpCallouts()
+	require.Len(t, callouts, 1)
+	assert.Equal(t, "/test_base.tiktoken", callouts[0].Header(":path"))
+	host.CallOnHttpCallResponse(callouts[0].ID, [][2]string{{":status", "200"}}, []byte("invalid"))
+	assert.Equal(t, "1", tokens())
+	tick()
+	callouts = host.HttpCallouts()
+	require.Len(t, callouts, 1)
+	// only the single bytes are in the vocabulary, so every byte is a token
+	host.CallOnHttpCallResponse(callouts[0].ID, [][2]string{{":status", "200"}}, []byte("YQ== 0\nYg== 1\n"))
+	assert.Equal(t, "4", tokens())
+	tick()
+	assert.Empty(t, host.HttpCallouts())
+
+	// the other VMs load the ranks from shared data
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin([]byte(`{}`)))
+	assert.Equal(t, "1", tokens())
+	tick()
+	assert.Empty(t, host.HttpCallouts())
+	assert.Equal(t, "4", tokens())
+}
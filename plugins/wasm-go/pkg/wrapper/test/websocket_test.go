@@ -0,0 +1,149 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !tinygo
+
+package test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+func newWebSocketVmCtx(texts *[]string) *wrapper.CommonVmCtx[struct{}] {
+	return wrapper.NewCommonVmCtx("websocket",
+		wrapper.ProcessStreamingRequestBodyBy(wrapper.WebSocketFrameHandler(wrapper.WebSocketHandlers[struct{}]{
+			OnText: func(ctx wrapper.HttpContext, config struct{}, frame *wrapper.WebSocketFrame) wrapper.WebSocketVerdict {
+				text := string(frame.Payload)
+				*texts = append(*texts, text)
+				switch {
+				case strings.Contains(text, "spam"):
+					return wrapper.WebSocketDrop
+				case strings.Contains(text, "attack"):
+					return wrapper.WebSocketReject
+				}
+				frame.Payload = bytes.ToUpper(frame.Payload)
+				return wrapper.WebSocketForward
+			},
+			RejectReason: "policy",
+			MaxFrameSize: 64,
+		})),
+	)
+}
+
+func TestWebSocketFrames(t *testing.T) {
+	var texts []string
+	host, reset := NewHost(newWebSocketVmCtx(&texts))
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin(nil))
+
+	stream := host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{
+		{":authority", "example.com"}, {":method", "GET"}, {":path", "/chat"}, {"connection", "Upgrade"}, {"upgrade", "websocket"},
+		{"sec-websocket-extensions", "permessage-deflate"},
+	}, false)
+	// the extensions are removed by the wrapper once a frame handler is created
+	_, ok := stream.RequestHeader("sec-websocket-extensions")
+	assert.False(t, ok)
+
+	mask := [4]byte{9, 8, 7, 6}
+	frame := func(text string) []byte {
+		return (&wrapper.WebSocketFrame{Fin: true, Opcode: wrapper.WebSocketText, Masked: true, Mask: mask, Payload: []byte(text)}).Bytes()
+	}
+	ping := (&wrapper.WebSocketFrame{Fin: true, Opcode: wrapper.WebSocketPing, Masked: true, Mask: mask}).Bytes()
+	data := bytes.Join([][]byte{frame("hello"), ping, frame("buy spam")}, nil)
+	stream.CallOnRequestBody(data[:4], false)
+	stream.CallOnRequestBody(data[4:], false)
+	assert.Equal(t, append(frame("HELLO"), ping...), stream.RequestBody())
+
+	stream.CallOnRequestBody(append(frame("attack now"), frame("after")...), false)
+	assert.Equal(t, []string{"hello", "buy spam", "attack now"}, texts)
+	parser := wrapper.NewWebSocketParser(0)
+	frames, err := parser.Feed(stream.RequestBody())
+	require.NoError(t, err)
+	last := frames[len(frames)-1]
+	assert.Equal(t, wrapper.WebSocketClose, last.Opcode)
+	assert.True(t, last.Masked)
+	code, reason := last.CloseStatus()
+	assert.Equal(t, 1008, code)
+	assert.Equal(t, "policy", reason)
+
+	// the body of the other requests is not parsed
+	stream = host.NewHttpStream()
+	stream.CallOnRequestHeaders([][2]string{{":authority", "example.com"}, {":method", "POST"}, {":path", "/"}}, false)
+	stream.CallOnRequestBody([]byte("attack"), true)
+	assert.Equal(t, "attack", string(stream.RequestBody()))
+}
+
+func TestWebSocketFailClosed(t *testing.T) {
+	var texts []string
+	host, reset := NewHost(newWebSocketVmCtx(&texts))
+	defer reset()
+	require.Equal(t, types.OnPluginStartStatusOK, host.StartPlugin(nil))
+
+	mask := [4]byte{9, 8, 7, 6}
+	upgrade := func(extensions string) *HttpStream {
+		stream := host.NewHttpStream()
+		stream.CallOnRequestHeaders([][2]string{
+			{":authority", "example.com"}, {":method", "GET"}, {":path", "/chat"}, {"connection", "Upgrade"}, {"upgrade", "websocket"},
+		}, false)
+		headers := [][2]string{{":status", "101"}}
+		if extensions != "" {
+			headers = append(headers, [2]string{"sec-websocket-extensions", extensions})
+		}
+		stream.CallOnResponseHeaders(headers, false)
+		return stream
+	}
+	closeCode := func(stream *HttpStream) int {
+		frames, err := wrapper.NewWebSocketParser(0).Feed(stream.RequestBody())
+		require.NoError(t, err)
+		require.NotEmpty(t, frames)
+		last := frames[len(frames)-1]
+		require.Equal(t, wrapper.WebSocketClose, last.Opcode)
+		code, _ := last.CloseStatus()
+		return code
+	}
+	large := (&wrapper.WebSocketFrame{Fin: true, Opcode: wrapper.WebSocketText, Masked: true, Mask: mask,
+		Payload: bytes.Repeat([]byte("a"), 100)}).Bytes()
+	compressed := (&wrapper.WebSocketFrame{Fin: true, Rsv: 0x04, Opcode: wrapper.WebSocketText, Masked: true, Mask: mask,
+		Payload: []byte("attack")}).Bytes()
+	attack := (&wrapper.WebSocketFrame{Fin: true, Opcode: wrapper.WebSocketText, Masked: true, Mask: mask,
+		Payload: []byte("attack")}).Bytes()
+
+	// the frames after a too large frame are not passed through uninspected
+	stream := upgrade("")
+	stream.CallOnRequestBody(large[:10], false)
+	assert.Equal(t, 1009, closeCode(stream))
+	stream.CallOnRequestBody(append(large[10:], attack...), false)
+	assert.Empty(t, stream.RequestBody())
+
+	// RSV1 is a protocol error if permessage-deflate is not negotiated
+	stream = upgrade("")
+	stream.CallOnRequestBody(append(compressed, attack...), false)
+	assert.Equal(t, 1002, closeCode(stream))
+	assert.Empty(t, texts)
+
+	// and so it is if the upstream accepts the extension removed from the request
+	stream = upgrade("permessage-deflate; client_max_window_bits")
+	stream.CallOnRequestBody(append(compressed, attack...), false)
+	assert.Equal(t, 1002, closeCode(stream))
+	assert.Empty(t, texts)
+}
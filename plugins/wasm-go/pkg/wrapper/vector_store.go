@@ -0,0 +1,389 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	VectorStoreDashVector = "dashvector"
+	VectorStoreQdrant     = "qdrant"
+	VectorStoreMilvus     = "milvus"
+)
+
+// EmbeddingsClient computes the embeddings with the provider, using a fixed model.
+type EmbeddingsClient struct {
+	Provider LLMProvider
+	Model    string
+}
+
+func NewEmbeddingsClient(provider LLMProvider, model string) *EmbeddingsClient {
+	return &EmbeddingsClient{Provider: provider, Model: model}
+}
+
+// Embed returns the vectors in the order of the texts.
+func (c *EmbeddingsClient) Embed(texts []string, callback func(vectors [][]float64, err error)) error {
+	return c.Provider.Embeddings(&EmbeddingsRequest{Model: c.Model, Input: texts}, func(response *EmbeddingsResponse, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		if len(response.Data) != len(texts) {
+			callback(nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(response.Data)))
+			return
+		}
+		vectors := make([][]float64, len(texts))
+		for i, embedding := range response.Data {
+			index := embedding.Index
+			if index < 0 || index >= len(vectors) {
+				index = i
+			}
+			vectors[index] = embedding.Embedding
+		}
+		callback(vectors, nil)
+	})
+}
+
+type VectorDocument struct {
+	ID       string
+	Vector   []float64
+	Content  string
+	Metadata map[string]interface{}
+}
+
+type VectorSearchResult struct {
+	ID string
+	// The score as returned by the store, its meaning depends on the metric of the collection, e.g. a cosine
+	// distance of DashVector is smaller for closer vectors while a cosine similarity of Qdrant is larger.
+	Score    float64
+	Content  string
+	Metadata map[string]interface{}
+}
+
+// VectorStore is the client of a vector database, the callbacks are not called if an error is returned.
+type VectorStore interface {
+	Upsert(documents []VectorDocument, callback func(err error)) error
+	Query(vector []float64, topK int, callback func(results []VectorSearchResult, err error)) error
+}
+
+type VectorStoreConfig struct {
+	Type       string
+	Client     HttpClient
+	ApiKey     string
+	Collection string
+	// The field storing the content of the documents, default is content
+	ContentField string
+	// The field storing the vector, only used by milvus, default is vector
+	VectorField string
+	// The timeout of the calls in milliseconds, default is 10000ms
+	Timeout uint32
+}
+
+// vectorStoreDialect builds the requests and parses the responses of a vector database.
+type vectorStoreDialect interface {
+	upsertRequest(config *VectorStoreConfig, documents []VectorDocument) (string, string, [][2]string, []byte, error)
+	upsertResponse(body []byte) error
+	queryRequest(config *VectorStoreConfig, vector []float64, topK int) (string, string, [][2]string, []byte, error)
+	queryResponse(config *VectorStoreConfig, body []byte) ([]VectorSearchResult, error)
+}
+
+var vectorStoreDialects = map[string]vectorStoreDialect{
+	VectorStoreDashVector: dashVectorDialect{},
+	VectorStoreQdrant:     qdrantDialect{},
+	VectorStoreMilvus:     milvusDialect{},
+}
+
+func NewVectorStore(config VectorStoreConfig) (VectorStore, error) {
+	dialect, ok := vectorStoreDialects[config.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown vector store type: %s", config.Type)
+	}
+	if config.Client == nil || config.Collection == "" {
+		return nil, errors.New("vector store client or collection is empty")
+	}
+	if config.ContentField == "" {
+		config.ContentField = "content"
+	}
+	if config.VectorField == "" {
+		config.VectorField = "vector"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10000
+	}
+	return &httpVectorStore{config: config, dialect: dialect}, nil
+}
+
+// ParseVectorStore parses the config like:
+//
+//	{"type": "dashvector", "serviceName": "dashvector.dns", "serviceHost": "vrs-cn-xxx.dashvector.cn-hangzhou.aliyuncs.com",
+//	 "servicePort": 443, "apiKey": "sk-xxx", "collection": "semantic_cache"}
+func ParseVectorStore(json gjson.Result) (VectorStore, error) {
+	serviceName := json.Get("serviceName").String()
+	if serviceName == "" {
+		return nil, errors.New("vector store serviceName is empty")
+	}
+	servicePort := json.Get("servicePort").Int()
+	if servicePort == 0 {
+		servicePort = 443
+	}
+	return NewVectorStore(VectorStoreConfig{
+		Type:         json.Get("type").String(),
+		Client:       NewClusterClient(FQDNCluster{FQDN: serviceName, Host: json.Get("serviceHost").String(), Port: servicePort}),
+		ApiKey:       json.Get("apiKey").String(),
+		Collection:   json.Get("collection").String(),
+		ContentField: json.Get("contentField").String(),
+		VectorField:  json.Get("vectorField").String(),
+		Timeout:      uint32(json.Get("timeout").Uint()),
+	})
+}
+
+type httpVectorStore struct {
+	config  VectorStoreConfig
+	dialect vectorStoreDialect
+}
+
+func vectorStoreError(statusCode int, body []byte) error {
+	if statusCode >= 200 && statusCode < 300 {
+		return nil
+	}
+	return fmt.Errorf("vector store responded with status %d: %s", statusCode, body)
+}
+
+func (s *httpVectorStore) Upsert(documents []VectorDocument, callback func(error)) error {
+	method, path, headers, body, err := s.dialect.upsertRequest(&s.config, documents)
+	if err != nil {
+		return err
+	}
+	return s.config.Client.Call(method, path, headers, body, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if err := vectorStoreError(statusCode, responseBody); err != nil {
+			callback(err)
+			return
+		}
+		callback(s.dialect.upsertResponse(responseBody))
+	}, s.config.Timeout)
+}
+
+func (s *httpVectorStore) Query(vector []float64, topK int, callback func([]VectorSearchResult, error)) error {
+	method, path, headers, body, err := s.dialect.queryRequest(&s.config, vector, topK)
+	if err != nil {
+		return err
+	}
+	return s.config.Client.Call(method, path, headers, body, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if err := vectorStoreError(statusCode, responseBody); err != nil {
+			callback(nil, err)
+			return
+		}
+		callback(s.dialect.queryResponse(&s.config, responseBody))
+	}, s.config.Timeout)
+}
+
+// documentFields merges the content into the metadata.
+func documentFields(config *VectorStoreConfig, document VectorDocument) map[string]interface{} {
+	fields := map[string]interface{}{}
+	for k, v := range document.Metadata {
+		fields[k] = v
+	}
+	if document.Content != "" {
+		fields[config.ContentField] = document.Content
+	}
+	return fields
+}
+
+// searchResult splits the content out of the fields.
+func searchResult(config *VectorStoreConfig, id string, score float64, fields gjson.Result) VectorSearchResult {
+	result := VectorSearchResult{ID: id, Score: score, Metadata: map[string]interface{}{}}
+	fields.ForEach(func(key, value gjson.Result) bool {
+		if key.String() == config.ContentField {
+			result.Content = value.String()
+		} else {
+			result.Metadata[key.String()] = value.Value()
+		}
+		return true
+	})
+	return result
+}
+
+func checkJsonResponse(body []byte) error {
+	if !gjson.ValidBytes(body) {
+		return fmt.Errorf("invalid vector store response: %s", body)
+	}
+	return nil
+}
+
+type dashVectorDialect struct{}
+
+func (dashVectorDialect) headers(config *VectorStoreConfig) [][2]string {
+	return [][2]string{{"content-type", "application/json"}, {"dashvector-auth-token", config.ApiKey}}
+}
+
+func (d dashVectorDialect) upsertRequest(config *VectorStoreConfig, documents []VectorDocument) (string, string, [][2]string, []byte, error) {
+	var docs []map[string]interface{}
+	for _, document := range documents {
+		docs = append(docs, map[string]interface{}{"id": document.ID, "vector": document.Vector, "fields": documentFields(config, document)})
+	}
+	body, err := json.Marshal(map[string]interface{}{"docs": docs})
+	return http.MethodPost, "/v1/collections/" + config.Collection + "/docs/upsert", d.headers(config), body, err
+}
+
+func dashVectorCheck(body []byte) error {
+	if err := checkJsonResponse(body); err != nil {
+		return err
+	}
+	if code := gjson.GetBytes(body, "code").Int(); code != 0 {
+		return fmt.Errorf("dashvector error %d: %s", code, gjson.GetBytes(body, "message").String())
+	}
+	return nil
+}
+
+func (dashVectorDialect) upsertResponse(body []byte) error {
+	return dashVectorCheck(body)
+}
+
+func (d dashVectorDialect) queryRequest(config *VectorStoreConfig, vector []float64, topK int) (string, string, [][2]string, []byte, error) {
+	body, err := json.Marshal(map[string]interface{}{"vector": vector, "topk": topK, "include_vector": false})
+	return http.MethodPost, "/v1/collections/" + config.Collection + "/query", d.headers(config), body, err
+}
+
+func (dashVectorDialect) queryResponse(config *VectorStoreConfig, body []byte) ([]VectorSearchResult, error) {
+	if err := dashVectorCheck(body); err != nil {
+		return nil, err
+	}
+	var results []VectorSearchResult
+	for _, item := range gjson.GetBytes(body, "output").Array() {
+		results = append(results, searchResult(config, item.Get("id").String(), item.Get("score").Float(), item.Get("fields")))
+	}
+	return results, nil
+}
+
+// qdrantDialect uses the REST API of Qdrant, the id of a point must be an unsigned integer or a UUID.
+type qdrantDialect struct{}
+
+func (qdrantDialect) headers(config *VectorStoreConfig) [][2]string {
+	headers := [][2]string{{"content-type", "application/json"}}
+	if config.ApiKey != "" {
+		headers = append(headers, [2]string{"api-key", config.ApiKey})
+	}
+	return headers
+}
+
+func (d qdrantDialect) upsertRequest(config *VectorStoreConfig, documents []VectorDocument) (string, string, [][2]string, []byte, error) {
+	var points []map[string]interface{}
+	for _, document := range documents {
+		points = append(points, map[string]interface{}{"id": document.ID, "vector": document.Vector, "payload": documentFields(config, document)})
+	}
+	body, err := json.Marshal(map[string]interface{}{"points": points})
+	return http.MethodPut, "/collections/" + config.Collection + "/points?wait=true", d.headers(config), body, err
+}
+
+func (qdrantDialect) upsertResponse(body []byte) error {
+	if err := checkJsonResponse(body); err != nil {
+		return err
+	}
+	if status := gjson.GetBytes(body, "status"); status.Exists() && status.String() != "ok" {
+		return fmt.Errorf("qdrant error: %s", status.String())
+	}
+	return nil
+}
+
+func (d qdrantDialect) queryRequest(config *VectorStoreConfig, vector []float64, topK int) (string, string, [][2]string, []byte, error) {
+	body, err := json.Marshal(map[string]interface{}{"vector": vector, "limit": topK, "with_payload": true})
+	return http.MethodPost, "/collections/" + config.Collection + "/points/search", d.headers(config), body, err
+}
+
+func (qdrantDialect) queryResponse(config *VectorStoreConfig, body []byte) ([]VectorSearchResult, error) {
+	if err := checkJsonResponse(body); err != nil {
+		return nil, err
+	}
+	var results []VectorSearchResult
+	for _, item := range gjson.GetBytes(body, "result").Array() {
+		results = append(results, searchResult(config, item.Get("id").String(), item.Get("score").Float(), item.Get("payload")))
+	}
+	return results, nil
+}
+
+// milvusDialect uses the RESTful API v2 of Milvus, the collection must have a varchar primary key named id.
+type milvusDialect struct{}
+
+func (milvusDialect) headers(config *VectorStoreConfig) [][2]string {
+	headers := [][2]string{{"content-type", "application/json"}}
+	if config.ApiKey != "" {
+		headers = append(headers, [2]string{"authorization", "Bearer " + config.ApiKey})
+	}
+	return headers
+}
+
+func (d milvusDialect) upsertRequest(config *VectorStoreConfig, documents []VectorDocument) (string, string, [][2]string, []byte, error) {
+	var data []map[string]interface{}
+	for _, document := range documents {
+		entity := documentFields(config, document)
+		entity["id"] = document.ID
+		entity[config.VectorField] = document.Vector
+		data = append(data, entity)
+	}
+	body, err := json.Marshal(map[string]interface{}{"collectionName": config.Collection, "data": data})
+	return http.MethodPost, "/v2/vectordb/entities/upsert", d.headers(config), body, err
+}
+
+func milvusCheck(body []byte) error {
+	if err := checkJsonResponse(body); err != nil {
+		return err
+	}
+	if code := gjson.GetBytes(body, "code").Int(); code != 0 {
+		return fmt.Errorf("milvus error %d: %s", code, gjson.GetBytes(body, "message").String())
+	}
+	return nil
+}
+
+func (milvusDialect) upsertResponse(body []byte) error {
+	return milvusCheck(body)
+}
+
+func (d milvusDialect) queryRequest(config *VectorStoreConfig, vector []float64, topK int) (string, string, [][2]string, []byte, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"collectionName": config.Collection,
+		"data":           [][]float64{vector},
+		"annsField":      config.VectorField,
+		"limit":          topK,
+		"outputFields":   []string{"*"},
+	})
+	return http.MethodPost, "/v2/vectordb/entities/search", d.headers(config), body, err
+}
+
+func (milvusDialect) queryResponse(config *VectorStoreConfig, body []byte) ([]VectorSearchResult, error) {
+	if err := milvusCheck(body); err != nil {
+		return nil, err
+	}
+	var results []VectorSearchResult
+	for _, item := range gjson.GetBytes(body, "data").Array() {
+		fields := map[string]interface{}{}
+		item.ForEach(func(key, value gjson.Result) bool {
+			switch key.String() {
+			case "id", "distance", config.VectorField:
+			default:
+				fields[key.String()] = value.Value()
+			}
+			return true
+		})
+		raw, _ := json.Marshal(fields)
+		results = append(results, searchResult(config, item.Get("id").String(), item.Get("distance").Float(), gjson.ParseBytes(raw)))
+	}
+	return results, nil
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+var testVectorDocument = VectorDocument{ID: "doc-1", Vector: []float64{0.1, 0.2}, Content: "hello", Metadata: map[string]interface{}{"lang": "en"}}
+
+func TestDashVectorDialect(t *testing.T) {
+	config := &VectorStoreConfig{Collection: "cache", ApiKey: "sk", ContentField: "content", VectorField: "vector"}
+	method, path, headers, body, err := dashVectorDialect{}.upsertRequest(config, []VectorDocument{testVectorDocument})
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodPost, method)
+	assert.Equal(t, "/v1/collections/cache/docs/upsert", path)
+	assert.Contains(t, headers, [2]string{"dashvector-auth-token", "sk"})
+	assert.JSONEq(t, `{"docs": [{"id": "doc-1", "vector": [0.1, 0.2], "fields": {"content": "hello", "lang": "en"}}]}`, string(body))
+
+	assert.NoError(t, dashVectorDialect{}.upsertResponse([]byte(`{"code": 0, "output": []}`)))
+	assert.Error(t, dashVectorDialect{}.upsertResponse([]byte(`{"code": -2021, "message": "invalid dimension"}`)))
+
+	results, err := dashVectorDialect{}.queryResponse(config, []byte(`{"code": 0, "output": [{"id": "doc-1", "score": 0.05, "fields": {"content": "hello", "lang": "en"}}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, []VectorSearchResult{{ID: "doc-1", Score: 0.05, Content: "hello", Metadata: map[string]interface{}{"lang": "en"}}}, results)
+}
+
+func TestQdrantDialect(t *testing.T) {
+	config := &VectorStoreConfig{Collection: "cache", ContentField: "content", VectorField: "vector"}
+	method, path, headers, body, err := qdrantDialect{}.upsertRequest(config, []VectorDocument{testVectorDocument})
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodPut, method)
+	assert.Equal(t, "/collections/cache/points?wait=true", path)
+	assert.NotContains(t, headers, [2]string{"api-key", ""})
+	assert.Equal(t, "hello", gjson.GetBytes(body, "points.0.payload.content").String())
+
+	_, path, _, body, err = qdrantDialect{}.queryRequest(config, []float64{0.1}, 3)
+	require.NoError(t, err)
+	assert.Equal(t, "/collections/cache/points/search", path)
+	assert.JSONEq(t, `{"vector": [0.1], "limit": 3, "with_payload": true}`, string(body))
+
+	results, err := qdrantDialect{}.queryResponse(config, []byte(`{"status": "ok", "result": [{"id": 42, "score": 0.98, "payload": {"content": "hi"}}]}`))
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "42", results[0].ID)
+	assert.Equal(t, "hi", results[0].Content)
+	assert.Error(t, qdrantDialect{}.upsertResponse([]byte(`{"status": {"error": "not found"}}`)))
+}
+
+func TestMilvusDialect(t *testing.T) {
+	config := &VectorStoreConfig{Collection: "cache", ApiKey: "root:Milvus", ContentField: "content", VectorField: "embedding"}
+	_, path, headers, body, err := milvusDialect{}.upsertRequest(config, []VectorDocument{testVectorDocument})
+	require.NoError(t, err)
+	assert.Equal(t, "/v2/vectordb/entities/upsert", path)
+	assert.Contains(t, headers, [2]string{"authorization", "Bearer root:Milvus"})
+	assert.JSONEq(t, `{"collectionName": "cache", "data": [{"id": "doc-1", "embedding": [0.1, 0.2], "content": "hello", "lang": "en"}]}`, string(body))
+
+	_, _, _, body, err = milvusDialect{}.queryRequest(config, []float64{0.1}, 5)
+	require.NoError(t, err)
+	assert.Equal(t, "embedding", gjson.GetBytes(body, "annsField").String())
+
+	results, err := milvusDialect{}.queryResponse(config, []byte(`{"code": 0, "data": [{"id": "doc-1", "distance": 0.9, "content": "hello", "lang": "en"}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, []VectorSearchResult{{ID: "doc-1", Score: 0.9, Content: "hello", Metadata: map[string]interface{}{"lang": "en"}}}, results)
+	_, err = milvusDialect{}.queryResponse(config, []byte(`{"code": 1100, "message": "collection not found"}`))
+	assert.Error(t, err)
+}
+
+func TestParseVectorStore(t *testing.T) {
+	store, err := ParseVectorStore(gjson.Parse(`{"type": "qdrant", "serviceName": "qdrant.dns", "servicePort": 6333, "collection": "docs"}`))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(10000), store.(*httpVectorStore).config.Timeout)
+
+	_, err = ParseVectorStore(gjson.Parse(`{"type": "unknown", "serviceName": "a.dns", "collection": "docs"}`))
+	assert.Error(t, err)
+	_, err = ParseVectorStore(gjson.Parse(`{"type": "qdrant", "serviceName": "a.dns"}`))
+	assert.Error(t, err)
+}
+
+type fakeEmbeddingsProvider struct {
+	LLMProvider
+}
+
+func (fakeEmbeddingsProvider) Embeddings(request *EmbeddingsRequest, callback func(*EmbeddingsResponse, error)) error {
+	response := &EmbeddingsResponse{}
+	for i := len(request.Input) - 1; i >= 0; i-- {
+		response.Data = append(response.Data, Embedding{Index: i, Embedding: []float64{float64(len(request.Input[i]))}})
+	}
+	callback(response, nil)
+	return nil
+}
+
+func TestEmbeddingsClient(t *testing.T) {
+	var vectors [][]float64
+	err := NewEmbeddingsClient(fakeEmbeddingsProvider{}, "text-embedding-v2").Embed([]string{"a", "bb"}, func(v [][]float64, err error) {
+		require.NoError(t, err)
+		vectors = v
+	})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{1}, {2}}, vectors)
+}
@@ -0,0 +1,369 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+const wafStateContextKey = "__waf_state__"
+
+type WafInterruption struct {
+	RuleID int
+	// The disruptive action, e.g. "deny", "drop" or "redirect"
+	Action string
+	// The status code to reply, 403 is used if zero
+	Status int
+	Data   string
+}
+
+type WafRuleMatch struct {
+	RuleID   int
+	Severity string
+	Message  string
+	// The full log line of the match, written to the plugin log when the transaction ends
+	Log string
+}
+
+type WafRequest struct {
+	ClientIP   string
+	ClientPort int
+	ServerIP   string
+	ServerPort int
+	Method     string
+	// The path including the query string
+	URI      string
+	Protocol string
+	Host     string
+	Headers  [][2]string
+}
+
+// WafTransaction evaluates the rules of one request, a nil interruption means the request can go on.
+type WafTransaction interface {
+	EvalRequestHeaders(req WafRequest) *WafInterruption
+	// The body is the whole buffered body
+	EvalRequestBody(body []byte) (*WafInterruption, error)
+	EvalResponseHeaders(statusCode int, protocol string, headers [][2]string) *WafInterruption
+	EvalResponseBody(body []byte) (*WafInterruption, error)
+	MatchedRules() []WafRuleMatch
+	// Close runs the logging phase and releases the transaction
+	Close() error
+}
+
+type WafEngine interface {
+	NewTransaction() WafTransaction
+}
+
+// CorazaStyleTransaction is the method set of a Coraza transaction, I is the interruption pointer type and M is the
+// matched rule type of the engine, so that the wrapper does not depend on the engine module.
+type CorazaStyleTransaction[I any, M any] interface {
+	ProcessConnection(client string, cPort int, server string, sPort int)
+	ProcessURI(uri string, method string, httpVersion string)
+	SetServerName(serverName string)
+	AddRequestHeader(key string, value string)
+	ProcessRequestHeaders() I
+	WriteRequestBody(body []byte) (I, int, error)
+	ProcessRequestBody() (I, error)
+	AddResponseHeader(key string, value string)
+	ProcessResponseHeaders(code int, proto string) I
+	WriteResponseBody(body []byte) (I, int, error)
+	ProcessResponseBody() (I, error)
+	IsRuleEngineOff() bool
+	IsRequestBodyAccessible() bool
+	IsResponseBodyAccessible() bool
+	MatchedRules() []M
+	ProcessLogging()
+	Close() error
+}
+
+type corazaStyleEngine[I any, M any] struct {
+	newTransaction   func() CorazaStyleTransaction[I, M]
+	convertInterrupt func(I) *WafInterruption
+	convertMatch     func(M) WafRuleMatch
+}
+
+// NewCorazaStyleEngine adapts a Coraza-style engine. convertInterrupt must return nil for a nil interruption. For example:
+//
+//	engine := wrapper.NewCorazaStyleEngine(
+//		func() wrapper.CorazaStyleTransaction[*ctypes.Interruption, ctypes.MatchedRule] { return waf.NewTransaction() },
+//		func(it *ctypes.Interruption) *wrapper.WafInterruption {
+//			if it == nil {
+//				return nil
+//			}
+//			return &wrapper.WafInterruption{RuleID: it.RuleID, Action: it.Action, Status: it.Status, Data: it.Data}
+//		},
+//		func(m ctypes.MatchedRule) wrapper.WafRuleMatch {
+//			return wrapper.WafRuleMatch{RuleID: m.Rule().ID(), Severity: m.Rule().Severity().String(), Message: m.Message(), Log: m.ErrorLog()}
+//		})
+func NewCorazaStyleEngine[I any, M any](newTransaction func() CorazaStyleTransaction[I, M],
+	convertInterrupt func(I) *WafInterruption, convertMatch func(M) WafRuleMatch) WafEngine {
+	return &corazaStyleEngine[I, M]{newTransaction, convertInterrupt, convertMatch}
+}
+
+func (e *corazaStyleEngine[I, M]) NewTransaction() WafTransaction {
+	return &corazaStyleTransaction[I, M]{engine: e, tx: e.newTransaction()}
+}
+
+type corazaStyleTransaction[I any, M any] struct {
+	engine *corazaStyleEngine[I, M]
+	tx     CorazaStyleTransaction[I, M]
+}
+
+func (t *corazaStyleTransaction[I, M]) EvalRequestHeaders(req WafRequest) *WafInterruption {
+	if t.tx.IsRuleEngineOff() {
+		return nil
+	}
+	t.tx.ProcessConnection(req.ClientIP, req.ClientPort, req.ServerIP, req.ServerPort)
+	t.tx.ProcessURI(req.URI, req.Method, req.Protocol)
+	for _, h := range req.Headers {
+		t.tx.AddRequestHeader(h[0], h[1])
+	}
+	if req.Host != "" {
+		// the host header is the :authority pseudo header in envoy
+		t.tx.AddRequestHeader("Host", req.Host)
+		if host, _, err := net.SplitHostPort(req.Host); err == nil {
+			t.tx.SetServerName(host)
+		} else {
+			t.tx.SetServerName(req.Host)
+		}
+	}
+	return t.engine.convertInterrupt(t.tx.ProcessRequestHeaders())
+}
+
+func (t *corazaStyleTransaction[I, M]) EvalRequestBody(body []byte) (*WafInterruption, error) {
+	if t.tx.IsRuleEngineOff() {
+		return nil, nil
+	}
+	if t.tx.IsRequestBodyAccessible() && len(body) > 0 {
+		interruption, _, err := t.tx.WriteRequestBody(body)
+		if err != nil {
+			return nil, err
+		}
+		if it := t.engine.convertInterrupt(interruption); it != nil {
+			return it, nil
+		}
+	}
+	interruption, err := t.tx.ProcessRequestBody()
+	return t.engine.convertInterrupt(interruption), err
+}
+
+func (t *corazaStyleTransaction[I, M]) EvalResponseHeaders(statusCode int, protocol string, headers [][2]string) *WafInterruption {
+	if t.tx.IsRuleEngineOff() {
+		return nil
+	}
+	for _, h := range headers {
+		t.tx.AddResponseHeader(h[0], h[1])
+	}
+	return t.engine.convertInterrupt(t.tx.ProcessResponseHeaders(statusCode, protocol))
+}
+
+func (t *corazaStyleTransaction[I, M]) EvalResponseBody(body []byte) (*WafInterruption, error) {
+	if t.tx.IsRuleEngineOff() {
+		return nil, nil
+	}
+	if t.tx.IsResponseBodyAccessible() && len(body) > 0 {
+		interruption, _, err := t.tx.WriteResponseBody(body)
+		if err != nil {
+			return nil, err
+		}
+		if it := t.engine.convertInterrupt(interruption); it != nil {
+			return it, nil
+		}
+	}
+	interruption, err := t.tx.ProcessResponseBody()
+	return t.engine.convertInterrupt(interruption), err
+}
+
+func (t *corazaStyleTransaction[I, M]) MatchedRules() []WafRuleMatch {
+	var matches []WafRuleMatch
+	for _, m := range t.tx.MatchedRules() {
+		matches = append(matches, t.engine.convertMatch(m))
+	}
+	return matches
+}
+
+func (t *corazaStyleTransaction[I, M]) Close() error {
+	t.tx.ProcessLogging()
+	return t.tx.Close()
+}
+
+type wafState struct {
+	tx                    WafTransaction
+	protocol              string
+	interrupted           bool
+	requestBodyProcessed  bool
+	responseBodyProcessed bool
+}
+
+// WafPipeline wires a WafEngine into the plugin phases, call its methods from the corresponding handlers of the plugin.
+type WafPipeline struct {
+	Engine WafEngine
+	// Only log the matched rules instead of blocking the request
+	DetectOnly bool
+}
+
+func (p *WafPipeline) state(ctx HttpContext) *wafState {
+	state, _ := ctx.GetContext(wafStateContextKey).(*wafState)
+	return state
+}
+
+func (p *WafPipeline) handleInterruption(ctx HttpContext, state *wafState, phase string, it *WafInterruption, bodySize int) types.Action {
+	proxywasm.LogWarnf("waf interruption in %s, rule: %d, action: %s, status: %d", phase, it.RuleID, it.Action, it.Status)
+	ctx.SetUserAttribute("waf_rule_id", it.RuleID)
+	ctx.SetUserAttribute("waf_action", it.Action)
+	if p.DetectOnly {
+		return types.ActionContinue
+	}
+	state.interrupted = true
+	if phase == "http_response_body" {
+		// the response headers are already sent, only the body can be masked
+		if err := proxywasm.ReplaceHttpResponseBody(bytes.Repeat([]byte("\x00"), bodySize)); err != nil {
+			proxywasm.LogErrorf("failed to replace response body: %v", err)
+		}
+		return types.ActionContinue
+	}
+	status := it.Status
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+	_ = proxywasm.SendHttpResponseWithDetail(uint32(status), "waf."+strconv.Itoa(it.RuleID), nil, nil, -1)
+	return types.ActionPause
+}
+
+func addressOf(name string) (string, int) {
+	address := getPropertyString(name, "address")
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address, 0
+	}
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
+
+func (p *WafPipeline) OnRequestHeaders(ctx HttpContext) types.Action {
+	state := &wafState{tx: p.Engine.NewTransaction(), protocol: getPropertyString("request", "protocol")}
+	if state.protocol == "" {
+		state.protocol = "HTTP/2.0"
+	}
+	ctx.SetContext(wafStateContextKey, state)
+	req := WafRequest{
+		Method:   ctx.Method(),
+		URI:      ctx.Path(),
+		Protocol: state.protocol,
+		Host:     ctx.Host(),
+	}
+	req.ClientIP, req.ClientPort = addressOf("source")
+	req.ServerIP, req.ServerPort = addressOf("destination")
+	headers, _ := proxywasm.GetHttpRequestHeaders()
+	for _, h := range headers {
+		if len(h[0]) > 0 && h[0][0] != ':' {
+			req.Headers = append(req.Headers, h)
+		}
+	}
+	if it := state.tx.EvalRequestHeaders(req); it != nil {
+		return p.handleInterruption(ctx, state, "http_request_headers", it, 0)
+	}
+	return types.ActionContinue
+}
+
+func (p *WafPipeline) OnRequestBody(ctx HttpContext, body []byte) types.Action {
+	state := p.state(ctx)
+	if state == nil || state.interrupted {
+		return types.ActionContinue
+	}
+	state.requestBodyProcessed = true
+	it, err := state.tx.EvalRequestBody(body)
+	if err != nil {
+		proxywasm.LogErrorf("failed to evaluate request body: %v", err)
+		return types.ActionContinue
+	}
+	if it != nil {
+		return p.handleInterruption(ctx, state, "http_request_body", it, 0)
+	}
+	return types.ActionContinue
+}
+
+func (p *WafPipeline) OnResponseHeaders(ctx HttpContext) types.Action {
+	state := p.state(ctx)
+	if state == nil || state.interrupted {
+		return types.ActionContinue
+	}
+	// requests without body do not go through the request body phase, but its rules still need to run
+	if !state.requestBodyProcessed {
+		if action := p.OnRequestBody(ctx, nil); action != types.ActionContinue {
+			return action
+		}
+	}
+	headers, _ := proxywasm.GetHttpResponseHeaders()
+	statusCode := 0
+	var responseHeaders [][2]string
+	for _, h := range headers {
+		if h[0] == ":status" {
+			statusCode, _ = strconv.Atoi(h[1])
+		} else {
+			responseHeaders = append(responseHeaders, h)
+		}
+	}
+	if it := state.tx.EvalResponseHeaders(statusCode, state.protocol, responseHeaders); it != nil {
+		return p.handleInterruption(ctx, state, "http_response_headers", it, 0)
+	}
+	return types.ActionContinue
+}
+
+func (p *WafPipeline) OnResponseBody(ctx HttpContext, body []byte) types.Action {
+	state := p.state(ctx)
+	if state == nil || state.interrupted {
+		return types.ActionContinue
+	}
+	state.responseBodyProcessed = true
+	it, err := state.tx.EvalResponseBody(body)
+	if err != nil {
+		proxywasm.LogErrorf("failed to evaluate response body: %v", err)
+		return types.ActionContinue
+	}
+	if it != nil {
+		return p.handleInterruption(ctx, state, "http_response_body", it, len(body))
+	}
+	return types.ActionContinue
+}
+
+// OnStreamDone runs the remaining phases, logs the matched rules and closes the transaction.
+func (p *WafPipeline) OnStreamDone(ctx HttpContext) {
+	state := p.state(ctx)
+	if state == nil {
+		return
+	}
+	if !state.interrupted && !state.responseBodyProcessed {
+		if _, err := state.tx.EvalResponseBody(nil); err != nil {
+			proxywasm.LogErrorf("failed to evaluate response body: %v", err)
+		}
+	}
+	for _, match := range state.tx.MatchedRules() {
+		if match.Log != "" {
+			proxywasm.LogWarn(match.Log)
+		} else {
+			proxywasm.LogWarnf("waf rule %d matched: %s", match.RuleID, match.Message)
+		}
+	}
+	if err := state.tx.Close(); err != nil {
+		proxywasm.LogErrorf("failed to close waf transaction: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeInterruption struct {
+	id     int
+	status int
+}
+
+type fakeMatch struct {
+	id  int
+	msg string
+}
+
+// fakeCorazaTransaction blocks requests whose uri or body contains "attack"
+type fakeCorazaTransaction struct {
+	uri        string
+	headers    map[string]string
+	serverName string
+	body       []byte
+	logged     bool
+	closed     bool
+	matches    []fakeMatch
+}
+
+func (t *fakeCorazaTransaction) check(s string, id int) *fakeInterruption {
+	if strings.Contains(s, "attack") {
+		t.matches = append(t.matches, fakeMatch{id, "attack detected"})
+		return &fakeInterruption{id: id, status: 403}
+	}
+	return nil
+}
+
+func (t *fakeCorazaTransaction) ProcessConnection(string, int, string, int) {}
+func (t *fakeCorazaTransaction) ProcessURI(uri, method, httpVersion string) {
+	t.uri = uri
+}
+func (t *fakeCorazaTransaction) SetServerName(serverName string) { t.serverName = serverName }
+func (t *fakeCorazaTransaction) AddRequestHeader(key, value string) {
+	t.headers[key] = value
+}
+func (t *fakeCorazaTransaction) ProcessRequestHeaders() *fakeInterruption { return t.check(t.uri, 1) }
+func (t *fakeCorazaTransaction) WriteRequestBody(body []byte) (*fakeInterruption, int, error) {
+	t.body = append(t.body, body...)
+	return nil, len(body), nil
+}
+func (t *fakeCorazaTransaction) ProcessRequestBody() (*fakeInterruption, error) {
+	return t.check(string(t.body), 2), nil
+}
+func (t *fakeCorazaTransaction) AddResponseHeader(string, string) {}
+func (t *fakeCorazaTransaction) ProcessResponseHeaders(int, string) *fakeInterruption {
+	return nil
+}
+func (t *fakeCorazaTransaction) WriteResponseBody(body []byte) (*fakeInterruption, int, error) {
+	return nil, len(body), nil
+}
+func (t *fakeCorazaTransaction) ProcessResponseBody() (*fakeInterruption, error) { return nil, nil }
+func (t *fakeCorazaTransaction) IsRuleEngineOff() bool                           { return false }
+func (t *fakeCorazaTransaction) IsRequestBodyAccessible() bool                   { return true }
+func (t *fakeCorazaTransaction) IsResponseBodyAccessible() bool                  { return false }
+func (t *fakeCorazaTransaction) MatchedRules() []fakeMatch                       { return t.matches }
+func (t *fakeCorazaTransaction) ProcessLogging()                                 { t.logged = true }
+func (t *fakeCorazaTransaction) Close() error {
+	t.closed = true
+	return nil
+}
+
+func TestCorazaStyleEngine(t *testing.T) {
+	var last *fakeCorazaTransaction
+	engine := NewCorazaStyleEngine(
+		func() CorazaStyleTransaction[*fakeInterruption, fakeMatch] {
+			last = &fakeCorazaTransaction{headers: map[string]string{}}
+			return last
+		},
+		func(it *fakeInterruption) *WafInterruption {
+			if it == nil {
+				return nil
+			}
+			return &WafInterruption{RuleID: it.id, Status: it.status, Action: "deny"}
+		},
+		func(m fakeMatch) WafRuleMatch {
+			return WafRuleMatch{RuleID: m.id, Message: m.msg}
+		})
+
+	tx := engine.NewTransaction()
+	assert.Nil(t, tx.EvalRequestHeaders(WafRequest{URI: "/index", Host: "example.com:8080"}))
+	assert.Equal(t, "example.com:8080", last.headers["Host"])
+	assert.Equal(t, "example.com", last.serverName)
+	it, err := tx.EvalRequestBody([]byte("payload=attack"))
+	require.NoError(t, err)
+	assert.Equal(t, &WafInterruption{RuleID: 2, Status: 403, Action: "deny"}, it)
+	assert.Equal(t, []WafRuleMatch{{RuleID: 2, Message: "attack detected"}}, tx.MatchedRules())
+	require.NoError(t, tx.Close())
+	assert.True(t, last.logged)
+	assert.True(t, last.closed)
+
+	tx = engine.NewTransaction()
+	it = tx.EvalRequestHeaders(WafRequest{URI: "/attack"})
+	require.NotNil(t, it)
+	assert.Equal(t, 1, it.RuleID)
+	it, err = tx.EvalResponseBody([]byte("attack"))
+	require.NoError(t, err)
+	assert.Nil(t, it)
+}
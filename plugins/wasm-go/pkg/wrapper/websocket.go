@@ -0,0 +1,408 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+)
+
+type WebSocketOpcode byte
+
+const (
+	WebSocketContinuation WebSocketOpcode = 0x0
+	WebSocketText         WebSocketOpcode = 0x1
+	WebSocketBinary       WebSocketOpcode = 0x2
+	WebSocketClose        WebSocketOpcode = 0x8
+	WebSocketPing         WebSocketOpcode = 0x9
+	WebSocketPong         WebSocketOpcode = 0xa
+)
+
+// The close codes sent when a frame is rejected by the plugin, is malformed or is too large
+const (
+	WebSocketCloseProtocolError   = 1002
+	WebSocketClosePolicyViolation = 1008
+	WebSocketCloseMessageTooBig   = 1009
+)
+
+// The frames larger than it close the stream, default of WebSocketHandlers.MaxFrameSize
+const DefaultWebSocketMaxFrameSize = 1 << 20
+
+var (
+	ErrWebSocketFrameTooLarge = errors.New("websocket frame is too large")
+	ErrWebSocketFrameInvalid  = errors.New("websocket frame is invalid")
+)
+
+// IsWebSocketUpgrade returns true if the request upgrades to WebSocket, by the upgrade header of HTTP/1.1 or the
+// extended CONNECT of HTTP/2.
+func IsWebSocketUpgrade() bool {
+	upgrade, _ := proxywasm.GetHttpRequestHeader("upgrade")
+	if strings.EqualFold(upgrade, "websocket") {
+		return true
+	}
+	method, _ := proxywasm.GetHttpRequestHeader(":method")
+	protocol, _ := proxywasm.GetHttpRequestHeader(":protocol")
+	return method == "CONNECT" && strings.EqualFold(protocol, "websocket")
+}
+
+// IsWebSocket returns true if the request upgrades to WebSocket, the frames can be inspected with
+// WebSocketFrameHandler.
+func IsWebSocket(ctx HttpContext) bool {
+	if c, ok := ctx.(httpContextExtension); ok {
+		return c.isWebSocket()
+	}
+	return false
+}
+
+func (ctx *CommonHttpCtx[PluginConfig]) isWebSocket() bool {
+	return ctx.webSocket
+}
+
+// PrepareWebSocketInspection removes the extensions offered by the client in the request headers phase, so the frames
+// are not compressed by permessage-deflate. The wrapper calls it for the matched WebSocket requests once a
+// WebSocketFrameHandler is created, the plugins parsing the frames by themselves should call it.
+func PrepareWebSocketInspection() {
+	_ = proxywasm.RemoveHttpRequestHeader("sec-websocket-extensions")
+}
+
+type WebSocketFrame struct {
+	Fin bool
+	// The RSV1-3 bits, RSV1 is set by the compressed frames of permessage-deflate
+	Rsv    byte
+	Opcode WebSocketOpcode
+	// Whether the message the frame belongs to is compressed, only its first frame has RSV1 set
+	Compressed bool
+	// The type of the message the frame belongs to, it's the opcode of the first frame for the continuation frames
+	MessageType WebSocketOpcode
+	Masked      bool
+	Mask        [4]byte
+	// The unmasked payload
+	Payload []byte
+}
+
+func (f *WebSocketFrame) IsControl() bool {
+	return f.Opcode >= WebSocketClose
+}
+
+// CloseStatus returns the status code and the reason of a close frame, the code is 1005 if it's absent.
+func (f *WebSocketFrame) CloseStatus() (int, string) {
+	if len(f.Payload) < 2 {
+		return 1005, ""
+	}
+	return int(binary.BigEndian.Uint16(f.Payload)), string(f.Payload[2:])
+}
+
+// Bytes serializes the frame, the payload is masked with the mask of the frame if it's masked.
+func (f *WebSocketFrame) Bytes() []byte {
+	header := []byte{f.Rsv<<4 | byte(f.Opcode)&0x0f, 0}
+	if f.Fin {
+		header[0] |= 0x80
+	}
+	length := len(f.Payload)
+	switch {
+	case length < 126:
+		header[1] = byte(length)
+	case length <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+	if f.Masked {
+		header[1] |= 0x80
+		header = append(header, f.Mask[:]...)
+	}
+	data := append(header, f.Payload...)
+	if f.Masked {
+		maskPayload(data[len(header):], f.Mask)
+	}
+	return data
+}
+
+func maskPayload(payload []byte, mask [4]byte) {
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+}
+
+// NewWebSocketCloseFrame returns a close frame of the code and the reason, the frames sent by the client must be
+// masked.
+func NewWebSocketCloseFrame(code int, reason string, masked bool, mask [4]byte) *WebSocketFrame {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	// the payload of a control frame is at most 125 bytes
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	return &WebSocketFrame{Fin: true, Opcode: WebSocketClose, MessageType: WebSocketClose, Masked: masked, Mask: mask,
+		Payload: append(payload, reason...)}
+}
+
+// WebSocketParser parses the frames of a direction of the WebSocket stream from the chunks of the body, the
+// incomplete frame at the end of a chunk is kept until the next chunk.
+type WebSocketParser struct {
+	MaxFrameSize int
+	// Whether permessage-deflate is negotiated, RSV1 is a protocol error otherwise
+	Deflate     bool
+	buf         []byte
+	messageType WebSocketOpcode
+	compressed  bool
+	// whether the last frame parsed or rejected is masked
+	masked bool
+}
+
+func NewWebSocketParser(maxFrameSize int) *WebSocketParser {
+	if maxFrameSize <= 0 {
+		maxFrameSize = DefaultWebSocketMaxFrameSize
+	}
+	return &WebSocketParser{MaxFrameSize: maxFrameSize}
+}
+
+// Feed returns the complete frames of the chunk and the ones kept before. It returns ErrWebSocketFrameTooLarge if a
+// frame exceeds MaxFrameSize, or ErrWebSocketFrameInvalid if a frame is malformed, then Pending returns the bytes not
+// parsed.
+func (p *WebSocketParser) Feed(chunk []byte) ([]*WebSocketFrame, error) {
+	p.buf = append(p.buf, chunk...)
+	var frames []*WebSocketFrame
+	for {
+		frame, size, err := p.parse(p.buf)
+		if err != nil {
+			return frames, err
+		}
+		if frame == nil {
+			return frames, nil
+		}
+		p.buf = p.buf[size:]
+		frames = append(frames, frame)
+	}
+}
+
+// Pending returns the bytes of the incomplete frame and resets the parser.
+func (p *WebSocketParser) Pending() []byte {
+	pending := p.buf
+	p.buf = nil
+	return pending
+}
+
+func (p *WebSocketParser) parse(data []byte) (*WebSocketFrame, int, error) {
+	if len(data) < 2 {
+		return nil, 0, nil
+	}
+	frame := &WebSocketFrame{
+		Fin:    data[0]&0x80 != 0,
+		Rsv:    data[0] >> 4 & 0x07,
+		Opcode: WebSocketOpcode(data[0] & 0x0f),
+		Masked: data[1]&0x80 != 0,
+	}
+	p.masked = frame.Masked
+	if err := p.validate(frame); err != nil {
+		return nil, 0, err
+	}
+	offset := 2
+	length := uint64(data[1] & 0x7f)
+	switch length {
+	case 126:
+		if len(data) < offset+2 {
+			return nil, 0, nil
+		}
+		length = uint64(binary.BigEndian.Uint16(data[offset:]))
+		offset += 2
+	case 127:
+		if len(data) < offset+8 {
+			return nil, 0, nil
+		}
+		length = binary.BigEndian.Uint64(data[offset:])
+		offset += 8
+	}
+	if frame.IsControl() && length > 125 {
+		return nil, 0, fmt.Errorf("%w: control frame of %d bytes", ErrWebSocketFrameInvalid, length)
+	}
+	if length > uint64(p.MaxFrameSize) {
+		return nil, 0, fmt.Errorf("%w: %d bytes", ErrWebSocketFrameTooLarge, length)
+	}
+	if frame.Masked {
+		if len(data) < offset+4 {
+			return nil, 0, nil
+		}
+		copy(frame.Mask[:], data[offset:offset+4])
+		offset += 4
+	}
+	if uint64(len(data)-offset) < length {
+		return nil, 0, nil
+	}
+	end := offset + int(length)
+	frame.Payload = append([]byte{}, data[offset:end]...)
+	if frame.Masked {
+		maskPayload(frame.Payload, frame.Mask)
+	}
+	switch {
+	case frame.IsControl():
+		frame.MessageType = frame.Opcode
+	case frame.Opcode == WebSocketContinuation:
+		frame.MessageType = p.messageType
+		frame.Compressed = p.compressed
+	default:
+		frame.MessageType = frame.Opcode
+		frame.Compressed = frame.Rsv&0x04 != 0
+		p.messageType = frame.Opcode
+		p.compressed = frame.Compressed
+	}
+	return frame, end, nil
+}
+
+func (p *WebSocketParser) validate(frame *WebSocketFrame) error {
+	switch frame.Opcode {
+	case WebSocketContinuation, WebSocketText, WebSocketBinary:
+	case WebSocketClose, WebSocketPing, WebSocketPong:
+		if !frame.Fin {
+			return fmt.Errorf("%w: fragmented control frame", ErrWebSocketFrameInvalid)
+		}
+	default:
+		return fmt.Errorf("%w: reserved opcode 0x%x", ErrWebSocketFrameInvalid, byte(frame.Opcode))
+	}
+	// RSV1 marks the first frame of a compressed message, RSV2 and RSV3 are not defined by any extension we accept
+	compressed := p.Deflate && !frame.IsControl() && frame.Opcode != WebSocketContinuation
+	if frame.Rsv&0x03 != 0 || (frame.Rsv&0x04 != 0 && !compressed) {
+		return fmt.Errorf("%w: unexpected rsv bits 0x%x", ErrWebSocketFrameInvalid, frame.Rsv)
+	}
+	return nil
+}
+
+type WebSocketVerdict int
+
+const (
+	// Forward the frame, with the changes made to its payload
+	WebSocketForward WebSocketVerdict = iota
+	// Drop the frame
+	WebSocketDrop
+	// Replace the frame with a close frame of policy violation, the frames after it are dropped
+	WebSocketReject
+)
+
+type WebSocketFrameFunc[PluginConfig any] func(ctx HttpContext, config PluginConfig, frame *WebSocketFrame) WebSocketVerdict
+
+// WebSocketHandlers are the callbacks of the frames, the text and binary ones also receive the continuation frames of
+// their messages. The ping and pong frames are forwarded. The extensions offered by the client are removed, so a
+// compressed frame is a protocol error and closes the stream.
+type WebSocketHandlers[PluginConfig any] struct {
+	OnText   WebSocketFrameFunc[PluginConfig]
+	OnBinary WebSocketFrameFunc[PluginConfig]
+	OnClose  WebSocketFrameFunc[PluginConfig]
+	// The frames larger than it close the stream, default is 1MB
+	MaxFrameSize int
+	// The reason of the close frame replacing a rejected frame
+	RejectReason string
+}
+
+type webSocketStream struct {
+	parser *WebSocketParser
+	closed bool
+}
+
+// the extensions are removed from the WebSocket requests once a handler is created
+var webSocketHandlerCount int32
+
+func webSocketInspected() bool {
+	return atomic.LoadInt32(&webSocketHandlerCount) > 0
+}
+
+// WebSocketFrameHandler returns the handler for ProcessStreamingRequestBodyBy or ProcessStreamingResponseBodyBy, it
+// parses the frames of the WebSocket requests and calls the handlers, the body of the other requests passes as is.
+// Create a handler for each direction:
+//
+//	wrapper.ProcessStreamingRequestBodyBy(wrapper.WebSocketFrameHandler(wrapper.WebSocketHandlers[Config]{OnText: limitMessages}))
+//
+// If a frame is too large or malformed, it's replaced with a close frame of 1009 or 1002 and the rest of the stream is
+// dropped, so the frames can't bypass the handlers.
+func WebSocketFrameHandler[PluginConfig any](handlers WebSocketHandlers[PluginConfig]) onHttpStreamingBodyFunc[PluginConfig] {
+	contextKey := fmt.Sprintf("__websocket_stream_%d__", atomic.AddInt32(&webSocketHandlerCount, 1))
+	if handlers.RejectReason == "" {
+		handlers.RejectReason = "rejected by gateway"
+	}
+	return func(ctx HttpContext, config PluginConfig, chunk []byte, isLastChunk bool, log Log) []byte {
+		if !IsWebSocket(ctx) {
+			return chunk
+		}
+		stream, ok := ctx.GetContext(contextKey).(*webSocketStream)
+		if !ok {
+			stream = &webSocketStream{parser: NewWebSocketParser(handlers.MaxFrameSize)}
+			ctx.SetContext(contextKey, stream)
+		}
+		if stream.closed {
+			return []byte{}
+		}
+		frames, err := stream.parser.Feed(chunk)
+		var out []byte
+		for _, frame := range frames {
+			if stream.closed {
+				break
+			}
+			out = append(out, handlers.process(ctx, config, stream, frame)...)
+		}
+		if err != nil && !stream.closed {
+			log.Warnf("close the websocket stream: %v", err)
+			code := WebSocketCloseProtocolError
+			if errors.Is(err, ErrWebSocketFrameTooLarge) {
+				code = WebSocketCloseMessageTooBig
+			}
+			stream.closed = true
+			stream.parser.Pending()
+			DebugDecision(ctx, "websocket stream is closed: %v", err)
+			out = append(out, NewWebSocketCloseFrame(code, "", stream.parser.masked, [4]byte{}).Bytes()...)
+		} else if isLastChunk {
+			out = append(out, stream.parser.Pending()...)
+		}
+		if out == nil {
+			return []byte{}
+		}
+		return out
+	}
+}
+
+func (h *WebSocketHandlers[PluginConfig]) process(ctx HttpContext, config PluginConfig, stream *webSocketStream, frame *WebSocketFrame) []byte {
+	var handler WebSocketFrameFunc[PluginConfig]
+	switch frame.MessageType {
+	case WebSocketText:
+		handler = h.OnText
+	case WebSocketBinary:
+		handler = h.OnBinary
+	case WebSocketClose:
+		handler = h.OnClose
+	}
+	if frame.Opcode == WebSocketClose {
+		stream.closed = true
+	}
+	// the payload of the compressed messages can't be inspected
+	if handler == nil || frame.Compressed {
+		return frame.Bytes()
+	}
+	switch handler(ctx, config, frame) {
+	case WebSocketDrop:
+		if frame.Opcode == WebSocketClose {
+			stream.closed = false
+		}
+		return nil
+	case WebSocketReject:
+		stream.closed = true
+		DebugDecision(ctx, "websocket frame is rejected: %s", h.RejectReason)
+		return NewWebSocketCloseFrame(WebSocketClosePolicyViolation, h.RejectReason, frame.Masked, frame.Mask).Bytes()
+	}
+	return frame.Bytes()
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebSocketParser(t *testing.T) {
+	mask := [4]byte{1, 2, 3, 4}
+	first := &WebSocketFrame{Opcode: WebSocketText, Masked: true, Mask: mask, Payload: []byte("hel")}
+	second := &WebSocketFrame{Fin: true, Opcode: WebSocketContinuation, Masked: true, Mask: mask, Payload: []byte("lo")}
+	large := &WebSocketFrame{Fin: true, Opcode: WebSocketBinary, Payload: bytes.Repeat([]byte("x"), 70000)}
+	closeFrame := NewWebSocketCloseFrame(1000, "bye", false, [4]byte{})
+	data := bytes.Join([][]byte{first.Bytes(), second.Bytes(), large.Bytes(), closeFrame.Bytes()}, nil)
+
+	// the frames are parsed across the chunks
+	parser := NewWebSocketParser(0)
+	var frames []*WebSocketFrame
+	for i := 0; i < len(data); i += 7 {
+		end := i + 7
+		if end > len(data) {
+			end = len(data)
+		}
+		parsed, err := parser.Feed(data[i:end])
+		require.NoError(t, err)
+		frames = append(frames, parsed...)
+	}
+	assert.Empty(t, parser.Pending())
+	require.Len(t, frames, 4)
+	assert.Equal(t, "hel", string(frames[0].Payload))
+	assert.False(t, frames[0].Fin)
+	assert.Equal(t, WebSocketText, frames[1].MessageType)
+	assert.Equal(t, WebSocketContinuation, frames[1].Opcode)
+	assert.Equal(t, "lo", string(frames[1].Payload))
+	assert.Equal(t, 70000, len(frames[2].Payload))
+	assert.True(t, frames[3].IsControl())
+	code, reason := frames[3].CloseStatus()
+	assert.Equal(t, 1000, code)
+	assert.Equal(t, "bye", reason)
+
+	// the frames are serialized as they are received
+	var out []byte
+	for _, frame := range frames {
+		out = append(out, frame.Bytes()...)
+	}
+	assert.Equal(t, data, out)
+}
+
+func TestWebSocketParserFrameTooLarge(t *testing.T) {
+	parser := NewWebSocketParser(10)
+	small := (&WebSocketFrame{Fin: true, Opcode: WebSocketText, Payload: []byte("ok")}).Bytes()
+	large := (&WebSocketFrame{Fin: true, Opcode: WebSocketText, Payload: []byte("too large payload")}).Bytes()
+	frames, err := parser.Feed(append(small, large[:5]...))
+	assert.True(t, errors.Is(err, ErrWebSocketFrameTooLarge))
+	require.Len(t, frames, 1)
+	assert.Equal(t, large[:5], parser.Pending())
+}
+
+func TestWebSocketParserFrameInvalid(t *testing.T) {
+	for name, frame := range map[string]*WebSocketFrame{
+		"rsv1":               {Fin: true, Rsv: 0x04, Opcode: WebSocketText, Payload: []byte("x")},
+		"rsv2":               {Fin: true, Rsv: 0x02, Opcode: WebSocketText},
+		"reserved opcode":    {Fin: true, Opcode: 0x3},
+		"fragmented control": {Opcode: WebSocketPing},
+		"large control":      {Fin: true, Opcode: WebSocketPing, Payload: bytes.Repeat([]byte("x"), 126)},
+	} {
+		_, err := NewWebSocketParser(0).Feed(frame.Bytes())
+		assert.True(t, errors.Is(err, ErrWebSocketFrameInvalid), name)
+	}
+
+	// RSV1 is only allowed on the first frame of a message once permessage-deflate is negotiated
+	parser := NewWebSocketParser(0)
+	parser.Deflate = true
+	frames, err := parser.Feed((&WebSocketFrame{Fin: true, Rsv: 0x04, Opcode: WebSocketText, Payload: []byte("x")}).Bytes())
+	require.NoError(t, err)
+	assert.Len(t, frames, 1)
+	_, err = parser.Feed((&WebSocketFrame{Fin: true, Rsv: 0x04, Opcode: WebSocketContinuation}).Bytes())
+	assert.True(t, errors.Is(err, ErrWebSocketFrameInvalid))
+}
+
+func TestWebSocketParserCompressedMessage(t *testing.T) {
+	parser := NewWebSocketParser(0)
+	parser.Deflate = true
+	data := bytes.Join([][]byte{
+		(&WebSocketFrame{Rsv: 0x04, Opcode: WebSocketText, Payload: []byte("a")}).Bytes(),
+		(&WebSocketFrame{Fin: true, Opcode: WebSocketPing}).Bytes(),
+		(&WebSocketFrame{Fin: true, Opcode: WebSocketContinuation, Payload: []byte("b")}).Bytes(),
+		(&WebSocketFrame{Fin: true, Opcode: WebSocketText, Payload: []byte("c")}).Bytes(),
+	}, nil)
+	frames, err := parser.Feed(data)
+	require.NoError(t, err)
+	require.Len(t, frames, 4)
+	// the continuation frames of a compressed message are marked too
+	assert.True(t, frames[0].Compressed)
+	assert.False(t, frames[1].Compressed)
+	assert.True(t, frames[2].Compressed)
+	assert.False(t, frames[3].Compressed)
+}
@@ -0,0 +1,20 @@
+// Copyright (c) 2024 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nottinygc replaces github.com/higress-group/nottinygc for the plugins built by the standard Go toolchain
+// targeting wasip1, which has its own garbage collector. The original package is the custom garbage collector of
+// TinyGo, which needs cgo and doesn't build with the standard Go toolchain. The plugins replace it in go.mod:
+//
+//	replace github.com/higress-group/nottinygc => ../../wasip1/nottinygc
+package nottinygc